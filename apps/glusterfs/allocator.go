@@ -16,6 +16,42 @@
 
 package glusterfs
 
+import (
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// Source of the positions in the allocators where the devices
+	// for new bricks are looked up
+	allocationRandLock sync.Mutex
+	allocationRand     = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Seeds the source of the positions in the allocators where the
+// devices for new bricks are looked up, so that bricks are placed on
+// the same devices of the same topology each time the same seed is
+// set.  The source is seeded with the time when the server starts.
+func SetAllocationSeed(seed int64) {
+	allocationRandLock.Lock()
+	defer allocationRandLock.Unlock()
+
+	allocationRand = rand.New(rand.NewSource(seed))
+}
+
+// Returns an id giving a random position in an allocator, from the
+// seeded source
+func allocationKey() string {
+	allocationRandLock.Lock()
+	defer allocationRandLock.Unlock()
+
+	key := make([]byte, 16)
+	allocationRand.Read(key)
+	return hex.EncodeToString(key)
+}
+
 type Allocator interface {
 
 	// Inform the brick allocator to include device
@@ -30,7 +66,8 @@ type Allocator interface {
 	// Returns a generator, done, and error channel.
 	// The generator returns the location for the brick, then the possible locations
 	// of its replicas. The caller must close() the done channel when it no longer
-	// needs to read from the generator.
+	// needs to read from the generator.  The brick id only sets where the
+	// allocator starts looking, any id from allocationKey() will do.
 	GetNodes(clusterId, brickId string) (<-chan string,
 		chan<- struct{}, <-chan error)
 }
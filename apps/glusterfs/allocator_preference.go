@@ -0,0 +1,151 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// Allocator which returns the devices of the nodes preferred for new
+// bricks before the other devices, each in the order of the allocator
+// it wraps.  Bricks are placed on the other devices only when the
+// preferred devices do not have the space for them.
+type PreferenceAwareAllocator struct {
+	Allocator
+
+	// Map [cluster] to the devices of preferred nodes
+	lock      sync.Mutex
+	preferred map[string]map[string]bool
+}
+
+// Create a new preference aware allocator ordering the devices
+// returned by the allocator given
+func NewPreferenceAwareAllocator(a Allocator) *PreferenceAwareAllocator {
+	return &PreferenceAwareAllocator{
+		Allocator: a,
+		preferred: make(map[string]map[string]bool),
+	}
+}
+
+// Create a new preference aware allocator of a simple allocator and
+// initialize it with data from the db
+func NewPreferenceAwareAllocatorFromDb(db *bolt.DB) *PreferenceAwareAllocator {
+	p := NewPreferenceAwareAllocator(NewSimpleAllocator())
+	err := addDevicesFromDb(db, p)
+	if err != nil {
+		return nil
+	}
+	return p
+}
+
+func (p *PreferenceAwareAllocator) AddDevice(cluster *ClusterEntry,
+	node *NodeEntry,
+	device *DeviceEntry) error {
+
+	err := p.Allocator.AddDevice(cluster, node, device)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	clusterId := cluster.Info.Id
+	if !node.Info.PreferredForNewBricks {
+		delete(p.preferred[clusterId], device.Info.Id)
+		return nil
+	}
+	if _, ok := p.preferred[clusterId]; !ok {
+		p.preferred[clusterId] = make(map[string]bool)
+	}
+	p.preferred[clusterId][device.Info.Id] = true
+
+	return nil
+}
+
+func (p *PreferenceAwareAllocator) RemoveDevice(cluster *ClusterEntry,
+	node *NodeEntry,
+	device *DeviceEntry) error {
+
+	p.lock.Lock()
+	delete(p.preferred[cluster.Info.Id], device.Info.Id)
+	p.lock.Unlock()
+
+	return p.Allocator.RemoveDevice(cluster, node, device)
+}
+
+func (p *PreferenceAwareAllocator) RemoveCluster(clusterId string) error {
+	p.lock.Lock()
+	delete(p.preferred, clusterId)
+	p.lock.Unlock()
+
+	return p.Allocator.RemoveCluster(clusterId)
+}
+
+func (p *PreferenceAwareAllocator) GetNodes(clusterId, brickId string) (<-chan string,
+	chan<- struct{}, <-chan error) {
+
+	// Initialize channels
+	device, done := make(chan string), make(chan struct{})
+	errc := make(chan error, 1)
+
+	// Read all the devices of the wrapped allocator to order them
+	devices, innerDone, innerErrc := p.Allocator.GetNodes(clusterId, brickId)
+	devicelist := []string{}
+	for deviceId := range devices {
+		devicelist = append(devicelist, deviceId)
+	}
+	close(innerDone)
+	if err := <-innerErrc; err != nil {
+		errc <- err
+		close(device)
+		return device, done, errc
+	}
+
+	p.lock.Lock()
+	preferred := make([]string, 0, len(devicelist))
+	others := make([]string, 0, len(devicelist))
+	for _, deviceId := range devicelist {
+		if p.preferred[clusterId][deviceId] {
+			preferred = append(preferred, deviceId)
+		} else {
+			others = append(others, deviceId)
+		}
+	}
+	p.lock.Unlock()
+	devicelist = append(preferred, others...)
+
+	// Start generator in a new goroutine
+	go func() {
+		defer func() {
+			errc <- nil
+			close(device)
+		}()
+
+		for _, deviceId := range devicelist {
+			select {
+			case device <- deviceId:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return device, done, errc
+}
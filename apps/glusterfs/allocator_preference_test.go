@@ -0,0 +1,119 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"testing"
+
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func preferenceAllocatorDevices(t *testing.T, a Allocator, clusterId string) []string {
+	ch, _, errc := a.GetNodes(clusterId, utils.GenUUID())
+	devices := []string{}
+	for d := range ch {
+		devices = append(devices, d)
+	}
+	err := <-errc
+	tests.Assert(t, err == nil, err)
+	return devices
+}
+
+func TestPreferenceAwareAllocator(t *testing.T) {
+	a := NewPreferenceAwareAllocator(NewSimpleAllocator())
+	cluster := createSampleClusterEntry()
+
+	// Unknown cluster
+	ch, _, errc := a.GetNodes(cluster.Info.Id, utils.GenUUID())
+	for d := range ch {
+		tests.Assert(t, false, d)
+	}
+	tests.Assert(t, <-errc == ErrNotFound)
+
+	preferredDevices := make(map[string]bool)
+	var preferredNode *NodeEntry
+	for n := 0; n < 4; n++ {
+		node := createSampleNodeEntry()
+		node.Info.ClusterId = cluster.Info.Id
+		node.Info.Zone = n + 1
+		node.Info.PreferredForNewBricks = n == 2
+		if n == 2 {
+			preferredNode = node
+		}
+		for d := 0; d < 2; d++ {
+			device := createSampleDeviceEntry(node.Info.Id, 10000)
+			err := a.AddDevice(cluster, node, device)
+			tests.Assert(t, err == nil)
+			if node.Info.PreferredForNewBricks {
+				preferredDevices[device.Info.Id] = true
+			}
+		}
+	}
+
+	// The devices of the preferred node come first, whatever the brick
+	for i := 0; i < 10; i++ {
+		devices := preferenceAllocatorDevices(t, a, cluster.Info.Id)
+		tests.Assert(t, len(devices) == 8)
+		tests.Assert(t, preferredDevices[devices[0]])
+		tests.Assert(t, preferredDevices[devices[1]])
+		for _, d := range devices[2:] {
+			tests.Assert(t, !preferredDevices[d])
+		}
+	}
+
+	// Without preference the order of the wrapped allocator is kept
+	for id := range preferredDevices {
+		device := createSampleDeviceEntry(preferredNode.Info.Id, 10000)
+		device.Info.Id = id
+		err := a.RemoveDevice(cluster, preferredNode, device)
+		tests.Assert(t, err == nil)
+		preferredNode.Info.PreferredForNewBricks = false
+		err = a.AddDevice(cluster, preferredNode, device)
+		tests.Assert(t, err == nil)
+	}
+	tests.Assert(t, len(a.preferred[cluster.Info.Id]) == 0)
+
+	brickId := utils.GenUUID()
+	ch, _, errc = a.Allocator.GetNodes(cluster.Info.Id, brickId)
+	expected := []string{}
+	for d := range ch {
+		expected = append(expected, d)
+	}
+	tests.Assert(t, <-errc == nil)
+
+	ch, _, errc = a.GetNodes(cluster.Info.Id, brickId)
+	devices := []string{}
+	for d := range ch {
+		devices = append(devices, d)
+	}
+	tests.Assert(t, <-errc == nil)
+	tests.Assert(t, len(devices) == len(expected))
+	for i := range devices {
+		tests.Assert(t, devices[i] == expected[i])
+	}
+
+	// Stop reading early
+	ch, done, errc := a.GetNodes(cluster.Info.Id, brickId)
+	<-ch
+	close(done)
+	tests.Assert(t, <-errc == nil)
+
+	err := a.RemoveCluster(cluster.Info.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(a.preferred) == 0)
+}
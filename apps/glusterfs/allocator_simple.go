@@ -17,8 +17,16 @@
 package glusterfs
 
 import (
-	"github.com/boltdb/bolt"
+	"sort"
 	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// Positions in the ring a device is moved back for each ms of
+	// storage latency of its node.  Latency is ignored when zero.
+	AllocatorLatencyWeight float64 = 0
 )
 
 // Simple allocator contains a map to rings of clusters
@@ -39,7 +47,18 @@ func NewSimpleAllocatorFromDb(db *bolt.DB) *SimpleAllocator {
 
 	s := NewSimpleAllocator()
 
-	err := db.View(func(tx *bolt.Tx) error {
+	err := addDevicesFromDb(db, s)
+	if err != nil {
+		return nil
+	}
+
+	return s
+
+}
+
+// Add the online devices of the online nodes in the db to the allocator
+func addDevicesFromDb(db *bolt.DB, a Allocator) error {
+	return db.View(func(tx *bolt.Tx) error {
 		clusters, err := ClusterList(tx)
 		if err != nil {
 			return err
@@ -74,7 +93,7 @@ func NewSimpleAllocatorFromDb(db *bolt.DB) *SimpleAllocator {
 					}
 
 					// Add device to ring
-					err = s.AddDevice(cluster, node, device)
+					err = a.AddDevice(cluster, node, device)
 					if err != nil {
 						return err
 					}
@@ -84,12 +103,6 @@ func NewSimpleAllocatorFromDb(db *bolt.DB) *SimpleAllocator {
 		}
 		return nil
 	})
-	if err != nil {
-		return nil
-	}
-
-	return s
-
 }
 
 func (s *SimpleAllocator) AddDevice(cluster *ClusterEntry,
@@ -109,6 +122,7 @@ func (s *SimpleAllocator) AddDevice(cluster *ClusterEntry,
 		zone:     node.Info.Zone,
 		nodeId:   node.Info.Id,
 		deviceId: device.Info.Id,
+		latency:  node.Info.StorageLatencyMs,
 	})
 
 	return nil
@@ -168,6 +182,9 @@ func (s *SimpleAllocator) getDeviceList(clusterId, brickId string) (SimpleDevice
 	ring := s.rings[clusterId]
 	ring.Rebalance()
 	devicelist := ring.GetDeviceList(brickId)
+	if weight := settings().AllocatorLatencyWeight; weight > 0 {
+		sortByAllocationScore(devicelist, weight)
+	}
 
 	return devicelist, nil
 
@@ -211,3 +228,38 @@ func (s *SimpleAllocator) GetNodes(clusterId, brickId string) (<-chan string,
 
 	return device, done, errc
 }
+
+// Lower scores are tried first.  The position keeps the balance of
+// the ring between devices with similar latency.
+func allocationScore(position int, d *SimpleDevice, weight float64) float64 {
+	return float64(position) + weight*d.latency
+}
+
+type scoredDevices struct {
+	devices SimpleDevices
+	scores  []float64
+}
+
+func (s *scoredDevices) Len() int {
+	return len(s.devices)
+}
+
+func (s *scoredDevices) Less(i, j int) bool {
+	return s.scores[i] < s.scores[j]
+}
+
+func (s *scoredDevices) Swap(i, j int) {
+	s.devices[i], s.devices[j] = s.devices[j], s.devices[i]
+	s.scores[i], s.scores[j] = s.scores[j], s.scores[i]
+}
+
+func sortByAllocationScore(devices SimpleDevices, weight float64) {
+	scored := &scoredDevices{
+		devices: devices,
+		scores:  make([]float64, len(devices)),
+	}
+	for i := range devices {
+		scored.scores[i] = allocationScore(i, &devices[i], weight)
+	}
+	sort.Stable(scored)
+}
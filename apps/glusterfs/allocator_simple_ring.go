@@ -18,6 +18,7 @@ package glusterfs
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 )
 
@@ -25,6 +26,9 @@ import (
 type SimpleDevice struct {
 	zone             int
 	nodeId, deviceId string
+
+	// Storage latency of the node in ms
+	latency float64
 }
 
 // Pretty pring a SimpleDevice
@@ -64,15 +68,32 @@ func NewSimpleAllocatorRing() *SimpleAllocatorRing {
 
 // Convert the ring map into a consumable list of lists.
 // This allows the rebalancer to go through the lists and remove
-// elements as it balances
+// elements as it balances.  The zones, nodes and devices are sorted
+// so that the same devices always give the same list.
 func (s *SimpleAllocatorRing) createZoneLists() []SimpleZone {
 	zones := make([]SimpleZone, 0)
 
-	for _, n := range s.ring {
+	zoneIds := make([]int, 0, len(s.ring))
+	for z := range s.ring {
+		zoneIds = append(zoneIds, z)
+	}
+	sort.Ints(zoneIds)
+
+	for _, z := range zoneIds {
+		n := s.ring[z]
+
+		nodeIds := make([]string, 0, len(n))
+		for nodeId := range n {
+			nodeIds = append(nodeIds, nodeId)
+		}
+		sort.Strings(nodeIds)
 
 		zone := make([]SimpleNode, 0)
-		for _, d := range n {
-			zone = append(zone, d)
+		for _, nodeId := range nodeIds {
+			node := make(SimpleNode, len(n[nodeId]))
+			copy(node, n[nodeId])
+			sort.Sort(simpleNodeByDeviceId(node))
+			zone = append(zone, node)
 		}
 		zones = append(zones, zone)
 	}
@@ -80,6 +101,12 @@ func (s *SimpleAllocatorRing) createZoneLists() []SimpleZone {
 	return zones
 }
 
+type simpleNodeByDeviceId SimpleNode
+
+func (l simpleNodeByDeviceId) Len() int           { return len(l) }
+func (l simpleNodeByDeviceId) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l simpleNodeByDeviceId) Less(i, j int) bool { return l[i].deviceId < l[j].deviceId }
+
 // Add a device to the ring map
 func (s *SimpleAllocatorRing) Add(d *SimpleDevice) {
 
@@ -18,7 +18,9 @@ package glusterfs
 
 import (
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
@@ -100,6 +102,45 @@ func TestSimpleAllocatorAddRemoveDevice(t *testing.T) {
 
 }
 
+func TestSimpleAllocatorLatencyWeight(t *testing.T) {
+	a := NewSimpleAllocator()
+	cluster := createSampleClusterEntry()
+
+	fast := createSampleNodeEntry()
+	fast.Info.ClusterId = cluster.Info.Id
+	fast.Info.Zone = 1
+	fastDevice := createSampleDeviceEntry(fast.Info.Id, 10000)
+
+	slow := createSampleNodeEntry()
+	slow.Info.ClusterId = cluster.Info.Id
+	slow.Info.Zone = 2
+	slow.Info.StorageLatencyMs = 10
+	slowDevice := createSampleDeviceEntry(slow.Info.Id, 10000)
+
+	tests.Assert(t, a.AddDevice(cluster, fast, fastDevice) == nil)
+	tests.Assert(t, a.AddDevice(cluster, slow, slowDevice) == nil)
+
+	firstDevices := func() map[string]bool {
+		first := make(map[string]bool)
+		for i := 0; i < 20; i++ {
+			ch, done, errc := a.GetNodes(cluster.Info.Id, utils.GenUUID())
+			first[<-ch] = true
+			close(done)
+			tests.Assert(t, <-errc == nil)
+		}
+		return first
+	}
+
+	// Latency is ignored by default
+	first := firstDevices()
+	tests.Assert(t, first[fastDevice.Info.Id] && first[slowDevice.Info.Id], first)
+
+	// The device on the fast node is always tried first
+	defer tests.Patch(&AllocatorLatencyWeight, 1.0).Restore()
+	first = firstDevices()
+	tests.Assert(t, len(first) == 1 && first[fastDevice.Info.Id], first)
+}
+
 func TestSimpleAllocatorInitFromDb(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -216,3 +257,50 @@ func TestSimpleAllocatorInitFromDbWithOfflineDevices(t *testing.T) {
 	tests.Assert(t, devices == 3, devices)
 
 }
+
+func TestSimpleAllocatorSeed(t *testing.T) {
+	cluster := createSampleClusterEntry()
+	var devices []*DeviceEntry
+	var nodes []*NodeEntry
+	for n := 0; n < 4; n++ {
+		node := createSampleNodeEntry()
+		node.Info.ClusterId = cluster.Info.Id
+		node.Info.Zone = n%2 + 1
+		for d := 0; d < 3; d++ {
+			nodes = append(nodes, node)
+			devices = append(devices, createSampleDeviceEntry(node.Info.Id, 10000))
+		}
+	}
+
+	// The same devices, added in another order
+	a := NewSimpleAllocator()
+	for i := range devices {
+		err := a.AddDevice(cluster, nodes[i], devices[i])
+		tests.Assert(t, err == nil)
+	}
+	b := NewSimpleAllocator()
+	for i := len(devices) - 1; i >= 0; i-- {
+		err := b.AddDevice(cluster, nodes[i], devices[i])
+		tests.Assert(t, err == nil)
+	}
+
+	placement := func(a Allocator, seed int64) []string {
+		SetAllocationSeed(seed)
+		first := []string{}
+		for i := 0; i < 20; i++ {
+			ch, done, errc := a.GetNodes(cluster.Info.Id, allocationKey())
+			first = append(first, <-ch)
+			close(done)
+			tests.Assert(t, <-errc == nil)
+		}
+		return first
+	}
+
+	// Identical for identical seeds
+	reference := placement(a, 42)
+	tests.Assert(t, reflect.DeepEqual(placement(a, 42), reference))
+	tests.Assert(t, reflect.DeepEqual(placement(b, 42), reference))
+	tests.Assert(t, !reflect.DeepEqual(placement(a, 7), reference))
+
+	SetAllocationSeed(time.Now().UnixNano())
+}
@@ -17,9 +17,13 @@
 package glusterfs
 
 import (
+	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"runtime/debug"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -30,6 +34,7 @@ import (
 	"github.com/heketi/heketi/executors/kubeexec"
 	"github.com/heketi/heketi/executors/mockexec"
 	"github.com/heketi/heketi/executors/sshexec"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/heketi/heketi/pkg/utils"
 	"github.com/heketi/rest"
 )
@@ -41,27 +46,76 @@ const (
 	BOLTDB_BUCKET_VOLUME  = "VOLUME"
 	BOLTDB_BUCKET_DEVICE  = "DEVICE"
 	BOLTDB_BUCKET_BRICK   = "BRICK"
+
+	BOLTDB_BUCKET_PENDING_OPS = "PENDINGOPS"
 )
 
 var (
 	logger     = utils.NewLogger("[heketi]", utils.LEVEL_INFO)
 	dbfilename = "heketi.db"
+
+	// Paths which do not require administrator access
+	userAllowedPaths = map[string]bool{
+		"/volumes":                true,
+		API_V2_ROUTE + "/volumes": true,
+		API_V2_ROUTE + "/hello":   true,
+	}
 )
 
 type App struct {
 	asyncManager *rest.AsyncHttpManager
+	operations   *asyncOperations
 	db           *bolt.DB
 	executor     executors.Executor
 	allocator    Allocator
 	conf         *GlusterFSConfig
 
+	// Guards the configuration, replaced when it is reloaded from
+	// the file it was read from
+	confLock   sync.RWMutex
+	configFile string
+
+	// Timing of the transactions of the handlers
+	dbStats *dbStats
+
+	// Read-only mode
+	readOnly     bool
+	readOnlyLock sync.RWMutex
+
+	// Log levels changed at runtime
+	logLevels logLevels
+
+	// Errors logged, for the debug state
+	recentErrors *utils.RecentErrors
+
+	// Asynchronous operations are not started in maintenance
+	maintenance maintenance
+
+	// Closed to stop background tasks
+	stop chan struct{}
+
+	// Readiness of the app
+	health health
+
+	// Changes requested through the API, nil if not enabled
+	audit *auditLog
+
+	// Notified of completed operations, nil if there are no webhooks
+	webhooks *webhooks
+
+	// Results of the create requests with an Idempotency-Key, nil if
+	// the db is read-only
+	idempotency *idempotencyKeys
+
 	// For testing only.  Keep access to the object
 	// not through the interface
 	xo *mockexec.MockExecutor
 }
 
 func NewApp(configIo io.Reader) *App {
-	app := &App{}
+	app := &App{
+		dbStats: newDbStats(),
+	}
 
 	// Load configuration file
 	app.conf = loadConfiguration(configIo)
@@ -71,9 +125,22 @@ func NewApp(configIo io.Reader) *App {
 
 	// Setup loglevel
 	app.setLogLevel(app.conf.Loglevel)
+	app.setLogFormat(app.conf.LogFormat)
+	app.recentErrors = utils.NewRecentErrors(debugRecentErrors)
+	logger.RecordErrors(app.recentErrors)
+
+	// Refuse to create bricks on paths the template cannot produce
+	if app.conf.BrickPathTemplate != "" {
+		err := executors.ValidateBrickPathTemplate(app.conf.BrickPathTemplate)
+		if err != nil {
+			logger.Err(err)
+			return nil
+		}
+	}
 
 	// Setup asynchronous manager
 	app.asyncManager = rest.NewAsyncHttpManager(ASYNC_ROUTE)
+	app.operations = newAsyncOperations()
 
 	// Setup executor
 	var err error
@@ -94,61 +161,103 @@ func NewApp(configIo io.Reader) *App {
 	}
 	logger.Info("Loaded %v executor", app.conf.Executor)
 
+	app.logLevels.executor = app.executor.LogLevel()
+
 	// Set db is set in the configuration file
 	if app.conf.DBfile != "" {
 		dbfilename = app.conf.DBfile
 	}
 
-	// Setup BoltDB database
-	app.db, err = bolt.Open(dbfilename, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	// Codec of the entries in a new db
+	entryCodec, err = NewCodec(app.conf.DbCodec)
 	if err != nil {
-		logger.LogError("Unable to open database")
+		logger.Err(err)
 		return nil
 	}
 
-	err = app.db.Update(func(tx *bolt.Tx) error {
-		// Create Cluster Bucket
-		_, err := tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_CLUSTER))
-		if err != nil {
-			logger.LogError("Unable to create cluster bucket in DB")
-			return err
+	// Replace the db with the restore staged before the restart
+	app.readOnly = app.conf.ReadOnly
+	if app.readOnly {
+		if _, err := os.Stat(dbRestoreFile(dbfilename)); err == nil {
+			logger.Warning("Database restore %v not applied in read-only mode",
+				dbRestoreFile(dbfilename))
 		}
-
-		// Create Node Bucket
-		_, err = tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_NODE))
+	} else {
+		err = applyDbRestore(dbfilename)
 		if err != nil {
-			logger.LogError("Unable to create node bucket in DB")
-			return err
+			logger.LogError("Unable to restore database: %v", err)
+			return nil
 		}
+	}
 
-		// Create Volume Bucket
-		_, err = tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_VOLUME))
-		if err != nil {
-			logger.LogError("Unable to create volume bucket in DB")
-			return err
+	// Open an existing db read-only in read-only mode
+	dbReadOnly := false
+	if app.readOnly {
+		if _, err := os.Stat(dbfilename); err == nil {
+			dbReadOnly = true
+		} else {
+			logger.Warning("Database %v does not exist, opening it read-write", dbfilename)
 		}
+	}
 
-		// Create Device Bucket
-		_, err = tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_DEVICE))
-		if err != nil {
-			logger.LogError("Unable to create device bucket in DB")
-			return err
-		}
+	// Setup BoltDB database
+	app.db, err = openDb(dbfilename, dbReadOnly)
+	if err != nil {
+		logger.Err(err)
+		return nil
+	}
 
-		// Create Brick Bucket
-		_, err = tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_BRICK))
-		if err != nil {
-			logger.LogError("Unable to create brick bucket in DB")
-			return err
-		}
+	// Refuse databases written by a newer version and migrate
+	// older ones
+	err = dbMigrate(app.db, dbfilename)
+	if err != nil {
+		logger.Err(err)
+		app.db.Close()
+		return nil
+	}
 
+	if dbReadOnly {
+		logger.Warning("Database opened read-only")
+		err = app.db.View(func(tx *bolt.Tx) error {
+			if missing := dbMissingBuckets(tx); len(missing) != 0 {
+				return fmt.Errorf("Database %v is missing the buckets %v, "+
+					"which cannot be created read-only", dbfilename, missing)
+			}
+			return nil
+		})
+	} else {
+		err = app.db.Update(initDb)
+	}
+	if err != nil {
+		logger.Err(err)
+		app.db.Close()
 		return nil
+	}
 
-	})
+	// Existing databases keep their codec
+	configured := entryCodec
+	err = useDbCodec(app.db)
 	if err != nil {
 		logger.Err(err)
+		app.db.Close()
 		return nil
 	}
+	if app.conf.DbCodec != "" && entryCodec.Name() != configured.Name() {
+		logger.Warning("Database uses the %v codec, ignoring db_codec %v",
+			entryCodec.Name(), configured.Name())
+	}
+	logger.Info("Using the %v codec for database entries", entryCodec.Name())
+
+	// Make sure the indexes match the entries in the db, which may
+	// have been edited by hand
+	if !dbReadOnly {
+		err = Reindex(app.db)
+		if err != nil {
+			logger.LogError("Unable to rebuild indexes in DB: %v", err)
+			app.db.Close()
+			return nil
+		}
+	}
 
 	// Set advanced settings
 	app.setAdvSettings()
@@ -159,18 +268,261 @@ func NewApp(configIo io.Reader) *App {
 		app.allocator = NewMockAllocator(app.db)
 	case app.conf.Allocator == "simple" || app.conf.Allocator == "":
 		app.conf.Allocator = "simple"
-		app.allocator = NewSimpleAllocatorFromDb(app.db)
+		app.allocator = NewPreferenceAwareAllocatorFromDb(app.db)
 	default:
 		return nil
 	}
 	logger.Info("Loaded %v allocator", app.conf.Allocator)
 
+	// Resolve operations interrupted by the last shutdown before
+	// any new requests are accepted.  A read-only db can only list them.
+	// The server is not ready while some are left unresolved.
+	unresolved, err := PendingOperationsResolve(app.db,
+		app.executor,
+		app.conf.PendingOpsListOnly || dbReadOnly)
+	if err != nil {
+		logger.Err(err)
+		return nil
+	}
+	if unresolved == 0 {
+		app.health.setRecovered()
+	} else {
+		logger.Warning("Not ready until the %v unresolved pending operations "+
+			"are resolved by a restart", unresolved)
+	}
+
+	// Restore the asynchronous operations, which are resumed once
+	// the routes are set
+	if !dbReadOnly {
+		err = app.operations.load(app.db)
+		if err != nil {
+			logger.LogError("Unable to load asynchronous operations: %v", err)
+			app.db.Close()
+			return nil
+		}
+	}
+
+	// Keep the operations queued if the server was in maintenance
+	err = app.loadMaintenance()
+	if err != nil {
+		logger.LogError("Unable to load maintenance state: %v", err)
+		app.db.Close()
+		return nil
+	}
+
+	// Record the changes requested through the API
+	if app.conf.AuditLog {
+		if dbReadOnly {
+			logger.Warning("Audit log disabled, the database is read-only")
+		} else {
+			app.audit, err = newAuditLog(app.db, app.conf.AuditLogStrict)
+			if err != nil {
+				logger.LogError("Unable to open audit log: %v", err)
+				app.db.Close()
+				return nil
+			}
+			logger.Info("Audit log enabled")
+		}
+	}
+
+	// Keep the results of create requests for their repeats
+	if !dbReadOnly {
+		app.idempotency, err = newIdempotencyKeys(app.db)
+		if err != nil {
+			logger.LogError("Unable to open idempotency keys: %v", err)
+			app.db.Close()
+			return nil
+		}
+	}
+
+	// Start periodic trim of devices
+	app.stop = make(chan struct{})
+	if app.conf.TrimInterval > 0 && !dbReadOnly {
+		logger.Info("Trimming devices every %v hours", app.conf.TrimInterval)
+		go trimDevicesEvery(app.db, app.executor,
+			time.Duration(app.conf.TrimInterval)*time.Hour, app.stop)
+	}
+
+	// Start periodic backups of the db
+	if app.conf.BackupDir != "" && BackupInterval > 0 {
+		logger.Info("Backing up database to %v every %v hours",
+			app.conf.BackupDir, BackupInterval)
+		go backupDbEvery(app.db, app.conf.BackupDir, BackupsKept,
+			time.Duration(BackupInterval)*time.Hour, app.stop)
+	}
+
+	// Start periodic health checks of the nodes
+	if app.conf.HealthCheckInterval > 0 && !dbReadOnly {
+		logger.Info("Checking nodes every %v minutes", app.conf.HealthCheckInterval)
+		go checkNodeHealthEvery(app.db, app.executor, app.allocator,
+			time.Duration(app.conf.HealthCheckInterval)*time.Minute, app.stop)
+	}
+
+	// Start periodic fetches of the I/O of the bricks
+	if app.conf.BrickIOStatsInterval > 0 && !dbReadOnly {
+		logger.Info("Fetching I/O of bricks every %v minutes", app.conf.BrickIOStatsInterval)
+		go fetchBrickIOStatsEvery(app.db, app.executor,
+			time.Duration(app.conf.BrickIOStatsInterval)*time.Minute, app.stop)
+	}
+
+	// Start scheduled rebalances of the clusters
+	if !dbReadOnly {
+		go rebalanceClustersEvery(app.db, app.executor, time.Minute, app.stop)
+	}
+
+	// Post the events of the completed operations to the webhooks
+	if len(app.conf.Webhooks.Urls) != 0 {
+		app.webhooks = newWebhooks(app.conf.Webhooks.Urls,
+			app.conf.Webhooks.Secret, app.conf.Webhooks.DeadLetterFile)
+		go app.webhooks.run(app.stop)
+		logger.Info("Posting events to %v webhooks", len(app.conf.Webhooks.Urls))
+	}
+
+	// Start periodic purge of old audit records
+	if app.audit != nil {
+		go purgeAuditLogEvery(app.audit, auditLogPurgeInterval, app.stop)
+	}
+
+	// Start periodic purge of expired idempotency keys
+	if app.idempotency != nil {
+		go purgeIdempotencyKeysEvery(app.idempotency, idempotencyKeyPurgeInterval, app.stop)
+	}
+
+	// Start periodic purge of old tombstones
+	if TombstonesEnabled && !dbReadOnly {
+		go purgeTombstonesEvery(app.db, tombstonePurgeInterval, app.stop, nil)
+	}
+
+	// Report the capacity of the clusters in the metrics
+	metricsStorage.setDb(app.db)
+
 	// Show application has loaded
 	logger.Info("GlusterFS Application Loaded")
 
 	return app
 }
 
+// Buckets every database must have, by their description in errors
+var dbRequiredBuckets = []struct {
+	name        string
+	description string
+}{
+	{BOLTDB_BUCKET_CLUSTER, "cluster"},
+	{BOLTDB_BUCKET_NODE, "node"},
+	{BOLTDB_BUCKET_VOLUME, "volume"},
+	{BOLTDB_BUCKET_DEVICE, "device"},
+	{BOLTDB_BUCKET_BRICK, "brick"},
+	{BOLTDB_BUCKET_PENDING_OPS, "pending operations"},
+	{BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, "cluster volumes index"},
+	{BOLTDB_BUCKET_INDEX_NODE_BRICKS, "node bricks index"},
+	{BOLTDB_BUCKET_METADATA, "metadata"},
+}
+
+// Returns the names of the required buckets missing from the db
+func dbMissingBuckets(tx *bolt.Tx) []string {
+	missing := make([]string, 0)
+	for _, bucket := range dbRequiredBuckets {
+		if tx.Bucket([]byte(bucket.name)) == nil {
+			missing = append(missing, bucket.name)
+		}
+	}
+	return missing
+}
+
+// Opens the db and checks that it is a whole bolt database, so that a
+// damaged file is refused at startup instead of failing requests later
+func openDb(dbfile string, readOnly bool) (db *bolt.DB, err error) {
+
+	// Bolt reads the pages of the file through a memory map, and reading
+	// past the end of a truncated file faults
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			db, err = nil, fmt.Errorf("Database %v is damaged: %v.  "+
+				"Restore it from a backup, or move it away to start with "+
+				"an empty database", dbfile, r)
+		}
+	}()
+
+	db, err = bolt.Open(dbfile, 0600, &bolt.Options{
+		Timeout:  3 * time.Second,
+		ReadOnly: readOnly,
+	})
+	switch err {
+	case nil:
+	case bolt.ErrTimeout:
+		return nil, fmt.Errorf("Database %v is locked by another process, "+
+			"make sure no other heketi server uses it", dbfile)
+	case bolt.ErrInvalid, bolt.ErrVersionMismatch, bolt.ErrChecksum:
+		return nil, fmt.Errorf("Database %v is not a valid heketi database: %v.  "+
+			"Restore it from a backup, or move it away to start with "+
+			"an empty database", dbfile, err)
+	default:
+		return nil, fmt.Errorf("Unable to open database %v: %v", dbfile, err)
+	}
+
+	// A truncated file has fewer pages than the db uses
+	stat, err := os.Stat(dbfile)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		if stat.Size() < tx.Size() {
+			return fmt.Errorf("Database %v is truncated to %v bytes of %v.  "+
+				"Restore it from a backup, or move it away to start with "+
+				"an empty database", dbfile, stat.Size(), tx.Size())
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Creates the buckets missing from the db
+func initDb(tx *bolt.Tx) error {
+	newDb := tx.Bucket([]byte(BOLTDB_BUCKET_CLUSTER)) == nil
+
+	for _, bucket := range dbRequiredBuckets {
+		if tx.Bucket([]byte(bucket.name)) != nil {
+			continue
+		}
+
+		_, err := tx.CreateBucket([]byte(bucket.name))
+		if err != nil {
+			logger.LogError("Unable to create %v bucket in DB", bucket.description)
+			return err
+		}
+		if !newDb {
+			logger.Warning("Created missing %v bucket %v in DB",
+				bucket.description, bucket.name)
+		}
+	}
+	if newDb {
+		logger.Info("Initialized new DB")
+	}
+
+	// New databases need no migration and use the configured codec
+	if newDb {
+		err := dbSetSchemaVersion(tx, dbSchemaVersionCurrent())
+		if err != nil {
+			logger.LogError("Unable to set schema version in DB")
+			return err
+		}
+		err = dbSetCodec(tx, entryCodec)
+		if err != nil {
+			logger.LogError("Unable to set codec in DB")
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (a *App) setLogLevel(level string) {
 	switch level {
 	case "none":
@@ -188,26 +540,211 @@ func (a *App) setLogLevel(level string) {
 	}
 }
 
+func (a *App) setLogFormat(name string) {
+	if name == "" {
+		return
+	}
+	format, err := utils.ParseLogFormat(name)
+	if err != nil {
+		logger.LogError("%v, logging in %v", err, logger.Format())
+		return
+	}
+	logger.SetFormat(format)
+}
+
 func (a *App) setAdvSettings() {
-	if a.conf.BrickMaxNum != 0 {
-		logger.Info("Adv: Max bricks per volume set to %v", a.conf.BrickMaxNum)
+	if a.conf.BrickPathTemplate != "" {
+		logger.Info("Adv: Bricks mounted on %v", a.conf.BrickPathTemplate)
+
+		// From brick_entry.go
+		BrickPathTemplate = a.conf.BrickPathTemplate
+	}
+	if a.conf.Tombstones {
+		logger.Info("Adv: Deleted entries kept as tombstones")
+
+		// From tombstone.go
+		TombstonesEnabled = true
+	}
+	if a.conf.AllocationSeed != 0 {
+		logger.Info("Adv: Allocation seed %v", a.conf.AllocationSeed)
+
+		// From allocator.go
+		SetAllocationSeed(a.conf.AllocationSeed)
+	}
+	if a.conf.CHAPSecretKey != "" {
+		logger.Info("Adv: CHAP authentication of volumes enabled")
+
+		// From volume_entry_chap.go
+		setCHAPSecretKey(a.conf.CHAPSecretKey)
+	}
+	if a.conf.BackupInterval != 0 {
+		logger.Info("Adv: Database backed up every %v hours", a.conf.BackupInterval)
+
+		// From db_backup.go
+		BackupInterval = a.conf.BackupInterval
+	}
+
+	a.setRuntimeSettings(a.conf)
+	a.setOperationLimits(a.conf)
+}
+
+// Settings which can also be changed by reloading the configuration.
+// Settings not set keep their current value.
+func (a *App) setRuntimeSettings(conf *GlusterFSConfig) {
+	runtimeSettingsLock.Lock()
+	defer runtimeSettingsLock.Unlock()
+
+	if conf.BrickMaxNum != 0 {
+		logger.Info("Adv: Max bricks per volume set to %v", conf.BrickMaxNum)
 
 		// From volume_entry.go
-		BrickMaxNum = a.conf.BrickMaxNum
+		BrickMaxNum = conf.BrickMaxNum
 	}
-	if a.conf.BrickMaxSize != 0 {
-		logger.Info("Adv: Max brick size %v GB", a.conf.BrickMaxSize)
+	if conf.BrickMaxSize != 0 {
+		logger.Info("Adv: Max brick size %v GB", conf.BrickMaxSize)
 
 		// From volume_entry.go
 		// Convert to KB
-		BrickMaxSize = uint64(a.conf.BrickMaxSize) * 1024 * 1024
+		BrickMaxSize = uint64(conf.BrickMaxSize) * 1024 * 1024
 	}
-	if a.conf.BrickMinSize != 0 {
-		logger.Info("Adv: Min brick size %v GB", a.conf.BrickMinSize)
+	if conf.BrickMinSize != 0 {
+		logger.Info("Adv: Min brick size %v GB", conf.BrickMinSize)
 
 		// From volume_entry.go
 		// Convert to KB
-		BrickMinSize = uint64(a.conf.BrickMinSize) * 1024 * 1024
+		BrickMinSize = uint64(conf.BrickMinSize) * 1024 * 1024
+	}
+	if conf.ArbiterBrickSize != 0 {
+		logger.Info("Adv: Arbiter brick size %v GB", conf.ArbiterBrickSize)
+
+		// From volume_entry_arbiter.go
+		// Convert to KB
+		ArbiterBrickSize = uint64(conf.ArbiterBrickSize) * 1024 * 1024
+	}
+	if !isValidStorageClass(conf.DefaultStorageClass) {
+		logger.Warning("Adv: Unknown default storage class %v ignored",
+			conf.DefaultStorageClass)
+	} else if conf.DefaultStorageClass != "" {
+		logger.Info("Adv: Default storage class %v", conf.DefaultStorageClass)
+
+		// From limits.go
+		DefaultStorageClass = conf.DefaultStorageClass
+	}
+	if conf.DbRetries != 0 {
+		logger.Info("Adv: Db transactions retried %v times", conf.DbRetries)
+
+		// From app_db.go
+		DbRetries = conf.DbRetries
+	}
+	if wm := conf.DeviceWatermarks; wm.Low != 0 || wm.High != 0 || wm.Critical != 0 {
+		if wm.Low < wm.High || wm.High < wm.Critical || wm.Low > 100 || wm.Critical < 0 {
+			logger.Warning("Adv: Device watermarks low:%v high:%v critical:%v "+
+				"must be decreasing percents, ignored", wm.Low, wm.High, wm.Critical)
+		} else {
+			logger.Info("Adv: Device watermarks low:%v%% high:%v%% critical:%v%% free",
+				wm.Low, wm.High, wm.Critical)
+
+			// From limits.go
+			DeviceWatermarkLow = wm.Low
+			DeviceWatermarkHigh = wm.High
+			DeviceWatermarkCritical = wm.Critical
+		}
+	}
+	if conf.AuditLogRetentionDays != 0 {
+		logger.Info("Adv: Audit records kept %v days", conf.AuditLogRetentionDays)
+
+		// From app_audit.go
+		AuditLogRetentionDays = conf.AuditLogRetentionDays
+	}
+	if conf.Webhooks.Retries != 0 {
+		logger.Info("Adv: Events posted again %v times to failed webhooks",
+			conf.Webhooks.Retries)
+
+		// From app_webhook.go
+		WebhookRetries = conf.Webhooks.Retries
+	}
+	if conf.BackupsKept != 0 {
+		logger.Info("Adv: %v database backups kept", conf.BackupsKept)
+
+		// From db_backup.go
+		BackupsKept = conf.BackupsKept
+	}
+	if conf.SnapshotReservePercent != 0 {
+		if conf.SnapshotReservePercent < 0 || conf.SnapshotReservePercent >= 100 {
+			logger.Warning("Adv: Snapshot reserve %v%% must be a percent below 100, ignored",
+				conf.SnapshotReservePercent)
+		} else {
+			logger.Info("Adv: %v%% of each device reserved for snapshots",
+				conf.SnapshotReservePercent)
+
+			// From limits.go
+			DeviceSnapshotReserve = conf.SnapshotReservePercent
+		}
+	}
+	if conf.DeviceFilesystemOverhead != 0 {
+		if conf.DeviceFilesystemOverhead < 0 || conf.DeviceFilesystemOverhead >= 100 {
+			logger.Warning("Adv: Filesystem overhead %v%% must be a percent below 100, ignored",
+				conf.DeviceFilesystemOverhead)
+		} else {
+			logger.Info("Adv: %v%% of each device added taken by the filesystem",
+				conf.DeviceFilesystemOverhead)
+
+			// From limits.go
+			DeviceFilesystemOverhead = conf.DeviceFilesystemOverhead
+		}
+	}
+	if conf.TombstoneRetentionDays != 0 {
+		logger.Info("Adv: Tombstones kept %v days", conf.TombstoneRetentionDays)
+
+		// From tombstone.go
+		TombstoneRetentionDays = conf.TombstoneRetentionDays
+	}
+	if conf.AllocatorLatencyWeight != 0 {
+		logger.Info("Adv: Allocator latency weight %v", conf.AllocatorLatencyWeight)
+
+		// From allocator_simple.go
+		AllocatorLatencyWeight = conf.AllocatorLatencyWeight
+	}
+	if conf.ShutdownDrainTimeout != 0 {
+		logger.Info("Adv: Shutdown waits %v seconds for asynchronous operations",
+			conf.ShutdownDrainTimeout)
+
+		// From app_health.go
+		ShutdownDrainTimeout = time.Duration(conf.ShutdownDrainTimeout) * time.Second
+	}
+	if conf.AsyncOperationRetention != 0 {
+		logger.Info("Adv: Completed asynchronous operations listed for %v minutes",
+			conf.AsyncOperationRetention)
+
+		// From app_queue.go
+		AsyncOperationRetention = time.Duration(conf.AsyncOperationRetention) * time.Minute
+	}
+	if conf.IdempotencyKeyRetention != 0 {
+		logger.Info("Adv: Idempotency keys kept for %v hours",
+			conf.IdempotencyKeyRetention)
+
+		// From app_idempotency.go
+		IdempotencyKeyRetention = time.Duration(conf.IdempotencyKeyRetention) * time.Hour
+	}
+}
+
+// Replaces the limits of concurrent operations, including those
+// changed through the API
+func (a *App) setOperationLimits(conf *GlusterFSConfig) {
+	if conf.MaxConcurrentOperations != 0 || len(conf.OperationLimits) != 0 {
+		limits := api.AsyncOperationLimits{
+			Max:   conf.MaxConcurrentOperations,
+			Types: conf.OperationLimits,
+		}
+		if err := validateOperationLimits(&limits); err != nil {
+			logger.Warning("Adv: %v, limits of concurrent operations ignored", err)
+		} else {
+			logger.Info("Adv: At most %v concurrent operations, by type %v",
+				limits.Max, limits.Types)
+
+			// From app_queue_limits.go
+			a.operations.limiter.set(limits)
+		}
 	}
 }
 
@@ -217,11 +754,21 @@ func (a *App) SetRoutes(router *mux.Router) error {
 	routes := rest.Routes{
 
 		// Asynchronous Manager
+		rest.Route{
+			Name:        "AsyncList",
+			Method:      "GET",
+			Pattern:     ASYNC_ROUTE,
+			HandlerFunc: a.AsyncOperationList},
 		rest.Route{
 			Name:        "Async",
 			Method:      "GET",
 			Pattern:     ASYNC_ROUTE + "/{id:[A-Fa-f0-9]+}",
-			HandlerFunc: a.asyncManager.HandlerStatus},
+			HandlerFunc: a.AsyncOperationStatus},
+		rest.Route{
+			Name:        "AsyncCancel",
+			Method:      "DELETE",
+			Pattern:     ASYNC_ROUTE + "/{id:[A-Fa-f0-9]+}",
+			HandlerFunc: a.AsyncOperationCancel},
 
 		// Cluster
 		rest.Route{
@@ -244,6 +791,31 @@ func (a *App) SetRoutes(router *mux.Router) error {
 			Method:      "DELETE",
 			Pattern:     "/clusters/{id:[A-Fa-f0-9]+}",
 			HandlerFunc: a.ClusterDelete},
+		rest.Route{
+			Name:        "ClusterDeleteImpact",
+			Method:      "GET",
+			Pattern:     "/clusters/{id:[A-Fa-f0-9]+}/delete-impact",
+			HandlerFunc: a.ClusterDeleteImpact},
+		rest.Route{
+			Name:        "ClusterSetPolicy",
+			Method:      "PUT",
+			Pattern:     "/clusters/{id:[A-Fa-f0-9]+}/policy",
+			HandlerFunc: a.ClusterSetPolicy},
+		rest.Route{
+			Name:        "ClusterSetRebalancePolicy",
+			Method:      "PUT",
+			Pattern:     "/clusters/{id:[A-Fa-f0-9]+}/rebalance-policy",
+			HandlerFunc: a.ClusterSetRebalancePolicy},
+		rest.Route{
+			Name:        "ClusterSetStoragePolicy",
+			Method:      "PUT",
+			Pattern:     "/clusters/{id:[A-Fa-f0-9]+}/storage-policy",
+			HandlerFunc: a.ClusterSetStoragePolicy},
+		rest.Route{
+			Name:        "ClusterZones",
+			Method:      "GET",
+			Pattern:     "/clusters/{id:[A-Fa-f0-9]+}/zones",
+			HandlerFunc: a.ClusterZones},
 
 		// Node
 		rest.Route{
@@ -251,11 +823,31 @@ func (a *App) SetRoutes(router *mux.Router) error {
 			Method:      "POST",
 			Pattern:     "/nodes",
 			HandlerFunc: a.NodeAdd},
+		rest.Route{
+			Name:        "NodeList",
+			Method:      "GET",
+			Pattern:     "/nodes",
+			HandlerFunc: a.NodeList},
 		rest.Route{
 			Name:        "NodeInfo",
 			Method:      "GET",
 			Pattern:     "/nodes/{id:[A-Fa-f0-9]+}",
 			HandlerFunc: a.NodeInfo},
+		rest.Route{
+			Name:        "NodeMetrics",
+			Method:      "GET",
+			Pattern:     "/nodes/{id:[A-Fa-f0-9]+}/metrics",
+			HandlerFunc: a.NodeMetrics},
+		rest.Route{
+			Name:        "NodeVolumes",
+			Method:      "GET",
+			Pattern:     "/nodes/{id:[A-Fa-f0-9]+}/volumes",
+			HandlerFunc: a.NodeVolumes},
+		rest.Route{
+			Name:        "NodeUpdate",
+			Method:      "PUT",
+			Pattern:     "/nodes/{id:[A-Fa-f0-9]+}",
+			HandlerFunc: a.NodeUpdate},
 		rest.Route{
 			Name:        "NodeDelete",
 			Method:      "DELETE",
@@ -266,6 +858,21 @@ func (a *App) SetRoutes(router *mux.Router) error {
 			Method:      "POST",
 			Pattern:     "/nodes/{id:[A-Fa-f0-9]+}/state",
 			HandlerFunc: a.NodeSetState},
+		rest.Route{
+			Name:        "NodeSetOwner",
+			Method:      "POST",
+			Pattern:     "/nodes/{id:[A-Fa-f0-9]+}/owner",
+			HandlerFunc: a.NodeSetOwner},
+		rest.Route{
+			Name:        "NodeSetZone",
+			Method:      "PUT",
+			Pattern:     "/nodes/{id:[A-Fa-f0-9]+}/zone",
+			HandlerFunc: a.NodeSetZone},
+		rest.Route{
+			Name:        "NodeRaw",
+			Method:      "GET",
+			Pattern:     "/nodes/{id:[A-Fa-f0-9]+}/raw",
+			HandlerFunc: a.NodeRaw},
 
 		// Devices
 		rest.Route{
@@ -288,6 +895,16 @@ func (a *App) SetRoutes(router *mux.Router) error {
 			Method:      "POST",
 			Pattern:     "/devices/{id:[A-Fa-f0-9]+}/state",
 			HandlerFunc: a.DeviceSetState},
+		rest.Route{
+			Name:        "DeviceTrim",
+			Method:      "POST",
+			Pattern:     "/devices/{id:[A-Fa-f0-9]+}/trim",
+			HandlerFunc: a.DeviceTrim},
+		rest.Route{
+			Name:        "DeviceScrub",
+			Method:      "POST",
+			Pattern:     "/devices/{id:[A-Fa-f0-9]+}/scrub",
+			HandlerFunc: a.DeviceScrub},
 
 		// Volume
 		rest.Route{
@@ -295,6 +912,11 @@ func (a *App) SetRoutes(router *mux.Router) error {
 			Method:      "POST",
 			Pattern:     "/volumes",
 			HandlerFunc: a.VolumeCreate},
+		rest.Route{
+			Name:        "VolumeDryRun",
+			Method:      "POST",
+			Pattern:     "/volumes/dry-run",
+			HandlerFunc: a.VolumeDryRun},
 		rest.Route{
 			Name:        "VolumeInfo",
 			Method:      "GET",
@@ -305,6 +927,50 @@ func (a *App) SetRoutes(router *mux.Router) error {
 			Method:      "POST",
 			Pattern:     "/volumes/{id:[A-Fa-f0-9]+}/expand",
 			HandlerFunc: a.VolumeExpand},
+		rest.Route{
+			Name:        "VolumeShrink",
+			Method:      "POST",
+			Pattern:     "/volumes/{id:[A-Fa-f0-9]+}/shrink",
+			HandlerFunc: a.VolumeShrink},
+		rest.Route{
+			Name:        "VolumeRename",
+			Method:      "PUT",
+			Pattern:     "/volumes/{id:[A-Fa-f0-9]+}/name",
+			HandlerFunc: a.VolumeRename},
+		rest.Route{
+			Name:        "VolumeConsistencyCheck",
+			Method:      "POST",
+			Pattern:     "/volumes/{id:[A-Fa-f0-9]+}/consistency-check",
+			HandlerFunc: a.VolumeConsistencyCheck},
+		rest.Route{
+			Name:        "VolumeProfileStart",
+			Method:      "POST",
+			Pattern:     "/volumes/{id:[A-Fa-f0-9]+}/profile/start",
+			HandlerFunc: a.VolumeProfileStart},
+		rest.Route{
+			Name:        "VolumeProfileStop",
+			Method:      "POST",
+			Pattern:     "/volumes/{id:[A-Fa-f0-9]+}/profile/stop",
+			HandlerFunc: a.VolumeProfileStop},
+		rest.Route{
+			Name:        "VolumeProfileInfo",
+			Method:      "GET",
+			Pattern:     "/volumes/{id:[A-Fa-f0-9]+}/profile/info",
+			HandlerFunc: a.VolumeProfileInfo},
+
+		rest.Route{
+			Name:        "VolumeMount",
+			Method:      "GET",
+			Pattern:     "/volumes/{id:[A-Fa-f0-9]+}/mount",
+			HandlerFunc: a.VolumeMount},
+
+		// Retrieving the secret is a change, so it is rejected
+		// in read-only mode even though it is a GET
+		rest.Route{
+			Name:        "VolumeCHAPCredentials",
+			Method:      "GET",
+			Pattern:     "/volumes/{id:[A-Fa-f0-9]+}/chap-credentials",
+			HandlerFunc: a.readOnlyFilter(a.VolumeCHAPCredentials)},
 		rest.Route{
 			Name:        "VolumeDelete",
 			Method:      "DELETE",
@@ -322,19 +988,155 @@ func (a *App) SetRoutes(router *mux.Router) error {
 			Method:      "GET",
 			Pattern:     "/backup/db",
 			HandlerFunc: a.Backup},
+		rest.Route{
+			Name:        "BackupRestore",
+			Method:      "POST",
+			Pattern:     "/backup/db",
+			HandlerFunc: a.BackupRestore},
+
+		// Admin
+		rest.Route{
+			Name:        "AuditLog",
+			Method:      "GET",
+			Pattern:     "/auditlog",
+			HandlerFunc: a.AuditLog},
+		rest.Route{
+			Name:        "DbStats",
+			Method:      "GET",
+			Pattern:     "/admin/db/stats",
+			HandlerFunc: a.DbStats},
+		rest.Route{
+			Name:        "Metrics",
+			Method:      "GET",
+			Pattern:     "/metrics",
+			HandlerFunc: a.Metrics},
+		rest.Route{
+			Name:        "ReadOnlyGet",
+			Method:      "GET",
+			Pattern:     "/admin/readonly",
+			HandlerFunc: a.ReadOnlyGet},
+		rest.Route{
+			Name:        "ReadOnlySet",
+			Method:      "POST",
+			Pattern:     "/admin/readonly",
+			HandlerFunc: a.ReadOnlySet},
+		rest.Route{
+			Name:        "AsyncOperationLimitsGet",
+			Method:      "GET",
+			Pattern:     "/admin/operations/limits",
+			HandlerFunc: a.AsyncOperationLimitsGet},
+		rest.Route{
+			Name:        "AsyncOperationLimitsSet",
+			Method:      "PUT",
+			Pattern:     "/admin/operations/limits",
+			HandlerFunc: a.AsyncOperationLimitsSet},
+		rest.Route{
+			Name:        "ConfigReload",
+			Method:      "POST",
+			Pattern:     "/admin/config/reload",
+			HandlerFunc: a.ConfigReload},
+		rest.Route{
+			Name:        "TombstoneList",
+			Method:      "GET",
+			Pattern:     "/admin/tombstones",
+			HandlerFunc: a.TombstoneList},
+		rest.Route{
+			Name:        "TombstonePurge",
+			Method:      "POST",
+			Pattern:     "/admin/tombstones/purge",
+			HandlerFunc: a.TombstonePurge},
+		rest.Route{
+			Name:        "TombstoneDelete",
+			Method:      "DELETE",
+			Pattern:     "/admin/tombstones/{type}/{id:[A-Fa-f0-9]+}",
+			HandlerFunc: a.TombstoneDelete},
+		rest.Route{
+			Name:        "TombstoneRestore",
+			Method:      "POST",
+			Pattern:     "/admin/tombstones/{type}/{id:[A-Fa-f0-9]+}/restore",
+			HandlerFunc: a.TombstoneRestore},
+		rest.Route{
+			Name:        "LogLevelGet",
+			Method:      "GET",
+			Pattern:     "/internal/loglevel",
+			HandlerFunc: a.LogLevelGet},
+		rest.Route{
+			Name:        "LogLevelSet",
+			Method:      "POST",
+			Pattern:     "/internal/loglevel",
+			HandlerFunc: a.LogLevelSet},
+		rest.Route{
+			Name:        "MaintenanceGet",
+			Method:      "GET",
+			Pattern:     "/internal/maintenance",
+			HandlerFunc: a.MaintenanceGet},
+		rest.Route{
+			Name:        "MaintenanceSet",
+			Method:      "POST",
+			Pattern:     "/internal/maintenance",
+			HandlerFunc: a.MaintenanceSet},
+		rest.Route{
+			Name:        "DebugState",
+			Method:      "GET",
+			Pattern:     "/internal/debug/state",
+			HandlerFunc: a.DebugState},
 	}
 
 	// Register all routes from the App
 	for _, route := range routes {
 
+		// Return the result of the first of the repeated creates
+		handler := route.HandlerFunc
+		if idempotentRoutes[route.Name] {
+			handler = a.idempotencyFilter(handler)
+		}
+
+		// Reject changes in read-only mode
+		if route.Method != "GET" && !readOnlyAllowedRoutes[route.Name] {
+			handler = a.readOnlyFilter(handler)
+		}
+		if maintenanceRejectedRoutes[route.Name] {
+			handler = a.maintenanceFilter(handler)
+		}
+		if (route.Method == "POST" || route.Method == "PUT") &&
+			!fileBodyRoutes[route.Name] {
+			handler = jsonContentHandler(handler)
+		}
+		if route.Method != "GET" {
+			handler = a.drainFilter(handler)
+			handler = a.auditHandler(handler)
+			if !fileBodyRoutes[route.Name] {
+				handler = asyncBodyHandler(handler)
+			}
+		}
+		handler = recoverHandler(route.Name, handler)
+		handler = metricsHandler(route.Name, handler)
+		handler = requestIdHandler(handler)
+
 		// Add routes from the table
 		router.
 			Methods(route.Method).
 			Path(route.Pattern).
 			Name(route.Name).
-			Handler(route.HandlerFunc)
+			Handler(handler)
+
+		// And under the v2 routes, with the same name
+		router.
+			Methods(route.Method).
+			Path(API_V2_ROUTE + route.Pattern).
+			Name(route.Name).
+			Handler(v2Handler(handler))
 
 	}
+	router.
+		Methods("GET").
+		Path(API_V2_ROUTE + "/hello").
+		Name("HelloV2").
+		HandlerFunc(a.HelloV2)
+
+	// Operations which had not started before the last shutdown
+	// need the routes to run again
+	a.resumeOperations(router)
 
 	return nil
 
@@ -342,6 +1144,24 @@ func (a *App) SetRoutes(router *mux.Router) error {
 
 func (a *App) Close() {
 
+	// Stop background tasks
+	close(a.stop)
+	a.stopLogLevelsRevert()
+
+	// Stop reporting the capacity of the clusters
+	metricsStorage.unsetDb(a.db)
+
+	// The operations still running use the db until they stop
+	a.operations.limiter.hold()
+	a.operations.stopRunning()
+	for logged := false; a.runningOperations() != 0; logged = true {
+		if !logged {
+			logger.Info("Waiting for %v asynchronous operations to stop",
+				a.runningOperations())
+		}
+		time.Sleep(drainPollInterval)
+	}
+
 	// Close the DB
 	a.db.Close()
 	logger.Info("Closed")
@@ -357,7 +1177,7 @@ func (a *App) Auth(w http.ResponseWriter, r *http.Request, next http.HandlerFunc
 	token := data.(*jwt.Token)
 
 	// Check access
-	if "user" == token.Claims["iss"] && r.URL.Path != "/volumes" {
+	if "user" == token.Claims["iss"] && !userAllowedPaths[r.URL.Path] {
 		http.Error(w, "Administrator access required", http.StatusUnauthorized)
 		return
 	}
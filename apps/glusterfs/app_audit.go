@@ -0,0 +1,427 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+const (
+	BOLTDB_BUCKET_AUDIT_LOG = "AUDITLOG"
+
+	// Bytes of the body of a request, and of the error of a
+	// response, kept in its audit record
+	auditSummaryMax = 1024
+)
+
+var (
+	// Days audit records are kept
+	AuditLogRetentionDays = 90
+
+	// Time between purges of old audit records
+	auditLogPurgeInterval = time.Hour
+)
+
+// Append-only log of the changes requested through the API, saved in
+// db.  The records are keyed by the time they were written, in
+// nanoseconds, so that they are listed in order.  The methods of a
+// nil audit log do nothing, for when it is disabled.
+type auditLog struct {
+	db *bolt.DB
+
+	// Requests are rejected if their audit record cannot be written
+	strict bool
+
+	// Key of the last record, so that keys are unique
+	lock sync.Mutex
+	last int64
+}
+
+func newAuditLog(db *bolt.DB, strict bool) (*auditLog, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_AUDIT_LOG))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditLog{
+		db:     db,
+		strict: strict,
+	}, nil
+}
+
+func auditKey(t int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t))
+	return key
+}
+
+func (l *auditLog) write(record *api.AuditRecord) error {
+	if l == nil {
+		return nil
+	}
+
+	buffer, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now().UnixNano()
+	if now <= l.last {
+		now = l.last + 1
+	}
+	err = l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BOLTDB_BUCKET_AUDIT_LOG))
+		if b == nil {
+			return ErrDbAccess
+		}
+		return b.Put(auditKey(now), buffer)
+	})
+	if err != nil {
+		return err
+	}
+	l.last = now
+
+	return nil
+}
+
+// Returns the records written at or after since, at most limit of
+// them if limit is not 0
+func (l *auditLog) list(since time.Time, limit int) ([]api.AuditRecord, error) {
+	records := []api.AuditRecord{}
+	err := l.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BOLTDB_BUCKET_AUDIT_LOG))
+		if b == nil {
+			return ErrDbAccess
+		}
+
+		c := b.Cursor()
+		k, v := c.First()
+		if !since.IsZero() {
+			k, v = c.Seek(auditKey(since.UnixNano()))
+		}
+		for ; k != nil; k, v = c.Next() {
+			if limit != 0 && len(records) >= limit {
+				break
+			}
+
+			var record api.AuditRecord
+			err := json.Unmarshal(v, &record)
+			if err != nil {
+				return fmt.Errorf("Unable to decode audit record: %v", err)
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Removes the records written before the time given.  Returns the
+// number of records removed.
+func (l *auditLog) purge(before time.Time) (int, error) {
+	purged := 0
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BOLTDB_BUCKET_AUDIT_LOG))
+		if b == nil {
+			return ErrDbAccess
+		}
+
+		end := auditKey(before.UnixNano())
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.First() {
+			err := b.Delete(k)
+			if err != nil {
+				return err
+			}
+			purged++
+		}
+		return nil
+	})
+
+	return purged, err
+}
+
+// Purges the audit records older than the retention
+func purgeAuditLogEvery(l *auditLog, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			days := settings().AuditLogRetentionDays
+			purged, err := l.purge(time.Now().AddDate(0, 0, -days))
+			if err != nil {
+				logger.LogError("Unable to purge audit log: %v", err)
+			} else if purged > 0 {
+				logger.Info("Purged %v audit records older than %v days",
+					purged, days)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Returns the body of the request with the values of the fields
+// which may hold secrets masked, truncated to auditSummaryMax
+func auditSummary(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Sprintf("%v bytes", len(body))
+	}
+	masked, err := json.Marshal(auditMask(value))
+	if err != nil {
+		return fmt.Sprintf("%v bytes", len(body))
+	}
+
+	if len(masked) > auditSummaryMax {
+		return string(masked[:auditSummaryMax]) + "..."
+	}
+	return string(masked)
+}
+
+func auditMask(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			name := strings.ToLower(key)
+			if strings.Contains(name, "secret") ||
+				strings.Contains(name, "password") ||
+				strings.Contains(name, "key") {
+				v[key] = "*****"
+			} else {
+				v[key] = auditMask(field)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = auditMask(v[i])
+		}
+	}
+	return value
+}
+
+// Returns the record of the request before it is handled
+func newAuditRecord(r *http.Request) *api.AuditRecord {
+	record := &api.AuditRecord{
+		Time:      time.Now().Unix(),
+		Event:     api.AuditEventRequest,
+		RequestId: requestId(r),
+		Issuer:    requestUser(r),
+		Route:     requestName(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Summary:   auditSummary(requestBody(r)),
+	}
+	if token, ok := context.Get(r, "jwt").(*jwt.Token); ok {
+		if sub, ok := token.Claims["sub"].(string); ok {
+			record.Subject = sub
+		}
+	}
+
+	// Ids in the path of the request, in the order of their names
+	vars := mux.Vars(r)
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		record.Targets = append(record.Targets, vars[name])
+	}
+
+	return record
+}
+
+// Keeps the status of the response, and the start of its body if it
+// is an error
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.status >= http.StatusBadRequest && w.body.Len() < auditSummaryMax {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Records the request in the audit log before it is handled, and its
+// response once handled.  The request is rejected when its record
+// cannot be written in strict mode.
+func (a *App) auditHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.audit == nil {
+			handler(w, r)
+			return
+		}
+
+		record := newAuditRecord(r)
+		err := a.audit.write(record)
+		if err != nil {
+			logger.LogError("Unable to write audit record of request %v: %v",
+				record.RequestId, err)
+			if a.audit.strict {
+				http.Error(w, "Unable to write audit record", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		aw := &auditResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		handler(aw, r)
+
+		record.Time = time.Now().Unix()
+		record.Event = api.AuditEventResponse
+		record.Summary = ""
+		record.Status = aw.status
+		location := strings.TrimPrefix(w.Header().Get("Location"), API_V2_ROUTE)
+		switch {
+		case aw.status == http.StatusAccepted && strings.HasPrefix(location, ASYNC_ROUTE+"/"):
+			record.OperationId = strings.TrimPrefix(location, ASYNC_ROUTE+"/")
+			record.Outcome = api.AsyncOperationPending
+		case aw.status >= http.StatusBadRequest:
+			record.Outcome = api.AsyncOperationFailed
+			record.Error = strings.TrimSpace(aw.body.String())
+		default:
+			record.Outcome = api.AsyncOperationSucceeded
+		}
+		err = a.audit.write(record)
+		if err != nil {
+			logger.LogError("Unable to write audit record of request %v: %v",
+				record.RequestId, err)
+		}
+	}
+}
+
+// Records the outcome of the asynchronous operation once completed
+func (a *App) auditOperationDone(id string) {
+	if a.audit == nil {
+		return
+	}
+
+	info, ok := a.operations.get(id)
+	if !ok {
+		return
+	}
+	record := &api.AuditRecord{
+		Time:        time.Now().Unix(),
+		Event:       api.AuditEventCompleted,
+		RequestId:   info.RequestId,
+		Issuer:      info.User,
+		Route:       info.Type,
+		Method:      info.Method,
+		Path:        info.Path,
+		Targets:     info.Targets,
+		OperationId: id,
+		Outcome:     info.State,
+		Error:       info.Error,
+	}
+	if info.Location != "" {
+		record.Targets = append(record.Targets, info.Location)
+	}
+	err := a.audit.write(record)
+	if err != nil {
+		logger.LogError("Unable to write audit record of operation %v: %v", id, err)
+	}
+}
+
+// Lists the audit records written since the time given by the since
+// parameter, either as RFC 3339 with optional fractional seconds or
+// in seconds since the epoch, and at most as many as the limit
+// parameter if set
+func (a *App) AuditLog(w http.ResponseWriter, r *http.Request) {
+	if a.audit == nil {
+		http.Error(w, "Audit log is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var since time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			seconds, serr := strconv.ParseInt(s, 10, 64)
+			if serr != nil {
+				http.Error(w, "Invalid since "+s, http.StatusBadRequest)
+				return
+			}
+			since = time.Unix(seconds, 0)
+		}
+	}
+
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			http.Error(w, "Invalid limit "+s, http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := a.audit.list(since, limit)
+	if err != nil {
+		logger.Err(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(&api.AuditLogResponse{
+		Records: records,
+	}); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,195 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/tests"
+)
+
+func TestAuditSummary(t *testing.T) {
+	tests.Assert(t, auditSummary(nil) == "")
+	tests.Assert(t, auditSummary([]byte("not json")) == "8 bytes")
+
+	summary := auditSummary([]byte(`{"size":10,"auth":{"Secret":"s3cr3t","user":"bob"},` +
+		`"items":[{"key":"k"}]}`))
+	tests.Assert(t, !strings.Contains(summary, "s3cr3t"), summary)
+	tests.Assert(t, !strings.Contains(summary, `"k"`), summary)
+	tests.Assert(t, strings.Contains(summary, `"user":"bob"`), summary)
+	tests.Assert(t, strings.Contains(summary, `"size":10`), summary)
+
+	long := auditSummary([]byte(`"` + strings.Repeat("a", 2*auditSummaryMax) + `"`))
+	tests.Assert(t, len(long) == auditSummaryMax+3, len(long))
+}
+
+func TestAuditLog(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	// Not enabled
+	_, err := c.AuditLog(time.Time{}, 0)
+	tests.Assert(t, err != nil)
+
+	app.audit, err = newAuditLog(app.db, false)
+	tests.Assert(t, err == nil)
+
+	// A change which succeeds
+	cluster, err := c.ClusterCreate()
+	tests.Assert(t, err == nil)
+
+	log, err := c.AuditLog(time.Time{}, 0)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(log.Records) == 2, log.Records)
+	request, response := log.Records[0], log.Records[1]
+	tests.Assert(t, request.Event == api.AuditEventRequest)
+	tests.Assert(t, request.Route == "ClusterCreate")
+	tests.Assert(t, request.Method == "POST" && request.Path == "/clusters")
+	tests.Assert(t, request.Issuer == "127.0.0.1", request.Issuer)
+	tests.Assert(t, request.RequestId != "")
+	tests.Assert(t, request.Summary == "{}", request.Summary)
+	tests.Assert(t, request.Outcome == "")
+	tests.Assert(t, response.Event == api.AuditEventResponse)
+	tests.Assert(t, response.RequestId == request.RequestId)
+	tests.Assert(t, response.Status == http.StatusCreated)
+	tests.Assert(t, response.Outcome == api.AsyncOperationSucceeded)
+
+	// Reads are not recorded, failures are
+	_, err = c.ClusterInfo(cluster.Id)
+	tests.Assert(t, err == nil)
+	err = c.ClusterDelete("123456789")
+	tests.Assert(t, err != nil)
+
+	log, err = c.AuditLog(time.Time{}, 0)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(log.Records) == 4, log.Records)
+	request, response = log.Records[2], log.Records[3]
+	tests.Assert(t, request.Route == "ClusterDelete")
+	tests.Assert(t, len(request.Targets) == 1 && request.Targets[0] == "123456789",
+		request.Targets)
+	tests.Assert(t, response.Status == http.StatusNotFound)
+	tests.Assert(t, response.Outcome == api.AsyncOperationFailed)
+	tests.Assert(t, response.Error != "")
+
+	// The completion of asynchronous operations is recorded
+	err = setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	since := time.Now()
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	volume, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+
+	log, err = c.AuditLog(since, 0)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(log.Records) == 3, log.Records)
+	request, response = log.Records[0], log.Records[1]
+	completed := log.Records[2]
+	tests.Assert(t, request.Route == "VolumeCreate")
+	tests.Assert(t, strings.Contains(request.Summary, `"size":10`), request.Summary)
+	tests.Assert(t, response.Status == http.StatusAccepted)
+	tests.Assert(t, response.Outcome == api.AsyncOperationPending)
+	tests.Assert(t, response.OperationId != "")
+	tests.Assert(t, completed.Event == api.AuditEventCompleted)
+	tests.Assert(t, completed.RequestId == request.RequestId)
+	tests.Assert(t, completed.OperationId == response.OperationId)
+	tests.Assert(t, completed.Outcome == api.AsyncOperationSucceeded)
+	tests.Assert(t, completed.Targets[len(completed.Targets)-1] == "/volumes/"+volume.Id,
+		completed.Targets)
+
+	// Limit
+	log, err = c.AuditLog(time.Time{}, 5)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(log.Records) == 5)
+
+	// Old records are purged
+	purged, err := app.audit.purge(since)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, purged == 4, purged)
+	log, err = c.AuditLog(time.Time{}, 0)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(log.Records) == 3)
+}
+
+func TestAuditLogStrict(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	var err error
+	app.audit, err = newAuditLog(app.db, false)
+	tests.Assert(t, err == nil)
+
+	// Records cannot be written
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(BOLTDB_BUCKET_AUDIT_LOG))
+	})
+	tests.Assert(t, err == nil)
+
+	// Changes are allowed unless in strict mode
+	_, err = c.ClusterCreate()
+	tests.Assert(t, err == nil, err)
+
+	app.audit.strict = true
+	_, err = c.ClusterCreate()
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "audit"), err)
+
+	list, err := c.ClusterList()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(list.Clusters) == 1)
+}
@@ -18,9 +18,11 @@ package glusterfs
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
 	"net/http"
 )
 
@@ -56,11 +58,17 @@ func (a *App) ClusterList(w http.ResponseWriter, r *http.Request) {
 
 	var list api.ClusterListResponse
 
-	// Get all the cluster ids from the DB
-	err := a.db.View(func(tx *bolt.Tx) error {
+	page, err := newListPageFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get the cluster ids of the page from the DB
+	err = a.db.View(func(tx *bolt.Tx) error {
 		var err error
 
-		list.Clusters, err = ClusterList(tx)
+		list.Clusters, list.Continue, err = page.keys(tx, BOLTDB_BUCKET_CLUSTER, nil)
 		if err != nil {
 			return err
 		}
@@ -69,7 +77,7 @@ func (a *App) ClusterList(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if err != nil {
-		logger.Err(err)
+		requestLogger(r).Err(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -123,17 +131,65 @@ func (a *App) ClusterInfo(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func (a *App) ClusterZones(w http.ResponseWriter, r *http.Request) {
+
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Get info from db
+	var info api.ClusterZonesResponse
+	err := a.db.View(func(tx *bolt.Tx) error {
+
+		// Create a db entry from the id
+		entry, err := NewClusterEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		info.Zones, err = entry.ZoneInfo(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+
+}
+
 func (a *App) ClusterDelete(w http.ResponseWriter, r *http.Request) {
 
 	// Get the id from the URL
 	vars := mux.Vars(r)
 	id := vars["id"]
+	user := requestUser(r)
 
-	// Delete cluster from db
+	// Delete cluster from db, unless its contents must be destroyed first
+	var (
+		cluster *ClusterEntry
+		destroy bool
+	)
 	err := a.db.Update(func(tx *bolt.Tx) error {
+		defer setTxUser(tx, user)()
 
 		// Access cluster entry
-		entry, err := NewClusterEntryFromId(tx, id)
+		var err error
+		cluster, err = NewClusterEntryFromId(tx, id)
 		if err == ErrNotFound {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return err
@@ -142,7 +198,10 @@ func (a *App) ClusterDelete(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
-		err = entry.Delete(tx)
+		destroy, err = cluster.checkDeletePolicy(tx)
+		if err == nil && !destroy {
+			err = cluster.Delete(tx)
+		}
 		if err == ErrConflict {
 			http.Error(w, err.Error(), http.StatusConflict)
 			return err
@@ -151,18 +210,229 @@ func (a *App) ClusterDelete(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
+		// Its contents are only destroyed once the client has seen
+		// what is deleted
+		if destroy {
+			impact, err := cluster.EstimatedDeleteImpact(tx)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+			if r.URL.Query().Get("confirm") != impact.Hash {
+				err = fmt.Errorf("Deleting cluster %v deletes %v volumes and %v nodes, "+
+					"confirm with the hash of its delete impact", id,
+					impact.Volumes, impact.Nodes)
+				http.Error(w, err.Error(), http.StatusPreconditionFailed)
+				return err
+			}
+		}
+
 		return nil
 	})
 	if err != nil {
 		return
 	}
 
+	if destroy {
+		requestLogger(r).Warning("Audit: %v deleting cluster %v with the %v policy, "+
+			"including its %v volumes and %v nodes",
+			user, id, cluster.DeletePolicy,
+			len(cluster.Info.Volumes), len(cluster.Info.Nodes))
+
+		a.asyncHttpRedirectFunc(w, r, []string{id}, func(cancel *operationCancel) (string, error) {
+			err := cluster.Destroy(a.db, a.requestExecutor(r), a.allocator, user, cancel)
+			if err != nil {
+				requestLogger(r).LogError("Failed to delete cluster %v: %v", id, err)
+				return "", err
+			}
+
+			requestLogger(r).Info("Deleted cluster [%s]", id)
+			return "", nil
+		})
+		return
+	}
+
 	// Update allocator hat the cluster has been removed
 	a.allocator.RemoveCluster(id)
 
 	// Show that the key has been deleted
-	logger.Info("Deleted cluster [%s]", id)
+	requestLogger(r).Info("Deleted cluster [%s]", id)
 
 	// Write msg
 	w.WriteHeader(http.StatusOK)
 }
+
+// Returns what deleting the cluster with its contents deletes, with
+// the hash to confirm the delete with
+func (a *App) ClusterDeleteImpact(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var impact *api.DeleteImpact
+	err := a.db.View(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		impact, err = cluster.EstimatedDeleteImpact(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(impact); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) ClusterSetPolicy(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Unmarshal JSON
+	var msg api.ClusterPolicyRequest
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+	if !isValidClusterDeletePolicy(msg.DeletePolicy) {
+		http.Error(w, "Unknown delete policy "+msg.DeletePolicy, http.StatusBadRequest)
+		return
+	}
+
+	err = a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		cluster.DeletePolicy = msg.DeletePolicy
+		err = cluster.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	requestLogger(r).Info("Delete policy of cluster %v set to '%v'", id, msg.DeletePolicy)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) ClusterSetRebalancePolicy(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Unmarshal JSON
+	var msg api.ClusterRebalancePolicyRequest
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+	err = validateClusterRebalancePolicy(msg.Policy, msg.Schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		cluster.RebalancePolicy = msg.Policy
+		cluster.RebalanceSchedule = msg.Schedule
+		err = cluster.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	requestLogger(r).Info("Rebalance policy of cluster %v set to '%v' '%v'", id, msg.Policy, msg.Schedule)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) ClusterSetStoragePolicy(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Unmarshal JSON
+	var msg api.ClusterStoragePolicy
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+	policy := StoragePolicy{
+		MinBrickSizeGB: msg.MinBrickSizeGB,
+		MaxBrickSizeGB: msg.MaxBrickSizeGB,
+	}
+	err = policy.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		cluster.StoragePolicy = policy
+		err = cluster.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	requestLogger(r).Info("Storage policy of cluster %v set to bricks of %v to %v GB",
+		id, msg.MinBrickSizeGB, msg.MaxBrickSizeGB)
+	w.WriteHeader(http.StatusOK)
+}
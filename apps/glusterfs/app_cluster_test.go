@@ -23,10 +23,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/executors"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/heketi/heketi/pkg/utils"
 	"github.com/heketi/tests"
@@ -146,6 +151,79 @@ func TestClusterList(t *testing.T) {
 	}
 }
 
+func TestClusterListPaged(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	numclusters := 5
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < numclusters; i++ {
+			entry := NewClusterEntry()
+			entry.Info.Id = fmt.Sprintf("%v", 5000+i)
+			err := entry.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Follow the pages
+	ids := make([]string, 0)
+	pages := 0
+	path := "/clusters?limit=2"
+	for {
+		r, err := http.Get(ts.URL + path)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, r.StatusCode == http.StatusOK)
+
+		var msg api.ClusterListResponse
+		err = utils.GetJsonFromResponse(r, &msg)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(msg.Clusters) <= 2)
+
+		ids = append(ids, msg.Clusters...)
+		pages++
+		if msg.Continue == "" {
+			break
+		}
+		path = "/clusters?limit=2&continue=" + msg.Continue
+	}
+	tests.Assert(t, pages == 3, pages)
+	tests.Assert(t, len(ids) == numclusters)
+	for i, id := range ids {
+		tests.Assert(t, id == fmt.Sprintf("%v", 5000+i))
+	}
+
+	// A limit covering the list has no next page
+	r, err := http.Get(ts.URL + "/clusters?limit=5")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+	var msg api.ClusterListResponse
+	err = utils.GetJsonFromResponse(r, &msg)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(msg.Clusters) == numclusters)
+	tests.Assert(t, msg.Continue == "")
+
+	// Bad parameters
+	for _, query := range []string{"limit=-1", "limit=abc", "continue=%21%21"} {
+		r, err := http.Get(ts.URL + "/clusters?" + query)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, r.StatusCode == http.StatusBadRequest, query)
+	}
+}
+
 func TestClusterInfoIdNotFound(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -208,6 +286,14 @@ func TestClusterInfo(t *testing.T) {
 			return err
 		}
 
+		// Volumes are reported from the cluster index
+		for _, vol := range entry.Info.Volumes {
+			err = IndexAdd(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, entry.Info.Id, vol)
+			if err != nil {
+				return err
+			}
+		}
+
 		return nil
 
 	})
@@ -383,3 +469,683 @@ func TestClusterDelete(t *testing.T) {
 	tests.Assert(t, err == nil, err)
 
 }
+
+func TestClusterSetPolicy(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	cluster, err := c.ClusterCreate()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, cluster.DeletePolicy == "")
+
+	err = c.ClusterPolicy(cluster.Id, &api.ClusterPolicyRequest{
+		DeletePolicy: api.ClusterDeletePolicyCascade,
+	})
+	tests.Assert(t, err == nil)
+	info, err := c.ClusterInfo(cluster.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.DeletePolicy == api.ClusterDeletePolicyCascade)
+
+	// Unknown policy
+	err = c.ClusterPolicy(cluster.Id, &api.ClusterPolicyRequest{
+		DeletePolicy: "sometimes",
+	})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Unknown delete policy"))
+	info, err = c.ClusterInfo(cluster.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.DeletePolicy == api.ClusterDeletePolicyCascade)
+
+	// Unknown cluster
+	err = c.ClusterPolicy("123456", &api.ClusterPolicyRequest{})
+	tests.Assert(t, err != nil)
+}
+
+func TestClusterZones(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	// Nodes alternate between zones 0 and 1
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		3,      // nodes_per_cluster
+		2,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var clusterId string
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		clusterId = clusters[0]
+
+		// The space of an offline node is not free
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		tests.Assert(t, err == nil)
+		for _, nodeId := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			tests.Assert(t, err == nil)
+			if node.Info.Zone == 1 {
+				node.State = api.EntryStateOffline
+				tests.Assert(t, node.Save(tx) == nil)
+			}
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	info, err := c.ClusterZones(clusterId)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(info.Zones) == 2)
+
+	zone := info.Zones[0]
+	tests.Assert(t, zone.ZoneId == 0)
+	tests.Assert(t, zone.NodeCount == 2)
+	tests.Assert(t, zone.DeviceCount == 4)
+	tests.Assert(t, zone.StorageSize == 4*500*GB, zone.StorageSize)
+	tests.Assert(t, zone.StorageFree == 4*500*GB, zone.StorageFree)
+
+	zone = info.Zones[1]
+	tests.Assert(t, zone.ZoneId == 1)
+	tests.Assert(t, zone.NodeCount == 1)
+	tests.Assert(t, zone.DeviceCount == 2)
+	tests.Assert(t, zone.StorageSize == 2*500*GB, zone.StorageSize)
+	tests.Assert(t, zone.StorageFree == 0, zone.StorageFree)
+
+	// Unknown cluster
+	_, err = c.ClusterZones("123456")
+	tests.Assert(t, err != nil)
+}
+
+func TestClusterSetRebalancePolicy(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	cluster, err := c.ClusterCreate()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, cluster.RebalancePolicy == "")
+
+	err = c.ClusterRebalancePolicy(cluster.Id, &api.ClusterRebalancePolicyRequest{
+		Policy:   api.ClusterRebalancePolicyScheduled,
+		Schedule: "0 2 * * 6",
+	})
+	tests.Assert(t, err == nil, err)
+	info, err := c.ClusterInfo(cluster.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.RebalancePolicy == api.ClusterRebalancePolicyScheduled)
+	tests.Assert(t, info.RebalanceSchedule == "0 2 * * 6")
+
+	// Invalid policies do not change it
+	for _, req := range []api.ClusterRebalancePolicyRequest{
+		{Policy: "sometimes"},
+		{Policy: api.ClusterRebalancePolicyScheduled},
+		{Policy: api.ClusterRebalancePolicyScheduled, Schedule: "0 25 * * *"},
+		{Policy: api.ClusterRebalancePolicyOnExpand, Schedule: "0 2 * * 6"},
+	} {
+		err = c.ClusterRebalancePolicy(cluster.Id, &req)
+		tests.Assert(t, err != nil, req)
+	}
+	info, err = c.ClusterInfo(cluster.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.RebalancePolicy == api.ClusterRebalancePolicyScheduled)
+
+	// The delete policy is kept
+	err = c.ClusterRebalancePolicy(cluster.Id, &api.ClusterRebalancePolicyRequest{
+		Policy: api.ClusterRebalancePolicyNever,
+	})
+	tests.Assert(t, err == nil)
+	info, err = c.ClusterInfo(cluster.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.RebalancePolicy == api.ClusterRebalancePolicyNever)
+	tests.Assert(t, info.RebalanceSchedule == "")
+
+	// Unknown cluster
+	err = c.ClusterRebalancePolicy("123456", &api.ClusterRebalancePolicyRequest{})
+	tests.Assert(t, err != nil)
+}
+
+func TestClusterSetStoragePolicy(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	clusters, err := c.ClusterList()
+	tests.Assert(t, err == nil)
+	clusterId := clusters.Clusters[0]
+	info, err := c.ClusterInfo(clusterId)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.StoragePolicy == nil)
+
+	// Invalid policies
+	err = c.ClusterStoragePolicy(clusterId, &api.ClusterStoragePolicy{
+		MinBrickSizeGB: 20,
+		MaxBrickSizeGB: 10,
+	})
+	tests.Assert(t, err != nil)
+	err = c.ClusterStoragePolicy("123456", &api.ClusterStoragePolicy{})
+	tests.Assert(t, err != nil)
+
+	err = c.ClusterStoragePolicy(clusterId, &api.ClusterStoragePolicy{
+		MinBrickSizeGB: 5,
+		MaxBrickSizeGB: 10,
+	})
+	tests.Assert(t, err == nil, err)
+	info, err = c.ClusterInfo(clusterId)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.StoragePolicy != nil)
+	tests.Assert(t, info.StoragePolicy.MinBrickSizeGB == 5, info.StoragePolicy)
+	tests.Assert(t, info.StoragePolicy.MaxBrickSizeGB == 10, info.StoragePolicy)
+
+	// Volumes are split in more bricks to stay under the maximum
+	v := createSampleVolumeEntry(40)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(v.Bricks) == 8, len(v.Bricks))
+	err = app.db.View(func(tx *bolt.Tx) error {
+		for _, id := range v.BricksIds() {
+			brick, err := NewBrickEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, brick.Info.Size <= 10*GB, brick.Info.Size)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Bricks are not made larger than the volume needs
+	v = createSampleVolumeEntry(8)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == ErrNoSpace, err)
+
+	// Removing the policy removes the limits
+	err = c.ClusterStoragePolicy(clusterId, &api.ClusterStoragePolicy{})
+	tests.Assert(t, err == nil, err)
+	info, err = c.ClusterInfo(clusterId)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.StoragePolicy == nil)
+	v = createSampleVolumeEntry(8)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+}
+
+func TestClusterRebalanceOnExpand(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	started := createSampleVolumeEntry(100)
+	err = started.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+	stopped := createSampleVolumeEntry(100)
+	err = stopped.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+
+	var nodeId string
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, started.Info.Cluster)
+		tests.Assert(t, err == nil)
+		nodeId = cluster.Info.Nodes[0]
+
+		v, err := NewVolumeEntryFromId(tx, stopped.Info.Id)
+		tests.Assert(t, err == nil)
+		v.SetStarted(false)
+		return v.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	var rebalanced []string
+	app.xo.MockVolumeRebalance = func(host, volume string) error {
+		rebalanced = append(rebalanced, volume)
+		return nil
+	}
+
+	// Not rebalanced by default
+	c := client.NewClientNoAuth(ts.URL)
+	deviceReq := &api.DeviceAddRequest{}
+	deviceReq.Name = "/dev/fake1"
+	deviceReq.NodeId = nodeId
+	err = c.DeviceAdd(deviceReq)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(rebalanced) == 0, rebalanced)
+
+	// Only the started volume is rebalanced
+	err = c.ClusterRebalancePolicy(started.Info.Cluster, &api.ClusterRebalancePolicyRequest{
+		Policy: api.ClusterRebalancePolicyOnExpand,
+	})
+	tests.Assert(t, err == nil)
+	defer tests.Patch(&RebalancePollInterval, time.Millisecond).Restore()
+	polls := 0
+	app.xo.MockVolumeRebalanceStatus = func(host, volume string) (*executors.RebalanceStatus, error) {
+		polls++
+		return &executors.RebalanceStatus{
+			Completed:      polls == 3,
+			Nodes:          2,
+			NodesCompleted: polls - 1,
+			Files:          uint64(10 * polls),
+		}, nil
+	}
+	deviceReq.Name = "/dev/fake2"
+	err = c.DeviceAdd(deviceReq)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(rebalanced) == 1, rebalanced)
+	tests.Assert(t, rebalanced[0] == started.Info.Name)
+
+	// The operation waited for the rebalance and kept its last step
+	tests.Assert(t, polls == 3, polls)
+	list, err := c.AsyncOperationList()
+	tests.Assert(t, err == nil, err)
+	var step *api.AsyncOperationStep
+	for _, op := range list.Operations {
+		if op.Step != nil && strings.HasPrefix(op.Step.Name, "rebalancing volume") {
+			step = op.Step
+		}
+	}
+	tests.Assert(t, step != nil, list.Operations)
+	tests.Assert(t, step.Name == fmt.Sprintf("rebalancing volume %v (1/1)", started.Info.Id), step)
+	tests.Assert(t, step.Done == 2 && step.Total == 2, step)
+	tests.Assert(t, step.Detail == "30 files moved", step)
+
+	// A failed rebalance does not fail the addition
+	app.xo.MockVolumeRebalance = func(host, volume string) error {
+		return errors.New("Rebalance failed")
+	}
+	nodeReq := &api.NodeAddRequest{
+		ClusterId: started.Info.Cluster,
+		Hostnames: api.HostAddresses{
+			Manage:  []string{"newmanage"},
+			Storage: []string{"newstorage"},
+		},
+		Zone: 1,
+	}
+	_, err = c.NodeAdd(nodeReq)
+	tests.Assert(t, err == nil, err)
+}
+
+func TestRebalanceScheduledClusters(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		2,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// A volume on each cluster, only the first is scheduled at 2:00
+	var clusters []string
+	var scheduled string
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err = ClusterList(tx)
+		tests.Assert(t, err == nil)
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		tests.Assert(t, err == nil)
+		scheduled = cluster.Info.Id
+		cluster.RebalancePolicy = api.ClusterRebalancePolicyScheduled
+		cluster.RebalanceSchedule = "0 2 * * *"
+		return cluster.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+	for _, id := range clusters {
+		req := &api.VolumeCreateRequest{}
+		req.Size = 100
+		req.Clusters = []string{id}
+		v := NewVolumeEntryFromRequest(req)
+		err = v.Create(app.db, app.executor, app.allocator)
+		tests.Assert(t, err == nil)
+	}
+
+	var rebalanced []string
+	app.xo.MockVolumeRebalance = func(host, volume string) error {
+		rebalanced = append(rebalanced, volume)
+		return nil
+	}
+
+	day := time.Date(2016, time.May, 1, 0, 0, 0, 0, time.Local)
+	RebalanceScheduledClusters(app.db, app.executor,
+		day.Add(time.Hour), day.Add(2*time.Hour-time.Second))
+	tests.Assert(t, len(rebalanced) == 0, rebalanced)
+
+	RebalanceScheduledClusters(app.db, app.executor,
+		day.Add(2*time.Hour-time.Second), day.Add(2*time.Hour+30*time.Second))
+	tests.Assert(t, len(rebalanced) == 1, rebalanced)
+
+	// Not again within the same minute
+	RebalanceScheduledClusters(app.db, app.executor,
+		day.Add(2*time.Hour+30*time.Second), day.Add(2*time.Hour+59*time.Second))
+	tests.Assert(t, len(rebalanced) == 1, rebalanced)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		volumes, err := IndexList(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, scheduled)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(volumes) == 1, volumes)
+		v, err := NewVolumeEntryFromId(tx, volumes[0])
+		tests.Assert(t, err == nil)
+		tests.Assert(t, rebalanced[0] == v.Info.Name)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestClusterDeletePolicy(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&TombstonesEnabled, true).Restore()
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+	clusters, err := c.ClusterList()
+	tests.Assert(t, err == nil)
+	clusterId := clusters.Clusters[0]
+
+	volumeReq := &api.VolumeCreateRequest{}
+	volumeReq.Size = 10
+	_, err = c.VolumeCreate(volumeReq)
+	tests.Assert(t, err == nil)
+	_, err = c.VolumeCreate(volumeReq)
+	tests.Assert(t, err == nil)
+
+	// The default policy fails
+	err = c.ClusterDelete(clusterId)
+	tests.Assert(t, err != nil)
+
+	// Force deletes the volumes but not the devices, so the nodes
+	// and the cluster remain
+	err = c.ClusterPolicy(clusterId, &api.ClusterPolicyRequest{
+		DeletePolicy: api.ClusterDeletePolicyForce,
+	})
+	tests.Assert(t, err == nil)
+	impact, err := c.ClusterDeleteImpact(clusterId)
+	tests.Assert(t, err == nil, err)
+	err = c.ClusterDeleteConfirm(clusterId, impact.Hash)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "has devices"), err)
+	info, err := c.ClusterInfo(clusterId)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(info.Volumes) == 0, info.Volumes)
+	tests.Assert(t, len(info.Nodes) == 3, info.Nodes)
+
+	// Cascade deletes everything
+	var teardowns, detaches int
+	app.xo.MockDeviceTeardown = func(host, device, vgid string) error {
+		teardowns++
+		return nil
+	}
+	app.xo.MockPeerDetach = func(exec_host, newnode string) error {
+		detaches++
+		return nil
+	}
+	err = c.ClusterPolicy(clusterId, &api.ClusterPolicyRequest{
+		DeletePolicy: api.ClusterDeletePolicyCascade,
+	})
+	tests.Assert(t, err == nil)
+
+	// Only once confirmed with the hash of its impact
+	err = c.ClusterDelete(clusterId)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "confirm"), err)
+	err = c.ClusterDeleteConfirm(clusterId, "abc")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, teardowns == 0)
+	impact, err = c.ClusterDeleteImpact(clusterId)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, impact.Volumes == 0)
+	tests.Assert(t, impact.Nodes == 3)
+	tests.Assert(t, impact.Devices == 6)
+	err = c.ClusterDeleteConfirm(clusterId, impact.Hash)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, teardowns == 6)
+	tests.Assert(t, detaches == 2)
+
+	clusters, err = c.ClusterList()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(clusters.Clusters) == 0)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{
+			BOLTDB_BUCKET_VOLUME,
+			BOLTDB_BUCKET_BRICK,
+			BOLTDB_BUCKET_DEVICE,
+			BOLTDB_BUCKET_NODE,
+		} {
+			tests.Assert(t, len(EntryKeys(tx, bucket)) == 0, bucket)
+		}
+
+		// Recorded as deleted by the request
+		list, err := TombstoneList(tx, "")
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 1+3+6+2, list)
+		for _, tombstone := range list {
+			tests.Assert(t, tombstone.DeletedBy == "127.0.0.1")
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestClusterDeletePolicyForce(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	// Nodes without devices
+	err := setupSampleDbWithTopology(app,
+		1, // clusters
+		2, // nodes_per_cluster
+		0, // devices_per_node,
+		0, // disksize)
+	)
+	tests.Assert(t, err == nil)
+	clusters, err := c.ClusterList()
+	tests.Assert(t, err == nil)
+	clusterId := clusters.Clusters[0]
+
+	err = c.ClusterPolicy(clusterId, &api.ClusterPolicyRequest{
+		DeletePolicy: api.ClusterDeletePolicyForce,
+	})
+	tests.Assert(t, err == nil)
+	impact, err := c.ClusterDeleteImpact(clusterId)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, impact.Nodes == 2)
+	tests.Assert(t, impact.Devices == 0)
+	err = c.ClusterDeleteConfirm(clusterId, impact.Hash)
+	tests.Assert(t, err == nil, err)
+
+	clusters, err = c.ClusterList()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(clusters.Clusters) == 0)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		tests.Assert(t, len(EntryKeys(tx, BOLTDB_BUCKET_NODE)) == 0)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestClusterDeletePolicyForceVolumes(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+	clusters, err := c.ClusterList()
+	tests.Assert(t, err == nil)
+	clusterId := clusters.Clusters[0]
+
+	volumeReq := &api.VolumeCreateRequest{}
+	volumeReq.Size = 10
+	volumeReq.Durability.Type = api.DurabilityReplicate
+	volumeReq.Durability.Replicate.Replica = 2
+	_, err = c.VolumeCreate(volumeReq)
+	tests.Assert(t, err == nil, err)
+
+	// Remove the devices, keeping the volume
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		tests.Assert(t, err == nil, err)
+		for _, nodeId := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			tests.Assert(t, err == nil, err)
+			node.Devices = sort.StringSlice{}
+			tests.Assert(t, node.Save(tx) == nil)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Force destroys the volume, then the nodes and the cluster
+	err = c.ClusterPolicy(clusterId, &api.ClusterPolicyRequest{
+		DeletePolicy: api.ClusterDeletePolicyForce,
+	})
+	tests.Assert(t, err == nil)
+	impact, err := c.ClusterDeleteImpact(clusterId)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, impact.Volumes == 1)
+	tests.Assert(t, impact.Devices == 0)
+	err = c.ClusterDeleteConfirm(clusterId, impact.Hash)
+	tests.Assert(t, err == nil, err)
+
+	clusters, err = c.ClusterList()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(clusters.Clusters) == 0)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		tests.Assert(t, len(EntryKeys(tx, BOLTDB_BUCKET_VOLUME)) == 0)
+		tests.Assert(t, len(EntryKeys(tx, BOLTDB_BUCKET_NODE)) == 0)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
@@ -33,10 +33,129 @@ type GlusterFSConfig struct {
 	KubeConfig kubeexec.KubeConfig `json:"kubeexec"`
 	Loglevel   string              `json:"loglevel"`
 
+	// format of the logs: text or json, one object per line
+	LogFormat string `json:"log_format"`
+
 	// advanced settings
 	BrickMaxSize int `json:"brick_max_size_gb"`
 	BrickMinSize int `json:"brick_min_size_gb"`
 	BrickMaxNum  int `json:"max_bricks_per_volume"`
+
+	// mount point of new bricks, where {vg}, {device} and {brick}
+	// are replaced by the volume group, device id and brick id.
+	// Bricks are mounted under /var/lib/heketi/mounts if not set.
+	BrickPathTemplate string `json:"brick_path_template"`
+
+	// size of the arbiter bricks of arbiter volumes
+	ArbiterBrickSize int `json:"arbiter_brick_size_gb"`
+
+	// storage class assigned when not specified
+	DefaultStorageClass string `json:"default_storage_class"`
+
+	// only list operations interrupted by a previous shutdown
+	// at startup instead of resolving them
+	PendingOpsListOnly bool `json:"pending_operations_list_only"`
+
+	// reject all changes.  The db is opened read-only if it exists.
+	ReadOnly bool `json:"read_only"`
+
+	// codec of the entries in new databases: gob or json.  Existing
+	// databases keep the codec they were created with.
+	DbCodec string `json:"db_codec"`
+
+	// hours between runs of fstrim on devices with trim enabled.
+	// Devices are only trimmed on request if not set.
+	TrimInterval int `json:"trim_interval_hours"`
+
+	// key used to encrypt the CHAP secrets of volumes.  Volumes
+	// cannot use CHAP authentication unless it is set.
+	CHAPSecretKey string `json:"chap_secret_key"`
+
+	// percent of free space at or below which devices are in
+	// the low, high and critical watermark bands
+	DeviceWatermarks struct {
+		Low      float64 `json:"low"`
+		High     float64 `json:"high"`
+		Critical float64 `json:"critical"`
+	} `json:"device_watermarks"`
+
+	// percent of the storage of each device kept free for the
+	// snapshots of the volumes
+	SnapshotReservePercent float64 `json:"snapshot_reserve_percent"`
+
+	// percent of the raw size of each device added taken by the
+	// filesystem and LVM metadata
+	DeviceFilesystemOverhead float64 `json:"device_filesystem_overhead_percent"`
+
+	// minutes between measurements of the storage latency of the
+	// nodes.  Nodes are not checked if not set.
+	HealthCheckInterval int `json:"health_check_interval_minutes"`
+
+	// minutes between fetches of the I/O of the bricks of the
+	// started volumes.  Not fetched if not set.
+	BrickIOStatsInterval int `json:"brick_iostats_interval_minutes"`
+
+	// positions in the allocation ring a device is moved back for
+	// each ms of storage latency of its node
+	AllocatorLatencyWeight float64 `json:"allocator_latency_weight"`
+
+	// seed of the positions in the allocation ring where the
+	// devices for new bricks are looked up, to reproduce placement.
+	// Seeded with the time if not set.
+	AllocationSeed int64 `json:"allocation_seed"`
+
+	// times the transaction of a request is run again when the
+	// entries it saves were changed concurrently
+	DbRetries int `json:"db_retries"`
+
+	// keep deleted clusters, nodes, devices and volumes in the db,
+	// and purge them once they are older than the retention in days
+	Tombstones             bool `json:"tombstones"`
+	TombstoneRetentionDays int  `json:"tombstone_retention_days"`
+
+	// seconds to wait at shutdown for asynchronous operations to
+	// complete, while readiness fails so that clients are drained
+	ShutdownDrainTimeout int `json:"shutdown_drain_timeout_seconds"`
+
+	// minutes completed asynchronous operations are listed under
+	// /queue before they are pruned
+	AsyncOperationRetention int `json:"async_operation_retention_minutes"`
+
+	// hours the Idempotency-Key of a create request is kept, during
+	// which repeats of the request return the same result
+	IdempotencyKeyRetention int `json:"idempotency_key_retention_hours"`
+
+	// record the changes requested through the API in the db, and
+	// purge the records once older than the retention in days.  In
+	// strict mode, requests whose record cannot be written are
+	// rejected.
+	AuditLog              bool `json:"audit_log"`
+	AuditLogStrict        bool `json:"audit_log_strict"`
+	AuditLogRetentionDays int  `json:"audit_log_retention_days"`
+
+	// asynchronous operations running at once, in total and by type
+	// of operation such as DeviceRemove.  Operations over the limits
+	// wait in a queue.  Unlimited if not set.
+	MaxConcurrentOperations int            `json:"max_concurrent_operations"`
+	OperationLimits         map[string]int `json:"operation_limits"`
+
+	// urls the events of volumes, nodes and devices are posted to
+	// once their operations complete, signed with the secret if set.
+	// Events which cannot be posted after the retries are appended
+	// to the dead letter file, or logged if it is not set.
+	Webhooks struct {
+		Urls           []string `json:"urls"`
+		Secret         string   `json:"secret"`
+		Retries        int      `json:"retries"`
+		DeadLetterFile string   `json:"dead_letter_file"`
+	} `json:"webhooks"`
+
+	// directory backups of the db are written to every interval,
+	// keeping the number of backups given.  No backups are written
+	// if not set.
+	BackupDir      string `json:"backup_dir"`
+	BackupInterval int    `json:"backup_interval_hours"`
+	BackupsKept    int    `json:"backups_kept"`
 }
 
 type ConfigFile struct {
@@ -44,13 +163,21 @@ type ConfigFile struct {
 }
 
 func loadConfiguration(configIo io.Reader) *GlusterFSConfig {
+	conf, err := parseConfiguration(configIo)
+	if err != nil {
+		logger.LogError("Unable to parse config file: %v\n",
+			err.Error())
+		return nil
+	}
+	return conf
+}
+
+func parseConfiguration(configIo io.Reader) (*GlusterFSConfig, error) {
 	configParser := json.NewDecoder(configIo)
 
 	var config ConfigFile
 	if err := configParser.Decode(&config); err != nil {
-		logger.LogError("Unable to parse config file: %v\n",
-			err.Error())
-		return nil
+		return nil, err
 	}
 
 	// Set environment variable to override configuration file
@@ -59,5 +186,5 @@ func loadConfiguration(configIo io.Reader) *GlusterFSConfig {
 		config.GlusterFS.Executor = env
 	}
 
-	return &config.GlusterFS
+	return &config.GlusterFS, nil
 }
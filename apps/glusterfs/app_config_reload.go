@@ -0,0 +1,412 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+var (
+	// Settings which can be changed by reloading the configuration,
+	// by their json keys.  The keys of nested settings are joined
+	// with dots.  Changing any other setting requires a restart.
+	configReloadable = map[string]bool{
+		"loglevel":                           true,
+		"log_format":                         true,
+		"brick_max_size_gb":                  true,
+		"brick_min_size_gb":                  true,
+		"max_bricks_per_volume":              true,
+		"arbiter_brick_size_gb":              true,
+		"default_storage_class":              true,
+		"db_retries":                         true,
+		"device_watermarks":                  true,
+		"snapshot_reserve_percent":           true,
+		"device_filesystem_overhead_percent": true,
+		"allocator_latency_weight":           true,
+		"tombstone_retention_days":           true,
+		"audit_log_retention_days":           true,
+		"backups_kept":                       true,
+		"shutdown_drain_timeout_seconds":     true,
+		"async_operation_retention_minutes":  true,
+		"idempotency_key_retention_hours":    true,
+		"max_concurrent_operations":          true,
+		"operation_limits":                   true,
+		"webhooks.urls":                      true,
+		"webhooks.secret":                    true,
+		"webhooks.retries":                   true,
+	}
+)
+
+// Returns all the problems of the configuration which would make the
+// server refuse or ignore settings, so that it is checked before it
+// is used
+func ValidateConfig(conf *GlusterFSConfig) error {
+	var problems []string
+	invalid := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	switch conf.Executor {
+	case "", "mock", "ssh", "kube", "kubernetes":
+	default:
+		invalid("Unknown executor %v", conf.Executor)
+	}
+	switch conf.Allocator {
+	case "", "mock", "simple":
+	default:
+		invalid("Unknown allocator %v", conf.Allocator)
+	}
+	if conf.Loglevel != "" {
+		if _, err := utils.ParseLogLevel(conf.Loglevel); err != nil {
+			invalid("%v", err)
+		}
+	}
+	if conf.LogFormat != "" {
+		if _, err := utils.ParseLogFormat(conf.LogFormat); err != nil {
+			invalid("%v", err)
+		}
+	}
+	if conf.BrickPathTemplate != "" {
+		if err := executors.ValidateBrickPathTemplate(conf.BrickPathTemplate); err != nil {
+			invalid("%v", err)
+		}
+	}
+	if _, err := NewCodec(conf.DbCodec); err != nil {
+		invalid("%v", err)
+	}
+	if !isValidStorageClass(conf.DefaultStorageClass) {
+		invalid("Unknown default storage class %v", conf.DefaultStorageClass)
+	}
+
+	for _, setting := range []struct {
+		name  string
+		value int
+	}{
+		{"brick_max_size_gb", conf.BrickMaxSize},
+		{"brick_min_size_gb", conf.BrickMinSize},
+		{"max_bricks_per_volume", conf.BrickMaxNum},
+		{"arbiter_brick_size_gb", conf.ArbiterBrickSize},
+		{"trim_interval_hours", conf.TrimInterval},
+		{"health_check_interval_minutes", conf.HealthCheckInterval},
+		{"brick_iostats_interval_minutes", conf.BrickIOStatsInterval},
+		{"db_retries", conf.DbRetries},
+		{"tombstone_retention_days", conf.TombstoneRetentionDays},
+		{"shutdown_drain_timeout_seconds", conf.ShutdownDrainTimeout},
+		{"async_operation_retention_minutes", conf.AsyncOperationRetention},
+		{"idempotency_key_retention_hours", conf.IdempotencyKeyRetention},
+		{"audit_log_retention_days", conf.AuditLogRetentionDays},
+		{"backup_interval_hours", conf.BackupInterval},
+		{"backups_kept", conf.BackupsKept},
+		{"webhooks.retries", conf.Webhooks.Retries},
+	} {
+		if setting.value < 0 {
+			invalid("%v must not be negative", setting.name)
+		}
+	}
+	if conf.BrickMinSize != 0 && conf.BrickMaxSize != 0 &&
+		conf.BrickMinSize > conf.BrickMaxSize {
+		invalid("brick_min_size_gb %v is larger than brick_max_size_gb %v",
+			conf.BrickMinSize, conf.BrickMaxSize)
+	}
+
+	if wm := conf.DeviceWatermarks; wm.Low != 0 || wm.High != 0 || wm.Critical != 0 {
+		if wm.Low < wm.High || wm.High < wm.Critical || wm.Low > 100 || wm.Critical < 0 {
+			invalid("Device watermarks low:%v high:%v critical:%v "+
+				"must be decreasing percents", wm.Low, wm.High, wm.Critical)
+		}
+	}
+	if conf.SnapshotReservePercent < 0 || conf.SnapshotReservePercent >= 100 {
+		invalid("Snapshot reserve %v%% must be a percent below 100",
+			conf.SnapshotReservePercent)
+	}
+	if conf.DeviceFilesystemOverhead < 0 || conf.DeviceFilesystemOverhead >= 100 {
+		invalid("Filesystem overhead %v%% must be a percent below 100",
+			conf.DeviceFilesystemOverhead)
+	}
+	err := validateOperationLimits(&api.AsyncOperationLimits{
+		Max:   conf.MaxConcurrentOperations,
+		Types: conf.OperationLimits,
+	})
+	if err != nil {
+		invalid("%v", err)
+	}
+
+	for _, hook := range conf.Webhooks.Urls {
+		u, err := url.Parse(hook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("Webhook %v is not an http or https url", hook)
+		}
+	}
+
+	if len(problems) != 0 {
+		return fmt.Errorf("Invalid configuration: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Parses and validates the configuration, without using it
+func CheckConfig(configIo io.Reader) error {
+	conf, err := parseConfiguration(configIo)
+	if err != nil {
+		return fmt.Errorf("Unable to parse config file: %v", err)
+	}
+	return ValidateConfig(conf)
+}
+
+// Returns the settings of the configuration by their json keys, with
+// the keys of nested settings joined with dots
+func flattenConfig(conf *GlusterFSConfig) (map[string]interface{}, error) {
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]interface{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]interface{})
+	var flatten func(prefix string, values map[string]interface{})
+	flatten = func(prefix string, values map[string]interface{}) {
+		for key, value := range values {
+			switch v := value.(type) {
+			case map[string]interface{}:
+				flatten(prefix+key+".", v)
+			case []interface{}:
+				// Empty lists are the same as no list
+				if len(v) != 0 {
+					settings[prefix+key] = v
+				}
+			default:
+				if v != nil {
+					settings[prefix+key] = v
+				}
+			}
+		}
+	}
+	flatten("", config)
+	return settings, nil
+}
+
+// Returns the sorted keys of the settings which differ, split by
+// whether they can be changed at runtime
+func configChanges(current, next *GlusterFSConfig) (reloadable, immutable []string, err error) {
+	before, err := flattenConfig(current)
+	if err != nil {
+		return nil, nil, err
+	}
+	after, err := flattenConfig(next)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed := make(map[string]bool)
+	for key, value := range before {
+		if !reflect.DeepEqual(value, after[key]) {
+			changed[key] = true
+		}
+	}
+	for key, value := range after {
+		if !reflect.DeepEqual(value, before[key]) {
+			changed[key] = true
+		}
+	}
+
+	for key := range changed {
+		if isConfigReloadable(key) {
+			reloadable = append(reloadable, key)
+		} else {
+			immutable = append(immutable, key)
+		}
+	}
+	sort.Strings(reloadable)
+	sort.Strings(immutable)
+	return reloadable, immutable, nil
+}
+
+// The setting or the setting it is nested in can be reloaded
+func isConfigReloadable(key string) bool {
+	for {
+		if configReloadable[key] {
+			return true
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			return false
+		}
+		key = key[:i]
+	}
+}
+
+// Returns the configuration in use.  It is replaced, not changed,
+// when reloaded.
+func (a *App) config() *GlusterFSConfig {
+	a.confLock.RLock()
+	defer a.confLock.RUnlock()
+
+	return a.conf
+}
+
+// Sets the file the configuration is reloaded from
+func (a *App) SetConfigFile(filename string) {
+	a.confLock.Lock()
+	defer a.confLock.Unlock()
+
+	a.configFile = filename
+}
+
+// Reads the configuration file again and applies it, as on SIGHUP
+func (a *App) ReloadConfigFile() ([]string, error) {
+	a.confLock.RLock()
+	filename := a.configFile
+	a.confLock.RUnlock()
+
+	if filename == "" {
+		return nil, fmt.Errorf("Configuration file unknown, unable to reload it")
+	}
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	return a.ReloadConfig(fp)
+}
+
+// Validates the configuration and applies the settings which changed,
+// returning their keys.  Nothing is applied if the configuration is
+// invalid or changes settings which require a restart.  Settings
+// removed from the configuration keep their current value, and the
+// limits of concurrent operations and the log level changed through
+// the API are only replaced when they change in the configuration.
+func (a *App) ReloadConfig(configIo io.Reader) ([]string, error) {
+	conf, err := parseConfiguration(configIo)
+	if err != nil {
+		logger.LogError("Unable to parse config file: %v", err)
+		return nil, err
+	}
+	err = ValidateConfig(conf)
+	if err != nil {
+		logger.LogError("Configuration not reloaded: %v", err)
+		return nil, err
+	}
+
+	a.confLock.Lock()
+	defer a.confLock.Unlock()
+
+	// As set at startup
+	if conf.Allocator == "" {
+		conf.Allocator = "simple"
+	}
+
+	changed, immutable, err := configChanges(a.conf, conf)
+	if err != nil {
+		return nil, err
+	}
+	if a.webhooks == nil && len(conf.Webhooks.Urls) != 0 {
+		immutable = append(immutable, "webhooks.urls")
+	}
+	if len(immutable) != 0 {
+		for _, key := range immutable {
+			logger.LogError("Setting %v cannot be changed without a restart", key)
+		}
+		return nil, fmt.Errorf("Configuration not reloaded, changing %v "+
+			"requires a restart", strings.Join(immutable, ", "))
+	}
+	if len(changed) == 0 {
+		logger.Info("Configuration reloaded without changes")
+		return changed, nil
+	}
+
+	isChanged := make(map[string]bool)
+	for _, key := range changed {
+		for key != "" {
+			isChanged[key] = true
+			if i := strings.LastIndex(key, "."); i >= 0 {
+				key = key[:i]
+			} else {
+				key = ""
+			}
+		}
+	}
+
+	a.conf = conf
+	if isChanged["loglevel"] && conf.Loglevel != "" {
+		// A level changed through the API is set back to the one
+		// of the configuration once it reverts
+		a.logLevels.lock.Lock()
+		if a.logLevels.revert != nil {
+			a.logLevels.revertGlusterFS = conf.Loglevel
+		} else {
+			a.changeLogLevels(conf.Loglevel, "")
+		}
+		a.logLevels.lock.Unlock()
+	}
+	if isChanged["log_format"] {
+		a.setLogFormat(conf.LogFormat)
+	}
+	a.setRuntimeSettings(conf)
+	if isChanged["max_concurrent_operations"] || isChanged["operation_limits"] {
+		a.setOperationLimits(conf)
+	}
+	if isChanged["webhooks"] && a.webhooks != nil {
+		a.webhooks.setTargets(conf.Webhooks.Urls, conf.Webhooks.Secret)
+	}
+
+	logger.Info("Configuration reloaded, changed %v", strings.Join(changed, ", "))
+	return changed, nil
+}
+
+func (a *App) ConfigReload(w http.ResponseWriter, r *http.Request) {
+	a.confLock.RLock()
+	filename := a.configFile
+	a.confLock.RUnlock()
+	if filename == "" {
+		http.Error(w, "Server was not started with a configuration file",
+			http.StatusConflict)
+		return
+	}
+
+	changed, err := a.ReloadConfigFile()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := &api.ConfigReloadResponse{Changed: changed}
+	if resp.Changed == nil {
+		resp.Changed = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		panic(err)
+	}
+}
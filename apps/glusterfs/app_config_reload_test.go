@@ -0,0 +1,233 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/tests"
+)
+
+func TestValidateConfig(t *testing.T) {
+	err := CheckConfig(bytes.NewBufferString(`{
+		"glusterfs" : {
+			"executor" : "mock",
+			"loglevel" : "info",
+			"brick_max_size_gb" : 100,
+			"webhooks" : { "urls" : ["https://example.com/hook"] }
+		}
+	}`))
+	tests.Assert(t, err == nil, err)
+
+	err = CheckConfig(bytes.NewBufferString(`{ "glusterfs" : `))
+	tests.Assert(t, err != nil)
+
+	// All the problems are reported
+	conf := &GlusterFSConfig{
+		Executor:               "telnet",
+		Loglevel:               "verbose",
+		BrickMaxSize:           10,
+		BrickMinSize:           20,
+		DbRetries:              -1,
+		SnapshotReservePercent: 100,
+		OperationLimits:        map[string]int{"VolumeCreate": -1},
+	}
+	conf.DeviceWatermarks.Low = 5
+	conf.DeviceWatermarks.High = 10
+	conf.Webhooks.Urls = []string{"ftp://example.com"}
+	err = ValidateConfig(conf)
+	tests.Assert(t, err != nil)
+	for _, problem := range []string{
+		"telnet",
+		"verbose",
+		"brick_min_size_gb",
+		"db_retries",
+		"Snapshot reserve",
+		"watermarks",
+		"VolumeCreate",
+		"ftp://example.com",
+	} {
+		tests.Assert(t, strings.Contains(err.Error(), problem), problem, err)
+	}
+}
+
+func TestAppReloadConfig(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	configfile := tests.Tempfile()
+	defer os.Remove(configfile)
+
+	// The settings are shared with the other tests
+	defer func(max uint64) {
+		BrickMaxSize = max
+	}(BrickMaxSize)
+	defer logger.SetLevel(logger.Level())
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	// Unknown configuration file
+	_, err := c.ConfigReload()
+	tests.Assert(t, err != nil)
+
+	config := func(settings string) string {
+		return `{
+			"glusterfs" : {
+				"executor" : "mock",
+				"allocator" : "simple",
+				"db" : "` + tmpfile + `"` + settings + `
+			}
+		}`
+	}
+	write := func(settings string) {
+		err := ioutil.WriteFile(configfile, []byte(config(settings)), 0600)
+		tests.Assert(t, err == nil, err)
+	}
+	app.SetConfigFile(configfile)
+
+	// Same configuration as at startup
+	write("")
+	resp, err := c.ConfigReload()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(resp.Changed) == 0, resp)
+
+	// Runtime settings are applied
+	write(`,
+		"loglevel" : "debug",
+		"brick_max_size_gb" : 500,
+		"operation_limits" : { "DeviceRemove" : 1 }`)
+	resp, err = c.ConfigReload()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, strings.Join(resp.Changed, ",") ==
+		"brick_max_size_gb,loglevel,operation_limits.DeviceRemove", resp)
+	tests.Assert(t, BrickMaxSize == 500*1024*1024)
+	tests.Assert(t, logger.Level().String() == "debug")
+	tests.Assert(t, app.operations.limiter.get().Types["DeviceRemove"] == 1)
+	tests.Assert(t, app.config().BrickMaxSize == 500)
+
+	// Changes to settings which require a restart reject the whole
+	// configuration
+	changed, err := app.ReloadConfig(bytes.NewBufferString(config(`,
+		"brick_max_size_gb" : 200,
+		"executor" : "ssh"`)))
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "executor"), err)
+	tests.Assert(t, changed == nil)
+	tests.Assert(t, BrickMaxSize == 500*1024*1024)
+
+	write(`,
+		"brick_max_size_gb" : 200,
+		"db" : "/tmp/other.db"`)
+	_, err = c.ConfigReload()
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "db"), err)
+	tests.Assert(t, BrickMaxSize == 500*1024*1024)
+	tests.Assert(t, app.config().BrickMaxSize == 500)
+
+	// Webhooks cannot be enabled without a restart
+	_, err = app.ReloadConfig(bytes.NewBufferString(config(`,
+		"webhooks" : { "urls" : ["http://localhost:1/hook"] }`)))
+	tests.Assert(t, err != nil)
+
+	// Invalid configurations are not applied
+	_, err = app.ReloadConfig(bytes.NewBufferString(config(`,
+		"brick_max_size_gb" : 200,
+		"brick_min_size_gb" : 300`)))
+	tests.Assert(t, err != nil)
+	tests.Assert(t, BrickMaxSize == 500*1024*1024)
+	_, err = app.ReloadConfig(bytes.NewBufferString(`{ "glusterfs" : `))
+	tests.Assert(t, err != nil)
+}
+
+func TestAppReloadConfigConcurrent(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// The settings are shared with the other tests
+	defer func(max uint64) {
+		BrickMaxSize = max
+	}(BrickMaxSize)
+
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	// Settings are read while the configuration is reloaded
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			tests.Assert(t, settings().BrickMaxSize != 0)
+		}
+	}()
+	for i := 1; i <= 10; i++ {
+		_, err := app.ReloadConfig(bytes.NewBufferString(`{
+			"glusterfs" : {
+				"executor" : "mock",
+				"allocator" : "simple",
+				"db" : "` + tmpfile + `",
+				"brick_max_size_gb" : ` + strconv.Itoa(100*i) + `
+			}
+		}`))
+		tests.Assert(t, err == nil, err)
+	}
+	<-done
+	tests.Assert(t, settings().BrickMaxSize == 1000*1024*1024)
+}
+
+func TestAppReloadConfigWebhooks(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	app.webhooks = newWebhooks([]string{"http://localhost:1/a"}, "", "")
+
+	changed, err := app.ReloadConfig(bytes.NewBufferString(`{
+		"glusterfs" : {
+			"executor" : "mock",
+			"allocator" : "simple",
+			"db" : "` + tmpfile + `",
+			"webhooks" : {
+				"urls" : ["http://localhost:1/b", "http://localhost:1/c"],
+				"secret" : "secret"
+			}
+		}
+	}`))
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, strings.Join(changed, ",") == "webhooks.secret,webhooks.urls", changed)
+
+	urls, secret := app.webhooks.targets()
+	tests.Assert(t, len(urls) == 2 && urls[0] == "http://localhost:1/b", urls)
+	tests.Assert(t, string(secret) == "secret")
+}
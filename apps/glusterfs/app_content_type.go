@@ -0,0 +1,56 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"mime"
+	"net/http"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+// Routes which take a file instead of JSON.  Their body is streamed
+// to the handler instead of being kept for the operation queue.
+var fileBodyRoutes = map[string]bool{
+	"BackupRestore": true,
+}
+
+// Rejects the POST and PUT requests with a body which is not sent as
+// JSON, before they reach the handler.  Requests without a body,
+// such as starting the profiling of a volume, need no Content-Type.
+func jsonContentHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method == "POST" || r.Method == "PUT") && r.ContentLength != 0 {
+			contentType := r.Header.Get("Content-Type")
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			if err != nil || mediaType != "application/json" {
+				message := "Missing Content-Type, expected application/json"
+				if contentType != "" {
+					message = "Unsupported Content-Type " + contentType +
+						", expected application/json"
+				}
+				writeApiError(w, r, &api.Error{
+					Code:    api.ErrorCodeUnsupportedMedia,
+					Message: message,
+					Status:  http.StatusUnsupportedMediaType,
+				})
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,87 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func TestJsonContentHandler(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	post := func(path, contentType string) *http.Response {
+		req, err := http.NewRequest("POST", ts.URL+path, bytes.NewBufferString("{}"))
+		tests.Assert(t, err == nil)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		r, err := http.DefaultClient.Do(req)
+		tests.Assert(t, err == nil)
+		return r
+	}
+
+	for _, path := range []string{"/clusters", "/v2/clusters"} {
+		for _, contentType := range []string{"", "text/plain", "application/jsonx"} {
+			r := post(path, contentType)
+			tests.Assert(t, r.StatusCode == http.StatusUnsupportedMediaType, path, contentType)
+
+			var e api.Error
+			err := utils.GetJsonFromResponse(r, &e)
+			tests.Assert(t, err == nil, err)
+			tests.Assert(t, e.Code == api.ErrorCodeUnsupportedMedia, e.Code)
+			tests.Assert(t, e.Status == http.StatusUnsupportedMediaType)
+			tests.Assert(t, e.Fields["route"] == "ClusterCreate", e.Fields)
+		}
+
+		r := post(path, "application/json; charset=UTF-8")
+		tests.Assert(t, r.StatusCode == http.StatusCreated, r.StatusCode)
+	}
+
+	// Requests without a body need no Content-Type
+	req, err := http.NewRequest("POST", ts.URL+"/clusters", nil)
+	tests.Assert(t, err == nil)
+	r, err := http.DefaultClient.Do(req)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode != http.StatusUnsupportedMediaType, r.StatusCode)
+
+	// Nor GET requests
+	req, err = http.NewRequest("GET", ts.URL+"/clusters", bytes.NewBufferString("{}"))
+	tests.Assert(t, err == nil)
+	r, err = http.DefaultClient.Do(req)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK, r.StatusCode)
+}
@@ -0,0 +1,267 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+const (
+	requestIdHeader = "X-Request-Id"
+
+	// Set on the request of an asynchronous operation once started,
+	// for its logs
+	operationIdHeader = "X-Heketi-Operation-Id"
+)
+
+var (
+	// Number of times a transaction of a handler is run again
+	// when it fails with a retryable error
+	DbRetries = 2
+
+	// Request ids of clients which are kept, others are replaced so
+	// that they are safe to log
+	validRequestId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+)
+
+// Transaction function of a handler.  Responses must be written to
+// the writer given to the function, which is discarded if the
+// transaction is retried.
+type dbHandlerFunc func(w http.ResponseWriter, tx *bolt.Tx) error
+
+// Errors which may not happen when the transaction is run again
+func isRetryableDbError(err error) bool {
+	return errors.Is(err, ErrStaleEntry) || errors.Is(err, bolt.ErrTimeout)
+}
+
+// Returns the id of the request, setting one if the client did not
+// or if the one it set is not valid
+func requestId(r *http.Request) string {
+	id := r.Header.Get(requestIdHeader)
+	if !validRequestId.MatchString(id) {
+		id = utils.GenUUID()[:8]
+		r.Header.Set(requestIdHeader, id)
+	}
+	return id
+}
+
+// Returns the name of the route of the request.  The route is no
+// longer known in asynchronous functions once the request is done.
+func requestName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil && route.GetName() != "" {
+		return route.GetName()
+	}
+	return r.Method + " " + r.URL.Path
+}
+
+// Returns the issuer of the token of the request, which is admin or
+// user, or the address of the client if authentication is disabled
+func requestUser(r *http.Request) string {
+	if token, ok := context.Get(r, "jwt").(*jwt.Token); ok {
+		if iss, ok := token.Claims["iss"].(string); ok {
+			return iss
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Buffers the response written by a transaction until it is known
+// whether the transaction is retried
+type dbResponseWriter struct {
+	w       http.ResponseWriter
+	header  http.Header
+	status  int
+	body    bytes.Buffer
+	written bool
+}
+
+func newDbResponseWriter(w http.ResponseWriter) *dbResponseWriter {
+	return &dbResponseWriter{
+		w:      w,
+		header: make(http.Header),
+	}
+}
+
+func (d *dbResponseWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *dbResponseWriter) WriteHeader(status int) {
+	if d.status == 0 {
+		d.status = status
+	}
+	d.written = true
+}
+
+func (d *dbResponseWriter) Write(b []byte) (int, error) {
+	d.written = true
+	return d.body.Write(b)
+}
+
+func (d *dbResponseWriter) flush() {
+	if !d.written || d.w == nil {
+		return
+	}
+	for key, values := range d.header {
+		d.w.Header()[key] = values
+	}
+	if d.status != 0 {
+		d.w.WriteHeader(d.status)
+	}
+	d.w.Write(d.body.Bytes())
+}
+
+type dbStats struct {
+	lock       sync.Mutex
+	operations map[string]*api.DbOperationStats
+}
+
+func newDbStats() *dbStats {
+	return &dbStats{
+		operations: make(map[string]*api.DbOperationStats),
+	}
+}
+
+func (s *dbStats) record(op string, elapsed time.Duration,
+	retries int, panicked bool, err error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stats, ok := s.operations[op]
+	if !ok {
+		stats = &api.DbOperationStats{}
+		s.operations[op] = stats
+	}
+
+	ms := uint64(elapsed / time.Millisecond)
+	stats.Count++
+	stats.Retries += uint64(retries)
+	stats.TotalMs += ms
+	if ms > stats.MaxMs {
+		stats.MaxMs = ms
+	}
+	if err != nil {
+		stats.Failures++
+	}
+	if panicked {
+		stats.Panics++
+	}
+}
+
+func (s *dbStats) response() *api.DbStatsResponse {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	resp := &api.DbStatsResponse{
+		Operations: make(map[string]api.DbOperationStats),
+	}
+	for op, stats := range s.operations {
+		resp.Operations[op] = *stats
+	}
+	return resp
+}
+
+// Runs fn in a read-write transaction for the request
+func (a *App) dbUpdate(w http.ResponseWriter, r *http.Request, fn dbHandlerFunc) error {
+	return a.dbTransaction(a.db.Update, w, r, fn)
+}
+
+// Runs fn in a read-only transaction for the request
+func (a *App) dbView(w http.ResponseWriter, r *http.Request, fn dbHandlerFunc) error {
+	return a.dbTransaction(a.db.View, w, r, fn)
+}
+
+// A panic in fn rolls back the transaction and is returned as an
+// error, with a 500 response unless fn already wrote one.  Retryable
+// errors run fn again in a new transaction up to DbRetries times.
+func (a *App) dbTransaction(run func(func(*bolt.Tx) error) error,
+	w http.ResponseWriter, r *http.Request, fn dbHandlerFunc) error {
+
+	op := requestName(r)
+	id := requestId(r)
+	user := requestUser(r)
+	start := time.Now()
+
+	var (
+		err      error
+		dw       *dbResponseWriter
+		panicked bool
+	)
+	for retries := 0; ; retries++ {
+		dw = newDbResponseWriter(w)
+		panicked = false
+		err = run(func(tx *bolt.Tx) (e error) {
+			defer setTxUser(tx, user)()
+			defer func() {
+				if p := recover(); p != nil {
+					logger.LogError("Panic in %v [request %v]: %v\n%s",
+						op, id, p, debug.Stack())
+					panicked = true
+					e = fmt.Errorf("Panic in %v: %v", op, p)
+				}
+			}()
+			return fn(dw, tx)
+		})
+
+		if isRetryableDbError(err) && retries < settings().DbRetries {
+			logger.Warning("Retrying %v [request %v]: %v", op, id, err)
+			continue
+		}
+
+		a.dbStats.record(op, time.Since(start), retries, panicked, err)
+		break
+	}
+
+	if panicked && !dw.written {
+		http.Error(dw, err.Error(), http.StatusInternalServerError)
+	}
+	dw.flush()
+
+	if err != nil {
+		logger.Warning("Failed %v [request %v]: %v", op, id, err)
+	}
+
+	return err
+}
+
+func (a *App) DbStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(a.dbStats.response()); err != nil {
+		panic(err)
+	}
+}
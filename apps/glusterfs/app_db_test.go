@@ -0,0 +1,148 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func TestDbUpdatePanic(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("POST", "/test", nil)
+	tests.Assert(t, err == nil)
+
+	// The changes before the panic are rolled back
+	err = app.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		cluster := NewClusterEntryFromRequest()
+		err := cluster.Save(tx)
+		tests.Assert(t, err == nil)
+		panic("bug")
+	})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, w.Code == http.StatusInternalServerError)
+	tests.Assert(t, r.Header.Get(requestIdHeader) != "")
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(clusters) == 0)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	stats := app.dbStats.response().Operations["POST /test"]
+	tests.Assert(t, stats.Count == 1)
+	tests.Assert(t, stats.Failures == 1)
+	tests.Assert(t, stats.Panics == 1)
+}
+
+func TestDbUpdateRetry(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	r, err := http.NewRequest("POST", "/test", nil)
+	tests.Assert(t, err == nil)
+
+	// Only the response of the last run is written
+	calls := 0
+	w := httptest.NewRecorder()
+	err = app.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		calls++
+		if calls == 1 {
+			http.Error(w, ErrStaleEntry.Error(), http.StatusInternalServerError)
+			return ErrStaleEntry
+		}
+		w.Write([]byte("ok"))
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, calls == 2)
+	tests.Assert(t, w.Code == http.StatusOK)
+	tests.Assert(t, w.Body.String() == "ok")
+
+	// Other errors are not retried
+	calls = 0
+	err = app.dbUpdate(httptest.NewRecorder(), r,
+		func(w http.ResponseWriter, tx *bolt.Tx) error {
+			calls++
+			return ErrNotFound
+		})
+	tests.Assert(t, err == ErrNotFound)
+	tests.Assert(t, calls == 1)
+
+	// Give up after the retries
+	calls = 0
+	w = httptest.NewRecorder()
+	err = app.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		calls++
+		http.Error(w, ErrStaleEntry.Error(), http.StatusInternalServerError)
+		return ErrStaleEntry
+	})
+	tests.Assert(t, err == ErrStaleEntry)
+	tests.Assert(t, calls == DbRetries+1)
+	tests.Assert(t, w.Code == http.StatusInternalServerError)
+
+	stats := app.dbStats.response().Operations["POST /test"]
+	tests.Assert(t, stats.Count == 3)
+	tests.Assert(t, stats.Failures == 2)
+	tests.Assert(t, stats.Retries == uint64(1+DbRetries))
+}
+
+func TestDbStats(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	r, err := http.Get(ts.URL + "/nodes/" + utils.GenUUID())
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusNotFound)
+
+	r, err = http.Get(ts.URL + "/admin/db/stats")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+
+	var msg api.DbStatsResponse
+	err = utils.GetJsonFromResponse(r, &msg)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, msg.Operations["NodeInfo"].Count == 1)
+	tests.Assert(t, msg.Operations["NodeInfo"].Failures == 1)
+}
@@ -0,0 +1,170 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+const (
+	redactedValue = "<redacted>"
+)
+
+var (
+	// Errors kept for the debug state
+	debugRecentErrors = 50
+
+	// Time to wait for each part of the debug state behind locks
+	debugStateTimeout = time.Second
+
+	// Largest stack dump returned
+	debugMaxStacks = 64 * 1024 * 1024
+)
+
+// Returns a snapshot of the state of the server for debugging, with
+// the stacks of all the goroutines if requested with ?stacks=true.
+// The parts of the state behind locks are read in the background and
+// reported as unavailable if they are not read in time, so that the
+// snapshot can be taken while those locks are stuck.
+func (a *App) DebugState(w http.ResponseWriter, r *http.Request) {
+	resp := &api.DebugStateResponse{
+		Time:           time.Now().Unix(),
+		Goroutines:     runtime.NumGoroutine(),
+		Operations:     []api.AsyncOperation{},
+		ExecutorQueues: make(map[string]int),
+		RecentErrors:   []api.DebugError{},
+		Unavailable:    make(map[string]string),
+	}
+
+	// Returns false if fn did not complete in time, in which case
+	// what it reads must not be used
+	collect := func(name string, fn func()) bool {
+		done := make(chan struct{})
+		go func() {
+			fn()
+			close(done)
+		}()
+		timer := time.NewTimer(debugStateTimeout)
+		defer timer.Stop()
+		select {
+		case <-done:
+			return true
+		case <-timer.C:
+			resp.Unavailable[name] = "Timed out waiting for its lock"
+			return false
+		}
+	}
+
+	var (
+		operations []api.AsyncOperation
+		queued     int
+	)
+	if collect("operations", func() {
+		operations = a.operations.list()
+		queued = a.operations.limiter.waiting()
+	}) {
+		resp.Operations = operations
+		resp.QueuedOperations = queued
+	}
+
+	if reporter, ok := a.executor.(executors.QueueReporter); ok {
+		resp.ExecutorQueues = reporter.QueueDepths()
+	}
+
+	var open, started int
+	if collect("db", func() {
+		stats := a.db.Stats()
+		open, started = stats.OpenTxN, stats.TxN
+	}) {
+		resp.Db.OpenTransactions = open
+		resp.Db.Transactions = started
+	}
+	resp.Db.ReadOnly = a.db.IsReadOnly()
+
+	var recent []api.DebugError
+	if collect("recent_errors", func() {
+		for _, e := range a.recentErrors.List() {
+			recent = append(recent, api.DebugError{
+				Time:    e.Time.Unix(),
+				Message: e.Message,
+			})
+		}
+	}) && recent != nil {
+		resp.RecentErrors = recent
+	}
+
+	config, err := redactedConfig(a.config())
+	if err != nil {
+		resp.Unavailable["config"] = err.Error()
+	} else {
+		resp.Config = config
+	}
+
+	if r.URL.Query().Get("stacks") == "true" {
+		resp.Stacks = goroutineStacks()
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		panic(err)
+	}
+}
+
+// Returns the configuration as JSON with the secrets set replaced
+func redactedConfig(conf *GlusterFSConfig) (map[string]interface{}, error) {
+	redact := func(value *string) {
+		if *value != "" {
+			*value = redactedValue
+		}
+	}
+
+	c := *conf
+	redact(&c.CHAPSecretKey)
+	redact(&c.KubeConfig.Password)
+	redact(&c.Webhooks.Secret)
+
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]interface{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Returns the stacks of all the goroutines, truncated if larger than
+// debugMaxStacks
+func goroutineStacks() string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= debugMaxStacks {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,90 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/tests"
+)
+
+func TestDebugState(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	app.conf.CHAPSecretKey = "chapsecret"
+	app.conf.Webhooks.Secret = "hooksecret"
+	logger.LogError("Debug state test %v", "error")
+
+	state, err := c.DebugState(false)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, state.Time != 0)
+	tests.Assert(t, state.Goroutines > 0)
+	tests.Assert(t, len(state.Unavailable) == 0, state.Unavailable)
+	tests.Assert(t, state.Stacks == "")
+	tests.Assert(t, state.Operations != nil)
+	tests.Assert(t, state.ExecutorQueues != nil)
+	tests.Assert(t, !state.Db.ReadOnly)
+	tests.Assert(t, state.Db.Transactions > 0)
+
+	last := state.RecentErrors[len(state.RecentErrors)-1]
+	tests.Assert(t, last.Message == "Debug state test error", last)
+	tests.Assert(t, last.Time != 0)
+
+	// Secrets are redacted
+	tests.Assert(t, state.Config["executor"] == "mock", state.Config)
+	tests.Assert(t, state.Config["chap_secret_key"] == redactedValue)
+	webhooks := state.Config["webhooks"].(map[string]interface{})
+	tests.Assert(t, webhooks["secret"] == redactedValue)
+	kube := state.Config["kubeexec"].(map[string]interface{})
+	tests.Assert(t, kube["password"] == "")
+	tests.Assert(t, app.conf.CHAPSecretKey == "chapsecret")
+
+	// Not waiting behind a lock which is held
+	defer tests.Patch(&debugStateTimeout, 50*time.Millisecond).Restore()
+	app.operations.lock.Lock()
+	state, err = c.DebugState(false)
+	app.operations.lock.Unlock()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, state.Unavailable["operations"] != "", state.Unavailable)
+	tests.Assert(t, len(state.Operations) == 0)
+	tests.Assert(t, state.Db.Transactions > 0)
+
+	// With the stacks of the goroutines
+	state, err = c.DebugState(true)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, strings.Contains(state.Stacks, "goroutine "), state.Stacks)
+	tests.Assert(t, strings.Contains(state.Stacks, "DebugState"))
+}
@@ -19,6 +19,7 @@ package glusterfs
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
@@ -46,7 +47,7 @@ func (a *App) DeviceAdd(w http.ResponseWriter, r *http.Request) {
 
 	// Check the node is in the db
 	var node *NodeEntry
-	err = a.db.Update(func(tx *bolt.Tx) error {
+	err = a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 		var err error
 		node, err = NewNodeEntryFromId(tx, msg.NodeId)
 		if err == ErrNotFound {
@@ -57,8 +58,27 @@ func (a *App) DeviceAdd(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Resolve the device path on the node so that symlinks to the
+	// same disk are detected as duplicates.  If it cannot be resolved,
+	// keep using the path provided.
+	path, err := a.requestExecutor(r).DeviceCanonicalPath(node.ManageHostName(), device.Info.Name)
+	if err != nil {
+		requestLogger(r).Warning("Unable to resolve path of device %v on node %v: %v",
+			device.Info.Name, msg.NodeId, err)
+	} else if path != device.Info.Name {
+		requestLogger(r).Info("Device %v resolved to %v", device.Info.Name, path)
+		device.Info.Name = path
+	}
+
+	err = a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 		// Register device
-		err = device.Register(tx)
+		err := device.Register(tx)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusConflict)
 			return err
@@ -71,17 +91,18 @@ func (a *App) DeviceAdd(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Log the devices are being added
-	logger.Info("Adding device %v to node %v", msg.Name, msg.NodeId)
+	requestLogger(r).Info("Adding device %v to node %v", device.Info.Name, msg.NodeId)
 
 	// Add device in an asynchronous function
-	a.asyncManager.AsyncHttpRedirectFunc(w, r, func() (seeOtherUrl string, e error) {
+	a.asyncHttpRedirectFunc(w, r, []string{device.Info.Id, msg.NodeId}, func(cancel *operationCancel) (seeOtherUrl string, e error) {
 
 		defer func() {
 			if e != nil {
 				a.db.Update(func(tx *bolt.Tx) error {
+					defer setTxUser(tx, requestUser(r))()
 					err := device.Deregister(tx)
 					if err != nil {
-						logger.Err(err)
+						requestLogger(r).Err(err)
 						return err
 					}
 
@@ -91,27 +112,30 @@ func (a *App) DeviceAdd(w http.ResponseWriter, r *http.Request) {
 		}()
 
 		// Setup device on node
-		info, err := a.executor.DeviceSetup(node.ManageHostName(),
+		cancel.step("setting up the device")
+		info, err := a.requestExecutor(r).DeviceSetup(node.ManageHostName(),
 			device.Info.Name, device.Info.Id)
 		if err != nil {
 			return "", err
 		}
 
 		// Create an entry for the device and set the size
-		device.StorageSet(info.Size)
+		device.StorageSetRaw(info.Size, settings().DeviceFilesystemOverhead)
 		device.SetExtentSize(info.ExtentSize)
 
 		// Setup garbage collector on error
 		defer func() {
 			if e != nil {
-				a.executor.DeviceTeardown(node.ManageHostName(),
+				a.requestExecutor(r).DeviceTeardown(node.ManageHostName(),
 					device.Info.Name,
 					device.Info.Id)
 			}
 		}()
 
 		// Save on db
+		cancel.step("saving the device")
 		err = a.db.Update(func(tx *bolt.Tx) error {
+			defer setTxUser(tx, requestUser(r))()
 
 			nodeEntry, err := NewNodeEntryFromId(tx, msg.NodeId)
 			if err != nil {
@@ -151,7 +175,8 @@ func (a *App) DeviceAdd(w http.ResponseWriter, r *http.Request) {
 			return "", err
 		}
 
-		logger.Info("Added device %v", msg.Name)
+		requestLogger(r).Info("Added device %v", device.Info.Name)
+		rebalanceClusterOnExpand(a.db, a.requestExecutor(r), node.Info.ClusterId, cancel)
 
 		// Done
 		// Returning a null string instructs the async manager
@@ -167,9 +192,20 @@ func (a *App) DeviceInfo(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	// The allocation history is only returned when requested
+	history := false
+	if value := r.URL.Query().Get("history"); value != "" {
+		var err error
+		history, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid history: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Get device information
 	var info *api.DeviceInfoResponse
-	err := a.db.View(func(tx *bolt.Tx) error {
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 		entry, err := NewDeviceEntryFromId(tx, id)
 		if err == ErrNotFound {
 			http.Error(w, "Id not found", http.StatusNotFound)
@@ -184,6 +220,9 @@ func (a *App) DeviceInfo(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return err
 		}
+		if history {
+			info.AllocationHistory = entry.allocationHistoryResponse()
+		}
 
 		return nil
 	})
@@ -211,7 +250,7 @@ func (a *App) DeviceDelete(w http.ResponseWriter, r *http.Request) {
 		node    *NodeEntry
 		cluster *ClusterEntry
 	)
-	err := a.db.View(func(tx *bolt.Tx) error {
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 		var err error
 		// Access device entry
 		device, err = NewDeviceEntryFromId(tx, id)
@@ -219,21 +258,27 @@ func (a *App) DeviceDelete(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return err
 		} else if err != nil {
-			logger.Err(err)
+			requestLogger(r).Err(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return err
 		}
 
-		// Check if we can delete the device
+		// Check if we can delete the device, and where its bricks
+		// can go if not
 		if !device.IsDeleteOk() {
-			http.Error(w, ErrConflict.Error(), http.StatusConflict)
+			targets, err := device.MigrationTargets(tx, a.allocator)
+			if err != nil {
+				requestLogger(r).LogError("Unable to find devices for the bricks "+
+					"of device %v: %v", device.Info.Id, err)
+			}
+			http.Error(w, deviceHasBricksMessage(device, targets), http.StatusConflict)
 			return ErrConflict
 		}
 
 		// Access node entry
 		node, err = NewNodeEntryFromId(tx, device.NodeId)
 		if err != nil {
-			logger.Err(err)
+			requestLogger(r).Err(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return err
 		}
@@ -251,11 +296,12 @@ func (a *App) DeviceDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete device
-	logger.Info("Deleting device %v on node %v", device.Info.Id, device.NodeId)
-	a.asyncManager.AsyncHttpRedirectFunc(w, r, func() (string, error) {
+	requestLogger(r).Info("Deleting device %v on node %v", device.Info.Id, device.NodeId)
+	a.asyncHttpRedirectFunc(w, r, []string{device.Info.Id}, func(cancel *operationCancel) (string, error) {
 
 		// Teardown device
-		err := a.executor.DeviceTeardown(node.ManageHostName(),
+		cancel.step("tearing down the device")
+		err := a.requestExecutor(r).DeviceTeardown(node.ManageHostName(),
 			device.Info.Name, device.Info.Id)
 		if err != nil {
 			return "", err
@@ -268,18 +314,20 @@ func (a *App) DeviceDelete(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Get info from db
+		cancel.step("removing the device")
 		err = a.db.Update(func(tx *bolt.Tx) error {
+			defer setTxUser(tx, requestUser(r))()
 
 			// Access node entry
 			node, err := NewNodeEntryFromId(tx, device.NodeId)
 			if err == ErrNotFound {
-				logger.Critical(
+				requestLogger(r).Critical(
 					"Node id %v pointed to by device %v, but it is not in the db",
 					device.NodeId,
 					device.Info.Id)
 				return err
 			} else if err != nil {
-				logger.Err(err)
+				requestLogger(r).Err(err)
 				return err
 			}
 
@@ -292,14 +340,14 @@ func (a *App) DeviceDelete(w http.ResponseWriter, r *http.Request) {
 			// Delete device from db
 			err = device.Delete(tx)
 			if err != nil {
-				logger.Err(err)
+				requestLogger(r).Err(err)
 				return err
 			}
 
 			// Deregister device
 			err = device.Deregister(tx)
 			if err != nil {
-				logger.Err(err)
+				requestLogger(r).Err(err)
 				return err
 			}
 
@@ -311,7 +359,7 @@ func (a *App) DeviceDelete(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Show that the key has been deleted
-		logger.Info("Deleted node [%s]", id)
+		requestLogger(r).Info("Deleted node [%s]", id)
 
 		return "", nil
 	})
@@ -332,7 +380,7 @@ func (a *App) DeviceSetState(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Set state
-	err = a.db.Update(func(tx *bolt.Tx) error {
+	err = a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 		device, err := NewDeviceEntryFromId(tx, id)
 		if err == ErrNotFound {
 			http.Error(w, "Id not found", http.StatusNotFound)
@@ -361,4 +409,93 @@ func (a *App) DeviceSetState(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+
+	if msg.State == api.EntryStateFailed {
+		a.webhookStateFailed(r, api.WebhookEventDeviceFailed, id)
+	}
+}
+
+func (a *App) DeviceTrim(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Get device entry
+	var device *DeviceEntry
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		var err error
+		device, err = NewDeviceEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Trim the bricks on the device
+	trimmed, err := device.Trim(a.db, a.requestExecutor(r))
+	if err != nil {
+		requestLogger(r).Err(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("Trimmed %v KB on device %v", trimmed, id)
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(api.DeviceTrimResponse{
+		Id:      id,
+		Trimmed: trimmed,
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) DeviceScrub(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Get device entry
+	var device *DeviceEntry
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		var err error
+		device, err = NewDeviceEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Scrub the volumes of the bricks on the device
+	result, err := device.Scrub(a.db, a.requestExecutor(r))
+	if err != nil {
+		requestLogger(r).Err(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("Scrubbed %v bricks on device %v", len(result.Bricks), id)
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		panic(err)
+	}
 }
@@ -18,10 +18,12 @@ package glusterfs
 
 import (
 	"bytes"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -432,6 +434,138 @@ func TestDeviceAddCleansUp(t *testing.T) {
 	}
 }
 
+func TestDeviceAddCanonicalPath(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Add Cluster then a Node on the cluster
+	cluster := NewClusterEntryFromRequest()
+	nodereq := &api.NodeAddRequest{
+		ClusterId: cluster.Info.Id,
+		Hostnames: api.HostAddresses{
+			Manage:  []string{"manage"},
+			Storage: []string{"storage"},
+		},
+		Zone: 99,
+	}
+	node := NewNodeEntryFromRequest(nodereq)
+	cluster.NodeAdd(node.Info.Id)
+
+	// Save information in the db
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		err := cluster.Save(tx)
+		if err != nil {
+			return err
+		}
+
+		return node.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	// Mock the node resolving a symlink to the disk
+	app.xo.MockDeviceCanonicalPath = func(host, device string) (string, error) {
+		tests.Assert(t, host == "manage")
+		switch device {
+		case "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4":
+			return "/dev/sdb", nil
+		case "/dev/unresolvable":
+			return "", ErrNotFound
+		}
+		return device, nil
+	}
+
+	// Add device using the symlink
+	request := []byte(`{
+        "node" : "` + node.Info.Id + `",
+        "name" : "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4"
+    }`)
+	r, err := http.Post(ts.URL+"/devices", "application/json", bytes.NewBuffer(request))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusAccepted)
+	location, err := r.Location()
+	tests.Assert(t, err == nil)
+
+	// Query queue until finished
+	for {
+		r, err = http.Get(location.String())
+		tests.Assert(t, err == nil)
+		if r.Header.Get("X-Pending") == "true" {
+			tests.Assert(t, r.StatusCode == http.StatusOK)
+			time.Sleep(time.Millisecond * 10)
+		} else {
+			tests.Assert(t, r.StatusCode == http.StatusNoContent)
+			break
+		}
+	}
+
+	// Adding the same disk by its canonical name should conflict
+	request = []byte(`{
+        "node" : "` + node.Info.Id + `",
+        "name" : "/dev/sdb"
+    }`)
+	r, err = http.Post(ts.URL+"/devices", "application/json", bytes.NewBuffer(request))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusConflict)
+
+	// A device which cannot be resolved keeps the path given
+	request = []byte(`{
+        "node" : "` + node.Info.Id + `",
+        "name" : "/dev/unresolvable"
+    }`)
+	r, err = http.Post(ts.URL+"/devices", "application/json", bytes.NewBuffer(request))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusAccepted)
+	location, err = r.Location()
+	tests.Assert(t, err == nil)
+
+	// Query queue until finished
+	for {
+		r, err = http.Get(location.String())
+		tests.Assert(t, err == nil)
+		if r.Header.Get("X-Pending") == "true" {
+			tests.Assert(t, r.StatusCode == http.StatusOK)
+			time.Sleep(time.Millisecond * 10)
+		} else {
+			tests.Assert(t, r.StatusCode == http.StatusNoContent)
+			break
+		}
+	}
+
+	// Check the names saved in the db
+	names := make([]string, 0)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, node.Info.Id)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range node.Devices {
+			device, err := NewDeviceEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			names = append(names, device.Info.Name)
+		}
+
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	sort.Strings(names)
+	tests.Assert(t, len(names) == 2)
+	tests.Assert(t, names[0] == "/dev/sdb")
+	tests.Assert(t, names[1] == "/dev/unresolvable")
+}
+
 func TestDeviceInfoIdNotFound(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -474,6 +608,7 @@ func TestDeviceInfo(t *testing.T) {
 	device.NodeId = "def"
 	device.StorageSet(10000)
 	device.StorageAllocate(1000)
+	device.AllocationAdd("vol1", "brick1", 1000)
 
 	// Save device in the db
 	err := app.db.Update(func(tx *bolt.Tx) error {
@@ -495,7 +630,22 @@ func TestDeviceInfo(t *testing.T) {
 	tests.Assert(t, info.Storage.Free == device.Info.Storage.Free)
 	tests.Assert(t, info.Storage.Used == device.Info.Storage.Used)
 	tests.Assert(t, info.Storage.Total == device.Info.Storage.Total)
+	tests.Assert(t, len(info.AllocationHistory) == 0)
+
+	// The allocations are only returned when requested
+	r, err = http.Get(ts.URL + "/devices/" + device.Info.Id + "?history=true")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+	info = api.DeviceInfoResponse{}
+	err = utils.GetJsonFromResponse(r, &info)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(info.AllocationHistory) == 1, info.AllocationHistory)
+	tests.Assert(t, info.AllocationHistory[0].VolumeId == "vol1")
+	tests.Assert(t, info.AllocationHistory[0].Size == 1000)
 
+	r, err = http.Get(ts.URL + "/devices/" + device.Info.Id + "?history=maybe")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusBadRequest)
 }
 
 func TestDeviceDeleteErrors(t *testing.T) {
@@ -685,3 +835,258 @@ func TestDeviceState(t *testing.T) {
 	tests.Assert(t, info.Storage.Used == device.Storage.Used)
 	tests.Assert(t, info.Storage.Total == device.Storage.Total)
 }
+
+func TestDeviceTrim(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Enable trim on all devices
+	var deviceIds []string
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		deviceIds, err = DeviceList(tx)
+		tests.Assert(t, err == nil)
+		for _, id := range deviceIds {
+			device, err := NewDeviceEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			device.Info.TrimEnabled = true
+			err = device.Save(tx)
+			tests.Assert(t, err == nil)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Bricks are mounted with discard
+	discard := true
+	createBrick := app.xo.MockBrickCreate
+	app.xo.MockBrickCreate = func(host string,
+		brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+		discard = discard && brick.Discard
+		return createBrick(host, brick)
+	}
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, discard)
+
+	// Unknown device
+	r, err := http.Post(ts.URL+"/devices/123456789/trim", "application/json", nil)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusNotFound)
+
+	// Trim the mount points of the bricks on the device
+	var mountpoints []string
+	app.xo.MockDeviceTrim = func(host string, m []string) (uint64, error) {
+		mountpoints = m
+		return 1024, nil
+	}
+	r, err = http.Post(ts.URL+"/devices/"+deviceIds[0]+"/trim", "application/json", nil)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+	tests.Assert(t, r.Header.Get("Content-Type") == "application/json; charset=UTF-8")
+
+	var trim api.DeviceTrimResponse
+	err = utils.GetJsonFromResponse(r, &trim)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, trim.Id == deviceIds[0])
+	tests.Assert(t, trim.Trimmed == 1024)
+	tests.Assert(t, len(mountpoints) > 0)
+}
+
+func TestDeviceScrub(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Both volumes have bricks on every device
+	started := createSampleVolumeEntry(100)
+	err = started.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+	stopped := createSampleVolumeEntry(100)
+	err = stopped.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+
+	var deviceId string
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		deviceIds, err := DeviceList(tx)
+		tests.Assert(t, err == nil)
+		deviceId = deviceIds[0]
+
+		v, err := NewVolumeEntryFromId(tx, stopped.Info.Id)
+		tests.Assert(t, err == nil)
+		v.SetStarted(false)
+		return v.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	// Unknown device
+	_, err = c.DeviceScrub("123456789")
+	tests.Assert(t, err != nil)
+
+	// Never scrubbed
+	info, err := c.DeviceInfo(deviceId)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.LastScrub == nil)
+
+	// Only the started volume is scrubbed, once
+	var scrubbed []string
+	app.xo.MockVolumeScrub = func(host, volume string) error {
+		scrubbed = append(scrubbed, volume)
+		return nil
+	}
+	result, err := c.DeviceScrub(deviceId)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, result.Id == deviceId)
+	tests.Assert(t, len(scrubbed) == 1, scrubbed)
+	tests.Assert(t, scrubbed[0] == started.Info.Name)
+	tests.Assert(t, len(result.Bricks) == len(info.Bricks))
+	for _, brick := range result.Bricks {
+		switch brick.VolumeId {
+		case started.Info.Id:
+			tests.Assert(t, brick.Status == api.ScrubStarted, brick)
+		case stopped.Info.Id:
+			tests.Assert(t, brick.Status == api.ScrubSkipped, brick)
+			tests.Assert(t, brick.Error == ErrVolumeStopped.Error())
+		default:
+			tests.Assert(t, false, "Unexpected volume of brick", brick)
+		}
+	}
+
+	info, err = c.DeviceInfo(deviceId)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.LastScrub != nil)
+	tests.Assert(t, info.LastScrub.Time == result.Time)
+	tests.Assert(t, len(info.LastScrub.Bricks) == len(result.Bricks))
+
+	// The failures are reported per brick
+	app.xo.MockVolumeScrub = func(host, volume string) error {
+		return errors.New("Bitrot is not enabled")
+	}
+	result, err = c.DeviceScrub(deviceId)
+	tests.Assert(t, err == nil, err)
+	for _, brick := range result.Bricks {
+		if brick.VolumeId == started.Info.Id {
+			tests.Assert(t, brick.Status == api.ScrubFailed, brick)
+			tests.Assert(t, brick.Error == "Bitrot is not enabled")
+		}
+	}
+}
+
+func TestDeviceDeleteWithBricks(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	volume, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+
+	// Blocked, with where the bricks can go
+	deviceId := volume.Bricks[0].DeviceId
+	err = c.DeviceDelete(deviceId)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), volume.Bricks[0].Id), err)
+	tests.Assert(t, strings.Contains(err.Error(),
+		"devices with the space to move them to: "), err)
+	tests.Assert(t, !strings.Contains(err.Error(), deviceId+" ("), err)
+}
+
+func TestDeviceAddFilesystemOverhead(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	cluster, err := c.ClusterCreate()
+	tests.Assert(t, err == nil, err)
+	nodeReq := &api.NodeAddRequest{
+		ClusterId: cluster.Id,
+		Zone:      1,
+	}
+	nodeReq.Hostnames.Manage = []string{"manage"}
+	nodeReq.Hostnames.Storage = []string{"storage"}
+	node, err := c.NodeAdd(nodeReq)
+	tests.Assert(t, err == nil, err)
+
+	// The mock devices have 500 GB
+	defer tests.Patch(&DeviceFilesystemOverhead, float64(2)).Restore()
+	deviceReq := &api.DeviceAddRequest{NodeId: node.Id}
+	deviceReq.Name = "/dev/sdb"
+	err = c.DeviceAdd(deviceReq)
+	tests.Assert(t, err == nil, err)
+
+	info, err := c.NodeInfo(node.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(info.DevicesInfo) == 1)
+	device := info.DevicesInfo[0]
+	tests.Assert(t, device.RawSize == 500*GB, device.RawSize)
+	tests.Assert(t, device.Storage.Total == 490*GB, device.Storage)
+	tests.Assert(t, device.Storage.Free == 490*GB, device.Storage)
+}
@@ -0,0 +1,249 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+var (
+	// Time Drain waits for asynchronous operations to complete
+	ShutdownDrainTimeout = 30 * time.Second
+
+	// Time between the checks of the operations still running
+	// while draining
+	drainPollInterval = 100 * time.Millisecond
+)
+
+type health struct {
+	lock sync.Mutex
+
+	// Operations interrupted by the last shutdown were all resolved
+	recovered bool
+
+	// The app is shutting down
+	draining bool
+
+	// Asynchronous operations, running or queued
+	asyncOps int
+}
+
+func (h *health) setRecovered() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.recovered = true
+}
+
+func (h *health) asyncStart() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.asyncOps++
+}
+
+func (h *health) asyncDone() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.asyncOps--
+}
+
+// Fails readiness, rejects the changes requested from now on, and
+// waits up to ShutdownDrainTimeout for the asynchronous operations
+// running to complete.  The queued operations are not started, they
+// are saved and resumed by the next startup.  Returns false if some
+// operations are still running, which are recorded as interrupted.
+// Those writing pending operation entries, as the creation and the
+// expansion of volumes, are then resolved by the next startup.
+func (a *App) Drain() bool {
+	a.health.lock.Lock()
+	a.health.draining = true
+	a.health.lock.Unlock()
+
+	if queued := a.operations.limiter.hold(); queued != 0 {
+		logger.Info("%v queued asynchronous operations resume on the next startup",
+			queued)
+	}
+
+	drainTimeout := settings().ShutdownDrainTimeout
+	timeout := time.After(drainTimeout)
+	for logged := false; ; logged = true {
+		running := a.runningOperations()
+		if running == 0 {
+			return true
+		}
+		if !logged {
+			logger.Info("Waiting for %v asynchronous operations", running)
+		}
+
+		select {
+		case <-timeout:
+			logger.Warning("Asynchronous operations still running after %v: %v",
+				drainTimeout, a.operations.interrupt())
+			return false
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+// Returns the number of asynchronous operations which are not waiting
+// in the queue
+func (a *App) runningOperations() int {
+	a.health.lock.Lock()
+	defer a.health.lock.Unlock()
+	return a.health.asyncOps - a.operations.limiter.waiting()
+}
+
+// Rejects the changes requested while shutting down
+func (a *App) drainFilter(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.checkNotDraining() != nil {
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// The db file can be opened
+func (a *App) checkDbFile() error {
+	fp, err := os.Open(a.db.Path())
+	if err != nil {
+		return err
+	}
+	return fp.Close()
+}
+
+// Transactions on the db succeed
+func (a *App) checkDb() error {
+	return a.db.View(func(tx *bolt.Tx) error {
+		if missing := dbMissingBuckets(tx); len(missing) != 0 {
+			return fmt.Errorf("Missing buckets %v", missing)
+		}
+		return nil
+	})
+}
+
+// The files the executor needs can be read
+func (a *App) checkExecutor() error {
+	conf := a.config()
+	var file string
+	switch conf.Executor {
+	case "ssh", "":
+		file = conf.SshConfig.PrivateKeyFile
+	case "kube", "kubernetes":
+		file = conf.KubeConfig.CertFile
+	}
+	if file == "" {
+		return nil
+	}
+
+	fp, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	return fp.Close()
+}
+
+func (a *App) checkRecovered() error {
+	a.health.lock.Lock()
+	defer a.health.lock.Unlock()
+	if !a.health.recovered {
+		return fmt.Errorf("Operations interrupted by the last shutdown are unresolved")
+	}
+	return nil
+}
+
+func (a *App) checkNotDraining() error {
+	a.health.lock.Lock()
+	defer a.health.lock.Unlock()
+	if a.health.draining {
+		return fmt.Errorf("Shutting down")
+	}
+	return nil
+}
+
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// Returned by checks which pass but report a condition
+type healthNotice string
+
+func (n healthNotice) Error() string {
+	return string(n)
+}
+
+// Runs the checks and responds 200 if all pass, or 503 otherwise
+func writeHealth(w http.ResponseWriter, checks []healthCheck) {
+	resp := &api.HealthResponse{
+		Healthy: true,
+		Checks:  make([]api.HealthCheck, 0, len(checks)),
+	}
+	for _, c := range checks {
+		result := api.HealthCheck{Name: c.name, Ok: true}
+		if err := c.check(); err != nil {
+			result.Message = err.Error()
+			if _, ok := err.(healthNotice); ok {
+				resp.Checks = append(resp.Checks, result)
+				continue
+			}
+			result.Ok = false
+			result.Message = err.Error()
+			resp.Healthy = false
+		}
+		resp.Checks = append(resp.Checks, result)
+	}
+
+	status := http.StatusOK
+	if !resp.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		panic(err)
+	}
+}
+
+// Liveness: the process answers and can open its db
+func (a *App) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, []healthCheck{
+		{"db_file", a.checkDbFile},
+	})
+}
+
+// Readiness: requests can be served.  Fails once shutdown starts, so
+// that clients go to another server before the app is closed.
+func (a *App) Readyz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, []healthCheck{
+		{"shutdown", a.checkNotDraining},
+		{"db", a.checkDb},
+		{"executor", a.checkExecutor},
+		{"pending_operations", a.checkRecovered},
+		{"maintenance", a.checkMaintenance},
+	})
+}
@@ -0,0 +1,458 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func getHealth(t *testing.T, url string) (int, map[string]api.HealthCheck) {
+	r, err := http.Get(url)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.Header.Get("Content-Type") == "application/json; charset=UTF-8")
+
+	var health api.HealthResponse
+	err = utils.GetJsonFromResponse(r, &health)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, health.Healthy == (r.StatusCode == http.StatusOK))
+
+	checks := make(map[string]api.HealthCheck)
+	for _, check := range health.Checks {
+		checks[check.Name] = check
+	}
+	return r.StatusCode, checks
+}
+
+func newHealthTestServer(app *App) *httptest.Server {
+	router := mux.NewRouter()
+	router.Methods("GET").Path("/healthz").HandlerFunc(app.Healthz)
+	router.Methods("GET").Path("/readyz").HandlerFunc(app.Readyz)
+	return httptest.NewServer(router)
+}
+
+func TestHealthz(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	ts := newHealthTestServer(app)
+	defer ts.Close()
+
+	status, checks := getHealth(t, ts.URL+"/healthz")
+	tests.Assert(t, status == http.StatusOK)
+	tests.Assert(t, checks["db_file"].Ok)
+
+	// The db file is gone
+	os.Remove(tmpfile)
+	status, checks = getHealth(t, ts.URL+"/healthz")
+	tests.Assert(t, status == http.StatusServiceUnavailable)
+	tests.Assert(t, !checks["db_file"].Ok)
+	tests.Assert(t, checks["db_file"].Message != "")
+}
+
+func TestReadyz(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	ts := newHealthTestServer(app)
+	defer ts.Close()
+
+	status, checks := getHealth(t, ts.URL+"/readyz")
+	tests.Assert(t, status == http.StatusOK, checks)
+	for _, name := range []string{"shutdown", "db", "executor", "pending_operations"} {
+		tests.Assert(t, checks[name].Ok, name)
+	}
+
+	// The key of the ssh executor cannot be read
+	app.conf.Executor = "ssh"
+	app.conf.SshConfig.PrivateKeyFile = tmpfile + ".missing"
+	status, checks = getHealth(t, ts.URL+"/readyz")
+	tests.Assert(t, status == http.StatusServiceUnavailable)
+	tests.Assert(t, !checks["executor"].Ok)
+	tests.Assert(t, checks["db"].Ok)
+}
+
+func TestReadyzPendingOperations(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// An operation is interrupted
+	app := NewTestApp(tmpfile)
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+	createSamplePendingVolumeCreate(t, app)
+	app.Close()
+
+	// Only listed on startup
+	app = NewApp(bytes.NewBuffer([]byte(`{
+		"glusterfs" : {
+			"executor" : "mock",
+			"allocator" : "simple",
+			"pending_operations_list_only" : true,
+			"db" : "` + tmpfile + `"
+		}
+	}`)))
+	tests.Assert(t, app != nil)
+
+	ts := newHealthTestServer(app)
+	status, checks := getHealth(t, ts.URL+"/readyz")
+	tests.Assert(t, status == http.StatusServiceUnavailable)
+	tests.Assert(t, !checks["pending_operations"].Ok)
+	tests.Assert(t, checks["db"].Ok)
+	ts.Close()
+	app.Close()
+
+	// Resolved on startup
+	app = NewTestApp(tmpfile)
+	defer app.Close()
+
+	ts = newHealthTestServer(app)
+	defer ts.Close()
+	status, checks = getHealth(t, ts.URL+"/readyz")
+	tests.Assert(t, status == http.StatusOK, checks)
+	tests.Assert(t, checks["pending_operations"].Ok)
+}
+
+func TestReadyzDrain(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	ts := newHealthTestServer(app)
+	defer ts.Close()
+
+	// An asynchronous operation is running
+	app.health.asyncStart()
+
+	drained := make(chan bool)
+	go func() {
+		drained <- app.Drain()
+	}()
+
+	// Readiness fails as soon as shutdown starts
+	for i := 0; ; i++ {
+		status, checks := getHealth(t, ts.URL+"/readyz")
+		if status == http.StatusServiceUnavailable {
+			tests.Assert(t, !checks["shutdown"].Ok)
+			break
+		}
+		tests.Assert(t, i < 100)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Shutdown waits for the operation
+	select {
+	case <-drained:
+		tests.Assert(t, false, "Drained with an operation running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	app.health.asyncDone()
+	tests.Assert(t, <-drained)
+
+	// The process is still alive
+	status, _ := getHealth(t, ts.URL+"/healthz")
+	tests.Assert(t, status == http.StatusOK)
+}
+
+func TestDrainTimeout(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&ShutdownDrainTimeout, 10*time.Millisecond).Restore()
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	app.health.asyncStart()
+	tests.Assert(t, !app.Drain())
+	app.health.asyncDone()
+}
+
+func TestDrainOperations(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&drainPollInterval, time.Millisecond).Restore()
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	// One volume is created at a time, and its creation is held
+	app.operations.limiter.set(api.AsyncOperationLimits{
+		Types: map[string]int{"VolumeCreate": 1},
+	})
+	release := make(chan bool)
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		<-release
+		return &executors.VolumeInfo{}, nil
+	}
+
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	for i := 0; i < 2; i++ {
+		go c.VolumeCreate(req)
+	}
+
+	var queued string
+	for i := 0; ; i++ {
+		list, err := c.AsyncOperationList()
+		tests.Assert(t, err == nil, err)
+		queued = ""
+		for _, op := range list.Operations {
+			if op.QueuePosition == 1 {
+				queued = op.Id
+			}
+		}
+		if len(list.Operations) == 2 && queued != "" {
+			break
+		}
+		tests.Assert(t, i < 100, list.Operations)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	drained := make(chan bool)
+	go func() {
+		drained <- app.Drain()
+	}()
+
+	// Changes are rejected, reads are not
+	for i := 0; ; i++ {
+		_, err = c.ClusterCreate()
+		if err != nil {
+			tests.Assert(t, strings.Contains(err.Error(), "shutting down"), err)
+			break
+		}
+		tests.Assert(t, i < 100)
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err = c.ClusterList()
+	tests.Assert(t, err == nil, err)
+
+	// Only the running operation is waited for
+	select {
+	case <-drained:
+		tests.Assert(t, false, "Drained with an operation running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	tests.Assert(t, <-drained)
+
+	// The queued operation was not started, it is resumed by the
+	// next startup
+	info, err := c.AsyncOperationInfo(queued)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.State == api.AsyncOperationPending)
+	tests.Assert(t, info.QueuePosition == 1)
+	started, _ := app.operations.ops[queued].cancel.status()
+	tests.Assert(t, !started)
+
+	// Do not leave it waiting
+	_, err = app.operations.cancelOperation(queued)
+	tests.Assert(t, err == nil, err)
+	for i := 0; app.runningOperations() != 0; i++ {
+		tests.Assert(t, i < 100)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDrainTimeoutInterrupts(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&ShutdownDrainTimeout, 10*time.Millisecond).Restore()
+	defer tests.Patch(&drainPollInterval, time.Millisecond).Restore()
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	release := make(chan bool)
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		<-release
+		return &executors.VolumeInfo{}, nil
+	}
+
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	go c.VolumeCreate(req)
+
+	var id string
+	for i := 0; ; i++ {
+		list, err := c.AsyncOperationList()
+		tests.Assert(t, err == nil, err)
+		if len(list.Operations) == 1 {
+			id = list.Operations[0].Id
+			if started, _ := app.operations.ops[id].cancel.status(); started {
+				break
+			}
+		}
+		tests.Assert(t, i < 100, list.Operations)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The operation is recorded as interrupted, and its pending
+	// operation entry is left for the next startup to resolve
+	tests.Assert(t, !app.Drain())
+	info, err := c.AsyncOperationInfo(id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.State == api.AsyncOperationPending)
+	tests.Assert(t, len(info.History) == 1, info.History)
+	tests.Assert(t, strings.Contains(info.History[0].Message, "shut down"), info.History)
+
+	close(release)
+	for i := 0; app.runningOperations() != 0; i++ {
+		tests.Assert(t, i < 100)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAppCloseWaitsForOperations(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&ShutdownDrainTimeout, 10*time.Millisecond).Restore()
+	defer tests.Patch(&drainPollInterval, time.Millisecond).Restore()
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	release := make(chan bool)
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		<-release
+		return &executors.VolumeInfo{}, nil
+	}
+
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	go c.VolumeCreate(req)
+
+	var id string
+	for i := 0; ; i++ {
+		list, err := c.AsyncOperationList()
+		tests.Assert(t, err == nil, err)
+		if len(list.Operations) == 1 {
+			id = list.Operations[0].Id
+			if started, _ := app.operations.ops[id].cancel.status(); started {
+				break
+			}
+		}
+		tests.Assert(t, i < 100, list.Operations)
+		time.Sleep(10 * time.Millisecond)
+	}
+	tests.Assert(t, !app.Drain())
+
+	// The db stays open until the operation completes
+	closed := make(chan bool)
+	go func() {
+		app.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		tests.Assert(t, false, "Closed with an operation running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+
+	// It completed, so it is not reported as interrupted
+	app = NewTestApp(tmpfile)
+	defer app.Close()
+	info, ok := app.operations.get(id)
+	tests.Assert(t, ok)
+	tests.Assert(t, info.State == api.AsyncOperationSucceeded, info)
+}
@@ -0,0 +1,473 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+const (
+	BOLTDB_BUCKET_IDEMPOTENCY_KEYS = "IDEMPOTENCYKEYS"
+
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "X-Idempotent-Replayed"
+	idempotencyKeyMax         = 255
+)
+
+var (
+	// Time the results of the requests with an idempotency key are
+	// kept for their repeats
+	IdempotencyKeyRetention = 24 * time.Hour
+
+	// Time between purges of expired idempotency keys
+	idempotencyKeyPurgeInterval = time.Hour
+)
+
+// Creates which are run once for all the repeats of a request with
+// the same Idempotency-Key header
+var idempotentRoutes = map[string]bool{
+	"VolumeCreate": true,
+	"NodeAdd":      true,
+	"DeviceAdd":    true,
+}
+
+// Result of the first request with an idempotency key, which its
+// repeats return
+type idempotencyRecord struct {
+	Route       string `json:"route"`
+	Digest      string `json:"digest"`
+	OperationId string `json:"operation"`
+	Created     int64  `json:"created"`
+
+	// Set once the operation has succeeded.  The records of the
+	// operations which fail are removed so that the request can be
+	// tried again.
+	Completed bool   `json:"completed,omitempty"`
+	Location  string `json:"location,omitempty"`
+}
+
+// Records of the requests with an idempotency key, saved in db and
+// keyed by the user and the key, so that the keys of different users
+// never match
+type idempotencyKeys struct {
+	db *bolt.DB
+
+	// Guards the maps below and the changes of the records of the
+	// operations
+	lock sync.Mutex
+
+	// Keys of the records of the operations not completed yet
+	ops map[string]string
+
+	// Held while a request with a key is handled, by user and key, so
+	// that a repeat waits until the first has started its operation
+	// and requests with other keys do not wait
+	keys map[string]*idempotencyKeyLock
+
+	// Outcomes of the operations completed while requests with a key
+	// were handled, for the operations which complete before their
+	// record is saved.  Cleared once no request with a key is handled.
+	early map[string]*api.AsyncOperationInfoResponse
+}
+
+type idempotencyKeyLock struct {
+	sync.Mutex
+	waiters int
+}
+
+func newIdempotencyKeys(db *bolt.DB) (*idempotencyKeys, error) {
+	k := &idempotencyKeys{
+		db:    db,
+		ops:   make(map[string]string),
+		keys:  make(map[string]*idempotencyKeyLock),
+		early: make(map[string]*api.AsyncOperationInfoResponse),
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_IDEMPOTENCY_KEYS))
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(key, value []byte) error {
+			var record idempotencyRecord
+			err := json.Unmarshal(value, &record)
+			if err != nil {
+				return fmt.Errorf("Unable to decode idempotency key: %v", err)
+			}
+			if !record.Completed {
+				k.ops[record.OperationId] = string(key)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func idempotencyKey(user, key string) string {
+	return user + "\n" + key
+}
+
+// Waits until no other request with the key of the user is handled.
+// Returns the function to call once the request is handled.
+func (k *idempotencyKeys) lockKey(user, key string) func() {
+	dbkey := idempotencyKey(user, key)
+
+	k.lock.Lock()
+	l, ok := k.keys[dbkey]
+	if !ok {
+		l = &idempotencyKeyLock{}
+		k.keys[dbkey] = l
+	}
+	l.waiters++
+	k.lock.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		k.lock.Lock()
+		defer k.lock.Unlock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(k.keys, dbkey)
+		}
+		if len(k.keys) == 0 && len(k.early) != 0 {
+			k.early = make(map[string]*api.AsyncOperationInfoResponse)
+		}
+	}
+}
+
+// Keys are printable ASCII, as any header value a client can send
+func validateIdempotencyKey(key string) error {
+	if len(key) > idempotencyKeyMax {
+		return fmt.Errorf("Idempotency key is longer than %v characters", idempotencyKeyMax)
+	}
+	for _, c := range key {
+		if c < ' ' || c > '~' {
+			return fmt.Errorf("Idempotency key %q has characters which are not printable", key)
+		}
+	}
+	return nil
+}
+
+// Returns the record of the key of the user, nil if there is none
+// or it has expired.  Must be called with the key locked.
+func (k *idempotencyKeys) get(user, key string) (*idempotencyRecord, error) {
+	var record *idempotencyRecord
+	err := k.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BOLTDB_BUCKET_IDEMPOTENCY_KEYS))
+		if b == nil {
+			return ErrDbAccess
+		}
+
+		value := b.Get([]byte(idempotencyKey(user, key)))
+		if value == nil {
+			return nil
+		}
+		record = &idempotencyRecord{}
+		return json.Unmarshal(value, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if record != nil &&
+		time.Since(time.Unix(record.Created, 0)) >= settings().IdempotencyKeyRetention {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// Saves the record of the key of the user.  Must be called with the
+// key locked.
+func (k *idempotencyKeys) put(user, key string, record *idempotencyRecord) error {
+	buffer, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	dbkey := idempotencyKey(user, key)
+	err = k.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BOLTDB_BUCKET_IDEMPOTENCY_KEYS))
+		if b == nil {
+			return ErrDbAccess
+		}
+		return b.Put([]byte(dbkey), buffer)
+	})
+	if err != nil {
+		return err
+	}
+
+	if record.Completed {
+		return nil
+	}
+	if info, ok := k.early[record.OperationId]; ok {
+		delete(k.early, record.OperationId)
+		return k.complete(dbkey, info)
+	}
+	k.ops[record.OperationId] = dbkey
+	return nil
+}
+
+// Records the outcome of the operation started by a request with a
+// key.  Repeats of a request whose operation failed create again.
+func (k *idempotencyKeys) done(id string, info *api.AsyncOperationInfoResponse) error {
+	if k == nil {
+		return nil
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	dbkey, ok := k.ops[id]
+	if !ok {
+		if len(k.keys) != 0 {
+			k.early[id] = info
+		}
+		return nil
+	}
+	delete(k.ops, id)
+
+	return k.complete(dbkey, info)
+}
+
+// Records the outcome of the operation of the record.  Must be called
+// with the lock held.
+func (k *idempotencyKeys) complete(dbkey string,
+	info *api.AsyncOperationInfoResponse) error {
+
+	return k.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BOLTDB_BUCKET_IDEMPOTENCY_KEYS))
+		if b == nil {
+			return ErrDbAccess
+		}
+
+		value := b.Get([]byte(dbkey))
+		if value == nil {
+			return nil
+		}
+		if info.State != api.AsyncOperationSucceeded {
+			return b.Delete([]byte(dbkey))
+		}
+
+		var record idempotencyRecord
+		err := json.Unmarshal(value, &record)
+		if err != nil {
+			return err
+		}
+		record.Completed = true
+		record.Location = info.Location
+		buffer, err := json.Marshal(&record)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(dbkey), buffer)
+	})
+}
+
+// Removes the records created before the time given.  Returns the
+// number of records removed.
+func (k *idempotencyKeys) purge(before time.Time) (int, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	purged := 0
+	err := k.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BOLTDB_BUCKET_IDEMPOTENCY_KEYS))
+		if b == nil {
+			return ErrDbAccess
+		}
+
+		expired := []idempotencyRecord{}
+		keys := [][]byte{}
+		err := b.ForEach(func(key, value []byte) error {
+			var record idempotencyRecord
+			err := json.Unmarshal(value, &record)
+			if err != nil {
+				return fmt.Errorf("Unable to decode idempotency key: %v", err)
+			}
+			if record.Created < before.Unix() {
+				expired = append(expired, record)
+				keys = append(keys, append([]byte{}, key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Deleting while iterating skips keys
+		for i, key := range keys {
+			err := b.Delete(key)
+			if err != nil {
+				return err
+			}
+			delete(k.ops, expired[i].OperationId)
+			purged++
+		}
+		return nil
+	})
+
+	return purged, err
+}
+
+// Purges the idempotency keys older than the retention
+func purgeIdempotencyKeysEvery(k *idempotencyKeys, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			retention := settings().IdempotencyKeyRetention
+			purged, err := k.purge(time.Now().Add(-retention))
+			if err != nil {
+				logger.LogError("Unable to purge idempotency keys: %v", err)
+			} else if purged > 0 {
+				logger.Info("Purged %v idempotency keys older than %v",
+					purged, retention)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Keeps the status of the response
+type idempotencyResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *idempotencyResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Returns the result of the first request with the same key, of the
+// same user, to its repeats instead of creating again.  While the
+// operation of the first request is known the repeats are redirected
+// to it, and once it is pruned to what it created.  Reusing a key
+// for a different request is refused.
+func (a *App) idempotencyFilter(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || a.idempotency == nil {
+			next(w, r)
+			return
+		}
+		if err := validateIdempotencyKey(key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		user := requestUser(r)
+		sum := sha256.Sum256(requestBody(r))
+		digest := hex.EncodeToString(sum[:])
+
+		unlock := a.idempotency.lockKey(user, key)
+		defer unlock()
+
+		record, err := a.idempotency.get(user, key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if record != nil {
+			if record.Route != requestName(r) || record.Digest != digest {
+				http.Error(w, "Idempotency key "+key+" was used for a different request",
+					http.StatusUnprocessableEntity)
+				return
+			}
+			if a.idempotentReplay(w, r, record) {
+				return
+			}
+		}
+
+		iw := &idempotencyResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next(iw, r)
+
+		location := strings.TrimPrefix(w.Header().Get("Location"), API_V2_ROUTE)
+		if iw.status != http.StatusAccepted || !strings.HasPrefix(location, ASYNC_ROUTE+"/") {
+			return
+		}
+		err = a.idempotency.put(user, key, &idempotencyRecord{
+			Route:       requestName(r),
+			Digest:      digest,
+			OperationId: strings.TrimPrefix(location, ASYNC_ROUTE+"/"),
+			Created:     time.Now().Unix(),
+		})
+		if err != nil {
+			requestLogger(r).LogError("Unable to save idempotency key: %v", err)
+		}
+	}
+}
+
+// Writes the result of the first request to its repeat.  Returns
+// false if it is lost, when the operation was interrupted and pruned.
+func (a *App) idempotentReplay(w http.ResponseWriter,
+	r *http.Request,
+	record *idempotencyRecord) bool {
+
+	switch {
+	case a.operations.replay(record.OperationId):
+		w.Header().Set(idempotencyReplayedHeader, "true")
+		http.Redirect(w, r, ASYNC_ROUTE+"/"+record.OperationId, http.StatusAccepted)
+	case !record.Completed:
+		return false
+	case record.Location != "":
+		w.Header().Set(idempotencyReplayedHeader, "true")
+		http.Redirect(w, r, record.Location, http.StatusSeeOther)
+	default:
+		w.Header().Set(idempotencyReplayedHeader, "true")
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return true
+}
+
+// Records the outcome of the operation for the repeats of its request
+func (a *App) idempotencyOperationDone(id string) {
+	if a.idempotency == nil {
+		return
+	}
+
+	info, ok := a.operations.get(id)
+	if !ok {
+		return
+	}
+	err := a.idempotency.done(id, info)
+	if err != nil {
+		logger.WithField("operation", id).LogError("Unable to save idempotency key: %v", err)
+	}
+}
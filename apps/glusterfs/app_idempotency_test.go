@@ -0,0 +1,289 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/tests"
+)
+
+func TestIdempotentVolumeCreate(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+
+	// Repeats return the volume created by the first request, also
+	// once its operation is pruned
+	volume, err := c.VolumeCreateIdempotent(req, "create-1")
+	tests.Assert(t, err == nil, err)
+	repeat, err := c.VolumeCreateIdempotent(req, "create-1")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, repeat.Id == volume.Id, repeat.Id, volume.Id)
+
+	app.operations.lock.Lock()
+	app.operations.ops = make(map[string]*asyncOperation)
+	app.operations.lock.Unlock()
+	repeat, err = c.VolumeCreateIdempotent(req, "create-1")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, repeat.Id == volume.Id, repeat.Id, volume.Id)
+
+	list, err := c.VolumeList()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(list.Volumes) == 1, list.Volumes)
+
+	// A key cannot be reused for a different request
+	req.Size = 20
+	_, err = c.VolumeCreateIdempotent(req, "create-1")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "different request"), err)
+
+	// Requests without a key or with another key create again
+	other, err := c.VolumeCreateIdempotent(req, "create-2")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, other.Id != volume.Id)
+	other, err = c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+	list, err = c.VolumeList()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(list.Volumes) == 3, list.Volumes)
+
+	// The key of a failed create can be used again
+	req.Size = 10 * 1024
+	_, err = c.VolumeCreateIdempotent(req, "create-3")
+	tests.Assert(t, err != nil)
+	record, err := app.idempotency.get("127.0.0.1", "create-3")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, record == nil, record)
+
+	// Invalid keys are refused
+	r, err := http.NewRequest("POST", ts.URL+"/volumes", bytes.NewBufferString(`{"size":10}`))
+	tests.Assert(t, err == nil)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(idempotencyKeyHeader, strings.Repeat("k", idempotencyKeyMax+1))
+	resp, err := http.DefaultClient.Do(r)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, resp.StatusCode == http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestIdempotentNodeAdd(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	cluster, err := c.ClusterCreate()
+	tests.Assert(t, err == nil, err)
+
+	req := &api.NodeAddRequest{
+		ClusterId: cluster.Id,
+		Zone:      1,
+	}
+	req.Hostnames.Manage = []string{"manage"}
+	req.Hostnames.Storage = []string{"storage"}
+	node, err := c.NodeAddIdempotent(req, "node-1")
+	tests.Assert(t, err == nil, err)
+	repeat, err := c.NodeAddIdempotent(req, "node-1")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, repeat.Id == node.Id)
+
+	// Devices are added once
+	device := &api.DeviceAddRequest{NodeId: node.Id}
+	device.Name = "/dev/sdb"
+	err = c.DeviceAddIdempotent(device, "device-1")
+	tests.Assert(t, err == nil, err)
+	app.operations.lock.Lock()
+	app.operations.ops = make(map[string]*asyncOperation)
+	app.operations.lock.Unlock()
+	err = c.DeviceAddIdempotent(device, "device-1")
+	tests.Assert(t, err == nil, err)
+
+	info, err := c.NodeInfo(node.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(info.DevicesInfo) == 1, info.DevicesInfo)
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	k := app.idempotency
+
+	created := time.Now().Add(-2 * time.Hour).Unix()
+	err := k.put("admin", "key", &idempotencyRecord{
+		Route:       "VolumeCreate",
+		OperationId: "op1",
+		Created:     created,
+	})
+	tests.Assert(t, err == nil, err)
+	err = k.put("user", "key", &idempotencyRecord{
+		Route:       "VolumeCreate",
+		OperationId: "op2",
+		Created:     time.Now().Unix(),
+	})
+	tests.Assert(t, err == nil, err)
+
+	// Keys are scoped by user
+	record, err := k.get("admin", "key")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, record != nil && record.OperationId == "op1", record)
+	record, err = k.get("user", "key")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, record != nil && record.OperationId == "op2", record)
+	record, err = k.get("other", "key")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, record == nil)
+
+	// The records are kept with the location once the operation
+	// succeeds
+	err = k.done("op2", &api.AsyncOperationInfoResponse{
+		AsyncOperation: api.AsyncOperation{
+			State:    api.AsyncOperationSucceeded,
+			Location: "/volumes/1",
+		},
+	})
+	tests.Assert(t, err == nil, err)
+	record, err = k.get("user", "key")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, record.Completed && record.Location == "/volumes/1", record)
+
+	// The records of operations pending at a restart are reloaded
+	k, err = newIdempotencyKeys(app.db)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, k.ops["op1"] != "" && k.ops["op2"] == "", k.ops)
+
+	// Expired records are ignored and purged
+	defer func(retention time.Duration) {
+		IdempotencyKeyRetention = retention
+	}(IdempotencyKeyRetention)
+	IdempotencyKeyRetention = time.Hour
+	record, err = k.get("admin", "key")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, record == nil)
+
+	purged, err := k.purge(time.Now().Add(-IdempotencyKeyRetention))
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, purged == 1, purged)
+	tests.Assert(t, len(k.ops) == 0, k.ops)
+	record, err = k.get("user", "key")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, record != nil)
+}
+
+func TestIdempotencyKeyLocks(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	k := app.idempotency
+
+	// Requests with other keys do not wait
+	unlock := k.lockKey("user", "a")
+	locked := make(chan func(), 1)
+	go func() {
+		locked <- k.lockKey("admin", "a")
+	}()
+	select {
+	case other := <-locked:
+		other()
+	case <-time.After(5 * time.Second):
+		tests.Assert(t, false, "Request with another key waited")
+	}
+
+	// Repeats wait for the first
+	go func() {
+		locked <- k.lockKey("user", "a")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	tests.Assert(t, len(locked) == 0)
+
+	// Operations completed before their record is saved are recorded
+	err := k.done("op1", &api.AsyncOperationInfoResponse{
+		AsyncOperation: api.AsyncOperation{
+			State:    api.AsyncOperationSucceeded,
+			Location: "/volumes/1",
+		},
+	})
+	tests.Assert(t, err == nil, err)
+	err = k.put("user", "a", &idempotencyRecord{
+		Route:       "VolumeCreate",
+		OperationId: "op1",
+		Created:     time.Now().Unix(),
+	})
+	tests.Assert(t, err == nil, err)
+	record, err := k.get("user", "a")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, record.Completed && record.Location == "/volumes/1", record)
+	tests.Assert(t, len(k.ops) == 0, k.ops)
+	unlock()
+
+	// The repeat then runs
+	select {
+	case repeat := <-locked:
+		repeat()
+	case <-time.After(5 * time.Second):
+		tests.Assert(t, false, "Repeat did not run")
+	}
+	tests.Assert(t, len(k.keys) == 0, k.keys)
+	tests.Assert(t, len(k.early) == 0, k.early)
+}
@@ -0,0 +1,72 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// A page of a list, set by the limit and continue parameters of
+// the request.  Without them the whole list is returned.
+type listPage struct {
+	limit int
+	after string
+}
+
+func newListPageFromRequest(r *http.Request) (*listPage, error) {
+	page := &listPage{}
+	query := r.URL.Query()
+
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		page.limit, err = strconv.Atoi(limit)
+		if err != nil || page.limit < 0 {
+			return nil, fmt.Errorf("Invalid limit %v", limit)
+		}
+	}
+
+	// The token is the last key of the previous page, which clients
+	// should not rely on
+	if token := query.Get("continue"); token != "" {
+		after, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || len(after) == 0 {
+			return nil, fmt.Errorf("Invalid continue token %v", token)
+		}
+		page.after = string(after)
+	}
+
+	return page, nil
+}
+
+// Returns the keys of the page from the bucket and the token of the
+// next page, empty if this is the last one
+func (p *listPage) keys(tx *bolt.Tx, bucket string,
+	skip func(key string) bool) ([]string, string, error) {
+	list, last, err := EntryKeysPage(tx, bucket, p.after, p.limit, skip)
+	if err != nil {
+		return nil, "", err
+	}
+	if last == "" {
+		return list, "", nil
+	}
+	return list, base64.RawURLEncoding.EncodeToString([]byte(last)), nil
+}
@@ -0,0 +1,171 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+// Levels of the loggers of the app and of the executor changed at
+// runtime, so that debug logging is turned on without restarting the
+// server, and the levels to set back when the timer fires
+type logLevels struct {
+	lock     sync.Mutex
+	executor string
+
+	revert          *time.Timer
+	revertAt        time.Time
+	revertGlusterFS string
+	revertExecutor  string
+}
+
+func (a *App) LogLevelGet(w http.ResponseWriter, r *http.Request) {
+	a.logLevels.lock.Lock()
+	info := a.logLevelInfo()
+	a.logLevels.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) LogLevelSet(w http.ResponseWriter, r *http.Request) {
+	var msg api.LogLevelRequest
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	if msg.GlusterFS == "" && msg.Executor == "" {
+		http.Error(w, "No log level given", http.StatusBadRequest)
+		return
+	}
+	for _, level := range []string{msg.GlusterFS, msg.Executor} {
+		if level == "" {
+			continue
+		}
+		if _, err := utils.ParseLogLevel(level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if msg.RevertAfterSec < 0 {
+		http.Error(w, "Revert time must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	a.logLevels.lock.Lock()
+	if a.logLevels.revert == nil {
+		a.logLevels.revertGlusterFS = logger.Level().String()
+		a.logLevels.revertExecutor = a.logLevels.executor
+	} else {
+		a.logLevels.revert.Stop()
+		a.logLevels.revert = nil
+		a.logLevels.revertAt = time.Time{}
+	}
+
+	a.changeLogLevels(msg.GlusterFS, msg.Executor)
+
+	// Set back the levels before the first change which was not
+	// reverted yet
+	if msg.RevertAfterSec > 0 {
+		after := time.Duration(msg.RevertAfterSec) * time.Second
+		a.logLevels.revertAt = time.Now().Add(after)
+		a.logLevels.revert = time.AfterFunc(after, a.revertLogLevels)
+		logger.Info("Log levels are set back in %v", after)
+	}
+
+	info := a.logLevelInfo()
+	a.logLevels.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+}
+
+// Must be called with the lock held
+func (a *App) logLevelInfo() *api.LogLevelInfo {
+	info := &api.LogLevelInfo{
+		GlusterFS: logger.Level().String(),
+		Executor:  a.logLevels.executor,
+	}
+	if a.logLevels.revert != nil {
+		info.RevertAt = a.logLevels.revertAt.Unix()
+	}
+	return info
+}
+
+// Sets the levels given which are not empty, and logs the changes.
+// The change is logged before the level is lowered and after it is
+// raised, so that it is written out at either level.  Must be called
+// with the lock held.
+func (a *App) changeLogLevels(glusterfs, executor string) {
+	if glusterfs != "" {
+		current := logger.Level()
+		level, _ := utils.ParseLogLevel(glusterfs)
+		if level < current {
+			logger.Info("Log level changed from %v to %v", current, level)
+			logger.SetLevel(level)
+		} else if level > current {
+			logger.SetLevel(level)
+			logger.Info("Log level changed from %v to %v", current, level)
+		}
+	}
+	if executor != "" && executor != a.logLevels.executor {
+		logger.Info("Log level of the executor changed from %v to %v",
+			a.logLevels.executor, executor)
+		a.executor.SetLogLevel(executor)
+		a.logLevels.executor = executor
+	}
+}
+
+func (a *App) revertLogLevels() {
+	a.logLevels.lock.Lock()
+	defer a.logLevels.lock.Unlock()
+
+	// Stopped or replaced by another change
+	if a.logLevels.revert == nil || time.Now().Before(a.logLevels.revertAt) {
+		return
+	}
+
+	logger.Info("Setting back the log levels changed at runtime")
+	a.changeLogLevels(a.logLevels.revertGlusterFS, a.logLevels.revertExecutor)
+	a.logLevels.revert = nil
+	a.logLevels.revertAt = time.Time{}
+}
+
+// Leaves the levels as they are
+func (a *App) stopLogLevelsRevert() {
+	a.logLevels.lock.Lock()
+	defer a.logLevels.lock.Unlock()
+
+	if a.logLevels.revert != nil {
+		a.logLevels.revert.Stop()
+		a.logLevels.revert = nil
+	}
+}
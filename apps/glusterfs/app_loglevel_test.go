@@ -0,0 +1,110 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/tests"
+)
+
+func TestLogLevel(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	// The level is shared with the other tests
+	defer logger.SetLevel(logger.Level())
+	initial := logger.Level().String()
+
+	var executorLevel string
+	app.xo.MockSetLogLevel = func(level string) {
+		executorLevel = level
+	}
+
+	info, err := c.LogLevel()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.GlusterFS == initial, info)
+	tests.Assert(t, info.Executor == "debug", info)
+	tests.Assert(t, info.RevertAt == 0)
+
+	// Bad requests
+	_, err = c.LogLevelSet(&api.LogLevelRequest{})
+	tests.Assert(t, err != nil)
+	_, err = c.LogLevelSet(&api.LogLevelRequest{GlusterFS: "verbose"})
+	tests.Assert(t, err != nil)
+	_, err = c.LogLevelSet(&api.LogLevelRequest{
+		GlusterFS:      "debug",
+		RevertAfterSec: -1,
+	})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, logger.Level().String() == initial)
+
+	// Takes effect at once
+	info, err = c.LogLevelSet(&api.LogLevelRequest{GlusterFS: "debug"})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.GlusterFS == "debug", info)
+	tests.Assert(t, info.Executor == "debug", info)
+	tests.Assert(t, logger.Level().String() == "debug")
+	tests.Assert(t, executorLevel == "")
+
+	// Set back to the levels before the first change
+	info, err = c.LogLevelSet(&api.LogLevelRequest{
+		GlusterFS:      "warning",
+		Executor:       "error",
+		RevertAfterSec: 1,
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.GlusterFS == "warning", info)
+	tests.Assert(t, info.Executor == "error", info)
+	tests.Assert(t, info.RevertAt >= time.Now().Unix(), info)
+	tests.Assert(t, executorLevel == "error")
+
+	info, err = c.LogLevelSet(&api.LogLevelRequest{
+		GlusterFS:      "info",
+		RevertAfterSec: 1,
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.GlusterFS == "info", info)
+
+	for i := 0; i < 50 && logger.Level().String() != "debug"; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	info, err = c.LogLevel()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.GlusterFS == "debug", info)
+	tests.Assert(t, info.Executor == "debug", info)
+	tests.Assert(t, info.RevertAt == 0, info)
+	tests.Assert(t, executorLevel == "debug")
+}
@@ -0,0 +1,237 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/lpabon/godbc"
+)
+
+const (
+	dbMaintenanceKey = "maintenance"
+)
+
+// Routes which run commands on the nodes without going through the
+// queue of operations, and are rejected in maintenance instead
+var maintenanceRejectedRoutes = map[string]bool{
+	"DeviceScrub":        true,
+	"DeviceTrim":         true,
+	"VolumeProfileInfo":  true,
+	"VolumeProfileStart": true,
+	"VolumeProfileStop":  true,
+}
+
+// Maintenance mode, in which asynchronous operations are queued but
+// not started, so that nothing changes the nodes while gluster is
+// upgraded.  Unlike read-only mode, requests are still served.  It is
+// saved in db so that it survives a restart.
+type maintenance struct {
+	lock   sync.Mutex
+	reason string
+	since  time.Time
+}
+
+// Saved in the metadata bucket while the server is in maintenance
+type dbMaintenanceState struct {
+	Reason string `json:"reason"`
+	Since  int64  `json:"since"`
+}
+
+// Returns nil unless the server was in maintenance
+func dbMaintenance(tx *bolt.Tx) (*dbMaintenanceState, error) {
+	godbc.Require(tx != nil)
+
+	b := tx.Bucket([]byte(BOLTDB_BUCKET_METADATA))
+	if b == nil {
+		return nil, nil
+	}
+	val := b.Get([]byte(dbMaintenanceKey))
+	if val == nil {
+		return nil, nil
+	}
+
+	var state dbMaintenanceState
+	err := json.Unmarshal(val, &state)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid maintenance state in database: %v", err)
+	}
+	return &state, nil
+}
+
+// Removes the state if nil
+func dbSetMaintenance(tx *bolt.Tx, state *dbMaintenanceState) error {
+	godbc.Require(tx != nil)
+
+	b, err := tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_METADATA))
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return b.Delete([]byte(dbMaintenanceKey))
+	}
+
+	val, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(dbMaintenanceKey), val)
+}
+
+// Pauses the queue of operations if the server was in maintenance
+// when it stopped
+func (a *App) loadMaintenance() error {
+	var state *dbMaintenanceState
+	err := a.db.View(func(tx *bolt.Tx) error {
+		var err error
+		state, err = dbMaintenance(tx)
+		return err
+	})
+	if err != nil || state == nil {
+		return err
+	}
+
+	a.maintenance.lock.Lock()
+	defer a.maintenance.lock.Unlock()
+
+	a.maintenance.reason = state.Reason
+	a.maintenance.since = time.Unix(state.Since, 0)
+	a.operations.limiter.pause()
+	logger.Warning("Server in maintenance since %v, operations are queued "+
+		"but not started: %v", a.maintenance.since, state.Reason)
+	return nil
+}
+
+func (a *App) maintenanceInfo() *api.MaintenanceInfo {
+	a.maintenance.lock.Lock()
+	defer a.maintenance.lock.Unlock()
+
+	info := &api.MaintenanceInfo{
+		Maintenance:      a.operations.limiter.isPaused(),
+		QueuedOperations: a.operations.limiter.waiting(),
+	}
+	if info.Maintenance {
+		info.Reason = a.maintenance.reason
+		info.Since = a.maintenance.since.Unix()
+	}
+	return info
+}
+
+// Passes with a message while the server is in maintenance, as it
+// still serves requests
+func (a *App) checkMaintenance() error {
+	info := a.maintenanceInfo()
+	if !info.Maintenance {
+		return nil
+	}
+
+	msg := fmt.Sprintf("In maintenance since %v, %v operations queued",
+		time.Unix(info.Since, 0).Format(time.RFC3339), info.QueuedOperations)
+	if info.Reason != "" {
+		msg += ": " + info.Reason
+	}
+	return healthNotice(msg)
+}
+
+// Rejects the requests which would change the nodes while in
+// maintenance, as they are not queued
+func (a *App) maintenanceFilter(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if info := a.maintenanceInfo(); info.Maintenance {
+			msg := "Server is in maintenance"
+			if info.Reason != "" {
+				msg += ": " + info.Reason
+			}
+			http.Error(w, msg, http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (a *App) MaintenanceGet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(a.maintenanceInfo()); err != nil {
+		panic(err)
+	}
+}
+
+// Pauses or resumes the queue of asynchronous operations.  Operations
+// already running complete.  Once resumed, the operations queued
+// start in the order they were requested.
+func (a *App) MaintenanceSet(w http.ResponseWriter, r *http.Request) {
+	var msg api.MaintenanceRequest
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	// The state could not be kept after a restart
+	if a.db.IsReadOnly() {
+		http.Error(w, "Database was opened read-only, "+
+			"restart the server to allow changes", http.StatusConflict)
+		return
+	}
+
+	a.maintenance.lock.Lock()
+	var state *dbMaintenanceState
+	since := time.Now()
+	if msg.Maintenance {
+		if a.operations.limiter.isPaused() {
+			since = a.maintenance.since
+		}
+		state = &dbMaintenanceState{
+			Reason: msg.Reason,
+			Since:  since.Unix(),
+		}
+	}
+	err = a.db.Update(func(tx *bolt.Tx) error {
+		return dbSetMaintenance(tx, state)
+	})
+	if err != nil {
+		a.maintenance.lock.Unlock()
+		logger.Err(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if msg.Maintenance {
+		a.maintenance.reason = msg.Reason
+		a.maintenance.since = since
+		a.operations.limiter.pause()
+		logger.Warning("%v started maintenance, operations are queued "+
+			"but not started: %v", requestUser(r), msg.Reason)
+	} else {
+		a.maintenance.reason = ""
+		a.maintenance.since = time.Time{}
+		a.operations.limiter.resume()
+		logger.Info("%v ended maintenance, starting the queued operations",
+			requestUser(r))
+	}
+	a.maintenance.lock.Unlock()
+
+	a.MaintenanceGet(w, r)
+}
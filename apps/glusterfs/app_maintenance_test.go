@@ -0,0 +1,211 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/tests"
+)
+
+func TestOperationLimiterPause(t *testing.T) {
+	l := newOperationLimiter()
+	l.set(api.AsyncOperationLimits{Max: 1})
+	l.pause()
+	tests.Assert(t, l.isPaused())
+
+	// Queued but not started
+	ids := []string{"a", "b", "c"}
+	waiters := make([]*operationWaiter, len(ids))
+	for i, id := range ids {
+		waiters[i] = l.enqueue(id, "VolumeCreate", newOperationCancel())
+		tests.Assert(t, waiters[i] != nil)
+	}
+	tests.Assert(t, l.waiting() == 3)
+	tests.Assert(t, l.total == 0)
+	for _, w := range waiters {
+		tests.Assert(t, len(w.ready) == 0)
+	}
+
+	// Started in the order they were queued once resumed
+	l.resume()
+	tests.Assert(t, !l.isPaused())
+	for i := range waiters {
+		_, ok := l.wait(waiters[i])
+		tests.Assert(t, ok)
+		for _, w := range waiters[i+1:] {
+			tests.Assert(t, len(w.ready) == 0)
+		}
+		l.release("VolumeCreate")
+	}
+	tests.Assert(t, l.waiting() == 0)
+	tests.Assert(t, l.total == 0)
+}
+
+func TestMaintenance(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+	ts := httptest.NewServer(router)
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	info, err := c.Maintenance()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, !info.Maintenance)
+
+	info, err = c.MaintenanceSet(&api.MaintenanceRequest{
+		Maintenance: true,
+		Reason:      "gluster upgrade",
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Maintenance)
+	tests.Assert(t, info.Reason == "gluster upgrade")
+	tests.Assert(t, info.Since != 0)
+	since := info.Since
+
+	// Still in maintenance after a restart
+	ts.Close()
+	app.Close()
+	app = NewTestApp(tmpfile)
+	defer app.Close()
+	router = mux.NewRouter()
+	app.SetRoutes(router)
+	ts = httptest.NewServer(router)
+	defer ts.Close()
+	c = client.NewClientNoAuth(ts.URL)
+
+	info, err = c.Maintenance()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Maintenance)
+	tests.Assert(t, info.Reason == "gluster upgrade")
+	tests.Assert(t, info.Since == since)
+
+	err = setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Operations are queued but not started
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	result := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := c.VolumeCreate(req)
+			result <- err
+		}()
+	}
+
+	var list *api.AsyncOperationListResponse
+	for i := 0; ; i++ {
+		list, err = c.AsyncOperationList()
+		tests.Assert(t, err == nil, err)
+		if len(list.Operations) == 3 && app.operations.limiter.waiting() == 3 {
+			break
+		}
+		tests.Assert(t, i < 100, list.Operations)
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, op := range list.Operations {
+		tests.Assert(t, op.State == api.AsyncOperationPending)
+		tests.Assert(t, op.QueueStatus == api.AsyncOperationQueuedMaintenance, op)
+	}
+	tests.Assert(t, len(result) == 0)
+
+	info, err = c.Maintenance()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.QueuedOperations == 3)
+
+	// Requests running commands on the nodes without being queued
+	// are rejected
+	var deviceId string
+	err = app.db.View(func(tx *bolt.Tx) error {
+		devices, err := DeviceList(tx)
+		tests.Assert(t, err == nil && len(devices) != 0)
+		deviceId = devices[0]
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	_, err = c.DeviceTrim(deviceId)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "maintenance: gluster upgrade"), err)
+	_, err = c.DeviceScrub(deviceId)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "maintenance"), err)
+	err = c.VolumeProfileStart("abc123")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "maintenance"), err)
+	_, err = c.VolumeProfileInfo("abc123")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "maintenance"), err)
+
+	// Still ready, with the maintenance in the detail
+	hs := newHealthTestServer(app)
+	defer hs.Close()
+	status, checks := getHealth(t, hs.URL+"/readyz")
+	tests.Assert(t, status == http.StatusOK, checks)
+	tests.Assert(t, checks["maintenance"].Ok)
+	tests.Assert(t, strings.Contains(checks["maintenance"].Message, "gluster upgrade"),
+		checks["maintenance"])
+
+	// The queued operations run once resumed
+	info, err = c.MaintenanceSet(&api.MaintenanceRequest{})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, !info.Maintenance)
+	tests.Assert(t, info.Reason == "")
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-result:
+			tests.Assert(t, err == nil, err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("Queued operations not started")
+		}
+	}
+
+	volumes, err := c.VolumeList()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(volumes.Volumes) == 3)
+	_, err = c.DeviceTrim(deviceId)
+	tests.Assert(t, err == nil, err)
+
+	status, checks = getHealth(t, hs.URL+"/readyz")
+	tests.Assert(t, status == http.StatusOK, checks)
+	tests.Assert(t, checks["maintenance"].Message == "")
+
+	// Invalid requests
+	r, err := http.Post(ts.URL+"/internal/maintenance", "application/json",
+		strings.NewReader("{"))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == 422)
+}
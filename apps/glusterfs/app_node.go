@@ -17,11 +17,16 @@
 package glusterfs
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
+	"github.com/heketi/heketi/executors"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/heketi/heketi/pkg/utils"
 )
@@ -53,6 +58,12 @@ func (a *App) NodeAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check storage class
+	if !isValidStorageClass(msg.StorageClass) {
+		http.Error(w, "Unknown storage class", http.StatusBadRequest)
+		return
+	}
+
 	// Check for correct values
 	for _, name := range append(msg.Hostnames.Manage, msg.Hostnames.Storage...) {
 		if name == "" {
@@ -67,12 +78,16 @@ func (a *App) NodeAdd(w http.ResponseWriter, r *http.Request) {
 	// Get cluster and peer node
 	var cluster *ClusterEntry
 	var peer_node *NodeEntry
-	err = a.db.Update(func(tx *bolt.Tx) error {
+	var clusterCreated bool
+	err = a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 		var err error
-		cluster, err = NewClusterEntryFromId(tx, msg.ClusterId)
+		cluster, clusterCreated, err = node.FindOrCreateCluster(tx, msg.CreateCluster)
 		if err == ErrNotFound {
 			http.Error(w, "Cluster id does not exist", http.StatusNotFound)
 			return err
+		} else if err == ErrInvalidId {
+			http.Error(w, "Invalid cluster id", http.StatusBadRequest)
+			return err
 		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return err
@@ -90,7 +105,7 @@ func (a *App) NodeAdd(w http.ResponseWriter, r *http.Request) {
 		if len(cluster.Info.Nodes) > 0 {
 			peer_node, err = cluster.NodeEntryFromClusterIndex(tx, 0)
 			if err != nil {
-				logger.Err(err)
+				requestLogger(r).Err(err)
 				return err
 			}
 		}
@@ -100,16 +115,27 @@ func (a *App) NodeAdd(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	msg.ClusterId = node.Info.ClusterId
 
 	// Add node
-	logger.Info("Adding node %v", node.ManageHostName())
-	a.asyncManager.AsyncHttpRedirectFunc(w, r, func() (seeother string, e error) {
+	requestLogger(r).Info("Adding node %v", node.ManageHostName())
+	a.asyncHttpRedirectFunc(w, r, []string{node.Info.Id, node.Info.ClusterId}, func(cancel *operationCancel) (seeother string, e error) {
 
 		// Cleanup in case of failure
 		defer func() {
 			if e != nil {
 				a.db.Update(func(tx *bolt.Tx) error {
+					defer setTxUser(tx, requestUser(r))()
 					node.Deregister(tx)
+
+					// Remove the cluster created for the node
+					// unless another node was added to it
+					if clusterCreated {
+						cluster, err := NewClusterEntryFromId(tx, msg.ClusterId)
+						if err == nil && len(cluster.Info.Nodes) == 0 {
+							cluster.Delete(tx)
+						}
+					}
 					return nil
 				})
 			}
@@ -118,38 +144,42 @@ func (a *App) NodeAdd(w http.ResponseWriter, r *http.Request) {
 		// Peer probe if there is at least one other node
 		// TODO: What happens if the peer_node is not responding.. we need to choose another.
 		if peer_node != nil {
-			err := a.executor.PeerProbe(peer_node.ManageHostName(), node.StorageHostName())
+			cancel.step("probing the node")
+			err := a.requestExecutor(r).PeerProbe(peer_node.ManageHostName(), node.StorageHostName())
 			if err != nil {
 				return "", err
 			}
 		}
 
+		// Record the versions of the node.  The node is added even if
+		// they cannot be read.
+		cancel.step("reading the versions of the node")
+		versions, err := a.requestExecutor(r).NodeVersions(node.ManageHostName())
+		if err != nil {
+			requestLogger(r).Warning("Unable to get the versions of node %v: %v",
+				node.ManageHostName(), err)
+		} else {
+			node.setVersions(versions)
+		}
+
 		// Add node entry into the db
+		cancel.step("saving the node")
 		err = a.db.Update(func(tx *bolt.Tx) error {
-			cluster, err := NewClusterEntryFromId(tx, msg.ClusterId)
-			if err == ErrNotFound {
-				http.Error(w, "Cluster id does not exist", http.StatusNotFound)
-				return err
-			} else if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return err
-			}
+			defer setTxUser(tx, requestUser(r))()
 
 			// Add node to cluster
-			cluster.NodeAdd(node.Info.Id)
-
-			// Save cluster
-			err = cluster.Save(tx)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+			_, err := node.SaveToCluster(tx)
+			if err == ErrNotFound {
+				return fmt.Errorf("Cluster id %v does not exist", node.Info.ClusterId)
+			} else if err != nil {
 				return err
 			}
 
-			// Save node
-			err = node.Save(tx)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return err
+			// A node running another version of GlusterFS is still
+			// added, as during rolling upgrades
+			warning, err := clusterVersionsWarning(tx, node.Info.ClusterId)
+			if err == nil && warning != "" {
+				logger.Warning("%v", warning)
 			}
 
 			return nil
@@ -158,11 +188,48 @@ func (a *App) NodeAdd(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			return "", err
 		}
-		logger.Info("Added node " + node.Info.Id)
+		requestLogger(r).Info("Added node " + node.Info.Id)
+		rebalanceClusterOnExpand(a.db, a.requestExecutor(r), node.Info.ClusterId, cancel)
 		return "/nodes/" + node.Info.Id, nil
 	})
 }
 
+func (a *App) NodeList(w http.ResponseWriter, r *http.Request) {
+
+	var list api.NodeListResponse
+
+	page, err := newListPageFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get the node ids of the page from the DB
+	err = a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		var err error
+
+		list.Nodes, list.Continue, err = page.keys(tx, BOLTDB_BUCKET_NODE, isNodeRegisterKey)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		requestLogger(r).Err(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Send list back
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		panic(err)
+	}
+}
+
 func (a *App) NodeInfo(w http.ResponseWriter, r *http.Request) {
 
 	// Get node id from URL
@@ -171,7 +238,7 @@ func (a *App) NodeInfo(w http.ResponseWriter, r *http.Request) {
 
 	// Get Node information
 	var info *api.NodeInfoResponse
-	err := a.db.View(func(tx *bolt.Tx) error {
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 		entry, err := NewNodeEntryFromId(tx, id)
 		if err == ErrNotFound {
 			http.Error(w, "Id not found", http.StatusNotFound)
@@ -202,17 +269,166 @@ func (a *App) NodeInfo(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Checks the memory and CPUs of the node and saves them with the node
+func (a *App) NodeMetrics(w http.ResponseWriter, r *http.Request) {
+
+	// Get node id from URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Get node entry
+	var node *NodeEntry
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		var err error
+		node, err = NewNodeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	metrics, err := a.requestExecutor(r).NodeMetrics(node.ManageHostName())
+	if err != nil {
+		requestLogger(r).Err(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	node.setMetrics(metrics)
+	brickOps := 0
+	if reporter, ok := a.executor.(executors.BrickOpsReporter); ok {
+		brickOps = reporter.BrickOpsInFlight(node.ManageHostName())
+	}
+
+	// Nothing can be saved in read-only mode
+	if !a.IsReadOnly() {
+		err = a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+			err := nodeSetMetrics(tx, id, metrics)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return err
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	resp := node.NewMetricsResponse()
+	resp.BrickOpsInFlight = brickOps
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		panic(err)
+	}
+}
+
+// Lists the volumes with bricks on the node, to check what taking it
+// down for maintenance impacts
+func (a *App) NodeVolumes(w http.ResponseWriter, r *http.Request) {
+
+	// Get node id from URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var volumes []api.NodeVolume
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		volumes, err = node.ImpactedVolumes(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(&api.NodeVolumesResponse{
+		Volumes: volumes,
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// Returns the node as stored in the db, encoded in base64, to debug
+// entries which cannot be decoded.  Like all routes other than
+// /volumes, it requires administrator access.
+func (a *App) NodeRaw(w http.ResponseWriter, r *http.Request) {
+
+	// Get node id from URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var raw []byte
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		var err error
+		raw, err = DumpRawNode(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(base64.StdEncoding.EncodeToString(raw)))
+}
+
+// Deletes the node, which must have no devices.  The force parameter
+// deletes the devices without bricks of the node with it, to recover
+// from devices already gone from the node.
 func (a *App) NodeDelete(w http.ResponseWriter, r *http.Request) {
 	// Get the id from the URL
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	force := false
+	if value := r.URL.Query().Get("force"); value != "" {
+		var err error
+		force, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid force: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Get node info
 	var (
 		peer_node, node *NodeEntry
 		cluster         *ClusterEntry
 	)
-	err := a.db.View(func(tx *bolt.Tx) error {
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 
 		// Access node entry
 		var err error
@@ -226,7 +442,16 @@ func (a *App) NodeDelete(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Check the node can be deleted
-		if !node.IsDeleteOk() {
+		if force {
+			err = node.checkForceDelete(tx)
+			if errors.Is(err, ErrConflict) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return err
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+		} else if !node.IsDeleteOk() {
 			http.Error(w, ErrConflict.Error(), http.StatusConflict)
 			return ErrConflict
 		}
@@ -248,7 +473,7 @@ func (a *App) NodeDelete(w http.ResponseWriter, r *http.Request) {
 			for index := range cluster.Info.Nodes {
 				peer_node, err = cluster.NodeEntryFromClusterIndex(tx, index)
 				if err != nil {
-					logger.Err(err)
+					requestLogger(r).Err(err)
 					return err
 				}
 
@@ -266,58 +491,87 @@ func (a *App) NodeDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete node asynchronously
-	logger.Info("Deleting node %v [%v]", node.ManageHostName(), node.Info.Id)
-	a.asyncManager.AsyncHttpRedirectFunc(w, r, func() (string, error) {
+	requestLogger(r).Info("Deleting node %v [%v]", node.ManageHostName(), node.Info.Id)
+	a.asyncHttpRedirectFunc(w, r, []string{node.Info.Id}, func(cancel *operationCancel) (string, error) {
 
 		// Remove from trusted pool
 		if peer_node != nil {
-			err := a.executor.PeerDetach(peer_node.ManageHostName(), node.StorageHostName())
+			cancel.step("detaching the node")
+			err := a.requestExecutor(r).PeerDetach(peer_node.ManageHostName(), node.StorageHostName())
 			if err != nil {
 				return "", err
 			}
 		}
 
-		// Remove from db
-		err = a.db.Update(func(tx *bolt.Tx) error {
-
-			// Get Cluster
-			cluster, err := NewClusterEntryFromId(tx, node.Info.ClusterId)
-			if err == ErrNotFound {
-				logger.Critical("Cluster id %v is expected be in db. Pointed to by node %v",
-					node.Info.ClusterId,
-					node.Info.Id)
-				return err
-			} else if err != nil {
-				logger.Err(err)
-				return err
-			}
-			cluster.NodeDelete(node.Info.Id)
-
-			// Save cluster
-			err = cluster.Save(tx)
+		// Remove the devices left from the allocator
+		for _, deviceId := range node.Devices {
+			device := NewDeviceEntry()
+			device.Info.Id = deviceId
+			err := a.allocator.RemoveDevice(cluster, node, device)
 			if err != nil {
-				logger.Err(err)
-				return err
+				return "", err
 			}
+		}
 
-			// Remove hostnames
-			node.Deregister(tx)
+		// Remove from db.  Forcing the delete saves the node without
+		// its devices, so reload it if it was saved since it was loaded.
+		cancel.step("removing the node")
+		err = EntryRetryStale(func(retry bool) error {
+			return a.db.Update(func(tx *bolt.Tx) error {
+				defer setTxUser(tx, requestUser(r))()
+
+				if retry {
+					latest, err := NewNodeEntryFromId(tx, node.Info.Id)
+					if err != nil {
+						return err
+					}
+					node = latest
+				}
 
-			// Delete node from db
-			err = node.Delete(tx)
-			if err != nil {
-				logger.Err(err)
-				return err
-			}
+				// Get Cluster
+				cluster, err := NewClusterEntryFromId(tx, node.Info.ClusterId)
+				if err == ErrNotFound {
+					requestLogger(r).Critical("Cluster id %v is expected be in db. Pointed to by node %v",
+						node.Info.ClusterId,
+						node.Info.Id)
+					return err
+				} else if err != nil {
+					requestLogger(r).Err(err)
+					return err
+				}
+				cluster.NodeDelete(node.Info.Id)
 
-			return nil
+				// Save cluster
+				err = cluster.Save(tx)
+				if err != nil {
+					requestLogger(r).Err(err)
+					return err
+				}
+
+				// Remove hostnames
+				node.Deregister(tx)
+
+				// Delete node from db
+				err = node.Delete(tx, force)
+				if err != nil {
+					requestLogger(r).Err(err)
+					return err
+				}
+
+				err = updateClusterMountHosts(tx, cluster)
+				if err != nil {
+					requestLogger(r).Err(err)
+					return err
+				}
 
+				return nil
+			})
 		})
 		if err != nil {
 			return "", err
 		}
 		// Show that the key has been deleted
-		logger.Info("Deleted node [%s]", id)
+		requestLogger(r).Info("Deleted node [%s]", id)
 
 		return "", nil
 
@@ -338,7 +592,7 @@ func (a *App) NodeSetState(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check state is supported
-	err = a.db.Update(func(tx *bolt.Tx) error {
+	err = a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 		node, err := NewNodeEntryFromId(tx, id)
 		if err == ErrNotFound {
 			http.Error(w, "Id not found", http.StatusNotFound)
@@ -367,4 +621,205 @@ func (a *App) NodeSetState(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+
+	if msg.State == api.EntryStateFailed {
+		a.webhookStateFailed(r, api.WebhookEventNodeFailed, id)
+	}
+}
+
+func (a *App) NodeSetOwner(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Unmarshal JSON
+	var msg api.NodeOwnerRequest
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	// Existing bricks are not moved, only new bricks are placed
+	// according to the owner
+	err = a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		node.Info.Owner = msg.Owner
+		err = node.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	requestLogger(r).Info("Owner of node %v set to '%v'", id, msg.Owner)
+}
+
+// Changes the settings of the node given in the request
+func (a *App) NodeUpdate(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Unmarshal JSON
+	var msg api.NodeUpdateRequest
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	var info *api.NodeInfoResponse
+	err = a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) (e error) {
+		node, err := NewNodeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		if msg.MaxConcurrentBrickOps != nil {
+			err = node.SetMaxConcurrentBrickOps(*msg.MaxConcurrentBrickOps)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return err
+			}
+		}
+
+		if msg.PreferredForNewBricks != nil {
+			previous := node.Info.PreferredForNewBricks
+			err = node.SetPreferredForNewBricks(tx, a.allocator, *msg.PreferredForNewBricks)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+			defer func() {
+				if e != nil {
+					node.SetPreferredForNewBricks(tx, a.allocator, previous)
+				}
+			}()
+		}
+
+		err = node.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		info, err = node.NewInfoReponse(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	requestLogger(r).Info("Node %v updated, preferred for new bricks: %v, "+
+		"concurrent brick operations: %v",
+		id, info.PreferredForNewBricks, info.MaxConcurrentBrickOps)
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) NodeSetZone(w http.ResponseWriter, r *http.Request) {
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Unmarshal JSON
+	var msg api.NodeZoneRequest
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+	if msg.Zone == 0 {
+		http.Error(w, "Zone cannot be zero or value is missing", http.StatusBadRequest)
+		return
+	}
+
+	// Existing bricks are not moved.  The volumes which may now have
+	// bricks of a set in the same zone are returned to be fixed.
+	var info *api.NodeZoneResponse
+	err = a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) (e error) {
+		node, err := NewNodeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		previous := node.Info.Zone
+		err = node.SetZone(tx, a.allocator, msg.Zone)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		defer func() {
+			if e != nil {
+				node.SetZone(tx, a.allocator, previous)
+			}
+		}()
+
+		volumes, err := node.ZoneConflicts(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		err = node.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		info = &api.NodeZoneResponse{
+			Id:           node.Info.Id,
+			Zone:         node.Info.Zone,
+			PreviousZone: previous,
+			Volumes:      volumes,
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	requestLogger(r).Info("Zone of node %v set to %v from %v", id, info.Zone, info.PreviousZone)
+	for _, volume := range info.Volumes {
+		requestLogger(r).Warning("Volume %v has bricks on node %v and on nodes %v of zone %v",
+			volume.Id, id, volume.Nodes, info.Zone)
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
 }
@@ -18,18 +18,23 @@ package glusterfs
 
 import (
 	"bytes"
+	"encoding/base64"
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
 	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/executors"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/heketi/heketi/pkg/utils"
 	"github.com/heketi/tests"
@@ -138,6 +143,25 @@ func TestNodeAddBadRequests(t *testing.T) {
 	tests.Assert(t, err == nil)
 	tests.Assert(t, strings.Contains(s, "Zone cannot be zero"))
 
+	// Make a request with an unknown storage class
+	request = []byte(`{
+		"cluster" : "123",
+		"hostnames" : {
+			"storage" : [ "storage.hostname.com" ],
+			"manage" : [ "manage.hostname.com"  ]
+		},
+		"zone" : 10,
+		"storage_class" : "tape"
+    }`)
+
+	// Check that it returns that the storage class is unknown
+	r, err = http.Post(ts.URL+"/nodes", "application/json", bytes.NewBuffer(request))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusBadRequest)
+	s, err = utils.GetStringFromResponse(r)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, strings.Contains(s, "Unknown storage class"))
+
 	// Make a request where the cluster id does not exist
 	request = []byte(`{
 		"cluster" : "123",
@@ -259,6 +283,180 @@ func TestPeerProbe(t *testing.T) {
 	tests.Assert(t, probe_called == true)
 }
 
+func TestNodeAddCreateCluster(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+	nodeReq := func(clusterId string, create bool) *api.NodeAddRequest {
+		req := &api.NodeAddRequest{
+			Zone:          1,
+			ClusterId:     clusterId,
+			CreateCluster: create,
+		}
+		req.Hostnames.Manage = []string{"manage" + utils.GenUUID()[:8]}
+		req.Hostnames.Storage = []string{"storage" + utils.GenUUID()[:8]}
+		return req
+	}
+
+	// Missing cluster
+	clusterId := utils.GenUUID()
+	_, err := c.NodeAdd(nodeReq(clusterId, false))
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Cluster id does not exist"), err)
+	_, err = c.NodeAdd(nodeReq("", false))
+	tests.Assert(t, err != nil)
+
+	// Created with the requested id
+	node, err := c.NodeAdd(nodeReq(clusterId, true))
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, node.ClusterId == clusterId)
+
+	// Found once it exists
+	node, err = c.NodeAdd(nodeReq(clusterId, true))
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, node.ClusterId == clusterId)
+
+	cluster, err := c.ClusterInfo(clusterId)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(cluster.Nodes) == 2)
+
+	// Created with a new id
+	node, err = c.NodeAdd(nodeReq("", true))
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, node.ClusterId != "" && node.ClusterId != clusterId)
+
+	list, err := c.ClusterList()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(list.Clusters) == 2)
+
+	// Invalid id
+	_, err = c.NodeAdd(nodeReq("mycluster", true))
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Invalid cluster id"), err)
+}
+
+func TestNodeAddConcurrent(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+	cluster, err := c.ClusterCreate()
+	tests.Assert(t, err == nil, err)
+
+	// Added at the same time to the same cluster
+	const nodes = 20
+	var wg sync.WaitGroup
+	ids := make(chan string, nodes)
+	for i := 0; i < nodes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := &api.NodeAddRequest{
+				Zone:      1,
+				ClusterId: cluster.Id,
+			}
+			req.Hostnames.Manage = []string{"manage" + utils.GenUUID()[:8]}
+			req.Hostnames.Storage = []string{"storage" + utils.GenUUID()[:8]}
+			node, err := c.NodeAdd(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids <- node.Id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	var added sort.StringSlice
+	for id := range ids {
+		added = append(added, id)
+	}
+	tests.Assert(t, len(added) == nodes)
+	added.Sort()
+
+	// None lost from the cluster
+	cluster, err = c.ClusterInfo(cluster.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, reflect.DeepEqual([]string(added), []string(cluster.Nodes)), cluster.Nodes)
+}
+
+func TestNodeAddVersions(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+	app.xo.MockNodeVersions = func(host string) (*executors.NodeVersions, error) {
+		if host == "broken" {
+			return nil, errors.New("gluster: command not found")
+		}
+		return &executors.NodeVersions{
+			GlusterVersion: "3.8.4",
+			KernelVersion:  "4.4.0",
+		}, nil
+	}
+
+	req := &api.NodeAddRequest{
+		Zone:          1,
+		CreateCluster: true,
+	}
+	req.Hostnames.Manage = []string{"manage"}
+	req.Hostnames.Storage = []string{"storage"}
+	node, err := c.NodeAdd(req)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, node.GlusterVersion == "3.8.4", node.GlusterVersion)
+	tests.Assert(t, node.KernelVersion == "4.4.0", node.KernelVersion)
+
+	// Saved with the node
+	info, err := c.NodeInfo(node.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.GlusterVersion == "3.8.4")
+	tests.Assert(t, info.KernelVersion == "4.4.0")
+	tests.Assert(t, info.Labels[api.NodeLabelZone] == "1", info.Labels)
+	tests.Assert(t, info.Labels[api.NodeLabelHostname] == "manage", info.Labels)
+	tests.Assert(t, info.Labels[api.NodeLabelCluster] == node.ClusterId, info.Labels)
+
+	// Added without versions when they cannot be read
+	req.ClusterId = node.ClusterId
+	req.Hostnames.Manage = []string{"broken"}
+	req.Hostnames.Storage = []string{"broken"}
+	node, err = c.NodeAdd(req)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, node.GlusterVersion == "")
+	tests.Assert(t, node.KernelVersion == "")
+}
+
 func TestNodeAddDelete(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -561,6 +759,60 @@ func TestNodeInfo(t *testing.T) {
 
 }
 
+func TestNodeRaw(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	node := createSampleNodeEntry()
+	node.DeviceAdd("abc")
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		return node.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	// Unknown node
+	r, err := http.Get(ts.URL + "/nodes/123456789/raw")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusNotFound)
+
+	// The stored bytes decode to the node
+	var raw []byte
+	err = app.db.View(func(tx *bolt.Tx) error {
+		var err error
+		raw, err = DumpRawNode(tx, node.Info.Id)
+		return err
+	})
+	tests.Assert(t, err == nil)
+
+	entry := NewNodeEntry()
+	err = entry.Unmarshal(raw)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, reflect.DeepEqual(entry, node))
+
+	// The endpoint returns the same bytes
+	r, err = http.Get(ts.URL + "/nodes/" + node.Info.Id + "/raw")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+	tests.Assert(t, r.Header.Get("Content-Type") == "text/plain; charset=UTF-8")
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	tests.Assert(t, err == nil)
+	decoded, err := base64.StdEncoding.DecodeString(string(body))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, bytes.Equal(decoded, raw))
+}
+
 func TestNodeDeleteErrors(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -598,6 +850,89 @@ func TestNodeDeleteErrors(t *testing.T) {
 
 }
 
+func TestNodeDeleteForce(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var node *NodeEntry
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		if err != nil {
+			return err
+		}
+		node, err = NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		return err
+	})
+	tests.Assert(t, err == nil)
+
+	// One of the devices is no longer in the db
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		device, err := NewDeviceEntryFromId(tx, node.Devices[0])
+		if err != nil {
+			return err
+		}
+		return EntryDelete(tx, device, device.Info.Id)
+	})
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+	err = c.NodeDelete(node.Info.Id)
+	tests.Assert(t, err != nil)
+
+	// The node is saved while it is detached
+	app.xo.MockPeerDetach = func(exec_host, newnode string) error {
+		return app.db.Update(func(tx *bolt.Tx) error {
+			n, err := NewNodeEntryFromId(tx, node.Info.Id)
+			if err != nil {
+				return err
+			}
+			n.Info.StorageLatencyMs = 1
+			return n.Save(tx)
+		})
+	}
+	err = c.NodeDeleteForce(node.Info.Id)
+	tests.Assert(t, err == nil, err)
+
+	_, err = c.NodeInfo(node.Info.Id)
+	tests.Assert(t, err != nil)
+	_, err = c.DeviceInfo(node.Devices[1])
+	tests.Assert(t, err != nil)
+
+	// No bricks are allocated on the devices of the node
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityDistributeOnly
+	for i := 0; i < 4; i++ {
+		volume, err := c.VolumeCreate(req)
+		tests.Assert(t, err == nil, err)
+		for _, brick := range volume.Bricks {
+			tests.Assert(t, brick.NodeId != node.Info.Id)
+		}
+	}
+}
+
 func TestNodePeerProbeFailure(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -1088,3 +1423,788 @@ func TestNodeState(t *testing.T) {
 	tests.Assert(t, mockAllocator.clustermap[cluster.Id][0] == device.Id)
 
 }
+
+func TestNodeSetOwner(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	// Create Cluster
+	cluster, err := c.ClusterCreate()
+	tests.Assert(t, err == nil)
+
+	// Create Node reserved for a tenant
+	nodeReq := &api.NodeAddRequest{
+		Zone:      1,
+		ClusterId: cluster.Id,
+		Owner:     "tenant-a",
+	}
+	nodeReq.Hostnames.Manage = sort.StringSlice{"manage.host"}
+	nodeReq.Hostnames.Storage = sort.StringSlice{"storage.host"}
+	node, err := c.NodeAdd(nodeReq)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, node.Owner == "tenant-a")
+
+	// Change the owner
+	err = c.NodeOwner(node.Id, &api.NodeOwnerRequest{Owner: "tenant-b"})
+	tests.Assert(t, err == nil)
+	node, err = c.NodeInfo(node.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, node.Owner == "tenant-b")
+
+	// Make it available to all tenants
+	err = c.NodeOwner(node.Id, &api.NodeOwnerRequest{})
+	tests.Assert(t, err == nil)
+	node, err = c.NodeInfo(node.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, node.Owner == "")
+
+	// Unknown node
+	err = c.NodeOwner("123456", &api.NodeOwnerRequest{Owner: "tenant-a"})
+	tests.Assert(t, err != nil)
+}
+
+func TestNodeSetZone(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Give each node its own zone
+	var nodes []*NodeEntry
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		if err != nil {
+			return err
+		}
+		for i, id := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			node.Info.Zone = i + 1
+			nodes = append(nodes, node)
+			err = node.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+
+	// Find two nodes with bricks of the volume
+	var withBricks []string
+	err = app.db.View(func(tx *bolt.Tx) error {
+		for _, id := range v.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			if !utils.SortedStringHas(withBricks, brick.Info.NodeId) {
+				withBricks = append(withBricks, brick.Info.NodeId)
+				sort.Strings(withBricks)
+			}
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(withBricks) >= 2)
+	moved, other := withBricks[0], withBricks[1]
+	var otherZone int
+	for _, node := range nodes {
+		if node.Info.Id == other {
+			otherZone = node.Info.Zone
+		}
+	}
+
+	// Moving to a zone of its own has no conflicts
+	zone, err := c.NodeZone(moved, &api.NodeZoneRequest{Zone: 99})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, zone.Id == moved)
+	tests.Assert(t, zone.Zone == 99)
+	tests.Assert(t, zone.PreviousZone != 99)
+	tests.Assert(t, len(zone.Volumes) == 0)
+
+	info, err := c.NodeInfo(moved)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.Zone == 99)
+
+	// Moving to the zone of another node with bricks of the volume
+	zone, err = c.NodeZone(moved, &api.NodeZoneRequest{Zone: otherZone})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, zone.Zone == otherZone)
+	tests.Assert(t, zone.PreviousZone == 99)
+	tests.Assert(t, len(zone.Volumes) == 1)
+	tests.Assert(t, zone.Volumes[0].Id == v.Info.Id)
+	tests.Assert(t, zone.Volumes[0].Name == v.Info.Name)
+	tests.Assert(t, reflect.DeepEqual(zone.Volumes[0].Nodes, []string{other}))
+	tests.Assert(t, len(zone.Volumes[0].Bricks) > 0)
+	tests.Assert(t, len(zone.Volumes[0].Remediation) > len(zone.Volumes[0].Bricks))
+
+	info, err = c.NodeInfo(moved)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.Zone == otherZone)
+
+	// Zone cannot be zero
+	_, err = c.NodeZone(moved, &api.NodeZoneRequest{})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Zone cannot be zero"))
+
+	// Unknown node
+	_, err = c.NodeZone("123456", &api.NodeZoneRequest{Zone: 1})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Id not found"))
+}
+
+func TestNodeList(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		2,      // clusters
+		3,      // nodes_per_cluster
+		1,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// The hostnames of the nodes are registered in their bucket
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		for _, clusterId := range clusters {
+			cluster, err := NewClusterEntryFromId(tx, clusterId)
+			if err != nil {
+				return err
+			}
+			for _, nodeId := range cluster.Info.Nodes {
+				node, err := NewNodeEntryFromId(tx, nodeId)
+				if err != nil {
+					return err
+				}
+				err = node.Register(tx)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	// Whole list
+	list, err := c.NodeList()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(list.Nodes) == 6)
+	tests.Assert(t, list.Continue == "")
+
+	// First page
+	page, err := c.NodeListPage(4, "")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, reflect.DeepEqual(page.Nodes, list.Nodes[:4]))
+	tests.Assert(t, page.Continue != "")
+
+	page, err = c.NodeListPage(4, page.Continue)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, reflect.DeepEqual(page.Nodes, list.Nodes[4:]))
+	tests.Assert(t, page.Continue == "")
+
+	// Iterate following the pages
+	ids := make([]string, 0)
+	err = c.NodeListEach(1, func(id string) error {
+		ids = append(ids, id)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, reflect.DeepEqual(ids, list.Nodes))
+
+	// Errors of the callback stop the iteration
+	stop := errors.New("stop")
+	count := 0
+	err = c.NodeListEach(2, func(id string) error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	tests.Assert(t, err == stop)
+	tests.Assert(t, count == 3)
+}
+
+func TestNodeMetrics(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		1,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var node *NodeEntry
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		if err != nil {
+			return err
+		}
+		node, err = NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		return err
+	})
+	tests.Assert(t, err == nil)
+
+	app.xo.MockNodeMetrics = func(host string) (*executors.NodeMetrics, error) {
+		tests.Assert(t, host == node.ManageHostName(), host)
+		return &executors.NodeMetrics{
+			MemoryTotal: 4096,
+			MemoryFree:  1024,
+			CpuCount:    2,
+		}, nil
+	}
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	// Unknown node
+	_, err = c.NodeMetrics("123")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Id not found"), err)
+
+	metrics, err := c.NodeMetrics(node.Info.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, metrics.MemoryTotal == 4096)
+	tests.Assert(t, metrics.MemoryFree == 1024)
+	tests.Assert(t, metrics.CpuCount == 2)
+
+	// The metrics are saved with the node
+	err = app.db.View(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, node.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, node.LastMetrics.MemoryTotal == 4096)
+		tests.Assert(t, node.LastMetrics.CpuCount == 2)
+		tests.Assert(t, node.LastMetrics.Time > 0)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// The node cannot be reached
+	app.xo.MockNodeMetrics = func(host string) (*executors.NodeMetrics, error) {
+		return nil, errors.New("ssh failed")
+	}
+	_, err = c.NodeMetrics(node.Info.Id)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "ssh failed"), err)
+}
+
+func TestNodeVolumes(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		2,      // nodes_per_cluster
+		2,      // devices_per_node,
+		100*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var node *NodeEntry
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		if err != nil {
+			return err
+		}
+		node, err = NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		return err
+	})
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	// Unknown node
+	_, err = c.NodeVolumes("123")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Id not found"), err)
+
+	// No volumes yet
+	volumes, err := c.NodeVolumes(node.Info.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(volumes.Volumes) == 0)
+
+	// One volume larger than a device, with bricks on both devices
+	// of the node, and a small one
+	req := &api.VolumeCreateRequest{}
+	req.Size = 150
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	large, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+	req.Size = 10
+	small, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+
+	onNode := func(info *api.VolumeInfoResponse) []string {
+		bricks := []string{}
+		for _, brick := range info.Bricks {
+			if brick.NodeId == node.Info.Id {
+				bricks = append(bricks, brick.Id)
+			}
+		}
+		return bricks
+	}
+
+	volumes, err = c.NodeVolumes(node.Info.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(volumes.Volumes) == 2, volumes.Volumes)
+	for _, v := range volumes.Volumes {
+		var info *api.VolumeInfoResponse
+		switch v.Id {
+		case large.Id:
+			info = large
+		case small.Id:
+			info = small
+		default:
+			tests.Assert(t, false, v.Id)
+		}
+		tests.Assert(t, v.Name == info.Name)
+		tests.Assert(t, v.Cluster == info.Cluster)
+		tests.Assert(t, v.Size == info.Size)
+		tests.Assert(t, v.Durability == api.DurabilityReplicate)
+		tests.Assert(t, len(v.Bricks) == len(onNode(info)), v.Bricks)
+	}
+
+	devices := make(map[string]bool)
+	for _, brick := range large.Bricks {
+		if brick.NodeId == node.Info.Id {
+			devices[brick.DeviceId] = true
+		}
+	}
+	tests.Assert(t, len(devices) == 2, devices)
+}
+
+func TestNodeUpdatePreferredForNewBricks(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var nodeId string
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		if err != nil {
+			return err
+		}
+		nodeId = cluster.Info.Nodes[2]
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Unknown node
+	preferred := true
+	_, err = c.NodeUpdate("abc123", &api.NodeUpdateRequest{
+		PreferredForNewBricks: &preferred,
+	})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Id not found"), err)
+
+	// Not JSON
+	req, err := http.NewRequest("PUT", ts.URL+"/nodes/"+nodeId,
+		bytes.NewBufferString("{"))
+	tests.Assert(t, err == nil)
+	req.Header.Set("Content-Type", "application/json")
+	r, err := http.DefaultClient.Do(req)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == 422)
+
+	info, err := c.NodeUpdate(nodeId, &api.NodeUpdateRequest{
+		PreferredForNewBricks: &preferred,
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.PreferredForNewBricks)
+
+	// The setting is kept when not given
+	info, err = c.NodeUpdate(nodeId, &api.NodeUpdateRequest{})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.PreferredForNewBricks)
+	info, err = c.NodeInfo(nodeId)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.PreferredForNewBricks)
+
+	volumeNodes := func(id string) map[string]bool {
+		nodes := make(map[string]bool)
+		volume, err := c.VolumeInfo(id)
+		tests.Assert(t, err == nil)
+		err = app.db.View(func(tx *bolt.Tx) error {
+			for _, b := range volume.Bricks {
+				brick, err := NewBrickEntryFromId(tx, b.Id)
+				if err != nil {
+					return err
+				}
+				nodes[brick.Info.NodeId] = true
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil)
+		return nodes
+	}
+
+	// New bricks go to the preferred node while it has space
+	request := &api.VolumeCreateRequest{}
+	request.Size = 100
+	request.Durability.Type = api.DurabilityDistributeOnly
+	volume, err := c.VolumeCreate(request)
+	tests.Assert(t, err == nil, err)
+	nodes := volumeNodes(volume.Id)
+	tests.Assert(t, len(nodes) == 1, nodes)
+	tests.Assert(t, nodes[nodeId])
+
+	// Then to the other nodes
+	request.Size = 1500
+	volume, err = c.VolumeCreate(request)
+	tests.Assert(t, err == nil, err)
+	nodes = volumeNodes(volume.Id)
+	tests.Assert(t, len(nodes) > 1, nodes)
+
+	// No longer preferred
+	preferred = false
+	info, err = c.NodeUpdate(nodeId, &api.NodeUpdateRequest{
+		PreferredForNewBricks: &preferred,
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, !info.PreferredForNewBricks)
+	a := app.allocator.(*PreferenceAwareAllocator)
+	for _, devices := range a.preferred {
+		tests.Assert(t, len(devices) == 0)
+	}
+}
+
+func TestNodeMaxConcurrentBrickOps(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var nodes []*NodeEntry
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		if err != nil {
+			return err
+		}
+		for _, id := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			nodes = append(nodes, node)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil, err)
+	limited, other := nodes[0], nodes[1]
+
+	// Nodes run the default number of brick operations at once
+	info, err := c.NodeInfo(limited.Info.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.MaxConcurrentBrickOps == NodeMaxConcurrentBrickOps,
+		info.MaxConcurrentBrickOps)
+
+	count := -1
+	_, err = c.NodeUpdate(limited.Info.Id, &api.NodeUpdateRequest{
+		MaxConcurrentBrickOps: &count,
+	})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Invalid number"), err)
+
+	count = 1
+	info, err = c.NodeUpdate(limited.Info.Id, &api.NodeUpdateRequest{
+		MaxConcurrentBrickOps: &count,
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.MaxConcurrentBrickOps == 1, info.MaxConcurrentBrickOps)
+
+	// Count the bricks created at once on each node, holding those of
+	// the other node
+	var lock sync.Mutex
+	running := make(map[string]int)
+	most := make(map[string]int)
+	release := make(chan bool)
+	app.xo.MockBrickCreate = func(host string, brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+		lock.Lock()
+		running[host]++
+		if running[host] > most[host] {
+			most[host] = running[host]
+		}
+		lock.Unlock()
+
+		if host == other.ManageHostName() {
+			<-release
+		} else {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		lock.Lock()
+		running[host]--
+		lock.Unlock()
+		return &executors.BrickInfo{Path: "/mockpath"}, nil
+	}
+
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	result := make(chan error)
+	go func() {
+		_, err := c.VolumeCreate(req)
+		result <- err
+	}()
+
+	// The bricks being created are reported in the metrics
+	for i := 0; ; i++ {
+		metrics, err := c.NodeMetrics(other.Info.Id)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, metrics.MaxConcurrentBrickOps == NodeMaxConcurrentBrickOps)
+		if metrics.BrickOpsInFlight == NodeMaxConcurrentBrickOps {
+			break
+		}
+		tests.Assert(t, i < 500, metrics)
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	err = <-result
+	tests.Assert(t, err == nil, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+	tests.Assert(t, most[limited.ManageHostName()] == 1, most)
+	tests.Assert(t, most[other.ManageHostName()] == NodeMaxConcurrentBrickOps, most)
+
+	metrics, err := c.NodeMetrics(limited.Info.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, metrics.BrickOpsInFlight == 0, metrics)
+	tests.Assert(t, metrics.MaxConcurrentBrickOps == 1, metrics)
+}
+
+func TestNodeMaxConcurrentBrickOpsCancel(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Other brick operations run on the nodes up to their limit
+	var hosts []string
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		if err != nil {
+			return err
+		}
+		for _, id := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			hosts = append(hosts, node.ManageHostName())
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil, err)
+	for _, host := range hosts {
+		for i := 0; i < NodeMaxConcurrentBrickOps; i++ {
+			err := app.xo.BrickThrottle.Acquire(host, 0, nil)
+			tests.Assert(t, err == nil, err)
+		}
+	}
+	created := 0
+	app.xo.MockBrickCreate = func(host string, brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+		created++
+		return &executors.BrickInfo{Path: "/mockpath"}, nil
+	}
+
+	// The bricks of a volume being created wait for them
+	v := createSampleVolumeEntry(10)
+	v.cancel = newOperationCancel()
+	tests.Assert(t, v.cancel.start())
+	result := make(chan error)
+	go func() {
+		result <- v.Create(app.db, app.executor, app.allocator)
+	}()
+	for i := 0; ; i++ {
+		v.cancel.stepLock.Lock()
+		step := v.cancel.current.Name
+		v.cancel.stepLock.Unlock()
+		if step == "creating bricks" {
+			break
+		}
+		tests.Assert(t, i < 500, step)
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// Until the volume is cancelled.  The bricks are then destroyed
+	// once the other operations are done.
+	_, err = v.cancel.request()
+	tests.Assert(t, err == nil, err)
+	time.Sleep(10 * time.Millisecond)
+	for _, host := range hosts {
+		for i := 0; i < NodeMaxConcurrentBrickOps; i++ {
+			app.xo.BrickThrottle.Release(host)
+		}
+	}
+	select {
+	case err = <-result:
+	case <-time.After(10 * time.Second):
+		tests.Assert(t, false, "Volume creation did not stop")
+	}
+	tests.Assert(t, err == ErrCancelled, err)
+	tests.Assert(t, created == 0, created)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		bricks, err := BrickList(tx)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, len(bricks) == 0, bricks)
+		return nil
+	})
+	tests.Assert(t, err == nil, err)
+}
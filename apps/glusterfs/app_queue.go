@@ -0,0 +1,568 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/rest"
+)
+
+var (
+	// Time completed asynchronous operations are listed before
+	// they are pruned
+	AsyncOperationRetention = time.Hour
+)
+
+// Asynchronous operations, as the async manager forgets them once
+// their result is read.  They are saved in db when set, so that they
+// survive a restart of the server.
+type asyncOperations struct {
+	lock sync.Mutex
+	ops  map[string]*asyncOperation
+	db   *bolt.DB
+
+	// Operations wait here until they can run within the limits
+	limiter *operationLimiter
+
+	// Durations of the steps of the operations of each type, which
+	// their progress is estimated from
+	durations map[string]*operationDurations
+}
+
+type asyncOperation struct {
+	info   api.AsyncOperationInfoResponse
+	cancel *operationCancel
+
+	// Request to run again if the server restarts before the
+	// operation is started
+	uri  string
+	body []byte
+
+	// Loaded from db at startup, the async manager does not know it
+	restored bool
+
+	// Also polled by the repeats of its request, the async manager
+	// forgets it once one of them has read its result
+	replayed bool
+
+	// Steps the operation went through since it started
+	timings []stepTiming
+
+	// Still running when the server shut down.  Reported as
+	// interrupted by the next startup unless it completes first.
+	interrupted bool
+}
+
+func newAsyncOperations() *asyncOperations {
+	return &asyncOperations{
+		ops:       make(map[string]*asyncOperation),
+		limiter:   newOperationLimiter(),
+		durations: make(map[string]*operationDurations),
+	}
+}
+
+func (op *asyncOperation) event(format string, v ...interface{}) {
+	op.info.History = append(op.info.History, api.AsyncOperationEvent{
+		Time:    time.Now().Unix(),
+		Message: fmt.Sprintf(format, v...),
+	})
+}
+
+// Adds the operation of the request, which is only started once it
+// is saved
+func (o *asyncOperations) add(id string,
+	r *http.Request,
+	targets []string,
+	cancel *operationCancel) error {
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.prune()
+
+	op := &asyncOperation{
+		info: api.AsyncOperationInfoResponse{
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			User:      requestUser(r),
+			RequestId: requestId(r),
+			History:   []api.AsyncOperationEvent{},
+		},
+		cancel: cancel,
+		uri:    r.URL.RequestURI(),
+		body:   requestBody(r),
+	}
+	op.info.Id = id
+	op.info.Type = requestName(r)
+	op.info.Targets = targets
+	op.info.State = api.AsyncOperationPending
+	op.info.Started = time.Now().Unix()
+
+	err := o.save(op)
+	if err != nil {
+		return err
+	}
+	o.ops[id] = op
+	return nil
+}
+
+// Waits until the limits on concurrent operations allow the queued
+// operation to run, and records how long it waited.  Returns false if
+// it was cancelled while queued.  Otherwise its place is held until
+// the limiter releases it.
+func (o *asyncOperations) admit(id string, waiter *operationWaiter) bool {
+	waited, ok := o.limiter.wait(waiter)
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	op, found := o.ops[id]
+	if !found || waited == 0 {
+		return ok
+	}
+	op.info.QueueWait = int64(waited / time.Second)
+	if ok {
+		op.event("Waited %v in the queue", waited.Truncate(time.Second))
+	}
+	return ok
+}
+
+// Marks the operation as running.  Returns false if it was cancelled
+// before it started.
+func (o *asyncOperations) start(id string) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	op, ok := o.ops[id]
+	if !ok || !op.cancel.start() {
+		return false
+	}
+	op.enterStep("", time.Now())
+
+	// Not run again after a restart from now on
+	err := o.save(op)
+	if err != nil {
+		logger.WithField("operation", id).LogError("Unable to save operation: %v", err)
+	}
+	return true
+}
+
+func (o *asyncOperations) done(id, location string, err error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	op, ok := o.ops[id]
+	if !ok {
+		return
+	}
+	defer func() {
+		if err := o.save(op); err != nil {
+			logger.WithField("operation", id).LogError("Unable to save operation: %v", err)
+		}
+	}()
+	now := time.Now()
+	op.info.Completed = now.Unix()
+	op.info.Progress = 100
+	started, requested := op.cancel.status()
+	switch {
+	case err == ErrCancelled:
+		op.info.State = api.AsyncOperationCancelled
+		op.info.Error = err.Error()
+		if started {
+			op.event("Cancelled, the changes made were rolled back")
+		}
+	case err != nil:
+		op.info.State = api.AsyncOperationFailed
+		op.info.Error = err.Error()
+		if requested {
+			op.event("Failed before the cancellation took effect")
+		}
+	default:
+		op.info.State = api.AsyncOperationSucceeded
+		op.info.Location = location
+		o.recordDurations(op, now)
+		if requested {
+			op.event("Completed before the cancellation took effect")
+		}
+	}
+}
+
+// Records the step the running operation is at.  Steps change too
+// often to be saved as they change, the last one is saved with the
+// operation once it completes.
+func (o *asyncOperations) step(id string, step api.AsyncOperationStep) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	op, ok := o.ops[id]
+	if !ok || op.info.State != api.AsyncOperationPending {
+		return
+	}
+	op.info.Step = &step
+	op.enterStep(step.Name, time.Now())
+}
+
+// Requests the cancellation of the operation.  Returns ErrNotFound
+// if the operation is not known, or the reason it cannot be
+// cancelled.  The attempt is recorded in the history.
+func (o *asyncOperations) cancelOperation(id string) (started bool, err error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.prune()
+
+	op, ok := o.ops[id]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if op.info.State != api.AsyncOperationPending {
+		return false, fmt.Errorf("Operation %v has already %v", id, op.info.State)
+	}
+
+	started, err = op.cancel.request()
+	switch {
+	case err != nil:
+		op.event("Cancellation refused: %v", err)
+	case started:
+		op.event("Cancellation requested, stopping at the next safe point")
+	default:
+		op.event("Cancelled before it started")
+		o.limiter.remove(id)
+	}
+	if err := o.save(op); err != nil {
+		logger.WithField("operation", id).LogError("Unable to save operation: %v", err)
+	}
+	return started, err
+}
+
+// Removes the operations completed longer than the retention ago.
+// Must be called with the lock held.
+func (o *asyncOperations) prune() {
+	oldest := time.Now().Add(-settings().AsyncOperationRetention).Unix()
+	pruned := []string{}
+	for id, op := range o.ops {
+		if op.info.State != api.AsyncOperationPending && op.info.Completed < oldest {
+			delete(o.ops, id)
+			pruned = append(pruned, id)
+		}
+	}
+
+	err := o.remove(pruned)
+	if err != nil {
+		logger.LogError("Unable to remove pruned operations: %v", err)
+	}
+}
+
+// Returns the operations in the order they were started
+func (o *asyncOperations) list() []api.AsyncOperation {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.prune()
+
+	list := make([]api.AsyncOperation, 0, len(o.ops))
+	for _, op := range o.ops {
+		list = append(list, o.queueStatus(op))
+	}
+	sort.Sort(asyncOperationsByStart(list))
+	return list
+}
+
+func (o *asyncOperations) get(id string) (*api.AsyncOperationInfoResponse, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.prune()
+
+	op, ok := o.ops[id]
+	if !ok {
+		return nil, false
+	}
+	info := op.info
+	info.AsyncOperation = o.queueStatus(op)
+	info.History = append([]api.AsyncOperationEvent{}, op.info.History...)
+	return &info, true
+}
+
+// Marks the operation as polled by the repeats of its request.
+// Returns false if it is not known.
+func (o *asyncOperations) replay(id string) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.prune()
+
+	op, ok := o.ops[id]
+	if !ok {
+		return false
+	}
+	op.replayed = true
+	return true
+}
+
+func (o *asyncOperations) isReplayed(id string) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	op, ok := o.ops[id]
+	return ok && op.replayed
+}
+
+// Writes the status of the operation once the async manager has
+// forgotten it
+func (o *asyncOperations) completedStatus(w http.ResponseWriter,
+	r *http.Request,
+	id string) {
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	op, ok := o.ops[id]
+	if !ok {
+		http.Error(w, "Id not found", http.StatusNotFound)
+		return
+	}
+	op.writeStatus(w, r)
+}
+
+// Returns the operation with its position in the queue and the time
+// it has waited so far, if it is queued
+func (o *asyncOperations) queueStatus(op *asyncOperation) api.AsyncOperation {
+	info := op.info.AsyncOperation
+	if info.State != api.AsyncOperationPending {
+		return info
+	}
+	if position, since := o.limiter.position(info.Id); position != 0 {
+		info.QueuePosition = position
+		info.QueueWait = int64(time.Since(since) / time.Second)
+		if o.limiter.isPaused() {
+			info.QueueStatus = api.AsyncOperationQueuedMaintenance
+		}
+	} else if len(op.timings) != 0 {
+		o.progress(op, &info)
+	}
+	return info
+}
+
+type asyncOperationsByStart []api.AsyncOperation
+
+func (l asyncOperationsByStart) Len() int      { return len(l) }
+func (l asyncOperationsByStart) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l asyncOperationsByStart) Less(i, j int) bool {
+	if l[i].Started != l[j].Started {
+		return l[i].Started < l[j].Started
+	}
+	return l[i].Id < l[j].Id
+}
+
+// Runs fn as an asynchronous operation of the request on the target
+// entries.  The operation is counted while pending and its failures
+// by route, listed under /queue, and waited for at shutdown.  It can
+// only be cancelled before it starts, fn only reports its steps
+// through cancel.
+func (a *App) asyncHttpRedirectFunc(w http.ResponseWriter,
+	r *http.Request,
+	targets []string,
+	fn func(cancel *operationCancel) (string, error)) {
+
+	reason := requestName(r) + " cannot be cancelled once it is running"
+	a.asyncCancellableHttpRedirectFunc(w, r, targets,
+		func(cancel *operationCancel) (string, error) {
+			err := cancel.commit(reason)
+			if err != nil {
+				return "", err
+			}
+			return fn(cancel)
+		})
+}
+
+// Like asyncHttpRedirectFunc, for operations which can be cancelled
+// while they run.  They check the cancellation between their steps,
+// and roll back what they did when cancelled.
+func (a *App) asyncCancellableHttpRedirectFunc(w http.ResponseWriter,
+	r *http.Request,
+	targets []string,
+	fn func(cancel *operationCancel) (string, error)) {
+
+	op := requestName(r)
+	cancel := newOperationCancel()
+	rlogger := requestLogger(r)
+
+	// Operations resumed after a restart keep their id, which the
+	// async manager does not know
+	var handler *rest.AsyncHttpHandler
+	id, resumed := context.Get(r, asyncResumeKey).(string)
+	if resumed {
+		a.operations.resume(id, targets, cancel)
+	} else {
+		handler = a.asyncManager.NewHandler()
+		id = strings.TrimPrefix(handler.Url(), ASYNC_ROUTE+"/")
+		err := a.operations.add(id, r, targets, cancel)
+		if err != nil {
+			rlogger.WithField("operation", id).LogError("Unable to save operation: %v", err)
+			handler.CompletedWithError(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The logs of the operation carry its id from now on
+	r.Header.Set(operationIdHeader, id)
+	rlogger = requestLogger(r)
+	cancel.report = func(step api.AsyncOperationStep) {
+		a.operations.step(id, step)
+	}
+
+	// Queued before the request returns, so that operations start
+	// in the order they were requested
+	metricsAsyncPending.Inc()
+	a.health.asyncStart()
+	waiter := a.operations.limiter.enqueue(id, op, cancel)
+	go func() {
+		defer metricsAsyncPending.Dec()
+		defer a.health.asyncDone()
+
+		var (
+			url string
+			err error
+		)
+		admitted := a.operations.admit(id, waiter)
+		start := time.Now()
+		if admitted && a.operations.start(id) {
+			rlogger.Info("Started job %v", id)
+			url, err = recoverOperation(rlogger, op, func() (string, error) {
+				return fn(cancel)
+			})
+			rlogger.Info("Completed job %v in %v", id, time.Since(start))
+		} else {
+			rlogger.Info("Job %v was cancelled before it started", id)
+			err = ErrCancelled
+		}
+		if admitted {
+			a.operations.limiter.release(op)
+		}
+
+		metricsAsyncDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+		if err != nil {
+			metricsOperationFailures.WithLabelValues(op).Inc()
+		}
+
+		// Record the outcome before clients polling can see it
+		a.operations.done(id, url, err)
+		a.auditOperationDone(id)
+		a.webhookOperationDone(id)
+		a.idempotencyOperationDone(id)
+		if handler == nil {
+			return
+		} else if err != nil {
+			handler.CompletedWithError(err)
+		} else if url != "" {
+			handler.CompletedWithLocation(url)
+		} else {
+			handler.Completed()
+		}
+	}()
+	http.Redirect(w, r, ASYNC_ROUTE+"/"+id, http.StatusAccepted)
+}
+
+func (a *App) AsyncOperationList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(&api.AsyncOperationListResponse{
+		Operations: a.operations.list(),
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// Clients polling an operation get its status as described in the
+// async manager, which forgets the operation once completed, with
+// the percent of the operation done in X-Progress.  The detail of
+// the operation is returned instead when the request accepts JSON.
+func (a *App) AsyncOperationStatus(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	a.operations.progressHeader(w, id)
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		switch {
+		case a.operations.restoredStatus(w, r, id):
+		case a.operations.isReplayed(id):
+			a.replayedStatus(w, r, id)
+		default:
+			a.asyncManager.HandlerStatus(w, r)
+		}
+		return
+	}
+
+	info, ok := a.operations.get(id)
+	if !ok {
+		http.Error(w, "Id not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+}
+
+// Operations polled by the repeats of their request are known to the
+// async manager until the first client reads their result, and
+// their status is written from the operation afterwards
+func (a *App) replayedStatus(w http.ResponseWriter, r *http.Request, id string) {
+	aw := &asyncResumeResponseWriter{header: make(http.Header)}
+	a.asyncManager.HandlerStatus(aw, r)
+	if aw.status == http.StatusNotFound {
+		a.operations.completedStatus(w, r, id)
+		return
+	}
+
+	for name, values := range aw.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(aw.status)
+	w.Write(aw.body.Bytes())
+}
+
+// Cancels an operation which has not started yet, or asks a running
+// operation to stop at its next safe point.  Clients waiting for the
+// operation get the cancellation as its error.
+func (a *App) AsyncOperationCancel(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	started, err := a.operations.cancelOperation(id)
+	if err == ErrNotFound {
+		http.Error(w, "Id not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	logger.Info("%v cancelled operation %v", requestUser(r), id)
+
+	if started {
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,294 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+// Limits the number of asynchronous operations running at once.  The
+// operations over the limits wait in a queue, and are started in the
+// order they were queued as soon as the limits allow it.  A queued
+// operation whose type is at its limit does not hold back the
+// operations of other types queued after it.
+type operationLimiter struct {
+	lock    sync.Mutex
+	limits  api.AsyncOperationLimits
+	total   int
+	running map[string]int
+	queue   []*operationWaiter
+
+	// No queued operation is started, the server is shutting down
+	held bool
+
+	// No queued operation is started until resumed, the server is
+	// in maintenance
+	paused bool
+}
+
+type operationWaiter struct {
+	id     string
+	optype string
+	since  time.Time
+
+	// Receives true when the operation can start, or false when
+	// it was cancelled while queued
+	ready chan bool
+}
+
+func newOperationLimiter() *operationLimiter {
+	return &operationLimiter{
+		running: make(map[string]int),
+	}
+}
+
+func validateOperationLimits(limits *api.AsyncOperationLimits) error {
+	if limits.Max < 0 {
+		return fmt.Errorf("Invalid limit %v of concurrent operations", limits.Max)
+	}
+	for optype, max := range limits.Types {
+		if max < 0 {
+			return fmt.Errorf("Invalid limit %v of concurrent %v operations", max, optype)
+		}
+	}
+	return nil
+}
+
+// Replaces the limits.  Operations already running are not stopped
+// when they are lowered, and queued operations start at once when
+// they are raised.
+func (l *operationLimiter) set(limits api.AsyncOperationLimits) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	types := make(map[string]int)
+	for optype, max := range limits.Types {
+		if max != 0 {
+			types[optype] = max
+		}
+	}
+	l.limits = api.AsyncOperationLimits{
+		Max:   limits.Max,
+		Types: types,
+	}
+	l.dispatch()
+}
+
+func (l *operationLimiter) get() api.AsyncOperationLimits {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	limits := l.limits
+	limits.Types = make(map[string]int)
+	for optype, max := range l.limits.Types {
+		limits.Types[optype] = max
+	}
+	return limits
+}
+
+// Waits until the operation can run within the limits.  Returns how
+// long it waited, and false if it was cancelled before it could run,
+// in which case release must not be called.
+func (l *operationLimiter) acquire(id, optype string, cancel *operationCancel) (time.Duration, bool) {
+	return l.wait(l.enqueue(id, optype, cancel))
+}
+
+// Queues the operation, which is started as soon as the limits allow
+// it in the order of the queue.  Returns nil if it was cancelled.
+func (l *operationLimiter) enqueue(id, optype string, cancel *operationCancel) *operationWaiter {
+	w := &operationWaiter{
+		id:     id,
+		optype: optype,
+		since:  time.Now(),
+		ready:  make(chan bool, 1),
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, requested := cancel.status(); requested {
+		return nil
+	}
+	l.queue = append(l.queue, w)
+	l.dispatch()
+	return w
+}
+
+// Waits until the queued operation can run, as acquire
+func (l *operationLimiter) wait(w *operationWaiter) (time.Duration, bool) {
+	if w == nil {
+		return 0, false
+	}
+
+	// Not waiting if the limits allowed it to run when queued
+	select {
+	case ok := <-w.ready:
+		return 0, ok
+	default:
+	}
+
+	ok := <-w.ready
+	return time.Since(w.since), ok
+}
+
+// Releases the place of a completed operation of the type
+func (l *operationLimiter) release(optype string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.total--
+	l.running[optype]--
+	if l.running[optype] == 0 {
+		delete(l.running, optype)
+	}
+	l.dispatch()
+}
+
+// Removes the operation from the queue, if it is waiting there
+func (l *operationLimiter) remove(id string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for i, w := range l.queue {
+		if w.id == id {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			w.ready <- false
+			return
+		}
+	}
+}
+
+// Returns the position of the operation in the queue, from 1, and
+// since when it waits.  The position is 0 if it is not queued.
+func (l *operationLimiter) position(id string) (int, time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for i, w := range l.queue {
+		if w.id == id {
+			return i + 1, w.since
+		}
+	}
+	return 0, time.Time{}
+}
+
+// Stops starting the queued operations, which stay queued.  Returns
+// the number of operations queued.
+func (l *operationLimiter) hold() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.held = true
+	return len(l.queue)
+}
+
+// Stops starting the queued operations until resumed.  Operations
+// already running complete.
+func (l *operationLimiter) pause() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.paused = true
+}
+
+// Starts the operations queued while paused in the order they were
+// queued, within the limits
+func (l *operationLimiter) resume() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.paused = false
+	l.dispatch()
+}
+
+func (l *operationLimiter) isPaused() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.paused
+}
+
+// Returns the number of operations queued
+func (l *operationLimiter) waiting() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return len(l.queue)
+}
+
+// Starts the queued operations which fit within the limits, unless
+// held or paused.  Must be called with the lock held.
+func (l *operationLimiter) dispatch() {
+	if l.held || l.paused {
+		return
+	}
+	queue := l.queue[:0]
+	for _, w := range l.queue {
+		if l.limits.Max != 0 && l.total >= l.limits.Max {
+			queue = append(queue, w)
+			continue
+		}
+		if max := l.limits.Types[w.optype]; max != 0 && l.running[w.optype] >= max {
+			queue = append(queue, w)
+			continue
+		}
+		l.total++
+		l.running[w.optype]++
+		w.ready <- true
+	}
+	l.queue = queue
+}
+
+func (a *App) AsyncOperationLimitsGet(w http.ResponseWriter, r *http.Request) {
+	limits := a.operations.limiter.get()
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(&limits); err != nil {
+		panic(err)
+	}
+}
+
+// Changes the limits until the next restart, which sets them from
+// the configuration again
+func (a *App) AsyncOperationLimitsSet(w http.ResponseWriter, r *http.Request) {
+	var msg api.AsyncOperationLimits
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	err = validateOperationLimits(&msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.operations.limiter.set(msg)
+	logger.Info("%v set the limits of concurrent operations to %v, by type %v",
+		requestUser(r), msg.Max, msg.Types)
+
+	a.AsyncOperationLimitsGet(w, r)
+}
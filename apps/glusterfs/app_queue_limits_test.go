@@ -0,0 +1,244 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/tests"
+)
+
+// Acquires the limiter in the background, the result is sent once
+// the operation can run or is cancelled
+func testAcquire(l *operationLimiter, id, optype string, cancel *operationCancel) <-chan bool {
+	result := make(chan bool, 1)
+	go func() {
+		_, ok := l.acquire(id, optype, cancel)
+		result <- ok
+	}()
+
+	// Wait until it is either admitted or queued
+	for i := 0; i < 100; i++ {
+		if len(result) != 0 {
+			break
+		}
+		if position, _ := l.position(id); position != 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return result
+}
+
+func TestOperationLimiter(t *testing.T) {
+	l := newOperationLimiter()
+
+	// Unlimited by default
+	for i := 0; i < 10; i++ {
+		_, ok := l.acquire("a", "VolumeCreate", newOperationCancel())
+		tests.Assert(t, ok)
+	}
+	for i := 0; i < 10; i++ {
+		l.release("VolumeCreate")
+	}
+	tests.Assert(t, l.total == 0)
+	tests.Assert(t, len(l.running) == 0)
+
+	l.set(api.AsyncOperationLimits{
+		Max:   3,
+		Types: map[string]int{"DeviceRemove": 1},
+	})
+
+	r1 := testAcquire(l, "r1", "DeviceRemove", newOperationCancel())
+	tests.Assert(t, <-r1)
+
+	// Over the limit of its type
+	r2 := testAcquire(l, "r2", "DeviceRemove", newOperationCancel())
+	r3 := testAcquire(l, "r3", "DeviceRemove", newOperationCancel())
+	position, _ := l.position("r2")
+	tests.Assert(t, position == 1, position)
+	position, _ = l.position("r3")
+	tests.Assert(t, position == 2, position)
+
+	// Other types are not held back by the queued operations
+	c1 := testAcquire(l, "c1", "VolumeCreate", newOperationCancel())
+	tests.Assert(t, <-c1)
+	c2 := testAcquire(l, "c2", "VolumeCreate", newOperationCancel())
+	tests.Assert(t, <-c2)
+
+	// Over the total limit
+	c3 := testAcquire(l, "c3", "VolumeCreate", newOperationCancel())
+	position, _ = l.position("c3")
+	tests.Assert(t, position == 3, position)
+
+	// Operations start in the order they were queued
+	l.release("DeviceRemove")
+	tests.Assert(t, <-r2)
+	position, _ = l.position("r3")
+	tests.Assert(t, position == 1, position)
+	position, _ = l.position("c3")
+	tests.Assert(t, position == 2, position)
+
+	// Cancelled while queued
+	l.remove("r3")
+	tests.Assert(t, !<-r3)
+	position, _ = l.position("c3")
+	tests.Assert(t, position == 1, position)
+
+	// Raising the limits starts the queued operations
+	l.set(api.AsyncOperationLimits{Max: 4})
+	tests.Assert(t, <-c3)
+	tests.Assert(t, l.total == 4)
+	tests.Assert(t, len(l.queue) == 0)
+
+	// An operation cancelled before it queues does not wait
+	cancel := newOperationCancel()
+	_, err := cancel.request()
+	tests.Assert(t, err == nil)
+	_, ok := l.acquire("r4", "DeviceRemove", cancel)
+	tests.Assert(t, !ok)
+	tests.Assert(t, l.total == 4)
+}
+
+func TestOperationLimitsValidate(t *testing.T) {
+	tests.Assert(t, validateOperationLimits(&api.AsyncOperationLimits{}) == nil)
+	tests.Assert(t, validateOperationLimits(&api.AsyncOperationLimits{
+		Max:   10,
+		Types: map[string]int{"DeviceRemove": 2},
+	}) == nil)
+	tests.Assert(t, validateOperationLimits(&api.AsyncOperationLimits{Max: -1}) != nil)
+	tests.Assert(t, validateOperationLimits(&api.AsyncOperationLimits{
+		Types: map[string]int{"DeviceRemove": -2},
+	}) != nil)
+}
+
+func TestAsyncOperationLimits(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	// Invalid limits are rejected
+	_, err = c.AsyncOperationLimitsSet(&api.AsyncOperationLimits{Max: -1})
+	tests.Assert(t, err != nil)
+
+	limits, err := c.AsyncOperationLimitsSet(&api.AsyncOperationLimits{
+		Types: map[string]int{"VolumeCreate": 1},
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, limits.Max == 0)
+	tests.Assert(t, limits.Types["VolumeCreate"] == 1)
+
+	limits, err = c.AsyncOperationLimits()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, limits.Types["VolumeCreate"] == 1)
+
+	// Hold the creation of the volumes
+	release := make(chan bool)
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		<-release
+		return &executors.VolumeInfo{}, nil
+	}
+
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	result := make(chan error)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := c.VolumeCreate(req)
+			result <- err
+		}()
+	}
+
+	// One runs, the others wait in the queue
+	var running string
+	queued := make(map[int]string)
+	for i := 0; ; i++ {
+		list, err := c.AsyncOperationList()
+		tests.Assert(t, err == nil, err)
+		running = ""
+		queued = make(map[int]string)
+		for _, op := range list.Operations {
+			if op.QueuePosition == 0 {
+				running = op.Id
+			} else {
+				queued[op.QueuePosition] = op.Id
+			}
+		}
+		if running != "" && len(queued) == 2 {
+			break
+		}
+		tests.Assert(t, i < 100, list.Operations)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	info, err := c.AsyncOperationInfo(queued[2])
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.QueuePosition == 2)
+
+	// Cancel the first queued, the last moves up
+	err = c.AsyncOperationCancel(queued[1])
+	tests.Assert(t, err == nil, err)
+	err = <-result
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), ErrCancelled.Error()), err)
+
+	info, err = c.AsyncOperationInfo(queued[2])
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.QueuePosition == 1, info.QueuePosition)
+
+	// Both complete once the volumes can be created
+	close(release)
+	tests.Assert(t, <-result == nil)
+	tests.Assert(t, <-result == nil)
+
+	info, err = c.AsyncOperationInfo(queued[2])
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.State == api.AsyncOperationSucceeded)
+	tests.Assert(t, info.QueuePosition == 0)
+	tests.Assert(t, len(info.History) == 1, info.History)
+	tests.Assert(t, strings.Contains(info.History[0].Message, "queue"), info.History)
+	tests.Assert(t, app.operations.limiter.total == 0)
+}
@@ -0,0 +1,364 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/context"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+const (
+	BOLTDB_BUCKET_ASYNC_OPS = "ASYNCOPERATIONS"
+
+	// Context keys of the body of a request, and of the id of the
+	// operation a request resumes
+	asyncBodyKey   = "asyncbody"
+	asyncResumeKey = "asyncresume"
+)
+
+// Operation saved in db
+type asyncOperationRecord struct {
+	Info        api.AsyncOperationInfoResponse
+	Started     bool
+	Interrupted bool
+	Uri         string
+	Body        []byte
+}
+
+// Keeps the body of the request so that its operation can be run
+// again after a restart
+func asyncBodyHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			body, err := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			context.Set(r, asyncBodyKey, body)
+		}
+		handler(w, r)
+	}
+}
+
+func requestBody(r *http.Request) []byte {
+	body, _ := context.Get(r, asyncBodyKey).([]byte)
+	return body
+}
+
+// Saves the operation if the operations are saved.  Must be called
+// with the lock held.
+func (o *asyncOperations) save(op *asyncOperation) error {
+	if o.db == nil {
+		return nil
+	}
+
+	started, _ := op.cancel.status()
+	buffer, err := json.Marshal(&asyncOperationRecord{
+		Info:        op.info,
+		Started:     started,
+		Interrupted: op.interrupted,
+		Uri:         op.uri,
+		Body:        op.body,
+	})
+	if err != nil {
+		return err
+	}
+
+	return o.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BOLTDB_BUCKET_ASYNC_OPS))
+		if b == nil {
+			return ErrDbAccess
+		}
+		return b.Put([]byte(op.info.Id), buffer)
+	})
+}
+
+// Removes the operations from db.  Must be called with the lock held.
+func (o *asyncOperations) remove(ids []string) error {
+	if o.db == nil || len(ids) == 0 {
+		return nil
+	}
+
+	return o.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BOLTDB_BUCKET_ASYNC_OPS))
+		if b == nil {
+			return ErrDbAccess
+		}
+		for _, id := range ids {
+			err := b.Delete([]byte(id))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Restores the operations saved before the last shutdown, and saves
+// the operations in db from now on.  The operations which were
+// running were interrupted and have failed.  The ones which had not
+// started are resumed by resumeOperations.
+func (o *asyncOperations) load(db *bolt.DB) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_ASYNC_OPS))
+		if err != nil {
+			return err
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var record asyncOperationRecord
+			err := json.Unmarshal(v, &record)
+			if err != nil {
+				return fmt.Errorf("Unable to decode operation %v: %v", string(k), err)
+			}
+
+			op := &asyncOperation{
+				info:     record.Info,
+				uri:      record.Uri,
+				body:     record.Body,
+				restored: true,
+			}
+			if op.info.State == api.AsyncOperationPending &&
+				(record.Started || record.Interrupted) {
+				reason := "Interrupted by a restart of the server"
+				if record.Interrupted {
+					reason = "Interrupted by the shutdown of the server"
+				}
+				logger.Warning("Operation %v: %v", op.info.Id, reason)
+				op.info.State = api.AsyncOperationFailed
+				op.info.Error = reason
+				op.info.Completed = time.Now().Unix()
+				op.event("%v", reason)
+			}
+			o.ops[op.info.Id] = op
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	o.db = db
+	for _, op := range o.ops {
+		if err := o.save(op); err != nil {
+			return err
+		}
+	}
+	o.prune()
+
+	return nil
+}
+
+// Records in the operations still running that the server shut down
+// before they completed, so that the next startup reports them as
+// interrupted unless they complete first.  Returns their ids.
+func (o *asyncOperations) interrupt() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	ids := []string{}
+	for id, op := range o.ops {
+		started, _ := op.cancel.status()
+		if !started || op.info.State != api.AsyncOperationPending {
+			continue
+		}
+		op.interrupted = true
+		op.event("Still running when the server shut down")
+		if err := o.save(op); err != nil {
+			logger.WithField("operation", id).LogError("Unable to save operation: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Requests the operations still running to stop at their next safe
+// point, as the server is closing.  Those which cannot be cancelled
+// run to completion.
+func (o *asyncOperations) stopRunning() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for id, op := range o.ops {
+		started, _ := op.cancel.status()
+		if !started || op.info.State != api.AsyncOperationPending {
+			continue
+		}
+		if _, err := op.cancel.request(); err != nil {
+			continue
+		}
+		op.event("Cancellation requested by the shutdown of the server")
+		if err := o.save(op); err != nil {
+			logger.WithField("operation", id).LogError("Unable to save operation: %v", err)
+		}
+	}
+}
+
+// Returns the restored operations which have not started, in the
+// order they were added
+func (o *asyncOperations) queued() []*asyncOperation {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	queued := []*asyncOperation{}
+	for _, op := range o.ops {
+		if op.restored && op.cancel == nil && op.info.State == api.AsyncOperationPending {
+			queued = append(queued, op)
+		}
+	}
+	sort.Sort(asyncOperationsQueue(queued))
+	return queued
+}
+
+type asyncOperationsQueue []*asyncOperation
+
+func (l asyncOperationsQueue) Len() int      { return len(l) }
+func (l asyncOperationsQueue) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l asyncOperationsQueue) Less(i, j int) bool {
+	if l[i].info.Started != l[j].info.Started {
+		return l[i].info.Started < l[j].info.Started
+	}
+	return l[i].info.Id < l[j].info.Id
+}
+
+// Resumes the restored operation with the request run again
+func (o *asyncOperations) resume(id string, targets []string, cancel *operationCancel) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	op, ok := o.ops[id]
+	if !ok {
+		return
+	}
+	op.info.Targets = targets
+	op.cancel = cancel
+	op.event("Resumed after a restart of the server")
+}
+
+func (o *asyncOperations) isResumed(id string) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	op, ok := o.ops[id]
+	return ok && op.cancel != nil
+}
+
+// Writes the status of a restored operation as the async manager
+// would.  Returns false if the operation was not restored.
+func (o *asyncOperations) restoredStatus(w http.ResponseWriter,
+	r *http.Request,
+	id string) bool {
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	op, ok := o.ops[id]
+	if !ok || !op.restored {
+		return false
+	}
+	op.writeStatus(w, r)
+	return true
+}
+
+// Writes the status of the operation as the async manager would.
+// Must be called with the lock held.
+func (op *asyncOperation) writeStatus(w http.ResponseWriter, r *http.Request) {
+	switch op.info.State {
+	case api.AsyncOperationPending:
+		w.Header().Add("X-Pending", "true")
+		w.WriteHeader(http.StatusOK)
+	case api.AsyncOperationSucceeded:
+		if op.info.Location != "" {
+			http.Redirect(w, r, op.info.Location, http.StatusSeeOther)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.Error(w, op.info.Error, http.StatusInternalServerError)
+	}
+}
+
+// Response to a request run again to resume its operation
+type asyncResumeResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *asyncResumeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *asyncResumeResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *asyncResumeResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+// Runs again the requests of the operations which had not started
+// before the last shutdown, so that they keep their id.  A request
+// which fails now, for example because what it changes is gone,
+// fails its operation.
+func (a *App) resumeOperations(router http.Handler) {
+	for _, op := range a.operations.queued() {
+		id := op.info.Id
+		logger.Info("Resuming operation %v: %v %v", id, op.info.Method, op.uri)
+
+		r, err := http.NewRequest(op.info.Method, op.uri, bytes.NewReader(op.body))
+		if err == nil {
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set(requestIdHeader, op.info.RequestId)
+			context.Set(r, asyncResumeKey, id)
+
+			w := &asyncResumeResponseWriter{header: make(http.Header)}
+			router.ServeHTTP(w, r)
+			if a.operations.isResumed(id) {
+				continue
+			}
+			if msg := strings.TrimSpace(w.body.String()); msg != "" {
+				err = fmt.Errorf("%v", msg)
+			} else {
+				err = fmt.Errorf("Request failed with status %v", w.status)
+			}
+		}
+
+		logger.WithField("operation", id).LogError("Unable to resume operation: %v", err)
+		a.operations.done(id, "", err)
+		a.auditOperationDone(id)
+		a.webhookOperationDone(id)
+		a.idempotencyOperationDone(id)
+	}
+}
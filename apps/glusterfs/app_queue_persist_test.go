@@ -0,0 +1,207 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+// Adds an operation as the handler of the request would, without
+// running it
+func addTestAsyncOperation(t *testing.T, app *App, method, uri string, body []byte) string {
+	r, err := http.NewRequest(method, uri, bytes.NewReader(body))
+	tests.Assert(t, err == nil)
+	context.Set(r, asyncBodyKey, body)
+	defer context.Clear(r)
+
+	id := utils.GenUUID()
+	err = app.operations.add(id, r, []string{}, newOperationCancel())
+	tests.Assert(t, err == nil, err)
+	return id
+}
+
+// Returns the status of the operation as seen by a client polling it
+func getTestAsyncStatus(url, id string) (*http.Response, error) {
+	c := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return c.Get(url + ASYNC_ROUTE + "/" + id)
+}
+
+func TestAsyncOperationsResumeAfterRestart(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Queued, not started before the restart
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	body, err := json.Marshal(req)
+	tests.Assert(t, err == nil)
+	queued := addTestAsyncOperation(t, app, "POST", "/volumes", body)
+
+	// Running when the server stopped
+	running := addTestAsyncOperation(t, app, "POST", "/volumes", body)
+	tests.Assert(t, app.operations.start(running))
+
+	// Completed, but its result not read yet
+	completed := addTestAsyncOperation(t, app, "DELETE", "/volumes/abc", nil)
+	tests.Assert(t, app.operations.start(completed))
+	app.operations.done(completed, "", nil)
+	app.Close()
+
+	// Restart
+	app = NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// The queued operation runs with its id
+	var r *http.Response
+	for i := 0; ; i++ {
+		r, err = getTestAsyncStatus(ts.URL, queued)
+		tests.Assert(t, err == nil)
+		if r.StatusCode != http.StatusOK {
+			break
+		}
+		tests.Assert(t, r.Header.Get("X-Pending") == "true")
+		tests.Assert(t, i < 100)
+		time.Sleep(10 * time.Millisecond)
+	}
+	tests.Assert(t, r.StatusCode == http.StatusSeeOther, r.StatusCode)
+	tests.Assert(t, strings.HasPrefix(r.Header.Get("Location"), "/volumes/"))
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		volumes, err := VolumeList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(volumes) == 1, volumes)
+		tests.Assert(t, r.Header.Get("Location") == "/volumes/"+volumes[0])
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+	info, err := c.AsyncOperationInfo(queued)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.State == api.AsyncOperationSucceeded)
+	tests.Assert(t, info.Method == "POST" && info.Path == "/volumes", info)
+	tests.Assert(t, len(info.History) == 1, info.History)
+	tests.Assert(t, strings.Contains(info.History[0].Message, "Resumed"))
+
+	// The running operation was interrupted
+	r, err = getTestAsyncStatus(ts.URL, running)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusInternalServerError)
+	msg, err := ioutil.ReadAll(r.Body)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, strings.Contains(string(msg), "Interrupted"), string(msg))
+
+	// The result of the completed operation can still be read
+	r, err = getTestAsyncStatus(ts.URL, completed)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusNoContent, r.StatusCode)
+
+	list, err := c.AsyncOperationList()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(list.Operations) == 3, list.Operations)
+}
+
+func TestAsyncOperationsResumeFailed(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// The volume no longer exists when the delete is resumed
+	app := NewTestApp(tmpfile)
+	id := addTestAsyncOperation(t, app, "DELETE", "/volumes/123456789", nil)
+	app.Close()
+
+	app = NewTestApp(tmpfile)
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	r, err := getTestAsyncStatus(ts.URL, id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusInternalServerError, r.StatusCode)
+	msg, err := ioutil.ReadAll(r.Body)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, strings.Contains(string(msg), "Id not found"), string(msg))
+
+	// The outcome is kept over another restart
+	app.Close()
+	app = NewTestApp(tmpfile)
+	defer app.Close()
+	info, ok := app.operations.get(id)
+	tests.Assert(t, ok)
+	tests.Assert(t, info.State == api.AsyncOperationFailed)
+	tests.Assert(t, info.Method == "DELETE")
+}
+
+func TestAsyncOperationsInterruptedByShutdown(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Still running at the end of the drain
+	app := NewTestApp(tmpfile)
+	id := addTestAsyncOperation(t, app, "DELETE", "/volumes/abc", nil)
+	tests.Assert(t, app.operations.start(id))
+	tests.Assert(t, reflect.DeepEqual(app.operations.interrupt(), []string{id}))
+	app.Close()
+
+	app = NewTestApp(tmpfile)
+	defer app.Close()
+	info, ok := app.operations.get(id)
+	tests.Assert(t, ok)
+	tests.Assert(t, info.State == api.AsyncOperationFailed, info)
+	tests.Assert(t, info.Error == "Interrupted by the shutdown of the server", info.Error)
+}
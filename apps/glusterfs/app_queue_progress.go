@@ -0,0 +1,196 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+const (
+	// Header of the polling responses with the percent of the
+	// operation done
+	asyncProgressHeader = "X-Progress"
+
+	// Percent running operations stay below until they complete
+	asyncProgressMax = 99
+)
+
+var (
+	// Runs of each type of operation the durations of their steps
+	// are averaged over, so that the estimates follow changes
+	operationDurationRuns = 20
+)
+
+// Step of a running operation and when it started.  Operations start
+// in an unnamed step, which is the only one of the operations which
+// do not report steps.
+type stepTiming struct {
+	name  string
+	start time.Time
+}
+
+// Average durations of the steps of the operations of a type which
+// succeeded, kept in memory only, and the steps of the last one
+type operationDurations struct {
+	steps []string
+	avg   map[string]time.Duration
+	runs  int
+}
+
+// Adds the durations of the steps of an operation which ended
+func (d *operationDurations) record(timings []stepTiming, end time.Time) {
+	d.runs++
+	n := d.runs
+	if n > operationDurationRuns {
+		n = operationDurationRuns
+	}
+
+	d.steps = make([]string, 0, len(timings))
+	for i, timing := range timings {
+		next := end
+		if i+1 < len(timings) {
+			next = timings[i+1].start
+		}
+		took := next.Sub(timing.start)
+		if avg, ok := d.avg[timing.name]; ok {
+			d.avg[timing.name] = avg + (took-avg)/time.Duration(n)
+		} else {
+			d.avg[timing.name] = took
+		}
+		d.steps = append(d.steps, timing.name)
+	}
+}
+
+// Records that the operation moved to the named step.  Must be called
+// with the lock held.
+func (op *asyncOperation) enterStep(name string, now time.Time) {
+	if n := len(op.timings); n != 0 && op.timings[n-1].name == name {
+		return
+	}
+	op.timings = append(op.timings, stepTiming{name: name, start: now})
+}
+
+// Records the durations of the steps of the operation which
+// succeeded.  Must be called with the lock held.
+func (o *asyncOperations) recordDurations(op *asyncOperation, end time.Time) {
+	if len(op.timings) == 0 {
+		return
+	}
+	d, ok := o.durations[op.info.Type]
+	if !ok {
+		d = &operationDurations{avg: make(map[string]time.Duration)}
+		o.durations[op.info.Type] = d
+	}
+	d.record(op.timings, end)
+}
+
+// Returns the percent of the running operation done, and the seconds
+// left if they can be estimated.  The steps are weighted by their
+// average durations in the previous operations of its type, and the
+// current step by its parts done or the time it has run.  Without
+// previous operations, the steps seen so far weigh the same and one
+// more is assumed to be left.  Must be called with the lock held.
+func (o *asyncOperations) estimate(op *asyncOperation, now time.Time) (int, int64) {
+	n := len(op.timings)
+	if n == 0 {
+		return 0, 0
+	}
+	current := op.timings[n-1]
+
+	fraction := 0.0
+	if step := op.info.Step; step != nil && step.Name == current.name && step.Total > 0 {
+		fraction = float64(step.Done) / float64(step.Total)
+	}
+
+	d, ok := o.durations[op.info.Type]
+	if !ok {
+		steps := float64(n - 1)
+		return int(100 * (steps + fraction) / (steps + 2)), 0
+	}
+
+	var done, left time.Duration
+	seen := make(map[string]bool)
+	for i, timing := range op.timings[:n-1] {
+		seen[timing.name] = true
+		if avg, ok := d.avg[timing.name]; ok {
+			done += avg
+		} else {
+			done += op.timings[i+1].start.Sub(timing.start)
+		}
+	}
+	seen[current.name] = true
+
+	// A step not seen before counts as done once started
+	elapsed := now.Sub(current.start)
+	avg, ok := d.avg[current.name]
+	if !ok {
+		avg = elapsed
+	}
+	if fraction == 0 && avg > 0 {
+		fraction = float64(elapsed) / float64(avg)
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	done += time.Duration(fraction * float64(avg))
+	left += avg - time.Duration(fraction*float64(avg))
+	for _, name := range d.steps {
+		if !seen[name] {
+			left += d.avg[name]
+		}
+	}
+
+	if done+left <= 0 {
+		return 0, 0
+	}
+	percent := int(100 * float64(done) / float64(done+left))
+	return percent, int64((left + time.Second - 1) / time.Second)
+}
+
+// Sets the progress of the pending operation in its status.  The
+// percent never goes down, and only reaches 100 once the operation
+// completes.  Must be called with the lock held.
+func (o *asyncOperations) progress(op *asyncOperation, info *api.AsyncOperation) {
+	percent, eta := o.estimate(op, time.Now())
+	if percent > asyncProgressMax {
+		percent = asyncProgressMax
+	}
+	if percent < op.info.Progress {
+		percent = op.info.Progress
+	}
+	op.info.Progress = percent
+	info.Progress = percent
+	info.Eta = eta
+}
+
+// Sets the header with the percent of the operation done on the
+// polling response, if the operation is known
+func (o *asyncOperations) progressHeader(w http.ResponseWriter, id string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	op, ok := o.ops[id]
+	if !ok {
+		return
+	}
+	info := o.queueStatus(op)
+	w.Header().Set(asyncProgressHeader, strconv.Itoa(info.Progress))
+}
@@ -0,0 +1,639 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/tests"
+)
+
+func TestAsyncOperations(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+	list, err := c.AsyncOperationList()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(list.Operations) == 0)
+
+	// Hold the volume create until released
+	release := make(chan bool)
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		<-release
+		return &executors.VolumeInfo{}, nil
+	}
+
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	created := make(chan *api.VolumeInfoResponse)
+	go func() {
+		volume, err := c.VolumeCreate(req)
+		tests.Assert(t, err == nil, err)
+		created <- volume
+	}()
+
+	// The operation is listed while pending
+	for i := 0; ; i++ {
+		list, err = c.AsyncOperationList()
+		tests.Assert(t, err == nil, err)
+		if len(list.Operations) == 1 {
+			break
+		}
+		tests.Assert(t, i < 100)
+		time.Sleep(10 * time.Millisecond)
+	}
+	op := list.Operations[0]
+	tests.Assert(t, op.Type == "VolumeCreate", op.Type)
+	tests.Assert(t, op.State == api.AsyncOperationPending)
+	tests.Assert(t, len(op.Targets) == 1)
+	tests.Assert(t, op.Started > 0)
+	tests.Assert(t, op.Completed == 0)
+
+	info, err := c.AsyncOperationInfo(op.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Method == "POST")
+	tests.Assert(t, info.Path == "/volumes")
+	tests.Assert(t, info.RequestId != "")
+	tests.Assert(t, info.State == api.AsyncOperationPending)
+
+	release <- true
+	volume := <-created
+	tests.Assert(t, op.Targets[0] == volume.Id, op.Targets, volume.Id)
+
+	// The result was read by the client, but the operation is
+	// still listed
+	info, err = c.AsyncOperationInfo(op.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.State == api.AsyncOperationSucceeded)
+	tests.Assert(t, info.Location == "/volumes/"+volume.Id, info.Location)
+	tests.Assert(t, info.Completed >= info.Started)
+
+	// Clients waiting for the operation get its status
+	r, err := http.Get(ts.URL + ASYNC_ROUTE + "/" + op.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusNotFound)
+
+	// Failed operations keep the error
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		return nil, errors.New("create failed")
+	}
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err != nil)
+
+	list, err = c.AsyncOperationList()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(list.Operations) == 2)
+	tests.Assert(t, list.Operations[0].Id == op.Id)
+	failed := list.Operations[1]
+	tests.Assert(t, failed.State == api.AsyncOperationFailed)
+	tests.Assert(t, strings.Contains(failed.Error, "create failed"), failed.Error)
+	tests.Assert(t, failed.Location == "")
+
+	_, err = c.AsyncOperationInfo("123")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Id not found"), err)
+}
+
+func TestOperationCancelSteps(t *testing.T) {
+	// Nothing is reported without a cancellation
+	var none *operationCancel
+	none.step("allocating bricks")
+	none.progress(1, 2, "")
+
+	var reported []api.AsyncOperationStep
+	c := newOperationCancel()
+	c.report = func(step api.AsyncOperationStep) {
+		reported = append(reported, step)
+	}
+
+	c.step("creating bricks")
+	c.progress(3, 4, "on 2 nodes")
+	tests.Assert(t, len(reported) == 2, reported)
+	tests.Assert(t, reported[0].Name == "creating bricks")
+	tests.Assert(t, reported[0].Started != 0)
+	tests.Assert(t, reported[0].Total == 0)
+	tests.Assert(t, reported[1].Name == "creating bricks")
+	tests.Assert(t, reported[1].Done == 3)
+	tests.Assert(t, reported[1].Total == 4)
+	tests.Assert(t, reported[1].Percent == 75, reported[1])
+	tests.Assert(t, reported[1].Detail == "on 2 nodes")
+
+	// A new step starts without progress
+	c.step("creating the volume")
+	tests.Assert(t, len(reported) == 3, reported)
+	tests.Assert(t, reported[2].Name == "creating the volume")
+	tests.Assert(t, reported[2].Done == 0)
+	tests.Assert(t, reported[2].Percent == 0)
+	tests.Assert(t, reported[2].Detail == "")
+}
+
+func TestAsyncOperationSteps(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Hold the creation of the last brick and of the volume
+	var lock sync.Mutex
+	bricks := 0
+	releaseBrick := make(chan bool)
+	releaseVolume := make(chan bool)
+	app.xo.MockBrickCreate = func(host string, brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+		lock.Lock()
+		bricks++
+		last := bricks == 4
+		lock.Unlock()
+		if last {
+			<-releaseBrick
+		}
+		return &executors.BrickInfo{Path: "/mockpath"}, nil
+	}
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		<-releaseVolume
+		return nil, errors.New("create failed")
+	}
+
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	result := make(chan error)
+	go func() {
+		_, err := c.VolumeCreate(req)
+		result <- err
+	}()
+
+	waitStep := func(name string, done int) *api.AsyncOperationStep {
+		for i := 0; ; i++ {
+			list, err := c.AsyncOperationList()
+			tests.Assert(t, err == nil, err)
+			if len(list.Operations) == 1 {
+				step := list.Operations[0].Step
+				if step != nil && step.Name == name && step.Done == done {
+					return step
+				}
+			}
+			tests.Assert(t, i < 500, list.Operations)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The bricks created are counted
+	step := waitStep("creating bricks", 3)
+	tests.Assert(t, step.Total == 4, step)
+	tests.Assert(t, step.Percent == 75, step)
+	tests.Assert(t, step.Started != 0)
+
+	// Polling responses carry the progress, which is partial until
+	// the operation completes
+	list, err := c.AsyncOperationList()
+	tests.Assert(t, err == nil, err)
+	progress := list.Operations[0].Progress
+	tests.Assert(t, progress > 0 && progress < 100, progress)
+	r, err := http.Get(ts.URL + ASYNC_ROUTE + "/" + list.Operations[0].Id)
+	tests.Assert(t, err == nil, err)
+	r.Body.Close()
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+	tests.Assert(t, r.Header.Get("X-Pending") == "true")
+	tests.Assert(t, r.Header.Get(asyncProgressHeader) != "")
+	close(releaseBrick)
+
+	waitStep("creating the volume", 0)
+	list, err = c.AsyncOperationList()
+	tests.Assert(t, err == nil, err)
+	info, err := c.AsyncOperationInfo(list.Operations[0].Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Step != nil)
+	tests.Assert(t, info.Step.Name == "creating the volume", info.Step)
+	tests.Assert(t, info.Progress >= progress && info.Progress < 100, info.Progress)
+	close(releaseVolume)
+
+	// Failed operations keep the step they failed at
+	err = <-result
+	tests.Assert(t, err != nil)
+	info, err = c.AsyncOperationInfo(info.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.State == api.AsyncOperationFailed)
+	tests.Assert(t, info.Step.Name == "creating the volume", info.Step)
+	tests.Assert(t, info.Progress == 100, info.Progress)
+}
+
+func TestAsyncOperationProgress(t *testing.T) {
+	r, err := http.NewRequest("POST", "/volumes", nil)
+	tests.Assert(t, err == nil)
+
+	ops := newAsyncOperations()
+	status := func(id string) api.AsyncOperation {
+		ops.lock.Lock()
+		defer ops.lock.Unlock()
+		return ops.queueStatus(ops.ops[id])
+	}
+
+	// Without previous operations the steps seen so far count the
+	// same, with one more left
+	err = ops.add("a", r, []string{}, newOperationCancel())
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, status("a").Progress == 0)
+	tests.Assert(t, ops.start("a"))
+	tests.Assert(t, status("a").Progress == 0)
+	ops.step("a", api.AsyncOperationStep{Name: "one"})
+	tests.Assert(t, status("a").Progress == 33, status("a"))
+	ops.step("a", api.AsyncOperationStep{Name: "one", Done: 1, Total: 2})
+	tests.Assert(t, status("a").Progress == 50, status("a"))
+	tests.Assert(t, status("a").Eta == 0)
+
+	// Completed operations are done
+	ops.done("a", "", nil)
+	tests.Assert(t, status("a").Progress == 100)
+
+	// Later operations are weighted by the durations of the steps of
+	// the previous ones
+	now := time.Now()
+	typ := status("a").Type
+	ops.durations[typ] = &operationDurations{
+		steps: []string{"", "one", "two"},
+		avg: map[string]time.Duration{
+			"":    0,
+			"one": 10 * time.Second,
+			"two": 30 * time.Second,
+		},
+		runs: 1,
+	}
+	err = ops.add("b", r, []string{}, newOperationCancel())
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, ops.start("b"))
+	ops.lock.Lock()
+	ops.ops["b"].timings = []stepTiming{
+		{name: "", start: now.Add(-25 * time.Second)},
+		{name: "one", start: now.Add(-25 * time.Second)},
+		{name: "two", start: now.Add(-15 * time.Second)},
+	}
+	ops.lock.Unlock()
+	info := status("b")
+	tests.Assert(t, info.Progress == 62, info)
+	tests.Assert(t, info.Eta == 15, info)
+
+	// The parts of the step done count over the time it ran
+	ops.lock.Lock()
+	ops.ops["b"].info.Step = &api.AsyncOperationStep{Name: "two", Done: 3, Total: 4}
+	ops.lock.Unlock()
+	info = status("b")
+	tests.Assert(t, info.Progress == 81, info)
+	tests.Assert(t, info.Eta == 8, info)
+
+	// The progress does not go back, and stays below 100 while the
+	// operation runs
+	ops.lock.Lock()
+	ops.ops["b"].info.Step = &api.AsyncOperationStep{Name: "two", Done: 1, Total: 4}
+	ops.lock.Unlock()
+	tests.Assert(t, status("b").Progress == 81)
+	ops.lock.Lock()
+	ops.ops["b"].timings = []stepTiming{
+		{name: "", start: now.Add(-time.Hour - 10*time.Second)},
+		{name: "one", start: now.Add(-time.Hour - 10*time.Second)},
+		{name: "two", start: now.Add(-time.Hour)},
+	}
+	ops.ops["b"].info.Step = nil
+	ops.lock.Unlock()
+	tests.Assert(t, status("b").Progress == asyncProgressMax)
+
+	// The durations are averaged with those of the operation
+	ops.done("b", "", nil)
+	tests.Assert(t, status("b").Progress == 100)
+	d := ops.durations[typ]
+	tests.Assert(t, d.runs == 2)
+	tests.Assert(t, d.avg["one"] == 10*time.Second, d.avg)
+	tests.Assert(t, d.avg["two"] > 30*time.Minute, d.avg)
+}
+
+func TestAsyncOperationsPrune(t *testing.T) {
+	defer func(d time.Duration) { AsyncOperationRetention = d }(AsyncOperationRetention)
+	AsyncOperationRetention = time.Minute
+
+	r, err := http.NewRequest("POST", "/volumes", nil)
+	tests.Assert(t, err == nil)
+
+	ops := newAsyncOperations()
+	ops.add("a", r, []string{"1"}, nil)
+	ops.add("b", r, []string{"2"}, nil)
+	ops.add("c", r, []string{"3"}, nil)
+	ops.done("a", "", nil)
+	ops.done("b", "", errors.New("failed"))
+
+	// Completed operations older than the retention are pruned
+	ops.ops["a"].info.Completed -= 61
+	ops.ops["c"].info.Started -= 61
+
+	list := ops.list()
+	tests.Assert(t, len(list) == 2, list)
+	tests.Assert(t, list[0].Id == "c")
+	tests.Assert(t, list[1].Id == "b")
+
+	_, ok := ops.get("a")
+	tests.Assert(t, !ok)
+	info, ok := ops.get("c")
+	tests.Assert(t, ok)
+	tests.Assert(t, info.State == api.AsyncOperationPending)
+}
+
+func TestAsyncOperationsCancel(t *testing.T) {
+	r, err := http.NewRequest("POST", "/volumes", nil)
+	tests.Assert(t, err == nil)
+
+	ops := newAsyncOperations()
+	_, err = ops.cancelOperation("a")
+	tests.Assert(t, err == ErrNotFound)
+
+	// Not started yet
+	cancel := newOperationCancel()
+	ops.add("a", r, []string{"1"}, cancel)
+	started, err := ops.cancelOperation("a")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, !started)
+	tests.Assert(t, !cancel.start())
+	ops.done("a", "", ErrCancelled)
+
+	info, ok := ops.get("a")
+	tests.Assert(t, ok)
+	tests.Assert(t, info.State == api.AsyncOperationCancelled)
+	tests.Assert(t, len(info.History) == 1, info.History)
+	tests.Assert(t, info.History[0].Message == "Cancelled before it started")
+
+	_, err = ops.cancelOperation("a")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "has already cancelled"), err)
+
+	// Running past the point of no return
+	cancel = newOperationCancel()
+	ops.add("b", r, []string{"2"}, cancel)
+	tests.Assert(t, cancel.start())
+	tests.Assert(t, cancel.checkpoint() == nil)
+	tests.Assert(t, cancel.commit("Too late") == nil)
+	_, err = ops.cancelOperation("b")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, err.Error() == "Too late", err)
+	tests.Assert(t, cancel.checkpoint() == nil)
+	ops.done("b", "/volumes/2", nil)
+
+	info, ok = ops.get("b")
+	tests.Assert(t, ok)
+	tests.Assert(t, info.State == api.AsyncOperationSucceeded)
+	tests.Assert(t, len(info.History) == 1, info.History)
+	tests.Assert(t, info.History[0].Message == "Cancellation refused: Too late")
+
+	// Running and cancelled at the next checkpoint
+	cancel = newOperationCancel()
+	ops.add("c", r, []string{"3"}, cancel)
+	tests.Assert(t, cancel.start())
+	started, err = ops.cancelOperation("c")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, started)
+	tests.Assert(t, cancel.checkpoint() == ErrCancelled)
+	tests.Assert(t, cancel.commit("Too late") == ErrCancelled)
+	ops.done("c", "", ErrCancelled)
+
+	info, ok = ops.get("c")
+	tests.Assert(t, ok)
+	tests.Assert(t, info.State == api.AsyncOperationCancelled)
+	tests.Assert(t, len(info.History) == 2, info.History)
+
+	// A nil cancellation is never cancelled
+	var none *operationCancel
+	tests.Assert(t, none.checkpoint() == nil)
+	tests.Assert(t, none.commit("") == nil)
+}
+
+func TestAsyncOperationCancelVolumeCreate(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Hold the creation of the bricks
+	var lock sync.Mutex
+	release := make(chan bool)
+	created, destroyed := 0, 0
+	app.xo.MockBrickCreate = func(host string, brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+		<-release
+		lock.Lock()
+		defer lock.Unlock()
+		created++
+		return &executors.BrickInfo{Path: "/mockpath"}, nil
+	}
+	app.xo.MockBrickDestroy = func(host string, brick *executors.BrickRequest) error {
+		lock.Lock()
+		defer lock.Unlock()
+		destroyed++
+		return nil
+	}
+
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	result := make(chan error)
+	go func() {
+		_, err := c.VolumeCreate(req)
+		result <- err
+	}()
+
+	var op api.AsyncOperation
+	for i := 0; ; i++ {
+		list, err := c.AsyncOperationList()
+		tests.Assert(t, err == nil, err)
+		if len(list.Operations) == 1 {
+			op = list.Operations[0]
+			break
+		}
+		tests.Assert(t, i < 100)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = c.AsyncOperationCancel(op.Id)
+	tests.Assert(t, err == nil, err)
+	close(release)
+
+	// The volume is not created, and the bricks are removed
+	err = <-result
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), ErrCancelled.Error()), err)
+	lock.Lock()
+	tests.Assert(t, created != 0)
+	tests.Assert(t, destroyed == created, created, destroyed)
+	lock.Unlock()
+
+	info, err := c.AsyncOperationInfo(op.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.State == api.AsyncOperationCancelled)
+	tests.Assert(t, len(info.History) == 2, info.History)
+	tests.Assert(t, strings.Contains(info.History[1].Message, "rolled back"))
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		volumes, err := VolumeList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(volumes) == 0, volumes)
+		bricks, err := BrickList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(bricks) == 0, bricks)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Completed operations cannot be cancelled
+	err = c.AsyncOperationCancel(op.Id)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "has already cancelled"), err)
+}
+
+func TestAsyncOperationCancelRefused(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Hold the creation of the volume
+	release := make(chan bool)
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		<-release
+		return &executors.VolumeInfo{}, nil
+	}
+
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	result := make(chan error)
+	go func() {
+		_, err := c.VolumeCreate(req)
+		result <- err
+	}()
+
+	// Cancellation is refused once the volume is being created
+	var op api.AsyncOperation
+	for i := 0; ; i++ {
+		list, err := c.AsyncOperationList()
+		tests.Assert(t, err == nil, err)
+		if len(list.Operations) == 1 {
+			op = list.Operations[0]
+			err = c.AsyncOperationCancel(op.Id)
+			if err != nil {
+				tests.Assert(t, strings.Contains(err.Error(),
+					"was already created and started"), err)
+				break
+			}
+		}
+		tests.Assert(t, i < 100)
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	tests.Assert(t, <-result == nil)
+
+	info, err := c.AsyncOperationInfo(op.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.State == api.AsyncOperationSucceeded)
+	found := false
+	for _, event := range info.History {
+		if strings.HasPrefix(event.Message, "Cancellation refused") {
+			found = true
+		}
+	}
+	tests.Assert(t, found, info.History)
+}
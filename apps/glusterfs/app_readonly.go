@@ -0,0 +1,107 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+const (
+	// Seconds clients are asked to wait before retrying a change
+	readOnlyRetryAfter = 60
+)
+
+// Routes which do not change any state and are allowed
+// in read-only mode even though they are not GETs
+var readOnlyAllowedRoutes = map[string]bool{
+	"AsyncCancel":             true,
+	"AsyncOperationLimitsSet": true,
+	"ConfigReload":            true,
+	"DeviceTrim":              true,
+	"LogLevelSet":             true,
+	"MaintenanceSet":          true,
+	"ReadOnlySet":             true,
+	"VolumeConsistencyCheck":  true,
+	"VolumeDryRun":            true,
+	"VolumeProfileStart":      true,
+	"VolumeProfileStop":       true,
+}
+
+func (a *App) IsReadOnly() bool {
+	a.readOnlyLock.RLock()
+	defer a.readOnlyLock.RUnlock()
+
+	return a.readOnly
+}
+
+// Only new requests are rejected.  Asynchronous operations which
+// have already started complete and update the db.
+func (a *App) readOnlyFilter(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.IsReadOnly() {
+			w.Header().Set("Retry-After", strconv.Itoa(readOnlyRetryAfter))
+			http.Error(w, "Server is in read-only mode", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (a *App) ReadOnlyGet(w http.ResponseWriter, r *http.Request) {
+	info := &api.ReadOnlyInfo{
+		ReadOnly:   a.IsReadOnly(),
+		DbReadOnly: a.db.IsReadOnly(),
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) ReadOnlySet(w http.ResponseWriter, r *http.Request) {
+	var msg api.ReadOnlyRequest
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	// A db opened read-only cannot be written until heketi is
+	// restarted without read-only mode
+	if !msg.ReadOnly && a.db.IsReadOnly() {
+		http.Error(w, "Database was opened read-only, "+
+			"restart the server to allow changes", http.StatusConflict)
+		return
+	}
+
+	a.readOnlyLock.Lock()
+	a.readOnly = msg.ReadOnly
+	a.readOnlyLock.Unlock()
+
+	if msg.ReadOnly {
+		logger.Warning("Read-only mode enabled")
+	} else {
+		logger.Info("Read-only mode disabled")
+	}
+}
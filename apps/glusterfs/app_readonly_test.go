@@ -0,0 +1,208 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func setReadOnly(t *testing.T, url string, readOnly bool) *http.Response {
+	request := []byte(`{"read_only" : false}`)
+	if readOnly {
+		request = []byte(`{"read_only" : true}`)
+	}
+	r, err := http.Post(url+"/admin/readonly", "application/json",
+		bytes.NewBuffer(request))
+	tests.Assert(t, err == nil)
+	return r
+}
+
+func TestAppReadOnlyToggle(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Enable read-only mode
+	r := setReadOnly(t, ts.URL, true)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+
+	var info api.ReadOnlyInfo
+	r, err := http.Get(ts.URL + "/admin/readonly")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+	err = utils.GetJsonFromResponse(r, &info)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, info.ReadOnly)
+	tests.Assert(t, !info.DbReadOnly)
+
+	// Changes are rejected
+	r, err = http.Post(ts.URL+"/clusters", "application/json", bytes.NewBuffer([]byte{}))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusServiceUnavailable)
+	tests.Assert(t, r.Header.Get("Retry-After") == "60")
+
+	req, err := http.NewRequest("DELETE", ts.URL+"/clusters/12345", nil)
+	tests.Assert(t, err == nil)
+	r, err = http.DefaultClient.Do(req)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusServiceUnavailable)
+
+	// Queries still work
+	r, err = http.Get(ts.URL + "/clusters")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+
+	// Disable read-only mode
+	r = setReadOnly(t, ts.URL, false)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+
+	r, err = http.Post(ts.URL+"/clusters", "application/json", bytes.NewBuffer([]byte{}))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusCreated)
+}
+
+func TestAppReadOnlyAsyncOperationCompletes(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Enable read-only mode while the volume is being created
+	app.xo.MockVolumeCreate = func(host string,
+		volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		r := setReadOnly(t, ts.URL, true)
+		tests.Assert(t, r.StatusCode == http.StatusOK)
+		return &executors.VolumeInfo{}, nil
+	}
+
+	request := []byte(`{"size" : 100}`)
+	r, err := http.Post(ts.URL+"/volumes", "application/json", bytes.NewBuffer(request))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusAccepted)
+	location, err := r.Location()
+	tests.Assert(t, err == nil)
+
+	// Query queue until finished
+	var info api.VolumeInfoResponse
+	for {
+		r, err = http.Get(location.String())
+		tests.Assert(t, err == nil)
+		tests.Assert(t, r.StatusCode == http.StatusOK)
+		if r.ContentLength <= 0 {
+			time.Sleep(time.Millisecond * 10)
+			continue
+		}
+		err = utils.GetJsonFromResponse(r, &info)
+		tests.Assert(t, err == nil)
+		break
+	}
+	tests.Assert(t, app.IsReadOnly())
+
+	// The volume was saved
+	err = app.db.View(func(tx *bolt.Tx) error {
+		_, err := NewVolumeEntryFromId(tx, info.Id)
+		return err
+	})
+	tests.Assert(t, err == nil)
+
+	// New volumes are rejected
+	r, err = http.Post(ts.URL+"/volumes", "application/json", bytes.NewBuffer(request))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusServiceUnavailable)
+}
+
+func TestAppReadOnlyAtStartup(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the db
+	app := NewTestApp(tmpfile)
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+	app.Close()
+
+	// Restart in read-only mode
+	app = NewApp(bytes.NewBuffer([]byte(`{
+		"glusterfs" : {
+			"executor" : "mock",
+			"allocator" : "simple",
+			"read_only" : true,
+			"db" : "` + tmpfile + `"
+		}
+	}`)))
+	tests.Assert(t, app != nil)
+	defer app.Close()
+	tests.Assert(t, app.IsReadOnly())
+	tests.Assert(t, app.db.IsReadOnly())
+
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Queries still work
+	r, err := http.Get(ts.URL + "/clusters")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+
+	// The db cannot be written until restarted
+	r = setReadOnly(t, ts.URL, false)
+	tests.Assert(t, r.StatusCode == http.StatusConflict)
+	tests.Assert(t, app.IsReadOnly())
+}
@@ -0,0 +1,109 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+// Keeps whether the handler has started the response
+type recoverResponseWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *recoverResponseWriter) WriteHeader(status int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recoverResponseWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Logs the panic with its stack under a new incident id, which is
+// returned so that it can be given to the client
+func logPanic(l *utils.Logger, route string, p interface{}) string {
+	incident := utils.GenUUID()
+	metricsPanics.WithLabelValues(route).Inc()
+	l.WithField("incident", incident).
+		Critical("Panic in %v: %v\n%s", route, p, debug.Stack())
+	return incident
+}
+
+// Answers 500 with an incident id if the handler of the route panics,
+// instead of letting the panic reach the server.  The response is only
+// written if the handler had not started it.
+func recoverHandler(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoverResponseWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			} else if p == http.ErrAbortHandler {
+				// Used by handlers to abort the response on purpose
+				panic(p)
+			}
+
+			incident := logPanic(requestLogger(r), route, p)
+			if rw.started {
+				return
+			}
+			writeApiError(w, r, &api.Error{
+				Code: api.ErrorCodeInternal,
+				Message: fmt.Sprintf("Internal error, report incident %v "+
+					"with request %v", incident, requestId(r)),
+				Status: http.StatusInternalServerError,
+				Fields: map[string]string{"incident": incident},
+			})
+		}()
+		handler(rw, r)
+	}
+}
+
+// Runs the function of an asynchronous operation, returning a panic
+// in it as an error so that the operation fails without stopping the
+// server or the queue
+func recoverOperation(l *utils.Logger, op string,
+	fn func() (string, error)) (url string, err error) {
+
+	defer func() {
+		if p := recover(); p != nil {
+			incident := logPanic(l, op, p)
+			url = ""
+			err = fmt.Errorf("Internal error, report incident %v", incident)
+		}
+	}()
+	return fn()
+}
+
+// Runs a function called from a goroutine of an operation, returning
+// a panic in it as an error so that the other goroutines can complete
+// and the operation fails instead of stopping the server
+func recoverCall(l *utils.Logger, name string, fn func() error) error {
+	_, err := recoverOperation(l, name, func() (string, error) {
+		return "", fn()
+	})
+	return err
+}
@@ -0,0 +1,181 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/tests"
+)
+
+func TestRecoverHandler(t *testing.T) {
+	router := mux.NewRouter()
+	router.Methods("GET").Path("/panic").Name("Panic").Handler(
+		requestIdHandler(recoverHandler("Panic",
+			func(w http.ResponseWriter, r *http.Request) {
+				panic("broken")
+			})))
+	router.Methods("GET").Path("/started").Name("Started").Handler(
+		recoverHandler("Started",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				panic("broken")
+			}))
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// The server keeps answering with the incident
+	for i := 0; i < 2; i++ {
+		r, err := http.Get(ts.URL + "/panic")
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, r.StatusCode == http.StatusInternalServerError)
+		tests.Assert(t, strings.HasPrefix(r.Header.Get("Content-Type"), "application/json"))
+
+		var e api.Error
+		err = json.NewDecoder(r.Body).Decode(&e)
+		r.Body.Close()
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, e.Code == api.ErrorCodeInternal, e)
+		tests.Assert(t, e.Status == http.StatusInternalServerError, e)
+		tests.Assert(t, e.Fields["route"] == "Panic", e)
+		tests.Assert(t, e.Fields["incident"] != "", e)
+		tests.Assert(t, strings.Contains(e.Message, e.Fields["incident"]), e)
+		tests.Assert(t, strings.Contains(e.Message, r.Header.Get(requestIdHeader)), e)
+	}
+
+	// Not written over a response already started
+	r, err := http.Get(ts.URL + "/started")
+	tests.Assert(t, err == nil, err)
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+	tests.Assert(t, len(body) == 0, string(body))
+}
+
+func TestRecoverOperation(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	create := app.xo.MockVolumeCreate
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		panic("broken")
+	}
+
+	// The operation fails with the incident
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "incident"), err)
+
+	// Without stopping the queue
+	app.xo.MockVolumeCreate = create
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+
+	r, err := http.Get(ts.URL + "/metrics")
+	tests.Assert(t, err == nil)
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, strings.Contains(string(body), `heketi_panics_total{route="VolumeCreate"}`),
+		string(body))
+}
+
+func TestRecoverBrickCreate(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// One of the bricks panics
+	var lock sync.Mutex
+	created := 0
+	create := app.xo.MockBrickCreate
+	app.xo.MockBrickCreate = func(host string, brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+		lock.Lock()
+		created++
+		first := created == 1
+		lock.Unlock()
+		if first {
+			panic("broken")
+		}
+		return create(host, brick)
+	}
+	destroyed := 0
+	app.xo.MockBrickDestroy = func(host string, brick *executors.BrickRequest) error {
+		lock.Lock()
+		defer lock.Unlock()
+		destroyed++
+		return nil
+	}
+
+	// The volume fails with the incident, after the other bricks
+	// completed, and all of them are destroyed
+	v := createSampleVolumeEntry(10)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "incident"), err)
+	tests.Assert(t, created > 1, created)
+	tests.Assert(t, destroyed == created, destroyed, created)
+
+	// Without stopping the server
+	v = createSampleVolumeEntry(10)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+}
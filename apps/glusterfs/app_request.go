@@ -0,0 +1,58 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+// Returns the context of the request for the logs, which carries its id
+// and the id of the operation it started, if any
+func requestContext(r *http.Request) context.Context {
+	ctx := utils.ContextWithRequestId(context.Background(), requestId(r))
+	if id := r.Header.Get(operationIdHeader); id != "" {
+		ctx = utils.ContextWithOperationId(ctx, id)
+	}
+	return ctx
+}
+
+// Returns the logger of the request, which writes its id with the
+// messages
+func requestLogger(r *http.Request) *utils.Logger {
+	return logger.WithContext(requestContext(r))
+}
+
+// Returns the executor of the request, which logs the commands it
+// runs with the id of the request
+func (a *App) requestExecutor(r *http.Request) executors.Executor {
+	return a.executor.WithContext(requestContext(r))
+}
+
+// Returns the id of the request in the response, so that clients can
+// find the logs of the request and of the operation it starts
+func requestIdHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Only set by the server for the operation of the request
+		r.Header.Del(operationIdHeader)
+		w.Header().Set(requestIdHeader, requestId(r))
+		handler(w, r)
+	}
+}
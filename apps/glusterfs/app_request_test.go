@@ -0,0 +1,153 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func TestRequestId(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	get := func(id string) *http.Response {
+		req, err := http.NewRequest("GET", ts.URL+"/clusters", nil)
+		tests.Assert(t, err == nil)
+		if id != "" {
+			req.Header.Set(requestIdHeader, id)
+		}
+		r, err := http.DefaultClient.Do(req)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, r.StatusCode == http.StatusOK)
+		r.Body.Close()
+		return r
+	}
+
+	// Set when the client does not
+	r := get("")
+	tests.Assert(t, len(r.Header.Get(requestIdHeader)) == 8, r.Header)
+
+	// Kept when valid
+	r = get("my-request.1")
+	tests.Assert(t, r.Header.Get(requestIdHeader) == "my-request.1", r.Header)
+
+	// Replaced otherwise
+	r = get("not { safe } to log")
+	tests.Assert(t, len(r.Header.Get(requestIdHeader)) == 8, r.Header)
+	r = get(strings.Repeat("a", 65))
+	tests.Assert(t, len(r.Header.Get(requestIdHeader)) == 8, r.Header)
+
+	// Also on errors
+	req, err := http.NewRequest("GET", ts.URL+"/clusters/123abc", nil)
+	tests.Assert(t, err == nil)
+	req.Header.Set(requestIdHeader, "missing")
+	r, err = http.DefaultClient.Do(req)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusNotFound)
+	tests.Assert(t, r.Header.Get(requestIdHeader) == "missing", r.Header)
+
+	// The operation keeps the id of the request which started it
+	req, err = http.NewRequest("POST", ts.URL+"/volumes",
+		bytes.NewBufferString(`{"size": 10, "durability": {"type": "none"}}`))
+	tests.Assert(t, err == nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestIdHeader, "create-1")
+	noRedirect := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	r, err = noRedirect.Do(req)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusAccepted, r.StatusCode)
+	tests.Assert(t, r.Header.Get(requestIdHeader) == "create-1", r.Header)
+	location, err := r.Location()
+	tests.Assert(t, err == nil)
+	id := strings.TrimPrefix(location.Path, ASYNC_ROUTE+"/")
+
+	c := client.NewClientNoAuth(ts.URL)
+	info, err := c.AsyncOperationInfo(id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.RequestId == "create-1", info.RequestId)
+
+	for i := 0; app.runningOperations() != 0; i++ {
+		tests.Assert(t, i < 100)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRequestExecutor(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	r, err := http.NewRequest("GET", "/test", nil)
+	tests.Assert(t, err == nil)
+	r.Header.Set(requestIdHeader, "abc")
+
+	ctx := requestContext(r)
+	tests.Assert(t, utils.RequestIdFromContext(ctx) == "abc")
+	tests.Assert(t, utils.OperationIdFromContext(ctx) == "")
+	tests.Assert(t, requestLogger(r) != logger)
+
+	// Once the operation of the request is started
+	r.Header.Set(operationIdHeader, "op1")
+	ctx = requestContext(r)
+	tests.Assert(t, utils.RequestIdFromContext(ctx) == "abc")
+	tests.Assert(t, utils.OperationIdFromContext(ctx) == "op1")
+
+	// Not taken from clients
+	var seen string
+	requestIdHandler(func(w http.ResponseWriter, r *http.Request) {
+		seen = utils.OperationIdFromContext(requestContext(r))
+	})(httptest.NewRecorder(), r)
+	tests.Assert(t, seen == "", seen)
+
+	// The mock logs no commands
+	tests.Assert(t, app.requestExecutor(r) == app.executor)
+}
@@ -18,10 +18,18 @@ package glusterfs
 
 import (
 	"bytes"
-	"github.com/heketi/heketi/pkg/utils"
-	"github.com/heketi/tests"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
 )
 
 func TestAppBadConfigData(t *testing.T) {
@@ -84,13 +92,16 @@ func TestAppAdvsettings(t *testing.T) {
 			"db" : "` + dbfile + `",
 			"brick_max_size_gb" : 1024,
 			"brick_min_size_gb" : 1,
-			"max_bricks_per_volume" : 33
+			"max_bricks_per_volume" : 33,
+			"default_storage_class" : "ssd"
 		}
 	}`)
 
 	bmax, bmin, bnum := BrickMaxSize, BrickMinSize, BrickMaxNum
+	sclass := DefaultStorageClass
 	defer func() {
 		BrickMaxSize, BrickMinSize, BrickMaxNum = bmax, bmin, bnum
+		DefaultStorageClass = sclass
 	}()
 
 	app := NewApp(bytes.NewReader(data))
@@ -99,6 +110,7 @@ func TestAppAdvsettings(t *testing.T) {
 	tests.Assert(t, BrickMaxNum == 33)
 	tests.Assert(t, BrickMaxSize == 1*TB)
 	tests.Assert(t, BrickMinSize == 1*GB)
+	tests.Assert(t, DefaultStorageClass == "ssd")
 }
 
 func TestAppLogLevel(t *testing.T) {
@@ -160,3 +172,157 @@ func TestAppLogLevel(t *testing.T) {
 	tests.Assert(t, app != nil)
 	tests.Assert(t, logger.Level() == utils.LEVEL_NOLOG)
 }
+
+func TestAppLogFormat(t *testing.T) {
+	dbfile := tests.Tempfile()
+	defer os.Remove(dbfile)
+	defer logger.SetFormat(utils.LOG_FORMAT_TEXT)
+
+	config := func(format string) []byte {
+		return []byte(`{
+			"glusterfs" : {
+				"executor" : "mock",
+				"allocator" : "simple",
+				"db" : "` + dbfile + `",
+				"log_format" : "` + format + `"
+			}
+		}`)
+	}
+
+	app := NewApp(bytes.NewReader(config("json")))
+	tests.Assert(t, app != nil)
+	tests.Assert(t, logger.Format() == utils.LOG_FORMAT_JSON)
+	app.Close()
+
+	// An unknown value does not change the format
+	app = NewApp(bytes.NewReader(config("xml")))
+	tests.Assert(t, app != nil)
+	tests.Assert(t, logger.Format() == utils.LOG_FORMAT_JSON)
+	app.Close()
+
+	app = NewApp(bytes.NewReader(config("text")))
+	tests.Assert(t, app != nil)
+	tests.Assert(t, logger.Format() == utils.LOG_FORMAT_TEXT)
+	app.Close()
+}
+
+func TestAppBrickPathTemplate(t *testing.T) {
+	dbfile := tests.Tempfile()
+	defer os.Remove(dbfile)
+	defer tests.Patch(&BrickPathTemplate, "").Restore()
+
+	config := func(template string) []byte {
+		return []byte(`{
+			"glusterfs" : {
+				"executor" : "mock",
+				"allocator" : "simple",
+				"db" : "` + dbfile + `",
+				"brick_path_template" : "` + template + `"
+			}
+		}`)
+	}
+
+	// Not started with a template which is not valid
+	app := NewApp(bytes.NewReader(config("/mounts/{volume}/{brick}")))
+	tests.Assert(t, app == nil)
+	tests.Assert(t, BrickPathTemplate == "")
+
+	app = NewApp(bytes.NewReader(config("/srv/bricks/{vg}/{brick}")))
+	tests.Assert(t, app != nil)
+	defer app.Close()
+	tests.Assert(t, BrickPathTemplate == "/srv/bricks/{vg}/{brick}")
+
+	// Given to the executor to create the bricks
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+	var templates []string
+	var lock sync.Mutex
+	app.xo.MockBrickCreate = func(host string, brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		templates = append(templates, brick.PathTemplate)
+		return &executors.BrickInfo{Path: "/mockpath"}, nil
+	}
+	v := createSampleVolumeEntry(10)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(templates) != 0)
+	for _, template := range templates {
+		tests.Assert(t, template == "/srv/bricks/{vg}/{brick}", templates)
+	}
+}
+
+func testAppConfig(dbfile string, readOnly bool) *bytes.Buffer {
+	return bytes.NewBuffer([]byte(`{
+		"glusterfs" : {
+			"executor" : "mock",
+			"allocator" : "simple",
+			"read_only" : ` + strconv.FormatBool(readOnly) + `,
+			"db" : "` + dbfile + `"
+		}
+	}`))
+}
+
+func TestAppInvalidDb(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	err := ioutil.WriteFile(tmpfile, bytes.Repeat([]byte("garbage "), 2048), 0600)
+	tests.Assert(t, err == nil)
+
+	_, err = openDb(tmpfile, false)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "not a valid heketi database"), err)
+}
+
+func TestAppTruncatedDb(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	app.Close()
+
+	// Keep the meta pages only
+	err := os.Truncate(tmpfile, 8192)
+	tests.Assert(t, err == nil)
+
+	// The freelist is past the end of the file
+	_, err = openDb(tmpfile, false)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "is damaged"), err)
+}
+
+func TestAppMissingBuckets(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	app.Close()
+
+	db, err := bolt.Open(tmpfile, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	tests.Assert(t, err == nil)
+	err = db.Update(func(tx *bolt.Tx) error {
+		tests.Assert(t, tx.DeleteBucket([]byte(BOLTDB_BUCKET_NODE)) == nil)
+		return tx.DeleteBucket([]byte(BOLTDB_BUCKET_INDEX_NODE_BRICKS))
+	})
+	tests.Assert(t, err == nil)
+	db.Close()
+
+	// Cannot be created read-only
+	app = NewApp(testAppConfig(tmpfile, true))
+	tests.Assert(t, app == nil)
+
+	app = NewApp(testAppConfig(tmpfile, false))
+	tests.Assert(t, app != nil)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		tests.Assert(t, len(dbMissingBuckets(tx)) == 0)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	app.Close()
+}
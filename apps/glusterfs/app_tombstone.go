@@ -0,0 +1,154 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+// Lists the tombstones, only of the type given by the type parameter
+// if set
+func (a *App) TombstoneList(w http.ResponseWriter, r *http.Request) {
+	tombstoneType := r.URL.Query().Get("type")
+	if _, ok := tombstoneBuckets[tombstoneType]; tombstoneType != "" && !ok {
+		http.Error(w, "Unknown tombstone type "+tombstoneType, http.StatusBadRequest)
+		return
+	}
+
+	var list []api.Tombstone
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		var err error
+		list, err = TombstoneList(tx, tombstoneType)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(&api.TombstoneListResponse{
+		Tombstones: list,
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) TombstonePurge(w http.ResponseWriter, r *http.Request) {
+	var msg api.TombstonePurgeRequest
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+	if msg.Days < 0 {
+		http.Error(w, "Days cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	info := &api.TombstonePurgeResponse{}
+	err = a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		var err error
+		info.Purged, err = TombstonePurge(tx, time.Now().AddDate(0, 0, -msg.Days))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	logger.Info("Purged %v tombstones older than %v days", info.Purged, msg.Days)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) TombstoneDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tombstoneType := vars["type"]
+	id := vars["id"]
+
+	if _, ok := tombstoneBuckets[tombstoneType]; !ok {
+		http.Error(w, "Unknown tombstone type "+tombstoneType, http.StatusBadRequest)
+		return
+	}
+
+	err := a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		err := TombstoneDelete(tx, tombstoneType, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	logger.Info("Purged tombstone of %v %v", tombstoneType, id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Puts a deleted cluster or node back in the db
+func (a *App) TombstoneRestore(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tombstoneType := vars["type"]
+	id := vars["id"]
+
+	if tombstoneType != api.TombstoneCluster && tombstoneType != api.TombstoneNode {
+		http.Error(w, "Only clusters and nodes can be restored", http.StatusBadRequest)
+		return
+	}
+
+	err := a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		err := TombstoneRestore(tx, tombstoneType, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	logger.Info("Restored %v %v", tombstoneType, id)
+	w.WriteHeader(http.StatusOK)
+}
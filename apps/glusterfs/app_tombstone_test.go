@@ -0,0 +1,243 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func getTombstones(t *testing.T, url, tombstoneType string) []api.Tombstone {
+	r, err := http.Get(url + "/admin/tombstones?type=" + tombstoneType)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+
+	var list api.TombstoneListResponse
+	err = utils.GetJsonFromResponse(r, &list)
+	tests.Assert(t, err == nil)
+	return list.Tombstones
+}
+
+func postTombstones(t *testing.T, url, path, body string) *http.Response {
+	r, err := http.Post(url+"/admin/tombstones/"+path, "application/json",
+		bytes.NewBuffer([]byte(body)))
+	tests.Assert(t, err == nil)
+	return r
+}
+
+func TestTombstones(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&TombstonesEnabled, true).Restore()
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	// Create a node and delete it with its cluster
+	cluster, err := c.ClusterCreate()
+	tests.Assert(t, err == nil)
+	nodeReq := &api.NodeAddRequest{
+		Zone:      1,
+		ClusterId: cluster.Id,
+	}
+	nodeReq.Hostnames.Manage = sort.StringSlice{"manage.host"}
+	nodeReq.Hostnames.Storage = sort.StringSlice{"storage.host"}
+	node, err := c.NodeAdd(nodeReq)
+	tests.Assert(t, err == nil)
+
+	err = c.NodeDelete(node.Id)
+	tests.Assert(t, err == nil)
+	err = c.ClusterDelete(cluster.Id)
+	tests.Assert(t, err == nil)
+
+	// Deleted entries are not listed
+	clusters, err := c.ClusterList()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(clusters.Clusters) == 0)
+	_, err = c.NodeInfo(node.Id)
+	tests.Assert(t, err != nil)
+
+	list := getTombstones(t, ts.URL, "")
+	tests.Assert(t, len(list) == 2, list)
+	tests.Assert(t, list[0].Type == api.TombstoneCluster)
+	tests.Assert(t, list[0].Id == cluster.Id)
+	tests.Assert(t, list[0].DeletedBy == "127.0.0.1")
+	tests.Assert(t, list[0].DeletedAt > 0)
+	tests.Assert(t, list[1].Type == api.TombstoneNode)
+	tests.Assert(t, list[1].Id == node.Id)
+	tests.Assert(t, list[1].DeletedBy == "127.0.0.1")
+
+	list = getTombstones(t, ts.URL, api.TombstoneNode)
+	tests.Assert(t, len(list) == 1)
+	tests.Assert(t, list[0].Id == node.Id)
+
+	r, err := http.Get(ts.URL + "/admin/tombstones?type=brick")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusBadRequest)
+
+	// The node cannot be restored without its cluster
+	r = postTombstones(t, ts.URL, "node/"+node.Id+"/restore", "")
+	tests.Assert(t, r.StatusCode == http.StatusConflict)
+
+	r = postTombstones(t, ts.URL, "cluster/"+cluster.Id+"/restore", "")
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+	r = postTombstones(t, ts.URL, "node/"+node.Id+"/restore", "")
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+
+	restored, err := c.NodeInfo(node.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, restored.ClusterId == cluster.Id)
+	tests.Assert(t, len(restored.DevicesInfo) == 0)
+	info, err := c.ClusterInfo(cluster.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(info.Nodes) == 1 && info.Nodes[0] == node.Id)
+	tests.Assert(t, len(getTombstones(t, ts.URL, "")) == 0)
+
+	// The hostnames of the restored node are registered again
+	_, err = c.NodeAdd(nodeReq)
+	tests.Assert(t, err != nil)
+
+	// Delete a volume
+	err = setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+	volumeReq := &api.VolumeCreateRequest{}
+	volumeReq.Size = 10
+	volume, err := c.VolumeCreate(volumeReq)
+	tests.Assert(t, err == nil)
+	err = c.VolumeDelete(volume.Id)
+	tests.Assert(t, err == nil)
+
+	list = getTombstones(t, ts.URL, api.TombstoneVolume)
+	tests.Assert(t, len(list) == 1)
+	tests.Assert(t, list[0].Id == volume.Id)
+	tests.Assert(t, list[0].DeletedBy == "127.0.0.1")
+
+	// Volumes are not restored
+	r = postTombstones(t, ts.URL, "volume/"+volume.Id+"/restore", "")
+	tests.Assert(t, r.StatusCode == http.StatusBadRequest)
+
+	// Purge a single tombstone
+	req, err := http.NewRequest("DELETE", ts.URL+"/admin/tombstones/volume/"+volume.Id, nil)
+	tests.Assert(t, err == nil)
+	r, err = http.DefaultClient.Do(req)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+	r, err = http.DefaultClient.Do(req)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusNotFound)
+}
+
+func TestTombstonesPurge(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&TombstonesEnabled, true).Restore()
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	for i := 0; i < 3; i++ {
+		cluster, err := c.ClusterCreate()
+		tests.Assert(t, err == nil)
+		err = c.ClusterDelete(cluster.Id)
+		tests.Assert(t, err == nil)
+	}
+
+	// Nothing is older than a day
+	var purged api.TombstonePurgeResponse
+	r := postTombstones(t, ts.URL, "purge", `{"days" : 1}`)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+	err := utils.GetJsonFromResponse(r, &purged)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, purged.Purged == 0)
+	tests.Assert(t, len(getTombstones(t, ts.URL, "")) == 3)
+
+	r = postTombstones(t, ts.URL, "purge", `{"days" : -1}`)
+	tests.Assert(t, r.StatusCode == http.StatusBadRequest)
+
+	// Purge all
+	r = postTombstones(t, ts.URL, "purge", `{}`)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+	err = utils.GetJsonFromResponse(r, &purged)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, purged.Purged == 3)
+	tests.Assert(t, len(getTombstones(t, ts.URL, "")) == 0)
+}
+
+func TestTombstonesDisabled(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Create a client
+	c := client.NewClientNoAuth(ts.URL)
+	tests.Assert(t, c != nil)
+
+	cluster, err := c.ClusterCreate()
+	tests.Assert(t, err == nil)
+	err = c.ClusterDelete(cluster.Id)
+	tests.Assert(t, err == nil)
+
+	tests.Assert(t, len(getTombstones(t, ts.URL, "")) == 0)
+	r := postTombstones(t, ts.URL, "cluster/"+cluster.Id+"/restore", "")
+	tests.Assert(t, r.StatusCode == http.StatusNotFound)
+}
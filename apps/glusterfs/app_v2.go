@@ -0,0 +1,171 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+// Prefix of the routes of the v2 API, which are the same as the
+// routes without it but return the errors as api.Error
+const API_V2_ROUTE = "/v2"
+
+var (
+	// Codes of the errors known by their message
+	v2MessageCodes = map[string]string{
+		ErrNotFound.Error():           api.ErrorCodeNotFound,
+		ErrNoSpace.Error():            api.ErrorCodeNoSpace,
+		ErrMaxBricks.Error():          api.ErrorCodeMaxBricks,
+		ErrMininumBrickSize.Error():   api.ErrorCodeMinimumBrickSize,
+		ErrStaleEntry.Error():         api.ErrorCodeStaleEntry,
+		ErrVolumeStopped.Error():      api.ErrorCodeVolumeStopped,
+		ErrCancelled.Error():          api.ErrorCodeCancelled,
+		ErrNameInUse.Error():          api.ErrorCodeNameInUse,
+		"request unable to be parsed": api.ErrorCodeUnparsableRequest,
+	}
+
+	// Codes of the conflicts of the routes which fail for a single
+	// reason
+	v2ConflictCodes = map[string]string{
+		"DeviceDelete":  api.ErrorCodeDeviceHasBricks,
+		"NodeDelete":    api.ErrorCodeNodeHasDevices,
+		"ClusterDelete": api.ErrorCodeClusterNotEmpty,
+		"VolumeRename":  api.ErrorCodeNameInUse,
+	}
+)
+
+// Returns the code of the error the route failed with
+func v2ErrorCode(route string, status int, message string) string {
+	if code, ok := v2ConflictCodes[route]; ok && status == http.StatusConflict {
+		return code
+	}
+	if code, ok := v2MessageCodes[message]; ok {
+		return code
+	}
+
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return api.ErrorCodeUnauthorized
+	case status == http.StatusNotFound:
+		return api.ErrorCodeNotFound
+	case status == http.StatusConflict:
+		return api.ErrorCodeConflict
+	case status == http.StatusGone:
+		return api.ErrorCodeGone
+	case status == 422:
+		return api.ErrorCodeUnparsableRequest
+	case status == http.StatusServiceUnavailable:
+		return api.ErrorCodeUnavailable
+	case status >= http.StatusInternalServerError:
+		return api.ErrorCodeInternal
+	default:
+		return api.ErrorCodeInvalidRequest
+	}
+}
+
+// Keeps the body of the error responses to write it as an api.Error
+// once the handler returns, and moves the locations the responses
+// point to under the v2 routes
+type v2ResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+
+	// The error was already written as an api.Error
+	written bool
+}
+
+func (w *v2ResponseWriter) WriteHeader(status int) {
+	location := w.Header().Get("Location")
+	if strings.HasPrefix(location, "/") && !strings.HasPrefix(location, API_V2_ROUTE+"/") {
+		w.Header().Set("Location", API_V2_ROUTE+location)
+	}
+
+	w.status = status
+	if status >= http.StatusBadRequest &&
+		strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.written = true
+	}
+	if status < http.StatusBadRequest || w.written {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *v2ResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status >= http.StatusBadRequest && !w.written {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Writes the error as an api.Error, with the route and ids of the
+// request added to its fields
+func writeApiError(w http.ResponseWriter, r *http.Request, e *api.Error) {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	e.Fields["route"] = requestName(r)
+	for name, value := range mux.Vars(r) {
+		e.Fields[name] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Del("X-Content-Type-Options")
+	w.WriteHeader(e.Status)
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		panic(err)
+	}
+}
+
+// Returns the handler of the route under the v2 routes.  The handler
+// gets the path without the prefix, so that the operations and the
+// audit records are the same for both routes.
+func v2Handler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, API_V2_ROUTE)
+
+		vw := &v2ResponseWriter{ResponseWriter: w}
+		handler(vw, r)
+		if vw.status < http.StatusBadRequest || vw.written {
+			return
+		}
+
+		message := strings.TrimSpace(vw.body.String())
+		writeApiError(w, r, &api.Error{
+			Code:    v2ErrorCode(requestName(r), vw.status, message),
+			Message: message,
+			Status:  vw.status,
+		})
+	}
+}
+
+// Lets clients check the server has the v2 routes
+func (a *App) HelloV2(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "Hello from Heketi")
+}
@@ -0,0 +1,174 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func TestV2ErrorCode(t *testing.T) {
+	tests.Assert(t, v2ErrorCode("VolumeInfo", http.StatusNotFound, "Id not found") ==
+		api.ErrorCodeNotFound)
+	tests.Assert(t, v2ErrorCode("DeviceDelete", http.StatusConflict, "Conflict") ==
+		api.ErrorCodeDeviceHasBricks)
+	tests.Assert(t, v2ErrorCode("DeviceDelete", http.StatusNotFound, "Id not found") ==
+		api.ErrorCodeNotFound)
+	tests.Assert(t, v2ErrorCode("Async", http.StatusInternalServerError, "No space") ==
+		api.ErrorCodeNoSpace)
+	tests.Assert(t, v2ErrorCode("VolumeCreate", 422, "request unable to be parsed") ==
+		api.ErrorCodeUnparsableRequest)
+	tests.Assert(t, v2ErrorCode("VolumeCreate", http.StatusBadRequest, "Invalid size") ==
+		api.ErrorCodeInvalidRequest)
+	tests.Assert(t, v2ErrorCode("VolumeCreate", http.StatusInternalServerError, "boom") ==
+		api.ErrorCodeInternal)
+}
+
+func TestApiErrorIs(t *testing.T) {
+	err := error(&api.Error{Code: api.ErrorCodeDeviceHasBricks, Message: "Conflict"})
+	tests.Assert(t, err.Error() == "Conflict")
+	tests.Assert(t, errors.Is(err, api.ErrDeviceHasBricks))
+	tests.Assert(t, errors.Is(err, api.ErrConflict))
+	tests.Assert(t, !errors.Is(err, api.ErrNodeHasDevices))
+	tests.Assert(t, !errors.Is(err, api.ErrNotFound))
+	tests.Assert(t, !errors.Is(err, errors.New("Conflict")))
+
+	// CONFLICT does not refine CONFLICT_DEVICE_HAS_BRICKS
+	err = &api.Error{Code: api.ErrorCodeConflict}
+	tests.Assert(t, !errors.Is(err, api.ErrDeviceHasBricks))
+}
+
+func TestV2Routes(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// The errors of the routes without prefix are unchanged
+	r, err := http.Get(ts.URL + "/volumes/123")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusNotFound)
+	s, err := utils.GetStringFromResponse(r)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, strings.TrimSpace(s) == "Id not found", s)
+
+	// The v2 routes return them as api.Error
+	r, err = http.Get(ts.URL + "/v2/volumes/123")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusNotFound)
+	tests.Assert(t, strings.HasPrefix(r.Header.Get("Content-Type"), "application/json"))
+	var e api.Error
+	err = utils.GetJsonFromResponse(r, &e)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, e.Code == api.ErrorCodeNotFound, e.Code)
+	tests.Assert(t, e.Message == "Id not found", e.Message)
+	tests.Assert(t, e.Status == http.StatusNotFound)
+	tests.Assert(t, e.Fields["id"] == "123", e.Fields)
+	tests.Assert(t, e.Fields["route"] == "VolumeInfo", e.Fields)
+
+	// The client uses the v2 routes, including for the operations
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	volume, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+
+	ops, err := c.AsyncOperationList()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(ops.Operations) == 1)
+	info, err := c.AsyncOperationInfo(ops.Operations[0].Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Path == "/volumes", info.Path)
+
+	_, err = c.VolumeInfo("123")
+	tests.Assert(t, errors.Is(err, api.ErrNotFound), err)
+	tests.Assert(t, err.Error() == "Id not found", err)
+
+	err = c.DeviceDelete(volume.Bricks[0].DeviceId)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, errors.Is(err, api.ErrDeviceHasBricks), err)
+	tests.Assert(t, errors.Is(err, api.ErrConflict), err)
+	var apiErr *api.Error
+	tests.Assert(t, errors.As(err, &apiErr))
+	tests.Assert(t, apiErr.Fields["id"] == volume.Bricks[0].DeviceId, apiErr.Fields)
+
+	// The errors of the operations too
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		return nil, ErrNoSpace
+	}
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, errors.Is(err, api.ErrNoSpace), err)
+}
+
+func TestV2ClientWithoutV2(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// A server without the v2 routes
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, API_V2_ROUTE+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		router.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+	_, err := c.ClusterCreate()
+	tests.Assert(t, err == nil, err)
+
+	_, err = c.ClusterInfo("123")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.TrimSpace(err.Error()) == "Id not found", err)
+	tests.Assert(t, !errors.Is(err, api.ErrNotFound))
+}
@@ -19,7 +19,9 @@ package glusterfs
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
@@ -31,13 +33,39 @@ const (
 	VOLUME_CREATE_MAX_SNAPSHOT_FACTOR = 100
 )
 
-func (a *App) VolumeCreate(w http.ResponseWriter, r *http.Request) {
+// Reads and checks a request to create a volume, and writes the
+// error to the response if it is not valid
+func (a *App) volumeCreateRequest(w http.ResponseWriter,
+	r *http.Request) (*api.VolumeCreateRequest, bool) {
 
 	var msg api.VolumeCreateRequest
 	err := utils.GetJsonFromRequest(r, &msg)
 	if err != nil {
 		http.Error(w, "request unable to be parsed", 422)
-		return
+		return nil, false
+	}
+
+	// The disperse counts may also be given outside of the durability
+	if msg.DisperseData != 0 || msg.DisperseRedundancy != 0 {
+		switch msg.Durability.Type {
+		case "":
+			msg.Durability.Type = api.DurabilityEC
+		case api.DurabilityEC:
+		default:
+			http.Error(w, "Disperse counts given for a volume of durability type "+
+				string(msg.Durability.Type), http.StatusBadRequest)
+			return nil, false
+		}
+
+		d := &msg.Durability.Disperse
+		if (d.Data != 0 && d.Data != msg.DisperseData) ||
+			(d.Redundancy != 0 && d.Redundancy != msg.DisperseRedundancy) {
+			http.Error(w, "Disperse counts do not match the durability",
+				http.StatusBadRequest)
+			return nil, false
+		}
+		d.Data = msg.DisperseData
+		d.Redundancy = msg.DisperseRedundancy
 	}
 
 	// Check durability type
@@ -49,29 +77,85 @@ func (a *App) VolumeCreate(w http.ResponseWriter, r *http.Request) {
 		msg.Durability.Type = api.DurabilityDistributeOnly
 	default:
 		http.Error(w, "Unknown durability type", http.StatusBadRequest)
-		return
+		return nil, false
+	}
+
+	// Check the durability the volume will have, not the request
+	switch msg.Durability.Type {
+	case api.DurabilityEC:
+		d := NewVolumeDisperseDurability(&msg.Durability.Disperse)
+		d.SetDurability()
+		msg.Durability.Disperse = d.DisperseDurability
+	case api.DurabilityReplicate:
+		d := NewVolumeReplicaDurability(&msg.Durability.Replicate)
+		d.SetDurability()
+		msg.Durability.Replicate = d.ReplicaDurability
 	}
 
 	// Check the message has devices
 	if msg.Size < 1 {
 		http.Error(w, "Invalid volume size", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 	if msg.Snapshot.Enable {
 		if msg.Snapshot.Factor < 1 || msg.Snapshot.Factor > VOLUME_CREATE_MAX_SNAPSHOT_FACTOR {
 			http.Error(w, "Invalid snapshot factor", http.StatusBadRequest)
-			return
+			return nil, false
 		}
 	}
 
+	// Check storage class
+	if !isValidStorageClass(msg.StorageClass) {
+		http.Error(w, "Unknown storage class", http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Check data locality
+	if !isValidDataLocality(msg.DataLocality) {
+		http.Error(w, "Unknown data locality", http.StatusBadRequest)
+		return nil, false
+	}
+	if msg.DataLocality != "" && msg.DataLocality != api.VolumeDataLocalityNone &&
+		msg.PreferredNodeId == "" {
+		http.Error(w, "Data locality "+msg.DataLocality+" requires a preferred node",
+			http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Check protocol
+	if !isValidVolumeProtocol(msg.Protocol) {
+		http.Error(w, "Unknown protocol "+msg.Protocol, http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Check WORM
+	if err := validateWORM(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
 	// Check replica values
 	if msg.Durability.Type == api.DurabilityReplicate {
 		if msg.Durability.Replicate.Replica > 3 {
 			http.Error(w, "Invalid replica value", http.StatusBadRequest)
-			return
+			return nil, false
 		}
 	}
 
+	// Check arbiter
+	switch msg.ArbiterCount {
+	case 0:
+	case 1:
+		if msg.Durability.Type != api.DurabilityReplicate ||
+			msg.Durability.Replicate.Replica != 3 {
+			http.Error(w, "An arbiter requires replica 3", http.StatusBadRequest)
+			return nil, false
+		}
+	default:
+		http.Error(w, "Invalid arbiter count", http.StatusBadRequest)
+		return nil, false
+	}
+
 	// Check Disperse combinations
 	if msg.Durability.Type == api.DurabilityEC {
 		d := msg.Durability.Disperse
@@ -84,12 +168,12 @@ func (a *App) VolumeCreate(w http.ResponseWriter, r *http.Request) {
 			http.Error(w,
 				fmt.Sprintf("Invalid dispersion combination: %v+%v", d.Data, d.Redundancy),
 				http.StatusBadRequest)
-			return
+			return nil, false
 		}
 	}
 
 	// Check that the clusters requested are avilable
-	err = a.db.View(func(tx *bolt.Tx) error {
+	err = a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 
 		// Check we have clusters
 		// :TODO: All we need to do is check for one instead of gathering all keys
@@ -112,26 +196,117 @@ func (a *App) VolumeCreate(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		// Each brick of a disperse set must be on a different node
+		if msg.Durability.Type == api.DurabilityEC {
+			if len(msg.Clusters) != 0 {
+				clusters = msg.Clusters
+			}
+			err := checkDisperseNodes(tx, clusters, msg.Durability.Disperse)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return err
+			}
+		}
+
+		// The preferred node must be able to hold bricks with strict
+		// locality
+		if msg.PreferredNodeId != "" {
+			node, err := NewNodeEntryFromId(tx, msg.PreferredNodeId)
+			if err == ErrNotFound {
+				http.Error(w, fmt.Sprintf("Preferred node %v not found", msg.PreferredNodeId),
+					http.StatusBadRequest)
+				return err
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return err
+			}
+			if msg.DataLocality == api.VolumeDataLocalityStrict {
+				if !node.isOnline() {
+					http.Error(w, fmt.Sprintf("Preferred node %v is not online", node.Info.Id),
+						http.StatusBadRequest)
+					return ErrConflict
+				}
+				inClusters := len(msg.Clusters) == 0
+				for _, id := range msg.Clusters {
+					if id == node.Info.ClusterId {
+						inClusters = true
+					}
+				}
+				if !inClusters {
+					http.Error(w, fmt.Sprintf("Preferred node %v is not in the clusters requested",
+						node.Info.Id), http.StatusBadRequest)
+					return ErrConflict
+				}
+			}
+		}
+
+		// Each brick of a set must be in a different affinity group
+		var setSize int
+		switch msg.Durability.Type {
+		case api.DurabilityEC:
+			setSize = msg.Durability.Disperse.Data + msg.Durability.Disperse.Redundancy
+		case api.DurabilityReplicate:
+			setSize = msg.Durability.Replicate.Replica
+		}
+		if setSize > 1 {
+			if len(msg.Clusters) != 0 {
+				clusters = msg.Clusters
+			}
+			for _, clusterId := range clusters {
+				err = CanPlaceAcrossGroups(tx, clusterId, setSize)
+				if err == nil {
+					break
+				}
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return err
+			}
+		}
+
 		return nil
 	})
 	if err != nil {
+		return nil, false
+	}
+
+	return &msg, true
+}
+
+func (a *App) VolumeCreate(w http.ResponseWriter, r *http.Request) {
+	msg, ok := a.volumeCreateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Check CHAP secrets can be encrypted
+	if msg.CHAPAuth && chapSecretKey == nil {
+		http.Error(w, ErrCHAPNotConfigured.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Create a volume entry
-	vol := NewVolumeEntryFromRequest(&msg)
+	vol := NewVolumeEntryFromRequest(msg)
+	if vol.Info.CHAPAuth {
+		err := vol.setCHAPSecret()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	// Add device in an asynchronous function
-	a.asyncManager.AsyncHttpRedirectFunc(w, r, func() (string, error) {
+	a.asyncCancellableHttpRedirectFunc(w, r, []string{vol.Info.Id}, func(cancel *operationCancel) (string, error) {
 
-		logger.Info("Creating volume %v", vol.Info.Id)
-		err := vol.Create(a.db, a.executor, a.allocator)
+		requestLogger(r).Info("Creating volume %v", vol.Info.Id)
+		vol.cancel = cancel
+		err := vol.Create(a.db, a.requestExecutor(r), a.allocator)
 		if err != nil {
-			logger.LogError("Failed to create volume: %v", err)
+			requestLogger(r).LogError("Failed to create volume: %v", err)
 			return "", err
 		}
 
-		logger.Info("Created volume %v", vol.Info.Id)
+		requestLogger(r).Info("Created volume %v", vol.Info.Id)
 
 		// Done
 		return "/volumes/" + vol.Info.Id, nil
@@ -139,15 +314,49 @@ func (a *App) VolumeCreate(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Returns where the bricks of the volume requested would be placed,
+// without creating anything
+func (a *App) VolumeDryRun(w http.ResponseWriter, r *http.Request) {
+	msg, ok := a.volumeCreateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	vol := NewVolumeEntryFromRequest(msg)
+	result, err := vol.DryRun(a.db, a.allocator)
+	switch err {
+	case nil:
+	case ErrNoSpace, ErrMaxBricks, ErrMininumBrickSize:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		requestLogger(r).LogError("Failed to place volume: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		panic(err)
+	}
+}
+
 func (a *App) VolumeList(w http.ResponseWriter, r *http.Request) {
 
 	var list api.VolumeListResponse
 
-	// Get all the cluster ids from the DB
-	err := a.db.View(func(tx *bolt.Tx) error {
+	page, err := newListPageFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get the volume ids of the page from the DB
+	err = a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 		var err error
 
-		list.Volumes, err = VolumeList(tx)
+		list.Volumes, list.Continue, err = page.keys(tx, BOLTDB_BUCKET_VOLUME, nil)
 		if err != nil {
 			return err
 		}
@@ -156,7 +365,7 @@ func (a *App) VolumeList(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if err != nil {
-		logger.Err(err)
+		requestLogger(r).Err(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -177,7 +386,7 @@ func (a *App) VolumeInfo(w http.ResponseWriter, r *http.Request) {
 
 	// Get device information
 	var info *api.VolumeInfoResponse
-	err := a.db.View(func(tx *bolt.Tx) error {
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 		entry, err := NewVolumeEntryFromId(tx, id)
 		if err == ErrNotFound {
 			http.Error(w, "Id not found", http.StatusNotFound)
@@ -208,6 +417,61 @@ func (a *App) VolumeInfo(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func (a *App) VolumeMount(w http.ResponseWriter, r *http.Request) {
+
+	// Get the id from the URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// The client address is optional, and linux is the default
+	query := r.URL.Query()
+	os := query.Get("os")
+	if os == "" {
+		os = api.MountOsLinux
+	}
+	client := query.Get("client")
+	if client != "" && net.ParseIP(client) == nil {
+		http.Error(w, "Invalid client address "+client, http.StatusBadRequest)
+		return
+	}
+
+	var mount *api.VolumeMountResponse
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		entry, err := NewVolumeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		mount, err = entry.MountCommand(os, client)
+		if err == ErrUnknownMountOs {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		} else if err == ErrNoMountHosts || err == ErrVolumeStopped {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(mount); err != nil {
+		panic(err)
+	}
+}
+
 func (a *App) VolumeDelete(w http.ResponseWriter, r *http.Request) {
 	// Get the id from the URL
 	vars := mux.Vars(r)
@@ -215,7 +479,7 @@ func (a *App) VolumeDelete(w http.ResponseWriter, r *http.Request) {
 
 	// Get volume entry
 	var volume *VolumeEntry
-	err := a.db.View(func(tx *bolt.Tx) error {
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 
 		// Access volume entry
 		var err error
@@ -228,6 +492,13 @@ func (a *App) VolumeDelete(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
+		// WORM volumes are kept for their retention
+		err = volume.checkWORMRetention(time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return err
+		}
+
 		return nil
 
 	})
@@ -235,21 +506,23 @@ func (a *App) VolumeDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.asyncManager.AsyncHttpRedirectFunc(w, r, func() (string, error) {
+	volume.deletedBy = requestUser(r)
+	a.asyncHttpRedirectFunc(w, r, []string{id}, func(cancel *operationCancel) (string, error) {
 
 		// Actually destroy the Volume here
-		err := volume.Destroy(a.db, a.executor)
+		volume.cancel = cancel
+		err := volume.Destroy(a.db, a.requestExecutor(r))
 
 		// If it fails for some reason, we will need to add to the DB again
 		// or hold state on the entry "DELETING"
 
 		// Show that the key has been deleted
 		if err != nil {
-			logger.LogError("Failed to delete volume %v: %v", volume.Info.Id, err)
+			requestLogger(r).LogError("Failed to delete volume %v: %v", volume.Info.Id, err)
 			return "", err
 		}
 
-		logger.Info("Deleted volume [%s]", id)
+		requestLogger(r).Info("Deleted volume [%s]", id)
 		return "", nil
 
 	})
@@ -257,7 +530,7 @@ func (a *App) VolumeDelete(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *App) VolumeExpand(w http.ResponseWriter, r *http.Request) {
-	logger.Debug("In VolumeExpand")
+	requestLogger(r).Debug("In VolumeExpand")
 
 	// Get the id from the URL
 	vars := mux.Vars(r)
@@ -269,18 +542,18 @@ func (a *App) VolumeExpand(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "request unable to be parsed", 422)
 		return
 	}
-	logger.Debug("Msg: %v", msg)
+	requestLogger(r).Debug("Msg: %v", msg)
 
 	// Check the message
 	if msg.Size < 1 {
 		http.Error(w, "Invalid volume size", http.StatusBadRequest)
 		return
 	}
-	logger.Debug("Size: %v", msg.Size)
+	requestLogger(r).Debug("Size: %v", msg.Size)
 
 	// Get volume entry
 	var volume *VolumeEntry
-	err = a.db.View(func(tx *bolt.Tx) error {
+	err = a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
 
 		// Access volume entry
 		var err error
@@ -293,6 +566,11 @@ func (a *App) VolumeExpand(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
+		if !volume.IsStarted() {
+			http.Error(w, ErrVolumeStopped.Error(), http.StatusConflict)
+			return ErrVolumeStopped
+		}
+
 		return nil
 
 	})
@@ -301,19 +579,307 @@ func (a *App) VolumeExpand(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Expand device in an asynchronous function
-	a.asyncManager.AsyncHttpRedirectFunc(w, r, func() (string, error) {
+	a.asyncCancellableHttpRedirectFunc(w, r, []string{id}, func(cancel *operationCancel) (string, error) {
 
-		logger.Info("Expanding volume %v", volume.Info.Id)
-		err := volume.Expand(a.db, a.executor, a.allocator, msg.Size)
+		requestLogger(r).Info("Expanding volume %v", volume.Info.Id)
+		volume.cancel = cancel
+		err := volume.Expand(a.db, a.requestExecutor(r), a.allocator, msg.Size)
 		if err != nil {
-			logger.LogError("Failed to expand volume %v", volume.Info.Id)
+			requestLogger(r).LogError("Failed to expand volume %v", volume.Info.Id)
 			return "", err
 		}
 
-		logger.Info("Expanded volume %v", volume.Info.Id)
+		requestLogger(r).Info("Expanded volume %v", volume.Info.Id)
 
 		// Done
 		return "/volumes/" + volume.Info.Id, nil
 	})
 
 }
+
+// Removes bricks from the volume, once their data has moved to the
+// other bricks
+func (a *App) VolumeShrink(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var msg api.VolumeShrinkRequest
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	if msg.Size < 1 {
+		http.Error(w, "Invalid volume size", http.StatusBadRequest)
+		return
+	}
+
+	var volume *VolumeEntry
+	err = a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		var err error
+		volume, err = NewVolumeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		if !volume.IsStarted() {
+			http.Error(w, ErrVolumeStopped.Error(), http.StatusConflict)
+			return ErrVolumeStopped
+		}
+		if msg.Size >= volume.Info.Size {
+			http.Error(w, ErrShrinkBelowMinimum.Error(), http.StatusBadRequest)
+			return ErrShrinkBelowMinimum
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	a.asyncCancellableHttpRedirectFunc(w, r, []string{id}, func(cancel *operationCancel) (string, error) {
+		requestLogger(r).Info("Shrinking volume %v by %v GB", volume.Info.Id, msg.Size)
+		volume.cancel = cancel
+		err := volume.Shrink(a.db, a.requestExecutor(r), msg.Size)
+		if err != nil {
+			requestLogger(r).LogError("Failed to shrink volume %v: %v", volume.Info.Id, err)
+			return "", err
+		}
+
+		requestLogger(r).Info("Shrunk volume %v to %v GB", volume.Info.Id, volume.Info.Size)
+		return "/volumes/" + volume.Info.Id, nil
+	})
+}
+
+func (a *App) VolumeConsistencyCheck(w http.ResponseWriter, r *http.Request) {
+
+	// Get volume id from URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Get volume entry
+	var volume *VolumeEntry
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		var err error
+		volume, err = NewVolumeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Compare with GlusterFS
+	report, err := volume.ConsistencyCheck(a.db, a.requestExecutor(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		panic(err)
+	}
+}
+
+// Returns the CHAP credentials of the volume.  The secret can only be
+// retrieved once.
+func (a *App) VolumeCHAPCredentials(w http.ResponseWriter, r *http.Request) {
+
+	// Get volume id from URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var creds *api.VolumeCHAPCredentials
+	err := a.dbUpdate(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		volume, err := NewVolumeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		if !volume.Info.CHAPAuth {
+			http.Error(w, "Volume does not use CHAP authentication", http.StatusNotFound)
+			return ErrNotFound
+		}
+		if volume.CHAPSecretRetrieved {
+			http.Error(w, "CHAP secret was already retrieved", http.StatusGone)
+			return ErrConflict
+		}
+
+		creds, err = volume.CHAPCredentials()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		volume.CHAPSecretRetrieved = true
+		err = volume.Save(tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(creds); err != nil {
+		panic(err)
+	}
+}
+
+// Changes the name of the volume in heketi
+func (a *App) VolumeRename(w http.ResponseWriter, r *http.Request) {
+	var msg api.VolumeRenameRequest
+	err := utils.GetJsonFromRequest(r, &msg)
+	if err != nil {
+		http.Error(w, "request unable to be parsed", 422)
+		return
+	}
+
+	err = validateVolumeName(msg.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get volume id from URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var volume *VolumeEntry
+	err = a.db.View(func(tx *bolt.Tx) error {
+		var err error
+		volume, err = NewVolumeEntryFromId(tx, id)
+		return err
+	})
+	if err == nil {
+		err = volume.Rename(a.db, msg.Name)
+	}
+	if err == ErrNotFound {
+		http.Error(w, "Id not found", http.StatusNotFound)
+		return
+	} else if err == ErrNameInUse {
+		http.Error(w, fmt.Sprintf("Name %v is already in use in cluster %v",
+			msg.Name, volume.Info.Cluster), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("%v renamed volume %v to %v", requestUser(r), id, msg.Name)
+
+	var info *api.VolumeInfoResponse
+	err = a.db.View(func(tx *bolt.Tx) error {
+		var err error
+		info, err = volume.NewInfoResponse(tx)
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write msg
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		panic(err)
+	}
+}
+
+// Runs fn with the volume of the request
+func (a *App) volumeProfile(w http.ResponseWriter, r *http.Request,
+	fn func(volume *VolumeEntry) error) {
+
+	// Get volume id from URL
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Get volume entry
+	var volume *VolumeEntry
+	err := a.dbView(w, r, func(w http.ResponseWriter, tx *bolt.Tx) error {
+		var err error
+		volume, err = NewVolumeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			http.Error(w, "Id not found", http.StatusNotFound)
+			return err
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	err = fn(volume)
+	if err == ErrVolumeStopped {
+		http.Error(w, err.Error(), http.StatusConflict)
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (a *App) VolumeProfileStart(w http.ResponseWriter, r *http.Request) {
+	a.volumeProfile(w, r, func(volume *VolumeEntry) error {
+		err := volume.ProfileStart(a.db, a.requestExecutor(r))
+		if err != nil {
+			return err
+		}
+		requestLogger(r).Info("Started profiling volume %v", volume.Info.Id)
+		return nil
+	})
+}
+
+func (a *App) VolumeProfileStop(w http.ResponseWriter, r *http.Request) {
+	a.volumeProfile(w, r, func(volume *VolumeEntry) error {
+		err := volume.ProfileStop(a.db, a.requestExecutor(r))
+		if err != nil {
+			return err
+		}
+		requestLogger(r).Info("Stopped profiling volume %v", volume.Info.Id)
+		return nil
+	})
+}
+
+func (a *App) VolumeProfileInfo(w http.ResponseWriter, r *http.Request) {
+	a.volumeProfile(w, r, func(volume *VolumeEntry) error {
+		info, err := volume.ProfileInfo(a.db, a.requestExecutor(r))
+		if err != nil {
+			return err
+		}
+
+		// Write msg
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(info); err != nil {
+			panic(err)
+		}
+		return nil
+	})
+}
@@ -18,6 +18,7 @@ package glusterfs
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -30,6 +31,8 @@ import (
 
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/executors"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/heketi/heketi/pkg/utils"
 	"github.com/heketi/tests"
@@ -206,6 +209,44 @@ func TestVolumeCreateBadReplicaValues(t *testing.T) {
 	tests.Assert(t, strings.Contains(string(body), "Invalid replica value"))
 }
 
+func TestVolumeCreateBadArbiterValues(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	for _, request := range []struct {
+		body, message string
+	}{
+		{`{"size": 100, "arbiter_count": 2,
+			"durability": {"type": "replicate", "replicate": {"replica": 3}}}`,
+			"Invalid arbiter count"},
+		{`{"size": 100, "arbiter_count": 1,
+			"durability": {"type": "replicate", "replicate": {"replica": 2}}}`,
+			"An arbiter requires replica 3"},
+		{`{"size": 100, "arbiter_count": 1,
+			"durability": {"type": "disperse", "disperse": {"data": 4, "redundancy": 2}}}`,
+			"An arbiter requires replica 3"},
+	} {
+		r, err := http.Post(ts.URL+"/volumes", "application/json",
+			bytes.NewBufferString(request.body))
+		tests.Assert(t, err == nil)
+		tests.Assert(t, r.StatusCode == http.StatusBadRequest, r.StatusCode)
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+		tests.Assert(t, err == nil)
+		r.Body.Close()
+		tests.Assert(t, strings.Contains(string(body), request.message), string(body))
+	}
+}
+
 func TestVolumeCreateBadDispersionValues(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -437,6 +478,178 @@ func TestVolumeCreate(t *testing.T) {
 	tests.Assert(t, info.Durability.Type == api.DurabilityDistributeOnly)
 }
 
+func TestVolumeDryRun(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Free space of each device
+	freeSpace := func() map[string]uint64 {
+		free := make(map[string]uint64)
+		err := app.db.View(func(tx *bolt.Tx) error {
+			ids, err := DeviceList(tx)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				device, err := NewDeviceEntryFromId(tx, id)
+				if err != nil {
+					return err
+				}
+				free[id] = device.Info.Storage.Free
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil, err)
+		return free
+	}
+	before := freeSpace()
+
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 100
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+
+	result, err := c.VolumeDryRun(req)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, result.Cluster != "")
+	tests.Assert(t, len(result.Bricks)%2 == 0, result.Bricks)
+	size := uint64(0)
+	for _, brick := range result.Bricks {
+		tests.Assert(t, brick.NodeId != "")
+		tests.Assert(t, brick.DeviceId != "")
+		tests.Assert(t, !brick.Arbiter)
+		size += brick.Size
+	}
+	tests.Assert(t, size == 2*100*GB, size)
+
+	// The bricks of each replica set are on different nodes
+	for i := 0; i < len(result.Bricks); i += 2 {
+		tests.Assert(t, result.Bricks[i].NodeId != result.Bricks[i+1].NodeId)
+	}
+
+	// Nothing was created or reserved
+	tests.Assert(t, reflect.DeepEqual(before, freeSpace()))
+	err = app.db.View(func(tx *bolt.Tx) error {
+		volumes, err := VolumeList(tx)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, len(volumes) == 0, volumes)
+		bricks, err := BrickList(tx)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, len(bricks) == 0, bricks)
+		return nil
+	})
+	tests.Assert(t, err == nil, err)
+
+	// A volume larger than the cluster cannot be placed
+	req.Size = 5 * 1024
+	_, err = c.VolumeDryRun(req)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), ErrNoSpace.Error()), err)
+
+	// The request is checked as for a create
+	req.Size = 100
+	req.Durability.Replicate.Replica = 4
+	_, err = c.VolumeDryRun(req)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Invalid replica value"), err)
+}
+
+func TestVolumeCHAPCredentials(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.CHAPAuth = true
+
+	// CHAP requires a key to encrypt the secrets
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "chap_secret_key"), err)
+
+	defer tests.Patch(&chapSecretKey, chapSecretKey).Restore()
+	setCHAPSecretKey("mykey")
+
+	// The executor gets the credentials
+	var vr *executors.VolumeRequest
+	app.xo.MockVolumeCreate = func(host string,
+		volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		vr = volume
+		return &executors.VolumeInfo{}, nil
+	}
+	info, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.CHAPAuth)
+	tests.Assert(t, info.CHAPUsername == info.Name)
+	tests.Assert(t, vr.CHAPUsername == info.Name)
+	tests.Assert(t, len(vr.CHAPSecret) == 16, vr.CHAPSecret)
+
+	// The secret is not stored in clear
+	err = app.db.View(func(tx *bolt.Tx) error {
+		v, err := NewVolumeEntryFromId(tx, info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, !bytes.Contains(v.CHAPSecret, []byte(vr.CHAPSecret)))
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// The secret is only returned once
+	creds, err := c.VolumeCHAPCredentials(info.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, creds.Username == info.Name)
+	tests.Assert(t, creds.Secret == vr.CHAPSecret)
+
+	r, err := http.Get(ts.URL + "/volumes/" + info.Id + "/chap-credentials")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusGone)
+
+	// Volume without CHAP
+	req.CHAPAuth = false
+	info, err = c.VolumeCreate(req)
+	tests.Assert(t, err == nil)
+	r, err = http.Get(ts.URL + "/volumes/" + info.Id + "/chap-credentials")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusNotFound)
+}
+
 func TestVolumeInfoIdNotFound(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -513,6 +726,96 @@ func TestVolumeInfo(t *testing.T) {
 	}
 }
 
+func TestVolumeConsistencyCheck(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Id not found
+	r, err := http.Post(ts.URL+"/volumes/12345/consistency-check", "application/json", nil)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusNotFound)
+
+	// Setup database
+	err = setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Keep the bricks GlusterFS was asked to use
+	var bricks []executors.BrickInfo
+	app.xo.MockBrickCreate = func(host string,
+		brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+		return &executors.BrickInfo{Path: "/mockpath/" + brick.Name}, nil
+	}
+	app.xo.MockVolumeCreate = func(host string,
+		volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		bricks = volume.Bricks
+		return &executors.VolumeInfo{}, nil
+	}
+	app.xo.MockVolumeInfo = func(host, volume string) (*executors.VolumeInfo, error) {
+		return &executors.VolumeInfo{Bricks: bricks}, nil
+	}
+
+	// Create a volume
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+
+	// Check consistent volume
+	r, err = http.Post(ts.URL+"/volumes/"+v.Info.Id+"/consistency-check", "application/json", nil)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+
+	var report ConsistencyReport
+	err = utils.GetJsonFromResponse(r, &report)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, report.Id == v.Info.Id)
+	tests.Assert(t, report.Consistent)
+	tests.Assert(t, report.DbBricks == len(v.Bricks))
+	tests.Assert(t, report.GlusterBricks == len(v.Bricks))
+	tests.Assert(t, len(report.MissingBricks) == 0)
+	tests.Assert(t, len(report.UnknownBricks) == 0)
+
+	// GlusterFS lost a brick and has one heketi does not know about
+	missing := bricks[0]
+	bricks = append([]executors.BrickInfo{
+		executors.BrickInfo{Host: "otherhost", Path: "/other"},
+	}, bricks[1:]...)
+
+	r, err = http.Post(ts.URL+"/volumes/"+v.Info.Id+"/consistency-check", "application/json", nil)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+
+	err = utils.GetJsonFromResponse(r, &report)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, !report.Consistent)
+	tests.Assert(t, len(report.MissingBricks) == 1)
+	tests.Assert(t, report.MissingBricks[0] == missing.Host+":"+missing.Path)
+	tests.Assert(t, len(report.UnknownBricks) == 1)
+	tests.Assert(t, report.UnknownBricks[0] == "otherhost:/other")
+
+	// Executor failure
+	app.xo.MockVolumeInfo = func(host, volume string) (*executors.VolumeInfo, error) {
+		return nil, ErrDbAccess
+	}
+	r, err = http.Post(ts.URL+"/volumes/"+v.Info.Id+"/consistency-check", "application/json", nil)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusInternalServerError)
+}
+
 func TestVolumeListEmpty(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -766,6 +1069,49 @@ func TestVolumeExpandSizeTooSmall(t *testing.T) {
 	tests.Assert(t, strings.Contains(string(body), "Invalid volume size"))
 }
 
+func TestVolumeExpandStopped(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		2*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Create a volume and stop it for maintenance
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		v.SetStarted(false)
+		return v.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	r, err := http.Post(ts.URL+"/volumes/"+v.Info.Id+"/expand",
+		"application/json",
+		bytes.NewBuffer([]byte(`{"expand_size" : 100}`)))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusConflict, r.StatusCode)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+	tests.Assert(t, err == nil)
+	r.Body.Close()
+	tests.Assert(t, strings.Contains(string(body), "Volume is stopped"), string(body))
+}
+
 func TestVolumeExpand(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -832,3 +1178,941 @@ func TestVolumeExpand(t *testing.T) {
 	tests.Assert(t, info.Size == 100+1000)
 	tests.Assert(t, len(vc.Bricks) < len(info.Bricks))
 }
+
+func TestVolumeCreateDisperse(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		5,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 100
+	req.DisperseData = 4
+	req.DisperseRedundancy = 2
+
+	// 4+2 needs a node for each brick of a set
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "requires 6 available nodes"), err)
+
+	// Also checked for the default dispersion
+	defaulted := &api.VolumeCreateRequest{}
+	defaulted.Size = 100
+	defaulted.Durability.Type = api.DurabilityEC
+	_, err = c.VolumeCreate(defaulted)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Dispersion 4+2 requires 6 available nodes"), err)
+
+	// Add a sixth node
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		tests.Assert(t, err == nil)
+
+		node := createSampleNodeEntry()
+		node.Info.ClusterId = cluster.Info.Id
+		cluster.NodeAdd(node.Info.Id)
+		device := createSampleDeviceEntry(node.Info.Id, 1*TB)
+		node.DeviceAdd(device.Info.Id)
+		tests.Assert(t, app.allocator.AddDevice(cluster, node, device) == nil)
+
+		tests.Assert(t, device.Save(tx) == nil)
+		tests.Assert(t, node.Save(tx) == nil)
+		return cluster.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	var vr *executors.VolumeRequest
+	app.xo.MockVolumeCreate = func(host string,
+		volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		vr = volume
+		return &executors.VolumeInfo{}, nil
+	}
+	info, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Durability.Type == api.DurabilityEC)
+	tests.Assert(t, info.Durability.Disperse.Data == 4)
+	tests.Assert(t, info.Durability.Disperse.Redundancy == 2)
+	tests.Assert(t, info.DisperseData == 4)
+	tests.Assert(t, info.DisperseRedundancy == 2)
+	tests.Assert(t, vr.Type == executors.DurabilityDispersion)
+	tests.Assert(t, vr.Data == 4 && vr.Redundancy == 2)
+	tests.Assert(t, len(vr.Bricks)%6 == 0, len(vr.Bricks))
+
+	// Only for disperse volumes
+	req.Durability.Type = api.DurabilityReplicate
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err != nil)
+
+	// Must match the durability
+	req.Durability.Type = api.DurabilityEC
+	req.Durability.Disperse.Data = 8
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "do not match"), err)
+
+	// Still a valid combination
+	req.Durability.Disperse.Data = 0
+	req.DisperseData = 4
+	req.DisperseRedundancy = 3
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Invalid dispersion combination"), err)
+}
+
+func TestVolumeMount(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	info, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+
+	mount, err := c.VolumeMount(info.Id, "", "")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, mount.Os == api.MountOsLinux)
+	tests.Assert(t, mount.Server == info.Mount.GlusterFS.Hosts[0])
+	tests.Assert(t, mount.Protocol == api.VolumeProtocolGlusterFS)
+	tests.Assert(t, strings.HasPrefix(mount.Command, "mount -t glusterfs "+
+		"-o backup-volfile-servers="), mount.Command)
+	tests.Assert(t, strings.HasSuffix(mount.Command,
+		mount.Server+":/"+info.Name+" /mnt/"+info.Name), mount.Command)
+
+	mount, err = c.VolumeMount(info.Id, api.MountOsWindows, "192.168.10.1")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, mount.Os == api.MountOsWindows)
+	tests.Assert(t, strings.HasPrefix(mount.Command, "mount -o nolock "), mount.Command)
+
+	_, err = c.VolumeMount(info.Id, "plan9", "")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Unknown operating system"), err)
+
+	_, err = c.VolumeMount(info.Id, "", "nothost")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Invalid client address"), err)
+
+	_, err = c.VolumeMount("12345", "", "")
+	tests.Assert(t, err != nil)
+}
+
+func TestVolumeRename(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Name = "project-a"
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	volume, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+	req.Name = "other"
+	other, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+
+	// Invalid names
+	_, err = c.VolumeRename(volume.Id, &api.VolumeRenameRequest{})
+	tests.Assert(t, err != nil)
+	_, err = c.VolumeRename(volume.Id, &api.VolumeRenameRequest{Name: "a/b"})
+	tests.Assert(t, err != nil)
+	_, err = c.VolumeRename("12345", &api.VolumeRenameRequest{Name: "project-b"})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Id not found"), err)
+
+	// Used by another volume
+	_, err = c.VolumeRename(volume.Id, &api.VolumeRenameRequest{Name: "other"})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "already in use"), err)
+
+	info, err := c.VolumeRename(volume.Id, &api.VolumeRenameRequest{Name: "project-b"})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Name == "project-b")
+	tests.Assert(t, info.Mount.GlusterFS.MountPoint == volume.Mount.GlusterFS.MountPoint)
+
+	info, err = c.VolumeInfo(volume.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Name == "project-b")
+
+	// The volume keeps its name in GlusterFS, which cannot be used
+	// by the other volumes
+	mount, err := c.VolumeMount(volume.Id, "", "")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, strings.Contains(mount.Command, ":/project-a "), mount.Command)
+
+	_, err = c.VolumeRename(other.Id, &api.VolumeRenameRequest{Name: "project-a"})
+	tests.Assert(t, err != nil)
+	req.Name = "project-a"
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err != nil)
+
+	// Renaming back is allowed
+	info, err = c.VolumeRename(volume.Id, &api.VolumeRenameRequest{Name: "project-a"})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Name == "project-a")
+
+	// GlusterFS commands use the name it was created with
+	_, err = c.VolumeRename(volume.Id, &api.VolumeRenameRequest{Name: "project-b"})
+	tests.Assert(t, err == nil, err)
+	destroyed := ""
+	app.xo.MockVolumeDestroy = func(host string, volume string) error {
+		destroyed = volume
+		return nil
+	}
+	err = c.VolumeDelete(volume.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, destroyed == "project-a", destroyed)
+}
+
+func TestVolumeCreateAffinityGroups(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Put all the nodes in the same group
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		if err != nil {
+			return err
+		}
+		for _, nodeId := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			if err != nil {
+				return err
+			}
+			node.Info.AffinityGroup = "rack1"
+			err = node.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	request := []byte(`{
+        "size" : 100,
+        "durability": {
+        	"type": "replicate",
+        	"replicate": {
+            	"replica" : 2
+        	}
+    	}
+    }`)
+
+	r, err := http.Post(ts.URL+"/volumes", "application/json", bytes.NewBuffer(request))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusBadRequest)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+	tests.Assert(t, err == nil)
+	r.Body.Close()
+	tests.Assert(t, strings.Contains(string(body), "different affinity groups"), string(body))
+
+	// Also checked for the default replica
+	request = []byte(`{
+        "size" : 100,
+        "durability": {
+        	"type": "replicate"
+    	}
+    }`)
+
+	r, err = http.Post(ts.URL+"/volumes", "application/json", bytes.NewBuffer(request))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusBadRequest)
+	body, err = ioutil.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+	tests.Assert(t, err == nil)
+	r.Body.Close()
+	tests.Assert(t, strings.Contains(string(body), "different affinity groups"), string(body))
+
+	// Distributed volumes have no sets
+	request = []byte(`{
+        "size" : 100,
+        "durability": {
+        	"type": "none"
+    	}
+    }`)
+
+	r, err = http.Post(ts.URL+"/volumes", "application/json", bytes.NewBuffer(request))
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusAccepted)
+}
+
+func TestVolumeCreateProtocol(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var protocol string
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		protocol = volume.Protocol
+		return &executors.VolumeInfo{}, nil
+	}
+
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+
+	// Unknown protocol
+	req.Protocol = "afp"
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Unknown protocol"), err)
+
+	// Native client only by default
+	req.Protocol = ""
+	info, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Protocol == api.VolumeProtocolGlusterFS)
+	tests.Assert(t, protocol == "")
+
+	// Exported over NFS
+	req.Protocol = api.VolumeProtocolNFS
+	info, err = c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Protocol == api.VolumeProtocolNFS)
+	tests.Assert(t, protocol == executors.VolumeProtocolNFS)
+
+	info, err = c.VolumeInfo(info.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Protocol == api.VolumeProtocolNFS)
+
+	mount, err := c.VolumeMount(info.Id, api.MountOsMac, "")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, mount.Protocol == api.VolumeProtocolNFS)
+
+	req.Protocol = api.VolumeProtocolSMB
+	info, err = c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Protocol == api.VolumeProtocolSMB)
+	tests.Assert(t, protocol == executors.VolumeProtocolSMB)
+}
+
+func TestVolumeCreateWORM(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var request executors.VolumeRequest
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		request = *volume
+		return &executors.VolumeInfo{}, nil
+	}
+
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+
+	// Invalid settings
+	req.WORMRetentionDays = 30
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "requires a WORM volume"), err)
+	req.WORM = true
+	req.WORMRetentionMode = "strict"
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Unknown WORM retention mode"), err)
+	req.WORMRetentionMode = ""
+	req.WORMRetentionDays = -1
+	_, err = c.VolumeCreate(req)
+	tests.Assert(t, err != nil)
+
+	// Relax retention mode by default
+	req.WORMRetentionDays = 30
+	info, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.WORM && info.WORMRetentionDays == 30, info)
+	tests.Assert(t, info.WORMRetentionMode == api.VolumeWORMRetentionRelax)
+	tests.Assert(t, request.WORM)
+	tests.Assert(t, request.WORMRetentionMode == executors.WORMRetentionRelax)
+	tests.Assert(t, request.WORMRetentionPeriod == 30*24*60*60, request.WORMRetentionPeriod)
+
+	// Not deleted before the retention expires, also with its cluster
+	err = c.VolumeDelete(info.Id)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "WORM"), err)
+	err = c.ClusterPolicy(info.Cluster, &api.ClusterPolicyRequest{
+		DeletePolicy: api.ClusterDeletePolicyForce,
+	})
+	tests.Assert(t, err == nil, err)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, info.Cluster)
+		tests.Assert(t, err == nil, err)
+		_, err = cluster.checkDeletePolicy(tx)
+		tests.Assert(t, err == ErrConflict, err)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		volume, err := NewVolumeEntryFromId(tx, info.Id)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, volume.WORMRetainUntil > time.Now().AddDate(0, 0, 29).Unix())
+		volume.WORMRetainUntil = time.Now().Add(-time.Minute).Unix()
+		return volume.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+	err = c.VolumeDelete(info.Id)
+	tests.Assert(t, err == nil, err)
+
+	// Without retention, in enterprise mode
+	req.WORMRetentionDays = 0
+	req.WORMRetentionMode = api.VolumeWORMRetentionEnterprise
+	info, err = c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, request.WORMRetentionMode == executors.WORMRetentionEnterprise)
+	tests.Assert(t, request.WORMRetentionPeriod == 0)
+	err = c.VolumeDelete(info.Id)
+	tests.Assert(t, err == nil, err)
+}
+
+func TestVolumeCreateDataLocality(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	for _, test := range []struct {
+		request string
+		message string
+	}{
+		{`{"size": 10, "data_locality": "nearby", "preferred_node": "123"}`,
+			"Unknown data locality"},
+		{`{"size": 10, "data_locality": "strict-local"}`,
+			"requires a preferred node"},
+		{`{"size": 10, "data_locality": "prefer-local", "preferred_node": "123"}`,
+			"Preferred node 123 not found"},
+	} {
+		r, err := http.Post(ts.URL+"/volumes", "application/json",
+			bytes.NewBufferString(test.request))
+		tests.Assert(t, err == nil)
+		tests.Assert(t, r.StatusCode == http.StatusBadRequest, test.request)
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+		tests.Assert(t, err == nil)
+		r.Body.Close()
+		tests.Assert(t, strings.Contains(string(body), test.message), string(body))
+	}
+
+	// The locality is kept with the volume
+	var nodeId string
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		if err != nil {
+			return err
+		}
+		nodeId = cluster.Info.Nodes[0]
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.DataLocality = api.VolumeDataLocalityStrict
+	req.PreferredNodeId = nodeId
+	info, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.DataLocality == api.VolumeDataLocalityStrict)
+	tests.Assert(t, info.PreferredNodeId == nodeId)
+	local := false
+	for _, brick := range info.Bricks {
+		if brick.NodeId == nodeId {
+			local = true
+		}
+	}
+	tests.Assert(t, local)
+}
+
+func TestVolumeProfile(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(10)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+
+	// Commands are run on a node with a brick of the volume
+	hosts := make(map[string]bool)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		for _, id := range v.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+			if err != nil {
+				return err
+			}
+			hosts[node.ManageHostName()] = true
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	profiling := false
+	app.xo.MockVolumeProfileStart = func(host, volume string) error {
+		tests.Assert(t, hosts[host], host)
+		tests.Assert(t, volume == v.Info.Name)
+		profiling = true
+		return nil
+	}
+	app.xo.MockVolumeProfileStop = func(host, volume string) error {
+		tests.Assert(t, volume == v.Info.Name)
+		profiling = false
+		return nil
+	}
+	app.xo.MockVolumeProfileInfo = func(host, volume string) (*executors.VolumeProfileInfo, error) {
+		tests.Assert(t, volume == v.Info.Name)
+		if !profiling {
+			return nil, errors.New("Profile on Volume is not started")
+		}
+		return &executors.VolumeProfileInfo{
+			Bricks: []executors.BrickProfileInfo{
+				executors.BrickProfileInfo{
+					Name:        "host1:/brick/one",
+					Duration:    60,
+					DataWritten: 4096,
+					Fops: []executors.FopProfileInfo{
+						executors.FopProfileInfo{
+							Name:       "WRITE",
+							Hits:       2,
+							AvgLatency: 10,
+							MinLatency: 5,
+							MaxLatency: 15,
+						},
+					},
+				},
+			},
+		}, nil
+	}
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	// Unknown volume
+	err = c.VolumeProfileStart("123")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Id not found"), err)
+
+	// Not started
+	_, err = c.VolumeProfileInfo(v.Info.Id)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "not started"), err)
+
+	err = c.VolumeProfileStart(v.Info.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, profiling)
+
+	info, err := c.VolumeProfileInfo(v.Info.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Id == v.Info.Id)
+	tests.Assert(t, len(info.Bricks) == 1)
+	tests.Assert(t, info.Bricks[0].Brick == "host1:/brick/one")
+	tests.Assert(t, info.Bricks[0].Duration == 60)
+	tests.Assert(t, info.Bricks[0].DataWritten == 4096)
+	tests.Assert(t, len(info.Bricks[0].Fops) == 1)
+	tests.Assert(t, info.Bricks[0].Fops[0].Name == "WRITE")
+	tests.Assert(t, info.Bricks[0].Fops[0].Hits == 2)
+	tests.Assert(t, info.Bricks[0].Fops[0].MaxLatency == 15)
+
+	err = c.VolumeProfileStop(v.Info.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, !profiling)
+}
+
+func TestVolumeShrink(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&RemoveBricksPollInterval, time.Millisecond).Restore()
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	app.xo.MockBrickCreate = func(host string, brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+		return &executors.BrickInfo{Path: "/mockpath/" + brick.Name}, nil
+	}
+
+	// Bricks of the volume in GlusterFS, in the order of their sets
+	var gluster []executors.BrickInfo
+	var expanded []executors.BrickInfo
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		gluster = append(gluster, volume.Bricks...)
+		return &executors.VolumeInfo{}, nil
+	}
+	app.xo.MockVolumeExpand = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		gluster = append(gluster, volume.Bricks...)
+		expanded = volume.Bricks
+		return &executors.VolumeInfo{}, nil
+	}
+	app.xo.MockVolumeInfo = func(host, volume string) (*executors.VolumeInfo, error) {
+		return &executors.VolumeInfo{Bricks: gluster}, nil
+	}
+	var removing []executors.BrickInfo
+	polls := 0
+	app.xo.MockVolumeRemoveBricksStart = func(host, volume string, bricks []executors.BrickInfo) error {
+		removing = bricks
+		return nil
+	}
+	app.xo.MockVolumeRemoveBricksStatus = func(host, volume string, bricks []executors.BrickInfo) (*executors.RemoveBricksStatus, error) {
+		tests.Assert(t, reflect.DeepEqual(bricks, removing))
+		polls++
+		return &executors.RemoveBricksStatus{Completed: polls%2 == 0}, nil
+	}
+	app.xo.MockVolumeRemoveBricksCommit = func(host, volume string, bricks []executors.BrickInfo) error {
+		tests.Assert(t, reflect.DeepEqual(bricks, removing))
+		left := []executors.BrickInfo{}
+		for _, b := range gluster {
+			kept := true
+			for _, r := range bricks {
+				if b == r {
+					kept = false
+				}
+			}
+			if kept {
+				left = append(left, b)
+			}
+		}
+		gluster = left
+		return nil
+	}
+
+	usedStorage := func() uint64 {
+		used := uint64(0)
+		err := app.db.View(func(tx *bolt.Tx) error {
+			devices, err := DeviceList(tx)
+			tests.Assert(t, err == nil)
+			for _, id := range devices {
+				device, err := NewDeviceEntryFromId(tx, id)
+				tests.Assert(t, err == nil)
+				used += device.Info.Storage.Used
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil)
+		return used
+	}
+
+	// Sets of 50GB, then of 25GB
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 100
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	volume, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+	volume, err = c.VolumeExpand(volume.Id, &api.VolumeExpandRequest{Size: 50})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, volume.Size == 150)
+	tests.Assert(t, len(volume.Bricks) == 8, len(volume.Bricks))
+	tests.Assert(t, len(gluster) == 8)
+
+	// Invalid sizes
+	_, err = c.VolumeShrink(volume.Id, &api.VolumeShrinkRequest{Size: 0})
+	tests.Assert(t, err != nil)
+	_, err = c.VolumeShrink(volume.Id, &api.VolumeShrinkRequest{Size: 150})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), ErrShrinkBelowMinimum.Error()), err)
+
+	// The smallest set which is enough, added last
+	used := usedStorage()
+	volume, err = c.VolumeShrink(volume.Id, &api.VolumeShrinkRequest{Size: 20})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, volume.Size == 125, volume.Size)
+	tests.Assert(t, len(volume.Bricks) == 6)
+	tests.Assert(t, reflect.DeepEqual(removing, expanded[2:]), removing, expanded)
+	tests.Assert(t, polls == 2, polls)
+	tests.Assert(t, usedStorage() < used)
+	for _, brick := range volume.Bricks {
+		for _, r := range removing {
+			tests.Assert(t, brick.Path != r.Path)
+		}
+	}
+
+	// The fewest sets, with as little extra space as possible
+	volume, err = c.VolumeShrink(volume.Id, &api.VolumeShrinkRequest{Size: 60})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, volume.Size == 50, volume.Size)
+	tests.Assert(t, len(volume.Bricks) == 2)
+	tests.Assert(t, len(removing) == 4)
+
+	// The last set is kept
+	_, err = c.VolumeShrink(volume.Id, &api.VolumeShrinkRequest{Size: 10})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), ErrShrinkBelowMinimum.Error()), err)
+	volume, err = c.VolumeInfo(volume.Id)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, volume.Size == 50)
+	tests.Assert(t, len(volume.Bricks) == 2)
+}
+
+func TestVolumeShrinkStopped(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&RemoveBricksPollInterval, time.Millisecond).Restore()
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	app.xo.MockBrickCreate = func(host string, brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+		return &executors.BrickInfo{Path: "/mockpath/" + brick.Name}, nil
+	}
+	var gluster []executors.BrickInfo
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		gluster = append(gluster, volume.Bricks...)
+		return &executors.VolumeInfo{}, nil
+	}
+	app.xo.MockVolumeExpand = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		gluster = append(gluster, volume.Bricks...)
+		return &executors.VolumeInfo{}, nil
+	}
+	app.xo.MockVolumeInfo = func(host, volume string) (*executors.VolumeInfo, error) {
+		return &executors.VolumeInfo{Bricks: gluster}, nil
+	}
+
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	err = v.Expand(app.db, app.executor, app.allocator, 50)
+	tests.Assert(t, err == nil, err)
+	bricks := len(v.Bricks)
+
+	// The migration never completes
+	polls := 0
+	var onPoll func()
+	app.xo.MockVolumeRemoveBricksStatus = func(host, volume string, bricks []executors.BrickInfo) (*executors.RemoveBricksStatus, error) {
+		polls++
+		if onPoll != nil {
+			onPoll()
+		}
+		return &executors.RemoveBricksStatus{}, nil
+	}
+	stopped, committed := 0, 0
+	app.xo.MockVolumeRemoveBricksStop = func(host, volume string, bricks []executors.BrickInfo) error {
+		stopped++
+		return nil
+	}
+	app.xo.MockVolumeRemoveBricksCommit = func(host, volume string, bricks []executors.BrickInfo) error {
+		committed++
+		return nil
+	}
+	checkBricks := func() {
+		err := app.db.View(func(tx *bolt.Tx) error {
+			volume, err := NewVolumeEntryFromId(tx, v.Info.Id)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, len(volume.Bricks) == bricks)
+			tests.Assert(t, volume.Info.Size == 150)
+			return nil
+		})
+		tests.Assert(t, err == nil)
+	}
+
+	// The removal is stopped when the operation is cancelled
+	v.cancel = newOperationCancel()
+	tests.Assert(t, v.cancel.start())
+	onPoll = func() {
+		_, err := v.cancel.request()
+		tests.Assert(t, err == nil, err)
+	}
+	err = v.Shrink(app.db, app.executor, 20)
+	tests.Assert(t, err == ErrCancelled, err)
+	tests.Assert(t, polls == 1, polls)
+	tests.Assert(t, stopped == 1 && committed == 0, stopped, committed)
+	checkBricks()
+
+	// Or when it does not complete in time
+	defer tests.Patch(&RemoveBricksPollTimeout, 5*time.Millisecond).Restore()
+	v.cancel = newOperationCancel()
+	tests.Assert(t, v.cancel.start())
+	onPoll = nil
+	err = v.Shrink(app.db, app.executor, 20)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Not completed"), err)
+	tests.Assert(t, stopped == 2 && committed == 0, stopped, committed)
+	checkBricks()
+
+	// Once the data is migrated the operation cannot be cancelled
+	app.xo.MockVolumeRemoveBricksStatus = func(host, volume string, bricks []executors.BrickInfo) (*executors.RemoveBricksStatus, error) {
+		return &executors.RemoveBricksStatus{Completed: true}, nil
+	}
+	v.cancel = newOperationCancel()
+	tests.Assert(t, v.cancel.start())
+	err = v.Shrink(app.db, app.executor, 20)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, stopped == 2 && committed == 1, stopped, committed)
+	_, err = v.cancel.request()
+	tests.Assert(t, err != nil)
+}
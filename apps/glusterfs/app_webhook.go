@@ -0,0 +1,310 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+var (
+	// Times an event is posted again to a webhook which failed
+	WebhookRetries = 5
+
+	// Time before the first retry, doubled before each of the next
+	webhookRetryDelay = time.Second
+
+	// Time to wait for a webhook to respond
+	webhookTimeout = 10 * time.Second
+
+	// Events waiting to be posted.  Events are dead-lettered instead
+	// of waiting when the queue is full.
+	webhookQueueSize = 1024
+
+	// Types of the events of the asynchronous operations, by route
+	webhookOperationEvents = map[string]string{
+		"VolumeCreate": api.WebhookEventVolumeCreated,
+		"VolumeDelete": api.WebhookEventVolumeDeleted,
+		"VolumeExpand": api.WebhookEventVolumeExpanded,
+		"NodeAdd":      api.WebhookEventNodeCreated,
+		"NodeDelete":   api.WebhookEventNodeDeleted,
+		"DeviceAdd":    api.WebhookEventDeviceCreated,
+		"DeviceDelete": api.WebhookEventDeviceDeleted,
+	}
+)
+
+// Posts events to the webhooks in the background, in the order they
+// happened, so that operations are never held up or failed by a
+// webhook.  Events which cannot be posted to a webhook after the
+// retries are appended to the dead letter file, or logged if it is
+// not set.  The methods of nil webhooks do nothing, for when there
+// are none.
+type webhooks struct {
+	urls           []string
+	secret         []byte
+	deadLetterFile string
+	client         *http.Client
+	events         chan *api.WebhookEvent
+
+	// Serializes the writes to the dead letter file
+	lock sync.Mutex
+
+	// Guards the urls and the secret, which are replaced when the
+	// configuration is reloaded
+	targetsLock sync.RWMutex
+}
+
+// Event which could not be posted to the webhook, as a line of the
+// dead letter file
+type webhookDeadLetter struct {
+	Time  int64             `json:"time"`
+	Url   string            `json:"url,omitempty"`
+	Error string            `json:"error"`
+	Event *api.WebhookEvent `json:"event"`
+}
+
+func newWebhooks(urls []string, secret, deadLetterFile string) *webhooks {
+	return &webhooks{
+		urls:           urls,
+		secret:         []byte(secret),
+		deadLetterFile: deadLetterFile,
+		client:         &http.Client{Timeout: webhookTimeout},
+		events:         make(chan *api.WebhookEvent, webhookQueueSize),
+	}
+}
+
+// Replaces the urls the events are posted to and the secret they are
+// signed with.  Events being posted keep the previous ones.
+func (h *webhooks) setTargets(urls []string, secret string) {
+	h.targetsLock.Lock()
+	defer h.targetsLock.Unlock()
+
+	h.urls = urls
+	h.secret = []byte(secret)
+}
+
+func (h *webhooks) targets() ([]string, []byte) {
+	h.targetsLock.RLock()
+	defer h.targetsLock.RUnlock()
+
+	return h.urls, h.secret
+}
+
+// Queues the event to be posted, without waiting
+func (h *webhooks) notify(event *api.WebhookEvent) {
+	if h == nil {
+		return
+	}
+
+	event.Id = utils.GenUUID()
+	event.Time = time.Now().Unix()
+	select {
+	case h.events <- event:
+	default:
+		h.deadLetter(event, "", errors.New("Queue of events is full"))
+	}
+}
+
+// Posts the queued events until stop is closed.  The events still
+// queued then are dead-lettered.
+func (h *webhooks) run(stop <-chan struct{}) {
+	for {
+		select {
+		case event := <-h.events:
+			h.deliver(event, stop)
+		case <-stop:
+			for {
+				select {
+				case event := <-h.events:
+					h.deadLetter(event, "", errors.New("Server stopped"))
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Posts the event to all the webhooks at once
+func (h *webhooks) deliver(event *api.WebhookEvent, stop <-chan struct{}) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		h.deadLetter(event, "", err)
+		return
+	}
+
+	urls, secret := h.targets()
+	var wg sync.WaitGroup
+	for _, url := range urls {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			err := h.post(url, secret, body, stop)
+			if err != nil {
+				h.deadLetter(event, url, err)
+			}
+		}(url)
+	}
+	wg.Wait()
+}
+
+// Posts the body to the webhook, retrying with a growing delay until
+// it succeeds, the retries run out or stop is closed
+func (h *webhooks) post(url string, secret, body []byte, stop <-chan struct{}) error {
+	delay := webhookRetryDelay
+	retries := settings().WebhookRetries
+	for retry := 0; ; retry++ {
+		err := h.postOnce(url, secret, body)
+		if err == nil {
+			return nil
+		}
+		if retry >= retries {
+			return err
+		}
+
+		logger.Warning("Unable to post event to %v, retrying in %v: %v",
+			url, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return fmt.Errorf("Server stopped before retrying: %v", err)
+		}
+		delay *= 2
+	}
+}
+
+func (h *webhooks) postOnce(url string, secret, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(secret) != 0 {
+		req.Header.Set(api.WebhookSignatureHeader, webhookSignature(secret, body))
+	}
+
+	r, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	io.Copy(ioutil.Discard, r.Body)
+
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return fmt.Errorf("Webhook responded with status %v", r.StatusCode)
+	}
+	return nil
+}
+
+// Returns the value of the signature header of the body
+func webhookSignature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *webhooks) deadLetter(event *api.WebhookEvent, url string, err error) {
+	logger.WithField("event", event.Id).WithField("url", url).
+		LogError("Unable to post event: %v", err)
+
+	letter, merr := json.Marshal(&webhookDeadLetter{
+		Time:  time.Now().Unix(),
+		Url:   url,
+		Error: err.Error(),
+		Event: event,
+	})
+	if merr != nil {
+		logger.Err(merr)
+		return
+	}
+	if h.deadLetterFile == "" {
+		logger.LogError("Event not posted: %s", letter)
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	fp, ferr := os.OpenFile(h.deadLetterFile,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if ferr == nil {
+		_, ferr = fp.Write(append(letter, '\n'))
+		if cerr := fp.Close(); ferr == nil {
+			ferr = cerr
+		}
+	}
+	if ferr != nil {
+		logger.LogError("Unable to write dead letter file %v: %v, event not posted: %s",
+			h.deadLetterFile, ferr, letter)
+	}
+}
+
+// Notifies the webhooks of the outcome of the asynchronous operation
+// once completed, if it has an event
+func (a *App) webhookOperationDone(id string) {
+	if a.webhooks == nil {
+		return
+	}
+
+	info, ok := a.operations.get(id)
+	if !ok {
+		return
+	}
+	eventType, ok := webhookOperationEvents[info.Type]
+	if !ok {
+		return
+	}
+	a.webhooks.notify(&api.WebhookEvent{
+		Type:        eventType,
+		ResourceIds: info.Targets,
+		Actor:       info.User,
+		Outcome:     info.State,
+		Error:       info.Error,
+		RequestId:   info.RequestId,
+		OperationId: id,
+	})
+}
+
+// Notifies the webhooks that the request set the state of the node or
+// device to failed
+func (a *App) webhookStateFailed(r *http.Request, eventType, id string) {
+	if a.webhooks == nil {
+		return
+	}
+
+	a.webhooks.notify(&api.WebhookEvent{
+		Type:        eventType,
+		ResourceIds: []string{id},
+		Actor:       requestUser(r),
+		Outcome:     api.AsyncOperationSucceeded,
+		RequestId:   requestId(r),
+	})
+}
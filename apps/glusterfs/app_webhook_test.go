@@ -0,0 +1,223 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/tests"
+)
+
+func TestWebhooks(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	// Webhook checking the signature of the events
+	events := make(chan *api.WebhookEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, r.Header.Get(api.WebhookSignatureHeader) ==
+			webhookSignature([]byte("secret"), body))
+
+		var event api.WebhookEvent
+		tests.Assert(t, json.Unmarshal(body, &event) == nil)
+		events <- &event
+	}))
+	defer hook.Close()
+
+	app.webhooks = newWebhooks([]string{hook.URL}, "secret", "")
+	go app.webhooks.run(app.stop)
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		3,      // nodes_per_cluster
+		2,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var deviceId string
+	err = app.db.View(func(tx *bolt.Tx) error {
+		devices, err := DeviceList(tx)
+		tests.Assert(t, err == nil)
+		deviceId = devices[0]
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	next := func() *api.WebhookEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("No event posted")
+		}
+		return nil
+	}
+
+	// Setting the state of a device to failed
+	err = c.DeviceState(deviceId, &api.StateRequest{State: api.EntryStateOffline})
+	tests.Assert(t, err == nil, err)
+	err = c.DeviceState(deviceId, &api.StateRequest{State: api.EntryStateFailed})
+	tests.Assert(t, err == nil, err)
+
+	event := next()
+	tests.Assert(t, event.Type == api.WebhookEventDeviceFailed, event)
+	tests.Assert(t, len(event.ResourceIds) == 1)
+	tests.Assert(t, event.ResourceIds[0] == deviceId)
+	tests.Assert(t, event.Outcome == api.AsyncOperationSucceeded)
+	tests.Assert(t, event.Actor != "")
+	tests.Assert(t, event.Id != "")
+	tests.Assert(t, event.Time != 0)
+
+	// Completed operations
+	volume, err := c.VolumeCreate(&api.VolumeCreateRequest{Size: 10})
+	tests.Assert(t, err == nil, err)
+
+	event = next()
+	tests.Assert(t, event.Type == api.WebhookEventVolumeCreated, event)
+	tests.Assert(t, event.ResourceIds[0] == volume.Id)
+	tests.Assert(t, event.Outcome == api.AsyncOperationSucceeded)
+	tests.Assert(t, event.OperationId != "")
+	tests.Assert(t, event.RequestId != "")
+
+	// Failed operations
+	app.xo.MockVolumeDestroy = func(host string, volume string) error {
+		return ErrNotFound
+	}
+	err = c.VolumeDelete(volume.Id)
+	tests.Assert(t, err != nil)
+
+	event = next()
+	tests.Assert(t, event.Type == api.WebhookEventVolumeDeleted, event)
+	tests.Assert(t, event.ResourceIds[0] == volume.Id)
+	tests.Assert(t, event.Outcome == api.AsyncOperationFailed)
+	tests.Assert(t, event.Error != "")
+
+	// Nothing else was posted
+	select {
+	case event := <-events:
+		t.Fatalf("Unexpected event %v", event)
+	default:
+	}
+}
+
+func TestWebhooksDeadLetter(t *testing.T) {
+	defer tests.Patch(&WebhookRetries, 2).Restore()
+	defer tests.Patch(&webhookRetryDelay, time.Millisecond).Restore()
+
+	deadletters := tests.Tempfile()
+	defer os.Remove(deadletters)
+
+	// Webhook which always fails
+	var (
+		lock     sync.Mutex
+		attempts int
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		attempts++
+		lock.Unlock()
+		http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+	}))
+	defer hook.Close()
+
+	stop := make(chan struct{})
+	h := newWebhooks([]string{hook.URL}, "", deadletters)
+	done := make(chan struct{})
+	go func() {
+		h.run(stop)
+		close(done)
+	}()
+
+	h.notify(&api.WebhookEvent{
+		Type:        api.WebhookEventNodeDeleted,
+		ResourceIds: []string{"node"},
+	})
+
+	// Written to the dead letter file once the retries run out
+	var letters []webhookDeadLetter
+	for i := 0; i < 500 && len(letters) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		letters = readDeadLetters(t, deadletters)
+	}
+	tests.Assert(t, len(letters) == 1, letters)
+	tests.Assert(t, letters[0].Url == hook.URL)
+	tests.Assert(t, letters[0].Error != "")
+	tests.Assert(t, letters[0].Event.Type == api.WebhookEventNodeDeleted)
+	tests.Assert(t, letters[0].Event.ResourceIds[0] == "node")
+	lock.Lock()
+	tests.Assert(t, attempts == 3, attempts)
+	lock.Unlock()
+
+	// Events are dead-lettered without waiting when the queue is full
+	defer tests.Patch(&webhookQueueSize, 0).Restore()
+	full := newWebhooks([]string{hook.URL}, "", deadletters)
+	full.notify(&api.WebhookEvent{Type: api.WebhookEventDeviceDeleted})
+	letters = readDeadLetters(t, deadletters)
+	tests.Assert(t, len(letters) == 2, letters)
+	tests.Assert(t, letters[1].Url == "")
+	tests.Assert(t, letters[1].Event.Type == api.WebhookEventDeviceDeleted)
+
+	close(stop)
+	<-done
+}
+
+func readDeadLetters(t *testing.T, filename string) []webhookDeadLetter {
+	fp, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	tests.Assert(t, err == nil, err)
+	defer fp.Close()
+
+	var letters []webhookDeadLetter
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		var letter webhookDeadLetter
+		err := json.Unmarshal(scanner.Bytes(), &letter)
+		tests.Assert(t, err == nil, err)
+		letters = append(letters, letter)
+	}
+	tests.Assert(t, scanner.Err() == nil)
+	return letters
+}
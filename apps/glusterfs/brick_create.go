@@ -17,6 +17,8 @@
 package glusterfs
 
 import (
+	"sync"
+
 	"github.com/boltdb/bolt"
 	"github.com/heketi/heketi/executors"
 	"github.com/heketi/heketi/pkg/utils"
@@ -33,18 +35,46 @@ func createDestroyConcurrently(db *bolt.DB,
 	executor executors.Executor,
 	brick_entries []*BrickEntry,
 	create_type CreateType) error {
+	return createDestroyReporting(db, executor, brick_entries, create_type, nil)
+}
+
+// Like createDestroyConcurrently, reporting the bricks created or
+// destroyed as the progress of the step of the operation
+func createDestroyReporting(db *bolt.DB,
+	executor executors.Executor,
+	brick_entries []*BrickEntry,
+	create_type CreateType,
+	cancel *operationCancel) error {
 
 	sg := utils.NewStatusGroup()
+	var (
+		lock sync.Mutex
+		done int
+	)
+	cancel.progress(0, len(brick_entries), "")
 
 	// Create a goroutine for each brick
 	for _, brick := range brick_entries {
 		sg.Add(1)
 		go func(b *BrickEntry) {
 			defer sg.Done()
+			var err error
 			if create_type == CREATOR_CREATE {
-				sg.Err(b.Create(db, executor))
+				err = recoverCall(logger, "BrickCreate", func() error {
+					return b.createCancellable(db, executor,
+						cancel.cancelled())
+				})
 			} else {
-				sg.Err(b.Destroy(db, executor))
+				err = recoverCall(logger, "BrickDestroy", func() error {
+					return b.Destroy(db, executor)
+				})
+			}
+			sg.Err(err)
+			if err == nil {
+				lock.Lock()
+				done++
+				cancel.progress(done, len(brick_entries), "")
+				lock.Unlock()
 			}
 		}(brick)
 	}
@@ -59,6 +89,12 @@ func createDestroyConcurrently(db *bolt.DB,
 		if create_type == CREATOR_CREATE {
 			createDestroyConcurrently(db, executor, brick_entries, CREATOR_DESTROY)
 		}
+
+		// Bricks stop waiting for the others on their node once the
+		// operation is cancelled
+		if cancel.checkpoint() != nil {
+			return ErrCancelled
+		}
 	}
 	return err
 }
@@ -67,6 +103,13 @@ func CreateBricks(db *bolt.DB, executor executors.Executor, brick_entries []*Bri
 	return createDestroyConcurrently(db, executor, brick_entries, CREATOR_CREATE)
 }
 
+// Creates the bricks, reporting those created as the progress of the
+// current step of the operation
+func createBricksReporting(db *bolt.DB, executor executors.Executor,
+	brick_entries []*BrickEntry, cancel *operationCancel) error {
+	return createDestroyReporting(db, executor, brick_entries, CREATOR_CREATE, cancel)
+}
+
 func DestroyBricks(db *bolt.DB, executor executors.Executor, brick_entries []*BrickEntry) error {
 	return createDestroyConcurrently(db, executor, brick_entries, CREATOR_DESTROY)
 }
@@ -17,8 +17,8 @@
 package glusterfs
 
 import (
-	"bytes"
-	"encoding/gob"
+	"strings"
+
 	"github.com/boltdb/bolt"
 	"github.com/heketi/heketi/executors"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
@@ -26,10 +26,25 @@ import (
 	"github.com/lpabon/godbc"
 )
 
+var (
+	// Template of the mount points of new bricks, see
+	// executors.RenderBrickPath.  The executor default if empty.
+	BrickPathTemplate = ""
+)
+
 type BrickEntry struct {
+	Revision
+
 	Info             api.BrickInfo
 	TpSize           uint64
 	PoolMetadataSize uint64
+
+	// I/O when last fetched, see brick_entry_iostats.go
+	IOStats api.BrickIOStats
+
+	// Set on the bricks of volumes with shared bricks, whose mount
+	// point other such volumes may use
+	Shareable bool
 }
 
 func BrickList(tx *bolt.Tx) ([]string, error) {
@@ -92,31 +107,50 @@ func (b *BrickEntry) Save(tx *bolt.Tx) error {
 	godbc.Require(tx != nil)
 	godbc.Require(len(b.Info.Id) > 0)
 
-	return EntrySave(tx, b, b.Info.Id)
+	err := EntrySave(tx, b, b.Info.Id)
+	if err != nil {
+		return err
+	}
+
+	// Update node index
+	if b.Info.NodeId != "" {
+		return IndexAdd(tx, BOLTDB_BUCKET_INDEX_NODE_BRICKS, b.Info.NodeId, b.Info.Id)
+	}
+
+	return nil
 }
 
 func (b *BrickEntry) Delete(tx *bolt.Tx) error {
-	return EntryDelete(tx, b, b.Info.Id)
+	err := EntryDelete(tx, b, b.Info.Id)
+	if err != nil {
+		return err
+	}
+
+	// Update node index
+	if b.Info.NodeId != "" {
+		return IndexDelete(tx, BOLTDB_BUCKET_INDEX_NODE_BRICKS, b.Info.NodeId, b.Info.Id)
+	}
+
+	return nil
 }
 
 func (b *BrickEntry) NewInfoResponse(tx *bolt.Tx) (*api.BrickInfo, error) {
 	info := &api.BrickInfo{}
 	*info = b.Info
+	if b.IOStats.Time != 0 {
+		stats := b.IOStats
+		info.IOStats = &stats
+	}
 
 	return info, nil
 }
 
 func (b *BrickEntry) Marshal() ([]byte, error) {
-	var buffer bytes.Buffer
-	enc := gob.NewEncoder(&buffer)
-	err := enc.Encode(*b)
-
-	return buffer.Bytes(), err
+	return entryCodec.Encode(*b)
 }
 
 func (b *BrickEntry) Unmarshal(buffer []byte) error {
-	dec := gob.NewDecoder(bytes.NewReader(buffer))
-	err := dec.Decode(b)
+	err := entryCodec.Decode(buffer, b)
 	if err != nil {
 		return err
 	}
@@ -125,12 +159,24 @@ func (b *BrickEntry) Unmarshal(buffer []byte) error {
 }
 
 func (b *BrickEntry) Create(db *bolt.DB, executor executors.Executor) error {
+	return b.createCancellable(db, executor, nil)
+}
+
+// Creates the brick, unless the cancel channel is closed while the
+// creation waits for the other brick operations on the node
+func (b *BrickEntry) createCancellable(db *bolt.DB,
+	executor executors.Executor,
+	cancel <-chan struct{}) error {
+
 	godbc.Require(db != nil)
 	godbc.Require(b.TpSize > 0)
 	godbc.Require(b.Info.Size > 0)
 
-	// Get node hostname
+	// Get node hostname, its limit on brick operations and whether
+	// the device is trimmed
 	var host string
+	var maxOps int
+	var discard bool
 	err := db.View(func(tx *bolt.Tx) error {
 		node, err := NewNodeEntryFromId(tx, b.Info.NodeId)
 		if err != nil {
@@ -139,6 +185,13 @@ func (b *BrickEntry) Create(db *bolt.DB, executor executors.Executor) error {
 
 		host = node.ManageHostName()
 		godbc.Check(host != "")
+		maxOps = node.MaxConcurrentBrickOps()
+
+		device, err := NewDeviceEntryFromId(tx, b.Info.DeviceId)
+		if err != nil {
+			return err
+		}
+		discard = device.Info.TrimEnabled
 		return nil
 	})
 	if err != nil {
@@ -152,6 +205,12 @@ func (b *BrickEntry) Create(db *bolt.DB, executor executors.Executor) error {
 	req.TpSize = b.TpSize
 	req.VgId = b.Info.DeviceId
 	req.PoolMetadataSize = b.PoolMetadataSize
+	req.MountPoint = b.Info.MountPoint
+	req.SubDir = b.Info.SubDir
+	req.Discard = discard
+	req.PathTemplate = BrickPathTemplate
+	req.MaxConcurrentOps = maxOps
+	req.Cancel = cancel
 
 	// Create brick on node
 	logger.Info("Creating brick %v", b.Info.Id)
@@ -160,6 +219,10 @@ func (b *BrickEntry) Create(db *bolt.DB, executor executors.Executor) error {
 		return err
 	}
 	b.Info.Path = info.Path
+	if info.MountPoint != "" {
+		b.Info.MountPoint = info.MountPoint
+		b.Info.SubDir = strings.TrimPrefix(info.Path, info.MountPoint+"/")
+	}
 
 	godbc.Ensure(b.Info.Path != "")
 
@@ -172,8 +235,11 @@ func (b *BrickEntry) Destroy(db *bolt.DB, executor executors.Executor) error {
 	godbc.Require(b.TpSize > 0)
 	godbc.Require(b.Info.Size > 0)
 
-	// Get node hostname
+	// Get node hostname and its limit on brick operations, and
+	// determine if the mount point is shared
 	var host string
+	var maxOps int
+	shared := false
 	err := db.View(func(tx *bolt.Tx) error {
 		node, err := NewNodeEntryFromId(tx, b.Info.NodeId)
 		if err != nil {
@@ -182,6 +248,15 @@ func (b *BrickEntry) Destroy(db *bolt.DB, executor executors.Executor) error {
 
 		host = node.ManageHostName()
 		godbc.Check(host != "")
+		maxOps = node.MaxConcurrentBrickOps()
+
+		if b.Info.MountPoint != "" {
+			device, err := NewDeviceEntryFromId(tx, b.Info.DeviceId)
+			if err != nil {
+				return err
+			}
+			shared = device.MountPointShared(b)
+		}
 		return nil
 	})
 	if err != nil {
@@ -194,6 +269,10 @@ func (b *BrickEntry) Destroy(db *bolt.DB, executor executors.Executor) error {
 	req.Size = b.Info.Size
 	req.TpSize = b.TpSize
 	req.VgId = b.Info.DeviceId
+	req.MountPoint = b.Info.MountPoint
+	req.SubDir = b.Info.SubDir
+	req.Last = !shared
+	req.MaxConcurrentOps = maxOps
 
 	// Delete brick on node
 	logger.Info("Deleting brick %v", b.Info.Id)
@@ -232,6 +311,8 @@ func (b *BrickEntry) DestroyCheck(db *bolt.DB, executor executors.Executor) erro
 	req.Size = b.Info.Size
 	req.TpSize = b.TpSize
 	req.VgId = b.Info.DeviceId
+	req.MountPoint = b.Info.MountPoint
+	req.SubDir = b.Info.SubDir
 
 	// Check brick on node
 	return executor.BrickDestroyCheck(host, req)
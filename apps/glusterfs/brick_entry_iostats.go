@@ -0,0 +1,141 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/lpabon/godbc"
+)
+
+// Fetches the I/O of the bricks of the volume from GlusterFS and
+// saves it with the bricks
+func (v *VolumeEntry) FetchIOStats(db *bolt.DB, executor executors.Executor) error {
+	godbc.Require(db != nil)
+
+	if !v.IsStarted() {
+		return ErrVolumeStopped
+	}
+
+	sshhost, err := v.manageHost(db)
+	if err != nil {
+		return err
+	}
+
+	stats, err := executor.VolumeIOStats(sshhost, v.glusterName())
+	if err != nil {
+		logger.Err(err)
+		return err
+	}
+
+	// Reported by host:path
+	reported := make(map[string]executors.BrickIOStats)
+	for _, b := range stats.Bricks {
+		reported[b.Host+":"+b.Path] = b
+	}
+
+	now := time.Now().Unix()
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, id := range v.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			if err == ErrNotFound {
+				// Replaced while the stats were fetched
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+			if err != nil {
+				return err
+			}
+
+			b, ok := reported[node.StorageHostName()+":"+brick.Info.Path]
+			if !ok {
+				logger.Warning("No I/O reported for brick %v of volume %v",
+					brick.Info.Id, v.Info.Id)
+				continue
+			}
+			brick.IOStats = api.BrickIOStats{
+				ReadOpsPerSec:  b.ReadOpsPerSec,
+				WriteOpsPerSec: b.WriteOpsPerSec,
+				ClientCount:    b.ClientCount,
+				Time:           now,
+			}
+			err = brick.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Fetches the I/O of the bricks of all the started volumes.  A volume
+// failing does not stop the others, the failures are logged.
+func BrickIOStatsFetch(db *bolt.DB, executor executors.Executor) {
+	var volumes []*VolumeEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		list, err := VolumeList(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range list {
+			volume, err := NewVolumeEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			if volume.IsStarted() {
+				volumes = append(volumes, volume)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Err(err)
+		return
+	}
+
+	for _, volume := range volumes {
+		err := volume.FetchIOStats(db, executor)
+		if err != nil {
+			logger.LogError("Unable to fetch I/O of the bricks of volume %v: %v",
+				volume.Info.Id, err)
+		}
+	}
+}
+
+// Fetch the I/O of the bricks every interval until stop is closed
+func fetchBrickIOStatsEvery(db *bolt.DB, executor executors.Executor,
+	interval time.Duration, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			BrickIOStatsFetch(db, executor)
+		case <-stop:
+			return
+		}
+	}
+}
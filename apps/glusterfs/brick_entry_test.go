@@ -223,3 +223,178 @@ func TestBrickEntryDestroyCheck(t *testing.T) {
 	err = b.DestroyCheck(app.db, app.executor)
 	tests.Assert(t, err == nil, err)
 }
+
+func TestBrickEntryCreateDestroySubDir(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	n := NewNodeEntry()
+	n.Info.Id = "node"
+	n.Info.Hostnames.Manage = []string{"manage"}
+	n.Info.Hostnames.Storage = []string{"storage"}
+	d := NewDeviceEntry()
+	d.Info.Id = "abc"
+	d.NodeId = "node"
+	d.StorageSet(10 * GB)
+
+	app.xo.MockBrickCreate = func(host string,
+		brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+		mountpoint := brick.MountPoint
+		if mountpoint == "" {
+			mountpoint = "/mnt/brick_" + brick.Name
+		}
+		subdir := brick.SubDir
+		if subdir == "" {
+			subdir = "brick"
+		}
+		return &executors.BrickInfo{
+			Path:       mountpoint + "/" + subdir,
+			MountPoint: mountpoint,
+		}, nil
+	}
+
+	// Create the brick owning the mount point
+	b := d.NewBrickEntry(1*GB, 1.0)
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		err := n.Save(tx)
+		tests.Assert(t, err == nil)
+		return d.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	err = b.Create(app.db, app.executor)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, b.Info.MountPoint == "/mnt/brick_"+b.Info.Id)
+	tests.Assert(t, b.Info.SubDir == "brick")
+	tests.Assert(t, b.Info.Path == b.Info.MountPoint+"/brick")
+
+	// Create a brick in a sub-directory of the same mount point
+	s := d.NewSubDirBrickEntry(b, "other")
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return d.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	err = s.Create(app.db, app.executor)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s.Info.MountPoint == b.Info.MountPoint)
+	tests.Assert(t, s.Info.Path == b.Info.MountPoint+"/other")
+
+	// Destroying a shared brick only removes its directory
+	var req *executors.BrickRequest
+	app.xo.MockBrickDestroy = func(host string, brick *executors.BrickRequest) error {
+		req = brick
+		return nil
+	}
+	err = s.Destroy(app.db, app.executor)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, req.MountPoint == b.Info.MountPoint)
+	tests.Assert(t, req.SubDir == "other")
+	tests.Assert(t, !req.Last)
+
+	// The last brick removes the logical volume
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		err := d.BrickStorageFree(s)
+		tests.Assert(t, err == nil)
+		return d.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	err = b.Destroy(app.db, app.executor)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, req.Last)
+}
+
+func TestBrickIOStatsFetch(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	started := createSampleVolumeEntry(100)
+	err = started.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+	stopped := createSampleVolumeEntry(100)
+	err = stopped.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+
+	// GlusterFS reports the bricks by storage host and path
+	reported := []executors.BrickIOStats{}
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		for _, id := range started.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+			tests.Assert(t, err == nil)
+			reported = append(reported, executors.BrickIOStats{
+				Host:           node.StorageHostName(),
+				Path:           brick.Info.Path,
+				ReadOpsPerSec:  12.5,
+				WriteOpsPerSec: 3,
+				ClientCount:    2,
+			})
+		}
+
+		v, err := NewVolumeEntryFromId(tx, stopped.Info.Id)
+		tests.Assert(t, err == nil)
+		v.SetStarted(false)
+		return v.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	var fetched []string
+	app.xo.MockVolumeIOStats = func(host, volume string) (*executors.VolumeIOStats, error) {
+		fetched = append(fetched, volume)
+		return &executors.VolumeIOStats{Bricks: reported}, nil
+	}
+	BrickIOStatsFetch(app.db, app.executor)
+	tests.Assert(t, reflect.DeepEqual(fetched, []string{started.Info.Name}), fetched)
+
+	// Listed with the bricks of the volume
+	err = app.db.View(func(tx *bolt.Tx) error {
+		v, err := NewVolumeEntryFromId(tx, started.Info.Id)
+		tests.Assert(t, err == nil)
+		info, err := v.NewInfoResponse(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(info.Bricks) == len(started.Bricks))
+		for _, b := range info.Bricks {
+			tests.Assert(t, b.IOStats != nil)
+			tests.Assert(t, b.IOStats.ReadOpsPerSec == 12.5)
+			tests.Assert(t, b.IOStats.WriteOpsPerSec == 3)
+			tests.Assert(t, b.IOStats.ClientCount == 2)
+			tests.Assert(t, b.IOStats.Time > 0)
+		}
+
+		v, err = NewVolumeEntryFromId(tx, stopped.Info.Id)
+		tests.Assert(t, err == nil)
+		info, err = v.NewInfoResponse(tx)
+		tests.Assert(t, err == nil)
+		for _, b := range info.Bricks {
+			tests.Assert(t, b.IOStats == nil)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// A failure is logged
+	app.xo.MockVolumeIOStats = func(host, volume string) (*executors.VolumeIOStats, error) {
+		return nil, fmt.Errorf("volume %v not found", volume)
+	}
+	err = started.FetchIOStats(app.db, app.executor)
+	tests.Assert(t, err != nil)
+	BrickIOStatsFetch(app.db, app.executor)
+}
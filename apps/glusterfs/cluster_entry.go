@@ -17,8 +17,7 @@
 package glusterfs
 
 import (
-	"bytes"
-	"encoding/gob"
+	"fmt"
 	"sort"
 
 	"github.com/boltdb/bolt"
@@ -28,7 +27,35 @@ import (
 )
 
 type ClusterEntry struct {
+	Revision
+
 	Info api.ClusterInfoResponse
+
+	// What deleting the cluster does with its volumes and nodes.
+	// Empty is the same as fail.
+	DeletePolicy string
+
+	// When the volumes are rebalanced, with the cron expression of
+	// the scheduled policy.  Empty is the same as never.
+	RebalancePolicy   string
+	RebalanceSchedule string
+
+	// Sizes the bricks created in the cluster are kept within
+	StoragePolicy StoragePolicy
+}
+
+// Sizes in GB of the bricks of a cluster.  Zero is no limit.
+type StoragePolicy struct {
+	MinBrickSizeGB uint64
+	MaxBrickSizeGB uint64
+}
+
+func (p StoragePolicy) Validate() error {
+	if p.MaxBrickSizeGB != 0 && p.MinBrickSizeGB > p.MaxBrickSizeGB {
+		return fmt.Errorf("Minimum brick size %v GB is larger than the maximum %v GB",
+			p.MinBrickSizeGB, p.MaxBrickSizeGB)
+	}
+	return nil
 }
 
 func ClusterList(tx *bolt.Tx) ([]string, error) {
@@ -86,28 +113,39 @@ func (c *ClusterEntry) Delete(tx *bolt.Tx) error {
 		return ErrConflict
 	}
 
-	return EntryDelete(tx, c, c.Info.Id)
+	return EntryTombstone(tx, c, api.TombstoneCluster, c.Info.Id)
 }
 
 func (c *ClusterEntry) NewClusterInfoResponse(tx *bolt.Tx) (*api.ClusterInfoResponse, error) {
 
 	info := &api.ClusterInfoResponse{}
 	*info = c.Info
+	info.DeletePolicy = c.DeletePolicy
+	info.RebalancePolicy = c.RebalancePolicy
+	info.RebalanceSchedule = c.RebalanceSchedule
+	if c.StoragePolicy != (StoragePolicy{}) {
+		info.StoragePolicy = &api.ClusterStoragePolicy{
+			MinBrickSizeGB: c.StoragePolicy.MinBrickSizeGB,
+			MaxBrickSizeGB: c.StoragePolicy.MaxBrickSizeGB,
+		}
+	}
+
+	// Get the volumes from the index
+	volumes, err := IndexList(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, c.Info.Id)
+	if err != nil {
+		return nil, err
+	}
+	info.Volumes = volumes
 
 	return info, nil
 }
 
 func (c *ClusterEntry) Marshal() ([]byte, error) {
-	var buffer bytes.Buffer
-	enc := gob.NewEncoder(&buffer)
-	err := enc.Encode(*c)
-
-	return buffer.Bytes(), err
+	return entryCodec.Encode(*c)
 }
 
 func (c *ClusterEntry) Unmarshal(buffer []byte) error {
-	dec := gob.NewDecoder(bytes.NewReader(buffer))
-	err := dec.Decode(c)
+	err := entryCodec.Decode(buffer, c)
 	if err != nil {
 		return err
 	}
@@ -132,6 +170,146 @@ func (c *ClusterEntry) NodeEntryFromClusterIndex(tx *bolt.Tx, index int) (*NodeE
 	return node, nil
 }
 
+// Returns the number of online nodes in the cluster which have at
+// least one online device, which is the most bricks a set can have
+// when each brick of the set must be on a different node
+func (c *ClusterEntry) AvailableNodeCount(tx *bolt.Tx) (int, error) {
+	count := 0
+	for _, nodeId := range c.Info.Nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return 0, err
+		}
+		if !node.isOnline() {
+			continue
+		}
+
+		for _, deviceId := range node.Devices {
+			device, err := NewDeviceEntryFromId(tx, deviceId)
+			if err != nil {
+				return 0, err
+			}
+			if device.isOnline() {
+				count++
+				break
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// Returns the nodes, devices and storage of each zone of the cluster,
+// ordered by zone
+func (c *ClusterEntry) ZoneInfo(tx *bolt.Tx) ([]api.ZoneInfo, error) {
+	zones := make(map[int]*api.ZoneInfo)
+	for _, nodeId := range c.Info.Nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return nil, err
+		}
+
+		zone, ok := zones[node.Info.Zone]
+		if !ok {
+			zone = &api.ZoneInfo{ZoneId: node.Info.Zone}
+			zones[node.Info.Zone] = zone
+		}
+		zone.NodeCount++
+
+		for _, deviceId := range node.Devices {
+			device, err := NewDeviceEntryFromId(tx, deviceId)
+			if err != nil {
+				return nil, err
+			}
+			zone.DeviceCount++
+			zone.StorageSize += device.Info.Storage.Total
+			if node.isOnline() && device.isOnline() {
+				zone.StorageFree += device.Info.Storage.Free
+			}
+		}
+	}
+
+	ids := make([]int, 0, len(zones))
+	for id := range zones {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	info := make([]api.ZoneInfo, 0, len(ids))
+	for _, id := range ids {
+		info = append(info, *zones[id])
+	}
+	return info, nil
+}
+
+// Returns an error unless the cluster has a node available for each
+// of the replica bricks of a set, all in different affinity groups
+func CanPlaceAcrossGroups(tx *bolt.Tx, clusterId string, replica int) error {
+	cluster, err := NewClusterEntryFromId(tx, clusterId)
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[string]bool)
+	for _, nodeId := range cluster.Info.Nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return err
+		}
+		if !node.isOnline() {
+			continue
+		}
+
+		for _, deviceId := range node.Devices {
+			device, err := NewDeviceEntryFromId(tx, deviceId)
+			if err != nil {
+				return err
+			}
+			if device.isOnline() {
+				groups[node.AffinityGroup()] = true
+				break
+			}
+		}
+	}
+
+	if len(groups) < replica {
+		return fmt.Errorf("Sets of %v bricks require available nodes in %v "+
+			"different affinity groups in cluster %v, but only %v are available",
+			replica, replica, clusterId, len(groups))
+	}
+	return nil
+}
+
+// Returns the devices of the cluster without bricks, whatever their
+// state, which can be removed without migrating bricks off them
+func EmptyDevices(tx *bolt.Tx, clusterId string) ([]*DeviceEntry, error) {
+	godbc.Require(tx != nil)
+
+	cluster, err := NewClusterEntryFromId(tx, clusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := []*DeviceEntry{}
+	for _, nodeId := range cluster.Info.Nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, deviceId := range node.Devices {
+			device, err := NewDeviceEntryFromId(tx, deviceId)
+			if err != nil {
+				return nil, err
+			}
+			if len(device.Bricks) == 0 {
+				devices = append(devices, device)
+			}
+		}
+	}
+	return devices, nil
+}
+
 func (c *ClusterEntry) NodeAdd(id string) {
 	c.Info.Nodes = append(c.Info.Nodes, id)
 	c.Info.Nodes.Sort()
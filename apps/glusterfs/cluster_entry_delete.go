@@ -0,0 +1,342 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/lpabon/godbc"
+)
+
+func isValidClusterDeletePolicy(policy string) bool {
+	switch policy {
+	case "",
+		api.ClusterDeletePolicyFail,
+		api.ClusterDeletePolicyForce,
+		api.ClusterDeletePolicyCascade:
+		return true
+	}
+	return false
+}
+
+// Returns true if the volumes or nodes of the cluster must be
+// destroyed before it is deleted, or ErrConflict if its policy does
+// not allow deleting them.  The force policy destroys the volumes,
+// and is refused here only when there are none to destroy and nodes
+// with devices would remain.
+func (c *ClusterEntry) checkDeletePolicy(tx *bolt.Tx) (bool, error) {
+	godbc.Require(tx != nil)
+
+	if len(c.Info.Nodes) == 0 && len(c.Info.Volumes) == 0 {
+		return false, nil
+	}
+
+	switch c.DeletePolicy {
+	case api.ClusterDeletePolicyCascade:
+	case api.ClusterDeletePolicyForce:
+		if len(c.Info.Volumes) != 0 {
+			break
+		}
+		for _, nodeId := range c.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			if err != nil {
+				return false, err
+			}
+			if len(node.Devices) != 0 {
+				logger.Warning("Unable to delete cluster [%v] because node %v has devices, "+
+					"which are only deleted with the %v policy",
+					c.Info.Id, nodeId, api.ClusterDeletePolicyCascade)
+				return false, ErrConflict
+			}
+		}
+	default:
+		logger.Warning("Unable to delete cluster [%v] because it contains volumes and/or nodes", c.Info.Id)
+		return false, ErrConflict
+	}
+
+	// WORM volumes are kept for their retention
+	now := time.Now()
+	for _, volumeId := range c.Info.Volumes {
+		volume, err := NewVolumeEntryFromId(tx, volumeId)
+		if err != nil {
+			return false, err
+		}
+		if err := volume.checkWORMRetention(now); err != nil {
+			logger.Warning("Unable to delete cluster [%v]: %v", c.Info.Id, err)
+			return false, ErrConflict
+		}
+	}
+	return true, nil
+}
+
+// Returns what deleting the cluster with its contents deletes, with
+// the hash the delete must be confirmed with.  The hash also covers
+// the ids of the volumes, so that it changes when a volume is
+// replaced by another one with as many bricks.
+func (c *ClusterEntry) EstimatedDeleteImpact(tx *bolt.Tx) (*api.DeleteImpact, error) {
+	godbc.Require(tx != nil)
+
+	impact := &api.DeleteImpact{
+		Id:      c.Info.Id,
+		Volumes: len(c.Info.Volumes),
+		Nodes:   len(c.Info.Nodes),
+		Pvcs:    []string{},
+	}
+
+	for _, id := range c.Info.Volumes {
+		volume, err := NewVolumeEntryFromId(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if pvc, ok := kubernetesVolumePvc(volume.Info.Name); ok {
+			impact.Pvcs = append(impact.Pvcs, pvc)
+		}
+
+		for _, brickId := range volume.Bricks {
+			brick, err := NewBrickEntryFromId(tx, brickId)
+			if err != nil {
+				return nil, err
+			}
+			impact.Bricks++
+			impact.StorageFreed += brick.TotalSize()
+		}
+	}
+	sort.Strings(impact.Pvcs)
+
+	for _, id := range c.Info.Nodes {
+		node, err := NewNodeEntryFromId(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		impact.Devices += len(node.Devices)
+	}
+
+	data, err := json.Marshal(impact)
+	if err != nil {
+		return nil, err
+	}
+	volumes := append([]string{}, c.Info.Volumes...)
+	sort.Strings(volumes)
+	h := sha256.New()
+	h.Write(data)
+	for _, id := range volumes {
+		h.Write([]byte("\n" + id))
+	}
+	impact.Hash = hex.EncodeToString(h.Sum(nil))
+
+	return impact, nil
+}
+
+// Returns the namespace/name of the PVC of a volume named by the
+// Kubernetes provisioner with a prefix: prefix_namespace_name_uid.
+// Names of namespaces and PVCs cannot contain underscores.
+func kubernetesVolumePvc(name string) (string, bool) {
+	parts := strings.Split(name, "_")
+	if len(parts) != 4 || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1] + "/" + parts[2], true
+}
+
+// Destroys the volumes of the cluster, the devices of its nodes with
+// the cascade policy, its nodes and then deletes the cluster.  With
+// the force policy the delete stops once the volumes are destroyed if
+// any node still has devices.  The entries deleted are recorded as
+// deleted by the user.  If any step fails, the entries already
+// deleted stay deleted.
+func (c *ClusterEntry) Destroy(db *bolt.DB,
+	executor executors.Executor,
+	allocator Allocator,
+	user string,
+	cancel *operationCancel) error {
+
+	var (
+		volumes []*VolumeEntry
+		nodes   []*NodeEntry
+	)
+	now := time.Now()
+	err := db.View(func(tx *bolt.Tx) error {
+		for _, id := range c.Info.Volumes {
+			volume, err := NewVolumeEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+
+			// Nothing is deleted if a WORM volume must be kept
+			err = volume.checkWORMRetention(now)
+			if err != nil {
+				return err
+			}
+			volumes = append(volumes, volume)
+		}
+		for _, id := range c.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			nodes = append(nodes, node)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, volume := range volumes {
+		cancel.step(fmt.Sprintf("deleting volume %v (%v/%v)", volume.Info.Id, i+1, len(volumes)))
+		volume.deletedBy = user
+		err := volume.Destroy(db, executor)
+		if err != nil {
+			return fmt.Errorf("Unable to delete volume %v: %v", volume.Info.Id, err)
+		}
+	}
+
+	if c.DeletePolicy != api.ClusterDeletePolicyCascade {
+		for _, node := range nodes {
+			if len(node.Devices) != 0 {
+				return fmt.Errorf("Unable to delete node %v because it has devices, "+
+					"which are only deleted with the %v policy",
+					node.Info.Id, api.ClusterDeletePolicyCascade)
+			}
+		}
+	}
+
+	for i, node := range nodes {
+		cancel.step(fmt.Sprintf("deleting node %v (%v/%v)", node.Info.Id, i+1, len(nodes)))
+		if c.DeletePolicy == api.ClusterDeletePolicyCascade {
+			for j, deviceId := range node.Devices {
+				cancel.progress(j, len(node.Devices), "deleting device "+deviceId)
+				err := c.destroyDevice(db, executor, allocator, node, deviceId, user)
+				if err != nil {
+					return fmt.Errorf("Unable to delete device %v: %v", deviceId, err)
+				}
+			}
+		}
+
+		// The last node is kept until the others have left its pool
+		if i < len(nodes)-1 {
+			last := nodes[len(nodes)-1]
+			err := executor.PeerDetach(last.ManageHostName(), node.StorageHostName())
+			if err != nil {
+				return err
+			}
+		}
+
+		err := db.Update(func(tx *bolt.Tx) error {
+			defer setTxUser(tx, user)()
+
+			cluster, err := NewClusterEntryFromId(tx, c.Info.Id)
+			if err != nil {
+				return err
+			}
+			cluster.NodeDelete(node.Info.Id)
+			err = cluster.Save(tx)
+			if err != nil {
+				return err
+			}
+
+			node, err := NewNodeEntryFromId(tx, node.Info.Id)
+			if err != nil {
+				return err
+			}
+			err = node.Deregister(tx)
+			if err != nil {
+				return err
+			}
+			return node.Delete(tx, false)
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to delete node %v: %v", node.Info.Id, err)
+		}
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		defer setTxUser(tx, user)()
+
+		cluster, err := NewClusterEntryFromId(tx, c.Info.Id)
+		if err != nil {
+			return err
+		}
+		return cluster.Delete(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The allocator has no ring for a cluster without devices
+	allocator.RemoveCluster(c.Info.Id)
+
+	return nil
+}
+
+func (c *ClusterEntry) destroyDevice(db *bolt.DB,
+	executor executors.Executor,
+	allocator Allocator,
+	node *NodeEntry,
+	deviceId string,
+	user string) error {
+
+	var device *DeviceEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		device, err = NewDeviceEntryFromId(tx, deviceId)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = executor.DeviceTeardown(node.ManageHostName(),
+		device.Info.Name, device.Info.Id)
+	if err != nil {
+		return err
+	}
+
+	err = allocator.RemoveDevice(c, node, device)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		defer setTxUser(tx, user)()
+
+		node, err := NewNodeEntryFromId(tx, node.Info.Id)
+		if err != nil {
+			return err
+		}
+		node.DeviceDelete(device.Info.Id)
+		err = node.Save(tx)
+		if err != nil {
+			return err
+		}
+
+		err = device.Delete(tx)
+		if err != nil {
+			return err
+		}
+		return device.Deregister(tx)
+	})
+}
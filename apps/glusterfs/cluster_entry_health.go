@@ -0,0 +1,172 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/lpabon/godbc"
+)
+
+// Health of a cluster, node or device, from best to worst
+const (
+	HealthGreen  = "green"
+	HealthYellow = "yellow"
+	HealthRed    = "red"
+)
+
+// Node or device which is not green
+type HealthProblem struct {
+	Id     string `json:"id"`
+	Type   string `json:"type"`
+	Health string `json:"health"`
+	Reason string `json:"reason"`
+}
+
+// Summary of the health of a cluster.  The cluster has the worst
+// health of its nodes and devices.  Storage is in KB and only counts
+// the online devices of the online nodes.
+type ClusterHealthReport struct {
+	Id            string          `json:"id"`
+	Health        string          `json:"health"`
+	Nodes         int             `json:"nodes"`
+	NodesOnline   int             `json:"nodes_online"`
+	Devices       int             `json:"devices"`
+	DevicesOnline int             `json:"devices_online"`
+	Storage       api.StorageSize `json:"storage"`
+	Problems      []HealthProblem `json:"problems"`
+}
+
+// Returns the health of the cluster from the states of its nodes and
+// devices, their last scrubs and the watermarks of the devices
+func ClusterHealth(tx *bolt.Tx, clusterId string) (*ClusterHealthReport, error) {
+	godbc.Require(tx != nil)
+
+	cluster, err := NewClusterEntryFromId(tx, clusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ClusterHealthReport{
+		Id:       clusterId,
+		Health:   HealthGreen,
+		Problems: make([]HealthProblem, 0),
+	}
+	problem := func(id, entryType, health, reason string) {
+		report.Problems = append(report.Problems, HealthProblem{
+			Id:     id,
+			Type:   entryType,
+			Health: health,
+			Reason: reason,
+		})
+		report.Health = worseHealth(report.Health, health)
+	}
+
+	for _, nodeId := range cluster.Info.Nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return nil, err
+		}
+
+		report.Nodes++
+		report.Devices += len(node.Devices)
+		if health, reason := node.health(); health != HealthGreen {
+			// The devices of a node which is down are not checked
+			problem(nodeId, "node", health, reason)
+			continue
+		}
+		report.NodesOnline++
+
+		for _, deviceId := range node.Devices {
+			device, err := NewDeviceEntryFromId(tx, deviceId)
+			if err != nil {
+				return nil, err
+			}
+
+			if device.isOnline() {
+				report.DevicesOnline++
+				report.Storage.Total += device.Info.Storage.Total
+				report.Storage.Free += device.Info.Storage.Free
+				report.Storage.Used += device.Info.Storage.Used
+			}
+			if health, reason := device.health(); health != HealthGreen {
+				problem(deviceId, "device", health, reason)
+			}
+		}
+	}
+
+	// Nothing can be allocated
+	if report.Nodes != 0 && report.DevicesOnline == 0 {
+		problem(clusterId, "cluster", HealthRed, "No online devices")
+	}
+
+	return report, nil
+}
+
+// Returns the health of the node from its state
+func (n *NodeEntry) health() (string, string) {
+	return stateHealth(n.State)
+}
+
+// Returns the health of the device from its state, its watermark and
+// its last scrub
+func (d *DeviceEntry) health() (string, string) {
+	if health, reason := stateHealth(d.State); health != HealthGreen {
+		return health, reason
+	}
+
+	switch d.Watermark() {
+	case api.DeviceWatermarkCritical:
+		return HealthRed, "Free space below the critical watermark"
+	case api.DeviceWatermarkHigh:
+		return HealthYellow, "Free space below the high watermark"
+	}
+
+	failed := 0
+	for _, brick := range d.LastScrub.Bricks {
+		if brick.Status == api.ScrubFailed {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return HealthYellow, fmt.Sprintf("Last scrub failed on %v bricks", failed)
+	}
+
+	return HealthGreen, ""
+}
+
+func stateHealth(state api.EntryState) (string, string) {
+	switch state {
+	case api.EntryStateOnline:
+		return HealthGreen, ""
+	case api.EntryStateFailed:
+		return HealthRed, "Failed"
+	}
+	return HealthYellow, fmt.Sprintf("State is %v", state)
+}
+
+func worseHealth(a, b string) string {
+	if a == HealthRed || b == HealthRed {
+		return HealthRed
+	}
+	if a == HealthYellow || b == HealthYellow {
+		return HealthYellow
+	}
+	return HealthGreen
+}
@@ -0,0 +1,282 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/lpabon/godbc"
+)
+
+var (
+	// Time between the checks of the progress of a rebalance or a
+	// heal an operation waits for
+	RebalancePollInterval = 10 * time.Second
+
+	// Time an operation waits for a rebalance or a heal to complete
+	RebalancePollTimeout = 6 * time.Hour
+)
+
+// Only the scheduled policy has a schedule, which must be a valid
+// cron expression
+func validateClusterRebalancePolicy(policy, schedule string) error {
+	switch policy {
+	case "", api.ClusterRebalancePolicyNever, api.ClusterRebalancePolicyOnExpand:
+		if schedule != "" {
+			return fmt.Errorf("A schedule is only used with the %v rebalance policy",
+				api.ClusterRebalancePolicyScheduled)
+		}
+		return nil
+	case api.ClusterRebalancePolicyScheduled:
+		_, err := utils.ParseCronSchedule(schedule)
+		return err
+	}
+	return fmt.Errorf("Unknown rebalance policy %v", policy)
+}
+
+// Returns true if the schedule of the cluster includes a minute after
+// from, up to and including to
+func (c *ClusterEntry) isRebalanceScheduled(from, to time.Time) bool {
+	if c.RebalancePolicy != api.ClusterRebalancePolicyScheduled {
+		return false
+	}
+
+	schedule, err := utils.ParseCronSchedule(c.RebalanceSchedule)
+	if err != nil {
+		logger.LogError("Cluster %v: %v", c.Info.Id, err)
+		return false
+	}
+
+	for t := from.Truncate(time.Minute).Add(time.Minute); !t.After(to); t = t.Add(time.Minute) {
+		if schedule.Matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Starts moving the data of the volume to the bricks added since it
+// was last rebalanced.  Within an operation, waits for the rebalance
+// to complete and reports its progress.
+func (v *VolumeEntry) Rebalance(db *bolt.DB, executor executors.Executor) error {
+	godbc.Require(db != nil)
+
+	if !v.IsStarted() {
+		return ErrVolumeStopped
+	}
+
+	sshhost, err := v.manageHost(db)
+	if err != nil {
+		return err
+	}
+
+	err = executor.VolumeRebalance(sshhost, v.glusterName())
+	if err != nil {
+		logger.Err(err)
+		return err
+	}
+	if v.cancel == nil {
+		return nil
+	}
+
+	return pollProgress(v.cancel, RebalancePollInterval, RebalancePollTimeout, func() (bool, error) {
+		status, err := executor.VolumeRebalanceStatus(sshhost, v.glusterName())
+		if err != nil {
+			return false, err
+		}
+		v.cancel.progress(status.NodesCompleted, status.Nodes,
+			fmt.Sprintf("%v files moved", status.Files))
+		return status.Completed, nil
+	})
+}
+
+// Waits for the heal of the volume to complete, reporting its
+// progress
+func (v *VolumeEntry) waitForHeal(executor executors.Executor, host string) error {
+	return pollProgress(v.cancel, RebalancePollInterval, RebalancePollTimeout, func() (bool, error) {
+		info, err := executor.VolumeHealInfo(host, v.glusterName())
+		if err != nil {
+			return false, err
+		}
+		v.cancel.progress(info.BricksHealed, info.Bricks,
+			fmt.Sprintf("%v entries left to heal", info.Entries))
+		return info.BricksHealed == info.Bricks, nil
+	})
+}
+
+// Calls poll every interval until it returns true or an error, up to
+// the timeout.  Returns ErrCancelled as soon as the cancellation of
+// the operation is requested.
+func pollProgress(cancel *operationCancel,
+	interval, timeout time.Duration,
+	poll func() (bool, error)) error {
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := cancel.checkpoint()
+		if err != nil {
+			return err
+		}
+		completed, err := poll()
+		if err != nil || completed {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Not completed after %v", timeout)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Rebalances the started volumes of the cluster.  A volume failing to
+// rebalance does not stop the others, the failures are logged.  Within
+// an operation, each rebalance is waited for and reported as a step
+// of the operation.  Returns the number of volumes rebalanced.
+func RebalanceClusterVolumes(db *bolt.DB,
+	executor executors.Executor,
+	clusterId string,
+	cancel *operationCancel) int {
+
+	var volumes []*VolumeEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		ids, err := IndexList(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, clusterId)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			volume, err := NewVolumeEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			if volume.IsStarted() {
+				volumes = append(volumes, volume)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.WithField("cluster", clusterId).LogError("Unable to list the volumes of cluster: %v", err)
+		return 0
+	}
+
+	rebalanced := 0
+	for i, volume := range volumes {
+		if cancel.checkpoint() != nil {
+			logger.Info("Rebalance of the volumes of cluster %v cancelled", clusterId)
+			break
+		}
+		cancel.step(fmt.Sprintf("rebalancing volume %v (%v/%v)",
+			volume.Info.Id, i+1, len(volumes)))
+		volume.cancel = cancel
+		err := volume.Rebalance(db, executor)
+		if err != nil {
+			logger.WithField("volume", volume.Info.Id).LogError("Unable to rebalance volume: %v", err)
+			continue
+		}
+		rebalanced++
+	}
+	logger.Info("Rebalanced %v of %v volumes of cluster %v",
+		rebalanced, len(volumes), clusterId)
+
+	return rebalanced
+}
+
+// Rebalances the volumes of the cluster if its policy is on-expand.
+// Called by the operation which added a node or a device to it.
+func rebalanceClusterOnExpand(db *bolt.DB,
+	executor executors.Executor,
+	clusterId string,
+	cancel *operationCancel) {
+
+	var policy string
+	err := db.View(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		if err != nil {
+			return err
+		}
+		policy = cluster.RebalancePolicy
+		return nil
+	})
+	if err != nil {
+		logger.WithField("cluster", clusterId).LogError("Unable to get the rebalance policy of cluster: %v", err)
+		return
+	}
+
+	if policy == api.ClusterRebalancePolicyOnExpand {
+		RebalanceClusterVolumes(db, executor, clusterId, cancel)
+	}
+}
+
+// Rebalances the clusters scheduled for a minute after from, up to
+// and including to
+func RebalanceScheduledClusters(db *bolt.DB, executor executors.Executor, from, to time.Time) {
+	var clusters []string
+	err := db.View(func(tx *bolt.Tx) error {
+		list, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range list {
+			cluster, err := NewClusterEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			if cluster.isRebalanceScheduled(from, to) {
+				clusters = append(clusters, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Err(err)
+		return
+	}
+
+	for _, id := range clusters {
+		logger.Info("Starting scheduled rebalance of cluster %v", id)
+		RebalanceClusterVolumes(db, executor, id, nil)
+	}
+}
+
+// Rebalance the scheduled clusters every interval until stop is
+// closed.  The minutes between two checks are all checked, so none
+// is missed if the ticks are late.
+func rebalanceClustersEvery(db *bolt.DB, executor executors.Executor,
+	interval time.Duration, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			RebalanceScheduledClusters(db, executor, last, now)
+			last = now
+		case <-stop:
+			return
+		}
+	}
+}
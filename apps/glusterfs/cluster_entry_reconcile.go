@@ -0,0 +1,214 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/lpabon/godbc"
+)
+
+// Accounting of a cluster or node corrected by a reconcile, and the
+// storage of its devices afterwards, in KB.  The storage of a node
+// counts all its devices, and the storage of a cluster all its nodes.
+type ReconcileReport struct {
+	Id          string                     `json:"id"`
+	Corrections []string                   `json:"corrections"`
+	Storage     api.StorageSize            `json:"storage"`
+	Nodes       map[string]api.StorageSize `json:"nodes,omitempty"`
+}
+
+// Sets the used and free storage and the shared mount points of the
+// device from the bricks allocated on it, and returns the corrections
+// made.  The caller saves the device if there are any.
+func reconcileDeviceStorage(tx *bolt.Tx, device *DeviceEntry) ([]string, error) {
+	var corrections []string
+	id := device.Info.Id
+
+	// Bricks sharing a mount point only use the space once
+	used := uint64(0)
+	mountpoints := make(map[string]int)
+	for _, brickId := range device.Bricks {
+		brick, err := NewBrickEntryFromId(tx, brickId)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if mp := brick.Info.MountPoint; mp != "" {
+			mountpoints[mp]++
+			if mountpoints[mp] > 1 {
+				continue
+			}
+		}
+		used += brick.TotalSize()
+	}
+	for mp, count := range mountpoints {
+		if count == 1 {
+			delete(mountpoints, mp)
+		}
+	}
+	if !reflect.DeepEqual(mountpoints, device.MountPoints) {
+		corrections = append(corrections, fmt.Sprintf(
+			"Set shared mount points of device %v to %v (was %v)",
+			id, mountpoints, device.MountPoints))
+		device.MountPoints = mountpoints
+	}
+
+	free := uint64(0)
+	if device.Info.Storage.Total > used {
+		free = device.Info.Storage.Total - used
+	}
+	if used != device.Info.Storage.Used || free != device.Info.Storage.Free {
+		corrections = append(corrections, fmt.Sprintf(
+			"Set storage of device %v to used:%v free:%v (was used:%v free:%v)",
+			id, used, free, device.Info.Storage.Used, device.Info.Storage.Free))
+		device.Info.Storage.Used = used
+		device.Info.Storage.Free = free
+	}
+
+	return corrections, nil
+}
+
+// Corrects the accounting of the node from its devices: devices which
+// no longer exist are removed from the node, bricks which no longer
+// exist are removed from their devices, and the storage of each device
+// is set from its bricks.  Returns what was corrected with the storage
+// of the node.
+//
+// The caller must remove the devices removed from the allocator of a
+// running server.
+func ReconcileNode(tx *bolt.Tx, nodeId string) (*ReconcileReport, error) {
+	godbc.Require(tx != nil)
+
+	node, err := NewNodeEntryFromId(tx, nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{
+		Id:          nodeId,
+		Corrections: make([]string, 0),
+	}
+
+	// Deleting from the lists changes them in place
+	removed := false
+	for _, deviceId := range append([]string{}, node.Devices...) {
+		device, err := NewDeviceEntryFromId(tx, deviceId)
+		if err == ErrNotFound {
+			report.Corrections = append(report.Corrections, fmt.Sprintf(
+				"Remove missing device %v from node %v", deviceId, nodeId))
+			node.DeviceDelete(deviceId)
+			removed = true
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		var corrections []string
+		for _, brickId := range append([]string{}, device.Bricks...) {
+			_, err := NewBrickEntryFromId(tx, brickId)
+			if err == ErrNotFound {
+				corrections = append(corrections, fmt.Sprintf(
+					"Remove missing brick %v from device %v", brickId, deviceId))
+				device.BrickDelete(brickId)
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		storage, err := reconcileDeviceStorage(tx, device)
+		if err != nil {
+			return nil, err
+		}
+		corrections = append(corrections, storage...)
+		if len(corrections) != 0 {
+			err = device.Save(tx)
+			if err != nil {
+				return nil, err
+			}
+			report.Corrections = append(report.Corrections, corrections...)
+		}
+
+		report.Storage.Total += device.Info.Storage.Total
+		report.Storage.Free += device.Info.Storage.Free
+		report.Storage.Used += device.Info.Storage.Used
+	}
+
+	if removed {
+		err = node.Save(tx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// Corrects the accounting of all the nodes of the cluster, and removes
+// the nodes which no longer exist from the cluster.  Returns what was
+// corrected with the storage of each node and of the cluster.  This is
+// the repair to run after an incident left the db inconsistent.
+//
+// The caller must remove the devices removed from the allocator of a
+// running server.
+func ReconcileCluster(tx *bolt.Tx, clusterId string) (*ReconcileReport, error) {
+	godbc.Require(tx != nil)
+
+	cluster, err := NewClusterEntryFromId(tx, clusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{
+		Id:          clusterId,
+		Corrections: make([]string, 0),
+		Nodes:       make(map[string]api.StorageSize),
+	}
+	missing := []string{}
+	for _, nodeId := range append([]string{}, cluster.Info.Nodes...) {
+		nodeReport, err := ReconcileNode(tx, nodeId)
+		if err == ErrNotFound {
+			missing = append(missing, nodeId)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		report.Corrections = append(report.Corrections, nodeReport.Corrections...)
+		report.Nodes[nodeId] = nodeReport.Storage
+		report.Storage.Total += nodeReport.Storage.Total
+		report.Storage.Free += nodeReport.Storage.Free
+		report.Storage.Used += nodeReport.Storage.Used
+	}
+
+	if len(missing) != 0 {
+		for _, nodeId := range missing {
+			report.Corrections = append(report.Corrections, fmt.Sprintf(
+				"Remove missing node %v from cluster %v", nodeId, clusterId))
+			cluster.NodeDelete(nodeId)
+		}
+		err = cluster.Save(tx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
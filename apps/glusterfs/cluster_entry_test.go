@@ -19,6 +19,7 @@ package glusterfs
 import (
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/boltdb/bolt"
@@ -168,6 +169,10 @@ func TestNewClusterEntryFromId(t *testing.T) {
 
 	// Save element in database
 	err := app.db.Update(func(tx *bolt.Tx) error {
+		err := IndexAdd(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, c.Info.Id, "vol_abc")
+		if err != nil {
+			return err
+		}
 		return c.Save(tx)
 	})
 	tests.Assert(t, err == nil)
@@ -209,6 +214,10 @@ func TestNewClusterEntrySaveDelete(t *testing.T) {
 
 	// Save element in database
 	err := app.db.Update(func(tx *bolt.Tx) error {
+		err := IndexAdd(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, c.Info.Id, "vol_abc")
+		if err != nil {
+			return err
+		}
 		return c.Save(tx)
 	})
 	tests.Assert(t, err == nil)
@@ -337,6 +346,10 @@ func TestNewClusterEntryNewInfoResponse(t *testing.T) {
 
 	// Save element in database
 	err := app.db.Update(func(tx *bolt.Tx) error {
+		err := IndexAdd(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, c.Info.Id, "vol_abc")
+		if err != nil {
+			return err
+		}
 		return c.Save(tx)
 	})
 	tests.Assert(t, err == nil)
@@ -362,3 +375,629 @@ func TestNewClusterEntryNewInfoResponse(t *testing.T) {
 	tests.Assert(t, reflect.DeepEqual(info.Nodes, c.Info.Nodes))
 	tests.Assert(t, reflect.DeepEqual(info.Volumes, c.Info.Volumes))
 }
+
+func TestCanPlaceAcrossGroups(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		4,      // nodes_per_cluster
+		1,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var clusterId string
+	setGroups := func(groups ...string) {
+		err := app.db.Update(func(tx *bolt.Tx) error {
+			clusters, err := ClusterList(tx)
+			if err != nil {
+				return err
+			}
+			clusterId = clusters[0]
+			cluster, err := NewClusterEntryFromId(tx, clusterId)
+			if err != nil {
+				return err
+			}
+			for i, nodeId := range cluster.Info.Nodes {
+				node, err := NewNodeEntryFromId(tx, nodeId)
+				if err != nil {
+					return err
+				}
+				node.Info.AffinityGroup = groups[i]
+				err = node.Save(tx)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil, err)
+	}
+	canPlace := func(replica int) error {
+		return app.db.View(func(tx *bolt.Tx) error {
+			return CanPlaceAcrossGroups(tx, clusterId, replica)
+		})
+	}
+
+	// Three distinct groups
+	setGroups("a", "a", "b", "c")
+	tests.Assert(t, canPlace(2) == nil)
+	tests.Assert(t, canPlace(3) == nil)
+	err = canPlace(4)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "only 3 are available"), err)
+
+	// All in the same group
+	setGroups("a", "a", "a", "a")
+	tests.Assert(t, canPlace(1) == nil)
+	tests.Assert(t, canPlace(2) != nil)
+
+	// Nodes without a group are each in their own
+	setGroups("a", "a", "", "")
+	tests.Assert(t, canPlace(3) == nil)
+	tests.Assert(t, canPlace(4) != nil)
+
+	// Offline nodes do not count
+	setGroups("a", "b", "c", "d")
+	tests.Assert(t, canPlace(4) == nil)
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		if err != nil {
+			return err
+		}
+		node, err := NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		if err != nil {
+			return err
+		}
+		node.State = api.EntryStateOffline
+		return node.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, canPlace(3) == nil)
+	tests.Assert(t, canPlace(4) != nil)
+
+	// Unknown cluster
+	err = app.db.View(func(tx *bolt.Tx) error {
+		return CanPlaceAcrossGroups(tx, "nosuchcluster", 2)
+	})
+	tests.Assert(t, err == ErrNotFound)
+}
+
+func TestEmptyDevices(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		3,      // nodes_per_cluster
+		2,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// All the devices are empty
+	var clusterId string
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		clusterId = clusters[0]
+
+		devices, err := EmptyDevices(tx, clusterId)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, len(devices) == 6, len(devices))
+
+		_, err = EmptyDevices(tx, "abc")
+		tests.Assert(t, err == ErrNotFound, err)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Place bricks on some of the devices
+	v := createSampleVolumeEntry(10)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		occupied := make(map[string]bool)
+		ids, err := BrickList(tx)
+		tests.Assert(t, err == nil)
+		for _, id := range ids {
+			brick, err := NewBrickEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			occupied[brick.Info.DeviceId] = true
+		}
+		tests.Assert(t, len(occupied) >= 2 && len(occupied) < 6, occupied)
+
+		devices, err := EmptyDevices(tx, clusterId)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, len(devices) == 6-len(occupied), len(devices))
+		for _, device := range devices {
+			tests.Assert(t, !occupied[device.Info.Id], device.Info.Id)
+			tests.Assert(t, len(device.Bricks) == 0)
+		}
+
+		// Devices which are not online are still listed
+		devices[0].State = api.EntryStateOffline
+		tests.Assert(t, devices[0].Save(tx) == nil)
+		offline, err := EmptyDevices(tx, clusterId)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, len(offline) == len(devices))
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestReconcileCluster(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		3,      // nodes_per_cluster
+		2,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+
+	// Storage of each node and of the cluster before the drift
+	var clusterId string
+	nodes := make(map[string]api.StorageSize)
+	var total api.StorageSize
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		clusterId = clusters[0]
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		tests.Assert(t, err == nil)
+		for _, nodeId := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			tests.Assert(t, err == nil)
+			var storage api.StorageSize
+			for _, deviceId := range node.Devices {
+				device, err := NewDeviceEntryFromId(tx, deviceId)
+				tests.Assert(t, err == nil)
+				storage.Total += device.Info.Storage.Total
+				storage.Free += device.Info.Storage.Free
+				storage.Used += device.Info.Storage.Used
+			}
+			nodes[nodeId] = storage
+			total.Total += storage.Total
+			total.Free += storage.Free
+			total.Used += storage.Used
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, total.Used != 0)
+
+	// Nothing to correct
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		report, err := ReconcileCluster(tx, clusterId)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, len(report.Corrections) == 0, report.Corrections)
+		tests.Assert(t, report.Storage == total, report.Storage, total)
+
+		_, err = ReconcileCluster(tx, "abc")
+		tests.Assert(t, err == ErrNotFound, err)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Drift the accounting of a device with bricks, of an empty
+	// device, of a node and of the cluster
+	var drifted, empty string
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		brick, err := NewBrickEntryFromId(tx, v.Bricks[0])
+		tests.Assert(t, err == nil)
+		device, err := NewDeviceEntryFromId(tx, brick.Info.DeviceId)
+		tests.Assert(t, err == nil)
+		drifted = device.Info.Id
+		device.Info.Storage.Used += 7 * GB
+		device.Info.Storage.Free -= 7 * GB
+		device.BrickAdd("0123456789abcdef0123456789abcdef")
+		tests.Assert(t, device.Save(tx) == nil)
+
+		devices, err := EmptyDevices(tx, clusterId)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(devices) != 0)
+		empty = devices[0].Info.Id
+		devices[0].Info.Storage.Used = 3 * GB
+		devices[0].Info.Storage.Free = devices[0].Info.Storage.Total - 3*GB
+		tests.Assert(t, devices[0].Save(tx) == nil)
+
+		node, err := NewNodeEntryFromId(tx, device.NodeId)
+		tests.Assert(t, err == nil)
+		node.DeviceAdd("1123456789abcdef0123456789abcdef")
+		tests.Assert(t, node.Save(tx) == nil)
+
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		tests.Assert(t, err == nil)
+		cluster.NodeAdd("2123456789abcdef0123456789abcdef")
+		return cluster.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		report, err := ReconcileCluster(tx, clusterId)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, report.Id == clusterId)
+		tests.Assert(t, len(report.Corrections) == 5, report.Corrections)
+		corrections := strings.Join(report.Corrections, "\n")
+		for _, id := range []string{
+			"0123456789abcdef0123456789abcdef",
+			"1123456789abcdef0123456789abcdef",
+			"2123456789abcdef0123456789abcdef",
+			"Set storage of device " + drifted,
+			"Set storage of device " + empty,
+		} {
+			tests.Assert(t, strings.Contains(corrections, id), id, corrections)
+		}
+
+		// The totals of the nodes and the cluster are those before
+		// the drift
+		tests.Assert(t, report.Storage == total, report.Storage, total)
+		tests.Assert(t, len(report.Nodes) == len(nodes))
+		for nodeId, storage := range nodes {
+			tests.Assert(t, report.Nodes[nodeId] == storage, nodeId)
+		}
+
+		device, err := NewDeviceEntryFromId(tx, empty)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, device.Info.Storage.Used == 0)
+		tests.Assert(t, device.Info.Storage.Free == device.Info.Storage.Total)
+		device, err = NewDeviceEntryFromId(tx, drifted)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, !utils.SortedStringHas(device.Bricks,
+			"0123456789abcdef0123456789abcdef"))
+		node, err := NewNodeEntryFromId(tx, device.NodeId)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(node.Devices) == 2)
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(cluster.Info.Nodes) == 3)
+
+		// Corrected once
+		report, err = ReconcileCluster(tx, clusterId)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, len(report.Corrections) == 0, report.Corrections)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestSelectZoneBalancedDevice(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	// Nodes alternate between zones 0 and 1
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		4,      // nodes_per_cluster
+		1,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Zone 0 has the device with the most free space, but more bricks
+	var clusterId, roomiest, balanced string
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		clusterId = clusters[0]
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		tests.Assert(t, err == nil)
+
+		// Space used by the devices of each zone
+		used := map[int][]uint64{
+			0: {0, 100 * GB},
+			1: {200 * GB, 300 * GB},
+		}
+		for _, nodeId := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			tests.Assert(t, err == nil)
+			device, err := NewDeviceEntryFromId(tx, node.Devices[0])
+			tests.Assert(t, err == nil)
+
+			zone := node.Info.Zone
+			size := used[zone][0]
+			used[zone] = used[zone][1:]
+			device.Info.Storage.Used = size
+			device.Info.Storage.Free = device.Info.Storage.Total - size
+			switch {
+			case zone == 0:
+				device.Bricks = append(device.Bricks, utils.GenUUID())
+				if size == 0 {
+					roomiest = device.Info.Id
+				}
+			case size == 200*GB:
+				balanced = device.Info.Id
+			}
+			tests.Assert(t, device.Save(tx) == nil)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, roomiest != "" && balanced != "")
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		device, err := SelectZoneBalancedDevice(tx, clusterId, 10*GB)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, device.Info.Id == balanced, device.Info.Id)
+		tests.Assert(t, device.Info.Id != roomiest)
+
+		// Only the roomiest device has the space
+		device, err = SelectZoneBalancedDevice(tx, clusterId, 450*GB)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, device.Info.Id == roomiest, device.Info.Id)
+
+		_, err = SelectZoneBalancedDevice(tx, clusterId, 600*GB)
+		tests.Assert(t, err == ErrNoSpace, err)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Bricks without replicas go to the zone with the fewest bricks
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityDistributeOnly
+	v := NewVolumeEntryFromRequest(req)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		for _, id := range v.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, node.Info.Zone == 1, node.Info.Zone)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestClusterHealth(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		3,      // nodes_per_cluster
+		2,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var (
+		clusterId string
+		nodes     []string
+		devices   []string
+	)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		clusterId = clusters[0]
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		tests.Assert(t, err == nil)
+		nodes = cluster.Info.Nodes
+		node, err := NewNodeEntryFromId(tx, nodes[0])
+		tests.Assert(t, err == nil)
+		devices = node.Devices
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	health := func() *ClusterHealthReport {
+		var report *ClusterHealthReport
+		err := app.db.View(func(tx *bolt.Tx) error {
+			var err error
+			report, err = ClusterHealth(tx, clusterId)
+			return err
+		})
+		tests.Assert(t, err == nil, err)
+		return report
+	}
+	updateDevice := func(id string, update func(d *DeviceEntry)) {
+		err := app.db.Update(func(tx *bolt.Tx) error {
+			device, err := NewDeviceEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			update(device)
+			return device.Save(tx)
+		})
+		tests.Assert(t, err == nil)
+	}
+
+	report := health()
+	tests.Assert(t, report.Health == HealthGreen, report)
+	tests.Assert(t, report.Nodes == 3)
+	tests.Assert(t, report.NodesOnline == 3)
+	tests.Assert(t, report.Devices == 6)
+	tests.Assert(t, report.DevicesOnline == 6)
+	tests.Assert(t, report.Storage.Total != 0)
+	tests.Assert(t, report.Storage.Free == report.Storage.Total)
+	tests.Assert(t, len(report.Problems) == 0)
+
+	// A scrub failed on a brick of a device
+	updateDevice(devices[0], func(d *DeviceEntry) {
+		d.LastScrub.Bricks = []api.BrickScrubStatus{
+			{Id: "b1", Status: api.ScrubStarted},
+			{Id: "b2", Status: api.ScrubFailed},
+		}
+	})
+	report = health()
+	tests.Assert(t, report.Health == HealthYellow, report)
+	tests.Assert(t, len(report.Problems) == 1)
+	tests.Assert(t, report.Problems[0].Id == devices[0])
+	tests.Assert(t, report.Problems[0].Type == "device")
+	tests.Assert(t, report.Problems[0].Health == HealthYellow)
+
+	// The other device is nearly full
+	updateDevice(devices[1], func(d *DeviceEntry) {
+		d.Info.Storage.Used = d.Info.Storage.Total * 97 / 100
+		d.Info.Storage.Free = d.Info.Storage.Total - d.Info.Storage.Used
+	})
+	report = health()
+	tests.Assert(t, report.Health == HealthRed, report)
+	tests.Assert(t, len(report.Problems) == 2)
+	tests.Assert(t, report.Problems[1].Id == devices[1])
+	tests.Assert(t, report.Problems[1].Health == HealthRed)
+	tests.Assert(t, report.Storage.Free < report.Storage.Total)
+
+	// A failed device
+	updateDevice(devices[1], func(d *DeviceEntry) {
+		d.Info.Storage.Used = 0
+		d.Info.Storage.Free = d.Info.Storage.Total
+		d.State = api.EntryStateFailed
+	})
+	report = health()
+	tests.Assert(t, report.Health == HealthRed, report)
+	tests.Assert(t, report.DevicesOnline == 5)
+	tests.Assert(t, report.Problems[1].Reason == "Failed")
+
+	// An offline device is only yellow
+	updateDevice(devices[1], func(d *DeviceEntry) {
+		d.State = api.EntryStateOffline
+	})
+	report = health()
+	tests.Assert(t, report.Health == HealthYellow, report)
+
+	// The devices of an offline node are not checked
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, nodes[0])
+		tests.Assert(t, err == nil)
+		node.State = api.EntryStateOffline
+		return node.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+	report = health()
+	tests.Assert(t, report.Health == HealthYellow, report)
+	tests.Assert(t, report.NodesOnline == 2)
+	tests.Assert(t, report.DevicesOnline == 4)
+	tests.Assert(t, len(report.Problems) == 1)
+	tests.Assert(t, report.Problems[0].Id == nodes[0])
+	tests.Assert(t, report.Problems[0].Type == "node")
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		_, err := ClusterHealth(tx, "missing")
+		return err
+	})
+	tests.Assert(t, err == ErrNotFound)
+}
+
+func TestClusterEntryEstimatedDeleteImpact(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var clusterId string
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		clusterId = clusters[0]
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	impact := func() *api.DeleteImpact {
+		var impact *api.DeleteImpact
+		err := app.db.View(func(tx *bolt.Tx) error {
+			cluster, err := NewClusterEntryFromId(tx, clusterId)
+			tests.Assert(t, err == nil)
+			impact, err = cluster.EstimatedDeleteImpact(tx)
+			return err
+		})
+		tests.Assert(t, err == nil, err)
+		return impact
+	}
+
+	empty := impact()
+	tests.Assert(t, empty.Id == clusterId)
+	tests.Assert(t, empty.Volumes == 0)
+	tests.Assert(t, empty.Bricks == 0)
+	tests.Assert(t, empty.StorageFreed == 0)
+	tests.Assert(t, empty.Nodes == 3)
+	tests.Assert(t, empty.Devices == 6)
+	tests.Assert(t, len(empty.Pvcs) == 0)
+	tests.Assert(t, empty.Hash != "")
+	tests.Assert(t, impact().Hash == empty.Hash)
+
+	// Named by the Kubernetes provisioner or not
+	for _, name := range []string{"", "vol_prod_claim1_0a1b", "data_a_b"} {
+		v := createSampleVolumeEntry(10)
+		v.Info.Name = name
+		err = v.Create(app.db, app.executor, app.allocator)
+		tests.Assert(t, err == nil, err)
+	}
+
+	full := impact()
+	tests.Assert(t, full.Volumes == 3)
+	tests.Assert(t, full.Bricks >= 3*2, full.Bricks)
+	tests.Assert(t, full.StorageFreed >= 3*2*10*GB, full.StorageFreed)
+	tests.Assert(t, reflect.DeepEqual(full.Pvcs, []string{"prod/claim1"}), full.Pvcs)
+	tests.Assert(t, full.Hash != empty.Hash)
+	tests.Assert(t, impact().Hash == full.Hash)
+
+	// A volume replaced by another with the same impact
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		tests.Assert(t, err == nil)
+		volume, err := NewVolumeEntryFromId(tx, cluster.Info.Volumes[0])
+		tests.Assert(t, err == nil)
+		err = volume.Delete(tx)
+		tests.Assert(t, err == nil)
+		cluster.VolumeDelete(volume.Info.Id)
+		volume.Info.Id = utils.GenUUID()
+		cluster.VolumeAdd(volume.Info.Id)
+		err = volume.Save(tx)
+		tests.Assert(t, err == nil)
+		return cluster.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+	replaced := impact()
+	tests.Assert(t, replaced.Volumes == full.Volumes)
+	tests.Assert(t, replaced.Bricks == full.Bricks)
+	tests.Assert(t, replaced.StorageFreed == full.StorageFreed)
+	tests.Assert(t, replaced.Hash != full.Hash)
+
+	pvc, ok := kubernetesVolumePvc("vol_3f8a")
+	tests.Assert(t, !ok, pvc)
+	pvc, ok = kubernetesVolumePvc("p__claim_uid")
+	tests.Assert(t, !ok, pvc)
+}
@@ -0,0 +1,109 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/lpabon/godbc"
+)
+
+// Returns the device to place a brick of the size given in KB on so
+// that the zones of the cluster stay balanced.  The device is on a
+// node of the zone with the fewest bricks among the zones which have
+// the space for the brick, and has the most free space in that zone.
+// Devices of the nodes preferred for new bricks are chosen before the
+// others, as by the allocator, and devices above the high watermark
+// are not chosen.  Returns ErrNoSpace if no other online device has
+// the space.
+func SelectZoneBalancedDevice(tx *bolt.Tx, clusterId string, size uint64) (*DeviceEntry, error) {
+	godbc.Require(tx != nil)
+
+	cluster, err := NewClusterEntryFromId(tx, clusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		device    *DeviceEntry
+		zone      int
+		preferred bool
+	}
+	zoneBricks := make(map[int]int)
+	candidates := make([]candidate, 0)
+	for _, nodeId := range cluster.Info.Nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, deviceId := range node.Devices {
+			device, err := NewDeviceEntryFromId(tx, deviceId)
+			if err != nil {
+				return nil, err
+			}
+
+			// Bricks on nodes and devices which are not online
+			// still count in their zone
+			zoneBricks[node.Info.Zone] += len(device.Bricks)
+			if node.isOnline() && device.isOnline() &&
+				!device.AboveHighWatermark() &&
+				device.TakesBrickSize(size) && device.StorageCheck(size) {
+				candidates = append(candidates, candidate{
+					device:    device,
+					zone:      node.Info.Zone,
+					preferred: node.Info.PreferredForNewBricks,
+				})
+			}
+		}
+	}
+
+	var best *candidate
+	for i := range candidates {
+		c := &candidates[i]
+		switch {
+		case best == nil:
+		case c.preferred != best.preferred:
+			if !c.preferred {
+				continue
+			}
+		case !betterZoneBalanced(c.device, zoneBricks[c.zone],
+			best.device, zoneBricks[best.zone]):
+			continue
+		}
+		best = c
+	}
+	if best == nil {
+		return nil, ErrNoSpace
+	}
+
+	return best.device, nil
+}
+
+// Returns true if the device a in a zone with the bricks given is a
+// better choice than the device b.  Ties are broken by the device id
+// so that the choice does not depend on the order of the devices.
+func betterZoneBalanced(a *DeviceEntry, aZoneBricks int,
+	b *DeviceEntry, bZoneBricks int) bool {
+
+	if aZoneBricks != bZoneBricks {
+		return aZoneBricks < bZoneBricks
+	}
+	if a.Info.Storage.Free != b.Info.Storage.Free {
+		return a.Info.Storage.Free > b.Info.Storage.Free
+	}
+	return a.Info.Id < b.Info.Id
+}
@@ -0,0 +1,131 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/lpabon/godbc"
+)
+
+const (
+	CODEC_GOB  = "gob"
+	CODEC_JSON = "json"
+
+	dbCodecKey = "codec"
+)
+
+// Encodes the entries saved in the db
+type Codec interface {
+	Name() string
+	Encode(v interface{}) ([]byte, error)
+	Decode(buffer []byte, v interface{}) error
+}
+
+type gobCodec struct{}
+
+func (c gobCodec) Name() string {
+	return CODEC_GOB
+}
+
+func (c gobCodec) Encode(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	enc := gob.NewEncoder(&buffer)
+	err := enc.Encode(v)
+
+	return buffer.Bytes(), err
+}
+
+func (c gobCodec) Decode(buffer []byte, v interface{}) error {
+	dec := gob.NewDecoder(bytes.NewReader(buffer))
+	return dec.Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (c jsonCodec) Name() string {
+	return CODEC_JSON
+}
+
+func (c jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (c jsonCodec) Decode(buffer []byte, v interface{}) error {
+	return json.Unmarshal(buffer, v)
+}
+
+// Codec of the cluster, node, device and brick entries.  Volumes and
+// pending operations are always encoded with gob since their
+// durability is an interface.
+var entryCodec Codec = gobCodec{}
+
+// Returns the codec with the name, gob if the name is empty
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case CODEC_GOB, "":
+		return gobCodec{}, nil
+	case CODEC_JSON:
+		return jsonCodec{}, nil
+	}
+	return nil, fmt.Errorf("Unknown codec %v, must be %v or %v",
+		name, CODEC_GOB, CODEC_JSON)
+}
+
+// Returns the codec of the entries in the db.  Databases created
+// before the codec was saved use gob.
+func DbCodec(tx *bolt.Tx) (Codec, error) {
+	godbc.Require(tx != nil)
+
+	b := tx.Bucket([]byte(BOLTDB_BUCKET_METADATA))
+	if b == nil {
+		return gobCodec{}, nil
+	}
+	name := b.Get([]byte(dbCodecKey))
+	if name == nil {
+		return gobCodec{}, nil
+	}
+
+	return NewCodec(string(name))
+}
+
+func dbSetCodec(tx *bolt.Tx, codec Codec) error {
+	godbc.Require(tx != nil)
+
+	b, err := tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_METADATA))
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(dbCodecKey), []byte(codec.Name()))
+}
+
+// Decodes the entries with the codec of the db
+func useDbCodec(db *bolt.DB) error {
+	return db.View(func(tx *bolt.Tx) error {
+		codec, err := DbCodec(tx)
+		if err != nil {
+			return err
+		}
+		entryCodec = codec
+		return nil
+	})
+}
@@ -0,0 +1,186 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/tests"
+)
+
+func newTestAppWithCodec(dbfile, codec string) *App {
+	return NewApp(bytes.NewBuffer([]byte(`{
+		"glusterfs" : {
+			"executor" : "mock",
+			"allocator" : "simple",
+			"db_codec" : "` + codec + `",
+			"db" : "` + dbfile + `"
+		}
+	}`)))
+}
+
+func TestNewCodec(t *testing.T) {
+	c, err := NewCodec("")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, c.Name() == CODEC_GOB)
+
+	c, err = NewCodec(CODEC_JSON)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, c.Name() == CODEC_JSON)
+
+	_, err = NewCodec("protobuf")
+	tests.Assert(t, err != nil)
+
+	// Unknown codecs are refused at startup
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&entryCodec, entryCodec).Restore()
+	app := newTestAppWithCodec(tmpfile, "protobuf")
+	tests.Assert(t, app == nil)
+}
+
+func TestCodecSaveLoad(t *testing.T) {
+	defer tests.Patch(&entryCodec, entryCodec).Restore()
+
+	for _, codec := range []string{CODEC_GOB, CODEC_JSON} {
+		tmpfile := tests.Tempfile()
+		defer os.Remove(tmpfile)
+
+		app := newTestAppWithCodec(tmpfile, codec)
+		tests.Assert(t, app != nil)
+		err := setupSampleDbWithTopology(app,
+			1,    // clusters
+			3,    // nodes_per_cluster
+			2,    // devices_per_node,
+			1*TB, // disksize)
+		)
+		tests.Assert(t, err == nil)
+
+		v := createSampleVolumeEntry(100)
+		err = v.Create(app.db, app.executor, app.allocator)
+		tests.Assert(t, err == nil, err)
+
+		// Load the entries as they were saved
+		var (
+			cluster *ClusterEntry
+			nodes   []*NodeEntry
+			devices []*DeviceEntry
+			bricks  []*BrickEntry
+		)
+		err = app.db.View(func(tx *bolt.Tx) error {
+			cluster, err = NewClusterEntryFromId(tx, v.Info.Cluster)
+			tests.Assert(t, err == nil)
+			for _, nodeId := range cluster.Info.Nodes {
+				node, err := NewNodeEntryFromId(tx, nodeId)
+				tests.Assert(t, err == nil)
+				nodes = append(nodes, node)
+				for _, deviceId := range node.Devices {
+					device, err := NewDeviceEntryFromId(tx, deviceId)
+					tests.Assert(t, err == nil)
+					devices = append(devices, device)
+				}
+			}
+			for _, brickId := range v.Bricks {
+				brick, err := NewBrickEntryFromId(tx, brickId)
+				tests.Assert(t, err == nil)
+				bricks = append(bricks, brick)
+			}
+
+			// Stored with the codec
+			raw := tx.Bucket([]byte(BOLTDB_BUCKET_NODE)).Get([]byte(nodes[0].Info.Id))
+			tests.Assert(t, (raw[0] == '{') == (codec == CODEC_JSON), codec)
+			return nil
+		})
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(nodes) == 3)
+		tests.Assert(t, len(devices) == 6)
+		tests.Assert(t, len(bricks) > 0)
+		app.Close()
+
+		// Loaded the same after a restart
+		app = newTestAppWithCodec(tmpfile, codec)
+		tests.Assert(t, app != nil)
+		err = app.db.View(func(tx *bolt.Tx) error {
+			c, err := NewClusterEntryFromId(tx, cluster.Info.Id)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, reflect.DeepEqual(c, cluster))
+			for _, node := range nodes {
+				n, err := NewNodeEntryFromId(tx, node.Info.Id)
+				tests.Assert(t, err == nil)
+				tests.Assert(t, reflect.DeepEqual(n, node), codec, n, node)
+			}
+			for _, device := range devices {
+				d, err := NewDeviceEntryFromId(tx, device.Info.Id)
+				tests.Assert(t, err == nil)
+				tests.Assert(t, reflect.DeepEqual(d, device), codec, d, device)
+			}
+			for _, brick := range bricks {
+				b, err := NewBrickEntryFromId(tx, brick.Info.Id)
+				tests.Assert(t, err == nil)
+				tests.Assert(t, reflect.DeepEqual(b, brick), codec, b, brick)
+			}
+
+			volume, err := NewVolumeEntryFromId(tx, v.Info.Id)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, volume.Info.Name == v.Info.Name)
+			return nil
+		})
+		tests.Assert(t, err == nil)
+		app.Close()
+	}
+}
+
+func TestCodecKeptByExistingDb(t *testing.T) {
+	defer tests.Patch(&entryCodec, entryCodec).Restore()
+
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := newTestAppWithCodec(tmpfile, CODEC_JSON)
+	tests.Assert(t, app != nil)
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+	app.Close()
+
+	// The json entries are still read
+	app = newTestAppWithCodec(tmpfile, CODEC_GOB)
+	tests.Assert(t, app != nil)
+	defer app.Close()
+	tests.Assert(t, entryCodec.Name() == CODEC_JSON)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		codec, err := DbCodec(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, codec.Name() == CODEC_JSON)
+
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(cluster.Info.Nodes) == 2)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
@@ -0,0 +1,252 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lpabon/godbc"
+)
+
+const (
+	backupPrefix     = "heketi-backup-"
+	backupSuffix     = ".db"
+	backupTimeFormat = "20060102-150405"
+)
+
+var (
+	// Hours between the backups written to the backup directory
+	BackupInterval = 24
+
+	// Backups kept in the backup directory, the oldest are removed
+	BackupsKept = 7
+
+	// Largest database in bytes accepted by a restore
+	BackupRestoreMaxSize int64 = 1 << 30
+)
+
+// Returns the name of the file of a backup taken at the time given
+func backupFileName(t time.Time) string {
+	return backupPrefix + t.UTC().Format(backupTimeFormat) + backupSuffix
+}
+
+// Returns the file of a restore waiting for the next start
+func dbRestoreFile(dbfile string) string {
+	return dbfile + ".restore"
+}
+
+// Writes a consistent copy of the db to the file.  The copy is
+// written to a temporary file which is renamed once synced, so that
+// the file is never left partly written.
+func BackupDb(db *bolt.DB, filename string) error {
+	godbc.Require(db != nil)
+
+	tmpfile := filename + ".tmp"
+	fp, err := os.OpenFile(tmpfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(fp)
+		return err
+	})
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpfile, filename)
+	}
+	if err != nil {
+		os.Remove(tmpfile)
+		return fmt.Errorf("Unable to write backup %v: %v", filename, err)
+	}
+
+	return nil
+}
+
+// Returns an error unless the file is a whole heketi database which
+// this version can open
+func ValidateDbFile(filename string) error {
+	db, err := openDb(filename, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(BOLTDB_BUCKET_CLUSTER)) == nil {
+			return fmt.Errorf("Database %v is not a heketi database", filename)
+		}
+		return dbCheckSchemaVersion(tx)
+	})
+}
+
+// Saves the database read from r to replace the db file at the next
+// start, once validated
+func StageDbRestore(dbfile string, r io.Reader) error {
+	restore := dbRestoreFile(dbfile)
+	tmpfile := restore + ".tmp"
+	fp, err := os.OpenFile(tmpfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile)
+
+	_, err = io.Copy(fp, r)
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to save database to restore: %v", err)
+	}
+
+	err = ValidateDbFile(tmpfile)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpfile, restore)
+}
+
+// Replaces the db file with the restore staged, if any.  The db file
+// replaced is kept next to it.  A restore which is no longer valid is
+// moved away and the db file is kept.
+func applyDbRestore(dbfile string) error {
+	restore := dbRestoreFile(dbfile)
+	if _, err := os.Stat(restore); os.IsNotExist(err) {
+		return nil
+	}
+
+	err := ValidateDbFile(restore)
+	if err != nil {
+		logger.LogError("Not restoring %v: %v", restore, err)
+		return os.Rename(restore, restore+".invalid")
+	}
+
+	if _, err := os.Stat(dbfile); err == nil {
+		replaced := fmt.Sprintf("%v.%v.replaced", dbfile,
+			time.Now().UTC().Format(backupTimeFormat))
+		err = os.Rename(dbfile, replaced)
+		if err != nil {
+			return err
+		}
+		logger.Warning("Moved database %v to %v", dbfile, replaced)
+	}
+
+	err = os.Rename(restore, dbfile)
+	if err != nil {
+		return err
+	}
+	logger.Warning("Restored database %v from %v", dbfile, restore)
+	return nil
+}
+
+// Writes a backup to the directory and removes the oldest backups
+// beyond those kept
+func backupDbToDir(db *bolt.DB, dir string, kept int) error {
+	err := BackupDb(db, filepath.Join(dir, backupFileName(time.Now())))
+	if err != nil {
+		return err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*"+backupSuffix))
+	if err != nil {
+		return err
+	}
+
+	// Names sort by the time of the backup
+	backups := make([]string, 0, len(matches))
+	for _, match := range matches {
+		name := filepath.Base(match)
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix)
+		if _, err := time.Parse(backupTimeFormat, ts); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+	for kept > 0 && len(backups) > kept {
+		err = os.Remove(backups[0])
+		if err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// Write a backup to the directory every interval until stop is closed
+func backupDbEvery(db *bolt.DB, dir string, kept int,
+	interval time.Duration, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := backupDbToDir(db, dir, kept)
+			if err != nil {
+				logger.LogError("Unable to back up database: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Saves the database of the request to replace the db at the next
+// start of the server, as the db in use cannot be replaced
+func (a *App) BackupRestore(w http.ResponseWriter, r *http.Request) {
+	if a.db.IsReadOnly() {
+		http.Error(w, "Database was opened read-only, "+
+			"restart the server to allow changes", http.StatusConflict)
+		return
+	}
+
+	if r.ContentLength > BackupRestoreMaxSize {
+		http.Error(w, fmt.Sprintf("Database is larger than %v bytes",
+			BackupRestoreMaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, BackupRestoreMaxSize)
+
+	err := StageDbRestore(dbfilename, r.Body)
+	if err != nil {
+		logger.LogError("Unable to stage database restore: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.Warning("%v staged a database restore, restart the server "+
+		"to complete it", requestUser(r))
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,212 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/tests"
+)
+
+func testClusterList(t *testing.T, app *App) []string {
+	var clusters []string
+	err := app.db.View(func(tx *bolt.Tx) error {
+		var err error
+		clusters, err = ClusterList(tx)
+		return err
+	})
+	tests.Assert(t, err == nil, err)
+	return clusters
+}
+
+func TestBackupDb(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	backup := tests.Tempfile()
+	defer os.Remove(backup)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		2,      // clusters
+		2,      // nodes_per_cluster
+		1,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	err = BackupDb(app.db, backup)
+	tests.Assert(t, err == nil, err)
+	_, err = os.Stat(backup + ".tmp")
+	tests.Assert(t, os.IsNotExist(err))
+	tests.Assert(t, ValidateDbFile(backup) == nil)
+
+	db, err := bolt.Open(backup, 0600, &bolt.Options{ReadOnly: true})
+	tests.Assert(t, err == nil)
+	err = db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(clusters) == 2)
+		return nil
+	})
+	db.Close()
+	tests.Assert(t, err == nil)
+
+	// Not a database
+	err = ioutil.WriteFile(backup, []byte("not a database"), 0600)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, ValidateDbFile(backup) != nil)
+
+	// A bolt database which is not a heketi database
+	os.Remove(backup)
+	db, err = bolt.Open(backup, 0600, nil)
+	tests.Assert(t, err == nil)
+	db.Close()
+	err = ValidateDbFile(backup)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "not a heketi database"), err)
+}
+
+func TestBackupDbToDir(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	dir, err := ioutil.TempDir("", "heketi-backups")
+	tests.Assert(t, err == nil)
+	defer os.RemoveAll(dir)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	// Older backups, and a file which is not a backup
+	old := []string{
+		backupFileName(time.Now().Add(-48 * time.Hour)),
+		backupFileName(time.Now().Add(-24 * time.Hour)),
+	}
+	for _, name := range append(old, "heketi-backup-other.db") {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte{}, 0600)
+		tests.Assert(t, err == nil)
+	}
+
+	err = backupDbToDir(app.db, dir, 2)
+	tests.Assert(t, err == nil, err)
+
+	// The oldest backup is removed
+	files, err := ioutil.ReadDir(dir)
+	tests.Assert(t, err == nil)
+	names := make([]string, 0)
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	tests.Assert(t, len(names) == 3, names)
+	_, err = os.Stat(filepath.Join(dir, old[0]))
+	tests.Assert(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, old[1]))
+	tests.Assert(t, err == nil)
+	_, err = os.Stat(filepath.Join(dir, "heketi-backup-other.db"))
+	tests.Assert(t, err == nil)
+}
+
+func TestBackupRestore(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer os.Remove(dbRestoreFile(tmpfile))
+	other := tests.Tempfile()
+	defer os.Remove(other)
+
+	// Backup of a db with a cluster
+	app := NewTestApp(other)
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		2,      // nodes_per_cluster
+		1,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+	clusters := testClusterList(t, app)
+	var backup bytes.Buffer
+	err = app.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(&backup)
+		return err
+	})
+	tests.Assert(t, err == nil)
+	app.Close()
+
+	// Restored to an empty db
+	app = NewTestApp(tmpfile)
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+	ts := httptest.NewServer(router)
+	c := client.NewClientNoAuth(ts.URL)
+
+	// Invalid files are refused
+	err = c.RestoreDb(strings.NewReader("not a database"))
+	tests.Assert(t, err != nil)
+	_, err = os.Stat(dbRestoreFile(tmpfile))
+	tests.Assert(t, os.IsNotExist(err))
+
+	// So are files larger than the limit
+	defer func(max int64) {
+		BackupRestoreMaxSize = max
+	}(BackupRestoreMaxSize)
+	BackupRestoreMaxSize = int64(backup.Len() - 1)
+	err = c.RestoreDb(bytes.NewReader(backup.Bytes()))
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "larger"), err)
+	err = c.RestoreDb(io.MultiReader(bytes.NewReader(backup.Bytes())))
+	tests.Assert(t, err != nil)
+	_, err = os.Stat(dbRestoreFile(tmpfile))
+	tests.Assert(t, os.IsNotExist(err))
+	BackupRestoreMaxSize = int64(backup.Len())
+
+	err = c.RestoreDb(bytes.NewReader(backup.Bytes()))
+	tests.Assert(t, err == nil, err)
+	_, err = os.Stat(dbRestoreFile(tmpfile))
+	tests.Assert(t, err == nil)
+
+	// The db in use is unchanged until restarted
+	tests.Assert(t, len(testClusterList(t, app)) == 0)
+	ts.Close()
+	app.Close()
+
+	app = NewTestApp(tmpfile)
+	restored := testClusterList(t, app)
+	app.Close()
+	tests.Assert(t, len(restored) == 1)
+	tests.Assert(t, restored[0] == clusters[0])
+	_, err = os.Stat(dbRestoreFile(tmpfile))
+	tests.Assert(t, os.IsNotExist(err))
+
+	// The db replaced is kept
+	replaced, err := filepath.Glob(tmpfile + ".*.replaced")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(replaced) == 1, replaced)
+	os.Remove(replaced[0])
+}
@@ -0,0 +1,302 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+type dbDumpEntry interface {
+	Unmarshal(buffer []byte) error
+}
+
+type dbDumpBucket struct {
+	bucket   string
+	newEntry func() dbDumpEntry
+	summary  func(entry dbDumpEntry) string
+}
+
+// Buckets which can be dumped, by the name used on the command line
+var dbDumpBuckets = map[string]dbDumpBucket{
+	"clusters": {
+		bucket:   BOLTDB_BUCKET_CLUSTER,
+		newEntry: func() dbDumpEntry { return NewClusterEntry() },
+		summary: func(e dbDumpEntry) string {
+			c := e.(*ClusterEntry)
+			return fmt.Sprintf("nodes:%v volumes:%v",
+				len(c.Info.Nodes), len(c.Info.Volumes))
+		},
+	},
+	"nodes": {
+		bucket:   BOLTDB_BUCKET_NODE,
+		newEntry: func() dbDumpEntry { return NewNodeEntry() },
+		summary: func(e dbDumpEntry) string {
+			n := e.(*NodeEntry)
+			return fmt.Sprintf("%v zone:%v state:%v cluster:%v devices:%v",
+				n.Info.Hostnames.Manage, n.Info.Zone, n.State,
+				n.Info.ClusterId, len(n.Devices))
+		},
+	},
+	"devices": {
+		bucket:   BOLTDB_BUCKET_DEVICE,
+		newEntry: func() dbDumpEntry { return NewDeviceEntry() },
+		summary: func(e dbDumpEntry) string {
+			d := e.(*DeviceEntry)
+			return fmt.Sprintf("%v node:%v state:%v total:%v free:%v bricks:%v",
+				d.Info.Name, d.NodeId, d.State, d.Info.Storage.Total,
+				d.Info.Storage.Free, len(d.Bricks))
+		},
+	},
+	"bricks": {
+		bucket:   BOLTDB_BUCKET_BRICK,
+		newEntry: func() dbDumpEntry { return &BrickEntry{} },
+		summary: func(e dbDumpEntry) string {
+			b := e.(*BrickEntry)
+			return fmt.Sprintf("%v device:%v size:%v",
+				b.Info.Path, b.Info.DeviceId, b.Info.Size)
+		},
+	},
+	"volumes": {
+		bucket:   BOLTDB_BUCKET_VOLUME,
+		newEntry: func() dbDumpEntry { return NewVolumeEntry() },
+		summary: func(e dbDumpEntry) string {
+			v := e.(*VolumeEntry)
+			return fmt.Sprintf("%v size:%v durability:%v cluster:%v bricks:%v",
+				v.Info.Name, v.Info.Size, v.Info.Durability.Type,
+				v.Info.Cluster, len(v.Bricks))
+		},
+	},
+	"pendingops": {
+		bucket:   BOLTDB_BUCKET_PENDING_OPS,
+		newEntry: func() dbDumpEntry { return &PendingOperationEntry{} },
+		summary: func(e dbDumpEntry) string {
+			p := e.(*PendingOperationEntry)
+			return fmt.Sprintf("type:%v bricks:%v", p.Type, len(p.Bricks))
+		},
+	},
+}
+
+// Names of the buckets which can be dumped
+func DbDumpBucketNames() []string {
+	names := make([]string, 0, len(dbDumpBuckets))
+	for name := range dbDumpBuckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// A value in a bucket.  Keys which are not entry ids are the
+// registrations of hostnames and devices.  Values which cannot be
+// decoded are returned with the error instead of the entry.
+type DbDumpValue struct {
+	Bucket string      `json:"bucket"`
+	Key    string      `json:"key"`
+	Entry  interface{} `json:"entry,omitempty"`
+	Value  string      `json:"value,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Prints the entries of a db without a running server.  A db in use
+// by a server is dumped from a copy.
+type DbDump struct {
+	db      *bolt.DB
+	out     io.Writer
+	tmpfile string
+}
+
+func NewDbDump(dbfile string, out io.Writer) (*DbDump, error) {
+	// Do not create a new db
+	if _, err := os.Stat(dbfile); err != nil {
+		return nil, err
+	}
+
+	d := &DbDump{out: out}
+	db, err := bolt.Open(dbfile, 0600, &bolt.Options{
+		Timeout:  time.Second,
+		ReadOnly: true,
+	})
+	if err == bolt.ErrTimeout {
+		// Locked by a server
+		d.tmpfile, err = copyDbFile(dbfile)
+		if err != nil {
+			return nil, err
+		}
+		db, err = bolt.Open(d.tmpfile, 0600, &bolt.Options{
+			Timeout:  time.Second,
+			ReadOnly: true,
+		})
+	}
+	if err != nil {
+		d.Close()
+		return nil, fmt.Errorf("Unable to open database %v: %v", dbfile, err)
+	}
+	d.db = db
+
+	err = useDbCodec(db)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func copyDbFile(dbfile string) (string, error) {
+	src, err := os.Open(dbfile)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := ioutil.TempFile("", "heketi-dump-")
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", fmt.Errorf("Unable to copy database %v: %v", dbfile, err)
+	}
+
+	return dst.Name(), nil
+}
+
+func (d *DbDump) Close() {
+	if d.db != nil {
+		d.db.Close()
+	}
+	if d.tmpfile != "" {
+		os.Remove(d.tmpfile)
+	}
+}
+
+// Returns the values of the buckets, or of all the buckets if none
+// are given.  Only the value with the key id is returned if it is set.
+func (d *DbDump) Values(buckets []string, id string) ([]DbDumpValue, error) {
+	if len(buckets) == 0 {
+		buckets = DbDumpBucketNames()
+	}
+
+	values := make([]DbDumpValue, 0)
+	err := d.db.View(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			bucket, ok := dbDumpBuckets[name]
+			if !ok {
+				return fmt.Errorf("Unknown bucket %v, must be one of %v",
+					name, DbDumpBucketNames())
+			}
+
+			b := tx.Bucket([]byte(bucket.bucket))
+			if b == nil {
+				continue
+			}
+			err := b.ForEach(func(k, v []byte) error {
+				key := string(k)
+				if id != "" && key != id {
+					return nil
+				}
+				values = append(values, dbDumpDecode(name, bucket, key, v))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return values, err
+}
+
+func dbDumpDecode(name string, bucket dbDumpBucket, key string, v []byte) DbDumpValue {
+	value := DbDumpValue{
+		Bucket: name,
+		Key:    key,
+	}
+	if !isValidEntryId(key) {
+		value.Value = string(v)
+		return value
+	}
+
+	entry := bucket.newEntry()
+	err := dbDumpUnmarshal(entry, v)
+	if err != nil {
+		value.Error = err.Error()
+		value.Value = fmt.Sprintf("%x", v)
+		return value
+	}
+	value.Entry = entry
+
+	return value
+}
+
+// Corrupt values may make the decoder panic
+func dbDumpUnmarshal(entry dbDumpEntry, v []byte) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("Unable to decode value: %v", p)
+		}
+	}()
+
+	return entry.Unmarshal(v)
+}
+
+// Prints the values as indented JSON
+func (d *DbDump) PrintJson(values []DbDumpValue) error {
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(d.out, "%s\n", data)
+	return err
+}
+
+// Prints a line with a summary of each value
+func (d *DbDump) PrintTable(values []DbDumpValue) error {
+	w := tabwriter.NewWriter(d.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BUCKET\tKEY\tSUMMARY")
+	for _, value := range values {
+		var summary string
+		switch {
+		case value.Error != "":
+			summary = "ERROR: " + value.Error
+		case value.Entry == nil:
+			summary = "registration of " + value.Value
+		default:
+			summary = dbDumpBuckets[value.Bucket].summary(value.Entry.(dbDumpEntry))
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", value.Bucket, value.Key, summary)
+	}
+	return w.Flush()
+}
+
+// Prints the clusters with their nodes, devices and volumes
+func (d *DbDump) PrintTopology() error {
+	return StreamTopology(d.db, d.out)
+}
@@ -0,0 +1,129 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func TestDbDump(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(10)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+
+	// The hostnames of a node and a value which cannot be decoded
+	corrupt := utils.GenUUID()
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, v.Info.Cluster)
+		tests.Assert(t, err == nil)
+		node, err := NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		tests.Assert(t, err == nil)
+		tests.Assert(t, node.Register(tx) == nil)
+
+		b := tx.Bucket([]byte(BOLTDB_BUCKET_VOLUME))
+		return b.Put([]byte(corrupt), []byte("garbage"))
+	})
+	tests.Assert(t, err == nil)
+
+	// The db is locked by the app, so it is dumped from a copy
+	var out bytes.Buffer
+	dump, err := NewDbDump(tmpfile, &out)
+	tests.Assert(t, err == nil, err)
+	defer dump.Close()
+	tests.Assert(t, dump.tmpfile != "")
+
+	values, err := dump.Values(nil, "")
+	tests.Assert(t, err == nil, err)
+
+	counts := make(map[string]int)
+	registrations := 0
+	for _, value := range values {
+		if value.Entry == nil && value.Error == "" {
+			registrations++
+			continue
+		}
+		counts[value.Bucket]++
+	}
+	tests.Assert(t, counts["clusters"] == 1)
+	tests.Assert(t, counts["nodes"] == 2)
+	tests.Assert(t, counts["devices"] == 2)
+	tests.Assert(t, counts["bricks"] == len(v.Bricks))
+	tests.Assert(t, counts["volumes"] == 2)
+
+	// Manage and storage hostnames
+	tests.Assert(t, registrations == 2, registrations)
+
+	// Only one entry
+	values, err = dump.Values([]string{"volumes"}, v.Info.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(values) == 1)
+	volume := values[0].Entry.(*VolumeEntry)
+	tests.Assert(t, volume.Info.Name == v.Info.Name)
+
+	values, err = dump.Values([]string{"volumes"}, corrupt)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(values) == 1)
+	tests.Assert(t, values[0].Error != "")
+	tests.Assert(t, values[0].Entry == nil)
+
+	_, err = dump.Values([]string{"widgets"}, "")
+	tests.Assert(t, err != nil)
+
+	// Output
+	values, err = dump.Values([]string{"volumes"}, "")
+	tests.Assert(t, err == nil)
+
+	err = dump.PrintTable(values)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, strings.Contains(out.String(), v.Info.Name), out.String())
+	tests.Assert(t, strings.Contains(out.String(), "ERROR"), out.String())
+
+	out.Reset()
+	err = dump.PrintJson(values)
+	tests.Assert(t, err == nil)
+	var parsed []map[string]interface{}
+	err = json.Unmarshal(out.Bytes(), &parsed)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(parsed) == 2)
+}
+
+func TestDbDumpMissingFile(t *testing.T) {
+	_, err := NewDbDump(tests.Tempfile(), os.Stdout)
+	tests.Assert(t, err != nil)
+}
@@ -0,0 +1,162 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/lpabon/godbc"
+)
+
+const (
+	BOLTDB_BUCKET_METADATA = "METADATA"
+
+	dbSchemaVersionKey = "schema_version"
+)
+
+type dbMigration struct {
+	description string
+	migrate     func(tx *bolt.Tx) error
+}
+
+// Migrations of the entries in the db, in order.  A db at version N
+// has had the first N migrations applied.  Migrations are only ever
+// appended to this list.
+var dbMigrations = []dbMigration{
+	{
+		description: "Set the schema version of existing databases",
+		migrate: func(tx *bolt.Tx) error {
+			return nil
+		},
+	},
+}
+
+// Version of the db written by this binary
+func dbSchemaVersionCurrent() uint64 {
+	return uint64(len(dbMigrations))
+}
+
+// Returns 0 for databases created before the schema was versioned
+func DbSchemaVersion(tx *bolt.Tx) (uint64, error) {
+	godbc.Require(tx != nil)
+
+	b := tx.Bucket([]byte(BOLTDB_BUCKET_METADATA))
+	if b == nil {
+		return 0, nil
+	}
+	val := b.Get([]byte(dbSchemaVersionKey))
+	if val == nil {
+		return 0, nil
+	}
+	if len(val) != 8 {
+		return 0, fmt.Errorf("Invalid schema version in database")
+	}
+
+	return binary.BigEndian.Uint64(val), nil
+}
+
+func dbSetSchemaVersion(tx *bolt.Tx, version uint64) error {
+	godbc.Require(tx != nil)
+
+	b, err := tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET_METADATA))
+	if err != nil {
+		return err
+	}
+
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, version)
+	return b.Put([]byte(dbSchemaVersionKey), val)
+}
+
+// Returns an error if the db was written by a newer version of heketi
+func dbCheckSchemaVersion(tx *bolt.Tx) error {
+	version, err := DbSchemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if version > dbSchemaVersionCurrent() {
+		return fmt.Errorf("Database schema version %v is newer than "+
+			"the supported version %v", version, dbSchemaVersionCurrent())
+	}
+
+	return nil
+}
+
+// Runs the migrations the db has not had yet in a single transaction.
+// The db is first copied to a backup file named after its version.
+// New databases are set to the current version by initDb().
+func dbMigrate(db *bolt.DB, dbfile string) error {
+	var version uint64
+	err := db.View(func(tx *bolt.Tx) error {
+		err := dbCheckSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		version, err = DbSchemaVersion(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if version == dbSchemaVersionCurrent() {
+		return nil
+	}
+
+	if db.IsReadOnly() {
+		logger.Warning("Database schema version %v is older than %v "+
+			"and cannot be migrated while read-only",
+			version, dbSchemaVersionCurrent())
+		return nil
+	}
+
+	newDb := false
+	backup := fmt.Sprintf("%v.v%v.backup", dbfile, version)
+	err = db.View(func(tx *bolt.Tx) error {
+		// Nothing to migrate in a new db
+		if tx.Bucket([]byte(BOLTDB_BUCKET_CLUSTER)) == nil {
+			newDb = true
+			return nil
+		}
+
+		err := tx.CopyFile(backup, 0600)
+		if err != nil {
+			return fmt.Errorf("Unable to backup database to %v: %v", backup, err)
+		}
+		return nil
+	})
+	if err != nil || newDb {
+		return err
+	}
+	logger.Info("Database backed up to %v before migration", backup)
+
+	return db.Update(func(tx *bolt.Tx) error {
+		for ; version < dbSchemaVersionCurrent(); version++ {
+			migration := dbMigrations[version]
+			logger.Info("Migrating database to version %v: %v",
+				version+1, migration.description)
+			err := migration.migrate(tx)
+			if err != nil {
+				return fmt.Errorf("Unable to migrate database to version %v: %v",
+					version+1, err)
+			}
+		}
+
+		return dbSetSchemaVersion(tx, version)
+	})
+}
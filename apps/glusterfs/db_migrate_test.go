@@ -0,0 +1,217 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/tests"
+)
+
+func openTestDb(t *testing.T, dbfile string) *bolt.DB {
+	db, err := bolt.Open(dbfile, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	tests.Assert(t, err == nil)
+	return db
+}
+
+func TestDbMigrateNewDb(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	app.Close()
+
+	db := openTestDb(t, tmpfile)
+	defer db.Close()
+	err := db.View(func(tx *bolt.Tx) error {
+		version, err := DbSchemaVersion(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, version == dbSchemaVersionCurrent())
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Nothing to backup
+	_, err = os.Stat(tmpfile + ".v0.backup")
+	tests.Assert(t, os.IsNotExist(err))
+}
+
+func TestDbMigrate(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	backup := tmpfile + ".v1.backup"
+	defer os.Remove(backup)
+
+	app := NewTestApp(tmpfile)
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+	app.Close()
+
+	// Migrations run in order, once
+	var ran []string
+	defer tests.Patch(&dbMigrations, append(dbMigrations,
+		dbMigration{
+			description: "first",
+			migrate: func(tx *bolt.Tx) error {
+				ran = append(ran, "first")
+				return nil
+			},
+		},
+		dbMigration{
+			description: "second",
+			migrate: func(tx *bolt.Tx) error {
+				ran = append(ran, "second")
+				return nil
+			},
+		})).Restore()
+
+	db := openTestDb(t, tmpfile)
+	err = dbMigrate(db, tmpfile)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(ran) == 2 && ran[0] == "first" && ran[1] == "second", ran)
+
+	err = dbMigrate(db, tmpfile)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(ran) == 2)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		version, err := DbSchemaVersion(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, version == 3)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	db.Close()
+
+	// The backup has the db before the migration
+	db = openTestDb(t, backup)
+	err = db.View(func(tx *bolt.Tx) error {
+		version, err := DbSchemaVersion(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, version == 1)
+
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(clusters) == 1)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	db.Close()
+}
+
+func TestDbMigrateUnversioned(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer os.Remove(tmpfile + ".v0.backup")
+
+	app := NewTestApp(tmpfile)
+	app.Close()
+
+	// Databases from before the schema was versioned
+	db := openTestDb(t, tmpfile)
+	err := db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(BOLTDB_BUCKET_METADATA))
+	})
+	tests.Assert(t, err == nil)
+	db.Close()
+
+	app = NewTestApp(tmpfile)
+	app.Close()
+
+	db = openTestDb(t, tmpfile)
+	defer db.Close()
+	err = db.View(func(tx *bolt.Tx) error {
+		version, err := DbSchemaVersion(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, version == dbSchemaVersionCurrent())
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	_, err = os.Stat(tmpfile + ".v0.backup")
+	tests.Assert(t, err == nil)
+}
+
+func TestDbMigrateFailure(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer os.Remove(tmpfile + ".v1.backup")
+
+	app := NewTestApp(tmpfile)
+	app.Close()
+
+	// A failed migration leaves the db unchanged
+	defer tests.Patch(&dbMigrations, append(dbMigrations,
+		dbMigration{
+			description: "change",
+			migrate: func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucket([]byte("NEW"))
+				return err
+			},
+		},
+		dbMigration{
+			description: "fail",
+			migrate: func(tx *bolt.Tx) error {
+				return errors.New("failed")
+			},
+		})).Restore()
+
+	db := openTestDb(t, tmpfile)
+	defer db.Close()
+	err := dbMigrate(db, tmpfile)
+	tests.Assert(t, err != nil)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		tests.Assert(t, tx.Bucket([]byte("NEW")) == nil)
+		version, err := DbSchemaVersion(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, version == 1)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestDbMigrateNewerVersion(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	app.Close()
+
+	db := openTestDb(t, tmpfile)
+	err := db.Update(func(tx *bolt.Tx) error {
+		return dbSetSchemaVersion(tx, dbSchemaVersionCurrent()+1)
+	})
+	tests.Assert(t, err == nil)
+
+	err = dbMigrate(db, tmpfile)
+	tests.Assert(t, err != nil)
+	db.Close()
+
+	// Neither the server nor the repair tool open it
+	_, err = NewDbRepair(tmpfile, os.Stdout, true)
+	tests.Assert(t, err != nil)
+}
@@ -0,0 +1,190 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// Runs the transactions of an operation.  Implemented by *bolt.DB,
+// and by overlayDB to run an operation without changing the db.
+type txRunner interface {
+	View(fn func(*bolt.Tx) error) error
+	Update(fn func(*bolt.Tx) error) error
+}
+
+// Changes made in a read transaction by an operation which is only
+// simulated, such as the dry run of a volume creation.  The entries
+// and index children saved and deleted are kept in memory, and the
+// loads of the transaction see them, so the operation runs as it would
+// without writing to the db or holding its write lock.  Listing the
+// keys of a bucket does not see them.
+type txOverlay struct {
+	parent *txOverlay
+
+	// Entries by bucket and key, nil if deleted
+	entries map[string]map[string][]byte
+
+	// Children by index and parent, false if deleted
+	children map[string]map[string]bool
+}
+
+// Overlays of the read transactions running a simulated operation
+var txOverlays = struct {
+	sync.RWMutex
+	m map[*bolt.Tx]*txOverlay
+}{m: make(map[*bolt.Tx]*txOverlay)}
+
+func newTxOverlay(parent *txOverlay) *txOverlay {
+	return &txOverlay{
+		parent:   parent,
+		entries:  make(map[string]map[string][]byte),
+		children: make(map[string]map[string]bool),
+	}
+}
+
+// Returns the overlay of the transaction, or nil if its changes go to
+// the db
+func overlayOf(tx *bolt.Tx) *txOverlay {
+	if tx.Writable() {
+		return nil
+	}
+
+	txOverlays.RLock()
+	defer txOverlays.RUnlock()
+	return txOverlays.m[tx]
+}
+
+func setOverlay(tx *bolt.Tx, o *txOverlay) {
+	txOverlays.Lock()
+	defer txOverlays.Unlock()
+	if o == nil {
+		delete(txOverlays.m, tx)
+	} else {
+		txOverlays.m[tx] = o
+	}
+}
+
+func (o *txOverlay) putEntry(bucket, key string, val []byte) {
+	if o.entries[bucket] == nil {
+		o.entries[bucket] = make(map[string][]byte)
+	}
+	o.entries[bucket][key] = val
+}
+
+// Returns the value of the entry and true if it was changed in the
+// overlay, nil if it was deleted
+func (o *txOverlay) entry(bucket, key string) ([]byte, bool) {
+	for ; o != nil; o = o.parent {
+		if val, ok := o.entries[bucket][key]; ok {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+func (o *txOverlay) putChild(index, parent, child string, present bool) {
+	key := index + "/" + parent
+	if o.children[key] == nil {
+		o.children[key] = make(map[string]bool)
+	}
+	o.children[key][child] = present
+}
+
+// Returns the children of the parent in the index, in order, given
+// the ones in the db
+func (o *txOverlay) indexList(index, parent string, list []string) []string {
+	key := index + "/" + parent
+	present := make(map[string]bool)
+	for _, child := range list {
+		present[child] = true
+	}
+	var layers []*txOverlay
+	for ; o != nil; o = o.parent {
+		layers = append(layers, o)
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		for child, p := range layers[i].children[key] {
+			present[child] = p
+		}
+	}
+
+	merged := make([]string, 0, len(present))
+	for child, p := range present {
+		if p {
+			merged = append(merged, child)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+// Keeps the changes in the parent overlay
+func (o *txOverlay) commit() {
+	for bucket, entries := range o.entries {
+		for key, val := range entries {
+			o.parent.putEntry(bucket, key, val)
+		}
+	}
+	for key, children := range o.children {
+		if o.parent.children[key] == nil {
+			o.parent.children[key] = make(map[string]bool)
+		}
+		for child, p := range children {
+			o.parent.children[key][child] = p
+		}
+	}
+}
+
+// Runs the transactions of an operation on the overlay of a read
+// transaction
+type overlayDB struct {
+	tx *bolt.Tx
+}
+
+func (d *overlayDB) View(fn func(*bolt.Tx) error) error {
+	return fn(d.tx)
+}
+
+// The changes are only kept if fn succeeds, as with a transaction of
+// the db
+func (d *overlayDB) Update(fn func(*bolt.Tx) error) error {
+	parent := overlayOf(d.tx)
+	o := newTxOverlay(parent)
+	setOverlay(d.tx, o)
+	defer setOverlay(d.tx, parent)
+
+	err := fn(d.tx)
+	if err == nil {
+		o.commit()
+	}
+	return err
+}
+
+// Runs an operation in one read transaction of the db, keeping the
+// changes of its transactions in an overlay which is dropped after
+func simulateOnDb(db *bolt.DB, operation func(db txRunner) error) error {
+	return db.View(func(tx *bolt.Tx) error {
+		setOverlay(tx, newTxOverlay(nil))
+		defer setOverlay(tx, nil)
+
+		return operation(&overlayDB{tx: tx})
+	})
+}
@@ -0,0 +1,115 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/tests"
+)
+
+func TestSimulateOnDb(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	index := BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES
+	d := NewDeviceEntry()
+	d.Info.Id = "d1"
+	d.StorageSet(100)
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		err := d.Save(tx)
+		if err != nil {
+			return err
+		}
+		return IndexAdd(tx, index, "c1", "v1")
+	})
+	tests.Assert(t, err == nil, err)
+
+	err = simulateOnDb(app.db, func(db txRunner) error {
+		err := db.Update(func(tx *bolt.Tx) error {
+			device, err := NewDeviceEntryFromId(tx, "d1")
+			tests.Assert(t, err == nil, err)
+			err = device.StorageAllocate(10)
+			tests.Assert(t, err == nil, err)
+			err = device.Save(tx)
+			tests.Assert(t, err == nil, err)
+
+			// Saved in the transaction
+			device, err = NewDeviceEntryFromId(tx, "d1")
+			tests.Assert(t, err == nil, err)
+			tests.Assert(t, device.Info.Storage.Free == 90)
+
+			err = IndexAdd(tx, index, "c1", "v0")
+			tests.Assert(t, err == nil, err)
+			return IndexDelete(tx, index, "c1", "v1")
+		})
+		tests.Assert(t, err == nil, err)
+
+		// Kept by the next transactions
+		err = db.View(func(tx *bolt.Tx) error {
+			device, err := NewDeviceEntryFromId(tx, "d1")
+			tests.Assert(t, err == nil, err)
+			tests.Assert(t, device.Info.Storage.Free == 90)
+			list, err := IndexList(tx, index, "c1")
+			tests.Assert(t, err == nil, err)
+			tests.Assert(t, reflect.DeepEqual(list, []string{"v0"}), list)
+			return nil
+		})
+		tests.Assert(t, err == nil, err)
+
+		// Unless the transaction fails
+		failed := errors.New("failed")
+		err = db.Update(func(tx *bolt.Tx) error {
+			device, err := NewDeviceEntryFromId(tx, "d1")
+			tests.Assert(t, err == nil, err)
+			err = EntryDelete(tx, device, "d1")
+			tests.Assert(t, err == nil, err)
+			_, err = NewDeviceEntryFromId(tx, "d1")
+			tests.Assert(t, err == ErrNotFound, err)
+			return failed
+		})
+		tests.Assert(t, err == failed, err)
+		return db.View(func(tx *bolt.Tx) error {
+			device, err := NewDeviceEntryFromId(tx, "d1")
+			tests.Assert(t, err == nil, err)
+			tests.Assert(t, device.Info.Storage.Free == 90)
+			return nil
+		})
+	})
+	tests.Assert(t, err == nil, err)
+
+	// The db is not changed
+	err = app.db.View(func(tx *bolt.Tx) error {
+		device, err := NewDeviceEntryFromId(tx, "d1")
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, device.Info.Storage.Free == 100)
+		list, err := IndexList(tx, index, "c1")
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, reflect.DeepEqual(list, []string{"v1"}), list)
+		return nil
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(txOverlays.m) == 0)
+}
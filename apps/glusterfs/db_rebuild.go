@@ -0,0 +1,439 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/executors/sshexec"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+// Nodes to interrogate when rebuilding the db, grouped by cluster
+type DbRebuildSeed struct {
+	SshConfig sshexec.SshConfig      `json:"sshexec"`
+	Clusters  []DbRebuildSeedCluster `json:"clusters"`
+}
+
+type DbRebuildSeedCluster struct {
+	Nodes []api.NodeAddRequest `json:"nodes"`
+}
+
+// Reconstructs the db from the state of the storage nodes.  Ids of
+// devices, bricks and volumes are recovered from the names heketi
+// gives to volume groups, logical volumes and gluster volumes.  Nodes
+// and clusters get new ids.  Storage which cannot be attributed to
+// heketi is only reported as unmanaged.
+type DbRebuild struct {
+	executor executors.Executor
+	out      io.Writer
+
+	clusters []*ClusterEntry
+	nodes    []*NodeEntry
+	devices  []*DeviceEntry
+	bricks   []*BrickEntry
+	volumes  []*VolumeEntry
+
+	// Bricks found on the nodes by storagehost:path
+	found map[string]*BrickEntry
+
+	// Gluster volumes by name
+	glusterVolumes map[string]executors.VolumeInfo
+
+	Unmanaged []string
+}
+
+func NewDbRebuild(executor executors.Executor, out io.Writer) *DbRebuild {
+	return &DbRebuild{
+		executor:       executor,
+		out:            out,
+		found:          make(map[string]*BrickEntry),
+		glusterVolumes: make(map[string]executors.VolumeInfo),
+		Unmanaged:      make([]string, 0),
+	}
+}
+
+func (r *DbRebuild) printf(format string, v ...interface{}) {
+	fmt.Fprintf(r.out, format+"\n", v...)
+}
+
+func (r *DbRebuild) unmanaged(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	r.printf("Unmanaged: %v", msg)
+	r.Unmanaged = append(r.Unmanaged, msg)
+}
+
+// Returns the id encoded in a name created by heketi
+func idFromName(name, prefix string) (string, bool) {
+	if !strings.HasPrefix(name, prefix) {
+		return "", false
+	}
+	id := strings.TrimPrefix(name, prefix)
+	if b, err := hex.DecodeString(id); err != nil || len(b) != 16 {
+		return "", false
+	}
+	return id, true
+}
+
+// Interrogate all the nodes in the seed and create the entries
+func (r *DbRebuild) Rebuild(seed *DbRebuildSeed) error {
+	for _, seedCluster := range seed.Clusters {
+		cluster := NewClusterEntryFromRequest()
+		r.clusters = append(r.clusters, cluster)
+		r.printf("Cluster %v", cluster.Info.Id)
+
+		for i := range seedCluster.Nodes {
+			req := seedCluster.Nodes[i]
+			req.ClusterId = cluster.Info.Id
+			if len(req.Hostnames.Manage) == 0 || len(req.Hostnames.Storage) == 0 {
+				return fmt.Errorf("Node %v in cluster %v is missing hostnames", i, len(r.clusters)-1)
+			}
+
+			node := NewNodeEntryFromRequest(&req)
+			cluster.NodeAdd(node.Info.Id)
+			r.nodes = append(r.nodes, node)
+			r.printf("Node %v (%v) in cluster %v",
+				node.Info.Id, node.ManageHostName(), cluster.Info.Id)
+
+			info, err := r.executor.NodeStorageInfo(node.ManageHostName())
+			if err != nil {
+				return err
+			}
+			r.addNodeStorage(node, info)
+		}
+	}
+
+	// Volumes can only be added once the bricks on all nodes are known
+	names := make([]string, 0, len(r.glusterVolumes))
+	for name := range r.glusterVolumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	used := make(map[string]bool)
+	for _, name := range names {
+		for _, brick := range r.addVolume(r.glusterVolumes[name]) {
+			used[brick.Info.Id] = true
+		}
+	}
+
+	// Only keep bricks which belong to a volume
+	for _, brick := range r.found {
+		if !used[brick.Info.Id] {
+			r.unmanaged("Brick %v on %v is not used by a managed volume",
+				brick.Info.Id, brick.Info.Path)
+			continue
+		}
+		r.bricks = append(r.bricks, brick)
+		r.device(brick.Info.DeviceId).BrickAdd(brick.Info.Id)
+	}
+
+	r.printf("Found %v clusters, %v nodes, %v devices, %v bricks, %v volumes, "+
+		"%v unmanaged",
+		len(r.clusters), len(r.nodes), len(r.devices), len(r.bricks),
+		len(r.volumes), len(r.Unmanaged))
+
+	return nil
+}
+
+func (r *DbRebuild) node(id string) *NodeEntry {
+	for _, node := range r.nodes {
+		if node.Info.Id == id {
+			return node
+		}
+	}
+	return nil
+}
+
+func (r *DbRebuild) device(id string) *DeviceEntry {
+	for _, device := range r.devices {
+		if device.Info.Id == id {
+			return device
+		}
+	}
+	return nil
+}
+
+func (r *DbRebuild) addNodeStorage(node *NodeEntry, info *executors.NodeStorageInfo) {
+	host := node.ManageHostName()
+
+	pvs := make(map[string][]string)
+	for _, pv := range info.PhysicalVolumes {
+		pvs[pv.VgName] = append(pvs[pv.VgName], pv.Name)
+	}
+
+	lvs := make(map[string]executors.LogicalVolumeInfo)
+	for _, lv := range info.LogicalVolumes {
+		lvs[lv.VgName+"/"+lv.Name] = lv
+	}
+
+	mounts := make(map[string]string)
+	for _, mount := range info.Mounts {
+		mounts[mount.Device] = mount.MountPoint
+	}
+
+	// Devices
+	devices := make(map[string]*DeviceEntry)
+	for _, vg := range info.VolumeGroups {
+		id, ok := idFromName(vg.Name, "vg_")
+		if !ok {
+			r.unmanaged("Volume group %v on %v", vg.Name, host)
+			continue
+		}
+		if len(pvs[vg.Name]) != 1 {
+			r.unmanaged("Volume group %v on %v has %v physical volumes",
+				vg.Name, host, len(pvs[vg.Name]))
+			continue
+		}
+
+		device := NewDeviceEntry()
+		device.Info.Id = id
+		device.Info.Name = pvs[vg.Name][0]
+		device.NodeId = node.Info.Id
+		device.StorageSetRaw(vg.Size, settings().DeviceFilesystemOverhead)
+
+		// The space used is taken out of what is left after the
+		// filesystem overhead, as when the bricks were allocated
+		device.Info.Storage.Used = vg.Size - vg.Free
+		if device.Info.Storage.Used < device.Info.Storage.Total {
+			device.Info.Storage.Free = device.Info.Storage.Total - device.Info.Storage.Used
+		} else {
+			device.Info.Storage.Free = 0
+		}
+		node.DeviceAdd(device.Info.Id)
+		devices[vg.Name] = device
+		r.devices = append(r.devices, device)
+		r.printf("Device %v (%v) on %v", device.Info.Id, device.Info.Name, host)
+	}
+
+	// Bricks
+	for _, lv := range info.LogicalVolumes {
+		device, ok := devices[lv.VgName]
+		if !ok {
+			continue
+		}
+
+		if _, ok := idFromName(lv.Name, "tp_"); ok {
+			// Thin pools are accounted with their brick
+			continue
+		}
+		id, ok := idFromName(lv.Name, "brick_")
+		if !ok {
+			r.unmanaged("Logical volume %v/%v on %v", lv.VgName, lv.Name, host)
+			continue
+		}
+
+		pool, ok := lvs[lv.VgName+"/"+lv.PoolLv]
+		if !ok || lv.PoolLv != "tp_"+id {
+			r.unmanaged("Logical volume %v/%v on %v is not in its own thin pool",
+				lv.VgName, lv.Name, host)
+			continue
+		}
+
+		mountpoint, ok := mounts["/dev/mapper/"+lv.VgName+"-"+lv.Name]
+		if !ok {
+			mountpoint, ok = mounts["/dev/"+lv.VgName+"/"+lv.Name]
+		}
+		if !ok {
+			r.unmanaged("Logical volume %v/%v on %v is not mounted",
+				lv.VgName, lv.Name, host)
+			continue
+		}
+
+		brick := &BrickEntry{}
+		brick.Info.Id = id
+		brick.Info.Size = lv.Size
+		brick.Info.NodeId = node.Info.Id
+		brick.Info.DeviceId = device.Info.Id
+		brick.Info.MountPoint = mountpoint
+		brick.Info.SubDir = "brick"
+		brick.Info.Path = path.Join(mountpoint, brick.Info.SubDir)
+		brick.TpSize = pool.Size
+		brick.PoolMetadataSize = pool.MetadataSize
+		r.found[node.StorageHostName()+":"+brick.Info.Path] = brick
+	}
+
+	// Gluster reports the volumes of the whole cluster on each node
+	for _, volume := range info.Volumes {
+		if _, ok := r.glusterVolumes[volume.Name]; !ok {
+			r.glusterVolumes[volume.Name] = volume
+		}
+	}
+}
+
+// Adds the volume if it and all its bricks were created by heketi.
+// Returns the bricks of the volume.
+func (r *DbRebuild) addVolume(info executors.VolumeInfo) []*BrickEntry {
+	id, ok := idFromName(info.Name, "vol_")
+	if !ok {
+		r.unmanaged("Volume %v", info.Name)
+		return nil
+	}
+	if len(info.Bricks) == 0 {
+		r.unmanaged("Volume %v has no bricks", info.Name)
+		return nil
+	}
+
+	var clusterId string
+	bricks := make([]*BrickEntry, 0, len(info.Bricks))
+	hosts := utils.NewStringSet()
+	for _, b := range info.Bricks {
+		brick, ok := r.found[b.Host+":"+b.Path]
+		if !ok {
+			r.unmanaged("Volume %v uses brick %v:%v which is not managed",
+				info.Name, b.Host, b.Path)
+			return nil
+		}
+
+		node := r.node(brick.Info.NodeId)
+		if clusterId == "" {
+			clusterId = node.Info.ClusterId
+		} else if clusterId != node.Info.ClusterId {
+			r.unmanaged("Volume %v has bricks in more than one cluster", info.Name)
+			return nil
+		}
+		hosts.Add(b.Host)
+		bricks = append(bricks, brick)
+	}
+
+	req := &api.VolumeCreateRequest{}
+	req.Name = info.Name
+	var dataBricks, bricksInSet int
+	switch info.Type {
+	case executors.DurabilityReplica:
+		req.Durability.Type = api.DurabilityReplicate
+		req.Durability.Replicate.Replica = info.Replica
+		dataBricks, bricksInSet = 1, info.Replica
+	case executors.DurabilityDispersion:
+		req.Durability.Type = api.DurabilityEC
+		req.Durability.Disperse.Data = info.Data
+		req.Durability.Disperse.Redundancy = info.Redundancy
+		dataBricks, bricksInSet = info.Data, info.Data+info.Redundancy
+	default:
+		req.Durability.Type = api.DurabilityDistributeOnly
+		dataBricks, bricksInSet = 1, 1
+	}
+	if bricksInSet < 1 || len(bricks)%bricksInSet != 0 {
+		r.unmanaged("Volume %v has %v bricks which are not complete sets of %v",
+			info.Name, len(bricks), bricksInSet)
+		return nil
+	}
+
+	// Size is the usable space of the bricks
+	total := uint64(0)
+	for _, brick := range bricks {
+		total += brick.Info.Size
+	}
+	req.Size = int(total * uint64(dataBricks) / uint64(bricksInSet) / GB)
+
+	// Thin pools larger than the bricks were created for snapshots
+	factor := float64(bricks[0].TpSize) / float64(bricks[0].Info.Size)
+	factor = math.Floor(factor*100+0.5) / 100
+	if factor > 1 {
+		req.Snapshot.Enable = true
+		req.Snapshot.Factor = float32(factor)
+	}
+
+	volume := NewVolumeEntryFromRequest(req)
+	volume.Info.Id = id
+	volume.Info.Cluster = clusterId
+	for _, brick := range bricks {
+		volume.BrickAdd(brick.Info.Id)
+	}
+	volume.setMountInfo(hosts.Strings())
+
+	for _, cluster := range r.clusters {
+		if cluster.Info.Id == clusterId {
+			cluster.VolumeAdd(volume.Info.Id)
+		}
+	}
+	r.volumes = append(r.volumes, volume)
+	r.printf("Volume %v (%v) with %v bricks", volume.Info.Id, volume.Info.Name, len(bricks))
+
+	return bricks
+}
+
+// Writes the entries to a new db
+func (r *DbRebuild) Save(dbfile string) error {
+	// Never overwrite an existing db
+	if _, err := os.Stat(dbfile); err == nil {
+		return fmt.Errorf("Database %v already exists", dbfile)
+	}
+
+	db, err := bolt.Open(dbfile, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return fmt.Errorf("Unable to create database %v: %v", dbfile, err)
+	}
+	defer db.Close()
+
+	err = db.Update(initDb)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, cluster := range r.clusters {
+			err := cluster.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		for _, node := range r.nodes {
+			err := node.Register(tx)
+			if err != nil {
+				return err
+			}
+			err = node.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		for _, device := range r.devices {
+			err := device.Register(tx)
+			if err != nil {
+				return err
+			}
+			err = device.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		for _, brick := range r.bricks {
+			err := brick.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		for _, volume := range r.volumes {
+			err := volume.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,158 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/executors/mockexec"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func TestDbRebuild(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&DeviceFilesystemOverhead, float64(2)).Restore()
+
+	volumeId := utils.GenUUID()
+	bricks := map[string]string{
+		"host1": utils.GenUUID(),
+		"host2": utils.GenUUID(),
+	}
+	devices := map[string]string{
+		"host1": utils.GenUUID(),
+		"host2": utils.GenUUID(),
+	}
+	volume := executors.VolumeInfo{
+		Name:    "vol_" + volumeId,
+		Type:    executors.DurabilityReplica,
+		Replica: 2,
+	}
+	for _, host := range []string{"host1", "host2"} {
+		volume.Bricks = append(volume.Bricks, executors.BrickInfo{
+			Host: host,
+			Path: "/var/lib/heketi/mounts/vg_" + devices[host] +
+				"/brick_" + bricks[host] + "/brick",
+		})
+	}
+	other := executors.VolumeInfo{
+		Name:    "other",
+		Type:    executors.DurabilityNone,
+		Bricks:  []executors.BrickInfo{{Host: "host1", Path: "/data/other"}},
+		Replica: 1,
+	}
+
+	executor, err := mockexec.NewMockExecutor()
+	tests.Assert(t, err == nil)
+	executor.MockNodeStorageInfo = func(host string) (*executors.NodeStorageInfo, error) {
+		vg := "vg_" + devices[host]
+		return &executors.NodeStorageInfo{
+			Volumes: []executors.VolumeInfo{volume, other},
+			PhysicalVolumes: []executors.PhysicalVolumeInfo{
+				{Name: "/dev/sdb", VgName: vg},
+				{Name: "/dev/sdc", VgName: "data"},
+			},
+			VolumeGroups: []executors.VolumeGroupInfo{
+				{Name: vg, Size: 100 * GB, Free: 90 * GB},
+				{Name: "data", Size: 100 * GB, Free: 0},
+			},
+			LogicalVolumes: []executors.LogicalVolumeInfo{
+				{Name: "tp_" + bricks[host], VgName: vg, Size: 10 * GB, MetadataSize: 52 * MB},
+				{Name: "brick_" + bricks[host], VgName: vg, PoolLv: "tp_" + bricks[host], Size: 10 * GB},
+				{Name: "other", VgName: "data", Size: 100 * GB},
+			},
+			Mounts: []executors.MountInfo{
+				{
+					Device:     "/dev/mapper/" + vg + "-brick_" + bricks[host],
+					MountPoint: "/var/lib/heketi/mounts/" + vg + "/brick_" + bricks[host],
+				},
+			},
+		}, nil
+	}
+
+	seed := &DbRebuildSeed{}
+	seed.Clusters = []DbRebuildSeedCluster{{}}
+	for _, host := range []string{"host1", "host2"} {
+		req := api.NodeAddRequest{Zone: 1}
+		req.Hostnames.Manage = []string{host}
+		req.Hostnames.Storage = []string{host}
+		seed.Clusters[0].Nodes = append(seed.Clusters[0].Nodes, req)
+	}
+
+	var out bytes.Buffer
+	r := NewDbRebuild(executor, &out)
+	err = r.Rebuild(seed)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(r.clusters) == 1)
+	tests.Assert(t, len(r.nodes) == 2)
+	tests.Assert(t, len(r.devices) == 2)
+	tests.Assert(t, len(r.bricks) == 2)
+	tests.Assert(t, len(r.volumes) == 1)
+
+	// The vg, lv and volume not created by heketi are not guessed
+	tests.Assert(t, len(r.Unmanaged) == 3, r.Unmanaged)
+
+	err = r.Save(tmpfile)
+	tests.Assert(t, err == nil, err)
+
+	// An existing db is never overwritten
+	err = r.Save(tmpfile)
+	tests.Assert(t, err != nil)
+
+	db, err := bolt.Open(tmpfile, 0600, nil)
+	tests.Assert(t, err == nil)
+	defer db.Close()
+	err = db.View(func(tx *bolt.Tx) error {
+		v, err := NewVolumeEntryFromId(tx, volumeId)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, v.Info.Size == 10)
+		tests.Assert(t, v.Info.Durability.Type == api.DurabilityReplicate)
+		tests.Assert(t, v.Info.Durability.Replicate.Replica == 2)
+		tests.Assert(t, len(v.Bricks) == 2)
+		tests.Assert(t, v.Info.Cluster == r.clusters[0].Info.Id)
+
+		cluster, err := NewClusterEntryFromId(tx, v.Info.Cluster)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(cluster.Info.Nodes) == 2)
+		tests.Assert(t, utils.SortedStringHas(cluster.Info.Volumes, volumeId))
+
+		for host, id := range bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, brick.Info.DeviceId == devices[host])
+			tests.Assert(t, brick.Info.Path == volume.Bricks[0].Path ||
+				brick.Info.Path == volume.Bricks[1].Path)
+
+			device, err := NewDeviceEntryFromId(tx, devices[host])
+			tests.Assert(t, err == nil)
+			tests.Assert(t, device.Info.Name == "/dev/sdb")
+			tests.Assert(t, device.RawSize == 100*GB, device.RawSize)
+			tests.Assert(t, device.Info.Storage.Total == 98*GB, device.Info.Storage)
+			tests.Assert(t, device.Info.Storage.Used == 10*GB, device.Info.Storage)
+			tests.Assert(t, device.Info.Storage.Free == 88*GB, device.Info.Storage)
+			tests.Assert(t, utils.SortedStringHas(device.Bricks, id))
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
@@ -0,0 +1,369 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/lpabon/godbc"
+)
+
+// Rebuilds the indexes derived from the entries of the db, which go
+// stale when the db is edited by hand.  These are the index buckets,
+// the nodes and volumes of each cluster, the devices of each node,
+// and the registered hostnames and device names.
+func Reindex(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		fixed, err := reindex(tx)
+		if err != nil {
+			return err
+		}
+		if fixed > 0 {
+			logger.Warning("Repaired %v index entries in DB", fixed)
+		}
+		return nil
+	})
+}
+
+// Returns the number of index entries which were changed
+func reindex(tx *bolt.Tx) (int, error) {
+	godbc.Require(tx != nil)
+
+	fixed, err := IndexReconcile(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := reindexMembers(tx)
+	if err != nil {
+		return 0, err
+	}
+	fixed += n
+
+	n, err = reindexRegistrations(tx)
+	if err != nil {
+		return 0, err
+	}
+	fixed += n
+
+	err = validateStorage(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	return fixed, nil
+}
+
+// Checks the storage accounting of the devices, which cannot be
+// repaired from the index.  As with underflows, the errors are only
+// returned in strict mode.
+func validateStorage(tx *bolt.Tx) error {
+	nodeIds, _, err := EntryKeysPage(tx, BOLTDB_BUCKET_NODE, "", 0, isNodeRegisterKey)
+	if err != nil {
+		return err
+	}
+	for _, id := range nodeIds {
+		node, err := NewNodeEntryFromId(tx, id)
+		if err != nil {
+			return err
+		}
+		err = node.ValidateStorage(tx)
+		if err == nil {
+			continue
+		}
+		logger.LogError("Invalid storage accounting: %v", err)
+		if StrictStorage {
+			return err
+		}
+	}
+	return nil
+}
+
+func isDeviceRegisterKey(key string) bool {
+	return strings.HasPrefix(key, "DEVICE")
+}
+
+// Returns the number of ids only in one of the sorted lists
+func sortedStringsDiff(a, b sort.StringSlice) int {
+	diff := 0
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i] < b[j]):
+			i++
+			diff++
+		case i == len(a) || a[i] > b[j]:
+			j++
+			diff++
+		default:
+			i++
+			j++
+		}
+	}
+	return diff
+}
+
+// Rebuilds the lists of nodes and volumes of the clusters and the
+// lists of devices of the nodes from the entries referring to them
+func reindexMembers(tx *bolt.Tx) (int, error) {
+	clusterIds, err := ClusterList(tx)
+	if err != nil {
+		return 0, err
+	}
+	nodeIds, _, err := EntryKeysPage(tx, BOLTDB_BUCKET_NODE, "", 0, isNodeRegisterKey)
+	if err != nil {
+		return 0, err
+	}
+	deviceIds, _, err := EntryKeysPage(tx, BOLTDB_BUCKET_DEVICE, "", 0, isDeviceRegisterKey)
+	if err != nil {
+		return 0, err
+	}
+	volumeIds, err := VolumeList(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	clusterNodes := make(map[string]sort.StringSlice)
+	clusterVolumes := make(map[string]sort.StringSlice)
+	for _, id := range clusterIds {
+		clusterNodes[id] = make(sort.StringSlice, 0)
+		clusterVolumes[id] = make(sort.StringSlice, 0)
+	}
+	nodeDevices := make(map[string]sort.StringSlice)
+	nodes := make([]*NodeEntry, 0, len(nodeIds))
+
+	for _, id := range nodeIds {
+		node, err := NewNodeEntryFromId(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		nodes = append(nodes, node)
+		nodeDevices[id] = make(sort.StringSlice, 0)
+
+		if _, ok := clusterNodes[node.Info.ClusterId]; !ok {
+			logger.Warning("Node %v is in cluster %v, which does not exist",
+				id, node.Info.ClusterId)
+			continue
+		}
+		clusterNodes[node.Info.ClusterId] = append(clusterNodes[node.Info.ClusterId], id)
+	}
+	for _, id := range deviceIds {
+		device, err := NewDeviceEntryFromId(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := nodeDevices[device.NodeId]; !ok {
+			logger.Warning("Device %v is on node %v, which does not exist",
+				id, device.NodeId)
+			continue
+		}
+		nodeDevices[device.NodeId] = append(nodeDevices[device.NodeId], id)
+	}
+	for _, id := range volumeIds {
+		volume, err := NewVolumeEntryFromId(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := clusterVolumes[volume.Info.Cluster]; !ok {
+			logger.Warning("Volume %v is in cluster %v, which does not exist",
+				id, volume.Info.Cluster)
+			continue
+		}
+		clusterVolumes[volume.Info.Cluster] = append(clusterVolumes[volume.Info.Cluster], id)
+	}
+
+	fixed := 0
+	for _, id := range clusterIds {
+		cluster, err := NewClusterEntryFromId(tx, id)
+		if err != nil {
+			return 0, err
+		}
+
+		// Keys are listed in order, so only the saved lists are sorted
+		cluster.Info.Nodes.Sort()
+		cluster.Info.Volumes.Sort()
+		n := sortedStringsDiff(cluster.Info.Nodes, clusterNodes[id]) +
+			sortedStringsDiff(cluster.Info.Volumes, clusterVolumes[id])
+		if n == 0 {
+			continue
+		}
+		logger.Warning("Nodes %v and volumes %v of cluster %v do not match "+
+			"its entries, replacing them with %v and %v", cluster.Info.Nodes,
+			cluster.Info.Volumes, id, clusterNodes[id], clusterVolumes[id])
+		cluster.Info.Nodes = clusterNodes[id]
+		cluster.Info.Volumes = clusterVolumes[id]
+		err = cluster.Save(tx)
+		if err != nil {
+			return 0, err
+		}
+		fixed += n
+	}
+	for _, node := range nodes {
+		node.Devices.Sort()
+		n := sortedStringsDiff(node.Devices, nodeDevices[node.Info.Id])
+		if n == 0 {
+			continue
+		}
+		logger.Warning("Devices %v of node %v do not match its entries, "+
+			"replacing them with %v", node.Devices, node.Info.Id,
+			nodeDevices[node.Info.Id])
+		node.Devices = nodeDevices[node.Info.Id]
+		err = node.Save(tx)
+		if err != nil {
+			return 0, err
+		}
+		fixed += n
+	}
+
+	return fixed, nil
+}
+
+// Rebuilds the registered hostnames of the nodes and names of the
+// devices.  Fails if two entries register the same key, as only the
+// admin can tell which one is right.
+func reindexRegistrations(tx *bolt.Tx) (int, error) {
+	nodeKeys := make(map[string]string)
+	deviceKeys := make(map[string]string)
+
+	register := func(keys map[string]string, key, id, what string) error {
+		if other, ok := keys[key]; ok && other != id {
+			return fmt.Errorf("%v is used by both %v and %v", what, other, id)
+		}
+		keys[key] = id
+		return nil
+	}
+
+	nodeIds, _, err := EntryKeysPage(tx, BOLTDB_BUCKET_NODE, "", 0, isNodeRegisterKey)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range nodeIds {
+		node, err := NewNodeEntryFromId(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		for _, h := range node.Info.Hostnames.Manage {
+			err := register(nodeKeys, node.registerManageKey(h), id,
+				"Manage hostname "+h)
+			if err != nil {
+				return 0, err
+			}
+		}
+		for _, h := range node.Info.Hostnames.Storage {
+			err := register(nodeKeys, node.registerStorageKey(h), id,
+				"Storage hostname "+h)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	deviceIds, _, err := EntryKeysPage(tx, BOLTDB_BUCKET_DEVICE, "", 0, isDeviceRegisterKey)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range deviceIds {
+		device, err := NewDeviceEntryFromId(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		err = register(deviceKeys, device.registerKey(), id,
+			fmt.Sprintf("Device %v of node %v", device.Info.Name, device.NodeId))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	fixed := 0
+	for bucket, expected := range map[string]map[string]string{
+		BOLTDB_BUCKET_NODE:   nodeKeys,
+		BOLTDB_BUCKET_DEVICE: deviceKeys,
+	} {
+		isKey := isNodeRegisterKey
+		if bucket == BOLTDB_BUCKET_DEVICE {
+			isKey = isDeviceRegisterKey
+		}
+		n, err := reindexKeys(tx, bucket, isKey, expected)
+		if err != nil {
+			return 0, err
+		}
+		fixed += n
+	}
+
+	return fixed, nil
+}
+
+// Makes the registration keys of the bucket match the expected keys
+// and values
+func reindexKeys(tx *bolt.Tx,
+	bucket string,
+	isKey func(key string) bool,
+	expected map[string]string) (int, error) {
+
+	b := tx.Bucket([]byte(bucket))
+	if b == nil {
+		err := ErrDbAccess
+		logger.Err(err)
+		return 0, err
+	}
+
+	// Keys cannot be changed while iterating the bucket
+	stale := make([]string, 0)
+	found := make(map[string]bool)
+	err := b.ForEach(func(k, v []byte) error {
+		key := string(k)
+		if !isKey(key) {
+			return nil
+		}
+		if id, ok := expected[key]; ok && bytes.Equal(v, []byte(id)) {
+			found[key] = true
+			return nil
+		}
+		stale = append(stale, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	fixed := 0
+	for _, key := range stale {
+		logger.Warning("Removing stale key %v from %v", key, bucket)
+		err := b.Delete([]byte(key))
+		if err != nil {
+			return 0, err
+		}
+		fixed++
+	}
+	for key, id := range expected {
+		if found[key] {
+			continue
+		}
+		logger.Warning("Adding missing key %v of %v to %v", key, id, bucket)
+		err := b.Put([]byte(key), []byte(id))
+		if err != nil {
+			return 0, err
+		}
+		fixed++
+	}
+
+	return fixed, nil
+}
@@ -0,0 +1,250 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/tests"
+)
+
+// Creates a cluster with a volume and registers the hostnames and
+// devices of its nodes.  Returns the cluster.
+func createSampleReindexDb(t *testing.T, app *App) *ClusterEntry {
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+
+	// The sample nodes and devices are not registered
+	err = Reindex(app.db)
+	tests.Assert(t, err == nil, err)
+
+	var cluster *ClusterEntry
+	err = app.db.View(func(tx *bolt.Tx) error {
+		var err error
+		cluster, err = NewClusterEntryFromId(tx, v.Info.Cluster)
+		return err
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(cluster.Info.Nodes) == 3)
+	tests.Assert(t, len(cluster.Info.Volumes) == 1)
+
+	return cluster
+}
+
+// Removes the first node and the volume from the cluster, a device
+// from the node, and the manage hostname of the node, and registers
+// a hostname and a device which do not exist
+func corruptSampleReindexDb(t *testing.T, db *bolt.DB, c *ClusterEntry) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, c.Info.Id)
+		if err != nil {
+			return err
+		}
+		node, err := NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		if err != nil {
+			return err
+		}
+
+		cluster.NodeDelete(node.Info.Id)
+		cluster.VolumeDelete(cluster.Info.Volumes[0])
+		err = cluster.Save(tx)
+		if err != nil {
+			return err
+		}
+
+		node.DeviceDelete(node.Devices[0])
+		err = node.Save(tx)
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte(BOLTDB_BUCKET_NODE))
+		err = b.Delete([]byte(node.registerManageKey(node.ManageHostName())))
+		if err != nil {
+			return err
+		}
+		err = b.Put([]byte(node.registerStorageKey("oldhost")), []byte(node.Info.Id))
+		if err != nil {
+			return err
+		}
+
+		b = tx.Bucket([]byte(BOLTDB_BUCKET_DEVICE))
+		return b.Put([]byte("DEVICE"+node.Info.Id+"/dev/old"), []byte("123"))
+	})
+	tests.Assert(t, err == nil, err)
+}
+
+func checkSampleReindexDb(t *testing.T, db *bolt.DB, c *ClusterEntry) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, c.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(cluster.Info.Nodes) == 3, cluster.Info.Nodes)
+		tests.Assert(t, len(cluster.Info.Volumes) == 1, cluster.Info.Volumes)
+
+		node, err := NewNodeEntryFromId(tx, c.Info.Nodes[0])
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(node.Devices) == 2, node.Devices)
+
+		// The hostname of the node is registered again
+		other := createSampleNodeEntry()
+		other.Info.Hostnames.Manage = []string{node.ManageHostName()}
+		err = other.Register(tx)
+		tests.Assert(t, err != nil)
+		tests.Assert(t, strings.Contains(err.Error(), "already used"), err)
+
+		// The stale hostname and device are free
+		other = createSampleNodeEntry()
+		other.Info.Hostnames.Storage = []string{"oldhost"}
+		err = other.Register(tx)
+		tests.Assert(t, err == nil, err)
+
+		device := createSampleDeviceEntry(node.Info.Id, 1*TB)
+		device.Info.Name = "/dev/old"
+		err = device.Register(tx)
+		tests.Assert(t, err == nil, err)
+
+		// Only check the lookups
+		return errDryRun
+	})
+	tests.Assert(t, err == errDryRun)
+}
+
+func TestReindex(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	cluster := createSampleReindexDb(t, app)
+
+	// Nothing to do on a db which was not edited
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		fixed, err := reindex(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, fixed == 0, fixed)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	corruptSampleReindexDb(t, app.db, cluster)
+
+	err = Reindex(app.db)
+	tests.Assert(t, err == nil, err)
+	checkSampleReindexDb(t, app.db, cluster)
+
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		fixed, err := reindex(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, fixed == 0, fixed)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestReindexAtStartup(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	cluster := createSampleReindexDb(t, app)
+	corruptSampleReindexDb(t, app.db, cluster)
+	app.Close()
+
+	app = NewTestApp(tmpfile)
+	defer app.Close()
+	checkSampleReindexDb(t, app.db, cluster)
+}
+
+func TestReindexHostnameConflict(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	cluster := createSampleReindexDb(t, app)
+
+	// Give two nodes the same hostname
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		first, err := NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		if err != nil {
+			return err
+		}
+		node, err := NewNodeEntryFromId(tx, cluster.Info.Nodes[1])
+		if err != nil {
+			return err
+		}
+		node.Info.Hostnames.Manage = first.Info.Hostnames.Manage
+		return node.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	err = Reindex(app.db)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "is used by both"), err)
+}
+
+func TestReindexInvalidStorage(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	cluster := createSampleReindexDb(t, app)
+
+	// Free more than the total of a device
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		if err != nil {
+			return err
+		}
+		device, err := NewDeviceEntryFromId(tx, node.Devices[0])
+		if err != nil {
+			return err
+		}
+		device.Info.Storage.Free = device.Info.Storage.Total + 1
+		return device.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	err = Reindex(app.db)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "exceeds total"), err)
+
+	// Only logged when not strict
+	defer func(s bool) { StrictStorage = s }(StrictStorage)
+	StrictStorage = false
+	err = Reindex(app.db)
+	tests.Assert(t, err == nil, err)
+}
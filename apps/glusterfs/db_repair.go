@@ -0,0 +1,450 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+// Returned from the transaction to discard the changes of a dry run
+var errDryRun = errors.New("Dry run")
+
+// Repairs dangling references in a db which is not in use by a
+// running server.  Every change is printed before it is applied, and
+// on a dry run the changes are only printed.
+type DbRepair struct {
+	db     *bolt.DB
+	out    io.Writer
+	dryRun bool
+}
+
+func NewDbRepair(dbfile string, out io.Writer, dryRun bool) (*DbRepair, error) {
+	// Do not create a new db
+	if _, err := os.Stat(dbfile); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(dbfile, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open database %v: %v", dbfile, err)
+	}
+
+	err = db.View(dbCheckSchemaVersion)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	err = useDbCodec(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DbRepair{
+		db:     db,
+		out:    out,
+		dryRun: dryRun,
+	}, nil
+}
+
+func (r *DbRepair) Close() {
+	r.db.Close()
+}
+
+func (r *DbRepair) printf(format string, v ...interface{}) {
+	if r.dryRun {
+		format = "[dry-run] " + format
+	}
+	fmt.Fprintf(r.out, format+"\n", v...)
+}
+
+func (r *DbRepair) update(fn func(tx *bolt.Tx) error) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		err := fn(tx)
+		if err != nil {
+			return err
+		}
+		if r.dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		return nil
+	}
+	return err
+}
+
+// Rebuild the indexes derived from the entries, as the server does
+// at startup.  The changes are logged.
+func (r *DbRepair) Reindex() error {
+	return r.update(func(tx *bolt.Tx) error {
+		fixed, err := reindex(tx)
+		if err != nil {
+			return err
+		}
+		r.printf("Repaired %v index entries", fixed)
+		return nil
+	})
+}
+
+// Delete a node and remove it from its cluster.  Unless force is set,
+// the node must not have any devices.  With force, all the devices of
+// the node are detached first.
+func (r *DbRepair) DeleteNode(id string, force bool) error {
+	return r.update(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, id)
+		if err == ErrNotFound {
+			// Only remove references from clusters
+			return r.removeNodeFromClusters(tx, id)
+		} else if err != nil {
+			return err
+		}
+
+		if !node.IsDeleteOk() {
+			if !force {
+				return fmt.Errorf("Node %v has %v devices, use force to detach them",
+					id, len(node.Devices))
+			}
+			for _, deviceId := range node.Devices {
+				err := r.detachDevice(tx, deviceId)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Reload the node after its devices were removed
+			node, err = NewNodeEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = r.removeNodeFromClusters(tx, id)
+		if err != nil {
+			return err
+		}
+
+		r.printf("Deregister hostnames %v %v of node %v",
+			node.Info.Hostnames.Manage, node.Info.Hostnames.Storage, id)
+		err = node.Deregister(tx)
+		if err != nil {
+			return err
+		}
+
+		r.printf("Delete node %v", id)
+		return node.Delete(tx, false)
+	})
+}
+
+// Delete all the bricks of a volume, returning their space to the
+// devices they were allocated on
+func (r *DbRepair) DeleteBricks(volumeId string) error {
+	return r.update(func(tx *bolt.Tx) error {
+		volume, err := NewVolumeEntryFromId(tx, volumeId)
+		if err != nil {
+			return err
+		}
+
+		devices := make(map[string]bool)
+		for _, brickId := range volume.BricksIds() {
+			brick, err := NewBrickEntryFromId(tx, brickId)
+			if err == ErrNotFound {
+				r.printf("Remove missing brick %v from volume %v", brickId, volumeId)
+				volume.BrickDelete(brickId)
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			err = r.deleteBrick(tx, brick)
+			if err != nil {
+				return err
+			}
+			devices[brick.Info.DeviceId] = true
+
+			r.printf("Remove brick %v from volume %v", brickId, volumeId)
+			volume.BrickDelete(brickId)
+		}
+
+		err = volume.Save(tx)
+		if err != nil {
+			return err
+		}
+
+		for deviceId := range devices {
+			err := r.recomputeDeviceStorage(tx, deviceId)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Delete a device along with its bricks and remove it from its node
+func (r *DbRepair) DetachDevice(id string) error {
+	return r.update(func(tx *bolt.Tx) error {
+		return r.detachDevice(tx, id)
+	})
+}
+
+// Merge the devices of a node which were added twice with the same
+// path, keeping one device with all their bricks
+func (r *DbRepair) MergeDevices(nodeId string) error {
+	return r.update(func(tx *bolt.Tx) error {
+		merged, err := MergeDuplicateDevices(tx, nodeId)
+		if err != nil {
+			return err
+		}
+		r.printf("Merged devices %v of node %v", merged, nodeId)
+		return nil
+	})
+}
+
+// Correct the storage accounting of the devices, nodes and cluster
+// from the bricks, and remove references to missing entries
+func (r *DbRepair) ReconcileCluster(id string) error {
+	return r.update(func(tx *bolt.Tx) error {
+		report, err := ReconcileCluster(tx, id)
+		if err != nil {
+			return err
+		}
+		for _, correction := range report.Corrections {
+			r.printf("%v", correction)
+		}
+		nodes := make([]string, 0, len(report.Nodes))
+		for nodeId := range report.Nodes {
+			nodes = append(nodes, nodeId)
+		}
+		sort.Strings(nodes)
+		for _, nodeId := range nodes {
+			storage := report.Nodes[nodeId]
+			r.printf("Node %v storage total:%v free:%v used:%v",
+				nodeId, storage.Total, storage.Free, storage.Used)
+		}
+		r.printf("Cluster %v storage total:%v free:%v used:%v, %v corrections",
+			id, report.Storage.Total, report.Storage.Free, report.Storage.Used,
+			len(report.Corrections))
+		return nil
+	})
+}
+
+func (r *DbRepair) detachDevice(tx *bolt.Tx, id string) error {
+	device, err := NewDeviceEntryFromId(tx, id)
+	if err == ErrNotFound {
+		// Only remove references from nodes
+		return r.removeDeviceFromNodes(tx, id)
+	} else if err != nil {
+		return err
+	}
+
+	// Delete the bricks on the device and remove them from their volumes
+	volumes, err := r.brickVolumes(tx)
+	if err != nil {
+		return err
+	}
+	for _, brickId := range device.Bricks {
+		brick, err := NewBrickEntryFromId(tx, brickId)
+		if err == ErrNotFound {
+			brick = &BrickEntry{}
+			brick.Info.Id = brickId
+			brick.Info.DeviceId = id
+		} else if err != nil {
+			return err
+		}
+
+		if volume, ok := volumes[brickId]; ok {
+			r.printf("Remove brick %v from volume %v", brickId, volume.Info.Id)
+			volume.BrickDelete(brickId)
+			err := volume.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = r.deleteBrick(tx, brick)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Reload the device after its bricks were removed
+	device, err = NewDeviceEntryFromId(tx, id)
+	if err != nil {
+		return err
+	}
+
+	// Remove the device from its node
+	node, err := NewNodeEntryFromId(tx, device.NodeId)
+	if err == nil {
+		r.printf("Remove device %v from node %v", id, node.Info.Id)
+		node.DeviceDelete(id)
+		err = node.Save(tx)
+		if err != nil {
+			return err
+		}
+	} else if err != ErrNotFound {
+		return err
+	}
+
+	r.printf("Deregister device %v of node %v", device.Info.Name, device.NodeId)
+	err = device.Deregister(tx)
+	if err != nil {
+		return err
+	}
+
+	r.printf("Delete device %v", id)
+	return device.Delete(tx)
+}
+
+// Delete the brick entry and return its space to its device
+func (r *DbRepair) deleteBrick(tx *bolt.Tx, brick *BrickEntry) error {
+	device, err := NewDeviceEntryFromId(tx, brick.Info.DeviceId)
+	if err == nil {
+		r.printf("Remove brick %v from device %v", brick.Info.Id, device.Info.Id)
+		device.BrickDelete(brick.Info.Id)
+		err = device.Save(tx)
+		if err != nil {
+			return err
+		}
+		err = r.recomputeDeviceStorage(tx, device.Info.Id)
+		if err != nil {
+			return err
+		}
+	} else if err != ErrNotFound {
+		return err
+	}
+
+	r.printf("Delete brick %v", brick.Info.Id)
+	return brick.Delete(tx)
+}
+
+// Set the used and free storage of the device from the bricks
+// which are still allocated on it
+func (r *DbRepair) recomputeDeviceStorage(tx *bolt.Tx, id string) error {
+	device, err := NewDeviceEntryFromId(tx, id)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	corrections, err := reconcileDeviceStorage(tx, device)
+	if err != nil {
+		return err
+	}
+	if len(corrections) == 0 {
+		return nil
+	}
+	for _, correction := range corrections {
+		r.printf("%v", correction)
+	}
+
+	return device.Save(tx)
+}
+
+func (r *DbRepair) removeNodeFromClusters(tx *bolt.Tx, id string) error {
+	clusters, err := ClusterList(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, clusterId := range clusters {
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		if err != nil {
+			return err
+		}
+		if !utils.SortedStringHas(cluster.Info.Nodes, id) {
+			continue
+		}
+
+		r.printf("Remove node %v from cluster %v", id, clusterId)
+		cluster.NodeDelete(id)
+		err = cluster.Save(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *DbRepair) removeDeviceFromNodes(tx *bolt.Tx, id string) error {
+	clusters, err := ClusterList(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, clusterId := range clusters {
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		if err != nil {
+			return err
+		}
+
+		for _, nodeId := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			if err == ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if !utils.SortedStringHas(node.Devices, id) {
+				continue
+			}
+
+			r.printf("Remove device %v from node %v", id, nodeId)
+			node.DeviceDelete(id)
+			err = node.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns a map of brick ids to the volume which uses the brick
+func (r *DbRepair) brickVolumes(tx *bolt.Tx) (map[string]*VolumeEntry, error) {
+	list, err := VolumeList(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := make(map[string]*VolumeEntry)
+	for _, id := range list {
+		volume, err := NewVolumeEntryFromId(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, brickId := range volume.Bricks {
+			volumes[brickId] = volume
+		}
+	}
+
+	return volumes, nil
+}
@@ -0,0 +1,290 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/tests"
+)
+
+// Creates a db with one cluster and a volume, and returns the volume
+func createSampleRepairDb(t *testing.T, dbfile string) *VolumeEntry {
+	app := NewTestApp(dbfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+
+	return v
+}
+
+func TestDbRepairDeleteBricks(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	v := createSampleRepairDb(t, tmpfile)
+	tests.Assert(t, len(v.Bricks) > 0)
+
+	// Dry run does not change the db
+	var out bytes.Buffer
+	r, err := NewDbRepair(tmpfile, &out, true)
+	tests.Assert(t, err == nil)
+	err = r.DeleteBricks(v.Info.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, strings.Contains(out.String(), "[dry-run] Delete brick "+v.Bricks[0]))
+
+	err = r.db.View(func(tx *bolt.Tx) error {
+		volume, err := NewVolumeEntryFromId(tx, v.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(volume.Bricks) == len(v.Bricks))
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	r.Close()
+
+	// Apply
+	out.Reset()
+	r, err = NewDbRepair(tmpfile, &out, false)
+	tests.Assert(t, err == nil)
+	defer r.Close()
+	err = r.DeleteBricks(v.Info.Id)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, strings.Contains(out.String(), "Delete brick "+v.Bricks[0]))
+	tests.Assert(t, !strings.Contains(out.String(), "[dry-run]"))
+
+	err = r.db.View(func(tx *bolt.Tx) error {
+		volume, err := NewVolumeEntryFromId(tx, v.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(volume.Bricks) == 0)
+
+		bricks, err := BrickList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(bricks) == 0)
+
+		// All the space has been returned
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		tests.Assert(t, err == nil)
+		for _, nodeId := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			tests.Assert(t, err == nil)
+			for _, deviceId := range node.Devices {
+				device, err := NewDeviceEntryFromId(tx, deviceId)
+				tests.Assert(t, err == nil)
+				tests.Assert(t, len(device.Bricks) == 0)
+				tests.Assert(t, device.Info.Storage.Used == 0)
+				tests.Assert(t, device.Info.Storage.Free == device.Info.Storage.Total)
+			}
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Missing volume
+	err = r.DeleteBricks("nothere")
+	tests.Assert(t, err == ErrNotFound)
+}
+
+func TestDbRepairDetachDevice(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	v := createSampleRepairDb(t, tmpfile)
+
+	var out bytes.Buffer
+	r, err := NewDbRepair(tmpfile, &out, false)
+	tests.Assert(t, err == nil)
+	defer r.Close()
+
+	// Get a device used by the volume
+	var brick *BrickEntry
+	err = r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		brick, err = NewBrickEntryFromId(tx, v.Bricks[0])
+		return err
+	})
+	tests.Assert(t, err == nil)
+
+	err = r.DetachDevice(brick.Info.DeviceId)
+	tests.Assert(t, err == nil)
+
+	err = r.db.View(func(tx *bolt.Tx) error {
+		_, err := NewDeviceEntryFromId(tx, brick.Info.DeviceId)
+		tests.Assert(t, err == ErrNotFound)
+
+		_, err = NewBrickEntryFromId(tx, brick.Info.Id)
+		tests.Assert(t, err == ErrNotFound)
+
+		node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+		tests.Assert(t, err == nil)
+		for _, id := range node.Devices {
+			tests.Assert(t, id != brick.Info.DeviceId)
+		}
+
+		// No bricks of the volume are left on the device
+		volume, err := NewVolumeEntryFromId(tx, v.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(volume.Bricks) < len(v.Bricks))
+		for _, id := range volume.Bricks {
+			b, err := NewBrickEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, b.Info.DeviceId != brick.Info.DeviceId)
+		}
+
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Dangling device reference on a node
+	err = r.db.Update(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+		tests.Assert(t, err == nil)
+		node.DeviceAdd("dangling")
+		return node.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	out.Reset()
+	err = r.DetachDevice("dangling")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, strings.Contains(out.String(), "Remove device dangling from node"))
+
+	err = r.db.View(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+		tests.Assert(t, err == nil)
+		for _, id := range node.Devices {
+			tests.Assert(t, id != "dangling")
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestDbRepairDeleteNode(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	v := createSampleRepairDb(t, tmpfile)
+
+	var out bytes.Buffer
+	r, err := NewDbRepair(tmpfile, &out, false)
+	tests.Assert(t, err == nil)
+	defer r.Close()
+
+	var brick *BrickEntry
+	err = r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		brick, err = NewBrickEntryFromId(tx, v.Bricks[0])
+		return err
+	})
+	tests.Assert(t, err == nil)
+
+	// Node has devices
+	err = r.DeleteNode(brick.Info.NodeId, false)
+	tests.Assert(t, err != nil)
+
+	// Force
+	err = r.DeleteNode(brick.Info.NodeId, true)
+	tests.Assert(t, err == nil)
+
+	err = r.db.View(func(tx *bolt.Tx) error {
+		_, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+		tests.Assert(t, err == ErrNotFound)
+
+		_, err = NewDeviceEntryFromId(tx, brick.Info.DeviceId)
+		tests.Assert(t, err == ErrNotFound)
+
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(cluster.Info.Nodes) == 3)
+		for _, id := range cluster.Info.Nodes {
+			tests.Assert(t, id != brick.Info.NodeId)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestDbRepairReindex(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	v := createSampleRepairDb(t, tmpfile)
+
+	// Drop the volume from its cluster
+	r, err := NewDbRepair(tmpfile, &bytes.Buffer{}, false)
+	tests.Assert(t, err == nil)
+	err = r.db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, v.Info.Cluster)
+		if err != nil {
+			return err
+		}
+		cluster.VolumeDelete(v.Info.Id)
+		return cluster.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+	r.Close()
+
+	// Dry run does not change the db
+	var out bytes.Buffer
+	r, err = NewDbRepair(tmpfile, &out, true)
+	tests.Assert(t, err == nil)
+	err = r.Reindex()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, strings.Contains(out.String(), "[dry-run] Repaired"), out.String())
+	err = r.db.View(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, v.Info.Cluster)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(cluster.Info.Volumes) == 0)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	r.Close()
+
+	// Apply
+	out.Reset()
+	r, err = NewDbRepair(tmpfile, &out, false)
+	tests.Assert(t, err == nil)
+	defer r.Close()
+	err = r.Reindex()
+	tests.Assert(t, err == nil, err)
+	err = r.db.View(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, v.Info.Cluster)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(cluster.Info.Volumes) == 1)
+		tests.Assert(t, cluster.Info.Volumes[0] == v.Info.Id)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
@@ -17,10 +17,17 @@
 package glusterfs
 
 import (
+	"errors"
+	"reflect"
+
 	"github.com/boltdb/bolt"
 	"github.com/lpabon/godbc"
 )
 
+// Number of times an operation is run again after another operation
+// changed an entry it was saving
+const entryStaleRetries = 5
+
 type DbEntry interface {
 	BucketName() string
 	Marshal() ([]byte, error)
@@ -77,6 +84,47 @@ func EntryKeys(tx *bolt.Tx, bucket string) []string {
 	return list
 }
 
+// Returns up to limit keys of the bucket following the key after, or
+// all of them if limit is zero.  The last key returned is also
+// returned if the bucket has more keys, to get the next page.  Keys
+// for which skip returns true are not returned.
+func EntryKeysPage(tx *bolt.Tx, bucket, after string, limit int,
+	skip func(key string) bool) ([]string, string, error) {
+	godbc.Require(tx != nil)
+	godbc.Require(limit >= 0)
+
+	b := tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil, "", ErrAccessList
+	}
+
+	// Keys are sorted, so the page starts after the given key even
+	// if it was deleted since
+	c := b.Cursor()
+	var k []byte
+	if after == "" {
+		k, _ = c.First()
+	} else {
+		k, _ = c.Seek([]byte(after))
+		if k != nil && string(k) == after {
+			k, _ = c.Next()
+		}
+	}
+
+	list := make([]string, 0)
+	for ; k != nil; k, _ = c.Next() {
+		if skip != nil && skip(string(k)) {
+			continue
+		}
+		if limit > 0 && len(list) == limit {
+			return list, list[len(list)-1], nil
+		}
+		list = append(list, string(k))
+	}
+
+	return list, "", nil
+}
+
 func EntrySave(tx *bolt.Tx, entry DbEntry, key string) error {
 	godbc.Require(tx != nil)
 	godbc.Require(len(key) > 0)
@@ -89,6 +137,57 @@ func EntrySave(tx *bolt.Tx, entry DbEntry, key string) error {
 		return err
 	}
 
+	if o := overlayOf(tx); o != nil {
+		buffer, err := entry.Marshal()
+		if err != nil {
+			logger.Err(err)
+			return err
+		}
+		o.putEntry(entry.BucketName(), key, buffer)
+		return nil
+	}
+
+	// Make sure the entry was not saved by another operation since it
+	// was loaded, otherwise the changes of that operation would be lost
+	if r, ok := entry.(revisionedEntry); ok {
+		rev := r.revision()
+		next := rev.Generation + 1
+		expected := rev.Generation
+		if rev.savedIn == tx {
+			// Already saved at the next generation in this transaction
+			expected = next
+		}
+		if val := b.Get([]byte(key)); val != nil {
+			stored := reflect.New(reflect.TypeOf(entry).Elem()).Interface().(revisionedEntry)
+			err := stored.Unmarshal(val)
+			if err != nil {
+				logger.Err(err)
+				return err
+			}
+			if stored.revision().Generation != expected {
+				logger.Warning("Entry %v in %v was saved at generation %v, expected %v",
+					key, entry.BucketName(),
+					stored.revision().Generation, expected)
+				return ErrStaleEntry
+			}
+		}
+
+		// The entry is saved at the next generation, but only moves to
+		// it if the transaction commits
+		if rev.savedIn != tx {
+			rev.savedIn = tx
+			tx.OnCommit(func() {
+				rev.Generation = next
+				rev.savedIn = nil
+			})
+		}
+		previous := rev.Generation
+		rev.Generation = next
+		defer func() {
+			rev.Generation = previous
+		}()
+	}
+
 	// Save device entry to db
 	buffer, err := entry.Marshal()
 	if err != nil {
@@ -118,6 +217,11 @@ func EntryDelete(tx *bolt.Tx, entry DbEntry, key string) error {
 		return err
 	}
 
+	if o := overlayOf(tx); o != nil {
+		o.putEntry(entry.BucketName(), key, nil)
+		return nil
+	}
+
 	// Delete key
 	err := b.Delete([]byte(key))
 	if err != nil {
@@ -140,6 +244,11 @@ func EntryLoad(tx *bolt.Tx, entry DbEntry, key string) error {
 	}
 
 	val := b.Get([]byte(key))
+	if o := overlayOf(tx); o != nil {
+		if changed, ok := o.entry(entry.BucketName(), key); ok {
+			val = changed
+		}
+	}
 	if val == nil {
 		return ErrNotFound
 	}
@@ -152,3 +261,51 @@ func EntryLoad(tx *bolt.Tx, entry DbEntry, key string) error {
 
 	return nil
 }
+
+// Returns a copy of the bytes stored for the key, as they are only
+// valid during the transaction
+func EntryLoadRaw(tx *bolt.Tx, entry DbEntry, key string) ([]byte, error) {
+	godbc.Require(tx != nil)
+	godbc.Require(len(key) > 0)
+
+	b := tx.Bucket([]byte(entry.BucketName()))
+	if b == nil {
+		err := ErrDbAccess
+		logger.Err(err)
+		return nil, err
+	}
+
+	val := b.Get([]byte(key))
+	if o := overlayOf(tx); o != nil {
+		if changed, ok := o.entry(entry.BucketName(), key); ok {
+			val = changed
+		}
+	}
+	if val == nil {
+		return nil, ErrNotFound
+	}
+
+	raw := make([]byte, len(val))
+	copy(raw, val)
+
+	return raw, nil
+}
+
+// Runs an operation which loads entries in one transaction and saves
+// them in a later one.  If a save fails with ErrStaleEntry because
+// another operation saved the entry in between, the operation is run
+// again with retry set so that it reloads the entries and applies its
+// changes to them.
+func EntryRetryStale(operation func(retry bool) error) error {
+	var err error
+	for attempt := 0; attempt <= entryStaleRetries; attempt++ {
+		err = operation(attempt > 0)
+		if !errors.Is(err, ErrStaleEntry) {
+			return err
+		}
+		logger.Info("Retrying operation on changed entry (%v/%v)",
+			attempt+1, entryStaleRetries)
+	}
+
+	return err
+}
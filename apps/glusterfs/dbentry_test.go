@@ -17,6 +17,8 @@
 package glusterfs
 
 import (
+	"errors"
+
 	"github.com/boltdb/bolt"
 	"github.com/heketi/tests"
 	"os"
@@ -84,3 +86,171 @@ func TestEntryRegister(t *testing.T) {
 	tests.Assert(t, err == nil)
 
 }
+
+func TestEntrySaveStale(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	n := createSampleNodeEntry()
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		return n.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, n.Generation == 1)
+
+	// Load two copies, as two operations would
+	var first, second *NodeEntry
+	err = app.db.View(func(tx *bolt.Tx) error {
+		var err error
+		first, err = NewNodeEntryFromId(tx, n.Info.Id)
+		tests.Assert(t, err == nil)
+		second, err = NewNodeEntryFromId(tx, n.Info.Id)
+		tests.Assert(t, err == nil)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, first.Generation == 1)
+
+	// A save which is rolled back leaves the generation as loaded
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		tests.Assert(t, first.Save(tx) == nil)
+		tests.Assert(t, first.Save(tx) == nil)
+		return errors.New("rolled back")
+	})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, first.Generation == 1)
+
+	// The first save wins
+	first.DeviceAdd("abc")
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return first.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, first.Generation == 2)
+
+	// The second would lose the device
+	second.DeviceAdd("def")
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return second.Save(tx)
+	})
+	tests.Assert(t, errors.Is(err, ErrStaleEntry), err)
+
+	// Retry on the reloaded entry
+	attempts := 0
+	err = EntryRetryStale(func(retry bool) error {
+		attempts++
+		return app.db.Update(func(tx *bolt.Tx) error {
+			if retry {
+				latest, err := NewNodeEntryFromId(tx, n.Info.Id)
+				tests.Assert(t, err == nil)
+				latest.DeviceAdd("def")
+				second = latest
+			}
+			return second.Save(tx)
+		})
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, attempts == 2)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, n.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, node.Generation == 3)
+		tests.Assert(t, len(node.Devices) == 2)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Give up after a number of retries
+	attempts = 0
+	err = EntryRetryStale(func(retry bool) error {
+		attempts++
+		return ErrStaleEntry
+	})
+	tests.Assert(t, err == ErrStaleEntry)
+	tests.Assert(t, attempts == entryStaleRetries+1)
+}
+
+func TestEntryKeysPage(t *testing.T) {
+	tmpfile := tests.Tempfile()
+
+	// Setup BoltDB database
+	db, err := bolt.Open(tmpfile, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	tests.Assert(t, err == nil)
+	defer os.Remove(tmpfile)
+
+	entry := &testDbEntry{}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(entry.BucketName()))
+		tests.Assert(t, err == nil)
+
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			_, err = EntryRegister(tx, entry, key, []byte("value"))
+			tests.Assert(t, err == nil)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	err = db.View(func(tx *bolt.Tx) error {
+
+		// Whole list
+		list, last, err := EntryKeysPage(tx, entry.BucketName(), "", 0, nil)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 5)
+		tests.Assert(t, last == "")
+
+		// Pages
+		list, last, err = EntryKeysPage(tx, entry.BucketName(), "", 2, nil)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 2 && list[0] == "a" && list[1] == "b", list)
+		tests.Assert(t, last == "b")
+
+		list, last, err = EntryKeysPage(tx, entry.BucketName(), last, 2, nil)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 2 && list[0] == "c" && list[1] == "d", list)
+		tests.Assert(t, last == "d")
+
+		list, last, err = EntryKeysPage(tx, entry.BucketName(), last, 2, nil)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 1 && list[0] == "e", list)
+		tests.Assert(t, last == "")
+
+		// A full last page has no next page
+		list, last, err = EntryKeysPage(tx, entry.BucketName(), "c", 2, nil)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 2)
+		tests.Assert(t, last == "")
+
+		// The page starts after a key which no longer exists
+		list, last, err = EntryKeysPage(tx, entry.BucketName(), "bb", 0, nil)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 3 && list[0] == "c", list)
+
+		// After the last key
+		list, last, err = EntryKeysPage(tx, entry.BucketName(), "z", 2, nil)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 0)
+		tests.Assert(t, last == "")
+
+		// Skipped keys do not count in the limit
+		skip := func(key string) bool {
+			return key == "b" || key == "c"
+		}
+		list, last, err = EntryKeysPage(tx, entry.BucketName(), "", 2, skip)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 2 && list[0] == "a" && list[1] == "d", list)
+		tests.Assert(t, last == "d")
+
+		// No bucket
+		_, _, err = EntryKeysPage(tx, "nosuchbucket", "", 0, nil)
+		tests.Assert(t, err == ErrAccessList)
+
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
@@ -0,0 +1,258 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/lpabon/godbc"
+)
+
+// Secondary indexes are kept in their own buckets.  Each index bucket
+// contains a sub-bucket for every parent id, and the keys of that
+// sub-bucket are the ids of its children.
+const (
+	BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES = "INDEX_CLUSTER_VOLUMES"
+	BOLTDB_BUCKET_INDEX_NODE_BRICKS     = "INDEX_NODE_BRICKS"
+)
+
+// Adds child to the list of ids indexed under parent
+func IndexAdd(tx *bolt.Tx, index, parent, child string) error {
+	godbc.Require(tx != nil)
+	godbc.Require(len(parent) > 0)
+	godbc.Require(len(child) > 0)
+
+	b := tx.Bucket([]byte(index))
+	if b == nil {
+		err := ErrDbAccess
+		logger.Err(err)
+		return err
+	}
+
+	if o := overlayOf(tx); o != nil {
+		o.putChild(index, parent, child, true)
+		return nil
+	}
+
+	children, err := b.CreateBucketIfNotExists([]byte(parent))
+	if err != nil {
+		logger.Err(err)
+		return err
+	}
+
+	err = children.Put([]byte(child), []byte{})
+	if err != nil {
+		logger.Err(err)
+		return err
+	}
+
+	return nil
+}
+
+// Removes child from the list of ids indexed under parent.  The
+// parent is removed from the index once it has no more children.
+func IndexDelete(tx *bolt.Tx, index, parent, child string) error {
+	godbc.Require(tx != nil)
+	godbc.Require(len(parent) > 0)
+	godbc.Require(len(child) > 0)
+
+	b := tx.Bucket([]byte(index))
+	if b == nil {
+		err := ErrDbAccess
+		logger.Err(err)
+		return err
+	}
+
+	if o := overlayOf(tx); o != nil {
+		o.putChild(index, parent, child, false)
+		return nil
+	}
+
+	children := b.Bucket([]byte(parent))
+	if children == nil {
+		return nil
+	}
+
+	err := children.Delete([]byte(child))
+	if err != nil {
+		logger.LogError("Unable to delete key [%v] from index %v: %v",
+			child, index, err.Error())
+		return err
+	}
+
+	if k, _ := children.Cursor().First(); k == nil {
+		return b.DeleteBucket([]byte(parent))
+	}
+
+	return nil
+}
+
+// Returns the ids indexed under parent, in order
+func IndexList(tx *bolt.Tx, index, parent string) ([]string, error) {
+	godbc.Require(tx != nil)
+
+	b := tx.Bucket([]byte(index))
+	if b == nil {
+		return nil, ErrAccessList
+	}
+
+	list := make([]string, 0)
+	if children := b.Bucket([]byte(parent)); children != nil {
+		err := children.ForEach(func(k, v []byte) error {
+			list = append(list, string(k))
+			return nil
+		})
+		if err != nil {
+			return nil, ErrAccessList
+		}
+	}
+
+	if o := overlayOf(tx); o != nil {
+		list = o.indexList(index, parent, list)
+	}
+
+	return list, nil
+}
+
+// Rebuilds the secondary indexes from the primary buckets.  Any entry
+// missing from, or stale in, an index is logged before the index is
+// replaced.  Returns the number of discrepancies found.
+func IndexReconcile(tx *bolt.Tx) (int, error) {
+	godbc.Require(tx != nil)
+
+	clusterVolumes := make(map[string]map[string]bool)
+	nodeBricks := make(map[string]map[string]bool)
+
+	// Volumes
+	volumes, err := VolumeList(tx)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range volumes {
+		volume, err := NewVolumeEntryFromId(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		if volume.Info.Cluster == "" {
+			continue
+		}
+		if _, ok := clusterVolumes[volume.Info.Cluster]; !ok {
+			clusterVolumes[volume.Info.Cluster] = make(map[string]bool)
+		}
+		clusterVolumes[volume.Info.Cluster][id] = true
+	}
+
+	// Bricks
+	bricks, err := BrickList(tx)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range bricks {
+		brick, err := NewBrickEntryFromId(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		if brick.Info.NodeId == "" {
+			continue
+		}
+		if _, ok := nodeBricks[brick.Info.NodeId]; !ok {
+			nodeBricks[brick.Info.NodeId] = make(map[string]bool)
+		}
+		nodeBricks[brick.Info.NodeId][id] = true
+	}
+
+	fixed := 0
+	for index, expected := range map[string]map[string]map[string]bool{
+		BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES: clusterVolumes,
+		BOLTDB_BUCKET_INDEX_NODE_BRICKS:     nodeBricks,
+	} {
+		n, err := indexRebuild(tx, index, expected)
+		if err != nil {
+			return 0, err
+		}
+		fixed += n
+	}
+
+	return fixed, nil
+}
+
+func indexRebuild(tx *bolt.Tx,
+	index string,
+	expected map[string]map[string]bool) (int, error) {
+
+	b := tx.Bucket([]byte(index))
+	if b == nil {
+		err := ErrDbAccess
+		logger.Err(err)
+		return 0, err
+	}
+
+	// Check what is currently in the index against the primary entries
+	fixed := 0
+	found := make(map[string]map[string]bool)
+	err := b.ForEach(func(parent, v []byte) error {
+		children := b.Bucket(parent)
+		if children == nil {
+			return nil
+		}
+		found[string(parent)] = make(map[string]bool)
+		return children.ForEach(func(child, v []byte) error {
+			found[string(parent)][string(child)] = true
+			if !expected[string(parent)][string(child)] {
+				logger.Warning("Index %v: stale entry %v -> %v",
+					index, string(parent), string(child))
+				fixed++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	for parent, children := range expected {
+		for child := range children {
+			if !found[parent][child] {
+				logger.Warning("Index %v: missing entry %v -> %v",
+					index, parent, child)
+				fixed++
+			}
+		}
+	}
+
+	if fixed == 0 {
+		return 0, nil
+	}
+
+	// Replace the index
+	err = tx.DeleteBucket([]byte(index))
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.CreateBucket([]byte(index))
+	if err != nil {
+		return 0, err
+	}
+	for parent, children := range expected {
+		for child := range children {
+			err := IndexAdd(tx, index, parent, child)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return fixed, nil
+}
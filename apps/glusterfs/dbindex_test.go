@@ -0,0 +1,201 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/tests"
+)
+
+func TestIndexAddDeleteList(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		index := BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES
+
+		// Empty parent
+		list, err := IndexList(tx, index, "c1")
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 0)
+
+		// Add children
+		tests.Assert(t, IndexAdd(tx, index, "c1", "v2") == nil)
+		tests.Assert(t, IndexAdd(tx, index, "c1", "v1") == nil)
+		tests.Assert(t, IndexAdd(tx, index, "c1", "v1") == nil)
+		tests.Assert(t, IndexAdd(tx, index, "c2", "v3") == nil)
+
+		list, err = IndexList(tx, index, "c1")
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 2)
+		tests.Assert(t, list[0] == "v1")
+		tests.Assert(t, list[1] == "v2")
+
+		// Delete
+		tests.Assert(t, IndexDelete(tx, index, "c1", "v1") == nil)
+		tests.Assert(t, IndexDelete(tx, index, "c1", "v2") == nil)
+		tests.Assert(t, IndexDelete(tx, index, "c1", "v2") == nil)
+		tests.Assert(t, IndexDelete(tx, index, "nothere", "v2") == nil)
+		tests.Assert(t, tx.Bucket([]byte(index)).Bucket([]byte("c1")) == nil)
+
+		list, err = IndexList(tx, index, "c2")
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 1)
+		tests.Assert(t, list[0] == "v3")
+
+		// Unknown index
+		_, err = IndexList(tx, "nothere", "c2")
+		tests.Assert(t, err == ErrAccessList)
+
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestIndexUpdatedByEntries(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	v := createSampleVolumeEntry(100)
+	v.Info.Cluster = "cluster1"
+	b := NewBrickEntry(10, 20, 5, "device1", "node1")
+
+	// Save entries
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		err := v.Save(tx)
+		if err != nil {
+			return err
+		}
+		return b.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		list, err := IndexList(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, "cluster1")
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 1)
+		tests.Assert(t, list[0] == v.Info.Id)
+
+		list, err = IndexList(tx, BOLTDB_BUCKET_INDEX_NODE_BRICKS, "node1")
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 1)
+		tests.Assert(t, list[0] == b.Info.Id)
+
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Delete entries
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		err := v.Delete(tx)
+		if err != nil {
+			return err
+		}
+		return b.Delete(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		list, err := IndexList(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, "cluster1")
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 0)
+
+		list, err = IndexList(tx, BOLTDB_BUCKET_INDEX_NODE_BRICKS, "node1")
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 0)
+
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestIndexReconcile(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+
+	v := createSampleVolumeEntry(100)
+	v.Info.Cluster = "cluster1"
+	b := NewBrickEntry(10, 20, 5, "device1", "node1")
+
+	// Save the entries directly, bypassing the index, and
+	// add a stale index entry
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		err := EntrySave(tx, v, v.Info.Id)
+		if err != nil {
+			return err
+		}
+		err = EntrySave(tx, b, b.Info.Id)
+		if err != nil {
+			return err
+		}
+		return IndexAdd(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, "cluster1", "gone")
+	})
+	tests.Assert(t, err == nil)
+
+	// Reconcile should find three problems
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		fixed, err := IndexReconcile(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, fixed == 3, fixed)
+
+		// Nothing left to fix
+		fixed, err = IndexReconcile(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, fixed == 0, fixed)
+
+		list, err := IndexList(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, "cluster1")
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 1)
+		tests.Assert(t, list[0] == v.Info.Id)
+
+		list, err = IndexList(tx, BOLTDB_BUCKET_INDEX_NODE_BRICKS, "node1")
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 1)
+		tests.Assert(t, list[0] == b.Info.Id)
+
+		// Break the index again
+		return IndexDelete(tx, BOLTDB_BUCKET_INDEX_NODE_BRICKS, "node1", b.Info.Id)
+	})
+	tests.Assert(t, err == nil)
+	app.Close()
+
+	// Restarting the app rebuilds the index
+	app = NewTestApp(tmpfile)
+	defer app.Close()
+	err = app.db.View(func(tx *bolt.Tx) error {
+		list, err := IndexList(tx, BOLTDB_BUCKET_INDEX_NODE_BRICKS, "node1")
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 1)
+		tests.Assert(t, list[0] == b.Info.Id)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
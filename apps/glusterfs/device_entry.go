@@ -17,8 +17,6 @@
 package glusterfs
 
 import (
-	"bytes"
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"sort"
@@ -35,11 +33,26 @@ const (
 
 type DeviceEntry struct {
 	Entry
+	Revision
 
 	Info       api.DeviceInfo
 	Bricks     sort.StringSlice
 	NodeId     string
 	ExtentSize uint64
+
+	// Size in KB of the device before the filesystem overhead, of
+	// which the total storage is the usable part.  Zero for the
+	// devices added before it was recorded.
+	RawSize uint64
+
+	// Number of bricks using each shared mount point
+	MountPoints map[string]int
+
+	// Recent bricks placed on the device, oldest first
+	Allocations []DeviceAllocation
+
+	// Time is zero if the device was never scrubbed
+	LastScrub api.ScrubResult
 }
 
 func DeviceList(tx *bolt.Tx) ([]string, error) {
@@ -54,6 +67,7 @@ func DeviceList(tx *bolt.Tx) ([]string, error) {
 func NewDeviceEntry() *DeviceEntry {
 	entry := &DeviceEntry{}
 	entry.Bricks = make(sort.StringSlice, 0)
+	entry.MountPoints = make(map[string]int)
 	entry.SetOnline()
 
 	// Default to 4096KB
@@ -68,6 +82,8 @@ func NewDeviceEntryFromRequest(req *api.DeviceAddRequest) *DeviceEntry {
 	device := NewDeviceEntry()
 	device.Info.Id = utils.GenUUID()
 	device.Info.Name = req.Name
+	device.Info.TrimEnabled = req.TrimEnabled
+	device.Info.MaxBrickSize = req.MaxBrickSize
 	device.NodeId = req.NodeId
 
 	return device
@@ -157,7 +173,7 @@ func (d *DeviceEntry) Delete(tx *bolt.Tx) error {
 		return ErrConflict
 	}
 
-	return EntryDelete(tx, d, d.Info.Id)
+	return EntryTombstone(tx, d, api.TombstoneDevice, d.Info.Id)
 }
 
 func (d *DeviceEntry) removeDeviceFromRing(tx *bolt.Tx,
@@ -251,9 +267,17 @@ func (d *DeviceEntry) NewInfoResponse(tx *bolt.Tx) (*api.DeviceInfoResponse, err
 	info := &api.DeviceInfoResponse{}
 	info.Id = d.Info.Id
 	info.Name = d.Info.Name
+	info.TrimEnabled = d.Info.TrimEnabled
+	info.MaxBrickSize = d.Info.MaxBrickSize
 	info.Storage = d.Info.Storage
+	info.RawSize = d.RawSize
 	info.State = d.State
+	info.Watermark = d.Watermark()
 	info.Bricks = make([]api.BrickInfo, 0)
+	if d.LastScrub.Time != 0 {
+		scrub := d.LastScrub
+		info.LastScrub = &scrub
+	}
 
 	// Add each drive information
 	for _, id := range d.Bricks {
@@ -273,16 +297,11 @@ func (d *DeviceEntry) NewInfoResponse(tx *bolt.Tx) (*api.DeviceInfoResponse, err
 }
 
 func (d *DeviceEntry) Marshal() ([]byte, error) {
-	var buffer bytes.Buffer
-	enc := gob.NewEncoder(&buffer)
-	err := enc.Encode(*d)
-
-	return buffer.Bytes(), err
+	return entryCodec.Encode(*d)
 }
 
 func (d *DeviceEntry) Unmarshal(buffer []byte) error {
-	dec := gob.NewDecoder(bytes.NewReader(buffer))
-	err := dec.Decode(d)
+	err := entryCodec.Decode(buffer, d)
 	if err != nil {
 		return err
 	}
@@ -291,6 +310,9 @@ func (d *DeviceEntry) Unmarshal(buffer []byte) error {
 	if d.Bricks == nil {
 		d.Bricks = make(sort.StringSlice, 0)
 	}
+	if d.MountPoints == nil {
+		d.MountPoints = make(map[string]int)
+	}
 
 	return nil
 }
@@ -311,18 +333,169 @@ func (d *DeviceEntry) StorageSet(amount uint64) {
 	d.Info.Storage.Total = amount
 }
 
-func (d *DeviceEntry) StorageAllocate(amount uint64) {
+// Sets the raw size of the device, and its total and free storage
+// to what is left of it after the filesystem overhead
+func (d *DeviceEntry) StorageSetRaw(amount uint64, overheadPercent float64) {
+	d.RawSize = amount
+	d.StorageSet(d.UsableSize(overheadPercent))
+}
+
+// Returns the size in KB of the device left for bricks once the
+// percent of the raw size given is taken by the filesystem and LVM
+// metadata.  The total storage is the raw size of the devices added
+// before it was recorded.
+func (d *DeviceEntry) UsableSize(overheadPercent float64) uint64 {
+	raw := d.RawSize
+	if raw == 0 {
+		raw = d.Info.Storage.Total
+	}
+	if overheadPercent <= 0 {
+		return raw
+	}
+	if overheadPercent >= 100 {
+		return 0
+	}
+	return raw - uint64(float64(raw)*overheadPercent/100)
+}
+
+func (d *DeviceEntry) StorageAllocate(amount uint64) error {
+	if amount > d.Info.Storage.Free {
+		err := d.storageUnderflow("free", amount)
+		if err != nil {
+			return err
+		}
+		d.Info.Storage.Free = 0
+		d.Info.Storage.Used = d.Info.Storage.Total
+		return nil
+	}
 	d.Info.Storage.Free -= amount
 	d.Info.Storage.Used += amount
+
+	return nil
 }
 
-func (d *DeviceEntry) StorageFree(amount uint64) {
-	d.Info.Storage.Free += amount
+func (d *DeviceEntry) StorageFree(amount uint64) error {
+	if amount > d.Info.Storage.Used {
+		err := d.storageUnderflow("used", amount)
+		if err != nil {
+			return err
+		}
+		d.Info.Storage.Used = 0
+		d.Info.Storage.Free = d.Info.Storage.Total
+		return nil
+	}
 	d.Info.Storage.Used -= amount
+	d.Info.Storage.Free += amount
+
+	return nil
+}
+
+// Returns the space of the brick to the device.  Bricks sharing a
+// mount point only return the space when the last one is removed.
+func (d *DeviceEntry) BrickStorageFree(brick *BrickEntry) error {
+	if mp := brick.Info.MountPoint; d.MountPoints[mp] > 1 {
+		d.MountPoints[mp]--
+		return nil
+	} else if mp != "" {
+		delete(d.MountPoints, mp)
+	}
+
+	return d.StorageFree(brick.TotalSize())
+}
+
+// Returns true if other bricks use the mount point of the brick
+func (d *DeviceEntry) MountPointShared(brick *BrickEntry) bool {
+	return d.MountPoints[brick.Info.MountPoint] > 1
+}
+
+// Reports an accounting bug where more storage is being removed than
+// is available.  In strict mode the device is left untouched and an
+// error is returned, otherwise the caller clamps the value to zero and
+// the other one to the total storage of the device.
+func (d *DeviceEntry) storageUnderflow(field string, amount uint64) error {
+	logger.LogError("Device %v: removing %v KB from %v storage "+
+		"[free:%v used:%v total:%v]",
+		d.Info.Id, amount, field,
+		d.Info.Storage.Free, d.Info.Storage.Used, d.Info.Storage.Total)
+
+	if StrictStorage {
+		return ErrStorageUnderflow
+	}
+
+	return nil
+}
+
+// Checks that neither the free nor the used storage of the device
+// exceed its total, which only happens after an accounting bug
+func (d *DeviceEntry) ValidateStorage() error {
+	storage := d.Info.Storage
+	if storage.Free > storage.Total {
+		return fmt.Errorf("Device %v: free storage %v KB exceeds total %v KB",
+			d.Info.Id, storage.Free, storage.Total)
+	}
+	if storage.Used > storage.Total {
+		return fmt.Errorf("Device %v: used storage %v KB exceeds total %v KB",
+			d.Info.Id, storage.Used, storage.Total)
+	}
+	return nil
+}
+
+// Returns the storage of the device kept free for snapshots
+func (d *DeviceEntry) SnapshotReserve() uint64 {
+	return uint64(float64(d.Info.Storage.Total) * settings().DeviceSnapshotReserve / 100)
+}
+
+// Returns the free storage which can be allocated to bricks, less the
+// snapshot reserve, rounded down to a multiple of the granularity,
+// since bricks are only allocated in whole multiples
+func (d *DeviceEntry) AlignedFree(granularity uint64) uint64 {
+	reserve := d.SnapshotReserve()
+	if d.Info.Storage.Free <= reserve {
+		return 0
+	}
+	free := d.Info.Storage.Free - reserve
+	if granularity == 0 {
+		return free
+	}
+	return free - free%granularity
+}
+
+// Returns the watermark band of the device from its percent of free
+// space.  Devices without storage are reported as ok.
+func (d *DeviceEntry) Watermark() string {
+	if d.Info.Storage.Total == 0 {
+		return api.DeviceWatermarkOk
+	}
+
+	free := float64(d.Info.Storage.Free) * 100 / float64(d.Info.Storage.Total)
+	s := settings()
+	switch {
+	case free <= s.DeviceWatermarkCritical:
+		return api.DeviceWatermarkCritical
+	case free <= s.DeviceWatermarkHigh:
+		return api.DeviceWatermarkHigh
+	case free <= s.DeviceWatermarkLow:
+		return api.DeviceWatermarkLow
+	}
+	return api.DeviceWatermarkOk
+}
+
+func (d *DeviceEntry) AboveHighWatermark() bool {
+	switch d.Watermark() {
+	case api.DeviceWatermarkHigh, api.DeviceWatermarkCritical:
+		return true
+	}
+	return false
+}
+
+// Returns true unless the size of the brick in KB is above the max
+// brick size of the device
+func (d *DeviceEntry) TakesBrickSize(size uint64) bool {
+	return d.Info.MaxBrickSize == 0 || size <= d.Info.MaxBrickSize
 }
 
 func (d *DeviceEntry) StorageCheck(amount uint64) bool {
-	return d.Info.Storage.Free > amount
+	return d.AlignedFree(d.ExtentSize) > amount
 }
 
 func (d *DeviceEntry) SetExtentSize(amount uint64) {
@@ -366,12 +539,41 @@ func (d *DeviceEntry) NewBrickEntry(amount uint64, snapFactor float64) *BrickEnt
 	}
 
 	// Allocate amount from disk
-	d.StorageAllocate(total)
+	if err := d.StorageAllocate(total); err != nil {
+		return nil
+	}
 
 	// Create brick
 	return NewBrickEntry(amount, tpsize, metadataSize, d.Info.Id, d.NodeId)
 }
 
+// Creates a brick in a sub-directory of the mount point of an existing
+// brick on the device.  No storage is allocated since the bricks share
+// the logical volume of the existing brick.  The caller is responsible
+// for adding the brick id to the brick list.
+func (d *DeviceEntry) NewSubDirBrickEntry(brick *BrickEntry, subdir string) *BrickEntry {
+	godbc.Require(brick.Info.DeviceId == d.Info.Id)
+	godbc.Require(brick.Info.MountPoint != "")
+	godbc.Require(subdir != "")
+
+	mp := brick.Info.MountPoint
+	if d.MountPoints[mp] == 0 {
+		// Count the brick which created the mount point
+		d.MountPoints[mp] = 1
+	}
+	d.MountPoints[mp]++
+
+	entry := NewBrickEntry(brick.Info.Size,
+		brick.TpSize,
+		brick.PoolMetadataSize,
+		d.Info.Id,
+		d.NodeId)
+	entry.Info.MountPoint = mp
+	entry.Info.SubDir = subdir
+
+	return entry
+}
+
 // Return poolmetadatasize in KB
 func (d *DeviceEntry) poolMetadataSize(tpsize uint64) uint64 {
 
@@ -0,0 +1,82 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"time"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+// Number of allocations kept on each device.  Older allocations
+// are dropped as new ones are recorded.
+var DeviceAllocationHistoryMax = 32
+
+// A brick placed on the device.  Size is the space taken from the
+// device in KB, and Time is in seconds since the epoch.
+type DeviceAllocation struct {
+	VolumeId string
+	BrickId  string
+	Size     uint64
+	Time     int64
+}
+
+// Records a brick of the volume placed on the device, dropping the
+// oldest allocations beyond DeviceAllocationHistoryMax
+func (d *DeviceEntry) AllocationAdd(volumeId, brickId string, size uint64) {
+	d.Allocations = append(d.Allocations, DeviceAllocation{
+		VolumeId: volumeId,
+		BrickId:  brickId,
+		Size:     size,
+		Time:     time.Now().Unix(),
+	})
+
+	if extra := len(d.Allocations) - DeviceAllocationHistoryMax; extra > 0 {
+		d.Allocations = append([]DeviceAllocation{}, d.Allocations[extra:]...)
+	}
+}
+
+// Drops the allocation of a brick which was rolled back, as when the
+// creation of its volume failed
+func (d *DeviceEntry) AllocationRemove(brickId string) {
+	for i, a := range d.Allocations {
+		if a.BrickId == brickId {
+			d.Allocations = append(d.Allocations[:i:i], d.Allocations[i+1:]...)
+			return
+		}
+	}
+}
+
+// Returns the recent allocations on the device, oldest first
+func (d *DeviceEntry) AllocationHistory() []DeviceAllocation {
+	history := make([]DeviceAllocation, len(d.Allocations))
+	copy(history, d.Allocations)
+	return history
+}
+
+func (d *DeviceEntry) allocationHistoryResponse() []api.DeviceAllocation {
+	history := make([]api.DeviceAllocation, 0, len(d.Allocations))
+	for _, a := range d.Allocations {
+		history = append(history, api.DeviceAllocation{
+			VolumeId: a.VolumeId,
+			BrickId:  a.BrickId,
+			Size:     a.Size,
+			Time:     a.Time,
+		})
+	}
+	return history
+}
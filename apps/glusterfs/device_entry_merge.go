@@ -0,0 +1,188 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/lpabon/godbc"
+)
+
+// Merges the devices of the node with the same path into one, for
+// the databases where a device was added twice.  The device
+// registered for the path survives, or else the first device of the
+// node with the path.  The bricks of the duplicates move to it with
+// the space they use, their shared mount points and their allocation
+// history, and the duplicates are deleted.  The paths of the bricks
+// are kept, as they are where the bricks are mounted.  The total of
+// the survivor is the largest of the duplicates, since they all
+// describe the same disk.  Returns the ids of the devices merged into
+// another.
+//
+// The caller must remove the merged devices from the allocator of a
+// running server.
+func MergeDuplicateDevices(tx *bolt.Tx, nodeId string) ([]string, error) {
+	godbc.Require(tx != nil)
+
+	node, err := NewNodeEntryFromId(tx, nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Devices of each path, in the order of the node
+	names := []string{}
+	byName := make(map[string][]*DeviceEntry)
+	for _, id := range node.Devices {
+		device, err := NewDeviceEntryFromId(tx, id)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if _, ok := byName[device.Info.Name]; !ok {
+			names = append(names, device.Info.Name)
+		}
+		byName[device.Info.Name] = append(byName[device.Info.Name], device)
+	}
+
+	merged := []string{}
+	for _, name := range names {
+		devices := byName[name]
+		if len(devices) < 2 {
+			continue
+		}
+
+		survivor, duplicates, registered := survivingDevice(tx, devices)
+		for _, duplicate := range duplicates {
+			err := survivor.merge(tx, duplicate)
+			if err != nil {
+				return nil, err
+			}
+			err = duplicate.Delete(tx)
+			if err != nil {
+				return nil, err
+			}
+			node.DeviceDelete(duplicate.Info.Id)
+			merged = append(merged, duplicate.Info.Id)
+			logger.Info("Merged device %v into device %v, both %v on node %v",
+				duplicate.Info.Id, survivor.Info.Id, name, nodeId)
+		}
+
+		if !registered {
+			err := survivor.Register(tx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = survivor.Save(tx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(merged) == 0 {
+		return merged, nil
+	}
+	err = node.Save(tx)
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// Returns the device registered for the path of the devices, or the
+// first of them if none is, the other devices, and whether the
+// survivor is registered
+func survivingDevice(tx *bolt.Tx, devices []*DeviceEntry) (*DeviceEntry, []*DeviceEntry, bool) {
+	b := tx.Bucket([]byte(BOLTDB_BUCKET_DEVICE))
+	owner := ""
+	if b != nil {
+		owner = string(b.Get([]byte(devices[0].registerKey())))
+	}
+
+	survivor := 0
+	for i, device := range devices {
+		if device.Info.Id == owner {
+			survivor = i
+			break
+		}
+	}
+
+	duplicates := []*DeviceEntry{}
+	for i, device := range devices {
+		if i != survivor {
+			duplicates = append(duplicates, device)
+		}
+	}
+	return devices[survivor], duplicates, devices[survivor].Info.Id == owner
+}
+
+// Moves the bricks of the duplicate to the device, with their space
+func (d *DeviceEntry) merge(tx *bolt.Tx, duplicate *DeviceEntry) error {
+	for _, id := range duplicate.Bricks {
+		brick, err := NewBrickEntryFromId(tx, id)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if brick != nil {
+			brick.Info.DeviceId = d.Info.Id
+			err = brick.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		if !utils.SortedStringHas(d.Bricks, id) {
+			d.BrickAdd(id)
+		}
+	}
+
+	for mp, count := range duplicate.MountPoints {
+		d.MountPoints[mp] += count
+	}
+
+	d.Allocations = append(d.Allocations, duplicate.Allocations...)
+	sort.Stable(deviceAllocationsByTime(d.Allocations))
+	if extra := len(d.Allocations) - DeviceAllocationHistoryMax; extra > 0 {
+		d.Allocations = append([]DeviceAllocation{}, d.Allocations[extra:]...)
+	}
+
+	if duplicate.Info.Storage.Total > d.Info.Storage.Total {
+		d.Info.Storage.Total = duplicate.Info.Storage.Total
+		d.RawSize = duplicate.RawSize
+	}
+	d.Info.Storage.Used += duplicate.Info.Storage.Used
+	if d.Info.Storage.Total > d.Info.Storage.Used {
+		d.Info.Storage.Free = d.Info.Storage.Total - d.Info.Storage.Used
+	} else {
+		d.Info.Storage.Free = 0
+	}
+
+	// Left empty to be deleted
+	duplicate.Bricks = make(sort.StringSlice, 0)
+	duplicate.MountPoints = make(map[string]int)
+	duplicate.Info.Storage.Used = 0
+
+	return nil
+}
+
+type deviceAllocationsByTime []DeviceAllocation
+
+func (l deviceAllocationsByTime) Len() int           { return len(l) }
+func (l deviceAllocationsByTime) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l deviceAllocationsByTime) Less(i, j int) bool { return l[i].Time < l[j].Time }
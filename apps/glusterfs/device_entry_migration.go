@@ -0,0 +1,112 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/lpabon/godbc"
+)
+
+// Returns the online devices of the online nodes of the cluster of the
+// device which have the space for all of its bricks, the most free
+// first.  Only the space is checked; where each brick can go in its
+// set is checked when it is moved.
+func (d *DeviceEntry) MigrationTargets(tx *bolt.Tx,
+	allocator Allocator) ([]*DeviceEntry, error) {
+
+	godbc.Require(tx != nil)
+	godbc.Require(allocator != nil)
+
+	node, err := NewNodeEntryFromId(tx, d.NodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	var needed, largest uint64
+	for _, id := range d.Bricks {
+		brick, err := NewBrickEntryFromId(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		needed += brick.TotalSize()
+		if brick.TotalSize() > largest {
+			largest = brick.TotalSize()
+		}
+	}
+
+	deviceCh, done, errc := allocator.GetNodes(node.Info.ClusterId, allocationKey())
+	defer close(done)
+
+	targets := make([]*DeviceEntry, 0)
+	seen := map[string]bool{d.Info.Id: true}
+	for id := range deviceCh {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		device, err := NewDeviceEntryFromId(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !device.isOnline() ||
+			!device.StorageCheck(needed) ||
+			!device.TakesBrickSize(largest) {
+			continue
+		}
+
+		deviceNode, err := NewNodeEntryFromId(tx, device.NodeId)
+		if err != nil {
+			return nil, err
+		}
+		if !deviceNode.isOnline() {
+			continue
+		}
+
+		targets = append(targets, device)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+
+	sort.Stable(sort.Reverse(devicesByFree(targets)))
+	return targets, nil
+}
+
+// Returns why the device cannot be deleted, with the devices its
+// bricks can be moved to unless targets is nil
+func deviceHasBricksMessage(d *DeviceEntry, targets []*DeviceEntry) string {
+	msg := fmt.Sprintf("%v: device %v still has bricks %v", ErrConflict,
+		d.Info.Id, strings.Join(d.Bricks, ", "))
+	if targets == nil {
+		return msg
+	} else if len(targets) == 0 {
+		return msg + "; no device has the space to move them to"
+	}
+
+	ids := make([]string, len(targets))
+	for i, target := range targets {
+		ids[i] = fmt.Sprintf("%v (%v GiB free)", target.Info.Id,
+			target.Info.Storage.Free/(1024*1024))
+	}
+	return msg + "; devices with the space to move them to: " +
+		strings.Join(ids, ", ")
+}
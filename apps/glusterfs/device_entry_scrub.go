@@ -0,0 +1,124 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/lpabon/godbc"
+)
+
+// Starts scrubbing for bit rot the volumes with a brick on the device.
+// GlusterFS scrubs whole volumes, so the status of a brick is the
+// status of its volume.  The result is saved as the last scrub of the
+// device.
+func (d *DeviceEntry) Scrub(db *bolt.DB, executor executors.Executor) (*api.ScrubResult, error) {
+	godbc.Require(db != nil)
+
+	var host string
+	var bricks []*BrickEntry
+	var volumes map[string]*VolumeEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, d.NodeId)
+		if err != nil {
+			return err
+		}
+		host = node.ManageHostName()
+
+		volumes, err = brickVolumes(tx, node.Info.ClusterId)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range d.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			bricks = append(bricks, brick)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &api.ScrubResult{
+		Id:     d.Info.Id,
+		Time:   time.Now().Unix(),
+		Bricks: make([]api.BrickScrubStatus, 0, len(bricks)),
+	}
+
+	// Scrub each volume once
+	scrubbed := make(map[string]error)
+	for _, brick := range bricks {
+		status := api.BrickScrubStatus{
+			Id:   brick.Info.Id,
+			Path: brick.Info.Path,
+		}
+
+		volume, ok := volumes[brick.Info.Id]
+		switch {
+		case !ok:
+			status.Status = api.ScrubSkipped
+			status.Error = "Brick does not belong to a volume"
+		case !volume.IsStarted():
+			status.VolumeId = volume.Info.Id
+			status.Status = api.ScrubSkipped
+			status.Error = ErrVolumeStopped.Error()
+		default:
+			status.VolumeId = volume.Info.Id
+			err, done := scrubbed[volume.Info.Id]
+			if !done {
+				logger.Info("Scrubbing volume %v for device %v", volume.Info.Name, d.Info.Id)
+				err = executor.VolumeScrub(host, volume.glusterName())
+				if err != nil {
+					logger.LogError("Unable to scrub volume %v: %v", volume.Info.Name, err)
+				}
+				scrubbed[volume.Info.Id] = err
+			}
+			if err != nil {
+				status.Status = api.ScrubFailed
+				status.Error = err.Error()
+			} else {
+				status.Status = api.ScrubStarted
+			}
+		}
+		result.Bricks = append(result.Bricks, status)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		device, err := NewDeviceEntryFromId(tx, d.Info.Id)
+		if err == ErrNotFound {
+			// Deleted while it was scrubbed
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		device.LastScrub = *result
+		return device.Save(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,332 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/lpabon/godbc"
+)
+
+// A brick to move to another device of its node
+type brickMove struct {
+	volume   *VolumeEntry
+	from, to *DeviceEntry
+	oldBrick *BrickEntry
+	newBrick *BrickEntry
+	created  bool
+	replaced bool
+}
+
+// Moves bricks between two devices of the same node until their free
+// space is as close as the sizes of the bricks allow.  Each brick is
+// replaced in its volume by a new brick on the other device, and
+// self-heal copies the data, so only the bricks of volumes with
+// replicas or redundancy are moved.  Keeping the bricks on the same
+// node keeps each brick of a set on a different node.
+//
+// The devices, the volumes, the bricks and the brick index of the
+// node are all updated in the transaction.  If a brick cannot be
+// moved, the bricks already moved are put back and the new bricks
+// are removed, so the caller only has to rollback the transaction.
+func SwapDeviceContents(tx *bolt.Tx,
+	executor executors.Executor,
+	deviceA, deviceB string,
+	allocator Allocator) error {
+
+	godbc.Require(tx != nil)
+
+	if deviceA == deviceB {
+		return fmt.Errorf("Unable to swap the contents of device %v with itself", deviceA)
+	}
+
+	a, err := NewDeviceEntryFromId(tx, deviceA)
+	if err != nil {
+		return err
+	}
+	b, err := NewDeviceEntryFromId(tx, deviceB)
+	if err != nil {
+		return err
+	}
+	if a.NodeId != b.NodeId {
+		return fmt.Errorf("Devices %v and %v are not on the same node", deviceA, deviceB)
+	}
+	if !a.isOnline() || !b.isOnline() {
+		return fmt.Errorf("Devices %v and %v must be online", deviceA, deviceB)
+	}
+
+	node, err := NewNodeEntryFromId(tx, a.NodeId)
+	if err != nil {
+		return err
+	}
+
+	moves, err := planBrickMoves(tx, node, a, b)
+	if err != nil || len(moves) == 0 {
+		return err
+	}
+
+	// Do not allocate from the devices while their bricks move
+	for _, d := range []*DeviceEntry{a, b} {
+		err := d.removeDeviceFromRing(tx, allocator)
+		if err != nil {
+			return err
+		}
+		defer d.addDeviceToRing(tx, allocator)
+	}
+
+	err = moveBricks(executor, node, moves)
+	if err != nil {
+		return err
+	}
+
+	// Save the new locations
+	for _, move := range moves {
+		move.volume.BrickDelete(move.oldBrick.Info.Id)
+		move.volume.BrickAdd(move.newBrick.Info.Id)
+		move.from.BrickDelete(move.oldBrick.Info.Id)
+		move.to.BrickAdd(move.newBrick.Info.Id)
+		move.to.AllocationAdd(move.volume.Info.Id, move.newBrick.Info.Id,
+			move.newBrick.TotalSize())
+
+		err := move.oldBrick.Delete(tx)
+		if err != nil {
+			return err
+		}
+		err = move.newBrick.Save(tx)
+		if err != nil {
+			return err
+		}
+		err = move.volume.Save(tx)
+		if err != nil {
+			return err
+		}
+	}
+	err = a.Save(tx)
+	if err != nil {
+		return err
+	}
+	return b.Save(tx)
+}
+
+// Chooses the bricks to move, largest first, and updates the storage
+// of the devices as if they had moved.  A brick is only moved if it
+// brings the free space of the devices closer together.
+func planBrickMoves(tx *bolt.Tx, node *NodeEntry,
+	a, b *DeviceEntry) ([]*brickMove, error) {
+
+	volumes, err := brickVolumes(tx, node.Info.ClusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	moves := make([]*brickMove, 0)
+	moved := make(map[string]bool)
+	for {
+		from, to := a, b
+		if from.Info.Storage.Free > to.Info.Storage.Free {
+			from, to = b, a
+		}
+		diff := to.Info.Storage.Free - from.Info.Storage.Free
+
+		var best *BrickEntry
+		for _, id := range from.Bricks {
+			if moved[id] {
+				continue
+			}
+			brick, err := NewBrickEntryFromId(tx, id)
+			if err != nil {
+				return nil, err
+			}
+			size := brick.TotalSize()
+			if size >= diff || !to.StorageCheck(size) ||
+				from.MountPointShared(brick) ||
+				!isBrickMovable(volumes[id]) {
+				continue
+			}
+			if best == nil || size > best.TotalSize() {
+				best = brick
+			}
+		}
+		if best == nil {
+			return moves, nil
+		}
+
+		err := from.BrickStorageFree(best)
+		if err != nil {
+			return nil, err
+		}
+		err = to.StorageAllocate(best.TotalSize())
+		if err != nil {
+			return nil, err
+		}
+
+		newBrick := NewBrickEntry(best.Info.Size,
+			best.TpSize,
+			best.PoolMetadataSize,
+			to.Info.Id,
+			to.NodeId)
+		moved[best.Info.Id] = true
+
+		moves = append(moves, &brickMove{
+			volume:   volumes[best.Info.Id],
+			from:     from,
+			to:       to,
+			oldBrick: best,
+			newBrick: newBrick,
+		})
+	}
+}
+
+// Returns the volume of each brick in the cluster
+func brickVolumes(tx *bolt.Tx, clusterId string) (map[string]*VolumeEntry, error) {
+	cluster, err := NewClusterEntryFromId(tx, clusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := make(map[string]*VolumeEntry)
+	for _, id := range cluster.Info.Volumes {
+		volume, err := NewVolumeEntryFromId(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, brickId := range volume.Bricks {
+			volumes[brickId] = volume
+		}
+	}
+
+	return volumes, nil
+}
+
+// The data of a replaced brick can only be healed from other bricks
+func isBrickMovable(volume *VolumeEntry) bool {
+	if volume == nil {
+		return false
+	}
+
+	switch volume.Info.Durability.Type {
+	case api.DurabilityReplicate, api.DurabilityEC:
+		return true
+	}
+	return false
+}
+
+// Creates the new bricks, replaces the old bricks in their volumes and
+// destroys the old bricks.  On failure the volumes are restored and the
+// new bricks destroyed.
+func moveBricks(executor executors.Executor, node *NodeEntry,
+	moves []*brickMove) (e error) {
+
+	host := node.ManageHostName()
+	brickInfo := func(b *BrickEntry) *executors.BrickInfo {
+		return &executors.BrickInfo{
+			Host: node.StorageHostName(),
+			Path: b.Info.Path,
+		}
+	}
+
+	defer func() {
+		if e == nil {
+			return
+		}
+		for _, move := range moves {
+			if move.replaced {
+				err := executor.VolumeReplaceBrick(host, move.volume.glusterName(),
+					brickInfo(move.newBrick), brickInfo(move.oldBrick))
+				if err != nil {
+					logger.Err(err)
+				}
+			}
+			if move.created {
+				err := executor.BrickDestroy(host, brickRequest(move.newBrick, move.to))
+				if err != nil {
+					logger.Err(err)
+				}
+			}
+		}
+	}()
+
+	for _, move := range moves {
+		logger.Info("Moving brick %v of volume %v from device %v to device %v",
+			move.oldBrick.Info.Id, move.volume.Info.Id,
+			move.from.Info.Id, move.to.Info.Id)
+
+		info, err := executor.BrickCreate(host, brickRequest(move.newBrick, move.to))
+		if err != nil {
+			return err
+		}
+		move.created = true
+		move.newBrick.Info.Path = info.Path
+		if info.MountPoint != "" {
+			move.newBrick.Info.MountPoint = info.MountPoint
+			move.newBrick.Info.SubDir = strings.TrimPrefix(info.Path, info.MountPoint+"/")
+		}
+
+		err = executor.VolumeReplaceBrick(host, move.volume.glusterName(),
+			brickInfo(move.oldBrick), brickInfo(move.newBrick))
+		if err != nil {
+			return err
+		}
+		move.replaced = true
+	}
+
+	// Let the new bricks heal before the old ones are gone
+	healed := map[string]bool{}
+	for _, move := range moves {
+		if healed[move.volume.Info.Id] {
+			continue
+		}
+		healed[move.volume.Info.Id] = true
+		err := move.volume.waitForHeal(executor, host)
+		if err != nil {
+			logger.Warning("Unable to wait for volume %v to heal: %v",
+				move.volume.Info.Id, err)
+		}
+	}
+
+	// The volumes no longer use the old bricks
+	for _, move := range moves {
+		err := executor.BrickDestroy(host, brickRequest(move.oldBrick, move.from))
+		if err != nil {
+			logger.LogError("Unable to destroy brick %v after moving it: %v",
+				move.oldBrick.Info.Id, err)
+		}
+	}
+
+	return nil
+}
+
+func brickRequest(b *BrickEntry, d *DeviceEntry) *executors.BrickRequest {
+	req := &executors.BrickRequest{}
+	req.Name = b.Info.Id
+	req.Size = b.Info.Size
+	req.TpSize = b.TpSize
+	req.VgId = b.Info.DeviceId
+	req.PoolMetadataSize = b.PoolMetadataSize
+	req.MountPoint = b.Info.MountPoint
+	req.SubDir = b.Info.SubDir
+	req.Discard = d.Info.TrimEnabled
+
+	// Bricks sharing a mount point are never moved
+	req.Last = true
+
+	return req
+}
@@ -17,17 +17,25 @@
 package glusterfs
 
 import (
+	"errors"
 	"os"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/heketi/heketi/pkg/utils"
 	"github.com/heketi/tests"
 )
 
+// Fail on any storage accounting bug found by the unit tests
+func init() {
+	StrictStorage = true
+}
+
 func createSampleDeviceEntry(nodeid string, disksize uint64) *DeviceEntry {
 
 	req := &api.DeviceAddRequest{}
@@ -58,11 +66,15 @@ func TestNewDeviceEntryFromRequest(t *testing.T) {
 	req := &api.DeviceAddRequest{}
 	req.NodeId = "123"
 	req.Name = "/dev/" + utils.GenUUID()
+	req.MaxBrickSize = 100 * GB
 
 	d := NewDeviceEntryFromRequest(req)
 	tests.Assert(t, d != nil)
 	tests.Assert(t, d.Info.Id != "")
 	tests.Assert(t, d.Info.Name == req.Name)
+	tests.Assert(t, d.Info.MaxBrickSize == 100*GB)
+	tests.Assert(t, d.TakesBrickSize(100*GB))
+	tests.Assert(t, !d.TakesBrickSize(100*GB+1))
 	tests.Assert(t, d.Info.Storage.Free == 0)
 	tests.Assert(t, d.Info.Storage.Total == 0)
 	tests.Assert(t, d.Info.Storage.Used == 0)
@@ -96,6 +108,89 @@ func TestNewDeviceEntryMarshal(t *testing.T) {
 
 }
 
+func TestDeviceEntryAllocationHistory(t *testing.T) {
+	defer tests.Patch(&DeviceAllocationHistoryMax, 3).Restore()
+
+	d := createSampleDeviceEntry("abc", 1*TB)
+	tests.Assert(t, len(d.AllocationHistory()) == 0)
+
+	// Only the latest allocations are kept, oldest first
+	for i, id := range []string{"v1", "v2", "v3", "v4", "v5"} {
+		d.AllocationAdd(id, "b"+id, uint64(i+1)*GB)
+	}
+	history := d.AllocationHistory()
+	tests.Assert(t, len(history) == 3, history)
+	tests.Assert(t, history[0].VolumeId == "v3")
+	tests.Assert(t, history[0].Size == 3*GB)
+	tests.Assert(t, history[2].VolumeId == "v5")
+	tests.Assert(t, history[2].Time > 0)
+
+	// The history returned is a copy
+	history[0].VolumeId = "changed"
+	tests.Assert(t, d.AllocationHistory()[0].VolumeId == "v3")
+
+	// And is saved with the device
+	buffer, err := d.Marshal()
+	tests.Assert(t, err == nil)
+	um := &DeviceEntry{}
+	err = um.Unmarshal(buffer)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, reflect.DeepEqual(um.AllocationHistory(), d.AllocationHistory()))
+
+	// Rolled back allocations are dropped
+	d.AllocationRemove("bv4")
+	d.AllocationRemove("unknown")
+	history = d.AllocationHistory()
+	tests.Assert(t, len(history) == 2, history)
+	tests.Assert(t, history[0].VolumeId == "v3")
+	tests.Assert(t, history[1].VolumeId == "v5")
+}
+
+func TestDeviceEntryAllocationHistoryVolumeCreate(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(10)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+
+	// Each brick is recorded on its device
+	err = app.db.View(func(tx *bolt.Tx) error {
+		bricks := make(map[string]int)
+		for _, id := range v.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			bricks[brick.Info.DeviceId]++
+
+			device, err := NewDeviceEntryFromId(tx, brick.Info.DeviceId)
+			tests.Assert(t, err == nil)
+			for _, a := range device.AllocationHistory() {
+				tests.Assert(t, a.VolumeId == v.Info.Id)
+				tests.Assert(t, a.Size == brick.TotalSize())
+			}
+		}
+		for id, n := range bricks {
+			device, err := NewDeviceEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, len(device.AllocationHistory()) == n)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
 func TestDeviceEntryNewBrickEntry(t *testing.T) {
 	req := &api.DeviceAddRequest{}
 	req.NodeId = "abc"
@@ -538,6 +633,296 @@ func TestDeviceEntryStorage(t *testing.T) {
 	tests.Assert(t, d.Info.Storage.Used == 0)
 }
 
+func TestDeviceEntryAlignedFree(t *testing.T) {
+	d := NewDeviceEntry()
+	d.StorageSet(10*GB + 4*MB + 5)
+
+	tests.Assert(t, d.AlignedFree(0) == 10*GB+4*MB+5)
+	tests.Assert(t, d.AlignedFree(1) == 10*GB+4*MB+5)
+	tests.Assert(t, d.AlignedFree(4096) == 10*GB+4*MB)
+	tests.Assert(t, d.AlignedFree(MB) == 10*GB+4*MB)
+	tests.Assert(t, d.AlignedFree(GB) == 10*GB)
+	tests.Assert(t, d.AlignedFree(3*GB) == 9*GB)
+
+	// Granularity larger than free
+	tests.Assert(t, d.AlignedFree(20*GB) == 0)
+
+	// Free is not changed
+	tests.Assert(t, d.Info.Storage.Free == 10*GB+4*MB+5)
+
+	// Allocation only considers whole extents
+	d.ExtentSize = 4096
+	tests.Assert(t, d.StorageCheck(10*GB+4*MB-1))
+	tests.Assert(t, !d.StorageCheck(10*GB+4*MB))
+}
+
+func TestDeviceEntrySnapshotReserve(t *testing.T) {
+	d := NewDeviceEntry()
+	d.StorageSet(100 * GB)
+	tests.Assert(t, d.SnapshotReserve() == 0)
+	tests.Assert(t, d.AlignedFree(GB) == 100*GB)
+
+	defer tests.Patch(&DeviceSnapshotReserve, float64(20)).Restore()
+	tests.Assert(t, d.SnapshotReserve() == 20*GB)
+	tests.Assert(t, d.AlignedFree(0) == 80*GB)
+	tests.Assert(t, d.AlignedFree(3*GB) == 78*GB)
+
+	// The reserve is only taken from free storage
+	d.StorageAllocate(70 * GB)
+	tests.Assert(t, d.AlignedFree(GB) == 10*GB)
+	d.StorageAllocate(15 * GB)
+	tests.Assert(t, d.AlignedFree(GB) == 0)
+
+	d.ExtentSize = 4096
+	tests.Assert(t, !d.StorageCheck(GB))
+}
+
+func TestDeviceEntryUsableSize(t *testing.T) {
+	d := NewDeviceEntry()
+	d.StorageSetRaw(100*GB, 0)
+	tests.Assert(t, d.RawSize == 100*GB)
+	tests.Assert(t, d.Info.Storage.Total == 100*GB)
+	tests.Assert(t, d.Info.Storage.Free == 100*GB)
+
+	for _, c := range []struct {
+		overhead float64
+		usable   uint64
+	}{
+		{-1, 100 * GB},
+		{0, 100 * GB},
+		{0.5, 100*GB - 512*MB},
+		{3, 97 * GB},
+		{12.5, 87*GB + 512*MB},
+		{50, 50 * GB},
+		{100, 0},
+		{150, 0},
+	} {
+		usable := d.UsableSize(c.overhead)
+		tests.Assert(t, usable == c.usable, c.overhead, usable, c.usable)
+	}
+
+	// Total and free are the usable part of the raw size
+	d.StorageSetRaw(100*GB, 5)
+	tests.Assert(t, d.RawSize == 100*GB)
+	tests.Assert(t, d.Info.Storage.Total == 95*GB)
+	tests.Assert(t, d.Info.Storage.Free == 95*GB)
+	tests.Assert(t, d.Info.Storage.Used == 0)
+
+	// Devices added before the raw size was recorded use their total
+	d = NewDeviceEntry()
+	d.StorageSet(10 * GB)
+	tests.Assert(t, d.UsableSize(10) == 9*GB)
+
+	// The raw size is saved
+	d.StorageSetRaw(10*GB, 10)
+	buffer, err := d.Marshal()
+	tests.Assert(t, err == nil)
+	um := &DeviceEntry{}
+	err = um.Unmarshal(buffer)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, um.RawSize == 10*GB)
+	tests.Assert(t, um.Info.Storage.Total == 9*GB)
+}
+
+func TestDeviceSnapshotReserveAllocation(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		3,      // nodes_per_cluster
+		1,      // devices_per_node,
+		100*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	defer tests.Patch(&DeviceSnapshotReserve, float64(20)).Restore()
+
+	// A replica on every device
+	req := &api.VolumeCreateRequest{}
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 3
+
+	// Would fit without the reserve
+	req.Size = 85
+	v := NewVolumeEntryFromRequest(req)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == ErrNoSpace, err)
+
+	req.Size = 70
+	v = NewVolumeEntryFromRequest(req)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+
+	// Every device kept its reserve
+	err = app.db.View(func(tx *bolt.Tx) error {
+		devices, err := DeviceList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(devices) == 3)
+		for _, id := range devices {
+			device, err := NewDeviceEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, len(device.Bricks) != 0)
+			tests.Assert(t, device.Info.Storage.Free >= device.SnapshotReserve(),
+				device.Info.Storage.Free, device.SnapshotReserve())
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestDeviceEntryWatermark(t *testing.T) {
+	d := NewDeviceEntry()
+
+	// No storage
+	tests.Assert(t, d.Watermark() == api.DeviceWatermarkOk)
+
+	d.StorageSet(100 * GB)
+	for _, test := range []struct {
+		free      uint64
+		watermark string
+		deferred  bool
+	}{
+		{100 * GB, api.DeviceWatermarkOk, false},
+		{31 * GB, api.DeviceWatermarkOk, false},
+		{30 * GB, api.DeviceWatermarkLow, false},
+		{16 * GB, api.DeviceWatermarkLow, false},
+		{15 * GB, api.DeviceWatermarkHigh, true},
+		{6 * GB, api.DeviceWatermarkHigh, true},
+		{5 * GB, api.DeviceWatermarkCritical, true},
+		{0, api.DeviceWatermarkCritical, true},
+	} {
+		d.Info.Storage.Free = test.free
+		d.Info.Storage.Used = 100*GB - test.free
+		tests.Assert(t, d.Watermark() == test.watermark, test.free, d.Watermark())
+		tests.Assert(t, d.AboveHighWatermark() == test.deferred, test.free)
+	}
+
+	// Configured watermarks
+	defer tests.Patch(&DeviceWatermarkLow, float64(50)).Restore()
+	defer tests.Patch(&DeviceWatermarkHigh, float64(40)).Restore()
+	defer tests.Patch(&DeviceWatermarkCritical, float64(20)).Restore()
+	d.Info.Storage.Free = 45 * GB
+	tests.Assert(t, d.Watermark() == api.DeviceWatermarkLow)
+	d.Info.Storage.Free = 30 * GB
+	tests.Assert(t, d.Watermark() == api.DeviceWatermarkHigh)
+	d.Info.Storage.Free = 10 * GB
+	tests.Assert(t, d.Watermark() == api.DeviceWatermarkCritical)
+}
+
+func TestDeviceEntryStorageUnderflowStrict(t *testing.T) {
+	d := NewDeviceEntry()
+	d.StorageSet(1000)
+
+	tests.Assert(t, d.StorageAllocate(600) == nil)
+
+	// Allocate more than is free
+	err := d.StorageAllocate(600)
+	tests.Assert(t, err == ErrStorageUnderflow)
+	tests.Assert(t, d.Info.Storage.Free == 400)
+	tests.Assert(t, d.Info.Storage.Used == 600)
+
+	// Free more than is used
+	err = d.StorageFree(700)
+	tests.Assert(t, err == ErrStorageUnderflow)
+	tests.Assert(t, d.Info.Storage.Free == 400)
+	tests.Assert(t, d.Info.Storage.Used == 600)
+}
+
+func TestDeviceEntryValidateStorage(t *testing.T) {
+	d := NewDeviceEntry()
+	d.Info.Id = "abc"
+	d.StorageSet(1000)
+	tests.Assert(t, d.ValidateStorage() == nil)
+
+	tests.Assert(t, d.StorageAllocate(1000) == nil)
+	tests.Assert(t, d.ValidateStorage() == nil)
+
+	// Free exceeds total
+	d.Info.Storage.Used = 0
+	d.Info.Storage.Free = 1001
+	err := d.ValidateStorage()
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "free storage 1001 KB exceeds total 1000 KB"), err)
+	tests.Assert(t, strings.Contains(err.Error(), "abc"), err)
+
+	// Used exceeds total
+	d.Info.Storage.Free = 0
+	d.Info.Storage.Used = 1001
+	err = d.ValidateStorage()
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "used storage 1001 KB exceeds total 1000 KB"), err)
+}
+
+func TestDeviceEntryStorageUnderflowLenient(t *testing.T) {
+	defer func(s bool) { StrictStorage = s }(StrictStorage)
+	StrictStorage = false
+
+	d := NewDeviceEntry()
+	d.StorageSet(1000)
+
+	tests.Assert(t, d.StorageAllocate(600) == nil)
+
+	// Allocate more than is free
+	err := d.StorageAllocate(600)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, d.Info.Storage.Free == 0)
+	tests.Assert(t, d.Info.Storage.Used == 1000)
+	tests.Assert(t, d.ValidateStorage() == nil)
+
+	// Free more than is used
+	err = d.StorageFree(1300)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, d.Info.Storage.Free == 1000)
+	tests.Assert(t, d.Info.Storage.Used == 0)
+	tests.Assert(t, d.ValidateStorage() == nil)
+}
+
+func TestDeviceEntrySubDirBricks(t *testing.T) {
+	d := NewDeviceEntry()
+	d.Info.Id = "device"
+	d.NodeId = "node"
+	d.StorageSet(10 * GB)
+
+	b := d.NewBrickEntry(1*GB, 1.0)
+	tests.Assert(t, b != nil)
+	b.Info.MountPoint = "/mnt/brick_" + b.Info.Id
+	used := d.Info.Storage.Used
+	tests.Assert(t, used == b.TotalSize())
+
+	// Bricks sharing the mount point do not use more space
+	s1 := d.NewSubDirBrickEntry(b, "s1")
+	s2 := d.NewSubDirBrickEntry(b, "s2")
+	tests.Assert(t, s1.Info.MountPoint == b.Info.MountPoint)
+	tests.Assert(t, s1.Info.SubDir == "s1")
+	tests.Assert(t, s1.TotalSize() == b.TotalSize())
+	tests.Assert(t, s1.Info.Id != s2.Info.Id)
+	tests.Assert(t, d.Info.Storage.Used == used)
+	tests.Assert(t, d.MountPoints[b.Info.MountPoint] == 3)
+	tests.Assert(t, d.MountPointShared(b))
+
+	// Space is only returned with the last brick
+	tests.Assert(t, d.BrickStorageFree(b) == nil)
+	tests.Assert(t, d.Info.Storage.Used == used)
+	tests.Assert(t, d.BrickStorageFree(s1) == nil)
+	tests.Assert(t, d.Info.Storage.Used == used)
+	tests.Assert(t, !d.MountPointShared(s2))
+
+	tests.Assert(t, d.BrickStorageFree(s2) == nil)
+	tests.Assert(t, d.Info.Storage.Used == 0)
+	tests.Assert(t, d.Info.Storage.Free == 10*GB)
+	tests.Assert(t, len(d.MountPoints) == 0)
+
+	// Bricks without a mount point return their space
+	b = d.NewBrickEntry(1*GB, 1.0)
+	tests.Assert(t, d.BrickStorageFree(b) == nil)
+	tests.Assert(t, d.Info.Storage.Used == 0)
+}
+
 func TestDeviceSetStateFailed(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -692,3 +1077,415 @@ func TestDeviceSetStateOfflineOnline(t *testing.T) {
 
 	})
 }
+
+// Returns the two devices of the first node with all the bricks of
+// the node on the first device
+func setupUnbalancedDevices(t *testing.T, app *App) (string, string) {
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var deviceA, deviceB string
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		tests.Assert(t, err == nil)
+		node, err := NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		tests.Assert(t, err == nil)
+
+		deviceA, deviceB = node.Devices[0], node.Devices[1]
+		device, err := NewDeviceEntryFromId(tx, deviceB)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, device.SetState(tx, app.allocator, api.EntryStateOffline) == nil)
+		return device.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	for _, size := range []int{100, 200, 50} {
+		v := createSampleVolumeEntry(size)
+		err = v.Create(app.db, app.executor, app.allocator)
+		tests.Assert(t, err == nil, err)
+	}
+
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		device, err := NewDeviceEntryFromId(tx, deviceB)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, device.SetState(tx, app.allocator, api.EntryStateOnline) == nil)
+		return device.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	return deviceA, deviceB
+}
+
+func TestSwapDeviceContents(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	deviceA, deviceB := setupUnbalancedDevices(t, app)
+	allocator := NewMockAllocator(app.db)
+
+	replaced := 0
+	app.xo.MockVolumeReplaceBrick = func(host, volume string,
+		oldBrick, newBrick *executors.BrickInfo) error {
+		tests.Assert(t, oldBrick.Host == newBrick.Host)
+		replaced++
+		return nil
+	}
+
+	var before, used uint64
+	bricks := 0
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		a, err := NewDeviceEntryFromId(tx, deviceA)
+		tests.Assert(t, err == nil)
+		b, err := NewDeviceEntryFromId(tx, deviceB)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(b.Bricks) == 0)
+		before = b.Info.Storage.Free - a.Info.Storage.Free
+		used = a.Info.Storage.Used
+		bricks = len(a.Bricks)
+
+		return SwapDeviceContents(tx, app.executor, deviceA, deviceB, allocator)
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, replaced > 0)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		a, err := NewDeviceEntryFromId(tx, deviceA)
+		tests.Assert(t, err == nil)
+		b, err := NewDeviceEntryFromId(tx, deviceB)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(b.Bricks) == replaced)
+		tests.Assert(t, len(a.Bricks) == bricks-replaced)
+		tests.Assert(t, a.Info.Storage.Used+b.Info.Storage.Used == used)
+
+		// No brick of the fuller device would bring them closer
+		fuller, emptier := a, b
+		if a.Info.Storage.Free > b.Info.Storage.Free {
+			fuller, emptier = b, a
+		}
+		diff := emptier.Info.Storage.Free - fuller.Info.Storage.Free
+		tests.Assert(t, diff < before)
+		for _, id := range fuller.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, brick.TotalSize() >= diff, diff, brick.TotalSize())
+		}
+
+		// The volumes use the new bricks
+		for _, id := range b.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, brick.Info.DeviceId == deviceB)
+
+			volumes, err := VolumeList(tx)
+			tests.Assert(t, err == nil)
+			found := 0
+			for _, volumeId := range volumes {
+				v, err := NewVolumeEntryFromId(tx, volumeId)
+				tests.Assert(t, err == nil)
+				if utils.SortedStringHas(v.Bricks, id) {
+					found++
+				}
+			}
+			tests.Assert(t, found == 1)
+		}
+
+		// The devices are back in the allocator
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		devices := allocator.clustermap[clusters[0]]
+		tests.Assert(t, utils.SortedStringHas(devices, deviceA))
+		tests.Assert(t, utils.SortedStringHas(devices, deviceB))
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Balanced devices are left alone
+	replaced = 0
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return SwapDeviceContents(tx, app.executor, deviceA, deviceB, allocator)
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, replaced == 0)
+
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return SwapDeviceContents(tx, app.executor, deviceA, deviceA, allocator)
+	})
+	tests.Assert(t, err != nil)
+}
+
+func TestSwapDeviceContentsRollback(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	deviceA, deviceB := setupUnbalancedDevices(t, app)
+	allocator := NewMockAllocator(app.db)
+
+	// The second brick fails to move
+	var restored []string
+	replaced := 0
+	app.xo.MockVolumeReplaceBrick = func(host, volume string,
+		oldBrick, newBrick *executors.BrickInfo) error {
+		replaced++
+		if replaced == 2 {
+			return errors.New("replace failed")
+		}
+		if replaced > 2 {
+			restored = append(restored, volume)
+		}
+		return nil
+	}
+	destroyed := 0
+	app.xo.MockBrickDestroy = func(host string, brick *executors.BrickRequest) error {
+		tests.Assert(t, brick.VgId == deviceB)
+		destroyed++
+		return nil
+	}
+
+	var a, b *DeviceEntry
+	err := app.db.View(func(tx *bolt.Tx) error {
+		var err error
+		a, err = NewDeviceEntryFromId(tx, deviceA)
+		tests.Assert(t, err == nil)
+		b, err = NewDeviceEntryFromId(tx, deviceB)
+		tests.Assert(t, err == nil)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return SwapDeviceContents(tx, app.executor, deviceA, deviceB, allocator)
+	})
+	tests.Assert(t, err != nil)
+
+	// The first brick was put back and both new bricks removed
+	tests.Assert(t, len(restored) == 1, restored)
+	tests.Assert(t, destroyed == 2, destroyed)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		after, err := NewDeviceEntryFromId(tx, deviceA)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, reflect.DeepEqual(after.Bricks, a.Bricks))
+		tests.Assert(t, after.Info.Storage == a.Info.Storage)
+
+		after, err = NewDeviceEntryFromId(tx, deviceB)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(after.Bricks) == 0)
+		tests.Assert(t, after.Info.Storage == b.Info.Storage)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestMergeDuplicateDevices(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	for _, size := range []int{100, 200, 50, 300} {
+		v := createSampleVolumeEntry(size)
+		err = v.Create(app.db, app.executor, app.allocator)
+		tests.Assert(t, err == nil, err)
+	}
+
+	// The second device of a node was added again with the path of
+	// the first
+	var (
+		nodeId           string
+		deviceA, deviceB string
+		used             uint64
+		bricks           []string
+	)
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		tests.Assert(t, err == nil)
+		node, err := NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		tests.Assert(t, err == nil)
+		nodeId = node.Info.Id
+		deviceA, deviceB = node.Devices[0], node.Devices[1]
+
+		a, err := NewDeviceEntryFromId(tx, deviceA)
+		tests.Assert(t, err == nil)
+		b, err := NewDeviceEntryFromId(tx, deviceB)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(a.Bricks) != 0 && len(b.Bricks) != 0)
+		used = a.Info.Storage.Used + b.Info.Storage.Used
+		bricks = append(append(bricks, a.Bricks...), b.Bricks...)
+		sort.Strings(bricks)
+
+		tests.Assert(t, b.Deregister(tx) == nil)
+		b.Info.Name = a.Info.Name
+		return b.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	var merged []string
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		merged, err = MergeDuplicateDevices(tx, nodeId)
+		return err
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, reflect.DeepEqual(merged, []string{deviceB}), merged)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		_, err := NewDeviceEntryFromId(tx, deviceB)
+		tests.Assert(t, err == ErrNotFound)
+
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, reflect.DeepEqual([]string(node.Devices), []string{deviceA}),
+			node.Devices)
+
+		// The survivor has the bricks and the space of both
+		a, err := NewDeviceEntryFromId(tx, deviceA)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, reflect.DeepEqual([]string(a.Bricks), bricks), a.Bricks, bricks)
+		tests.Assert(t, a.Info.Storage.Used == used)
+		tests.Assert(t, a.Info.Storage.Total == 1*TB)
+		tests.Assert(t, a.Info.Storage.Free == a.Info.Storage.Total-used)
+		for _, id := range bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, brick.Info.DeviceId == deviceA)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Nothing left to merge
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		merged, err = MergeDuplicateDevices(tx, nodeId)
+		return err
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(merged) == 0)
+}
+
+func TestDeviceEntryMigrationTargets(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,     // clusters
+		3,     // nodes_per_cluster
+		2,     // devices_per_node,
+		50*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(20)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+
+	// A device with bricks and the others
+	var (
+		source  *DeviceEntry
+		devices []*DeviceEntry
+	)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		for _, id := range EntryKeys(tx, BOLTDB_BUCKET_DEVICE) {
+			device, err := NewDeviceEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			if source == nil && len(device.Bricks) != 0 {
+				source = device
+			} else {
+				devices = append(devices, device)
+			}
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, source != nil)
+	tests.Assert(t, len(devices) == 5)
+
+	targets := func() []*DeviceEntry {
+		var targets []*DeviceEntry
+		err := app.db.View(func(tx *bolt.Tx) error {
+			device, err := NewDeviceEntryFromId(tx, source.Info.Id)
+			tests.Assert(t, err == nil)
+			targets, err = device.MigrationTargets(tx, app.allocator)
+			return err
+		})
+		tests.Assert(t, err == nil, err)
+		return targets
+	}
+	update := func(id string, update func(d *DeviceEntry)) {
+		err := app.db.Update(func(tx *bolt.Tx) error {
+			device, err := NewDeviceEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			update(device)
+			return device.Save(tx)
+		})
+		tests.Assert(t, err == nil)
+	}
+
+	// All the others, the most free first
+	list := targets()
+	tests.Assert(t, len(list) == 5, list)
+	for i, target := range list {
+		tests.Assert(t, target.Info.Id != source.Info.Id)
+		if i > 0 {
+			tests.Assert(t, list[i-1].Info.Storage.Free >= target.Info.Storage.Free)
+		}
+	}
+
+	// Not the devices which are offline or lack the space
+	update(devices[0].Info.Id, func(d *DeviceEntry) {
+		d.State = api.EntryStateOffline
+	})
+	update(devices[1].Info.Id, func(d *DeviceEntry) {
+		d.Info.Storage.Free = 1 * GB
+	})
+	list = targets()
+	tests.Assert(t, len(list) == 3, list)
+	for _, target := range list {
+		tests.Assert(t, target.Info.Id != devices[0].Info.Id)
+		tests.Assert(t, target.Info.Id != devices[1].Info.Id)
+	}
+
+	// None available
+	for _, device := range devices[2:] {
+		update(device.Info.Id, func(d *DeviceEntry) {
+			d.State = api.EntryStateOffline
+		})
+	}
+	tests.Assert(t, len(targets()) == 0)
+	msg := deviceHasBricksMessage(source, targets())
+	tests.Assert(t, strings.Contains(msg, "no device has the space"), msg)
+
+	// Unless they could not be found
+	msg = deviceHasBricksMessage(source, nil)
+	tests.Assert(t, strings.Contains(msg, source.Bricks[0]), msg)
+	tests.Assert(t, !strings.Contains(msg, "space"), msg)
+}
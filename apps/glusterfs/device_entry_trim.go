@@ -0,0 +1,118 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"path"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/lpabon/godbc"
+)
+
+// Run fstrim on the file systems of the bricks on the device so that
+// the unused blocks are returned to the disk.  Returns the amount of
+// space trimmed in KB.
+func (d *DeviceEntry) Trim(db *bolt.DB, executor executors.Executor) (uint64, error) {
+	godbc.Require(db != nil)
+
+	var host string
+	mountpoints := utils.NewStringSet()
+	err := db.View(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, d.NodeId)
+		if err != nil {
+			return err
+		}
+		host = node.ManageHostName()
+
+		for _, id := range d.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+
+			// Bricks created before mount points were saved are
+			// always in a directory at the root of their mount point
+			if brick.Info.MountPoint != "" {
+				mountpoints.Add(brick.Info.MountPoint)
+			} else {
+				mountpoints.Add(path.Dir(brick.Info.Path))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Info("Trimming %v mount points on device %v", mountpoints.Len(), d.Info.Id)
+	return executor.DeviceTrim(host, mountpoints.Strings())
+}
+
+// Trim all the devices which have trim enabled
+func TrimDevices(db *bolt.DB, executor executors.Executor) {
+	var devices []*DeviceEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		list, err := DeviceList(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range list {
+			device, err := NewDeviceEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			if device.Info.TrimEnabled && len(device.Bricks) > 0 {
+				devices = append(devices, device)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Err(err)
+		return
+	}
+
+	for _, device := range devices {
+		trimmed, err := device.Trim(db, executor)
+		if err != nil {
+			logger.LogError("Unable to trim device %v: %v", device.Info.Id, err)
+			continue
+		}
+		logger.Info("Trimmed %v KB on device %v", trimmed, device.Info.Id)
+	}
+}
+
+// Trim the devices every interval until stop is closed
+func trimDevicesEvery(db *bolt.DB, executor executors.Executor,
+	interval time.Duration, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			TrimDevices(db, executor)
+		case <-stop:
+			return
+		}
+	}
+}
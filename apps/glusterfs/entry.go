@@ -17,6 +17,9 @@
 package glusterfs
 
 import (
+	"encoding/hex"
+
+	"github.com/boltdb/bolt"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
 )
 
@@ -31,3 +34,29 @@ func (e *Entry) isOnline() bool {
 func (e *Entry) SetOnline() {
 	e.State = api.EntryStateOnline
 }
+
+// Ids requested by clients must look like the ones from utils.GenUUID()
+func isValidEntryId(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == 16
+}
+
+// Incremented every time the entry is saved, so that a save can detect
+// that another operation saved the entry after it was loaded
+type Revision struct {
+	Generation uint64
+
+	// Transaction which saved the next generation of the entry.  The
+	// entry only moves to that generation once it commits, so that it
+	// can still be saved after the transaction is rolled back.
+	savedIn *bolt.Tx
+}
+
+func (r *Revision) revision() *Revision {
+	return r
+}
+
+type revisionedEntry interface {
+	DbEntry
+	revision() *Revision
+}
@@ -22,12 +22,21 @@ import (
 )
 
 var (
-	ErrNoSpace          = errors.New("No space")
-	ErrNotFound         = errors.New("Id not found")
-	ErrConflict         = errors.New(http.StatusText(http.StatusConflict))
-	ErrMaxBricks        = errors.New("Maximum number of bricks reached.")
-	ErrMininumBrickSize = errors.New("Minimum brick size limit reached.  Out of space.")
-	ErrDbAccess         = errors.New("Unable to access db")
-	ErrAccessList       = errors.New("Unable to access list")
-	ErrKeyExists        = errors.New("Key already exists in the database")
+	ErrNoSpace           = errors.New("No space")
+	ErrNotFound          = errors.New("Id not found")
+	ErrConflict          = errors.New(http.StatusText(http.StatusConflict))
+	ErrMaxBricks         = errors.New("Maximum number of bricks reached.")
+	ErrMininumBrickSize  = errors.New("Minimum brick size limit reached.  Out of space.")
+	ErrDbAccess          = errors.New("Unable to access db")
+	ErrAccessList        = errors.New("Unable to access list")
+	ErrKeyExists         = errors.New("Key already exists in the database")
+	ErrStorageUnderflow  = errors.New("Storage accounting underflow")
+	ErrStaleEntry        = errors.New("Entry was changed by another operation")
+	ErrCHAPNotConfigured = errors.New("CHAP authentication requires chap_secret_key to be configured")
+	ErrInvalidId         = errors.New("Invalid id")
+	ErrVolumeStopped     = errors.New("Volume is stopped")
+	ErrCancelled         = errors.New("Operation was cancelled")
+	ErrNameInUse         = errors.New("Name already in use in the cluster")
+
+	ErrShrinkBelowMinimum = errors.New("Shrinking would leave the volume without space")
 )
@@ -16,9 +16,94 @@
 
 package glusterfs
 
+import (
+	"sync"
+	"time"
+)
+
 var (
 	// Default limits
 	BrickMinSize = uint64(4 * GB)
 	BrickMaxSize = uint64(4 * TB)
 	BrickMaxNum  = 100
+
+	// Storage class used for nodes and volumes which do not specify one
+	DefaultStorageClass = ""
+
+	// When set, storage accounting underflows on a device are returned
+	// as errors instead of being clamped to the bounds of the device.
+	// Enabled in unit tests.
+	StrictStorage = false
+
+	// Percent of free space on a device at or below which the device
+	// is in the low, high or critical watermark band.  The allocator
+	// uses devices above the high watermark last.
+	DeviceWatermarkLow      = float64(30)
+	DeviceWatermarkHigh     = float64(15)
+	DeviceWatermarkCritical = float64(5)
+
+	// Percent of the storage of each device kept free for the
+	// snapshots of the volumes.  Bricks are not allocated in it.
+	DeviceSnapshotReserve = float64(0)
+
+	// Percent of the raw size of each device taken by the filesystem
+	// and LVM metadata, which is not usable by bricks.  Applied when
+	// devices are added.
+	DeviceFilesystemOverhead = float64(0)
 )
+
+// Guards the settings which reloading the configuration changes while
+// requests and background tasks use them
+var runtimeSettingsLock sync.RWMutex
+
+// Settings which can be changed while heketi runs
+type runtimeSettings struct {
+	BrickMinSize             uint64
+	BrickMaxSize             uint64
+	BrickMaxNum              int
+	ArbiterBrickSize         uint64
+	DefaultStorageClass      string
+	DbRetries                int
+	DeviceWatermarkLow       float64
+	DeviceWatermarkHigh      float64
+	DeviceWatermarkCritical  float64
+	DeviceSnapshotReserve    float64
+	DeviceFilesystemOverhead float64
+	AuditLogRetentionDays    int
+	WebhookRetries           int
+	BackupsKept              int
+	TombstoneRetentionDays   int
+	AllocatorLatencyWeight   float64
+	ShutdownDrainTimeout     time.Duration
+	AsyncOperationRetention  time.Duration
+	IdempotencyKeyRetention  time.Duration
+}
+
+// Returns the current runtime settings.  They must be read through
+// it, since the configuration can be reloaded at any time.
+func settings() runtimeSettings {
+	runtimeSettingsLock.RLock()
+	defer runtimeSettingsLock.RUnlock()
+
+	return runtimeSettings{
+		BrickMinSize:             BrickMinSize,
+		BrickMaxSize:             BrickMaxSize,
+		BrickMaxNum:              BrickMaxNum,
+		ArbiterBrickSize:         ArbiterBrickSize,
+		DefaultStorageClass:      DefaultStorageClass,
+		DbRetries:                DbRetries,
+		DeviceWatermarkLow:       DeviceWatermarkLow,
+		DeviceWatermarkHigh:      DeviceWatermarkHigh,
+		DeviceWatermarkCritical:  DeviceWatermarkCritical,
+		DeviceSnapshotReserve:    DeviceSnapshotReserve,
+		DeviceFilesystemOverhead: DeviceFilesystemOverhead,
+		AuditLogRetentionDays:    AuditLogRetentionDays,
+		WebhookRetries:           WebhookRetries,
+		BackupsKept:              BackupsKept,
+		TombstoneRetentionDays:   TombstoneRetentionDays,
+		AllocatorLatencyWeight:   AllocatorLatencyWeight,
+		ShutdownDrainTimeout:     ShutdownDrainTimeout,
+		AsyncOperationRetention:  AsyncOperationRetention,
+		IdempotencyKeyRetention:  IdempotencyKeyRetention,
+	}
+}
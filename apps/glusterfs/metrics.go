@@ -0,0 +1,312 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Labels are limited to route names, operation names, cluster ids,
+// hostnames and device names, so the number of series does not grow
+// with the number of volumes or requests.
+var (
+	metricsRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "heketi",
+		Name:      "http_requests_total",
+		Help:      "Requests by route and status code.",
+	}, []string{"route", "code"})
+
+	metricsRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "heketi",
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to answer the requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+
+	metricsAsyncPending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "heketi",
+		Name:      "async_operations_pending",
+		Help:      "Asynchronous operations which have not completed.",
+	})
+
+	metricsAsyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "heketi",
+		Name:      "async_operation_duration_seconds",
+		Help:      "Time to complete the asynchronous operations by route.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+	}, []string{"operation"})
+
+	metricsOperationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "heketi",
+		Name:      "operation_failures_total",
+		Help:      "Asynchronous operations which failed by route.",
+	}, []string{"operation"})
+
+	metricsPanics = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "heketi",
+		Name:      "panics_total",
+		Help:      "Panics recovered in requests and asynchronous operations by route.",
+	}, []string{"route"})
+
+	metricsStorage = newMetricsCollector()
+)
+
+func init() {
+	prometheus.MustRegister(metricsRequests)
+	prometheus.MustRegister(metricsRequestDuration)
+	prometheus.MustRegister(metricsAsyncPending)
+	prometheus.MustRegister(metricsAsyncDuration)
+	prometheus.MustRegister(metricsOperationFailures)
+	prometheus.MustRegister(metricsPanics)
+	prometheus.MustRegister(metricsStorage)
+}
+
+// Keeps the status code written by a handler
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (m *metricsResponseWriter) WriteHeader(status int) {
+	if m.status == 0 {
+		m.status = status
+	}
+	m.ResponseWriter.WriteHeader(status)
+}
+
+func (m *metricsResponseWriter) Write(b []byte) (int, error) {
+	if m.status == 0 {
+		m.status = http.StatusOK
+	}
+	return m.ResponseWriter.Write(b)
+}
+
+// Counts the requests of the route and their duration
+func metricsHandler(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mw := &metricsResponseWriter{ResponseWriter: w}
+		start := time.Now()
+		handler(mw, r)
+
+		if mw.status == 0 {
+			mw.status = http.StatusOK
+		}
+		metricsRequests.WithLabelValues(route, strconv.Itoa(mw.status)).Inc()
+		metricsRequestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Storage of a cluster, node or device in bytes
+type metricsCapacity struct {
+	size, free, used *prometheus.Desc
+}
+
+func newMetricsCapacity(entry string, labels ...string) *metricsCapacity {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName("heketi", entry, name),
+			help, labels, nil)
+	}
+	return &metricsCapacity{
+		size: desc("size_bytes", "Storage of the "+entry+"."),
+		free: desc("free_bytes", "Storage of the "+entry+" available for bricks."),
+		used: desc("used_bytes", "Storage of the "+entry+" used by bricks."),
+	}
+}
+
+func (m *metricsCapacity) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.size
+	ch <- m.free
+	ch <- m.used
+}
+
+func (m *metricsCapacity) collect(ch chan<- prometheus.Metric,
+	storage api.StorageSize, labels ...string) {
+
+	// Sizes are stored in KB
+	for desc, value := range map[*prometheus.Desc]uint64{
+		m.size: storage.Total,
+		m.free: storage.Free,
+		m.used: storage.Used,
+	} {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue,
+			float64(value*1024), labels...)
+	}
+}
+
+// Reads the capacity of the clusters, nodes and devices from the db
+// of the app when scraped, along with the statistics of the db.  The
+// registry is global, so the last app opened is reported.
+type metricsCollector struct {
+	lock sync.Mutex
+	db   *bolt.DB
+
+	clusters        *metricsCapacity
+	nodes           *metricsCapacity
+	devices         *metricsCapacity
+	clusterVolumes  *prometheus.Desc
+	dbSize          *prometheus.Desc
+	dbTransactions  *prometheus.Desc
+	dbOpenReadTx    *prometheus.Desc
+	dbFreePages     *prometheus.Desc
+	dbPendingPages  *prometheus.Desc
+	dbWrites        *prometheus.Desc
+	dbWriteDuration *prometheus.Desc
+}
+
+func newMetricsCollector() *metricsCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc("heketi_"+name, help, labels, nil)
+	}
+	return &metricsCollector{
+		clusters: newMetricsCapacity("cluster", "cluster"),
+		nodes:    newMetricsCapacity("node", "cluster", "hostname"),
+		devices:  newMetricsCapacity("device", "cluster", "hostname", "device"),
+		clusterVolumes: desc("cluster_volumes",
+			"Volumes of the cluster.", "cluster"),
+		dbSize: desc("db_size_bytes",
+			"Size of the database."),
+		dbTransactions: desc("db_read_transactions_total",
+			"Read transactions started on the database."),
+		dbOpenReadTx: desc("db_open_read_transactions",
+			"Read transactions open on the database."),
+		dbFreePages: desc("db_free_pages",
+			"Pages on the free list of the database."),
+		dbPendingPages: desc("db_pending_pages",
+			"Pages freed by transactions still open on the database."),
+		dbWrites: desc("db_writes_total",
+			"Writes of pages to the database."),
+		dbWriteDuration: desc("db_write_seconds_total",
+			"Time spent writing pages to the database."),
+	}
+}
+
+func (m *metricsCollector) setDb(db *bolt.DB) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.db = db
+}
+
+// Stops reporting db unless another app was opened since
+func (m *metricsCollector) unsetDb(db *bolt.DB) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.db == db {
+		m.db = nil
+	}
+}
+
+func (m *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	m.clusters.describe(ch)
+	m.nodes.describe(ch)
+	m.devices.describe(ch)
+	ch <- m.clusterVolumes
+	ch <- m.dbSize
+	ch <- m.dbTransactions
+	ch <- m.dbOpenReadTx
+	ch <- m.dbFreePages
+	ch <- m.dbPendingPages
+	ch <- m.dbWrites
+	ch <- m.dbWriteDuration
+}
+
+func (m *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	gauge := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+	counter := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value)
+	}
+
+	// Closing the app waits for the scrape
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.db == nil {
+		return
+	}
+
+	// Stats of the db before the transaction below
+	stats := m.db.Stats()
+	counter(m.dbTransactions, float64(stats.TxN))
+	gauge(m.dbOpenReadTx, float64(stats.OpenTxN))
+	gauge(m.dbFreePages, float64(stats.FreePageN))
+	gauge(m.dbPendingPages, float64(stats.PendingPageN))
+	counter(m.dbWrites, float64(stats.TxStats.Write))
+	counter(m.dbWriteDuration, stats.TxStats.WriteTime.Seconds())
+
+	err := m.db.View(func(tx *bolt.Tx) error {
+		gauge(m.dbSize, float64(tx.Size()))
+
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		for _, clusterId := range clusters {
+			cluster, err := NewClusterEntryFromId(tx, clusterId)
+			if err != nil {
+				return err
+			}
+			ch <- prometheus.MustNewConstMetric(m.clusterVolumes,
+				prometheus.GaugeValue, float64(len(cluster.Info.Volumes)), clusterId)
+
+			var clusterStorage api.StorageSize
+			for _, nodeId := range cluster.Info.Nodes {
+				node, err := NewNodeEntryFromId(tx, nodeId)
+				if err != nil {
+					return err
+				}
+				hostname := node.ManageHostName()
+
+				var nodeStorage api.StorageSize
+				for _, deviceId := range node.Devices {
+					device, err := NewDeviceEntryFromId(tx, deviceId)
+					if err != nil {
+						return err
+					}
+					m.devices.collect(ch, device.Info.Storage,
+						clusterId, hostname, device.Info.Name)
+
+					nodeStorage.Total += device.Info.Storage.Total
+					nodeStorage.Free += device.Info.Storage.Free
+					nodeStorage.Used += device.Info.Storage.Used
+				}
+				m.nodes.collect(ch, nodeStorage, clusterId, hostname)
+
+				clusterStorage.Total += nodeStorage.Total
+				clusterStorage.Free += nodeStorage.Free
+				clusterStorage.Used += nodeStorage.Used
+			}
+			m.clusters.collect(ch, clusterStorage, clusterId)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.LogError("Unable to collect metrics: %v", err)
+	}
+}
+
+// Prometheus metrics in the text format
+func (a *App) Metrics(w http.ResponseWriter, r *http.Request) {
+	prometheus.Handler().ServeHTTP(w, r)
+}
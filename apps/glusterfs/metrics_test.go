@@ -0,0 +1,106 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/tests"
+)
+
+func TestMetrics(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		2,      // nodes_per_cluster
+		1,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Create a volume through the async manager
+	c := client.NewClientNoAuth(ts.URL)
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	volume, err := c.VolumeCreate(req)
+	tests.Assert(t, err == nil, err)
+
+	var (
+		cluster *ClusterEntry
+		node    *NodeEntry
+	)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		var err error
+		cluster, err = NewClusterEntryFromId(tx, volume.Cluster)
+		if err != nil {
+			return err
+		}
+		node, err = NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		return err
+	})
+	tests.Assert(t, err == nil)
+
+	r, err := http.Get(ts.URL + "/metrics")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, r.StatusCode == http.StatusOK)
+	body, err := ioutil.ReadAll(r.Body)
+	tests.Assert(t, err == nil)
+	r.Body.Close()
+	metrics := string(body)
+
+	for _, line := range []string{
+		`heketi_http_requests_total{code="202",route="VolumeCreate"}`,
+		`heketi_http_request_duration_seconds_count{route="VolumeCreate"}`,
+		`heketi_async_operation_duration_seconds_count{operation="VolumeCreate"}`,
+		`heketi_async_operations_pending 0`,
+		fmt.Sprintf(`heketi_cluster_size_bytes{cluster="%v"} %v`,
+			cluster.Info.Id, float64(2*500*GB*1024)),
+		fmt.Sprintf(`heketi_cluster_volumes{cluster="%v"} 1`, cluster.Info.Id),
+		fmt.Sprintf(`heketi_node_size_bytes{cluster="%v",hostname="%v"} %v`,
+			cluster.Info.Id, node.ManageHostName(), float64(500*GB*1024)),
+		`heketi_db_size_bytes`,
+	} {
+		tests.Assert(t, strings.Contains(metrics, line), line, metrics)
+	}
+
+	// Series are not kept per volume
+	tests.Assert(t, !strings.Contains(metrics, volume.Id))
+}
@@ -17,11 +17,11 @@
 package glusterfs
 
 import (
-	"bytes"
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/boltdb/bolt"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
@@ -31,9 +31,16 @@ import (
 
 type NodeEntry struct {
 	Entry
+	Revision
 
 	Info    api.NodeInfo
 	Devices sort.StringSlice
+
+	// Memory and CPUs of the node when last checked
+	LastMetrics NodeMetrics
+
+	// Manage hostname which was reachable when last connected to
+	LastManageHost string
 }
 
 func NewNodeEntry() *NodeEntry {
@@ -52,6 +59,13 @@ func NewNodeEntryFromRequest(req *api.NodeAddRequest) *NodeEntry {
 	node.Info.ClusterId = req.ClusterId
 	node.Info.Hostnames = req.Hostnames
 	node.Info.Zone = req.Zone
+	node.Info.StorageClass = req.StorageClass
+	if node.Info.StorageClass == "" {
+		node.Info.StorageClass = settings().DefaultStorageClass
+	}
+	node.Info.Owner = req.Owner
+	node.Info.Tags = req.Tags
+	node.Info.AffinityGroup = req.AffinityGroup
 
 	return node
 }
@@ -68,6 +82,172 @@ func NewNodeEntryFromId(tx *bolt.Tx, id string) (*NodeEntry, error) {
 	return entry, nil
 }
 
+// Returns the cluster the node is added to.  Unless create is set, the
+// cluster must exist.  Otherwise a cluster with the id of the node is
+// created if it does not exist, or a new cluster if the node has no
+// cluster id.  Returns whether the cluster was created.
+func (n *NodeEntry) FindOrCreateCluster(tx *bolt.Tx, create bool) (*ClusterEntry, bool, error) {
+	godbc.Require(tx != nil)
+
+	if n.Info.ClusterId != "" {
+		cluster, err := NewClusterEntryFromId(tx, n.Info.ClusterId)
+		if err != ErrNotFound {
+			return cluster, false, err
+		}
+	}
+	if !create {
+		return nil, false, ErrNotFound
+	}
+
+	cluster := NewClusterEntryFromRequest()
+	if n.Info.ClusterId != "" {
+		if !isValidEntryId(n.Info.ClusterId) {
+			return nil, false, ErrInvalidId
+		}
+		cluster.Info.Id = n.Info.ClusterId
+	}
+	err := cluster.Save(tx)
+	if err != nil {
+		return nil, false, err
+	}
+	n.Info.ClusterId = cluster.Info.Id
+
+	logger.Info("Created cluster %v for node %v", cluster.Info.Id, n.Info.Id)
+	return cluster, true, nil
+}
+
+// Adds the node to its cluster and saves both in the same transaction.
+// The cluster is read again in tx, so that the nodes added to it
+// concurrently are not lost from its list of nodes.
+func (n *NodeEntry) SaveToCluster(tx *bolt.Tx) (*ClusterEntry, error) {
+	godbc.Require(tx != nil)
+
+	cluster, err := NewClusterEntryFromId(tx, n.Info.ClusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster.NodeAdd(n.Info.Id)
+	err = cluster.Save(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = n.Save(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return cluster, updateClusterMountHosts(tx, cluster)
+}
+
+// Returns the bytes stored in the db for the node, without decoding them
+func DumpRawNode(tx *bolt.Tx, id string) ([]byte, error) {
+	godbc.Require(tx != nil)
+
+	return EntryLoadRaw(tx, &NodeEntry{}, id)
+}
+
+// Returns the online node in the cluster with the most free storage
+// on its online devices.  Returns ErrNotFound if the cluster has no
+// online nodes.
+func LeastLoadedNode(tx *bolt.Tx, clusterId string) (*NodeEntry, error) {
+	godbc.Require(tx != nil)
+
+	cluster, err := NewClusterEntryFromId(tx, clusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		leastLoaded *NodeEntry
+		mostFree    uint64
+	)
+	for _, nodeId := range cluster.Info.Nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return nil, err
+		}
+
+		if !node.isOnline() {
+			continue
+		}
+
+		free := uint64(0)
+		for _, deviceId := range node.Devices {
+			device, err := NewDeviceEntryFromId(tx, deviceId)
+			if err != nil {
+				return nil, err
+			}
+			if device.isOnline() {
+				free += device.Info.Storage.Free
+			}
+		}
+
+		if leastLoaded == nil || free > mostFree {
+			leastLoaded = node
+			mostFree = free
+		}
+	}
+
+	if leastLoaded == nil {
+		return nil, ErrNotFound
+	}
+
+	return leastLoaded, nil
+}
+
+// Sets the tags on all the nodes for which filter returns true.
+// Existing tags with the same names are replaced.  Returns the number
+// of nodes updated.
+func BulkTagNodes(tx *bolt.Tx,
+	filter func(*NodeEntry) bool,
+	tags map[string]string) (int, error) {
+
+	godbc.Require(tx != nil)
+	godbc.Require(filter != nil)
+
+	clusters, err := ClusterList(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, clusterId := range clusters {
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, nodeId := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			if err != nil {
+				return 0, err
+			}
+			if !filter(node) {
+				continue
+			}
+
+			node.SetTags(tags)
+			err = node.Save(tx)
+			if err != nil {
+				return 0, err
+			}
+			updated++
+		}
+	}
+
+	return updated, nil
+}
+
+func isValidStorageClass(class string) bool {
+	switch class {
+	case "", api.StorageClassHdd, api.StorageClassSsd, api.StorageClassNvme:
+		return true
+	}
+	return false
+}
+
 func (n *NodeEntry) registerManageKey(host string) string {
 	return "MANAGE" + host
 }
@@ -76,6 +256,12 @@ func (n *NodeEntry) registerStorageKey(host string) string {
 	return "STORAGE" + host
 }
 
+// Returns true for the keys of the hostnames registered in the
+// bucket of the nodes
+func isNodeRegisterKey(key string) bool {
+	return strings.HasPrefix(key, "MANAGE") || strings.HasPrefix(key, "STORAGE")
+}
+
 func (n *NodeEntry) Register(tx *bolt.Tx) error {
 
 	// Save manage hostnames
@@ -133,7 +319,12 @@ func (n *NodeEntry) Save(tx *bolt.Tx) error {
 	godbc.Require(tx != nil)
 	godbc.Require(len(n.Info.Id) > 0)
 
-	return EntrySave(tx, n, n.Info.Id)
+	err := EntrySave(tx, n, n.Info.Id)
+	if err != nil {
+		return fmt.Errorf("Unable to save node %v: %w", n.Info.Id, err)
+	}
+
+	return nil
 
 }
 
@@ -141,6 +332,12 @@ func (n *NodeEntry) ManageHostName() string {
 	godbc.Require(n.Info.Hostnames.Manage != nil)
 	godbc.Require(len(n.Info.Hostnames.Manage) > 0)
 
+	// Keep using the hostname which last worked
+	for _, h := range n.Info.Hostnames.Manage {
+		if h == n.LastManageHost {
+			return h
+		}
+	}
 	return n.Info.Hostnames.Manage[0]
 }
 
@@ -151,6 +348,55 @@ func (n *NodeEntry) StorageHostName() string {
 	return n.Info.Hostnames.Storage[0]
 }
 
+// Returns the storage class of the node.  Nodes added before
+// storage classes were supported belong to the default class.
+func (n *NodeEntry) StorageClass() string {
+	if n.Info.StorageClass == "" {
+		return settings().DefaultStorageClass
+	}
+	return n.Info.StorageClass
+}
+
+// Returns the anti-affinity group of the node.  A node without a
+// group is in a group of its own, which no group name can match.
+func (n *NodeEntry) AffinityGroup() string {
+	if n.Info.AffinityGroup == "" {
+		return "\x00" + n.Info.Id
+	}
+	return n.Info.AffinityGroup
+}
+
+func (n *NodeEntry) SetTags(tags map[string]string) {
+	if n.Info.Tags == nil {
+		n.Info.Tags = make(map[string]string)
+	}
+	for name, value := range tags {
+		n.Info.Tags[name] = value
+	}
+}
+
+// Returns the tags of the node with its zone, management hostname and
+// cluster, as Kubernetes labels.  The labels set from the node replace
+// the tags of the same names.
+func (n *NodeEntry) Labels() map[string]string {
+	labels := make(map[string]string, len(n.Info.Tags)+3)
+	for name, value := range n.Info.Tags {
+		labels[name] = value
+	}
+	labels[api.NodeLabelZone] = strconv.Itoa(n.Info.Zone)
+	labels[api.NodeLabelCluster] = n.Info.ClusterId
+	if len(n.Info.Hostnames.Manage) != 0 {
+		labels[api.NodeLabelHostname] = n.Info.Hostnames.Manage[0]
+	}
+	return labels
+}
+
+// Returns true if bricks of volumes owned by the tenant may be placed
+// on the node.  Nodes without an owner are available to all tenants.
+func (n *NodeEntry) AllowsOwner(owner string) bool {
+	return n.Info.Owner == "" || n.Info.Owner == owner
+}
+
 func (n *NodeEntry) IsDeleteOk() bool {
 	// Check if the nodes still has drives
 	if len(n.Devices) > 0 {
@@ -159,16 +405,84 @@ func (n *NodeEntry) IsDeleteOk() bool {
 	return true
 }
 
-func (n *NodeEntry) Delete(tx *bolt.Tx) error {
+// Returns the ids of the bricks on the devices of the node
+func (n *NodeEntry) BrickIds(tx *bolt.Tx) ([]string, error) {
+	return IndexList(tx, BOLTDB_BUCKET_INDEX_NODE_BRICKS, n.Info.Id)
+}
+
+// Returns an error if the node cannot be deleted by force because
+// its devices still have bricks
+func (n *NodeEntry) checkForceDelete(tx *bolt.Tx) error {
+	bricks, err := n.BrickIds(tx)
+	if err != nil {
+		return err
+	}
+	if len(bricks) != 0 {
+		return fmt.Errorf("Unable to delete node %v because its devices contain %v bricks: %w",
+			n.Info.Id, len(bricks), ErrConflict)
+	}
+	return nil
+}
+
+// Deletes the devices of the node, and the references to the devices
+// which are no longer in the db
+func (n *NodeEntry) forceDeleteDevices(tx *bolt.Tx) error {
+	err := n.checkForceDelete(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range append([]string{}, n.Devices...) {
+		device, err := NewDeviceEntryFromId(tx, id)
+		if err == ErrNotFound {
+			logger.Warning("Removing reference to missing device %v of node %v", id, n.Info.Id)
+			n.DeviceDelete(id)
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		logger.Warning("Deleting device %v of node %v", id, n.Info.Id)
+		err = device.Delete(tx)
+		if err != nil {
+			return err
+		}
+		err = device.Deregister(tx)
+		if err != nil {
+			return err
+		}
+		n.DeviceDelete(id)
+	}
+
+	return n.Save(tx)
+}
+
+// Deletes the node, which must have no devices.  Forcing it deletes
+// the devices of the node first, as long as they have no bricks, for
+// when they are already gone from the node.
+func (n *NodeEntry) Delete(tx *bolt.Tx, force bool) error {
 	godbc.Require(tx != nil)
 
 	// Check if the nodes still has drives
 	if !n.IsDeleteOk() {
-		logger.Warning("Unable to delete node [%v] because it contains devices", n.Info.Id)
-		return ErrConflict
+		if !force {
+			return fmt.Errorf("Unable to delete node %v because it contains devices: %w",
+				n.Info.Id, ErrConflict)
+		}
+
+		logger.Warning("Forcing the delete of node %v with devices %v", n.Info.Id, n.Devices)
+		err := n.forceDeleteDevices(tx)
+		if err != nil {
+			return fmt.Errorf("Unable to delete node %v: %w", n.Info.Id, err)
+		}
+	}
+
+	err := EntryTombstone(tx, n, api.TombstoneNode, n.Info.Id)
+	if err != nil {
+		return fmt.Errorf("Unable to delete node %v: %w", n.Info.Id, err)
 	}
 
-	return EntryDelete(tx, n, n.Info.Id)
+	return nil
 }
 
 func (n *NodeEntry) removeAllDisksFromRing(tx *bolt.Tx,
@@ -283,19 +597,36 @@ func (n *NodeEntry) NewInfoReponse(tx *bolt.Tx) (*api.NodeInfoResponse, error) {
 	info.Hostnames = n.Info.Hostnames
 	info.Id = n.Info.Id
 	info.Zone = n.Info.Zone
+	info.StorageClass = n.Info.StorageClass
+	info.Owner = n.Info.Owner
+	info.StorageLatencyMs = n.Info.StorageLatencyMs
+	info.PreferredForNewBricks = n.Info.PreferredForNewBricks
+	info.GlusterVersion = n.Info.GlusterVersion
+	info.KernelVersion = n.Info.KernelVersion
+	info.MaxConcurrentBrickOps = n.MaxConcurrentBrickOps()
+	info.Tags = n.Info.Tags
+	info.Labels = n.Labels()
 	info.State = n.State
 	info.DevicesInfo = make([]api.DeviceInfoResponse, 0)
 
+	bricks, err := n.BrickIds(tx)
+	if err != nil {
+		return nil, err
+	}
+	info.BrickCount = len(bricks)
+
 	// Add each drive information
 	for _, deviceid := range n.Devices {
 		device, err := NewDeviceEntryFromId(tx, deviceid)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Unable to load device %v of node %v: %w",
+				deviceid, n.Info.Id, err)
 		}
 
 		driveinfo, err := device.NewInfoResponse(tx)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Unable to get information of device %v of node %v: %w",
+				deviceid, n.Info.Id, err)
 		}
 		info.DevicesInfo = append(info.DevicesInfo, *driveinfo)
 	}
@@ -303,19 +634,33 @@ func (n *NodeEntry) NewInfoReponse(tx *bolt.Tx) (*api.NodeInfoResponse, error) {
 	return info, nil
 }
 
-func (n *NodeEntry) Marshal() ([]byte, error) {
-	var buffer bytes.Buffer
-	enc := gob.NewEncoder(&buffer)
-	err := enc.Encode(*n)
+// Checks the storage accounting of every device on the node
+func (n *NodeEntry) ValidateStorage(tx *bolt.Tx) error {
+	godbc.Require(tx != nil)
+
+	for _, deviceid := range n.Devices {
+		device, err := NewDeviceEntryFromId(tx, deviceid)
+		if err != nil {
+			return err
+		}
+
+		err = device.ValidateStorage()
+		if err != nil {
+			return fmt.Errorf("Node %v: %w", n.Info.Id, err)
+		}
+	}
 
-	return buffer.Bytes(), err
+	return nil
+}
+
+func (n *NodeEntry) Marshal() ([]byte, error) {
+	return entryCodec.Encode(*n)
 }
 
 func (n *NodeEntry) Unmarshal(buffer []byte) error {
-	dec := gob.NewDecoder(bytes.NewReader(buffer))
-	err := dec.Decode(n)
+	err := entryCodec.Decode(buffer, n)
 	if err != nil {
-		return err
+		return fmt.Errorf("Unable to decode node: %w", err)
 	}
 
 	// Make sure to setup arrays if nil
@@ -0,0 +1,67 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/lpabon/godbc"
+)
+
+// Tries each manage hostname of the node in order until one of them
+// reaches glusterd.  The hostname which succeeded is saved with the
+// node, so that ManageHostName returns it from now on.
+func connectWithFailover(db *bolt.DB, executor executors.Executor, node *NodeEntry) (string, error) {
+	godbc.Require(db != nil)
+	godbc.Require(len(node.Info.Hostnames.Manage) > 0)
+
+	var failures []string
+	for _, host := range node.Info.Hostnames.Manage {
+		err := executor.GlusterdCheck(host)
+		if err != nil {
+			logger.Warning("Unable to reach node %v at %v: %v", node.Info.Id, host, err)
+			failures = append(failures, err.Error())
+			continue
+		}
+
+		if host != node.LastManageHost {
+			err = db.Update(func(tx *bolt.Tx) error {
+				entry, err := NewNodeEntryFromId(tx, node.Info.Id)
+				if err != nil {
+					return err
+				}
+				entry.LastManageHost = host
+				return entry.Save(tx)
+			})
+			if err != nil {
+				logger.LogError("Unable to record %v as the manage hostname of node %v: %v",
+					host, node.Info.Id, err)
+			}
+			if len(failures) > 0 {
+				logger.Info("Node %v failed over to manage hostname %v", node.Info.Id, host)
+			}
+			node.LastManageHost = host
+		}
+		return host, nil
+	}
+
+	return "", fmt.Errorf("No manage hostname of node %v is reachable: %v",
+		node.Info.Id, strings.Join(failures, "; "))
+}
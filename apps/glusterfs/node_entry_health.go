@@ -0,0 +1,174 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+)
+
+// Measure the storage latency of the online nodes and save it so
+// that the allocator can prefer the devices of faster nodes.  The
+// memory and CPUs of the nodes are saved too.  A node is reached at
+// the first of its manage hostnames which responds.
+func NodeHealthCheck(db *bolt.DB, executor executors.Executor, allocator Allocator) {
+	devices := make(map[string][]string)
+	online := make(map[string]*NodeEntry)
+	err := db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, clusterId := range clusters {
+			cluster, err := NewClusterEntryFromId(tx, clusterId)
+			if err != nil {
+				return err
+			}
+
+			for _, id := range cluster.Info.Nodes {
+				node, err := NewNodeEntryFromId(tx, id)
+				if err != nil {
+					return err
+				}
+				if !node.isOnline() {
+					continue
+				}
+				online[id] = node
+
+				for _, deviceId := range node.Devices {
+					device, err := NewDeviceEntryFromId(tx, deviceId)
+					if err != nil {
+						return err
+					}
+					if device.isOnline() {
+						devices[id] = append(devices[id], device.Info.Name)
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Err(err)
+		return
+	}
+
+	for id, node := range online {
+		host, err := connectWithFailover(db, executor, node)
+		if err != nil {
+			logger.LogError("Unable to check node %v: %v", id, err)
+			continue
+		}
+
+		if len(devices[id]) > 0 {
+			latency, err := executor.NodeStorageLatency(host, devices[id])
+			if err != nil {
+				logger.LogError("Unable to check storage of node %v: %v", id, err)
+			} else {
+				err = db.Update(func(tx *bolt.Tx) error {
+					return nodeSetStorageLatency(tx, allocator, id, latency)
+				})
+				if err != nil {
+					logger.LogError("Unable to save storage latency of node %v: %v", id, err)
+				}
+			}
+		}
+
+		metrics, err := executor.NodeMetrics(host)
+		if err != nil {
+			logger.LogError("Unable to get metrics of node %v: %v", id, err)
+			continue
+		}
+
+		err = db.Update(func(tx *bolt.Tx) error {
+			return nodeSetMetrics(tx, id, metrics)
+		})
+		if err != nil {
+			logger.LogError("Unable to save metrics of node %v: %v", id, err)
+		}
+	}
+}
+
+// Save the latency and add the devices of the node to the allocator
+// again so that it uses the new latency
+func nodeSetStorageLatency(tx *bolt.Tx, allocator Allocator,
+	id string, latency float64) error {
+
+	node, err := NewNodeEntryFromId(tx, id)
+	if err == ErrNotFound {
+		// Deleted while it was checked
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	logger.Debug("Storage latency of node %v is %.2f ms", id, latency)
+	node.Info.StorageLatencyMs = latency
+	err = node.Save(tx)
+	if err != nil {
+		return err
+	}
+
+	if !node.isOnline() {
+		return nil
+	}
+
+	cluster, err := NewClusterEntryFromId(tx, node.Info.ClusterId)
+	if err != nil {
+		return err
+	}
+	for _, deviceId := range node.Devices {
+		device, err := NewDeviceEntryFromId(tx, deviceId)
+		if err != nil {
+			return err
+		}
+		if !device.isOnline() {
+			continue
+		}
+
+		err = allocator.RemoveDevice(cluster, node, device)
+		if err != nil {
+			return err
+		}
+		err = allocator.AddDevice(cluster, node, device)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Check the nodes every interval until stop is closed
+func checkNodeHealthEvery(db *bolt.DB, executor executors.Executor,
+	allocator Allocator, interval time.Duration, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			NodeHealthCheck(db, executor, allocator)
+		case <-stop:
+			return
+		}
+	}
+}
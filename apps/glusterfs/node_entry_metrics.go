@@ -0,0 +1,69 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+// Memory in bytes and CPUs of a node for capacity planning.  Time is
+// in seconds since the epoch, and zero if never checked.
+type NodeMetrics struct {
+	MemoryTotal uint64
+	MemoryFree  uint64
+	CpuCount    int
+	Time        int64
+}
+
+func (n *NodeEntry) setMetrics(metrics *executors.NodeMetrics) {
+	n.LastMetrics = NodeMetrics{
+		MemoryTotal: metrics.MemoryTotal,
+		MemoryFree:  metrics.MemoryFree,
+		CpuCount:    metrics.CpuCount,
+		Time:        time.Now().Unix(),
+	}
+}
+
+func (n *NodeEntry) NewMetricsResponse() *api.NodeMetricsResponse {
+	return &api.NodeMetricsResponse{
+		MemoryTotal: n.LastMetrics.MemoryTotal,
+		MemoryFree:  n.LastMetrics.MemoryFree,
+		CpuCount:    n.LastMetrics.CpuCount,
+
+		MaxConcurrentBrickOps: n.MaxConcurrentBrickOps(),
+	}
+}
+
+// Save the metrics checked on the node
+func nodeSetMetrics(tx *bolt.Tx, id string, metrics *executors.NodeMetrics) error {
+	node, err := NewNodeEntryFromId(tx, id)
+	if err == ErrNotFound {
+		// Deleted while it was checked
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	logger.Debug("Node %v has %v of %v bytes of memory free and %v CPUs",
+		id, metrics.MemoryFree, metrics.MemoryTotal, metrics.CpuCount)
+	node.setMetrics(metrics)
+	return node.Save(tx)
+}
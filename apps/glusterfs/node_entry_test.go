@@ -17,12 +17,15 @@
 package glusterfs
 
 import (
+	"errors"
 	"os"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/heketi/heketi/pkg/utils"
 	"github.com/heketi/tests"
@@ -121,6 +124,102 @@ func TestNodeEntryAddDeleteDevices(t *testing.T) {
 	tests.Assert(t, len(n.Devices) == 1)
 }
 
+func TestNodeEntryValidateStorage(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		1,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		tests.Assert(t, err == nil)
+		node, err := NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		tests.Assert(t, err == nil)
+		tests.Assert(t, node.ValidateStorage(tx) == nil)
+
+		// Free exceeds total on the second device
+		device, err := NewDeviceEntryFromId(tx, node.Devices[1])
+		tests.Assert(t, err == nil)
+		device.Info.Storage.Free = device.Info.Storage.Total + 1
+		tests.Assert(t, device.Save(tx) == nil)
+
+		err = node.ValidateStorage(tx)
+		tests.Assert(t, err != nil)
+		tests.Assert(t, strings.Contains(err.Error(), node.Info.Id), err)
+		tests.Assert(t, strings.Contains(err.Error(), device.Info.Id), err)
+		tests.Assert(t, strings.Contains(err.Error(), "free storage"), err)
+
+		// Used exceeds total
+		device.Info.Storage.Free = 0
+		device.Info.Storage.Used = device.Info.Storage.Total + 1
+		tests.Assert(t, device.Save(tx) == nil)
+
+		err = node.ValidateStorage(tx)
+		tests.Assert(t, err != nil)
+		tests.Assert(t, strings.Contains(err.Error(), "used storage"), err)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestNodeEntryWrappedErrors(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	n := createSampleNodeEntry()
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		return n.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	// Stale save
+	stale := *n
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		tests.Assert(t, n.Save(tx) == nil)
+		return stale.Save(tx)
+	})
+	tests.Assert(t, errors.Is(err, ErrStaleEntry), err)
+	tests.Assert(t, strings.Contains(err.Error(), n.Info.Id), err)
+	tests.Assert(t, isRetryableDbError(err))
+
+	// Missing device
+	n.DeviceAdd("abc")
+	err = app.db.View(func(tx *bolt.Tx) error {
+		_, err := n.NewInfoReponse(tx)
+		return err
+	})
+	tests.Assert(t, errors.Is(err, ErrNotFound), err)
+	tests.Assert(t, strings.Contains(err.Error(), "abc"), err)
+
+	// Devices left on the node
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return n.Delete(tx, false)
+	})
+	tests.Assert(t, errors.Is(err, ErrConflict), err)
+	tests.Assert(t, strings.Contains(err.Error(), n.Info.Id), err)
+
+	// Corrupt entry
+	err = NewNodeEntry().Unmarshal([]byte("garbage"))
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "Unable to decode node"), err)
+}
+
 func TestNodeEntryRegister(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -327,7 +426,7 @@ func TestNewNodeEntrySaveDelete(t *testing.T) {
 			return err
 		}
 
-		err = node.Delete(tx)
+		err = node.Delete(tx, false)
 		if err != nil {
 			return err
 		}
@@ -335,7 +434,7 @@ func TestNewNodeEntrySaveDelete(t *testing.T) {
 		return nil
 
 	})
-	tests.Assert(t, err == ErrConflict)
+	tests.Assert(t, errors.Is(err, ErrConflict), err)
 
 	// Delete devices in node
 	node.DeviceDelete("abc")
@@ -354,7 +453,7 @@ func TestNewNodeEntrySaveDelete(t *testing.T) {
 			return err
 		}
 
-		err = node.Delete(tx)
+		err = node.Delete(tx, false)
 		if err != nil {
 			return err
 		}
@@ -377,6 +476,111 @@ func TestNewNodeEntrySaveDelete(t *testing.T) {
 	tests.Assert(t, err == ErrNotFound)
 }
 
+func TestNodeEntryDeleteForce(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	// A node with a device which is no longer in the db, a device
+	// without bricks and a device with a brick
+	req := &api.NodeAddRequest{
+		ClusterId: "123",
+		Hostnames: api.HostAddresses{
+			Manage:  []string{"manage"},
+			Storage: []string{"storage"},
+		},
+		Zone: 99,
+	}
+	n := NewNodeEntryFromRequest(req)
+	empty := createSampleDeviceEntry(n.Info.Id, 10*GB)
+	used := createSampleDeviceEntry(n.Info.Id, 10*GB)
+	brick := NewBrickEntry(10, 10, 0, used.Info.Id, n.Info.Id)
+	used.BrickAdd(brick.Info.Id)
+	n.DeviceAdd("missing")
+	n.DeviceAdd(empty.Info.Id)
+	n.DeviceAdd(used.Info.Id)
+
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		for _, d := range []*DeviceEntry{empty, used} {
+			err := d.Save(tx)
+			if err != nil {
+				return err
+			}
+			err = d.Register(tx)
+			if err != nil {
+				return err
+			}
+		}
+		err := brick.Save(tx)
+		if err != nil {
+			return err
+		}
+		return n.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	deleteNode := func(force bool) error {
+		return app.db.Update(func(tx *bolt.Tx) error {
+			node, err := NewNodeEntryFromId(tx, n.Info.Id)
+			if err != nil {
+				return err
+			}
+			return node.Delete(tx, force)
+		})
+	}
+
+	// Not without force
+	err = deleteNode(false)
+	tests.Assert(t, errors.Is(err, ErrConflict), err)
+
+	// Nor with force while a device has bricks
+	err = deleteNode(true)
+	tests.Assert(t, errors.Is(err, ErrConflict), err)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, n.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(node.Devices) == 3)
+		_, err = NewDeviceEntryFromId(tx, empty.Info.Id)
+		return err
+	})
+	tests.Assert(t, err == nil)
+
+	// Nor when the index still lists bricks on the node
+	used.BrickDelete(brick.Info.Id)
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return used.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+	err = deleteNode(true)
+	tests.Assert(t, errors.Is(err, ErrConflict), err)
+
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return brick.Delete(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	err = deleteNode(true)
+	tests.Assert(t, err == nil, err)
+
+	// The node and its devices are gone, and the devices can be
+	// added again
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		_, err := NewNodeEntryFromId(tx, n.Info.Id)
+		tests.Assert(t, err == ErrNotFound)
+		for _, d := range []*DeviceEntry{empty, used} {
+			_, err = NewDeviceEntryFromId(tx, d.Info.Id)
+			tests.Assert(t, err == ErrNotFound)
+			err = d.Register(tx)
+			tests.Assert(t, err == nil, err)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
 func TestNewNodeEntryNewInfoResponse(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
@@ -396,9 +600,23 @@ func TestNewNodeEntryNewInfoResponse(t *testing.T) {
 	}
 
 	n := NewNodeEntryFromRequest(req)
+	d := createSampleDeviceEntry(n.Info.Id, 10*GB)
+	n.DeviceAdd(d.Info.Id)
 
 	// Save element in database
 	err := app.db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < 2; i++ {
+			brick := NewBrickEntry(10, 10, 0, d.Info.Id, n.Info.Id)
+			d.BrickAdd(brick.Info.Id)
+			err := brick.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		err := d.Save(tx)
+		if err != nil {
+			return err
+		}
 		return n.Save(tx)
 	})
 	tests.Assert(t, err == nil)
@@ -427,6 +645,115 @@ func TestNewNodeEntryNewInfoResponse(t *testing.T) {
 	tests.Assert(t, len(info.Hostnames.Storage) == 1)
 	tests.Assert(t, reflect.DeepEqual(info.Hostnames.Manage, n.Info.Hostnames.Manage))
 	tests.Assert(t, reflect.DeepEqual(info.Hostnames.Storage, n.Info.Hostnames.Storage))
+	tests.Assert(t, len(info.DevicesInfo) == 1)
+	tests.Assert(t, info.BrickCount == 2, info.BrickCount)
+}
+
+func TestNodeEntryVersions(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	cluster := createSampleClusterEntry()
+	nodes := []*NodeEntry{}
+	for i := 0; i < 3; i++ {
+		node := createSampleNodeEntry()
+		node.Info.ClusterId = cluster.Info.Id
+		node.setVersions(&executors.NodeVersions{
+			GlusterVersion: "3.7.11",
+			KernelVersion:  "3.10.0",
+		})
+		cluster.NodeAdd(node.Info.Id)
+		nodes = append(nodes, node)
+	}
+	save := func() {
+		err := app.db.Update(func(tx *bolt.Tx) error {
+			for _, node := range nodes {
+				if err := node.Save(tx); err != nil {
+					return err
+				}
+			}
+			return cluster.Save(tx)
+		})
+		tests.Assert(t, err == nil)
+	}
+	warning := func() string {
+		var w string
+		err := app.db.View(func(tx *bolt.Tx) error {
+			var err error
+			w, err = clusterVersionsWarning(tx, cluster.Info.Id)
+			return err
+		})
+		tests.Assert(t, err == nil)
+		return w
+	}
+	save()
+
+	// Kept in the db
+	err := app.db.View(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, nodes[0].Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, node.Info.GlusterVersion == "3.7.11")
+		tests.Assert(t, node.Info.KernelVersion == "3.10.0")
+
+		info, err := node.NewInfoReponse(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, info.GlusterVersion == "3.7.11")
+		tests.Assert(t, info.KernelVersion == "3.10.0")
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, warning() == "")
+
+	// Unknown versions are not compared
+	nodes[1].Info.GlusterVersion = ""
+	nodes[1].Info.KernelVersion = ""
+	save()
+	tests.Assert(t, warning() == "")
+
+	// Mixed versions
+	nodes[1].Info.GlusterVersion = "3.8.4"
+	nodes[2].Info.KernelVersion = "4.4.0"
+	save()
+	w := warning()
+	tests.Assert(t, strings.Contains(w, cluster.Info.Id), w)
+	tests.Assert(t, strings.Contains(w, "GlusterFS 3.7.11, 3.8.4"), w)
+	tests.Assert(t, strings.Contains(w, "kernel 3.10.0, 4.4.0"), w)
+}
+
+func TestOlderGlusterVersion(t *testing.T) {
+	tests.Assert(t, olderGlusterVersion("3.12.2", "3.10.1") == "3.10.1")
+	tests.Assert(t, olderGlusterVersion("3.10.1", "3.12.2") == "3.10.1")
+	tests.Assert(t, olderGlusterVersion("", "3.12.2") == "3.12.2")
+	tests.Assert(t, olderGlusterVersion("3.12.2", "") == "3.12.2")
+	tests.Assert(t, olderGlusterVersion("", "") == "")
+}
+
+func TestNodeEntryLabels(t *testing.T) {
+	n := createSampleNodeEntry()
+	n.Info.Zone = 3
+	n.Info.ClusterId = "cluster"
+	n.Info.Hostnames.Manage = []string{"manage1", "manage2"}
+
+	labels := n.Labels()
+	tests.Assert(t, len(labels) == 3, labels)
+	tests.Assert(t, labels[api.NodeLabelZone] == "3", labels)
+	tests.Assert(t, labels[api.NodeLabelHostname] == "manage1", labels)
+	tests.Assert(t, labels[api.NodeLabelCluster] == "cluster", labels)
+
+	// Tags are labels, except those set from the node
+	n.SetTags(map[string]string{
+		"rack":            "r1",
+		api.NodeLabelZone: "9",
+	})
+	labels = n.Labels()
+	tests.Assert(t, len(labels) == 4, labels)
+	tests.Assert(t, labels["rack"] == "r1", labels)
+	tests.Assert(t, labels[api.NodeLabelZone] == "3", labels)
+	tests.Assert(t, n.Info.Tags[api.NodeLabelZone] == "9")
 }
 
 func TestNodeSetStateFailed(t *testing.T) {
@@ -583,3 +910,445 @@ func TestNodeSetStateOfflineOnline(t *testing.T) {
 
 	})
 }
+
+func TestNodeEntryFindOrCreateCluster(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		existing := createSampleClusterEntry()
+		err := existing.Save(tx)
+		tests.Assert(t, err == nil)
+
+		// Existing cluster
+		node := createSampleNodeEntry()
+		node.Info.ClusterId = existing.Info.Id
+		cluster, created, err := node.FindOrCreateCluster(tx, false)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, !created)
+		tests.Assert(t, cluster.Info.Id == existing.Info.Id)
+
+		cluster, created, err = node.FindOrCreateCluster(tx, true)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, !created)
+		tests.Assert(t, cluster.Info.Id == existing.Info.Id)
+
+		// Missing cluster
+		missing := utils.GenUUID()
+		node.Info.ClusterId = missing
+		_, _, err = node.FindOrCreateCluster(tx, false)
+		tests.Assert(t, err == ErrNotFound)
+		_, err = NewClusterEntryFromId(tx, missing)
+		tests.Assert(t, err == ErrNotFound)
+
+		node.Info.ClusterId = ""
+		_, _, err = node.FindOrCreateCluster(tx, false)
+		tests.Assert(t, err == ErrNotFound)
+
+		// Created with the requested id
+		node.Info.ClusterId = missing
+		cluster, created, err = node.FindOrCreateCluster(tx, true)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, created)
+		tests.Assert(t, cluster.Info.Id == missing)
+		_, err = NewClusterEntryFromId(tx, missing)
+		tests.Assert(t, err == nil)
+
+		// Created with a new id
+		node.Info.ClusterId = ""
+		cluster, created, err = node.FindOrCreateCluster(tx, true)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, created)
+		tests.Assert(t, cluster.Info.Id != "")
+		tests.Assert(t, node.Info.ClusterId == cluster.Info.Id)
+
+		// Ids which could not have been generated are rejected
+		node.Info.ClusterId = "mycluster"
+		_, _, err = node.FindOrCreateCluster(tx, true)
+		tests.Assert(t, err == ErrInvalidId)
+
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestNodeHealthCheck(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		2,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var slow, fast *NodeEntry
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		tests.Assert(t, err == nil)
+		slow, err = NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		tests.Assert(t, err == nil)
+		fast, err = NewNodeEntryFromId(tx, cluster.Info.Nodes[1])
+		tests.Assert(t, err == nil)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	app.xo.MockNodeStorageLatency = func(host string, devices []string) (float64, error) {
+		tests.Assert(t, len(devices) == 2)
+		if host == slow.ManageHostName() {
+			return 20, nil
+		}
+		return 0.5, nil
+	}
+	app.xo.MockNodeMetrics = func(host string) (*executors.NodeMetrics, error) {
+		if host == slow.ManageHostName() {
+			return nil, errors.New("ssh failed")
+		}
+		return &executors.NodeMetrics{
+			MemoryTotal: 8 * 1024 * 1024 * 1024,
+			MemoryFree:  1024 * 1024 * 1024,
+			CpuCount:    4,
+		}, nil
+	}
+	NodeHealthCheck(app.db, app.executor, app.allocator)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, slow.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, node.Info.StorageLatencyMs == 20)
+		tests.Assert(t, node.LastMetrics.Time == 0)
+
+		node, err = NewNodeEntryFromId(tx, fast.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, node.Info.StorageLatencyMs == 0.5)
+
+		// The metrics are saved with the node
+		tests.Assert(t, node.LastMetrics.MemoryTotal == 8*1024*1024*1024)
+		tests.Assert(t, node.LastMetrics.MemoryFree == 1024*1024*1024)
+		tests.Assert(t, node.LastMetrics.CpuCount == 4)
+		tests.Assert(t, node.LastMetrics.Time > 0)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// The allocator uses the new latency
+	simple := app.allocator.(*PreferenceAwareAllocator).Allocator.(*SimpleAllocator)
+	ring := simple.rings[slow.Info.ClusterId]
+	devices := ring.ring[slow.Info.Zone][slow.Info.Id]
+	tests.Assert(t, len(devices) == 2)
+	for _, d := range devices {
+		tests.Assert(t, d.latency == 20)
+	}
+}
+
+func TestLeastLoadedNode(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		c := createSampleClusterEntry()
+
+		// Empty cluster
+		err := c.Save(tx)
+		tests.Assert(t, err == nil)
+		_, err = LeastLoadedNode(tx, c.Info.Id)
+		tests.Assert(t, err == ErrNotFound)
+
+		// Missing cluster
+		_, err = LeastLoadedNode(tx, "nothere")
+		tests.Assert(t, err == ErrNotFound)
+
+		// Create nodes with a device each with varying free storage
+		nodes := make([]*NodeEntry, 0)
+		for _, free := range []uint64{100, 500, 300, 900} {
+			n := createSampleNodeEntry()
+			n.Info.ClusterId = c.Info.Id
+			c.NodeAdd(n.Info.Id)
+
+			d := createSampleDeviceEntry(n.Info.Id, 1000)
+			d.StorageAllocate(1000 - free)
+			n.DeviceAdd(d.Info.Id)
+
+			tests.Assert(t, d.Save(tx) == nil)
+			tests.Assert(t, n.Save(tx) == nil)
+			nodes = append(nodes, n)
+		}
+		tests.Assert(t, c.Save(tx) == nil)
+
+		node, err := LeastLoadedNode(tx, c.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, node.Info.Id == nodes[3].Info.Id)
+
+		// Skip offline nodes
+		nodes[3].State = api.EntryStateOffline
+		tests.Assert(t, nodes[3].Save(tx) == nil)
+
+		node, err = LeastLoadedNode(tx, c.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, node.Info.Id == nodes[1].Info.Id)
+
+		// Skip storage on offline devices
+		d, err := NewDeviceEntryFromId(tx, nodes[1].Devices[0])
+		tests.Assert(t, err == nil)
+		d.State = api.EntryStateOffline
+		tests.Assert(t, d.Save(tx) == nil)
+
+		node, err = LeastLoadedNode(tx, c.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, node.Info.Id == nodes[2].Info.Id)
+
+		// No online nodes
+		for _, n := range nodes {
+			n.State = api.EntryStateOffline
+			tests.Assert(t, n.Save(tx) == nil)
+		}
+		_, err = LeastLoadedNode(tx, c.Info.Id)
+		tests.Assert(t, err == ErrNotFound)
+
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestBulkTagNodes(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		2,      // clusters
+		3,      // nodes_per_cluster
+		1,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Put the first node of each cluster in zone 2
+	zone2 := make(map[string]bool)
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		for _, clusterId := range clusters {
+			cluster, err := NewClusterEntryFromId(tx, clusterId)
+			tests.Assert(t, err == nil)
+			node, err := NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+			tests.Assert(t, err == nil)
+			node.Info.Zone = 2
+			node.Info.Tags = map[string]string{"rack": "r1", "keep": "yes"}
+			err = node.Save(tx)
+			tests.Assert(t, err == nil)
+			zone2[node.Info.Id] = true
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Tag all zone-2 nodes
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		count, err := BulkTagNodes(tx, func(n *NodeEntry) bool {
+			return n.Info.Zone == 2
+		}, map[string]string{"rack": "r2", "ssd": "true"})
+		tests.Assert(t, err == nil)
+		tests.Assert(t, count == 2, count)
+
+		// No matches
+		count, err = BulkTagNodes(tx, func(n *NodeEntry) bool {
+			return false
+		}, map[string]string{"none": "true"})
+		tests.Assert(t, err == nil)
+		tests.Assert(t, count == 0)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Check the tags were saved
+	err = app.db.View(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		for _, clusterId := range clusters {
+			cluster, err := NewClusterEntryFromId(tx, clusterId)
+			tests.Assert(t, err == nil)
+			for _, nodeId := range cluster.Info.Nodes {
+				node, err := NewNodeEntryFromId(tx, nodeId)
+				tests.Assert(t, err == nil)
+				if zone2[nodeId] {
+					tests.Assert(t, len(node.Info.Tags) == 3, node.Info.Tags)
+					tests.Assert(t, node.Info.Tags["rack"] == "r2")
+					tests.Assert(t, node.Info.Tags["ssd"] == "true")
+					tests.Assert(t, node.Info.Tags["keep"] == "yes")
+				} else {
+					tests.Assert(t, len(node.Info.Tags) == 0)
+				}
+			}
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestNodeEntrySetZone(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	a := NewSimpleAllocator()
+
+	cluster := createSampleClusterEntry()
+	node := createSampleNodeEntry()
+	node.Info.ClusterId = cluster.Info.Id
+	node.Info.Zone = 1
+	cluster.NodeAdd(node.Info.Id)
+	online := createSampleDeviceEntry(node.Info.Id, 10*TB)
+	offline := createSampleDeviceEntry(node.Info.Id, 10*TB)
+	offline.State = api.EntryStateOffline
+	node.DeviceAdd(online.Info.Id)
+	node.DeviceAdd(offline.Info.Id)
+	tests.Assert(t, a.AddDevice(cluster, node, online) == nil)
+
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		for _, e := range []interface {
+			Save(*bolt.Tx) error
+		}{cluster, node, online, offline} {
+			err := e.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil, err)
+
+	zoneDevices := func(zone int) []string {
+		devices := make([]string, 0)
+		for _, d := range a.rings[cluster.Info.Id].ring[zone][node.Info.Id] {
+			devices = append(devices, d.deviceId)
+		}
+		return devices
+	}
+
+	// Zone cannot be zero
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return node.SetZone(tx, a, 0)
+	})
+	tests.Assert(t, err != nil)
+	tests.Assert(t, node.Info.Zone == 1)
+
+	// Online devices move to the new zone
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return node.SetZone(tx, a, 2)
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, node.Info.Zone == 2)
+	tests.Assert(t, len(zoneDevices(1)) == 0)
+	tests.Assert(t, reflect.DeepEqual(zoneDevices(2), []string{online.Info.Id}))
+
+	// An offline node has no devices in the allocator
+	node.State = api.EntryStateOffline
+	tests.Assert(t, a.RemoveDevice(cluster, node, online) == nil)
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return node.SetZone(tx, a, 3)
+	})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, node.Info.Zone == 3)
+	tests.Assert(t, len(zoneDevices(2)) == 0)
+	tests.Assert(t, len(zoneDevices(3)) == 0)
+}
+
+func TestNodeConnectWithFailover(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		1,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Give the node a second manage hostname
+	var node *NodeEntry
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		tests.Assert(t, err == nil)
+		node, err = NewNodeEntryFromId(tx, cluster.Info.Nodes[0])
+		tests.Assert(t, err == nil)
+		node.Info.Hostnames.Manage = []string{"first", "second"}
+		return node.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, node.ManageHostName() == "first")
+
+	// The first hostname fails, the second succeeds
+	var tried []string
+	app.xo.MockGlusterdCheck = func(host string) error {
+		tried = append(tried, host)
+		if host == "first" {
+			return errors.New("no route to host")
+		}
+		return nil
+	}
+	host, err := connectWithFailover(app.db, app.executor, node)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, host == "second", host)
+	tests.Assert(t, reflect.DeepEqual(tried, []string{"first", "second"}), tried)
+	tests.Assert(t, node.ManageHostName() == "second")
+
+	// The hostname which succeeded is saved
+	err = app.db.View(func(tx *bolt.Tx) error {
+		entry, err := NewNodeEntryFromId(tx, node.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, entry.LastManageHost == "second")
+		tests.Assert(t, entry.ManageHostName() == "second")
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// The health check reaches the node at the second hostname
+	var checked []string
+	app.xo.MockNodeMetrics = func(host string) (*executors.NodeMetrics, error) {
+		checked = append(checked, host)
+		return &executors.NodeMetrics{CpuCount: 4}, nil
+	}
+	NodeHealthCheck(app.db, app.executor, app.allocator)
+	tests.Assert(t, reflect.DeepEqual(checked, []string{"second"}), checked)
+
+	// No hostname is reachable
+	app.xo.MockGlusterdCheck = func(host string) error {
+		return errors.New("no route to host")
+	}
+	_, err = connectWithFailover(app.db, app.executor, node)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), node.Info.Id), err)
+
+	// The last hostname which worked is still used
+	tests.Assert(t, node.ManageHostName() == "second")
+}
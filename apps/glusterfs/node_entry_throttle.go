@@ -0,0 +1,46 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+)
+
+var (
+	// Brick creations and deletions run at once on the nodes which
+	// do not set their own limit
+	NodeMaxConcurrentBrickOps = 2
+)
+
+// Returns the brick creations and deletions run at once on the node
+func (n *NodeEntry) MaxConcurrentBrickOps() int {
+	if n.Info.MaxConcurrentBrickOps == 0 {
+		return NodeMaxConcurrentBrickOps
+	}
+	return n.Info.MaxConcurrentBrickOps
+}
+
+// Sets the brick creations and deletions run at once on the node, or
+// the default if 0.  The brick operations already waiting keep the
+// limit they were requested with.
+func (n *NodeEntry) SetMaxConcurrentBrickOps(limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("Invalid number of concurrent brick operations %v", limit)
+	}
+	n.Info.MaxConcurrentBrickOps = limit
+	return nil
+}
@@ -0,0 +1,89 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+func (n *NodeEntry) setVersions(versions *executors.NodeVersions) {
+	n.Info.GlusterVersion = versions.GlusterVersion
+	n.Info.KernelVersion = versions.KernelVersion
+}
+
+// Returns the older of two versions of GlusterFS, ignoring the one
+// which is not known
+func olderGlusterVersion(a, b string) string {
+	if a == "" || (b != "" && utils.CompareVersions(b, a) < 0) {
+		return b
+	}
+	return a
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Returns a warning if the nodes of the cluster run different versions
+// of GlusterFS or of the kernel, or an empty string if they do not.
+// The nodes whose versions are not known are not compared.
+func clusterVersionsWarning(tx *bolt.Tx, clusterId string) (string, error) {
+	cluster, err := NewClusterEntryFromId(tx, clusterId)
+	if err != nil {
+		return "", err
+	}
+
+	gluster := make(map[string]bool)
+	kernel := make(map[string]bool)
+	for _, nodeId := range cluster.Info.Nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return "", err
+		}
+		if node.Info.GlusterVersion != "" {
+			gluster[node.Info.GlusterVersion] = true
+		}
+		if node.Info.KernelVersion != "" {
+			kernel[node.Info.KernelVersion] = true
+		}
+	}
+
+	warnings := []string{}
+	if len(gluster) > 1 {
+		warnings = append(warnings, "GlusterFS "+strings.Join(sortedKeys(gluster), ", "))
+	}
+	if len(kernel) > 1 {
+		warnings = append(warnings, "kernel "+strings.Join(sortedKeys(kernel), ", "))
+	}
+	if len(warnings) == 0 {
+		return "", nil
+	}
+
+	return fmt.Sprintf("Nodes of cluster %v run different versions of %v",
+		clusterId, strings.Join(warnings, " and ")), nil
+}
@@ -0,0 +1,75 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/lpabon/godbc"
+)
+
+// Returns the volumes with bricks on the devices of the node, which
+// are impacted when it is taken down, ordered by id.  A volume is
+// listed once with all its bricks on the node, whichever devices
+// they are on.
+func (n *NodeEntry) ImpactedVolumes(tx *bolt.Tx) ([]api.NodeVolume, error) {
+	godbc.Require(tx != nil)
+
+	brickIds, err := n.BrickIds(tx)
+	if err != nil {
+		return nil, err
+	}
+	bricks := make(map[string]bool)
+	for _, brickId := range brickIds {
+		bricks[brickId] = true
+	}
+
+	volumes := make([]api.NodeVolume, 0)
+	if len(bricks) == 0 {
+		return volumes, nil
+	}
+
+	cluster, err := NewClusterEntryFromId(tx, n.Info.ClusterId)
+	if err != nil {
+		return nil, err
+	}
+	for _, volumeId := range cluster.Info.Volumes {
+		volume, err := NewVolumeEntryFromId(tx, volumeId)
+		if err != nil {
+			return nil, err
+		}
+
+		summary := api.NodeVolume{
+			Id:         volume.Info.Id,
+			Name:       volume.Info.Name,
+			Cluster:    volume.Info.Cluster,
+			Size:       volume.Info.Size,
+			Durability: volume.Info.Durability.Type,
+			Bricks:     make([]string, 0),
+		}
+		for _, brickId := range volume.Bricks {
+			if bricks[brickId] {
+				summary.Bricks = append(summary.Bricks, brickId)
+			}
+		}
+		if len(summary.Bricks) != 0 {
+			volumes = append(volumes, summary)
+		}
+	}
+
+	return volumes, nil
+}
@@ -0,0 +1,225 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/lpabon/godbc"
+)
+
+// Moves the node to another zone.  The devices of the node are moved
+// to the new zone in the allocator, so only new bricks are placed
+// according to the new zone.
+func (n *NodeEntry) SetZone(tx *bolt.Tx, a Allocator, zone int) error {
+	godbc.Require(tx != nil)
+
+	if zone == 0 {
+		return fmt.Errorf("Zone cannot be zero")
+	}
+	if zone == n.Info.Zone {
+		return nil
+	}
+
+	// Only an online node has devices in the allocator
+	if !n.isOnline() {
+		n.Info.Zone = zone
+		return nil
+	}
+
+	cluster, err := NewClusterEntryFromId(tx, n.Info.ClusterId)
+	if err != nil {
+		return err
+	}
+	devices := make([]*DeviceEntry, 0)
+	for _, deviceId := range n.Devices {
+		device, err := NewDeviceEntryFromId(tx, deviceId)
+		if err != nil {
+			return err
+		}
+		if device.isOnline() {
+			devices = append(devices, device)
+		}
+	}
+
+	// The allocator finds the devices by the zone of the node
+	for _, device := range devices {
+		err := a.RemoveDevice(cluster, n, device)
+		if err != nil {
+			return err
+		}
+	}
+	n.Info.Zone = zone
+	for _, device := range devices {
+		err := a.AddDevice(cluster, n, device)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sets whether new bricks are placed on the node before the nodes
+// which are not preferred.  Existing bricks are not moved.
+func (n *NodeEntry) SetPreferredForNewBricks(tx *bolt.Tx, a Allocator, preferred bool) error {
+	godbc.Require(tx != nil)
+
+	if preferred == n.Info.PreferredForNewBricks {
+		return nil
+	}
+
+	// Only an online node has devices in the allocator
+	if !n.isOnline() {
+		n.Info.PreferredForNewBricks = preferred
+		return nil
+	}
+
+	cluster, err := NewClusterEntryFromId(tx, n.Info.ClusterId)
+	if err != nil {
+		return err
+	}
+	devices := make([]*DeviceEntry, 0)
+	for _, deviceId := range n.Devices {
+		device, err := NewDeviceEntryFromId(tx, deviceId)
+		if err != nil {
+			return err
+		}
+		if device.isOnline() {
+			devices = append(devices, device)
+		}
+	}
+
+	// The allocator learns the preference when the devices are added
+	for _, device := range devices {
+		err := a.RemoveDevice(cluster, n, device)
+		if err != nil {
+			return err
+		}
+	}
+	n.Info.PreferredForNewBricks = preferred
+	for _, device := range devices {
+		err := a.AddDevice(cluster, n, device)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the volumes with replicas or redundancy which have bricks
+// on the node and on other nodes of the same zone.  The sets of the
+// bricks are not saved, so bricks of a volume in the same zone may be
+// in different sets, but each of these volumes should be checked.
+func (n *NodeEntry) ZoneConflicts(tx *bolt.Tx) ([]api.NodeZoneVolume, error) {
+	godbc.Require(tx != nil)
+
+	cluster, err := NewClusterEntryFromId(tx, n.Info.ClusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]*NodeEntry)
+	for _, nodeId := range cluster.Info.Nodes {
+		if nodeId == n.Info.Id {
+			nodes[nodeId] = n
+			continue
+		}
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return nil, err
+		}
+		nodes[nodeId] = node
+	}
+
+	conflicts := make([]api.NodeZoneVolume, 0)
+	for _, volumeId := range cluster.Info.Volumes {
+		volume, err := NewVolumeEntryFromId(tx, volumeId)
+		if err != nil {
+			return nil, err
+		}
+		if volume.Durability.BricksInSet() < 2 {
+			continue
+		}
+
+		bricks := make([]*BrickEntry, 0)
+		used := make(map[string]bool)
+		zoneNodes := make(sort.StringSlice, 0)
+		for _, brickId := range volume.Bricks {
+			brick, err := NewBrickEntryFromId(tx, brickId)
+			if err != nil {
+				return nil, err
+			}
+			used[brick.Info.NodeId] = true
+
+			if brick.Info.NodeId == n.Info.Id {
+				bricks = append(bricks, brick)
+				continue
+			}
+			node, ok := nodes[brick.Info.NodeId]
+			if ok && node.Info.Zone == n.Info.Zone &&
+				!utils.SortedStringHas(zoneNodes, node.Info.Id) {
+				zoneNodes = append(zoneNodes, node.Info.Id)
+				zoneNodes.Sort()
+			}
+		}
+		if len(bricks) == 0 || len(zoneNodes) == 0 {
+			continue
+		}
+
+		// Nodes the bricks could be moved to
+		targets := 0
+		for id, node := range nodes {
+			if !used[id] && node.isOnline() && node.Info.Zone != n.Info.Zone {
+				targets++
+			}
+		}
+
+		conflict := api.NodeZoneVolume{
+			Id:          volume.Info.Id,
+			Name:        volume.Info.Name,
+			Bricks:      make([]string, 0, len(bricks)),
+			Nodes:       zoneNodes,
+			Remediation: make([]string, 0),
+		}
+		conflict.Remediation = append(conflict.Remediation,
+			fmt.Sprintf("Check that no set of volume %v has more than one brick "+
+				"on nodes %v", volume.Info.Name, append([]string{n.Info.Id}, zoneNodes...)))
+		if targets == 0 {
+			conflict.Remediation = append(conflict.Remediation,
+				fmt.Sprintf("Add a node to cluster %v in a zone other than %v "+
+					"which has no bricks of the volume", cluster.Info.Id, n.Info.Zone))
+		}
+		for _, brick := range bricks {
+			conflict.Bricks = append(conflict.Bricks, brick.Info.Id)
+			conflict.Remediation = append(conflict.Remediation,
+				fmt.Sprintf("Replace brick %v:%v with a brick on a node outside "+
+					"zone %v: gluster volume replace-brick %v %v:%v NEWHOST:NEWPATH commit force",
+					n.StorageHostName(), brick.Info.Path, n.Info.Zone,
+					volume.glusterName(), n.StorageHostName(), brick.Info.Path))
+		}
+
+		conflicts = append(conflicts, conflict)
+	}
+
+	return conflicts, nil
+}
@@ -0,0 +1,178 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+// Cancellation of an asynchronous operation.  A running operation
+// calls checkpoint between its steps, and returns ErrCancelled from
+// it after rolling back.  Once it is past the point where it can be
+// rolled back, it calls commit with the reason.  It also reports the
+// step it is at, and the progress of the step.  The methods of a nil
+// cancellation do nothing, for operations run without one.
+type operationCancel struct {
+	lock      sync.Mutex
+	started   bool
+	requested bool
+
+	// Closed once the cancellation is requested
+	done chan struct{}
+
+	// Why the operation can no longer be cancelled, once set
+	committed string
+
+	// Step the operation is at, given to report when it changes.
+	// Steps have their own lock as report takes the lock of the
+	// operations, which is held when cancelling.
+	stepLock sync.Mutex
+	current  api.AsyncOperationStep
+	report   func(step api.AsyncOperationStep)
+}
+
+func newOperationCancel() *operationCancel {
+	return &operationCancel{
+		done: make(chan struct{}),
+	}
+}
+
+// Marks the operation as running.  Returns false if it was cancelled
+// before it started.
+func (c *operationCancel) start() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.requested {
+		return false
+	}
+	c.started = true
+	return true
+}
+
+// Returns ErrCancelled if the cancellation of the operation was
+// requested
+func (c *operationCancel) checkpoint() error {
+	if c == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.requested {
+		return ErrCancelled
+	}
+	return nil
+}
+
+// Marks the operation as no longer cancellable, unless its
+// cancellation was requested, in which case ErrCancelled is returned
+func (c *operationCancel) commit(reason string) error {
+	if c == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.requested {
+		return ErrCancelled
+	}
+	c.committed = reason
+	return nil
+}
+
+// Requests the cancellation of the operation.  Returns whether the
+// operation is running, or why it cannot be cancelled.
+func (c *operationCancel) request() (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.committed != "" {
+		return c.started, errors.New(c.committed)
+	}
+	if !c.requested {
+		c.requested = true
+		close(c.done)
+	}
+	return c.started, nil
+}
+
+// Returns a channel closed once the cancellation of the operation is
+// requested, for the waits of the operation to stop
+func (c *operationCancel) cancelled() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.done
+}
+
+func (c *operationCancel) status() (started, requested bool) {
+	if c == nil {
+		return false, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.started, c.requested
+}
+
+// Reports that the operation started the named step
+func (c *operationCancel) step(name string) {
+	if c == nil {
+		return
+	}
+
+	c.stepLock.Lock()
+	defer c.stepLock.Unlock()
+
+	c.current = api.AsyncOperationStep{
+		Name:    name,
+		Started: time.Now().Unix(),
+	}
+	if c.report != nil {
+		c.report(c.current)
+	}
+}
+
+// Reports the parts of the current step done out of the total, with
+// details of the progress if any
+func (c *operationCancel) progress(done, total int, detail string) {
+	if c == nil {
+		return
+	}
+
+	c.stepLock.Lock()
+	defer c.stepLock.Unlock()
+
+	c.current.Done = done
+	c.current.Total = total
+	c.current.Percent = 0
+	if total > 0 {
+		c.current.Percent = done * 100 / total
+	}
+	c.current.Detail = detail
+	if c.report != nil {
+		c.report(c.current)
+	}
+}
@@ -0,0 +1,326 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/lpabon/godbc"
+)
+
+type PendingOperationType int
+
+const (
+	PENDING_OP_VOLUME_CREATE PendingOperationType = iota
+	PENDING_OP_VOLUME_EXPAND
+)
+
+func (t PendingOperationType) String() string {
+	switch t {
+	case PENDING_OP_VOLUME_CREATE:
+		return "volume create"
+	case PENDING_OP_VOLUME_EXPAND:
+		return "volume expand"
+	}
+	return fmt.Sprintf("unknown(%d)", int(t))
+}
+
+// A pending operation is saved in the db while an operation changes
+// both the db and the storage nodes.  If heketi stops before the
+// operation completes, the record is used at startup to either finish
+// or undo the operation.
+type PendingOperationEntry struct {
+	Id     string
+	Type   PendingOperationType
+	Volume *VolumeEntry
+	Bricks []*BrickEntry
+
+	// Set before the executor adds the bricks to an existing volume.
+	// From then GlusterFS may own the bricks, so rolling back does not
+	// destroy them.
+	ExecutorStarted bool
+
+	// Set once all executor steps have completed.  At that point
+	// only the db needs to be updated to finish the operation.
+	ExecutorDone bool
+
+	// Size in GB an expansion adds to the volume
+	ExpandSize int
+}
+
+func PendingOperationList(tx *bolt.Tx) ([]string, error) {
+
+	list := EntryKeys(tx, BOLTDB_BUCKET_PENDING_OPS)
+	if list == nil {
+		return nil, ErrAccessList
+	}
+	return list, nil
+}
+
+func NewPendingOperationEntry(optype PendingOperationType,
+	v *VolumeEntry,
+	bricks []*BrickEntry) *PendingOperationEntry {
+
+	godbc.Require(v != nil)
+
+	entry := &PendingOperationEntry{}
+	entry.Id = utils.GenUUID()
+	entry.Type = optype
+	entry.Volume = v
+	entry.Bricks = bricks
+
+	return entry
+}
+
+func NewPendingOperationEntryFromId(tx *bolt.Tx, id string) (*PendingOperationEntry, error) {
+	godbc.Require(tx != nil)
+
+	entry := &PendingOperationEntry{}
+	entry.Volume = NewVolumeEntry()
+	err := EntryLoad(tx, entry, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (p *PendingOperationEntry) BucketName() string {
+	return BOLTDB_BUCKET_PENDING_OPS
+}
+
+func (p *PendingOperationEntry) Save(tx *bolt.Tx) error {
+	godbc.Require(tx != nil)
+	godbc.Require(len(p.Id) > 0)
+
+	return EntrySave(tx, p, p.Id)
+}
+
+func (p *PendingOperationEntry) Delete(tx *bolt.Tx) error {
+	return EntryDelete(tx, p, p.Id)
+}
+
+func (p *PendingOperationEntry) Marshal() ([]byte, error) {
+	var buffer bytes.Buffer
+	enc := gob.NewEncoder(&buffer)
+	err := enc.Encode(*p)
+
+	return buffer.Bytes(), err
+}
+
+func (p *PendingOperationEntry) Unmarshal(buffer []byte) error {
+	dec := gob.NewDecoder(bytes.NewReader(buffer))
+	err := dec.Decode(p)
+	if err != nil {
+		return err
+	}
+
+	// Make sure to setup arrays if nil
+	if p.Bricks == nil {
+		p.Bricks = make([]*BrickEntry, 0)
+	}
+
+	return nil
+}
+
+func (p *PendingOperationEntry) String() string {
+	return fmt.Sprintf("%v %v of volume %v with %v bricks",
+		p.Id, p.Type, p.Volume.Info.Id, len(p.Bricks))
+}
+
+// Returns true if the operation can be finished by only updating
+// the db.  Otherwise the operation must be rolled back.
+func (p *PendingOperationEntry) CanRollForward() bool {
+	return p.ExecutorDone
+}
+
+// Finish the operation by saving the entries it had created or changed
+func (p *PendingOperationEntry) RollForward(tx *bolt.Tx) error {
+	godbc.Require(p.CanRollForward())
+
+	for _, brick := range p.Bricks {
+		err := brick.Save(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Another operation may have saved the volume since it was
+	// expanded, the expansion is then applied to the saved volume
+	volume := p.Volume
+	if p.Type == PENDING_OP_VOLUME_EXPAND {
+		latest, err := NewVolumeEntryFromId(tx, p.Volume.Info.Id)
+		if err != nil {
+			return err
+		}
+		if latest.Generation != p.Volume.Generation {
+			for _, brick := range p.Bricks {
+				latest.BrickAdd(brick.Info.Id)
+			}
+			latest.Info.Size += p.ExpandSize
+			volume = latest
+		}
+	}
+
+	err := volume.Save(tx)
+	if err != nil {
+		return err
+	}
+
+	if p.Type == PENDING_OP_VOLUME_CREATE {
+		cluster, err := NewClusterEntryFromId(tx, p.Volume.Info.Cluster)
+		if err != nil {
+			return err
+		}
+		if !utils.SortedStringHas(cluster.Info.Volumes, p.Volume.Info.Id) {
+			cluster.VolumeAdd(p.Volume.Info.Id)
+		}
+		err = cluster.Save(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.Delete(tx)
+}
+
+// Undo the operation by removing the volume and bricks from the
+// storage nodes and returning the space allocated on the devices.
+// Errors from the executor are logged and ignored since the
+// operation may have stopped before those steps were issued.
+func (p *PendingOperationEntry) RollBack(db *bolt.DB, executor executors.Executor) error {
+
+	if p.Type == PENDING_OP_VOLUME_CREATE && len(p.Bricks) > 0 {
+		var host string
+		err := db.View(func(tx *bolt.Tx) error {
+			node, err := NewNodeEntryFromId(tx, p.Bricks[0].Info.NodeId)
+			if err != nil {
+				return err
+			}
+			host = node.ManageHostName()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = executor.VolumeDestroy(host, p.Volume.glusterName())
+		if err != nil {
+			logger.Warning("Unable to destroy volume %v: %v",
+				p.Volume.Info.Name, err)
+		}
+	}
+
+	if p.ExecutorStarted {
+		logger.Warning("Bricks of %v may be part of volume %v and are not destroyed",
+			p, p.Volume.Info.Name)
+	} else {
+		err := DestroyBricks(db, executor, p.Bricks)
+		if err != nil {
+			logger.Warning("Unable to destroy all bricks of volume %v: %v",
+				p.Volume.Info.Id, err)
+		}
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, brick := range p.Bricks {
+			err := p.Volume.rollbackBrickFromDb(tx, brick)
+			if err != nil {
+				return err
+			}
+		}
+
+		return p.Delete(tx)
+	})
+}
+
+// Resolve all operations which did not complete before heketi was
+// last stopped.  If listOnly is set, the operations are only logged.
+// Returns the number of operations left unresolved.
+func PendingOperationsResolve(db *bolt.DB,
+	executor executors.Executor,
+	listOnly bool) (int, error) {
+
+	var ops []*PendingOperationEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		list, err := PendingOperationList(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range list {
+			op, err := NewPendingOperationEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, op)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(ops) == 0 {
+		return 0, nil
+	}
+
+	if listOnly {
+		for _, op := range ops {
+			if op.CanRollForward() {
+				logger.Warning("Pending operation %v: can roll forward", op)
+			} else {
+				logger.Warning("Pending operation %v: must roll back", op)
+			}
+		}
+		logger.Warning("%v pending operations left unresolved", len(ops))
+		return len(ops), nil
+	}
+
+	forward, back, failed := 0, 0, 0
+	for _, op := range ops {
+		if op.CanRollForward() {
+			logger.Info("Rolling forward pending operation %v", op)
+			err = db.Update(func(tx *bolt.Tx) error {
+				return op.RollForward(tx)
+			})
+			if err == nil {
+				forward++
+			}
+		} else {
+			logger.Info("Rolling back pending operation %v", op)
+			err = op.RollBack(db, executor)
+			if err == nil {
+				back++
+			}
+		}
+		if err != nil {
+			logger.LogError("Unable to resolve pending operation %v: %v", op, err)
+			failed++
+		}
+	}
+
+	logger.Warning("Pending operations: %v rolled forward, %v rolled back, %v failed",
+		forward, back, failed)
+
+	return failed, nil
+}
@@ -0,0 +1,384 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/tests"
+)
+
+// Allocate the bricks for a volume create and save a pending
+// operation for it, as if heketi stopped right after
+func createSamplePendingVolumeCreate(t *testing.T,
+	app *App) (*VolumeEntry, []*BrickEntry, *PendingOperationEntry) {
+
+	var clusters []string
+	err := app.db.View(func(tx *bolt.Tx) error {
+		var err error
+		clusters, err = ClusterList(tx)
+		return err
+	})
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(100)
+	v.Info.Cluster = clusters[0]
+	bricks, err := v.allocBricksInCluster(app.db, app.allocator, clusters[0], v.Info.Size)
+	tests.Assert(t, err == nil)
+
+	op := NewPendingOperationEntry(PENDING_OP_VOLUME_CREATE, v, bricks)
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return op.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	return v, bricks, op
+}
+
+func TestPendingOperationEntryMarshal(t *testing.T) {
+	v := createSampleVolumeEntry(100)
+	b := NewBrickEntry(10, 20, 5, "device1", "node1")
+	op := NewPendingOperationEntry(PENDING_OP_VOLUME_EXPAND, v, []*BrickEntry{b})
+	op.ExecutorDone = true
+
+	buffer, err := op.Marshal()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, buffer != nil)
+	tests.Assert(t, len(buffer) > 0)
+
+	um := &PendingOperationEntry{}
+	err = um.Unmarshal(buffer)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, um.Id == op.Id)
+	tests.Assert(t, um.Type == PENDING_OP_VOLUME_EXPAND)
+	tests.Assert(t, um.ExecutorDone)
+	tests.Assert(t, reflect.DeepEqual(um.Volume.Info, v.Info))
+	tests.Assert(t, len(um.Bricks) == 1)
+	tests.Assert(t, reflect.DeepEqual(um.Bricks[0], b))
+}
+
+func TestPendingOperationCompletedVolumeCreate(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// No operations are left behind
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+
+	err = v.Expand(app.db, app.executor, app.allocator, 100)
+	tests.Assert(t, err == nil)
+
+	app.xo.MockVolumeCreate = func(host string,
+		volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		return nil, ErrDbAccess
+	}
+	v = createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == ErrDbAccess)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		list, err := PendingOperationList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 0, list)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestPendingOperationRollBackVolumeCreate(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	_, bricks, _ := createSamplePendingVolumeCreate(t, app)
+	app.Close()
+
+	// Restart.  The executor steps were never completed, so the
+	// operation is rolled back
+	app = NewTestApp(tmpfile)
+	defer app.Close()
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		list, err := PendingOperationList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 0)
+
+		// Space has been returned to the devices
+		for _, brick := range bricks {
+			device, err := NewDeviceEntryFromId(tx, brick.Info.DeviceId)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, device.Info.Storage.Used == 0)
+			tests.Assert(t, device.Info.Storage.Free == device.Info.Storage.Total)
+			tests.Assert(t, len(device.Bricks) == 0)
+		}
+
+		volumes, err := VolumeList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(volumes) == 0)
+
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestPendingOperationRollForwardVolumeCreate(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Run all the executor steps
+	v, bricks, op := createSamplePendingVolumeCreate(t, app)
+	err = CreateBricks(app.db, app.executor, bricks)
+	tests.Assert(t, err == nil)
+	err = v.createVolume(app.db, app.executor, bricks)
+	tests.Assert(t, err == nil)
+	op.ExecutorDone = true
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return op.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	// Resolve
+	destroyed := 0
+	app.xo.MockBrickDestroy = func(host string, brick *executors.BrickRequest) error {
+		destroyed++
+		return nil
+	}
+	unresolved, err := PendingOperationsResolve(app.db, app.executor, false)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, unresolved == 0)
+	tests.Assert(t, destroyed == 0)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		list, err := PendingOperationList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 0)
+
+		volume, err := NewVolumeEntryFromId(tx, v.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(volume.Bricks) == len(bricks))
+		tests.Assert(t, volume.Info.Mount.GlusterFS.MountPoint != "")
+
+		for _, brick := range bricks {
+			_, err := NewBrickEntryFromId(tx, brick.Info.Id)
+			tests.Assert(t, err == nil)
+		}
+
+		cluster, err := NewClusterEntryFromId(tx, v.Info.Cluster)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(cluster.Info.Volumes) == 1)
+		tests.Assert(t, cluster.Info.Volumes[0] == v.Info.Id)
+
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestPendingOperationListOnly(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	_, _, op := createSamplePendingVolumeCreate(t, app)
+
+	// Nothing is changed
+	unresolved, err := PendingOperationsResolve(app.db, app.executor, true)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, unresolved == 1)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		list, err := PendingOperationList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 1)
+		tests.Assert(t, list[0] == op.Id)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestPendingOperationVolumeExpandStarted(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+
+	// The operation records that the bricks may be added before the
+	// executor adds them.  Stop as if heketi stopped while they were
+	// being added.
+	var op *PendingOperationEntry
+	app.xo.MockVolumeExpand = func(host string,
+		volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		err := app.db.View(func(tx *bolt.Tx) error {
+			list, err := PendingOperationList(tx)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, len(list) == 1, list)
+			op, err = NewPendingOperationEntryFromId(tx, list[0])
+			tests.Assert(t, err == nil)
+			return nil
+		})
+		tests.Assert(t, err == nil)
+		panic("stopped")
+	}
+	func() {
+		defer func() {
+			tests.Assert(t, recover() != nil)
+		}()
+		v.Expand(app.db, app.executor, app.allocator, 100)
+	}()
+	tests.Assert(t, op != nil && op.ExecutorStarted && !op.ExecutorDone, op)
+
+	// Rolling back does not destroy the bricks, which may be part of
+	// the volume
+	destroyed := 0
+	app.xo.MockBrickDestroy = func(host string, brick *executors.BrickRequest) error {
+		destroyed++
+		return nil
+	}
+	unresolved, err := PendingOperationsResolve(app.db, app.executor, false)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, unresolved == 0)
+	tests.Assert(t, destroyed == 0, destroyed)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		list, err := PendingOperationList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 0, list)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestPendingOperationRollForwardVolumeExpandChanged(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+	created := len(v.Bricks)
+
+	// The executor steps of the expansion completed
+	bricks, err := v.allocBricksInCluster(app.db, app.allocator, v.Info.Cluster, 100)
+	tests.Assert(t, err == nil)
+	op := NewPendingOperationEntry(PENDING_OP_VOLUME_EXPAND, v, bricks)
+	op.ExpandSize = 100
+	v.Info.Size += 100
+	op.ExecutorDone = true
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		return op.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	// Another operation saved the volume in the meantime
+	renamed := &VolumeEntry{}
+	renamed.Info.Id = v.Info.Id
+	err = renamed.Rename(app.db, "renamed")
+	tests.Assert(t, err == nil, err)
+
+	unresolved, err := PendingOperationsResolve(app.db, app.executor, false)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, unresolved == 0)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		list, err := PendingOperationList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 0, list)
+
+		volume, err := NewVolumeEntryFromId(tx, v.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, volume.Info.Name == "renamed", volume.Info.Name)
+		tests.Assert(t, volume.Info.Size == 200, volume.Info.Size)
+		tests.Assert(t, len(volume.Bricks) == created+len(bricks), volume.Bricks)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
@@ -0,0 +1,360 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/lpabon/godbc"
+)
+
+const (
+	BOLTDB_BUCKET_TOMBSTONE_PREFIX = "DELETED_"
+)
+
+var (
+	// Deleted clusters, nodes, devices and volumes are kept in the
+	// db until purged.  Bricks are always removed since failed
+	// allocations create and remove many of them.
+	TombstonesEnabled = false
+
+	// Days tombstones are kept by the periodic purge
+	TombstoneRetentionDays = 30
+
+	// Time between purges of the tombstones past the retention
+	tombstonePurgeInterval = time.Hour
+
+	// Buckets of the entries kept as tombstones by their type
+	tombstoneBuckets = map[string]string{
+		api.TombstoneCluster: BOLTDB_BUCKET_CLUSTER,
+		api.TombstoneNode:    BOLTDB_BUCKET_NODE,
+		api.TombstoneDevice:  BOLTDB_BUCKET_DEVICE,
+		api.TombstoneVolume:  BOLTDB_BUCKET_VOLUME,
+	}
+
+	// Users deleting entries in each transaction
+	txUsers     = make(map[*bolt.Tx]string)
+	txUsersLock sync.Mutex
+)
+
+// A deleted entry with its value as it was stored
+type tombstoneEntry struct {
+	Info api.Tombstone
+	Data []byte
+}
+
+// Records the user deleting entries in the transaction.  The returned
+// function must be called before the transaction ends.
+func setTxUser(tx *bolt.Tx, user string) func() {
+	txUsersLock.Lock()
+	defer txUsersLock.Unlock()
+
+	txUsers[tx] = user
+	return func() {
+		txUsersLock.Lock()
+		defer txUsersLock.Unlock()
+
+		delete(txUsers, tx)
+	}
+}
+
+func txUser(tx *bolt.Tx) string {
+	txUsersLock.Lock()
+	defer txUsersLock.Unlock()
+
+	return txUsers[tx]
+}
+
+func tombstoneBucket(tombstoneType string) (string, error) {
+	bucket, ok := tombstoneBuckets[tombstoneType]
+	if !ok {
+		return "", fmt.Errorf("Unknown tombstone type %v", tombstoneType)
+	}
+	return BOLTDB_BUCKET_TOMBSTONE_PREFIX + bucket, nil
+}
+
+// Deletes the entry, keeping it as a tombstone if tombstones are
+// enabled.  Only the entries of the tombstone types are kept.
+func EntryTombstone(tx *bolt.Tx, entry DbEntry, tombstoneType, key string) error {
+	godbc.Require(tx != nil)
+	godbc.Require(len(key) > 0)
+
+	if !TombstonesEnabled {
+		return EntryDelete(tx, entry, key)
+	}
+
+	data, err := EntryLoadRaw(tx, entry, key)
+	if err != nil {
+		return err
+	}
+	name, err := tombstoneBucket(tombstoneType)
+	if err != nil {
+		return err
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		logger.Err(err)
+		return err
+	}
+
+	// The record is json whatever the codec of the entries
+	buffer, err := json.Marshal(&tombstoneEntry{
+		Info: api.Tombstone{
+			Type:      tombstoneType,
+			Id:        key,
+			DeletedAt: time.Now().Unix(),
+			DeletedBy: txUser(tx),
+		},
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+	err = b.Put([]byte(key), buffer)
+	if err != nil {
+		logger.Err(err)
+		return err
+	}
+
+	return EntryDelete(tx, entry, key)
+}
+
+// Calls fn for each tombstone of the type, or of all types if empty
+func tombstonesForEach(tx *bolt.Tx, tombstoneType string,
+	fn func(b *bolt.Bucket, t *tombstoneEntry) error) error {
+
+	types := make([]string, 0, len(tombstoneBuckets))
+	if tombstoneType != "" {
+		types = append(types, tombstoneType)
+	} else {
+		for t := range tombstoneBuckets {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+	}
+
+	for _, t := range types {
+		name, err := tombstoneBucket(t)
+		if err != nil {
+			return err
+		}
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			continue
+		}
+
+		// Keys cannot be deleted while iterating the bucket
+		entries := make([]*tombstoneEntry, 0)
+		err = b.ForEach(func(k, v []byte) error {
+			entry := &tombstoneEntry{}
+			err := json.Unmarshal(v, entry)
+			if err != nil {
+				return fmt.Errorf("Unable to decode tombstone %v %v: %v", t, string(k), err)
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			err := fn(b, entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the tombstones of the type, or of all types if empty
+func TombstoneList(tx *bolt.Tx, tombstoneType string) ([]api.Tombstone, error) {
+	list := make([]api.Tombstone, 0)
+	err := tombstonesForEach(tx, tombstoneType,
+		func(b *bolt.Bucket, t *tombstoneEntry) error {
+			list = append(list, t.Info)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Removes the tombstones deleted at or before the time
+func TombstonePurge(tx *bolt.Tx, before time.Time) (int, error) {
+	purged := 0
+	err := tombstonesForEach(tx, "",
+		func(b *bolt.Bucket, t *tombstoneEntry) error {
+			if t.Info.DeletedAt > before.Unix() {
+				return nil
+			}
+			purged++
+			return b.Delete([]byte(t.Info.Id))
+		})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+func tombstoneLoad(tx *bolt.Tx, tombstoneType, id string) (*bolt.Bucket, *tombstoneEntry, error) {
+	name, err := tombstoneBucket(tombstoneType)
+	if err != nil {
+		return nil, nil, err
+	}
+	b := tx.Bucket([]byte(name))
+	if b == nil {
+		return nil, nil, ErrNotFound
+	}
+	val := b.Get([]byte(id))
+	if val == nil {
+		return nil, nil, ErrNotFound
+	}
+
+	entry := &tombstoneEntry{}
+	err = json.Unmarshal(val, entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, entry, nil
+}
+
+// Removes a single tombstone
+func TombstoneDelete(tx *bolt.Tx, tombstoneType, id string) error {
+	b, _, err := tombstoneLoad(tx, tombstoneType, id)
+	if err != nil {
+		return err
+	}
+	return b.Delete([]byte(id))
+}
+
+// Puts a deleted cluster or node back in the db.  Only the entry is
+// restored: a node is added back to its cluster without devices, and
+// nothing is changed in the storage.  A node can only be restored
+// to an existing cluster, and if its hostnames are not used.
+func TombstoneRestore(tx *bolt.Tx, tombstoneType, id string) error {
+	b, tombstone, err := tombstoneLoad(tx, tombstoneType, id)
+	if err != nil {
+		return err
+	}
+
+	switch tombstoneType {
+	case api.TombstoneCluster:
+		cluster := NewClusterEntry()
+		err := cluster.Unmarshal(tombstone.Data)
+		if err != nil {
+			return err
+		}
+		_, err = NewClusterEntryFromId(tx, id)
+		if err == nil {
+			return fmt.Errorf("Cluster %v already exists", id)
+		} else if err != ErrNotFound {
+			return err
+		}
+
+		// Nodes and volumes of the cluster are restored separately
+		cluster.Info.Nodes = make(sort.StringSlice, 0)
+		cluster.Info.Volumes = make(sort.StringSlice, 0)
+		err = cluster.Save(tx)
+		if err != nil {
+			return err
+		}
+
+	case api.TombstoneNode:
+		node := NewNodeEntry()
+		err := node.Unmarshal(tombstone.Data)
+		if err != nil {
+			return err
+		}
+		_, err = NewNodeEntryFromId(tx, id)
+		if err == nil {
+			return fmt.Errorf("Node %v already exists", id)
+		} else if err != ErrNotFound {
+			return err
+		}
+
+		cluster, err := NewClusterEntryFromId(tx, node.Info.ClusterId)
+		if err == ErrNotFound {
+			return fmt.Errorf("Cluster %v of node %v does not exist",
+				node.Info.ClusterId, id)
+		} else if err != nil {
+			return err
+		}
+		err = node.Register(tx)
+		if err != nil {
+			return err
+		}
+		cluster.NodeAdd(id)
+		err = cluster.Save(tx)
+		if err != nil {
+			return err
+		}
+
+		node.Devices = make(sort.StringSlice, 0)
+		err = node.Save(tx)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("Only clusters and nodes can be restored")
+	}
+
+	return b.Delete([]byte(id))
+}
+
+// Purges the tombstones older than the retention until stop is closed,
+// then closes done if it is not nil
+func purgeTombstonesEvery(db *bolt.DB, interval time.Duration,
+	stop <-chan struct{}, done chan<- struct{}) {
+	if done != nil {
+		defer close(done)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := db.Update(func(tx *bolt.Tx) error {
+				days := settings().TombstoneRetentionDays
+				purged, err := TombstonePurge(tx, time.Now().AddDate(0, 0, -days))
+				if err == nil && purged > 0 {
+					logger.Info("Purged %v tombstones older than %v days",
+						purged, days)
+				}
+				return err
+			})
+			if err != nil {
+				logger.LogError("Unable to purge tombstones: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
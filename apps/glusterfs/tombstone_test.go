@@ -0,0 +1,124 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/tests"
+)
+
+func TestEntryTombstone(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&TombstonesEnabled, true).Restore()
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	device := createSampleDeviceEntry("abc", 10*TB)
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		err := device.Save(tx)
+		if err != nil {
+			return err
+		}
+
+		defer setTxUser(tx, "admin")()
+		return device.Delete(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		_, err := NewDeviceEntryFromId(tx, device.Info.Id)
+		tests.Assert(t, err == ErrNotFound)
+
+		list, err := TombstoneList(tx, api.TombstoneDevice)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(list) == 1)
+		tests.Assert(t, list[0].Id == device.Info.Id)
+		tests.Assert(t, list[0].DeletedBy == "admin")
+
+		// The entry is kept as it was stored
+		_, tombstone, err := tombstoneLoad(tx, api.TombstoneDevice, device.Info.Id)
+		tests.Assert(t, err == nil)
+		deleted := NewDeviceEntry()
+		err = deleted.Unmarshal(tombstone.Data)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, deleted.Info.Name == device.Info.Name)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// The user is only known during the transaction
+	tests.Assert(t, len(txUsers) == 0)
+}
+
+func TestTombstoneRetention(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+	defer tests.Patch(&TombstonesEnabled, true).Restore()
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	cluster := createSampleClusterEntry()
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		err := cluster.Save(tx)
+		if err != nil {
+			return err
+		}
+		return cluster.Delete(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	countTombstones := func() int {
+		var list []api.Tombstone
+		err := app.db.View(func(tx *bolt.Tx) error {
+			var err error
+			list, err = TombstoneList(tx, "")
+			return err
+		})
+		tests.Assert(t, err == nil)
+		return len(list)
+	}
+
+	// Kept during the retention
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer tests.Patch(&tombstonePurgeInterval, 10*time.Millisecond).Restore()
+	go purgeTombstonesEvery(app.db, tombstonePurgeInterval, stop, done)
+	time.Sleep(50 * time.Millisecond)
+	tests.Assert(t, countTombstones() == 1)
+	close(stop)
+	<-done
+
+	// Purged once older than the retention
+	defer tests.Patch(&TombstoneRetentionDays, 0).Restore()
+	stop = make(chan struct{})
+	done = make(chan struct{})
+	go purgeTombstonesEvery(app.db, tombstonePurgeInterval, stop, done)
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	<-done
+	tests.Assert(t, countTombstones() == 0)
+}
@@ -0,0 +1,135 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/boltdb/bolt"
+)
+
+// Writes the topology of the db to w as a JSON array of the clusters,
+// in the same form as the clusters of api.TopologyInfoResponse.  The
+// clusters are read with a cursor, and each node and volume is written
+// as soon as it is loaded, so only one of them is held in memory at a
+// time however large the clusters are.
+func StreamTopology(db *bolt.DB, w io.Writer) error {
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BOLTDB_BUCKET_CLUSTER))
+		if b == nil {
+			return ErrDbAccess
+		}
+
+		if _, err := io.WriteString(w, "["); err != nil {
+			return err
+		}
+		c := b.Cursor()
+		written := 0
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			cluster := NewClusterEntry()
+			err := cluster.Unmarshal(v)
+			if err != nil {
+				return fmt.Errorf("Unable to decode cluster %v: %v", string(k), err)
+			}
+			if written > 0 {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			err = streamCluster(tx, cluster, w)
+			if err != nil {
+				return err
+			}
+			written++
+		}
+		_, err := io.WriteString(w, "]\n")
+		return err
+	})
+}
+
+func streamCluster(tx *bolt.Tx, cluster *ClusterEntry, w io.Writer) error {
+	id, err := json.Marshal(cluster.Info.Id)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, `{"id":%s,"nodes":[`, id)
+	if err != nil {
+		return err
+	}
+
+	for i, nodeId := range cluster.Info.Nodes {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return fmt.Errorf("Unable to load node %v of cluster %v: %v",
+				nodeId, cluster.Info.Id, err)
+		}
+		info, err := node.NewInfoReponse(tx)
+		if err != nil {
+			return err
+		}
+		err = streamElement(w, i, info)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(w, `],"volumes":[`); err != nil {
+		return err
+	}
+
+	written := 0
+	for _, volumeId := range cluster.Info.Volumes {
+		volume, err := NewVolumeEntryFromId(tx, volumeId)
+		if err != nil {
+			return fmt.Errorf("Unable to load volume %v of cluster %v: %v",
+				volumeId, cluster.Info.Id, err)
+		}
+		// Same as the topology returned by the client
+		if volume.Info.Cluster != cluster.Info.Id {
+			continue
+		}
+		info, err := volume.NewInfoResponse(tx)
+		if err != nil {
+			return err
+		}
+		err = streamElement(w, written, info)
+		if err != nil {
+			return err
+		}
+		written++
+	}
+
+	_, err = io.WriteString(w, "]}")
+	return err
+}
+
+// Writes an element of an array, after a comma unless it is the first
+func streamElement(w io.Writer, index int, element interface{}) error {
+	buffer, err := json.Marshal(element)
+	if err != nil {
+		return err
+	}
+	if index > 0 {
+		if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(buffer)
+	return err
+}
@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	client "github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/tests"
+)
+
+func TestStreamTopology(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+
+	// Setup the server
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	c := client.NewClientNoAuth(ts.URL)
+
+	// No clusters
+	var buffer bytes.Buffer
+	err := StreamTopology(app.db, &buffer)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, buffer.String() == "[]\n", buffer.String())
+
+	err = setupSampleDbWithTopology(app,
+		3,      // clusters
+		4,      // nodes_per_cluster
+		2,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	for i := 0; i < 3; i++ {
+		v := createSampleVolumeEntry(100)
+		err = v.Create(app.db, app.executor, app.allocator)
+		tests.Assert(t, err == nil, err)
+	}
+
+	buffer.Reset()
+	err = StreamTopology(app.db, &buffer)
+	tests.Assert(t, err == nil, err)
+
+	var streamed []api.Cluster
+	err = json.Unmarshal(buffer.Bytes(), &streamed)
+	tests.Assert(t, err == nil, err, buffer.String())
+
+	// Same as the topology assembled in memory by the client
+	topo, err := c.TopologyInfo()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(streamed) == 3)
+	tests.Assert(t, reflect.DeepEqual(streamed, topo.ClusterList),
+		streamed, topo.ClusterList)
+
+	volumes := 0
+	for _, cluster := range streamed {
+		tests.Assert(t, len(cluster.Nodes) == 4)
+		for _, node := range cluster.Nodes {
+			tests.Assert(t, len(node.DevicesInfo) == 2)
+		}
+		volumes += len(cluster.Volumes)
+	}
+	tests.Assert(t, volumes == 3)
+}
@@ -17,6 +17,9 @@
 package glusterfs
 
 import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
 	"github.com/heketi/heketi/executors"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
 )
@@ -49,6 +52,7 @@ func (d *VolumeDisperseDurability) BrickSizeGenerator(size uint64) func() (int,
 
 		var brick_size uint64
 
+		limits := settings()
 		for {
 			sets *= 2
 			brick_size = size / uint64(sets)
@@ -57,9 +61,9 @@ func (d *VolumeDisperseDurability) BrickSizeGenerator(size uint64) func() (int,
 			// number of data drives in the disperse request
 			brick_size /= uint64(d.Data)
 
-			if brick_size < BrickMinSize {
+			if brick_size < limits.BrickMinSize {
 				return 0, 0, ErrMininumBrickSize
-			} else if brick_size <= BrickMaxSize {
+			} else if brick_size <= limits.BrickMaxSize {
 				break
 			}
 		}
@@ -77,3 +81,32 @@ func (d *VolumeDisperseDurability) SetExecutorVolumeRequest(v *executors.VolumeR
 	v.Data = d.Data
 	v.Redundancy = d.Redundancy
 }
+
+// Returns an error unless one of the clusters has a node available
+// for each brick of a disperse set
+func checkDisperseNodes(tx *bolt.Tx, clusters []string,
+	d api.DisperseDurability) error {
+
+	needed := d.Data + d.Redundancy
+	most := 0
+	for _, clusterId := range clusters {
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		if err != nil {
+			return err
+		}
+		available, err := cluster.AvailableNodeCount(tx)
+		if err != nil {
+			return err
+		}
+		if available >= needed {
+			return nil
+		}
+		if available > most {
+			most = available
+		}
+	}
+
+	return fmt.Errorf("Dispersion %v+%v requires %v available nodes "+
+		"in a cluster, but at most %v are available",
+		d.Data, d.Redundancy, needed, most)
+}
@@ -45,13 +45,14 @@ func (r *VolumeReplicaDurability) BrickSizeGenerator(size uint64) func() (int, u
 
 		var brick_size uint64
 
+		limits := settings()
 		for {
 			sets *= 2
 			brick_size = size / uint64(sets)
 
-			if brick_size < BrickMinSize {
+			if brick_size < limits.BrickMinSize {
 				return 0, 0, ErrMininumBrickSize
-			} else if brick_size <= BrickMaxSize {
+			} else if brick_size <= limits.BrickMaxSize {
 				break
 			}
 		}
@@ -21,6 +21,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/heketi/heketi/executors"
@@ -45,9 +46,36 @@ const (
 )
 
 type VolumeEntry struct {
+	Revision
+
 	Info       api.VolumeInfo
 	Bricks     sort.StringSlice
 	Durability VolumeDurability
+
+	// CHAP secret encrypted with the configured key, and whether it
+	// was already retrieved
+	CHAPSecret          []byte
+	CHAPSecretRetrieved bool
+
+	// Stopped for maintenance.  Volumes are started when they are
+	// created, so older entries without it are started.
+	Stopped bool
+
+	// Name of the volume in GlusterFS, which keeps the name it was
+	// created with when it is renamed.  Empty until the volume is
+	// first renamed, as both names are the same until then.
+	GlusterName string
+
+	// Time in seconds since the epoch before which a WORM volume
+	// cannot be deleted, zero if it can be at any time
+	WORMRetainUntil int64
+
+	// User deleting the volume, not saved
+	deletedBy string
+
+	// Cancellation of the operation running on the volume, which its
+	// steps are reported to, not saved
+	cancel *operationCancel
 }
 
 func VolumeList(tx *bolt.Tx) ([]string, error) {
@@ -124,6 +152,32 @@ func NewVolumeEntryFromRequest(req *api.VolumeCreateRequest) *VolumeEntry {
 	// If it is zero, then it will be assigned during volume creation
 	vol.Info.Clusters = req.Clusters
 
+	// Set default storage class
+	if req.StorageClass == "" {
+		vol.Info.StorageClass = settings().DefaultStorageClass
+	} else {
+		vol.Info.StorageClass = req.StorageClass
+	}
+	vol.Info.Owner = req.Owner
+	vol.Info.DataLocality = req.DataLocality
+	vol.Info.PreferredNodeId = req.PreferredNodeId
+	vol.Info.ArbiterCount = req.ArbiterCount
+	vol.Info.SharedBricks = req.SharedBricks
+	vol.Info.Protocol = req.Protocol
+	if vol.Info.Protocol == "" {
+		vol.Info.Protocol = api.VolumeProtocolGlusterFS
+	}
+	vol.setWORM(req, time.Now())
+
+	// The secret is generated by setCHAPSecret
+	if req.CHAPAuth {
+		vol.Info.CHAPAuth = true
+		vol.Info.CHAPUsername = req.CHAPUsername
+		if vol.Info.CHAPUsername == "" {
+			vol.Info.CHAPUsername = vol.Info.Name
+		}
+	}
+
 	return vol
 }
 
@@ -143,15 +197,44 @@ func (v *VolumeEntry) BucketName() string {
 	return BOLTDB_BUCKET_VOLUME
 }
 
+// Name of the volume in GlusterFS, which is the one the executor
+// commands and the mounts must use
+func (v *VolumeEntry) glusterName() string {
+	if v.GlusterName != "" {
+		return v.GlusterName
+	}
+	return v.Info.Name
+}
+
 func (v *VolumeEntry) Save(tx *bolt.Tx) error {
 	godbc.Require(tx != nil)
 	godbc.Require(len(v.Info.Id) > 0)
 
-	return EntrySave(tx, v, v.Info.Id)
+	err := EntrySave(tx, v, v.Info.Id)
+	if err != nil {
+		return err
+	}
+
+	// Update cluster index
+	if v.Info.Cluster != "" {
+		return IndexAdd(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, v.Info.Cluster, v.Info.Id)
+	}
+
+	return nil
 }
 
 func (v *VolumeEntry) Delete(tx *bolt.Tx) error {
-	return EntryDelete(tx, v, v.Info.Id)
+	err := EntryTombstone(tx, v, api.TombstoneVolume, v.Info.Id)
+	if err != nil {
+		return err
+	}
+
+	// Update cluster index
+	if v.Info.Cluster != "" {
+		return IndexDelete(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, v.Info.Cluster, v.Info.Id)
+	}
+
+	return nil
 }
 
 func (v *VolumeEntry) NewInfoResponse(tx *bolt.Tx) (*api.VolumeInfoResponse, error) {
@@ -161,10 +244,24 @@ func (v *VolumeEntry) NewInfoResponse(tx *bolt.Tx) (*api.VolumeInfoResponse, err
 	info.Id = v.Info.Id
 	info.Cluster = v.Info.Cluster
 	info.Mount = v.Info.Mount
+	info.Mount.GlusterFS.BackupVolfileServers = v.backupVolfileServers()
 	info.Snapshot = v.Info.Snapshot
 	info.Size = v.Info.Size
 	info.Durability = v.Info.Durability
 	info.Name = v.Info.Name
+	info.CHAPAuth = v.Info.CHAPAuth
+	info.CHAPUsername = v.Info.CHAPUsername
+	info.DataLocality = v.Info.DataLocality
+	info.PreferredNodeId = v.Info.PreferredNodeId
+	info.ArbiterCount = v.Info.ArbiterCount
+	info.Protocol = v.protocol()
+	info.WORM = v.Info.WORM
+	info.WORMRetentionDays = v.Info.WORMRetentionDays
+	info.WORMRetentionMode = v.Info.WORMRetentionMode
+	if v.Info.Durability.Type == api.DurabilityEC {
+		info.DisperseData = v.Info.Durability.Disperse.Data
+		info.DisperseRedundancy = v.Info.Durability.Disperse.Redundancy
+	}
 
 	for _, brickid := range v.BricksIds() {
 		brick, err := NewBrickEntryFromId(tx, brickid)
@@ -231,97 +328,60 @@ func (v *VolumeEntry) Create(db *bolt.DB,
 		}
 	}()
 
-	// Get list of clusters
-	var possibleClusters, clusters []string
-	if len(v.Info.Clusters) == 0 {
-		err := db.View(func(tx *bolt.Tx) error {
-			var err error
-			possibleClusters, err = ClusterList(tx)
-			return err
-
-		})
-		if err != nil {
-			return err
-		}
-	} else {
-		possibleClusters = v.Info.Clusters
-	}
-
-	// Check we have clusters
-	if len(possibleClusters) == 0 {
-		logger.LogError("Volume being ask to be created, but there are no clusters configured")
-		return ErrNoSpace
+	// For each cluster look for storage space for this volume
+	v.cancel.step("allocating bricks")
+	brick_entries, err := v.allocBricksInClusters(db, allocator)
+	if err != nil {
+		return err
 	}
-	logger.Debug("Using the following clusters: %+v", clusters)
 
-	// Check for volume name conflict on any cluster
-	for _, cluster := range possibleClusters {
-		var err error
-
-		// Check this cluster does not have a volume with the name
-		err = db.View(func(tx *bolt.Tx) error {
-			ce, err := NewClusterEntryFromId(tx, cluster)
-			if err != nil {
-				return err
-			}
-
-			for _, volumeId := range ce.Info.Volumes {
-				volume, err := NewVolumeEntryFromId(tx, volumeId)
-				if err != nil {
-					return err
-				}
-				if v.Info.Name == volume.Info.Name {
-					return fmt.Errorf("Name %v already in use in cluster %v",
-						v.Info.Name, cluster)
-				}
-			}
-
-			return nil
-
-		})
-		if err != nil {
-			logger.Warning("%v", err.Error())
-		} else {
-			clusters = append(clusters, cluster)
+	// Volumes are created on clusters of mixed versions, as during
+	// upgrades, but some of their features may not work
+	db.View(func(tx *bolt.Tx) error {
+		warning, err := clusterVersionsWarning(tx, v.Info.Cluster)
+		if err == nil && warning != "" {
+			logger.Warning("%v", warning)
 		}
-	}
-	if len(clusters) == 0 {
-		return fmt.Errorf("Name %v is already in use in all available clusters", v.Info.Name)
-	}
-
-	// For each cluster look for storage space for this volume
-	var brick_entries []*BrickEntry
-	for _, cluster := range clusters {
-		var err error
-
-		// Check this cluster for space
-		brick_entries, err = v.allocBricksInCluster(db, allocator, cluster, v.Info.Size)
+		return nil
+	})
 
-		// Check if allocation was successfull
-		if err == nil {
-			v.Info.Cluster = cluster
-			logger.Debug("Volume to be created on cluster %v", cluster)
-			break
+	// Record the operation until it completes
+	op := NewPendingOperationEntry(PENDING_OP_VOLUME_CREATE, v, brick_entries)
+	defer func() {
+		if e != nil {
+			db.Update(func(tx *bolt.Tx) error {
+				return op.Delete(tx)
+			})
 		}
-	}
-	if brick_entries == nil {
-		return ErrNoSpace
-	}
+	}()
 
 	// Make sure to clean up bricks on error
 	defer func() {
 		if e != nil {
 			db.Update(func(tx *bolt.Tx) error {
 				for _, brick := range brick_entries {
-					v.removeBrickFromDb(tx, brick)
+					v.rollbackBrickFromDb(tx, brick)
 				}
 				return nil
 			})
 		}
 	}()
 
+	err = db.Update(func(tx *bolt.Tx) error {
+		return op.Save(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = v.cancel.checkpoint()
+	if err != nil {
+		return err
+	}
+
 	// Create the bricks on the nodes
-	err := CreateBricks(db, executor, brick_entries)
+	v.cancel.step("creating bricks")
+	err = createBricksReporting(db, executor, brick_entries, v.cancel)
 	if err != nil {
 		return err
 	}
@@ -333,7 +393,14 @@ func (v *VolumeEntry) Create(db *bolt.DB,
 		}
 	}()
 
+	// The volume is started once created, so clients may use it
+	err = v.cancel.commit("Volume " + v.Info.Id + " was already created and started")
+	if err != nil {
+		return err
+	}
+
 	// Create GlusterFS volume
+	v.cancel.step("creating the volume")
 	err = v.createVolume(db, executor, brick_entries)
 	if err != nil {
 		return err
@@ -346,6 +413,15 @@ func (v *VolumeEntry) Create(db *bolt.DB,
 		}
 	}()
 
+	// Only the db is left to be updated
+	op.ExecutorDone = true
+	err = db.Update(func(tx *bolt.Tx) error {
+		return op.Save(tx)
+	})
+	if err != nil {
+		return err
+	}
+
 	// Save information on db
 	err = db.Update(func(tx *bolt.Tx) error {
 
@@ -369,7 +445,13 @@ func (v *VolumeEntry) Create(db *bolt.DB,
 			return err
 		}
 		cluster.VolumeAdd(v.Info.Id)
-		return cluster.Save(tx)
+		err = cluster.Save(tx)
+		if err != nil {
+			return err
+		}
+
+		// Operation complete
+		return op.Delete(tx)
 	})
 	if err != nil {
 		return err
@@ -379,6 +461,101 @@ func (v *VolumeEntry) Create(db *bolt.DB,
 
 }
 
+// Allocates the bricks of the volume on the first of the clusters
+// it may be created on with the space for it, and sets the cluster
+// of the volume.  The bricks are added to the volume and to their
+// devices in the db.
+func (v *VolumeEntry) allocBricksInClusters(db txRunner,
+	allocator Allocator) ([]*BrickEntry, error) {
+
+	// Get list of clusters
+	var possibleClusters, clusters []string
+	if len(v.Info.Clusters) == 0 {
+		err := db.View(func(tx *bolt.Tx) error {
+			var err error
+			possibleClusters, err = ClusterList(tx)
+			return err
+
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		possibleClusters = v.Info.Clusters
+	}
+
+	// Check we have clusters
+	if len(possibleClusters) == 0 {
+		logger.LogError("Volume being ask to be created, but there are no clusters configured")
+		return nil, ErrNoSpace
+	}
+	logger.Debug("Using the following clusters: %+v", clusters)
+
+	// Check for volume name conflict on any cluster
+	for _, cluster := range possibleClusters {
+		var err error
+
+		// Check this cluster does not have a volume with the name
+		err = db.View(func(tx *bolt.Tx) error {
+			volumes, err := IndexList(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, cluster)
+			if err != nil {
+				return err
+			}
+
+			for _, volumeId := range volumes {
+				volume, err := NewVolumeEntryFromId(tx, volumeId)
+				if err != nil {
+					return err
+				}
+				if volume.nameInUse(v.Info.Name) {
+					return fmt.Errorf("Name %v already in use in cluster %v",
+						v.Info.Name, cluster)
+				}
+			}
+
+			return nil
+
+		})
+		if err != nil {
+			logger.Warning("%v", err.Error())
+		} else {
+			clusters = append(clusters, cluster)
+		}
+	}
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("Name %v is already in use in all available clusters", v.Info.Name)
+	}
+
+	// Volumes with data locality go to the cluster of the preferred
+	// node first, and only there if strict
+	if ordered, err := v.localityClusters(db, clusters); err != nil {
+		return nil, err
+	} else {
+		clusters = ordered
+	}
+
+	// For each cluster look for storage space for this volume
+	var brick_entries []*BrickEntry
+	for _, cluster := range clusters {
+		var err error
+
+		// Check this cluster for space
+		brick_entries, err = v.allocBricksInCluster(db, allocator, cluster, v.Info.Size)
+
+		// Check if allocation was successfull
+		if err == nil {
+			v.Info.Cluster = cluster
+			logger.Debug("Volume to be created on cluster %v", cluster)
+			break
+		}
+	}
+	if brick_entries == nil {
+		return nil, ErrNoSpace
+	}
+
+	return brick_entries, nil
+}
+
 func (v *VolumeEntry) Destroy(db *bolt.DB, executor executors.Executor) error {
 	logger.Info("Destroying volume %v", v.Info.Id)
 
@@ -408,7 +585,7 @@ func (v *VolumeEntry) Destroy(db *bolt.DB, executor executors.Executor) error {
 	})
 
 	// Determine if we can destroy the volume
-	err := executor.VolumeDestroyCheck(sshhost, v.Info.Name)
+	err := executor.VolumeDestroyCheck(sshhost, v.glusterName())
 	if err != nil {
 		logger.Err(err)
 		return err
@@ -423,13 +600,15 @@ func (v *VolumeEntry) Destroy(db *bolt.DB, executor executors.Executor) error {
 
 	// :TODO: What if the host is no longer available, we may need to try others
 	// Stop volume
-	err = executor.VolumeDestroy(sshhost, v.Info.Name)
+	v.cancel.step("deleting the volume")
+	err = executor.VolumeDestroy(sshhost, v.glusterName())
 	if err != nil {
 		logger.LogError("Unable to delete volume: %v", err)
 		return err
 	}
 
 	// Destroy bricks
+	v.cancel.step("destroying the bricks")
 	err = DestroyBricks(db, executor, brick_entries)
 	if err != nil {
 		logger.LogError("Unable to delete bricks: %v", err)
@@ -438,6 +617,7 @@ func (v *VolumeEntry) Destroy(db *bolt.DB, executor executors.Executor) error {
 
 	// Remove from entries from the db
 	err = db.Update(func(tx *bolt.Tx) error {
+		defer setTxUser(tx, v.deletedBy)()
 		for _, brick := range brick_entries {
 			err = v.removeBrickFromDb(tx, brick)
 			if err != nil {
@@ -475,32 +655,62 @@ func (v *VolumeEntry) Expand(db *bolt.DB,
 	allocator Allocator,
 	sizeGB int) (e error) {
 
+	if !v.IsStarted() {
+		return ErrVolumeStopped
+	}
+
 	// Allocate new bricks in the cluster
+	v.cancel.step("allocating bricks")
 	brick_entries, err := v.allocBricksInCluster(db, allocator, v.Info.Cluster, sizeGB)
 	if err != nil {
 		return err
 	}
 
+	// Record the operation until it completes.  Once the bricks are
+	// added GlusterFS owns them, and the operation is left for the
+	// next startup to finish instead of being rolled back.
+	op := NewPendingOperationEntry(PENDING_OP_VOLUME_EXPAND, v, brick_entries)
+	op.ExpandSize = sizeGB
+	defer func() {
+		if e != nil && !op.ExecutorDone {
+			db.Update(func(tx *bolt.Tx) error {
+				return op.Delete(tx)
+			})
+		}
+	}()
+
 	// Setup cleanup function
 	defer func() {
-		if e != nil {
+		if e != nil && !op.ExecutorDone {
 			logger.Debug("Error detected, cleaning up")
 
-			// Remove from db
+			// Remove from db.  The volume itself is only saved once
+			// the expansion completes, so it does not need to be saved.
 			db.Update(func(tx *bolt.Tx) error {
 				for _, brick := range brick_entries {
-					v.removeBrickFromDb(tx, brick)
+					v.rollbackBrickFromDb(tx, brick)
 				}
-				err := v.Save(tx)
-				godbc.Check(err == nil)
 
 				return nil
 			})
 		}
 	}()
 
+	err = db.Update(func(tx *bolt.Tx) error {
+		return op.Save(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = v.cancel.checkpoint()
+	if err != nil {
+		return err
+	}
+
 	// Create bricks
-	err = CreateBricks(db, executor, brick_entries)
+	v.cancel.step("creating bricks")
+	err = createBricksReporting(db, executor, brick_entries, v.cancel)
 	if err != nil {
 		logger.Err(err)
 		return err
@@ -508,12 +718,18 @@ func (v *VolumeEntry) Expand(db *bolt.DB,
 
 	// Setup cleanup function
 	defer func() {
-		if e != nil {
+		if e != nil && !op.ExecutorDone {
 			logger.Debug("Error detected, cleaning up")
 			DestroyBricks(db, executor, brick_entries)
 		}
 	}()
 
+	// Clients may use the new bricks as soon as they are added
+	err = v.cancel.commit("Bricks are already being added to volume " + v.Info.Id)
+	if err != nil {
+		return err
+	}
+
 	// Create a volume request to send to executor
 	// so that it can add the new bricks
 	vr, host, err := v.createVolumeRequest(db, brick_entries)
@@ -521,7 +737,17 @@ func (v *VolumeEntry) Expand(db *bolt.DB,
 		return err
 	}
 
+	// Record that the bricks may be added before adding them
+	op.ExecutorStarted = true
+	err = db.Update(func(tx *bolt.Tx) error {
+		return op.Save(tx)
+	})
+	if err != nil {
+		return err
+	}
+
 	// Expand the volume
+	v.cancel.step("adding the bricks")
 	_, err = executor.VolumeExpand(host, vr)
 	if err != nil {
 		return err
@@ -530,24 +756,70 @@ func (v *VolumeEntry) Expand(db *bolt.DB,
 	// Increase the recorded volume size
 	v.Info.Size += sizeGB
 
-	// Save volume entry
+	// Only the db is left to be updated
+	op.ExecutorDone = true
 	err = db.Update(func(tx *bolt.Tx) error {
+		return op.Save(tx)
+	})
+	if err != nil {
+		return err
+	}
 
-		// Save brick entries
-		for _, brick := range brick_entries {
-			err := brick.Save(tx)
+	// Save volume entry.  If another operation saved the volume since
+	// it was loaded, add the new bricks and size to the saved volume.
+	err = EntryRetryStale(func(retry bool) error {
+		return db.Update(func(tx *bolt.Tx) error {
+
+			if retry {
+				latest, err := NewVolumeEntryFromId(tx, v.Info.Id)
+				if err != nil {
+					return err
+				}
+				for _, brick := range brick_entries {
+					latest.BrickAdd(brick.Info.Id)
+				}
+				latest.Info.Size += sizeGB
+				*v = *latest
+			}
+
+			// Save brick entries
+			for _, brick := range brick_entries {
+				err := brick.Save(tx)
+				if err != nil {
+					return err
+				}
+			}
+
+			// The new bricks may be on other nodes
+			_, err := v.updateMountHosts(tx)
+			if err != nil {
+				return err
+			}
+
+			err = v.Save(tx)
 			if err != nil {
 				return err
 			}
-		}
 
-		return v.Save(tx)
+			// Operation complete
+			return op.Delete(tx)
+		})
 	})
 
 	return err
 
 }
 
+// Records whether the volume was started or stopped in GlusterFS.
+// Operations which need the volume running fail while it is stopped.
+func (v *VolumeEntry) SetStarted(started bool) {
+	v.Stopped = !started
+}
+
+func (v *VolumeEntry) IsStarted() bool {
+	return !v.Stopped
+}
+
 func (v *VolumeEntry) BricksIds() sort.StringSlice {
 	ids := make(sort.StringSlice, len(v.Bricks))
 	copy(ids, v.Bricks)
@@ -565,7 +837,9 @@ func (v *VolumeEntry) checkBricksCanBeDestroyed(db *bolt.DB,
 		sg.Add(1)
 		go func(b *BrickEntry) {
 			defer sg.Done()
-			sg.Err(b.DestroyCheck(db, executor))
+			sg.Err(recoverCall(logger, "BrickDestroyCheck", func() error {
+				return b.DestroyCheck(db, executor)
+			}))
 		}(brick)
 	}
 
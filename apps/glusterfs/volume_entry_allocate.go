@@ -18,10 +18,11 @@ package glusterfs
 
 import (
 	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/heketi/heketi/pkg/utils"
 )
 
-func (v *VolumeEntry) allocBricksInCluster(db *bolt.DB,
+func (v *VolumeEntry) allocBricksInCluster(db txRunner,
 	allocator Allocator,
 	cluster string,
 	gbsize int) ([]*BrickEntry, error) {
@@ -30,6 +31,37 @@ func (v *VolumeEntry) allocBricksInCluster(db *bolt.DB,
 	// space is found, or it is determined that the cluster is full
 	size := uint64(gbsize) * GB
 
+	// Bricks stay within the sizes of the storage policy of the
+	// cluster
+	var policy StoragePolicy
+	err := db.View(func(tx *bolt.Tx) error {
+		entry, err := NewClusterEntryFromId(tx, cluster)
+		if err != nil {
+			return err
+		}
+		policy = entry.StoragePolicy
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	minSize := settings().BrickMinSize
+	if policy.MinBrickSizeGB*GB > minSize {
+		minSize = policy.MinBrickSizeGB * GB
+	}
+
+	// Bricks are made smaller until the devices take them, unless
+	// none of the devices takes even the smallest bricks
+	takes, err := clusterTakesBrickSize(db, cluster, minSize)
+	if err != nil {
+		return nil, err
+	}
+	if !takes {
+		logger.Debug("No device of cluster %v takes bricks of %v KB",
+			cluster, minSize)
+		return nil, ErrNoSpace
+	}
+
 	// Setup a brick size generator
 	gen := v.Durability.BrickSizeGenerator(size)
 
@@ -48,12 +80,24 @@ func (v *VolumeEntry) allocBricksInCluster(db *bolt.DB,
 		logger.Debug("sets = %v", sets)
 
 		// Check that the volume does not have too many bricks
-		if (sets*v.Durability.BricksInSet() + len(v.Bricks)) > BrickMaxNum {
+		if (sets*v.Durability.BricksInSet() + len(v.Bricks)) > settings().BrickMaxNum {
 			logger.Debug("Maximum number of bricks reached")
 			// Try other clusters if possible
 			return nil, ErrMaxBricks
 		}
 
+		// Bricks larger than the policy of the cluster allows are
+		// split in more bricks, but smaller bricks are not made
+		// larger than the volume needs
+		if policy.MaxBrickSizeGB != 0 && brick_size > policy.MaxBrickSizeGB*GB {
+			logger.Debug("Brick size over the maximum of cluster %v", cluster)
+			continue
+		}
+		if brick_size < policy.MinBrickSizeGB*GB {
+			logger.Debug("Brick size under the minimum of cluster %v", cluster)
+			return nil, ErrMininumBrickSize
+		}
+
 		// Allocate bricks in the cluster
 		brick_entries, err := v.allocBricks(db, allocator, cluster, sets, brick_size)
 		if err == ErrNoSpace {
@@ -73,7 +117,7 @@ func (v *VolumeEntry) allocBricksInCluster(db *bolt.DB,
 }
 
 func (v *VolumeEntry) allocBricks(
-	db *bolt.DB,
+	db txRunner,
 	allocator Allocator,
 	cluster string,
 	bricksets int,
@@ -87,7 +131,7 @@ func (v *VolumeEntry) allocBricks(
 			logger.Debug("Error detected.  Cleaning up volume %v: Len(%v) ", v.Info.Id, len(brick_entries))
 			db.Update(func(tx *bolt.Tx) error {
 				for _, brick := range brick_entries {
-					v.removeBrickFromDb(tx, brick)
+					v.rollbackBrickFromDb(tx, brick)
 				}
 				return nil
 			})
@@ -104,17 +148,49 @@ func (v *VolumeEntry) allocBricks(
 		// Create a brick set list to later make sure that the
 		// proposed bricks and devices are acceptable
 		setlist := make([]*BrickEntry, 0)
+		setgroups := make(map[string]bool)
+		setzones := make(map[int]bool)
+
+		// Zones with the space for a brick of the set
+		roomyZones, err := zonesWithSpace(db, cluster, brick_size)
+		if err != nil {
+			return brick_entries, err
+		}
+
+		// The node kept for the arbiter brick of the set
+		tiebreakNodeId := ""
+		if v.Info.ArbiterCount != 0 {
+			err := db.View(func(tx *bolt.Tx) error {
+				node, err := v.TiebreakNode(tx, cluster, nil)
+				if node != nil {
+					tiebreakNodeId = node.Info.Id
+				}
+				return err
+			})
+			if err != nil {
+				return brick_entries, err
+			}
+		}
 
 		// Generate an id for the brick
 		brickId := utils.GenUUID()
 
 		// Get allocator generator
-		// The same generator should be used for the brick and its replicas
-		deviceCh, done, errc := allocator.GetNodes(cluster, brickId)
+		// The same generator should be used for the brick and its replicas.
+		// It starts from a position of the seedable source, rather than
+		// from the brick id, so that placement can be reproduced.
+		deviceCh, done, errc := allocator.GetNodes(cluster, allocationKey())
 		defer func() {
 			close(done)
 		}()
 
+		// Devices above the high watermark are only tried once the
+		// other devices from the allocator have been tried, and the
+		// devices of the node kept for the arbiter after them
+		deferred := make([]string, 0)
+		reserved := make([]string, 0)
+		allocatorDone := false
+
 		// Check location has space for each brick and its replicas
 		for i := 0; i < v.Durability.BricksInSet(); i++ {
 			logger.Debug("%v / %v", i, v.Durability.BricksInSet())
@@ -123,63 +199,202 @@ func (v *VolumeEntry) allocBricks(
 			// data does not change while determining brick location
 			err := db.Update(func(tx *bolt.Tx) error {
 
-				// Check the ring for devices to place the brick
-				for deviceId := range deviceCh {
+				// Returns true if the brick was placed on the device
+				tryDevice := func(device *DeviceEntry, size uint64) (bool, error) {
 
-					// Get device entry
-					device, err := NewDeviceEntryFromId(tx, deviceId)
-					if err != nil {
-						return err
+					// Nor bricks larger than the device takes, so
+					// that smaller bricks are tried next
+					if !device.TakesBrickSize(size) {
+						return false, nil
 					}
 
 					// Do not allow a device from the same node to be
 					// in the set
-					deviceOk := true
 					for _, brickInSet := range setlist {
 						if brickInSet.Info.NodeId == device.NodeId {
-							deviceOk = false
+							return false, nil
 						}
 					}
 
-					if !deviceOk {
-						continue
+					// Only use nodes of the storage class requested
+					// which are not reserved for another tenant
+					node, err := NewNodeEntryFromId(tx, device.NodeId)
+					if err != nil {
+						return false, err
+					}
+					if v.Info.StorageClass != "" &&
+						node.StorageClass() != v.Info.StorageClass {
+						return false, nil
+					}
+					if !node.AllowsOwner(v.Info.Owner) {
+						return false, nil
+					}
+
+					// Nor two nodes of the same affinity group
+					if setgroups[node.AffinityGroup()] {
+						return false, nil
 					}
 
-					// Try to allocate a brick on this device
-					brick := device.NewBrickEntry(brick_size, float64(v.Info.Snapshot.Factor))
+					// Try to share the mount point of a brick of the
+					// same size, or else to allocate a brick on this
+					// device
+					var brick *BrickEntry
+					if v.Info.SharedBricks && !v.isArbiterBrick(i) {
+						base, err := v.sharedBrickBase(tx, device, size)
+						if err != nil {
+							return false, err
+						}
+						if base != nil {
+							brick = device.NewSubDirBrickEntry(base,
+								"brick_"+utils.GenUUID())
+						}
+					}
+					if brick == nil {
+						brick = device.NewBrickEntry(size, float64(v.Info.Snapshot.Factor))
+					}
 
 					// Determine if it was successful
-					if brick != nil {
+					if brick == nil {
+						return false, nil
+					}
+
+					// If the first in the set, the reset the id
+					if i == 0 {
+						brick.SetId(brickId)
+					}
+					brick.Info.Arbiter = v.isArbiterBrick(i)
+					brick.Shareable = v.Info.SharedBricks
+
+					// Save the brick entry to create later
+					brick_entries = append(brick_entries, brick)
+
+					// Add to set list
+					setlist = append(setlist, brick)
+					setgroups[node.AffinityGroup()] = true
+					setzones[node.Info.Zone] = true
+
+					// Add brick to device
+					device.BrickAdd(brick.Id())
+					device.AllocationAdd(v.Info.Id, brick.Id(), brick.TotalSize())
 
-						// If the first in the set, the reset the id
-						if i == 0 {
-							brick.SetId(brickId)
+					// Add brick to volume
+					v.BrickAdd(brick.Id())
+
+					// Save values
+					err = device.Save(tx)
+					if err != nil {
+						return false, err
+					}
+					return true, nil
+				}
+
+				// The first brick of each set goes on the preferred
+				// node of a volume with data locality
+				if i == 0 && v.localityNodeId() != "" {
+					devices, err := v.localityDevices(tx, cluster)
+					if err != nil {
+						return err
+					}
+					for _, device := range devices {
+						placed, err := tryDevice(device, brick_size)
+						if err != nil || placed {
+							return err
 						}
+					}
+					if v.Info.DataLocality == api.VolumeDataLocalityStrict {
+						logger.Debug("Preferred node %v has no space for a brick of %v KB",
+							v.Info.PreferredNodeId, brick_size)
+						return ErrNoSpace
+					}
+				}
 
-						// Save the brick entry to create later
-						brick_entries = append(brick_entries, brick)
+				// The arbiter brick only stores metadata, and goes to
+				// the node with the least storage
+				if v.isArbiterBrick(i) {
+					size := settings().ArbiterBrickSize
+					if size > brick_size {
+						size = brick_size
+					}
+					return v.allocArbiterBrick(tx, cluster, size, tryDevice)
+				}
 
-						// Add to set list
-						setlist = append(setlist, brick)
+				// A brick without replicas goes to the zone with the
+				// fewest bricks, to keep the zones balanced over time,
+				// unless the device chosen does not take it
+				if v.Durability.BricksInSet() == 1 && v.localityNodeId() == "" {
+					device, err := SelectZoneBalancedDevice(tx, cluster, brick_size)
+					if err == nil {
+						placed, err := tryDevice(device, brick_size)
+						if err != nil || placed {
+							return err
+						}
+					} else if err != ErrNoSpace {
+						return err
+					}
+				}
 
-						// Add brick to device
-						device.BrickAdd(brick.Id())
+				// Check the ring for devices to place the brick
+				for deviceId := range deviceCh {
 
-						// Add brick to volume
-						v.BrickAdd(brick.Id())
+					// Get device entry
+					device, err := NewDeviceEntryFromId(tx, deviceId)
+					if err != nil {
+						return err
+					}
+
+					if device.NodeId == tiebreakNodeId {
+						reserved = append(reserved, deviceId)
+						continue
+					}
+					if device.AboveHighWatermark() {
+						deferred = append(deferred, deviceId)
+						continue
+					}
 
-						// Save values
-						err := device.Save(tx)
+					// Bricks of a set go to different zones while
+					// other zones have the space for them
+					if len(setzones) != 0 {
+						node, err := NewNodeEntryFromId(tx, device.NodeId)
 						if err != nil {
 							return err
 						}
-						return nil
+						if zoneTaken(node.Info.Zone, setzones, roomyZones) {
+							deferred = append(deferred, deviceId)
+							continue
+						}
+					}
+
+					placed, err := tryDevice(device, brick_size)
+					if err != nil || placed {
+						return err
 					}
 				}
 
 				// Check if allocator returned an error
-				if err := <-errc; err != nil {
-					return err
+				if !allocatorDone {
+					allocatorDone = true
+					if err := <-errc; err != nil {
+						return err
+					}
+				}
+
+				// Then try the devices deferred
+				for _, list := range []*[]string{&deferred, &reserved} {
+					for index, deviceId := range *list {
+						device, err := NewDeviceEntryFromId(tx, deviceId)
+						if err != nil {
+							return err
+						}
+
+						placed, err := tryDevice(device, brick_size)
+						if err != nil {
+							return err
+						}
+						if placed {
+							*list = append((*list)[:index], (*list)[index+1:]...)
+							return nil
+						}
+					}
 				}
 
 				// No devices found
@@ -196,6 +411,122 @@ func (v *VolumeEntry) allocBricks(
 
 }
 
+// Returns the zones of the cluster with at least the free space given
+// in KB
+func zonesWithSpace(db txRunner, clusterId string, size uint64) (map[int]bool, error) {
+	zones := make(map[int]bool)
+	err := db.View(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		if err != nil {
+			return err
+		}
+		info, err := cluster.ZoneInfo(tx)
+		if err != nil {
+			return err
+		}
+		for _, zone := range info {
+			if zone.StorageFree >= size {
+				zones[zone.ZoneId] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// Returns true if an online device of the cluster takes bricks of the
+// size given in KB
+func clusterTakesBrickSize(db txRunner, clusterId string, size uint64) (bool, error) {
+	takes := false
+	err := db.View(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, clusterId)
+		if err != nil {
+			return err
+		}
+		for _, nodeId := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			if err != nil {
+				return err
+			}
+			for _, deviceId := range node.Devices {
+				device, err := NewDeviceEntryFromId(tx, deviceId)
+				if err != nil {
+					return err
+				}
+				if device.isOnline() && device.TakesBrickSize(size) {
+					takes = true
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+	return takes, err
+}
+
+// Returns true if the zone already has a brick of the set and one of
+// the zones with space does not
+func zoneTaken(zone int, setzones, roomyZones map[int]bool) bool {
+	if !setzones[zone] {
+		return false
+	}
+	for z := range roomyZones {
+		if !setzones[z] {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the brick of the device with the least shared mount point
+// which a brick of the size can share, or nil.  Only created bricks
+// of other volumes with shared bricks are shared.
+func (v *VolumeEntry) sharedBrickBase(tx *bolt.Tx,
+	device *DeviceEntry,
+	size uint64) (*BrickEntry, error) {
+
+	var base *BrickEntry
+	for _, id := range device.Bricks {
+		if utils.SortedStringHas(v.Bricks, id) {
+			continue
+		}
+		brick, err := NewBrickEntryFromId(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !brick.Shareable || brick.Info.Arbiter ||
+			brick.Info.MountPoint == "" || brick.Info.Size != size {
+			continue
+		}
+		if base == nil || device.MountPoints[brick.Info.MountPoint] <
+			device.MountPoints[base.Info.MountPoint] {
+			base = brick
+		}
+	}
+	return base, nil
+}
+
+// Removes a brick whose allocation is rolled back, and its entry in
+// the allocation history of the device
+func (v *VolumeEntry) rollbackBrickFromDb(tx *bolt.Tx, brick *BrickEntry) error {
+	device, err := NewDeviceEntryFromId(tx, brick.Info.DeviceId)
+	if err != nil {
+		logger.Err(err)
+		return err
+	}
+	device.AllocationRemove(brick.Info.Id)
+	err = device.Save(tx)
+	if err != nil {
+		logger.Err(err)
+		return err
+	}
+
+	return v.removeBrickFromDb(tx, brick)
+}
+
 func (v *VolumeEntry) removeBrickFromDb(tx *bolt.Tx, brick *BrickEntry) error {
 
 	// Access device
@@ -206,7 +537,11 @@ func (v *VolumeEntry) removeBrickFromDb(tx *bolt.Tx, brick *BrickEntry) error {
 	}
 
 	// Deallocate space on device
-	device.StorageFree(brick.TotalSize())
+	err = device.BrickStorageFree(brick)
+	if err != nil {
+		logger.Err(err)
+		return err
+	}
 
 	// Delete brick from device
 	device.BrickDelete(brick.Info.Id)
@@ -0,0 +1,142 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// Size in KB of the arbiter bricks, which only store the metadata
+	// of the files.  Smaller bricks are allocated for volumes whose
+	// data bricks are smaller.
+	ArbiterBrickSize = uint64(1 * GB)
+)
+
+// Returns true if the brick of the index in its set is an arbiter
+func (v *VolumeEntry) isArbiterBrick(index int) bool {
+	return v.Info.ArbiterCount == 1 && index == v.Durability.BricksInSet()-1
+}
+
+// Online devices of a node and their free space in KB
+type tiebreakCandidate struct {
+	node    *NodeEntry
+	devices []*DeviceEntry
+	free    uint64
+}
+
+// Returns the online node of the cluster with the least free storage
+// on its online devices, other than the nodes excluded, to place an
+// arbiter brick on.  Returns nil when there is no such node.
+func (v *VolumeEntry) TiebreakNode(tx *bolt.Tx,
+	clusterId string,
+	exclude map[string]bool) (*NodeEntry, error) {
+
+	candidate, err := v.tiebreakCandidate(tx, clusterId, exclude)
+	if err != nil || candidate == nil {
+		return nil, err
+	}
+	return candidate.node, nil
+}
+
+func (v *VolumeEntry) tiebreakCandidate(tx *bolt.Tx,
+	clusterId string,
+	exclude map[string]bool) (*tiebreakCandidate, error) {
+
+	cluster, err := NewClusterEntryFromId(tx, clusterId)
+	if err != nil {
+		return nil, err
+	}
+
+	var least *tiebreakCandidate
+	for _, nodeId := range cluster.Info.Nodes {
+		if exclude[nodeId] {
+			continue
+		}
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return nil, err
+		}
+		if !node.isOnline() {
+			continue
+		}
+
+		c := &tiebreakCandidate{node: node}
+		for _, deviceId := range node.Devices {
+			device, err := NewDeviceEntryFromId(tx, deviceId)
+			if err != nil {
+				return nil, err
+			}
+			if !device.isOnline() {
+				continue
+			}
+			c.devices = append(c.devices, device)
+			c.free += device.Info.Storage.Free
+		}
+		if len(c.devices) == 0 {
+			continue
+		}
+
+		// The node id breaks ties so that the same node is chosen
+		if least == nil || c.free < least.free ||
+			(c.free == least.free && nodeId < least.node.Info.Id) {
+			least = c
+		}
+	}
+
+	return least, nil
+}
+
+// Places the arbiter brick of a set on the tiebreak node, trying its
+// devices with the least free space first, then on the next nodes
+// with the least storage.  Returns ErrNoSpace if no device takes it.
+func (v *VolumeEntry) allocArbiterBrick(tx *bolt.Tx,
+	clusterId string,
+	size uint64,
+	tryDevice func(device *DeviceEntry, size uint64) (bool, error)) error {
+
+	tried := make(map[string]bool)
+	for {
+		candidate, err := v.tiebreakCandidate(tx, clusterId, tried)
+		if err != nil {
+			return err
+		}
+		if candidate == nil {
+			logger.Debug("No node has space for an arbiter brick of %v KB", size)
+			return ErrNoSpace
+		}
+		tried[candidate.node.Info.Id] = true
+
+		sort.Sort(devicesByFree(candidate.devices))
+		for _, device := range candidate.devices {
+			placed, err := tryDevice(device, size)
+			if err != nil || placed {
+				return err
+			}
+		}
+	}
+}
+
+type devicesByFree []*DeviceEntry
+
+func (l devicesByFree) Len() int      { return len(l) }
+func (l devicesByFree) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l devicesByFree) Less(i, j int) bool {
+	return l[i].Info.Storage.Free < l[j].Info.Storage.Free
+}
@@ -0,0 +1,109 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/lpabon/godbc"
+)
+
+const (
+	// Random bytes in a CHAP secret.  Encoded in hex, the secret
+	// is within the 12 to 16 characters iSCSI initiators accept.
+	chapSecretBytes = 8
+)
+
+var (
+	// AES-256 key to encrypt the CHAP secrets in the db
+	chapSecretKey []byte
+)
+
+func setCHAPSecretKey(key string) {
+	sum := sha256.Sum256([]byte(key))
+	chapSecretKey = sum[:]
+}
+
+func chapCipher() (cipher.AEAD, error) {
+	if chapSecretKey == nil {
+		return nil, ErrCHAPNotConfigured
+	}
+
+	block, err := aes.NewCipher(chapSecretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Generate a random CHAP secret for the volume and save it encrypted
+func (v *VolumeEntry) setCHAPSecret() error {
+	godbc.Require(v.Info.CHAPAuth)
+
+	gcm, err := chapCipher()
+	if err != nil {
+		return err
+	}
+
+	secret := make([]byte, chapSecretBytes)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return err
+	}
+
+	// The nonce is saved in front of the encrypted secret
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	v.CHAPSecret = gcm.Seal(nonce, nonce, []byte(hex.EncodeToString(secret)), nil)
+	v.CHAPSecretRetrieved = false
+
+	return nil
+}
+
+// Returns the decrypted CHAP credentials of the volume
+func (v *VolumeEntry) CHAPCredentials() (*api.VolumeCHAPCredentials, error) {
+	godbc.Require(v.Info.CHAPAuth)
+
+	gcm, err := chapCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(v.CHAPSecret) < gcm.NonceSize() {
+		return nil, errors.New("CHAP secret is missing")
+	}
+	nonce := v.CHAPSecret[:gcm.NonceSize()]
+	secret, err := gcm.Open(nil, nonce, v.CHAPSecret[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.VolumeCHAPCredentials{
+		Username: v.Info.CHAPUsername,
+		Secret:   string(secret),
+	}, nil
+}
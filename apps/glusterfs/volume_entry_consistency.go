@@ -0,0 +1,118 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/lpabon/godbc"
+)
+
+// Differences between the bricks of a volume in the db and the
+// bricks reported by GlusterFS.  Bricks are listed as host:path.
+type ConsistencyReport struct {
+	Id            string   `json:"id"`
+	Consistent    bool     `json:"consistent"`
+	DbBricks      int      `json:"db_bricks"`
+	GlusterBricks int      `json:"gluster_bricks"`
+	MissingBricks []string `json:"missing_bricks"`
+	UnknownBricks []string `json:"unknown_bricks"`
+}
+
+// Compare the bricks of the volume in the db against the bricks
+// GlusterFS reports for the volume
+func (v *VolumeEntry) ConsistencyCheck(db *bolt.DB,
+	executor executors.Executor) (*ConsistencyReport, error) {
+
+	godbc.Require(db != nil)
+
+	// Get the bricks from the db
+	var sshhost string
+	dbBricks := make(map[string]bool)
+	err := db.View(func(tx *bolt.Tx) error {
+		for _, id := range v.BricksIds() {
+			brick, err := NewBrickEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+
+			node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+			if err != nil {
+				return err
+			}
+			if sshhost == "" {
+				sshhost = node.ManageHostName()
+			}
+
+			dbBricks[node.StorageHostName()+":"+brick.Info.Path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Err(err)
+		return nil, err
+	}
+	if sshhost == "" {
+		logger.LogError("Volume %v has no bricks", v.Info.Id)
+		return nil, ErrNotFound
+	}
+
+	// Get the bricks from GlusterFS
+	info, err := executor.VolumeInfo(sshhost, v.glusterName())
+	if err != nil {
+		logger.Err(err)
+		return nil, err
+	}
+
+	report := &ConsistencyReport{
+		Id:            v.Info.Id,
+		DbBricks:      len(dbBricks),
+		GlusterBricks: len(info.Bricks),
+		MissingBricks: make([]string, 0),
+		UnknownBricks: make([]string, 0),
+	}
+
+	glusterBricks := make(map[string]bool)
+	for _, brick := range info.Bricks {
+		name := brick.Host + ":" + brick.Path
+		glusterBricks[name] = true
+		if !dbBricks[name] {
+			report.UnknownBricks = append(report.UnknownBricks, name)
+		}
+	}
+	for name := range dbBricks {
+		if !glusterBricks[name] {
+			report.MissingBricks = append(report.MissingBricks, name)
+		}
+	}
+
+	// Every brick set must be complete
+	report.Consistent = len(report.MissingBricks) == 0 &&
+		len(report.UnknownBricks) == 0 &&
+		report.GlusterBricks%v.Durability.BricksInSet() == 0
+
+	if !report.Consistent {
+		logger.Warning("Volume %v is inconsistent: %v missing bricks, "+
+			"%v unknown bricks, %v bricks in GlusterFS",
+			v.Info.Id,
+			len(report.MissingBricks),
+			len(report.UnknownBricks),
+			report.GlusterBricks)
+	}
+
+	return report, nil
+}
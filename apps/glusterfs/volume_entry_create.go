@@ -50,20 +50,30 @@ func (v *VolumeEntry) createVolume(db *bolt.DB,
 	for _, brick := range vr.Bricks {
 		stringset.Add(brick.Host)
 	}
-	hosts := stringset.Strings()
+	v.setMountInfo(stringset.Strings())
+
+	godbc.Ensure(v.Info.Mount.GlusterFS.MountPoint != "")
+	return nil
+}
+
+// Set the information clients need to mount the volume from
+// the storage hosts of its bricks
+func (v *VolumeEntry) setMountInfo(hosts []string) {
+	godbc.Require(len(hosts) > 0)
+
 	v.Info.Mount.GlusterFS.Hosts = hosts
 
 	// Save volume information
 	v.Info.Mount.GlusterFS.MountPoint = fmt.Sprintf("%v:%v",
-		hosts[0], vr.Name)
+		hosts[0], v.glusterName())
 
 	// Set glusterfs mount volfile-servers options
-	v.Info.Mount.GlusterFS.Options = make(map[string]string)
+	if v.Info.Mount.GlusterFS.Options == nil {
+		v.Info.Mount.GlusterFS.Options = make(map[string]string)
+	}
+	v.Info.Mount.GlusterFS.BackupVolfileServers = append([]string{}, hosts[1:]...)
 	v.Info.Mount.GlusterFS.Options["backup-volfile-servers"] =
 		strings.Join(hosts[1:], ",")
-
-	godbc.Ensure(v.Info.Mount.GlusterFS.MountPoint != "")
-	return nil
 }
 
 func (v *VolumeEntry) createVolumeRequest(db *bolt.DB,
@@ -90,6 +100,8 @@ func (v *VolumeEntry) createVolumeRequest(db *bolt.DB,
 			if sshhost == "" {
 				sshhost = node.ManageHostName()
 			}
+			vr.GlusterVersion = olderGlusterVersion(vr.GlusterVersion,
+				node.Info.GlusterVersion)
 			vr.Bricks[i].Host = node.StorageHostName()
 			godbc.Check(vr.Bricks[i].Host != "")
 
@@ -102,8 +114,20 @@ func (v *VolumeEntry) createVolumeRequest(db *bolt.DB,
 	}
 
 	// Setup volume information in the request
-	vr.Name = v.Info.Name
+	vr.Name = v.glusterName()
 	v.Durability.SetExecutorVolumeRequest(vr)
+	vr.Arbiter = v.Info.ArbiterCount
+	vr.Protocol = v.executorProtocol()
+	v.setExecutorWORM(vr)
+
+	if v.Info.CHAPAuth {
+		creds, err := v.CHAPCredentials()
+		if err != nil {
+			return nil, "", err
+		}
+		vr.CHAPUsername = creds.Username
+		vr.CHAPSecret = creds.Secret
+	}
 
 	return vr, sshhost, nil
 }
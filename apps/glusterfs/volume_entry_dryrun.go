@@ -0,0 +1,57 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/lpabon/godbc"
+)
+
+// Returns where the bricks of the volume would be placed if it was
+// created now, without creating it.  The allocation reserves the space
+// of each brick before placing the next, so it is run in one read
+// transaction with its changes kept in an overlay, and the db itself
+// is not changed.  The allocator places bricks from random positions,
+// so the volume may be placed differently when it is created.
+func (v *VolumeEntry) DryRun(db *bolt.DB, allocator Allocator) (*api.DryRunResult, error) {
+	godbc.Require(db != nil)
+
+	var brick_entries []*BrickEntry
+	err := simulateOnDb(db, func(db txRunner) error {
+		var err error
+		brick_entries, err = v.allocBricksInClusters(db, allocator)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &api.DryRunResult{
+		Cluster: v.Info.Cluster,
+		Bricks:  make([]api.DryRunBrick, 0, len(brick_entries)),
+	}
+	for _, brick := range brick_entries {
+		result.Bricks = append(result.Bricks, api.DryRunBrick{
+			NodeId:   brick.Info.NodeId,
+			DeviceId: brick.Info.DeviceId,
+			Size:     brick.Info.Size,
+			Arbiter:  brick.Info.Arbiter,
+		})
+	}
+	return result, nil
+}
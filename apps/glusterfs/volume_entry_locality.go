@@ -0,0 +1,121 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+func isValidDataLocality(locality string) bool {
+	switch locality {
+	case "",
+		api.VolumeDataLocalityNone,
+		api.VolumeDataLocalityPrefer,
+		api.VolumeDataLocalityStrict:
+		return true
+	}
+	return false
+}
+
+// Returns the node the first brick of each set should be placed on,
+// or an empty string if the volume has no data locality
+func (v *VolumeEntry) localityNodeId() string {
+	switch v.Info.DataLocality {
+	case api.VolumeDataLocalityPrefer, api.VolumeDataLocalityStrict:
+		return v.Info.PreferredNodeId
+	}
+	return ""
+}
+
+// Moves the cluster of the preferred node first in the list of
+// clusters.  With strict locality only that cluster is returned.
+func (v *VolumeEntry) localityClusters(db txRunner, clusters []string) ([]string, error) {
+	nodeId := v.localityNodeId()
+	if nodeId == "" {
+		return clusters, nil
+	}
+
+	var clusterId string
+	err := db.View(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, nodeId)
+		if err != nil {
+			return err
+		}
+		clusterId = node.Info.ClusterId
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := make([]string, 0, len(clusters))
+	for _, id := range clusters {
+		if id == clusterId {
+			ordered = append(ordered, id)
+		}
+	}
+	if len(ordered) == 0 && v.Info.DataLocality == api.VolumeDataLocalityStrict {
+		logger.LogError("Preferred node %v of volume %v is not in the clusters %v",
+			nodeId, v.Info.Id, clusters)
+		return nil, ErrNoSpace
+	}
+	if v.Info.DataLocality == api.VolumeDataLocalityStrict {
+		return ordered, nil
+	}
+	for _, id := range clusters {
+		if id != clusterId {
+			ordered = append(ordered, id)
+		}
+	}
+
+	return ordered, nil
+}
+
+// Returns the online devices of the preferred node if it is an online
+// node of the cluster.  Devices above the high watermark are last.
+func (v *VolumeEntry) localityDevices(tx *bolt.Tx, cluster string) ([]*DeviceEntry, error) {
+	devices := make([]*DeviceEntry, 0)
+
+	node, err := NewNodeEntryFromId(tx, v.localityNodeId())
+	if err == ErrNotFound {
+		return devices, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if node.Info.ClusterId != cluster || !node.isOnline() {
+		return devices, nil
+	}
+
+	deferred := make([]*DeviceEntry, 0)
+	for _, deviceId := range node.Devices {
+		device, err := NewDeviceEntryFromId(tx, deviceId)
+		if err != nil {
+			return nil, err
+		}
+		if !device.isOnline() {
+			continue
+		}
+		if device.AboveHighWatermark() {
+			deferred = append(deferred, device)
+		} else {
+			devices = append(devices, device)
+		}
+	}
+
+	return append(devices, deferred...), nil
+}
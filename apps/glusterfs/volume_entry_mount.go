@@ -0,0 +1,251 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+var (
+	ErrUnknownMountOs = errors.New("Unknown operating system, must be " +
+		api.MountOsLinux + ", " + api.MountOsMac + " or " + api.MountOsWindows)
+	ErrNoMountHosts = errors.New("Volume has no hosts to mount it from")
+)
+
+// Returns the command which mounts the volume on a client running the
+// operating system.  Linux clients use the native client, which fails
+// over to the other hosts of the volume.  Mac and Windows clients have
+// no native client and mount the volume over NFS, which requires the
+// volume to be created with the nfs protocol.  If the address of the client is given,
+// the host with the address closest to it is mounted from.
+func (v *VolumeEntry) MountCommand(os, client string) (*api.VolumeMountResponse, error) {
+	if !v.IsStarted() {
+		return nil, ErrVolumeStopped
+	}
+
+	hosts := v.mountHosts()
+	if len(hosts) == 0 {
+		return nil, ErrNoMountHosts
+	}
+	hosts = sortHostsByDistance(hosts, net.ParseIP(client))
+
+	mount := &api.VolumeMountResponse{
+		Os:       os,
+		Server:   hosts[0],
+		Protocol: v.protocol(),
+	}
+	switch os {
+	case api.MountOsLinux:
+		options := v.mountOptions(hosts[1:])
+		cmd := "mount -t glusterfs "
+		if len(options) != 0 {
+			cmd += "-o " + strings.Join(options, ",") + " "
+		}
+		mount.Command = cmd + fmt.Sprintf("%v:/%v /mnt/%v",
+			hosts[0], v.glusterName(), v.Info.Name)
+	case api.MountOsMac:
+		mount.Command = fmt.Sprintf("mount -t nfs -o vers=3,tcp,resvport "+
+			"%v:/%v /Volumes/%v", hosts[0], v.glusterName(), v.Info.Name)
+	case api.MountOsWindows:
+		mount.Command = fmt.Sprintf(`mount -o nolock \\%v\%v *`,
+			hosts[0], v.glusterName())
+	default:
+		return nil, ErrUnknownMountOs
+	}
+
+	return mount, nil
+}
+
+// Volumes created before the hosts were saved only have the mount point
+// and the backup volfile servers
+func (v *VolumeEntry) mountHosts() []string {
+	glusterfs := v.Info.Mount.GlusterFS
+	if len(glusterfs.Hosts) != 0 {
+		return append([]string{}, glusterfs.Hosts...)
+	}
+	if glusterfs.MountPoint == "" {
+		return nil
+	}
+
+	hosts := []string{strings.SplitN(glusterfs.MountPoint, ":", 2)[0]}
+	if backup := glusterfs.Options["backup-volfile-servers"]; backup != "" {
+		hosts = append(hosts, strings.Split(backup, ",")...)
+	}
+	return hosts
+}
+
+// Returns the backup volfile servers of the volume.  Volumes created
+// before they were saved have them only in the mount options.
+func (v *VolumeEntry) backupVolfileServers() []string {
+	if backup := v.Info.Mount.GlusterFS.BackupVolfileServers; len(backup) != 0 {
+		return backup
+	}
+	if hosts := v.mountHosts(); len(hosts) > 1 {
+		return hosts[1:]
+	}
+	return []string{}
+}
+
+// Sets the mount hosts of the volume from the storage hostnames of
+// the nodes of its bricks.  The host of the mount point stays first
+// while it has a brick of the volume, as clients may have saved the
+// mount point.  Returns true if the hosts changed.
+func (v *VolumeEntry) updateMountHosts(tx *bolt.Tx) (bool, error) {
+	set := utils.NewStringSet()
+	for _, id := range v.Bricks {
+		brick, err := NewBrickEntryFromId(tx, id)
+		if err != nil {
+			return false, err
+		}
+		node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+		if err == ErrNotFound {
+			logger.Warning("Node %v of brick %v of volume %v does not exist",
+				brick.Info.NodeId, id, v.Info.Id)
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		set.Add(node.StorageHostName())
+	}
+	hosts := set.Strings()
+	if len(hosts) == 0 {
+		return false, nil
+	}
+
+	current := v.mountHosts()
+	if len(current) != 0 {
+		for i, host := range hosts {
+			if host == current[0] {
+				copy(hosts[1:i+1], hosts[:i])
+				hosts[0] = host
+				break
+			}
+		}
+	}
+	if reflect.DeepEqual(hosts, current) &&
+		reflect.DeepEqual(hosts[1:], v.Info.Mount.GlusterFS.BackupVolfileServers) {
+		return false, nil
+	}
+
+	logger.Info("Mount hosts of volume %v changed from %v to %v",
+		v.Info.Id, current, hosts)
+	v.setMountInfo(hosts)
+	return true, nil
+}
+
+// Updates the mount hosts of the volumes of the cluster after nodes
+// were added to or removed from it
+func updateClusterMountHosts(tx *bolt.Tx, cluster *ClusterEntry) error {
+	for _, id := range cluster.Info.Volumes {
+		volume, err := NewVolumeEntryFromId(tx, id)
+		if err != nil {
+			return err
+		}
+		changed, err := volume.updateMountHosts(tx)
+		if err != nil {
+			return err
+		}
+		if changed {
+			err = volume.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Options of the native client.  The backup volfile servers are
+// separated by colons on the mount command line.
+func (v *VolumeEntry) mountOptions(backup []string) []string {
+	options := make([]string, 0)
+	for key, value := range v.Info.Mount.GlusterFS.Options {
+		if key == "backup-volfile-servers" {
+			continue
+		}
+		options = append(options, key+"="+value)
+	}
+	sort.Strings(options)
+
+	if len(backup) != 0 {
+		options = append([]string{
+			"backup-volfile-servers=" + strings.Join(backup, ":"),
+		}, options...)
+	}
+
+	return options
+}
+
+type hostsByDistance struct {
+	hosts  []string
+	common []int
+}
+
+func (h hostsByDistance) Len() int           { return len(h.hosts) }
+func (h hostsByDistance) Less(i, j int) bool { return h.common[i] > h.common[j] }
+func (h hostsByDistance) Swap(i, j int) {
+	h.hosts[i], h.hosts[j] = h.hosts[j], h.hosts[i]
+	h.common[i], h.common[j] = h.common[j], h.common[i]
+}
+
+// Orders the hosts by the number of leading bits their addresses have in
+// common with the client.  Hosts which are not addresses are left last.
+func sortHostsByDistance(hosts []string, client net.IP) []string {
+	if client == nil {
+		return hosts
+	}
+
+	h := hostsByDistance{
+		hosts:  hosts,
+		common: make([]int, len(hosts)),
+	}
+	for i, host := range hosts {
+		h.common[i] = commonPrefixBits(net.ParseIP(host), client)
+	}
+	sort.Stable(h)
+
+	return hosts
+}
+
+// Returns -1 if a is not an address
+func commonPrefixBits(a, b net.IP) int {
+	if a == nil {
+		return -1
+	}
+
+	a, b = a.To16(), b.To16()
+	bits := 0
+	for i := range a {
+		x := a[i] ^ b[i]
+		for mask := byte(0x80); mask != 0; mask >>= 1 {
+			if x&mask != 0 {
+				return bits
+			}
+			bits++
+		}
+	}
+	return bits
+}
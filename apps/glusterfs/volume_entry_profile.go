@@ -0,0 +1,148 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/lpabon/godbc"
+)
+
+// Returns the manage hostname of a node with a brick of the volume
+// to run the gluster commands of the volume on
+func (v *VolumeEntry) manageHost(db *bolt.DB) (string, error) {
+	if len(v.Bricks) == 0 {
+		logger.LogError("Volume %v has no bricks", v.Info.Id)
+		return "", ErrNotFound
+	}
+
+	var sshhost string
+	err := db.View(func(tx *bolt.Tx) error {
+		brick, err := NewBrickEntryFromId(tx, v.Bricks[0])
+		if err != nil {
+			return err
+		}
+
+		node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+		if err != nil {
+			return err
+		}
+		sshhost = node.ManageHostName()
+		return nil
+	})
+	if err != nil {
+		logger.Err(err)
+		return "", err
+	}
+
+	return sshhost, nil
+}
+
+// Starts gathering statistics on the bricks of the volume
+func (v *VolumeEntry) ProfileStart(db *bolt.DB, executor executors.Executor) error {
+	godbc.Require(db != nil)
+
+	if !v.IsStarted() {
+		return ErrVolumeStopped
+	}
+
+	sshhost, err := v.manageHost(db)
+	if err != nil {
+		return err
+	}
+
+	err = executor.VolumeProfileStart(sshhost, v.glusterName())
+	if err != nil {
+		logger.Err(err)
+		return err
+	}
+
+	return nil
+}
+
+// Stops gathering statistics on the bricks of the volume.  GlusterFS
+// drops the statistics gathered so far.
+func (v *VolumeEntry) ProfileStop(db *bolt.DB, executor executors.Executor) error {
+	godbc.Require(db != nil)
+
+	if !v.IsStarted() {
+		return ErrVolumeStopped
+	}
+
+	sshhost, err := v.manageHost(db)
+	if err != nil {
+		return err
+	}
+
+	err = executor.VolumeProfileStop(sshhost, v.glusterName())
+	if err != nil {
+		logger.Err(err)
+		return err
+	}
+
+	return nil
+}
+
+// Returns the statistics gathered on the bricks of the volume since
+// profiling was started
+func (v *VolumeEntry) ProfileInfo(db *bolt.DB,
+	executor executors.Executor) (*api.VolumeProfileInfoResponse, error) {
+
+	godbc.Require(db != nil)
+
+	if !v.IsStarted() {
+		return nil, ErrVolumeStopped
+	}
+
+	sshhost, err := v.manageHost(db)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := executor.VolumeProfileInfo(sshhost, v.glusterName())
+	if err != nil {
+		logger.Err(err)
+		return nil, err
+	}
+
+	resp := &api.VolumeProfileInfoResponse{
+		Id:     v.Info.Id,
+		Bricks: make([]api.BrickProfileInfo, 0, len(info.Bricks)),
+	}
+	for _, brick := range info.Bricks {
+		b := api.BrickProfileInfo{
+			Brick:       brick.Name,
+			Duration:    brick.Duration,
+			DataRead:    brick.DataRead,
+			DataWritten: brick.DataWritten,
+			Fops:        make([]api.FopProfileInfo, 0, len(brick.Fops)),
+		}
+		for _, fop := range brick.Fops {
+			b.Fops = append(b.Fops, api.FopProfileInfo{
+				Name:       fop.Name,
+				Hits:       fop.Hits,
+				AvgLatency: fop.AvgLatency,
+				MinLatency: fop.MinLatency,
+				MaxLatency: fop.MaxLatency,
+			})
+		}
+		resp.Bricks = append(resp.Bricks, b)
+	}
+
+	return resp, nil
+}
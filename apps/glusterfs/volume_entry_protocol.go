@@ -0,0 +1,54 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+func isValidVolumeProtocol(protocol string) bool {
+	switch protocol {
+	case "",
+		api.VolumeProtocolGlusterFS,
+		api.VolumeProtocolNFS,
+		api.VolumeProtocolSMB:
+		return true
+	}
+	return false
+}
+
+// Volumes created before the protocol was saved only use the native
+// client
+func (v *VolumeEntry) protocol() string {
+	if v.Info.Protocol == "" {
+		return api.VolumeProtocolGlusterFS
+	}
+	return v.Info.Protocol
+}
+
+// Returns the protocol the executor exports the volume over besides
+// the native client
+func (v *VolumeEntry) executorProtocol() string {
+	switch v.protocol() {
+	case api.VolumeProtocolNFS:
+		return executors.VolumeProtocolNFS
+	case api.VolumeProtocolSMB:
+		return executors.VolumeProtocolSMB
+	}
+	return ""
+}
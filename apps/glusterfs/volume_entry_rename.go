@@ -0,0 +1,89 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/lpabon/godbc"
+)
+
+// Returns true if the volume is known by the name, either in heketi
+// or in GlusterFS
+func (v *VolumeEntry) nameInUse(name string) bool {
+	return v.Info.Name == name || v.glusterName() == name
+}
+
+func validateVolumeName(name string) error {
+	if name == "" {
+		return fmt.Errorf("Name must not be empty")
+	}
+	if strings.ContainsAny(name, " \t\n/:") {
+		return fmt.Errorf("Invalid name %v", name)
+	}
+	return nil
+}
+
+// Renames the volume in heketi.  GlusterFS cannot rename a volume, so
+// the volume keeps the name it was created with in GlusterFS, which
+// the mounts keep using, and the new name cannot be used by another
+// volume of the cluster in either.
+func (v *VolumeEntry) Rename(db *bolt.DB, name string) error {
+	godbc.Require(db != nil)
+
+	err := validateVolumeName(name)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		entry, err := NewVolumeEntryFromId(tx, v.Info.Id)
+		if err != nil {
+			return err
+		}
+
+		volumes, err := IndexList(tx, BOLTDB_BUCKET_INDEX_CLUSTER_VOLUMES, entry.Info.Cluster)
+		if err != nil {
+			return err
+		}
+		for _, id := range volumes {
+			if id == entry.Info.Id {
+				continue
+			}
+			volume, err := NewVolumeEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			if volume.nameInUse(name) {
+				return ErrNameInUse
+			}
+		}
+
+		logger.Info("Renaming volume %v from %v to %v", entry.Info.Id, entry.Info.Name, name)
+		entry.GlusterName = entry.glusterName()
+		entry.Info.Name = name
+		err = entry.Save(tx)
+		if err != nil {
+			return err
+		}
+
+		*v = *entry
+		return nil
+	})
+}
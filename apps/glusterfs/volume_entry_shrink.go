@@ -0,0 +1,297 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/heketi/heketi/executors"
+	"github.com/lpabon/godbc"
+)
+
+var (
+	// Time between the checks of the migration of the data off the
+	// bricks removed by a shrink
+	RemoveBricksPollInterval = 10 * time.Second
+
+	// Time a shrink waits for the data to migrate off the bricks
+	RemoveBricksPollTimeout = 6 * time.Hour
+)
+
+// Bricks of a replica or disperse set, and the space in KB the set
+// provides to the volume
+type brickSet struct {
+	bricks []*BrickEntry
+	size   uint64
+}
+
+// Returns the brick sets of the volume, in the order GlusterFS reports
+// them
+func (v *VolumeEntry) brickSets(db *bolt.DB,
+	executor executors.Executor,
+	sshhost string) ([]*brickSet, error) {
+
+	info, err := executor.VolumeInfo(sshhost, v.glusterName())
+	if err != nil {
+		return nil, err
+	}
+
+	inSet := v.Durability.BricksInSet()
+	if len(info.Bricks) == 0 || len(info.Bricks)%inSet != 0 {
+		return nil, fmt.Errorf("Volume %v has %v bricks in GlusterFS, "+
+			"which are not sets of %v bricks", v.Info.Id, len(info.Bricks), inSet)
+	}
+
+	// Bricks in the db, by host:path
+	bricks := make(map[string]*BrickEntry)
+	err = db.View(func(tx *bolt.Tx) error {
+		for _, id := range v.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+			if err != nil {
+				return err
+			}
+			bricks[node.StorageHostName()+":"+brick.Info.Path] = brick
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data := uint64(1)
+	if d, ok := v.Durability.(*VolumeDisperseDurability); ok {
+		data = uint64(d.Data)
+	}
+
+	sets := make([]*brickSet, 0, len(info.Bricks)/inSet)
+	for i := 0; i < len(info.Bricks); i += inSet {
+		set := &brickSet{}
+		for _, b := range info.Bricks[i : i+inSet] {
+			brick, ok := bricks[b.Host+":"+b.Path]
+			if !ok {
+				return nil, fmt.Errorf("Brick %v:%v of volume %v is not in the db, "+
+					"check the consistency of the volume", b.Host, b.Path, v.Info.Id)
+			}
+			set.bricks = append(set.bricks, brick)
+		}
+		set.size = set.bricks[0].Info.Size * data
+		sets = append(sets, set)
+	}
+
+	return sets, nil
+}
+
+type brickSetsBySize []*brickSet
+
+func (l brickSetsBySize) Len() int           { return len(l) }
+func (l brickSetsBySize) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l brickSetsBySize) Less(i, j int) bool { return l[i].size > l[j].size }
+
+// Returns the fewest sets which provide at least the space in GB, and
+// the space in GB they provide.  The largest sets are taken until one
+// set is enough for the space left, then the smallest such set, so
+// that as little space as possible is removed beyond the space asked.
+// The sets added last are taken first among sets of the same size.
+// At least one set is kept in the volume.
+func shrinkSets(sets []*brickSet, sizeGB int) ([]*brickSet, int, error) {
+	candidates := make([]*brickSet, len(sets))
+	for i, set := range sets {
+		candidates[len(sets)-1-i] = set
+	}
+	sort.Stable(brickSetsBySize(candidates))
+
+	wanted := uint64(sizeGB) * GB
+	removed := []*brickSet{}
+	reclaimed := uint64(0)
+	for reclaimed < wanted && len(removed) < len(sets)-1 {
+		pick := -1
+		for i, set := range candidates {
+			if reclaimed+set.size >= wanted &&
+				(pick == -1 || set.size < candidates[pick].size) {
+				pick = i
+			}
+		}
+		if pick == -1 {
+			pick = 0
+		}
+		set := candidates[pick]
+		candidates = append(candidates[:pick], candidates[pick+1:]...)
+		removed = append(removed, set)
+		reclaimed += set.size
+	}
+	if reclaimed < wanted {
+		return nil, 0, ErrShrinkBelowMinimum
+	}
+
+	// Rounded, as the bricks may be slightly smaller than the size
+	// they were allocated for
+	return removed, int((reclaimed + GB/2) / GB), nil
+}
+
+// Removes bricks from the volume to reduce its size by at least the
+// size given.  The data on the bricks is migrated to the other bricks
+// of the volume by GlusterFS before they are removed, so whole replica
+// or disperse sets are removed, the fewest which provide the space.
+// The volume is reduced by the space of the sets removed, which may be
+// more than asked.  Returns ErrShrinkBelowMinimum if the volume would
+// be left without bricks or without space.
+func (v *VolumeEntry) Shrink(db *bolt.DB,
+	executor executors.Executor,
+	sizeGB int) error {
+
+	godbc.Require(db != nil)
+	godbc.Require(sizeGB > 0)
+
+	if !v.IsStarted() {
+		return ErrVolumeStopped
+	}
+	if sizeGB >= v.Info.Size {
+		return ErrShrinkBelowMinimum
+	}
+
+	sshhost, err := v.manageHost(db)
+	if err != nil {
+		return err
+	}
+
+	v.cancel.step("finding the bricks to remove")
+	sets, err := v.brickSets(db, executor, sshhost)
+	if err != nil {
+		logger.Err(err)
+		return err
+	}
+	removed, reclaimedGB, err := shrinkSets(sets, sizeGB)
+	if err != nil {
+		return err
+	}
+	if reclaimedGB >= v.Info.Size {
+		return ErrShrinkBelowMinimum
+	}
+
+	brick_entries := []*BrickEntry{}
+	bricks := []executors.BrickInfo{}
+	err = db.View(func(tx *bolt.Tx) error {
+		for _, set := range removed {
+			for _, brick := range set.bricks {
+				node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+				if err != nil {
+					return err
+				}
+				brick_entries = append(brick_entries, brick)
+				bricks = append(bricks, executors.BrickInfo{
+					Host: node.StorageHostName(),
+					Path: brick.Info.Path,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = v.cancel.checkpoint()
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Removing %v bricks of volume %v to reclaim %v GB",
+		len(bricks), v.Info.Id, reclaimedGB)
+	err = executor.VolumeRemoveBricksStart(sshhost, v.glusterName(), bricks)
+	if err != nil {
+		return err
+	}
+
+	// Until the removal is committed, the migration can be stopped
+	// with the bricks left in the volume
+	v.cancel.step("migrating data off the bricks")
+	err = pollProgress(v.cancel, RemoveBricksPollInterval, RemoveBricksPollTimeout, func() (bool, error) {
+		status, err := executor.VolumeRemoveBricksStatus(sshhost, v.glusterName(), bricks)
+		if err != nil {
+			return false, err
+		}
+		v.cancel.progress(status.NodesCompleted, status.Nodes,
+			fmt.Sprintf("%v files migrated", status.Files))
+		return status.Completed, nil
+	})
+	if err == nil {
+		err = v.cancel.commit("The bricks of volume " + v.Info.Id + " are being removed")
+	}
+	if err != nil {
+		logger.Info("Stopping the removal of bricks of volume %v: %v", v.Info.Id, err)
+		if serr := executor.VolumeRemoveBricksStop(sshhost, v.glusterName(), bricks); serr != nil {
+			logger.LogError("Unable to stop the removal of bricks of volume %v: %v",
+				v.Info.Id, serr)
+		}
+		return err
+	}
+
+	v.cancel.step("removing the bricks")
+	err = executor.VolumeRemoveBricksCommit(sshhost, v.glusterName(), bricks)
+	if err != nil {
+		return err
+	}
+
+	// The bricks are out of the volume.  If another operation saved the
+	// volume since it was loaded, remove them from the saved volume.
+	err = EntryRetryStale(func(retry bool) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			if retry {
+				latest, err := NewVolumeEntryFromId(tx, v.Info.Id)
+				if err != nil {
+					return err
+				}
+				*v = *latest
+			}
+
+			for _, brick := range brick_entries {
+				err := v.removeBrickFromDb(tx, brick)
+				if err != nil {
+					return err
+				}
+			}
+			v.Info.Size -= reclaimedGB
+
+			_, err := v.updateMountHosts(tx)
+			if err != nil {
+				return err
+			}
+
+			return v.Save(tx)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// The space is already returned to the devices, the logical
+	// volumes left behind can be removed by hand
+	err = DestroyBricks(db, executor, brick_entries)
+	if err != nil {
+		logger.LogError("Unable to destroy the bricks removed from volume %v: %v",
+			v.Info.Id, err)
+	}
+
+	return nil
+}
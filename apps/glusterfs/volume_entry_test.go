@@ -23,7 +23,9 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/heketi/heketi/executors"
@@ -100,6 +102,362 @@ func setupSampleDbWithTopology(app *App,
 	return nil
 }
 
+func TestVolumeEntryAllocateHighWatermarkLast(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		2,      // nodes_per_cluster
+		1,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Fill the first device above the high watermark
+	var full, empty string
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		devices, err := DeviceList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(devices) == 2)
+		full, empty = devices[0], devices[1]
+
+		device, err := NewDeviceEntryFromId(tx, full)
+		tests.Assert(t, err == nil)
+		device.Info.Storage.Free = 50 * GB
+		device.Info.Storage.Used = device.Info.Storage.Total - 50*GB
+		return device.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	deviceOfBricks := func(v *VolumeEntry) map[string]bool {
+		devices := make(map[string]bool)
+		err := app.db.View(func(tx *bolt.Tx) error {
+			for _, id := range v.Bricks {
+				brick, err := NewBrickEntryFromId(tx, id)
+				tests.Assert(t, err == nil)
+				devices[brick.Info.DeviceId] = true
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil)
+		return devices
+	}
+
+	// The other device is used whatever the position in the ring
+	for i := 0; i < 5; i++ {
+		req := &api.VolumeCreateRequest{}
+		req.Size = 10
+		req.Durability.Type = api.DurabilityDistributeOnly
+		v := NewVolumeEntryFromRequest(req)
+		err = v.Create(app.db, app.executor, app.allocator)
+		tests.Assert(t, err == nil, err)
+
+		devices := deviceOfBricks(v)
+		tests.Assert(t, len(devices) == 1 && devices[empty], devices)
+	}
+
+	// The device above the watermark is still used when needed
+	v := createSampleVolumeEntry(10)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	devices := deviceOfBricks(v)
+	tests.Assert(t, devices[full] && devices[empty], devices)
+}
+
+func TestVolumeEntryAllocateAcrossZones(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	// Two nodes in zone 0 and one in zone 1
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		3,      // nodes_per_cluster
+		1,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	zonesOfBricks := func(v *VolumeEntry) []int {
+		zones := []int{}
+		err := app.db.View(func(tx *bolt.Tx) error {
+			for _, id := range v.Bricks {
+				brick, err := NewBrickEntryFromId(tx, id)
+				tests.Assert(t, err == nil)
+				node, err := NewNodeEntryFromId(tx, brick.Info.NodeId)
+				tests.Assert(t, err == nil)
+				zones = append(zones, node.Info.Zone)
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil)
+		sort.Ints(zones)
+		return zones
+	}
+
+	// The bricks of each of the two sets are in different zones
+	// whatever the position in the ring
+	for i := 0; i < 10; i++ {
+		v := createSampleVolumeEntry(10)
+		err = v.Create(app.db, app.executor, app.allocator)
+		tests.Assert(t, err == nil, err)
+		zones := zonesOfBricks(v)
+		tests.Assert(t, reflect.DeepEqual(zones, []int{0, 0, 1, 1}), zones)
+	}
+
+	// Both go to the same zone when the other zone is full
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		devices, err := DeviceList(tx)
+		tests.Assert(t, err == nil)
+		for _, id := range devices {
+			device, err := NewDeviceEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			node, err := NewNodeEntryFromId(tx, device.NodeId)
+			tests.Assert(t, err == nil)
+			if node.Info.Zone == 1 {
+				device.Info.Storage.Free = 0
+				device.Info.Storage.Used = device.Info.Storage.Total
+				tests.Assert(t, device.Save(tx) == nil)
+			}
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(10)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	zones := zonesOfBricks(v)
+	tests.Assert(t, reflect.DeepEqual(zones, []int{0, 0, 0, 0}), zones)
+}
+
+func TestVolumeEntryCreateArbiter(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		3,      // nodes_per_cluster
+		2,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// A fourth node with less storage
+	var smallNode string
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		tests.Assert(t, err == nil)
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		tests.Assert(t, err == nil)
+
+		node := createSampleNodeEntry()
+		node.Info.ClusterId = cluster.Info.Id
+		cluster.NodeAdd(node.Info.Id)
+		smallNode = node.Info.Id
+		for d := 0; d < 2; d++ {
+			device := createSampleDeviceEntry(node.Info.Id, 100*GB)
+			node.DeviceAdd(device.Id())
+			tests.Assert(t, app.allocator.AddDevice(cluster, node, device) == nil)
+			tests.Assert(t, device.Save(tx) == nil)
+		}
+		tests.Assert(t, node.Save(tx) == nil)
+		tests.Assert(t, cluster.Save(tx) == nil)
+
+		tiebreak, err := NewVolumeEntry().TiebreakNode(tx, cluster.Info.Id, nil)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, tiebreak.Info.Id == smallNode)
+		tiebreak, err = NewVolumeEntry().TiebreakNode(tx, cluster.Info.Id,
+			map[string]bool{smallNode: true})
+		tests.Assert(t, err == nil)
+		tests.Assert(t, tiebreak.Info.Id != smallNode)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	var request *executors.VolumeRequest
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		request = volume
+		return &executors.VolumeInfo{}, nil
+	}
+
+	req := &api.VolumeCreateRequest{}
+	req.Size = 100
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 3
+	req.ArbiterCount = 1
+	v := NewVolumeEntryFromRequest(req)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, request.Replica == 3)
+	tests.Assert(t, request.Arbiter == 1)
+
+	// The last brick of each set is a small arbiter on the node with
+	// the least storage
+	tests.Assert(t, len(request.Bricks) == 6, request.Bricks)
+	err = app.db.View(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, smallNode)
+		tests.Assert(t, err == nil)
+		for i, b := range request.Bricks {
+			tests.Assert(t, (b.Host == node.StorageHostName()) == (i%3 == 2),
+				i, request.Bricks)
+		}
+
+		arbiters := 0
+		for _, id := range v.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			if brick.Info.Arbiter {
+				arbiters++
+				tests.Assert(t, brick.Info.NodeId == smallNode)
+				tests.Assert(t, brick.Info.Size == ArbiterBrickSize, brick.Info.Size)
+			} else {
+				tests.Assert(t, brick.Info.NodeId != smallNode)
+				tests.Assert(t, brick.Info.Size == 50*GB, brick.Info.Size)
+			}
+		}
+		tests.Assert(t, arbiters == 2)
+
+		info, err := v.NewInfoResponse(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, info.ArbiterCount == 1)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestVolumeEntryCreateGlusterVersion(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var request *executors.VolumeRequest
+	app.xo.MockVolumeCreate = func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		request = volume
+		return &executors.VolumeInfo{}, nil
+	}
+	setVersions := func(versions ...string) {
+		err := app.db.Update(func(tx *bolt.Tx) error {
+			clusters, err := ClusterList(tx)
+			tests.Assert(t, err == nil)
+			cluster, err := NewClusterEntryFromId(tx, clusters[0])
+			tests.Assert(t, err == nil)
+			for i, id := range cluster.Info.Nodes {
+				node, err := NewNodeEntryFromId(tx, id)
+				tests.Assert(t, err == nil)
+				node.Info.GlusterVersion = versions[i]
+				tests.Assert(t, node.Save(tx) == nil)
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil)
+	}
+	create := func() string {
+		req := &api.VolumeCreateRequest{}
+		req.Size = 100
+		req.Durability.Type = api.DurabilityReplicate
+		req.Durability.Replicate.Replica = 3
+		v := NewVolumeEntryFromRequest(req)
+		err := v.Create(app.db, app.executor, app.allocator)
+		tests.Assert(t, err == nil, err)
+		return request.GlusterVersion
+	}
+
+	// The oldest version of the nodes of the bricks
+	setVersions("3.12.2", "3.10.1", "3.12.15")
+	version := create()
+	tests.Assert(t, version == "3.10.1", version)
+
+	// Unknown versions are ignored
+	setVersions("3.12.2", "", "3.12.15")
+	version = create()
+	tests.Assert(t, version == "3.12.2", version)
+
+	setVersions("", "", "")
+	version = create()
+	tests.Assert(t, version == "", version)
+}
+
+func TestVolumeEntryCreateDeviceMaxBrickSize(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		3,      // nodes_per_cluster
+		2,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	setMaxBrickSize := func(size uint64) {
+		err := app.db.Update(func(tx *bolt.Tx) error {
+			devices, err := DeviceList(tx)
+			tests.Assert(t, err == nil)
+			for _, id := range devices {
+				device, err := NewDeviceEntryFromId(tx, id)
+				tests.Assert(t, err == nil)
+				device.Info.MaxBrickSize = size
+				tests.Assert(t, device.Save(tx) == nil)
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil)
+	}
+
+	// Bricks are made smaller until the devices take them
+	setMaxBrickSize(10 * GB)
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(v.Bricks) == 32, len(v.Bricks))
+	err = app.db.View(func(tx *bolt.Tx) error {
+		for _, id := range v.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			tests.Assert(t, brick.Info.Size == 100*GB/16, brick.Info.Size)
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// No device takes the smallest bricks
+	setMaxBrickSize(BrickMinSize / 2)
+	v2 := createSampleVolumeEntry(100)
+	err = v2.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == ErrNoSpace, err)
+
+	err = v.Expand(app.db, app.executor, app.allocator, 10)
+	tests.Assert(t, err == ErrNoSpace, err)
+}
+
 func TestNewVolumeEntry(t *testing.T) {
 	v := NewVolumeEntry()
 
@@ -219,6 +577,26 @@ func TestNewVolumeEntryFromRequestName(t *testing.T) {
 
 }
 
+func TestNewVolumeEntryFromRequestStorageClass(t *testing.T) {
+
+	req := &api.VolumeCreateRequest{}
+	req.Size = 1024
+
+	v := NewVolumeEntryFromRequest(req)
+	tests.Assert(t, v.Info.StorageClass == "")
+
+	// Default storage class
+	defer func(c string) { DefaultStorageClass = c }(DefaultStorageClass)
+	DefaultStorageClass = api.StorageClassHdd
+	v = NewVolumeEntryFromRequest(req)
+	tests.Assert(t, v.Info.StorageClass == api.StorageClassHdd)
+
+	// Requested storage class
+	req.StorageClass = api.StorageClassSsd
+	v = NewVolumeEntryFromRequest(req)
+	tests.Assert(t, v.Info.StorageClass == api.StorageClassSsd)
+}
+
 func TestNewVolumeEntryMarshal(t *testing.T) {
 
 	req := &api.VolumeCreateRequest{}
@@ -244,6 +622,43 @@ func TestNewVolumeEntryMarshal(t *testing.T) {
 
 }
 
+func TestVolumeEntryStartedPersistence(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	v := createSampleVolumeEntry(1024)
+	tests.Assert(t, v.IsStarted())
+
+	v.SetStarted(false)
+	tests.Assert(t, !v.IsStarted())
+	err := app.db.Update(func(tx *bolt.Tx) error {
+		return v.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		entry, err := NewVolumeEntryFromId(tx, v.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, !entry.IsStarted())
+
+		entry.SetStarted(true)
+		return entry.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	err = app.db.View(func(tx *bolt.Tx) error {
+		entry, err := NewVolumeEntryFromId(tx, v.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, entry.IsStarted())
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
 func TestVolumeEntryAddDeleteDevices(t *testing.T) {
 
 	v := NewVolumeEntry()
@@ -1344,7 +1759,7 @@ func TestVolumeEntryExpand(t *testing.T) {
 	tests.Assert(t, reflect.DeepEqual(entry, v))
 }
 
-func TestVolumeEntryDoNotAllowDeviceOnSameNode(t *testing.T) {
+func TestVolumeEntryExpandStopped(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
 
@@ -1352,29 +1767,38 @@ func TestVolumeEntryDoNotAllowDeviceOnSameNode(t *testing.T) {
 	app := NewTestApp(tmpfile)
 	defer app.Close()
 
-	// Create cluster with plenty of space, but
-	// it will not have enough nodes
 	err := setupSampleDbWithTopology(app,
 		1,    // clusters
-		1,    // nodes_per_cluster
-		200,  // devices_per_node,
-		6*TB, // disksize)
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		2*TB, // disksize)
 	)
 	tests.Assert(t, err == nil)
 
-	// Create volume
 	v := createSampleVolumeEntry(100)
 	err = v.Create(app.db, app.executor, app.allocator)
-	tests.Assert(t, err != nil, err)
-	tests.Assert(t, err == ErrNoSpace)
+	tests.Assert(t, err == nil, err)
+	bricks := len(v.Bricks)
 
-	v = createSampleVolumeEntry(10000)
-	err = v.Create(app.db, app.executor, app.allocator)
-	tests.Assert(t, err != nil, err)
-	tests.Assert(t, err == ErrNoSpace)
+	v.SetStarted(false)
+	err = v.Expand(app.db, app.executor, app.allocator, 100)
+	tests.Assert(t, err == ErrVolumeStopped, err)
+	tests.Assert(t, v.Info.Size == 100)
+	tests.Assert(t, len(v.Bricks) == bricks)
+
+	_, err = v.MountCommand(api.MountOsLinux, "")
+	tests.Assert(t, err == ErrVolumeStopped, err)
+	_, err = v.ProfileInfo(app.db, app.executor)
+	tests.Assert(t, err == ErrVolumeStopped, err)
+
+	// Running again after maintenance
+	v.SetStarted(true)
+	err = v.Expand(app.db, app.executor, app.allocator, 100)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, v.Info.Size == 200)
 }
 
-func TestVolumeEntryDestroyCheck(t *testing.T) {
+func TestVolumeEntryExpandConcurrent(t *testing.T) {
 	tmpfile := tests.Tempfile()
 	defer os.Remove(tmpfile)
 
@@ -1382,26 +1806,291 @@ func TestVolumeEntryDestroyCheck(t *testing.T) {
 	app := NewTestApp(tmpfile)
 	defer app.Close()
 
-	// Lots of nodes with little drives
 	err := setupSampleDbWithTopology(app,
-		1,      // clusters
-		4,      // nodes_per_cluster
-		4,      // devices_per_node,
-		500*GB, // disksize)
+		1,    // clusters
+		10,   // nodes_per_cluster
+		20,   // devices_per_node,
+		6*TB, // disksize)
 	)
 	tests.Assert(t, err == nil)
 
-	// Create a volume with a snapshot factor of 1.5
-	// For a 200G vol, it would get a brick size of 100G, with a thin pool
-	// size of 100G * 1.5 = 150GB.
-	v := createSampleVolumeEntry(200)
-	v.Info.Snapshot.Enable = true
-	v.Info.Snapshot.Factor = 1.5
-
+	v := createSampleVolumeEntry(1024)
 	err = v.Create(app.db, app.executor, app.allocator)
 	tests.Assert(t, err == nil)
 
-	// Test that a volume that is sharing space in a thin pool
+	// Hold both expansions in the executor until both have started,
+	// so that each one saves a volume loaded before the other saved
+	var started sync.WaitGroup
+	started.Add(2)
+	app.xo.MockVolumeExpand = func(host string,
+		volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		started.Done()
+		started.Wait()
+		return &executors.VolumeInfo{}, nil
+	}
+
+	var done sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+
+			// Load the volume as a request would
+			var volume *VolumeEntry
+			err := app.db.View(func(tx *bolt.Tx) error {
+				var err error
+				volume, err = NewVolumeEntryFromId(tx, v.Info.Id)
+				return err
+			})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = volume.Expand(app.db, app.executor, app.allocator, 100)
+		}(i)
+	}
+	done.Wait()
+	tests.Assert(t, errs[0] == nil, errs[0])
+	tests.Assert(t, errs[1] == nil, errs[1])
+
+	// Both expansions are in the db
+	err = app.db.View(func(tx *bolt.Tx) error {
+		entry, err := NewVolumeEntryFromId(tx, v.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, entry.Info.Size == 1024+100+100, entry.Info.Size)
+		tests.Assert(t, len(entry.Bricks) > len(v.Bricks))
+
+		// Every brick in the db belongs to the volume
+		bricks, err := BrickList(tx)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, len(bricks) == len(entry.Bricks), len(bricks), len(entry.Bricks))
+		for _, id := range bricks {
+			tests.Assert(t, utils.SortedStringHas(entry.Bricks, id))
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestVolumeEntryDoNotAllowDeviceOnSameNode(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	// Create cluster with plenty of space, but
+	// it will not have enough nodes
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		1,    // nodes_per_cluster
+		200,  // devices_per_node,
+		6*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Create volume
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err != nil, err)
+	tests.Assert(t, err == ErrNoSpace)
+
+	v = createSampleVolumeEntry(10000)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err != nil, err)
+	tests.Assert(t, err == ErrNoSpace)
+}
+
+func TestVolumeEntryCreateOnStorageClass(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	// Create a cluster with four nodes
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Make two of the nodes SSD
+	ssdnodes := make(map[string]bool)
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		if err != nil {
+			return err
+		}
+		for _, id := range cluster.Info.Nodes[:2] {
+			node, err := NewNodeEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			node.Info.StorageClass = api.StorageClassSsd
+			err = node.Save(tx)
+			if err != nil {
+				return err
+			}
+			ssdnodes[id] = true
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Create volume on SSD nodes
+	v := createSampleVolumeEntry(100)
+	v.Info.StorageClass = api.StorageClassSsd
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+
+	// Check all the bricks are on SSD nodes
+	err = app.db.View(func(tx *bolt.Tx) error {
+		for _, id := range v.Bricks {
+			brick, err := NewBrickEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			tests.Assert(t, ssdnodes[brick.Info.NodeId])
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// There are no NVMe nodes
+	v = createSampleVolumeEntry(100)
+	v.Info.StorageClass = api.StorageClassNvme
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == ErrNoSpace, err)
+}
+
+func TestVolumeEntryCreateOnOwnedNodes(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	// Create a cluster with four nodes
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		4,    // nodes_per_cluster
+		4,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Reserve two nodes for tenant A and one for tenant B
+	owners := make(map[string]string)
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		if err != nil {
+			return err
+		}
+		for i, id := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, id)
+			if err != nil {
+				return err
+			}
+			switch i {
+			case 0, 1:
+				node.Info.Owner = "A"
+			case 2:
+				node.Info.Owner = "B"
+			}
+			err = node.Save(tx)
+			if err != nil {
+				return err
+			}
+			owners[id] = node.Info.Owner
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	checkOwners := func(v *VolumeEntry, allowed ...string) {
+		err := app.db.View(func(tx *bolt.Tx) error {
+			for _, id := range v.Bricks {
+				brick, err := NewBrickEntryFromId(tx, id)
+				if err != nil {
+					return err
+				}
+				ok := false
+				for _, owner := range allowed {
+					if owners[brick.Info.NodeId] == owner {
+						ok = true
+					}
+				}
+				tests.Assert(t, ok, owners[brick.Info.NodeId])
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil)
+	}
+
+	// Volumes of tenant A use nodes owned by A or unowned
+	v := createSampleVolumeEntry(100)
+	v.Info.Owner = "A"
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	checkOwners(v, "A", "")
+
+	// Volumes of tenant B use nodes owned by B or unowned
+	v = createSampleVolumeEntry(100)
+	v.Info.Owner = "B"
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	checkOwners(v, "B", "")
+
+	// Volumes without a tenant only use unowned nodes.  A replica 2
+	// volume needs two nodes, and only one is unowned.
+	v = createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == ErrNoSpace, err)
+}
+
+func TestVolumeEntryDestroyCheck(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	// Lots of nodes with little drives
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		4,      // nodes_per_cluster
+		4,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Create a volume with a snapshot factor of 1.5
+	// For a 200G vol, it would get a brick size of 100G, with a thin pool
+	// size of 100G * 1.5 = 150GB.
+	v := createSampleVolumeEntry(200)
+	v.Info.Snapshot.Enable = true
+	v.Info.Snapshot.Factor = 1.5
+
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil)
+
+	// Test that a volume that is sharing space in a thin pool
 	// with either a clone or a snapshot cannot be deleted
 	app.xo.MockBrickDestroyCheck = func(host string, brick *executors.BrickRequest) error {
 		return fmt.Errorf("BRICKMOCK")
@@ -1490,3 +2179,568 @@ func TestVolumeEntryNameConflictMultiCluster(t *testing.T) {
 	err = v.Create(app.db, app.executor, app.allocator)
 	tests.Assert(t, err != nil, err)
 }
+
+func TestVolumeEntryMountCommand(t *testing.T) {
+	v := createSampleVolumeEntry(10)
+	v.Info.Name = "myvol"
+
+	// Not created yet
+	_, err := v.MountCommand(api.MountOsLinux, "")
+	tests.Assert(t, err == ErrNoMountHosts)
+
+	v.setMountInfo([]string{"10.0.1.1", "10.0.2.1", "10.0.2.2"})
+
+	mount, err := v.MountCommand(api.MountOsLinux, "")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, mount.Server == "10.0.1.1")
+	tests.Assert(t, mount.Command == "mount -t glusterfs "+
+		"-o backup-volfile-servers=10.0.2.1:10.0.2.2 "+
+		"10.0.1.1:/myvol /mnt/myvol", mount.Command)
+
+	// Mount from the closest host
+	mount, err = v.MountCommand(api.MountOsLinux, "10.0.2.200")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, mount.Server == "10.0.2.1", mount.Server)
+	tests.Assert(t, mount.Command == "mount -t glusterfs "+
+		"-o backup-volfile-servers=10.0.2.2:10.0.1.1 "+
+		"10.0.2.1:/myvol /mnt/myvol", mount.Command)
+
+	// The saved hosts are not reordered
+	tests.Assert(t, v.Info.Mount.GlusterFS.Hosts[0] == "10.0.1.1")
+
+	// Other options of the volume
+	v.Info.Mount.GlusterFS.Options["log-level"] = "WARNING"
+	mount, err = v.MountCommand(api.MountOsLinux, "")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, strings.Contains(mount.Command,
+		"-o backup-volfile-servers=10.0.2.1:10.0.2.2,log-level=WARNING "), mount.Command)
+
+	mount, err = v.MountCommand(api.MountOsMac, "")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, mount.Command == "mount -t nfs -o vers=3,tcp,resvport "+
+		"10.0.1.1:/myvol /Volumes/myvol", mount.Command)
+
+	mount, err = v.MountCommand(api.MountOsWindows, "")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, mount.Command == `mount -o nolock \\10.0.1.1\myvol *`, mount.Command)
+
+	_, err = v.MountCommand("plan9", "")
+	tests.Assert(t, err == ErrUnknownMountOs)
+
+	// Volumes saved without the hosts
+	v.Info.Mount.GlusterFS.Hosts = nil
+	delete(v.Info.Mount.GlusterFS.Options, "log-level")
+	mount, err = v.MountCommand(api.MountOsLinux, "")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, mount.Command == "mount -t glusterfs "+
+		"-o backup-volfile-servers=10.0.2.1:10.0.2.2 "+
+		"10.0.1.1:/myvol /mnt/myvol", mount.Command)
+}
+
+func TestVolumeEntryCreateAffinityGroups(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		4,      // nodes_per_cluster
+		4,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// A single node in group b and three nodes in group a
+	groups := make(map[string]string)
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		clusters, err := ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[0])
+		if err != nil {
+			return err
+		}
+		for i, nodeId := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, nodeId)
+			if err != nil {
+				return err
+			}
+			node.Info.AffinityGroup = "a"
+			if i == 0 {
+				node.Info.AffinityGroup = "b"
+			}
+			groups[nodeId] = node.Info.AffinityGroup
+			err = node.Save(tx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	v := createSampleVolumeEntry(600)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+
+	var info *api.VolumeInfoResponse
+	err = app.db.View(func(tx *bolt.Tx) error {
+		entry, err := NewVolumeEntryFromId(tx, v.Info.Id)
+		if err != nil {
+			return err
+		}
+		info, err = entry.NewInfoResponse(tx)
+		return err
+	})
+	tests.Assert(t, err == nil)
+
+	// Each replica set has a brick on the only node of group b
+	tests.Assert(t, len(info.Bricks)%2 == 0)
+	count := make(map[string]int)
+	for _, brick := range info.Bricks {
+		count[groups[brick.NodeId]]++
+	}
+	tests.Assert(t, count["a"] == count["b"], count)
+
+	// Three replicas cannot be placed on two groups
+	req := &api.VolumeCreateRequest{}
+	req.Size = 100
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 3
+	v = NewVolumeEntryFromRequest(req)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == ErrNoSpace, err)
+}
+
+func TestVolumeEntryCreateDataLocality(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		2,      // clusters
+		4,      // nodes_per_cluster
+		2,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	var clusters []string
+	var local *NodeEntry
+	err = app.db.View(func(tx *bolt.Tx) error {
+		var err error
+		clusters, err = ClusterList(tx)
+		if err != nil {
+			return err
+		}
+		cluster, err := NewClusterEntryFromId(tx, clusters[1])
+		if err != nil {
+			return err
+		}
+		local, err = NewNodeEntryFromId(tx, cluster.Info.Nodes[2])
+		return err
+	})
+	tests.Assert(t, err == nil)
+
+	newVolume := func(locality string) *VolumeEntry {
+		req := &api.VolumeCreateRequest{}
+		req.Size = 200
+		req.Durability.Type = api.DurabilityReplicate
+		req.Durability.Replicate.Replica = 2
+		req.DataLocality = locality
+		req.PreferredNodeId = local.Info.Id
+		return NewVolumeEntryFromRequest(req)
+	}
+	localBricks := func(v *VolumeEntry) int {
+		count := 0
+		err := app.db.View(func(tx *bolt.Tx) error {
+			for _, id := range v.Bricks {
+				brick, err := NewBrickEntryFromId(tx, id)
+				if err != nil {
+					return err
+				}
+				if brick.Info.NodeId == local.Info.Id {
+					count++
+				}
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil)
+		return count
+	}
+
+	// Each set has a brick on the preferred node, in its cluster
+	for _, locality := range []string{
+		api.VolumeDataLocalityPrefer,
+		api.VolumeDataLocalityStrict,
+	} {
+		v := newVolume(locality)
+		err = v.Create(app.db, app.executor, app.allocator)
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, v.Info.Cluster == clusters[1])
+		tests.Assert(t, localBricks(v) == len(v.Bricks)/2, locality)
+	}
+
+	// Strict locality only uses the clusters requested
+	v := newVolume(api.VolumeDataLocalityStrict)
+	v.Info.Clusters = []string{clusters[0]}
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == ErrNoSpace, err)
+
+	v = newVolume(api.VolumeDataLocalityPrefer)
+	v.Info.Clusters = []string{clusters[0]}
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, localBricks(v) == 0)
+
+	// The node cannot host bricks once offline
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		node, err := NewNodeEntryFromId(tx, local.Info.Id)
+		if err != nil {
+			return err
+		}
+		node.State = api.EntryStateOffline
+		return node.Save(tx)
+	})
+	tests.Assert(t, err == nil)
+
+	v = newVolume(api.VolumeDataLocalityStrict)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == ErrNoSpace, err)
+
+	v = newVolume(api.VolumeDataLocalityPrefer)
+	v.Info.Clusters = []string{clusters[1]}
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+
+	// Without locality the preferred node is ignored
+	v = newVolume(api.VolumeDataLocalityNone)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+}
+
+func TestVolumeEntryBackupVolfileServers(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		3,    // nodes_per_cluster
+		1,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// A brick on every node
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 3
+	v := NewVolumeEntryFromRequest(req)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+
+	glusterfs := v.Info.Mount.GlusterFS
+	tests.Assert(t, len(glusterfs.Hosts) == 3, glusterfs.Hosts)
+	tests.Assert(t, reflect.DeepEqual(glusterfs.BackupVolfileServers, glusterfs.Hosts[1:]),
+		glusterfs.BackupVolfileServers)
+	tests.Assert(t, strings.HasPrefix(glusterfs.MountPoint, glusterfs.Hosts[0]+":"))
+
+	// Nothing changes while the nodes are the same
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, v.Info.Cluster)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, updateClusterMountHosts(tx, cluster) == nil)
+
+		volume, err := NewVolumeEntryFromId(tx, v.Info.Id)
+		tests.Assert(t, err == nil)
+		tests.Assert(t, reflect.DeepEqual(volume.Info.Mount, v.Info.Mount))
+		return nil
+	})
+	tests.Assert(t, err == nil)
+
+	// Change the storage hostname of the node of the mount point
+	primary := glusterfs.Hosts[0]
+	err = app.db.Update(func(tx *bolt.Tx) error {
+		cluster, err := NewClusterEntryFromId(tx, v.Info.Cluster)
+		tests.Assert(t, err == nil)
+		for _, id := range cluster.Info.Nodes {
+			node, err := NewNodeEntryFromId(tx, id)
+			tests.Assert(t, err == nil)
+			if node.StorageHostName() == primary {
+				node.Info.Hostnames.Storage = []string{"zzz"}
+				tests.Assert(t, node.Save(tx) == nil)
+			}
+		}
+		tests.Assert(t, updateClusterMountHosts(tx, cluster) == nil)
+
+		volume, err := NewVolumeEntryFromId(tx, v.Info.Id)
+		tests.Assert(t, err == nil)
+		mount := volume.Info.Mount.GlusterFS
+		tests.Assert(t, mount.Hosts[0] == glusterfs.Hosts[1], mount.Hosts)
+		tests.Assert(t, mount.MountPoint == glusterfs.Hosts[1]+":"+v.Info.Name)
+		tests.Assert(t, reflect.DeepEqual(mount.BackupVolfileServers,
+			[]string{glusterfs.Hosts[2], "zzz"}), mount.BackupVolfileServers)
+		tests.Assert(t, mount.Options["backup-volfile-servers"] ==
+			glusterfs.Hosts[2]+",zzz")
+		return nil
+	})
+	tests.Assert(t, err == nil)
+}
+
+func TestVolumeEntryBackupVolfileServersOldVolume(t *testing.T) {
+	v := createSampleVolumeEntry(10)
+	v.Info.Name = "myvol"
+
+	// Saved before the backup volfile servers were
+	v.setMountInfo([]string{"10.0.1.1", "10.0.2.1", "10.0.2.2"})
+	v.Info.Mount.GlusterFS.Hosts = nil
+	v.Info.Mount.GlusterFS.BackupVolfileServers = nil
+
+	tests.Assert(t, reflect.DeepEqual(v.backupVolfileServers(),
+		[]string{"10.0.2.1", "10.0.2.2"}), v.backupVolfileServers())
+}
+
+func TestVolumeEntryCreateSeededPlacement(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,    // clusters
+		6,    // nodes_per_cluster
+		2,    // devices_per_node,
+		1*TB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	// Devices of the bricks of a new volume.  The bricks are listed
+	// by their random ids, so the devices are sorted.
+	placement := func(seed int64) []string {
+		SetAllocationSeed(seed)
+		v := createSampleVolumeEntry(200)
+		err := v.Create(app.db, app.executor, app.allocator)
+		tests.Assert(t, err == nil, err)
+
+		devices := []string{}
+		err = app.db.View(func(tx *bolt.Tx) error {
+			for _, id := range v.Bricks {
+				brick, err := NewBrickEntryFromId(tx, id)
+				if err != nil {
+					return err
+				}
+				devices = append(devices, brick.Info.DeviceId)
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil)
+		sort.Strings(devices)
+
+		err = v.Destroy(app.db, app.executor)
+		tests.Assert(t, err == nil)
+		return devices
+	}
+
+	reference := placement(1234)
+	tests.Assert(t, len(reference) > 0)
+	for i := 0; i < 3; i++ {
+		devices := placement(1234)
+		tests.Assert(t, len(devices) == len(reference))
+		for j := range devices {
+			tests.Assert(t, devices[j] == reference[j], devices, reference)
+		}
+	}
+
+	SetAllocationSeed(time.Now().UnixNano())
+}
+
+func TestVolumeEntryCreateSharedBricks(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	// The bricks of both volumes go on the same devices
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		2,      // nodes_per_cluster
+		1,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	app.xo.MockBrickCreate = func(host string,
+		brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+		mp := brick.MountPoint
+		if mp == "" {
+			mp = "/mnt/" + brick.Name
+		}
+		subdir := brick.SubDir
+		if subdir == "" {
+			subdir = "brick"
+		}
+		return &executors.BrickInfo{
+			Path:       mp + "/" + subdir,
+			MountPoint: mp,
+		}, nil
+	}
+
+	deviceUsed := func() map[string]uint64 {
+		used := make(map[string]uint64)
+		err := app.db.View(func(tx *bolt.Tx) error {
+			devices, err := DeviceList(tx)
+			tests.Assert(t, err == nil)
+			for _, id := range devices {
+				device, err := NewDeviceEntryFromId(tx, id)
+				tests.Assert(t, err == nil)
+				used[id] = device.Info.Storage.Used
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil)
+		return used
+	}
+	volumeBricks := func(v *VolumeEntry) []*BrickEntry {
+		var bricks []*BrickEntry
+		err := app.db.View(func(tx *bolt.Tx) error {
+			for _, id := range v.Bricks {
+				brick, err := NewBrickEntryFromId(tx, id)
+				tests.Assert(t, err == nil)
+				bricks = append(bricks, brick)
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil)
+		return bricks
+	}
+
+	req := &api.VolumeCreateRequest{}
+	req.Size = 10
+	req.Durability.Type = api.DurabilityReplicate
+	req.Durability.Replicate.Replica = 2
+	req.SharedBricks = true
+
+	first := NewVolumeEntryFromRequest(req)
+	err = first.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	used := deviceUsed()
+
+	// The second volume uses sub-directories of the bricks of the
+	// first one, one per mount point, without allocating storage
+	second := NewVolumeEntryFromRequest(req)
+	err = second.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, reflect.DeepEqual(deviceUsed(), used))
+	mountpoints := make(map[string]bool)
+	for _, brick := range volumeBricks(first) {
+		tests.Assert(t, brick.Shareable)
+		tests.Assert(t, brick.Info.SubDir == "brick", brick.Info.SubDir)
+		mountpoints[brick.Info.MountPoint] = true
+	}
+	for _, brick := range volumeBricks(second) {
+		tests.Assert(t, mountpoints[brick.Info.MountPoint], brick.Info.MountPoint)
+		delete(mountpoints, brick.Info.MountPoint)
+		tests.Assert(t, brick.Info.SubDir != "brick")
+		tests.Assert(t, strings.HasSuffix(brick.Info.Path, "/"+brick.Info.SubDir))
+	}
+
+	// A volume without shared bricks allocates its own
+	err = createSampleVolumeEntry(10).Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, !reflect.DeepEqual(deviceUsed(), used))
+	used = deviceUsed()
+
+	// The storage is only freed with the last brick of a mount point
+	destroyed := 0
+	app.xo.MockBrickDestroy = func(host string, brick *executors.BrickRequest) error {
+		if brick.Last {
+			destroyed++
+		}
+		return nil
+	}
+	err = first.Destroy(app.db, app.executor)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, destroyed == 0, destroyed)
+	tests.Assert(t, reflect.DeepEqual(deviceUsed(), used))
+
+	err = second.Destroy(app.db, app.executor)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, destroyed == 4, destroyed)
+	for id, size := range deviceUsed() {
+		tests.Assert(t, size < used[id])
+	}
+}
+
+func TestVolumeEntryCreateFailureAllocationHistory(t *testing.T) {
+	tmpfile := tests.Tempfile()
+	defer os.Remove(tmpfile)
+
+	// Create the app
+	app := NewTestApp(tmpfile)
+	defer app.Close()
+
+	err := setupSampleDbWithTopology(app,
+		1,      // clusters
+		2,      // nodes_per_cluster
+		2,      // devices_per_node,
+		500*GB, // disksize)
+	)
+	tests.Assert(t, err == nil)
+
+	history := func() []DeviceAllocation {
+		var allocations []DeviceAllocation
+		err := app.db.View(func(tx *bolt.Tx) error {
+			devices, err := DeviceList(tx)
+			tests.Assert(t, err == nil)
+			for _, id := range devices {
+				device, err := NewDeviceEntryFromId(tx, id)
+				tests.Assert(t, err == nil)
+				allocations = append(allocations, device.AllocationHistory()...)
+			}
+			return nil
+		})
+		tests.Assert(t, err == nil)
+		return allocations
+	}
+
+	// The allocations of a volume which fails are rolled back
+	app.xo.MockVolumeCreate = func(host string,
+		volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		return nil, errors.New("failed")
+	}
+	v := createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, len(history()) == 0, history())
+
+	// Those of a volume which is created are kept
+	app.xo.MockVolumeCreate = func(host string,
+		volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
+		return &executors.VolumeInfo{}, nil
+	}
+	v = createSampleVolumeEntry(100)
+	err = v.Create(app.db, app.executor, app.allocator)
+	tests.Assert(t, err == nil, err)
+	allocations := history()
+	tests.Assert(t, len(allocations) == len(v.Bricks), allocations)
+	for _, a := range allocations {
+		tests.Assert(t, a.VolumeId == v.Info.Id)
+		tests.Assert(t, utils.SortedStringHas(v.Bricks, a.BrickId))
+	}
+}
@@ -0,0 +1,83 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package glusterfs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+// Returns an error if the WORM settings of the request are invalid
+func validateWORM(req *api.VolumeCreateRequest) error {
+	switch req.WORMRetentionMode {
+	case "", api.VolumeWORMRetentionRelax, api.VolumeWORMRetentionEnterprise:
+	default:
+		return fmt.Errorf("Unknown WORM retention mode %v", req.WORMRetentionMode)
+	}
+	if req.WORMRetentionDays < 0 {
+		return fmt.Errorf("Invalid WORM retention of %v days", req.WORMRetentionDays)
+	}
+	if !req.WORM && (req.WORMRetentionDays != 0 || req.WORMRetentionMode != "") {
+		return fmt.Errorf("WORM retention requires a WORM volume")
+	}
+	return nil
+}
+
+// Sets the WORM settings of the request on the volume, with the time
+// the volume can be deleted from
+func (v *VolumeEntry) setWORM(req *api.VolumeCreateRequest, now time.Time) {
+	if !req.WORM {
+		return
+	}
+
+	v.Info.WORM = true
+	v.Info.WORMRetentionDays = req.WORMRetentionDays
+	v.Info.WORMRetentionMode = req.WORMRetentionMode
+	if v.Info.WORMRetentionMode == "" {
+		v.Info.WORMRetentionMode = api.VolumeWORMRetentionRelax
+	}
+	if req.WORMRetentionDays > 0 {
+		v.WORMRetainUntil = now.AddDate(0, 0, req.WORMRetentionDays).Unix()
+	}
+}
+
+// Sets the WORM settings of the volume in the request to the executor
+func (v *VolumeEntry) setExecutorWORM(vr *executors.VolumeRequest) {
+	if !v.Info.WORM {
+		return
+	}
+
+	vr.WORM = true
+	vr.WORMRetentionMode = executors.WORMRetentionRelax
+	if v.Info.WORMRetentionMode == api.VolumeWORMRetentionEnterprise {
+		vr.WORMRetentionMode = executors.WORMRetentionEnterprise
+	}
+	vr.WORMRetentionPeriod = v.Info.WORMRetentionDays * 24 * 60 * 60
+}
+
+// Returns an error if the volume is WORM and its retention has not
+// expired, in which case it cannot be deleted
+func (v *VolumeEntry) checkWORMRetention(now time.Time) error {
+	if !v.Info.WORM || now.Unix() >= v.WORMRetainUntil {
+		return nil
+	}
+	return fmt.Errorf("Volume %v is WORM and cannot be deleted before %v",
+		v.Info.Id, time.Unix(v.WORMRetainUntil, 0).UTC().Format(time.RFC3339))
+}
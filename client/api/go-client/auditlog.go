@@ -0,0 +1,75 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+// Lists the audit records written since the time given, all of them
+// if it is zero.  At most limit records are returned if it is not 0.
+func (c *Client) AuditLog(since time.Time, limit int) (*api.AuditLogResponse, error) {
+
+	query := url.Values{}
+	if !since.IsZero() {
+		query.Set("since", since.Format(time.RFC3339Nano))
+	}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	u := c.host + "/auditlog"
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+
+	// Create request
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get records
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var log api.AuditLogResponse
+	err = utils.GetJsonFromResponse(r, &log)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &log, nil
+}
@@ -17,7 +17,6 @@
 package client
 
 import (
-	"github.com/heketi/utils"
 	"io"
 	"net/http"
 )
@@ -41,7 +40,7 @@ func (c *Client) BackupDb(w io.Writer) error {
 		return err
 	}
 	if r.StatusCode != http.StatusOK {
-		return utils.GetErrorFromResponse(r)
+		return errorFromResponse(r)
 	}
 
 	// Read data from response
@@ -50,3 +49,32 @@ func (c *Client) BackupDb(w io.Writer) error {
 
 	return err
 }
+
+// Sends a database to replace the database of the server.  The server
+// validates it and replaces its database with it once restarted.
+func (c *Client) RestoreDb(r io.Reader) error {
+	// Create a request
+	req, err := http.NewRequest("POST", c.host+"/backup/db", r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return err
+	}
+
+	// Send request
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return errorFromResponse(resp)
+	}
+
+	return nil
+}
@@ -18,16 +18,31 @@ package client
 
 import (
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/heketi/heketi/pkg/utils"
 )
 
 const (
 	MAX_CONCURRENT_REQUESTS = 32
+
+	// Prefix of the routes of the v2 API
+	API_V2_ROUTE = "/v2"
+
+	// Header of the key which makes repeated creates return the
+	// result of the first
+	idempotencyKeyHeader = "Idempotency-Key"
 )
 
 // Client object
@@ -36,6 +51,15 @@ type Client struct {
 	key      string
 	user     string
 	throttle chan bool
+
+	// Transport of the requests, the default of net/http if nil
+	transport http.RoundTripper
+
+	// Whether the server was asked if it has the v2 API, and
+	// whether it has it
+	lock       sync.Mutex
+	negotiated bool
+	v2         bool
 }
 
 // Creates a new client to access a Heketi server
@@ -57,6 +81,62 @@ func NewClientNoAuth(host string) *Client {
 	return NewClient(host, "", "")
 }
 
+// TLS settings of a client.  The server certificate is verified with
+// the CA in the CA file, or with the CAs of the system if it is not
+// set.  The client certificate is presented to the servers which
+// require one.
+type ClientTLSOptions struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+// Returns the TLS configuration of the settings
+func (o *ClientTLSOptions) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != "" {
+		pool, err := utils.LoadCertPool(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// Creates a new client to access a Heketi server over TLS
+func NewClientTLS(host, user, key string, options *ClientTLSOptions) (*Client, error) {
+	config, err := options.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewClient(host, user, key)
+	c.SetTLSConfig(config)
+	return c, nil
+}
+
+// Sets the TLS configuration of the connections to the server
+func (c *Client) SetTLSConfig(config *tls.Config) {
+	c.transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: config,
+	}
+}
+
 // Simple Hello test to check if the server is up
 func (c *Client) Hello() error {
 	// Create request
@@ -77,20 +157,102 @@ func (c *Client) Hello() error {
 		return err
 	}
 	if r.StatusCode != http.StatusOK {
-		return utils.GetErrorFromResponse(r)
+		return errorFromResponse(r)
 	}
 
 	return nil
 }
 
-// Make sure we do not run out of fds by throttling the requests
+// Asks the server if it has the v2 API, once it answers.  The
+// requests use the v2 API if it has it, so that the errors are
+// returned as *api.Error.
+func (c *Client) negotiate() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.negotiated {
+		return c.v2
+	}
+
+	req, err := http.NewRequest("GET", c.host+API_V2_ROUTE+"/hello", nil)
+	if err != nil {
+		return false
+	}
+	err = c.setToken(req)
+	if err != nil {
+		return false
+	}
+	r, err := c.send(req)
+	if err != nil {
+		// Asked again with the next request
+		return false
+	}
+	r.Body.Close()
+
+	c.negotiated = true
+	c.v2 = r.StatusCode == http.StatusOK
+	return c.v2
+}
+
+// Returns the error in the body of the response, as *api.Error if
+// the server returned it with the v2 API
+func errorFromResponse(r *http.Response) error {
+	s, err := utils.GetStringFromResponse(r)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var e api.Error
+		if err := json.Unmarshal([]byte(s), &e); err == nil && e.Code != "" {
+			return &e
+		}
+	}
+	return errors.New(s)
+}
+
+// Adds the paging parameters of a list to the path.  A zero limit
+// and an empty token get the whole list.
+func listPath(path string, limit int, token string) string {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if token != "" {
+		query.Set("continue", token)
+	}
+	if len(query) != 0 {
+		path += "?" + query.Encode()
+	}
+	return path
+}
+
+// Sends the request with the v2 API if the server has it.  The
+// bodies of the requests are always JSON.
 func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.negotiate() && !strings.HasPrefix(req.URL.Path, API_V2_ROUTE+"/") {
+		req.URL.Path = API_V2_ROUTE + req.URL.Path
+
+		// The token is for the path
+		err := c.setToken(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.send(req)
+}
+
+// Make sure we do not run out of fds by throttling the requests
+func (c *Client) send(req *http.Request) (*http.Response, error) {
 	c.throttle <- true
 	defer func() {
 		<-c.throttle
 	}()
 
-	httpClient := &http.Client{}
+	httpClient := &http.Client{Transport: c.transport}
 	httpClient.CheckRedirect = c.checkRedirect
 	return httpClient.Do(req)
 }
@@ -134,7 +296,7 @@ func (c *Client) waitForResponseWithTimer(r *http.Response,
 		// Check if the request is pending
 		if r.Header.Get("X-Pending") == "true" {
 			if r.StatusCode != http.StatusOK {
-				return nil, utils.GetErrorFromResponse(r)
+				return nil, errorFromResponse(r)
 			}
 			time.Sleep(waitTime)
 		} else {
@@ -17,7 +17,9 @@
 package client
 
 import (
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"net/http/httptest"
 	"os"
 	"reflect"
@@ -268,6 +270,15 @@ func TestClientCluster(t *testing.T) {
 	tests.Assert(t, len(list.Clusters) == 1)
 	tests.Assert(t, list.Clusters[0] == info.Id)
 
+	// Follow the pages of clusters
+	ids := make([]string, 0)
+	err = c.ClusterListEach(1, func(id string) error {
+		ids = append(ids, id)
+		return nil
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, reflect.DeepEqual(ids, list.Clusters))
+
 	// Delete non-existent cluster
 	err = c.ClusterDelete("badid")
 	tests.Assert(t, err != nil)
@@ -586,3 +597,52 @@ func TestClientVolume(t *testing.T) {
 	tests.Assert(t, err == nil)
 
 }
+
+func TestClientTLS(t *testing.T) {
+	db := tests.Tempfile()
+	defer os.Remove(db)
+
+	app := glusterfs.NewTestApp(db)
+	defer app.Close()
+
+	router := mux.NewRouter()
+	app.SetRoutes(router)
+	ts := httptest.NewTLSServer(router)
+	defer ts.Close()
+
+	// The certificate of the server is not trusted
+	c := NewClientNoAuth(ts.URL)
+	_, err := c.ClusterList()
+	tests.Assert(t, err != nil)
+
+	// Missing CA file
+	_, err = NewClientTLS(ts.URL, "", "", &ClientTLSOptions{
+		CAFile: "/does/not/exist",
+	})
+	tests.Assert(t, err != nil)
+
+	// Trust the certificate of the server
+	caFile := tests.Tempfile()
+	defer os.Remove(caFile)
+	err = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: ts.Certificate().Raw,
+	}), 0600)
+	tests.Assert(t, err == nil)
+
+	c, err = NewClientTLS(ts.URL, "", "", &ClientTLSOptions{
+		CAFile: caFile,
+	})
+	tests.Assert(t, err == nil)
+	list, err := c.ClusterList()
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(list.Clusters) == 0)
+
+	// Without verifying the server
+	c, err = NewClientTLS(ts.URL, "", "", &ClientTLSOptions{
+		InsecureSkipVerify: true,
+	})
+	tests.Assert(t, err == nil)
+	_, err = c.ClusterList()
+	tests.Assert(t, err == nil)
+}
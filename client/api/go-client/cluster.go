@@ -18,9 +18,11 @@ package client
 
 import (
 	"bytes"
+	"encoding/json"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/heketi/heketi/pkg/utils"
 	"net/http"
+	"time"
 )
 
 func (c *Client) ClusterCreate() (*api.ClusterInfoResponse, error) {
@@ -44,7 +46,7 @@ func (c *Client) ClusterCreate() (*api.ClusterInfoResponse, error) {
 		return nil, err
 	}
 	if r.StatusCode != http.StatusCreated {
-		return nil, utils.GetErrorFromResponse(r)
+		return nil, errorFromResponse(r)
 	}
 
 	// Read JSON response
@@ -78,7 +80,7 @@ func (c *Client) ClusterInfo(id string) (*api.ClusterInfoResponse, error) {
 		return nil, err
 	}
 	if r.StatusCode != http.StatusOK {
-		return nil, utils.GetErrorFromResponse(r)
+		return nil, errorFromResponse(r)
 	}
 
 	// Read JSON response
@@ -92,10 +94,86 @@ func (c *Client) ClusterInfo(id string) (*api.ClusterInfoResponse, error) {
 	return &cluster, nil
 }
 
+// Returns the nodes, devices and storage of each zone of the cluster
+func (c *Client) ClusterZones(id string) (*api.ClusterZonesResponse, error) {
+
+	// Create request
+	req, err := http.NewRequest("GET", c.host+"/clusters/"+id+"/zones", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get info
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var zones api.ClusterZonesResponse
+	err = utils.GetJsonFromResponse(r, &zones)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &zones, nil
+}
+
+func (c *Client) ClusterDeleteImpact(id string) (*api.DeleteImpact, error) {
+
+	// Create request
+	req, err := http.NewRequest("GET", c.host+"/clusters/"+id+"/delete-impact", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get info
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var impact api.DeleteImpact
+	err = utils.GetJsonFromResponse(r, &impact)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &impact, nil
+}
+
 func (c *Client) ClusterList() (*api.ClusterListResponse, error) {
+	return c.ClusterListPage(0, "")
+}
+
+// Returns up to limit clusters following the page of the token, or all
+// of them if limit is zero and the token empty.  The continue token of
+// the response gets the next page.
+func (c *Client) ClusterListPage(limit int, token string) (*api.ClusterListResponse, error) {
 
 	// Create request
-	req, err := http.NewRequest("GET", c.host+"/clusters", nil)
+	req, err := http.NewRequest("GET", listPath(c.host+"/clusters", limit, token), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -112,7 +190,7 @@ func (c *Client) ClusterList() (*api.ClusterListResponse, error) {
 		return nil, err
 	}
 	if r.StatusCode != http.StatusOK {
-		return nil, utils.GetErrorFromResponse(r)
+		return nil, errorFromResponse(r)
 	}
 
 	// Read JSON response
@@ -125,10 +203,42 @@ func (c *Client) ClusterList() (*api.ClusterListResponse, error) {
 	return &clusters, nil
 }
 
+// Calls fn with the id of each cluster, getting them in pages of limit
+// ids.  Stops at the first error returned by fn.
+func (c *Client) ClusterListEach(limit int, fn func(id string) error) error {
+	token := ""
+	for {
+		list, err := c.ClusterListPage(limit, token)
+		if err != nil {
+			return err
+		}
+		for _, id := range list.Clusters {
+			err := fn(id)
+			if err != nil {
+				return err
+			}
+		}
+		if list.Continue == "" {
+			return nil
+		}
+		token = list.Continue
+	}
+}
+
 func (c *Client) ClusterDelete(id string) error {
+	return c.ClusterDeleteConfirm(id, "")
+}
+
+// Deletes the cluster with its contents, as allowed by its delete
+// policy.  The hash of its delete impact confirms what is deleted.
+func (c *Client) ClusterDeleteConfirm(id, hash string) error {
 
 	// Create DELETE request
-	req, err := http.NewRequest("DELETE", c.host+"/clusters/"+id, nil)
+	url := c.host + "/clusters/" + id
+	if hash != "" {
+		url += "?confirm=" + hash
+	}
+	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		return err
 	}
@@ -144,8 +254,127 @@ func (c *Client) ClusterDelete(id string) error {
 	if err != nil {
 		return err
 	}
+
+	// Clusters with a force or cascade delete policy are
+	// deleted asynchronously with their contents
+	switch r.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusAccepted:
+	default:
+		return errorFromResponse(r)
+	}
+
+	// Wait for response
+	r, err = c.waitForResponseWithTimer(r, time.Millisecond*250)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode != http.StatusNoContent {
+		return errorFromResponse(r)
+	}
+
+	return nil
+}
+
+func (c *Client) ClusterPolicy(id string, request *api.ClusterPolicyRequest) error {
+	// Marshal request to JSON
+	buffer, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	// Create a request
+	req, err := http.NewRequest("PUT",
+		c.host+"/clusters/"+id+"/policy",
+		bytes.NewBuffer(buffer))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return err
+	}
+
+	// Get info
+	r, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode != http.StatusOK {
+		return errorFromResponse(r)
+	}
+	return nil
+}
+
+func (c *Client) ClusterRebalancePolicy(id string, request *api.ClusterRebalancePolicyRequest) error {
+	// Marshal request to JSON
+	buffer, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	// Create a request
+	req, err := http.NewRequest("PUT",
+		c.host+"/clusters/"+id+"/rebalance-policy",
+		bytes.NewBuffer(buffer))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return err
+	}
+
+	// Get info
+	r, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode != http.StatusOK {
+		return errorFromResponse(r)
+	}
+
+	return nil
+}
+
+// Keeps the bricks created in the cluster within the sizes of the
+// policy
+func (c *Client) ClusterStoragePolicy(id string, request *api.ClusterStoragePolicy) error {
+	// Marshal request to JSON
+	buffer, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	// Create a request
+	req, err := http.NewRequest("PUT",
+		c.host+"/clusters/"+id+"/storage-policy",
+		bytes.NewBuffer(buffer))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return err
+	}
+
+	// Get info
+	r, err := c.do(req)
+	if err != nil {
+		return err
+	}
 	if r.StatusCode != http.StatusOK {
-		return utils.GetErrorFromResponse(r)
+		return errorFromResponse(r)
 	}
 
 	return nil
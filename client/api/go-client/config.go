@@ -0,0 +1,60 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"net/http"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+// Makes the server read its configuration file again and apply the
+// settings which can be changed without a restart
+func (c *Client) ConfigReload() (*api.ConfigReloadResponse, error) {
+
+	// Create a request
+	req, err := http.NewRequest("POST", c.host+"/admin/config/reload", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var resp api.ConfigReloadResponse
+	err = utils.GetJsonFromResponse(r, &resp)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
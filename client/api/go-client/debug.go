@@ -0,0 +1,64 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"net/http"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+// Returns a snapshot of the state of the server, with the stacks of
+// its goroutines if requested
+func (c *Client) DebugState(stacks bool) (*api.DebugStateResponse, error) {
+
+	// Create request
+	url := c.host + "/internal/debug/state"
+	if stacks {
+		url += "?stacks=true"
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get state
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var state api.DebugStateResponse
+	err = utils.GetJsonFromResponse(r, &state)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
@@ -26,6 +26,11 @@ import (
 )
 
 func (c *Client) DeviceAdd(request *api.DeviceAddRequest) error {
+	return c.DeviceAddIdempotent(request, "")
+}
+
+// Adds the device once for all the calls with the same key
+func (c *Client) DeviceAddIdempotent(request *api.DeviceAddRequest, key string) error {
 	// Marshal request to JSON
 	buffer, err := json.Marshal(request)
 	if err != nil {
@@ -38,6 +43,9 @@ func (c *Client) DeviceAdd(request *api.DeviceAddRequest) error {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
 
 	// Set token
 	err = c.setToken(req)
@@ -50,26 +58,35 @@ func (c *Client) DeviceAdd(request *api.DeviceAddRequest) error {
 	if err != nil {
 		return err
 	}
-	if r.StatusCode != http.StatusAccepted {
-		return utils.GetErrorFromResponse(r)
-	}
 
-	// Wait for response
-	r, err = c.waitForResponseWithTimer(r, time.Second)
-	if err != nil {
-		return err
+	// Repeats of a request return its result once its operation
+	// is gone
+	if r.StatusCode == http.StatusAccepted {
+		r, err = c.waitForResponseWithTimer(r, time.Second)
+		if err != nil {
+			return err
+		}
 	}
 	if r.StatusCode != http.StatusNoContent {
-		return utils.GetErrorFromResponse(r)
+		return errorFromResponse(r)
 	}
 
 	return nil
 }
 
 func (c *Client) DeviceInfo(id string) (*api.DeviceInfoResponse, error) {
+	return c.deviceInfo(id, "")
+}
+
+// Also returns the recent allocations on the device
+func (c *Client) DeviceInfoWithHistory(id string) (*api.DeviceInfoResponse, error) {
+	return c.deviceInfo(id, "?history=true")
+}
+
+func (c *Client) deviceInfo(id, query string) (*api.DeviceInfoResponse, error) {
 
 	// Create request
-	req, err := http.NewRequest("GET", c.host+"/devices/"+id, nil)
+	req, err := http.NewRequest("GET", c.host+"/devices/"+id+query, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +103,7 @@ func (c *Client) DeviceInfo(id string) (*api.DeviceInfoResponse, error) {
 		return nil, err
 	}
 	if r.StatusCode != http.StatusOK {
-		return nil, utils.GetErrorFromResponse(r)
+		return nil, errorFromResponse(r)
 	}
 
 	// Read JSON response
@@ -120,7 +137,7 @@ func (c *Client) DeviceDelete(id string) error {
 		return err
 	}
 	if r.StatusCode != http.StatusAccepted {
-		return utils.GetErrorFromResponse(r)
+		return errorFromResponse(r)
 	}
 
 	// Wait for response
@@ -129,7 +146,7 @@ func (c *Client) DeviceDelete(id string) error {
 		return err
 	}
 	if r.StatusCode != http.StatusNoContent {
-		return utils.GetErrorFromResponse(r)
+		return errorFromResponse(r)
 	}
 
 	return nil
@@ -165,7 +182,75 @@ func (c *Client) DeviceState(id string,
 		return err
 	}
 	if r.StatusCode != http.StatusOK {
-		return utils.GetErrorFromResponse(r)
+		return errorFromResponse(r)
 	}
 	return nil
 }
+
+func (c *Client) DeviceTrim(id string) (*api.DeviceTrimResponse, error) {
+
+	// Create request
+	req, err := http.NewRequest("POST", c.host+"/devices/"+id+"/trim", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var trim api.DeviceTrimResponse
+	err = utils.GetJsonFromResponse(r, &trim)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &trim, nil
+}
+
+func (c *Client) DeviceScrub(id string) (*api.ScrubResult, error) {
+
+	// Create request
+	req, err := http.NewRequest("POST", c.host+"/devices/"+id+"/scrub", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var result api.ScrubResult
+	err = utils.GetJsonFromResponse(r, &result)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -26,6 +26,13 @@ import (
 )
 
 func (c *Client) NodeAdd(request *api.NodeAddRequest) (*api.NodeInfoResponse, error) {
+	return c.NodeAddIdempotent(request, "")
+}
+
+// Adds the node once for all the calls with the same key, which
+// return the node added by the first
+func (c *Client) NodeAddIdempotent(request *api.NodeAddRequest,
+	key string) (*api.NodeInfoResponse, error) {
 
 	// Marshal request to JSON
 	buffer, err := json.Marshal(request)
@@ -39,6 +46,9 @@ func (c *Client) NodeAdd(request *api.NodeAddRequest) (*api.NodeInfoResponse, er
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
 
 	// Set token
 	err = c.setToken(req)
@@ -51,17 +61,17 @@ func (c *Client) NodeAdd(request *api.NodeAddRequest) (*api.NodeInfoResponse, er
 	if err != nil {
 		return nil, err
 	}
-	if r.StatusCode != http.StatusAccepted {
-		return nil, utils.GetErrorFromResponse(r)
-	}
 
-	// Wait for response
-	r, err = c.waitForResponseWithTimer(r, time.Millisecond*250)
-	if err != nil {
-		return nil, err
+	// Repeats of a request return its result once its operation
+	// is gone
+	if r.StatusCode == http.StatusAccepted {
+		r, err = c.waitForResponseWithTimer(r, time.Millisecond*250)
+		if err != nil {
+			return nil, err
+		}
 	}
 	if r.StatusCode != http.StatusOK {
-		return nil, utils.GetErrorFromResponse(r)
+		return nil, errorFromResponse(r)
 	}
 
 	// Read JSON response
@@ -75,6 +85,68 @@ func (c *Client) NodeAdd(request *api.NodeAddRequest) (*api.NodeInfoResponse, er
 	return &node, nil
 }
 
+func (c *Client) NodeList() (*api.NodeListResponse, error) {
+	return c.NodeListPage(0, "")
+}
+
+// Returns up to limit nodes following the page of the token, or all
+// of them if limit is zero and the token empty.  The continue token of
+// the response gets the next page.
+func (c *Client) NodeListPage(limit int, token string) (*api.NodeListResponse, error) {
+
+	// Create request
+	req, err := http.NewRequest("GET", listPath(c.host+"/nodes", limit, token), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get info
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var nodes api.NodeListResponse
+	err = utils.GetJsonFromResponse(r, &nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nodes, nil
+}
+
+// Calls fn with the id of each node, getting them in pages of limit
+// ids.  Stops at the first error returned by fn.
+func (c *Client) NodeListEach(limit int, fn func(id string) error) error {
+	token := ""
+	for {
+		list, err := c.NodeListPage(limit, token)
+		if err != nil {
+			return err
+		}
+		for _, id := range list.Nodes {
+			err := fn(id)
+			if err != nil {
+				return err
+			}
+		}
+		if list.Continue == "" {
+			return nil
+		}
+		token = list.Continue
+	}
+}
+
 func (c *Client) NodeInfo(id string) (*api.NodeInfoResponse, error) {
 
 	// Create request
@@ -95,7 +167,7 @@ func (c *Client) NodeInfo(id string) (*api.NodeInfoResponse, error) {
 		return nil, err
 	}
 	if r.StatusCode != http.StatusOK {
-		return nil, utils.GetErrorFromResponse(r)
+		return nil, errorFromResponse(r)
 	}
 
 	// Read JSON response
@@ -109,10 +181,90 @@ func (c *Client) NodeInfo(id string) (*api.NodeInfoResponse, error) {
 	return &node, nil
 }
 
+// Lists the volumes with bricks on the node
+func (c *Client) NodeVolumes(id string) (*api.NodeVolumesResponse, error) {
+
+	// Create request
+	req, err := http.NewRequest("GET", c.host+"/nodes/"+id+"/volumes", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get info
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var volumes api.NodeVolumesResponse
+	err = utils.GetJsonFromResponse(r, &volumes)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &volumes, nil
+}
+
+// Checks the memory and CPUs of the node
+func (c *Client) NodeMetrics(id string) (*api.NodeMetricsResponse, error) {
+
+	// Create request
+	req, err := http.NewRequest("GET", c.host+"/nodes/"+id+"/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get metrics
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var metrics api.NodeMetricsResponse
+	err = utils.GetJsonFromResponse(r, &metrics)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &metrics, nil
+}
+
 func (c *Client) NodeDelete(id string) error {
+	return c.nodeDelete(id, "")
+}
+
+// Deletes the node with the devices without bricks it still has, when
+// they are already gone from the node
+func (c *Client) NodeDeleteForce(id string) error {
+	return c.nodeDelete(id, "?force=true")
+}
+
+func (c *Client) nodeDelete(id, query string) error {
 
 	// Create a request
-	req, err := http.NewRequest("DELETE", c.host+"/nodes/"+id, nil)
+	req, err := http.NewRequest("DELETE", c.host+"/nodes/"+id+query, nil)
 	if err != nil {
 		return err
 	}
@@ -129,7 +281,7 @@ func (c *Client) NodeDelete(id string) error {
 		return err
 	}
 	if r.StatusCode != http.StatusAccepted {
-		return utils.GetErrorFromResponse(r)
+		return errorFromResponse(r)
 	}
 
 	// Wait for response
@@ -138,7 +290,7 @@ func (c *Client) NodeDelete(id string) error {
 		return err
 	}
 	if r.StatusCode != http.StatusNoContent {
-		return utils.GetErrorFromResponse(r)
+		return errorFromResponse(r)
 	}
 
 	return nil
@@ -172,7 +324,125 @@ func (c *Client) NodeState(id string, request *api.StateRequest) error {
 		return err
 	}
 	if r.StatusCode != http.StatusOK {
-		return utils.GetErrorFromResponse(r)
+		return errorFromResponse(r)
 	}
 	return nil
 }
+
+func (c *Client) NodeOwner(id string, request *api.NodeOwnerRequest) error {
+	// Marshal request to JSON
+	buffer, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	// Create a request
+	req, err := http.NewRequest("POST",
+		c.host+"/nodes/"+id+"/owner",
+		bytes.NewBuffer(buffer))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return err
+	}
+
+	// Get info
+	r, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode != http.StatusOK {
+		return errorFromResponse(r)
+	}
+	return nil
+}
+
+// Changes the settings of the node given in the request
+func (c *Client) NodeUpdate(id string, request *api.NodeUpdateRequest) (*api.NodeInfoResponse, error) {
+	// Marshal request to JSON
+	buffer, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a request
+	req, err := http.NewRequest("PUT",
+		c.host+"/nodes/"+id,
+		bytes.NewBuffer(buffer))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get info
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var node api.NodeInfoResponse
+	err = utils.GetJsonFromResponse(r, &node)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &node, nil
+}
+
+func (c *Client) NodeZone(id string, request *api.NodeZoneRequest) (*api.NodeZoneResponse, error) {
+	// Marshal request to JSON
+	buffer, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a request
+	req, err := http.NewRequest("PUT",
+		c.host+"/nodes/"+id+"/zone",
+		bytes.NewBuffer(buffer))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get info
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var zone api.NodeZoneResponse
+	err = utils.GetJsonFromResponse(r, &zone)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &zone, nil
+}
@@ -0,0 +1,205 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/heketi/pkg/utils"
+)
+
+func (c *Client) AsyncOperationList() (*api.AsyncOperationListResponse, error) {
+
+	// Create request
+	req, err := http.NewRequest("GET", c.host+"/queue", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get list
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var list api.AsyncOperationListResponse
+	err = utils.GetJsonFromResponse(r, &list)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+func (c *Client) AsyncOperationInfo(id string) (*api.AsyncOperationInfoResponse, error) {
+
+	// Create request
+	req, err := http.NewRequest("GET", c.host+"/queue/"+id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without it the status of the operation is returned, as
+	// when waiting for it
+	req.Header.Set("Accept", "application/json")
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get info
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var info api.AsyncOperationInfoResponse
+	err = utils.GetJsonFromResponse(r, &info)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// Cancels an operation which has not started, or asks a running
+// operation to stop and roll back.  The outcome is only known once
+// the operation completes.
+func (c *Client) AsyncOperationCancel(id string) error {
+
+	// Create a request
+	req, err := http.NewRequest("DELETE", c.host+"/queue/"+id, nil)
+	if err != nil {
+		return err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return err
+	}
+
+	// Send request
+	r, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode != http.StatusAccepted && r.StatusCode != http.StatusNoContent {
+		return errorFromResponse(r)
+	}
+
+	return nil
+}
+
+func (c *Client) AsyncOperationLimits() (*api.AsyncOperationLimits, error) {
+
+	// Create request
+	req, err := http.NewRequest("GET", c.host+"/admin/operations/limits", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get limits
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var limits api.AsyncOperationLimits
+	err = utils.GetJsonFromResponse(r, &limits)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &limits, nil
+}
+
+// Changes the limits on concurrent operations until the server
+// restarts.  Queued operations start at once if they are raised.
+func (c *Client) AsyncOperationLimitsSet(request *api.AsyncOperationLimits) (*api.AsyncOperationLimits, error) {
+
+	// Marshal request to JSON
+	buffer, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a request
+	req, err := http.NewRequest("PUT", c.host+"/admin/operations/limits",
+		bytes.NewBuffer(buffer))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var limits api.AsyncOperationLimits
+	err = utils.GetJsonFromResponse(r, &limits)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &limits, nil
+}
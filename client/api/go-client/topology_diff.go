@@ -0,0 +1,214 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+)
+
+type TopologyChange string
+
+const (
+	TopologyAdded    TopologyChange = "added"
+	TopologyRemoved  TopologyChange = "removed"
+	TopologyModified TopologyChange = "modified"
+)
+
+type TopologyDiffEntry struct {
+	Id     string         `json:"id"`
+	Name   string         `json:"name"`
+	Change TopologyChange `json:"change"`
+
+	// Fields which differ in a modified entry
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Differences between two topology snapshots.  Entries are sorted by id.
+type TopologyDiffReport struct {
+	Nodes   []TopologyDiffEntry `json:"nodes"`
+	Devices []TopologyDiffEntry `json:"devices"`
+	Volumes []TopologyDiffEntry `json:"volumes"`
+}
+
+func (r *TopologyDiffReport) Empty() bool {
+	return len(r.Nodes) == 0 && len(r.Devices) == 0 && len(r.Volumes) == 0
+}
+
+// A field of an entry to compare
+type topologyField struct {
+	name          string
+	before, after interface{}
+}
+
+// Devices are compared along with the node they belong to
+type topologyDevice struct {
+	api.DeviceInfoResponse
+	NodeId string
+}
+
+func TopologyDiff(before, after *api.TopologyInfoResponse) TopologyDiffReport {
+	bnodes, bdevices, bvolumes := topologyItems(before)
+	anodes, adevices, avolumes := topologyItems(after)
+
+	return TopologyDiffReport{
+		Nodes:   topologyDiffItems(bnodes, anodes, nodeFields),
+		Devices: topologyDiffItems(bdevices, adevices, deviceFields),
+		Volumes: topologyDiffItems(bvolumes, avolumes, volumeFields),
+	}
+}
+
+// Returns the nodes, devices and volumes of the topology by id
+func topologyItems(topo *api.TopologyInfoResponse) (nodes,
+	devices,
+	volumes map[string]interface{}) {
+
+	nodes = make(map[string]interface{})
+	devices = make(map[string]interface{})
+	volumes = make(map[string]interface{})
+	if topo == nil {
+		return
+	}
+
+	for _, cluster := range topo.ClusterList {
+		for _, node := range cluster.Nodes {
+			nodes[node.Id] = node
+			for _, device := range node.DevicesInfo {
+				devices[device.Id] = topologyDevice{
+					DeviceInfoResponse: device,
+					NodeId:             node.Id,
+				}
+			}
+		}
+		for _, volume := range cluster.Volumes {
+			volumes[volume.Id] = volume
+		}
+	}
+
+	return
+}
+
+func topologyDiffItems(before, after map[string]interface{},
+	fields func(before, after interface{}) (string, []topologyField)) []TopologyDiffEntry {
+
+	diff := make([]TopologyDiffEntry, 0)
+
+	for id, b := range before {
+		a, ok := after[id]
+		if !ok {
+			name, _ := fields(b, b)
+			diff = append(diff, TopologyDiffEntry{
+				Id:     id,
+				Name:   name,
+				Change: TopologyRemoved,
+			})
+			continue
+		}
+
+		name, list := fields(b, a)
+		changed := make([]string, 0)
+		for _, field := range list {
+			if !reflect.DeepEqual(field.before, field.after) {
+				changed = append(changed, field.name)
+			}
+		}
+		if len(changed) > 0 {
+			diff = append(diff, TopologyDiffEntry{
+				Id:     id,
+				Name:   name,
+				Change: TopologyModified,
+				Fields: changed,
+			})
+		}
+	}
+
+	for id, a := range after {
+		if _, ok := before[id]; !ok {
+			name, _ := fields(a, a)
+			diff = append(diff, TopologyDiffEntry{
+				Id:     id,
+				Name:   name,
+				Change: TopologyAdded,
+			})
+		}
+	}
+
+	sort.Sort(topologyDiffById(diff))
+	return diff
+}
+
+func nodeFields(before, after interface{}) (string, []topologyField) {
+	b := before.(api.NodeInfoResponse)
+	a := after.(api.NodeInfoResponse)
+
+	name := ""
+	if len(a.Hostnames.Manage) > 0 {
+		name = a.Hostnames.Manage[0]
+	}
+
+	return name, []topologyField{
+		{"cluster", b.ClusterId, a.ClusterId},
+		{"zone", b.Zone, a.Zone},
+		{"hostnames", b.Hostnames, a.Hostnames},
+		{"state", b.State, a.State},
+		{"storage_class", b.StorageClass, a.StorageClass},
+		{"owner", b.Owner, a.Owner},
+		{"tags", b.Tags, a.Tags},
+	}
+}
+
+func deviceFields(before, after interface{}) (string, []topologyField) {
+	b := before.(topologyDevice)
+	a := after.(topologyDevice)
+
+	return a.Name, []topologyField{
+		{"name", b.Name, a.Name},
+		{"node", b.NodeId, a.NodeId},
+		{"state", b.State, a.State},
+		{"storage", b.Storage, a.Storage},
+	}
+}
+
+func volumeFields(before, after interface{}) (string, []topologyField) {
+	b := before.(api.VolumeInfoResponse)
+	a := after.(api.VolumeInfoResponse)
+
+	return a.Name, []topologyField{
+		{"name", b.Name, a.Name},
+		{"cluster", b.Cluster, a.Cluster},
+		{"size", b.Size, a.Size},
+		{"durability", b.Durability, a.Durability},
+		{"bricks", brickIds(b.Bricks), brickIds(a.Bricks)},
+	}
+}
+
+func brickIds(bricks []api.BrickInfo) []string {
+	ids := make([]string, 0, len(bricks))
+	for _, brick := range bricks {
+		ids = append(ids, brick.Id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+type topologyDiffById []TopologyDiffEntry
+
+func (d topologyDiffById) Len() int           { return len(d) }
+func (d topologyDiffById) Less(i, j int) bool { return d[i].Id < d[j].Id }
+func (d topologyDiffById) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
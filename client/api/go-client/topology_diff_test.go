@@ -0,0 +1,150 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/heketi/tests"
+)
+
+func sampleTopology() *api.TopologyInfoResponse {
+	node := func(id, host string, devices ...string) api.NodeInfoResponse {
+		n := api.NodeInfoResponse{}
+		n.Id = id
+		n.ClusterId = "c1"
+		n.Zone = 1
+		n.State = api.EntryStateOnline
+		n.Hostnames.Manage = []string{host}
+		n.Hostnames.Storage = []string{host}
+		for _, d := range devices {
+			device := api.DeviceInfoResponse{}
+			device.Id = d
+			device.Name = "/dev/" + d
+			device.State = api.EntryStateOnline
+			device.Storage.Total = 1000
+			device.Storage.Free = 1000
+			n.DevicesInfo = append(n.DevicesInfo, device)
+		}
+		return n
+	}
+
+	volume := api.VolumeInfoResponse{}
+	volume.Id = "v1"
+	volume.Name = "vol_v1"
+	volume.Size = 10
+	volume.Cluster = "c1"
+	volume.Bricks = []api.BrickInfo{{Id: "b1"}, {Id: "b2"}}
+
+	return &api.TopologyInfoResponse{
+		ClusterList: []api.Cluster{
+			{
+				Id:      "c1",
+				Nodes:   []api.NodeInfoResponse{node("n1", "host1", "d1", "d2"), node("n2", "host2", "d3")},
+				Volumes: []api.VolumeInfoResponse{volume},
+			},
+		},
+	}
+}
+
+// Returns a deep copy of the topology
+func copyTopology(t *testing.T, topo *api.TopologyInfoResponse) *api.TopologyInfoResponse {
+	data, err := json.Marshal(topo)
+	tests.Assert(t, err == nil)
+	var c api.TopologyInfoResponse
+	err = json.Unmarshal(data, &c)
+	tests.Assert(t, err == nil)
+	return &c
+}
+
+func TestTopologyDiffNoChanges(t *testing.T) {
+	before := sampleTopology()
+	after := copyTopology(t, before)
+
+	report := TopologyDiff(before, after)
+	tests.Assert(t, report.Empty())
+	tests.Assert(t, len(report.Nodes) == 0)
+	tests.Assert(t, len(report.Devices) == 0)
+	tests.Assert(t, len(report.Volumes) == 0)
+}
+
+func TestTopologyDiff(t *testing.T) {
+	before := sampleTopology()
+	after := copyTopology(t, before)
+	cluster := &after.ClusterList[0]
+
+	// Modify node n1 and its device d1, remove device d2
+	cluster.Nodes[0].Zone = 2
+	cluster.Nodes[0].State = api.EntryStateOffline
+	cluster.Nodes[0].DevicesInfo[0].Storage.Free = 500
+	cluster.Nodes[0].DevicesInfo = cluster.Nodes[0].DevicesInfo[:1]
+
+	// Remove node n2 with its device, add node n3
+	n3 := cluster.Nodes[1]
+	n3.Id = "n3"
+	n3.Hostnames.Manage = []string{"host3"}
+	n3.DevicesInfo = []api.DeviceInfoResponse{}
+	cluster.Nodes[1] = n3
+
+	// Expand the volume and add another one
+	cluster.Volumes[0].Size = 20
+	cluster.Volumes[0].Bricks = append(cluster.Volumes[0].Bricks, api.BrickInfo{Id: "b3"})
+	v2 := api.VolumeInfoResponse{}
+	v2.Id = "v2"
+	v2.Name = "vol_v2"
+	cluster.Volumes = append(cluster.Volumes, v2)
+
+	report := TopologyDiff(before, after)
+	tests.Assert(t, !report.Empty())
+
+	tests.Assert(t, reflect.DeepEqual(report.Nodes, []TopologyDiffEntry{
+		{Id: "n1", Name: "host1", Change: TopologyModified, Fields: []string{"zone", "state"}},
+		{Id: "n2", Name: "host2", Change: TopologyRemoved},
+		{Id: "n3", Name: "host3", Change: TopologyAdded},
+	}), report.Nodes)
+
+	tests.Assert(t, reflect.DeepEqual(report.Devices, []TopologyDiffEntry{
+		{Id: "d1", Name: "/dev/d1", Change: TopologyModified, Fields: []string{"storage"}},
+		{Id: "d2", Name: "/dev/d2", Change: TopologyRemoved},
+		{Id: "d3", Name: "/dev/d3", Change: TopologyRemoved},
+	}), report.Devices)
+
+	tests.Assert(t, reflect.DeepEqual(report.Volumes, []TopologyDiffEntry{
+		{Id: "v1", Name: "vol_v1", Change: TopologyModified, Fields: []string{"size", "bricks"}},
+		{Id: "v2", Name: "vol_v2", Change: TopologyAdded},
+	}), report.Volumes)
+}
+
+func TestTopologyDiffDeviceMoved(t *testing.T) {
+	before := sampleTopology()
+	after := copyTopology(t, before)
+	nodes := after.ClusterList[0].Nodes
+
+	// Move d2 from n1 to n2
+	nodes[1].DevicesInfo = append(nodes[1].DevicesInfo, nodes[0].DevicesInfo[1])
+	nodes[0].DevicesInfo = nodes[0].DevicesInfo[:1]
+
+	report := TopologyDiff(before, after)
+	tests.Assert(t, len(report.Nodes) == 0)
+	tests.Assert(t, len(report.Volumes) == 0)
+	tests.Assert(t, reflect.DeepEqual(report.Devices, []TopologyDiffEntry{
+		{Id: "d2", Name: "/dev/d2", Change: TopologyModified, Fields: []string{"node"}},
+	}), report.Devices)
+}
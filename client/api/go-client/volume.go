@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/heketi/heketi/pkg/glusterfs/api"
@@ -28,6 +29,13 @@ import (
 
 func (c *Client) VolumeCreate(request *api.VolumeCreateRequest) (
 	*api.VolumeInfoResponse, error) {
+	return c.VolumeCreateIdempotent(request, "")
+}
+
+// Creates the volume once for all the calls with the same key, which
+// return the volume created by the first
+func (c *Client) VolumeCreateIdempotent(request *api.VolumeCreateRequest,
+	key string) (*api.VolumeInfoResponse, error) {
 
 	// Marshal request to JSON
 	buffer, err := json.Marshal(request)
@@ -43,6 +51,109 @@ func (c *Client) VolumeCreate(request *api.VolumeCreateRequest) (
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Repeats of a request return its result once its operation
+	// is gone
+	if r.StatusCode == http.StatusAccepted {
+		r, err = c.waitForResponseWithTimer(r, time.Second)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var volume api.VolumeInfoResponse
+	err = utils.GetJsonFromResponse(r, &volume)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &volume, nil
+
+}
+
+// Returns where the bricks of the volume requested would be placed,
+// without creating it
+func (c *Client) VolumeDryRun(request *api.VolumeCreateRequest) (
+	*api.DryRunResult, error) {
+
+	// Marshal request to JSON
+	buffer, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a request
+	req, err := http.NewRequest("POST",
+		c.host+"/volumes/dry-run",
+		bytes.NewBuffer(buffer))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var result api.DryRunResult
+	err = utils.GetJsonFromResponse(r, &result)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *Client) VolumeExpand(id string, request *api.VolumeExpandRequest) (
+	*api.VolumeInfoResponse, error) {
+
+	// Marshal request to JSON
+	buffer, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a request
+	req, err := http.NewRequest("POST",
+		c.host+"/volumes/"+id+"/expand",
+		bytes.NewBuffer(buffer))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
 
 	// Set token
 	err = c.setToken(req)
@@ -56,7 +167,7 @@ func (c *Client) VolumeCreate(request *api.VolumeCreateRequest) (
 		return nil, err
 	}
 	if r.StatusCode != http.StatusAccepted {
-		return nil, utils.GetErrorFromResponse(r)
+		return nil, errorFromResponse(r)
 	}
 
 	// Wait for response
@@ -65,7 +176,7 @@ func (c *Client) VolumeCreate(request *api.VolumeCreateRequest) (
 		return nil, err
 	}
 	if r.StatusCode != http.StatusOK {
-		return nil, utils.GetErrorFromResponse(r)
+		return nil, errorFromResponse(r)
 	}
 
 	// Read JSON response
@@ -80,7 +191,9 @@ func (c *Client) VolumeCreate(request *api.VolumeCreateRequest) (
 
 }
 
-func (c *Client) VolumeExpand(id string, request *api.VolumeExpandRequest) (
+// Removes bricks from the volume to reduce its size by at least the
+// size requested
+func (c *Client) VolumeShrink(id string, request *api.VolumeShrinkRequest) (
 	*api.VolumeInfoResponse, error) {
 
 	// Marshal request to JSON
@@ -91,7 +204,7 @@ func (c *Client) VolumeExpand(id string, request *api.VolumeExpandRequest) (
 
 	// Create a request
 	req, err := http.NewRequest("POST",
-		c.host+"/volumes/"+id+"/expand",
+		c.host+"/volumes/"+id+"/shrink",
 		bytes.NewBuffer(buffer))
 	if err != nil {
 		return nil, err
@@ -110,7 +223,7 @@ func (c *Client) VolumeExpand(id string, request *api.VolumeExpandRequest) (
 		return nil, err
 	}
 	if r.StatusCode != http.StatusAccepted {
-		return nil, utils.GetErrorFromResponse(r)
+		return nil, errorFromResponse(r)
 	}
 
 	// Wait for response
@@ -119,7 +232,7 @@ func (c *Client) VolumeExpand(id string, request *api.VolumeExpandRequest) (
 		return nil, err
 	}
 	if r.StatusCode != http.StatusOK {
-		return nil, utils.GetErrorFromResponse(r)
+		return nil, errorFromResponse(r)
 	}
 
 	// Read JSON response
@@ -131,13 +244,65 @@ func (c *Client) VolumeExpand(id string, request *api.VolumeExpandRequest) (
 	}
 
 	return &volume, nil
+}
 
+// Changes the name of the volume in heketi.  The volume keeps the
+// name it was created with in GlusterFS.
+func (c *Client) VolumeRename(id string, request *api.VolumeRenameRequest) (
+	*api.VolumeInfoResponse, error) {
+
+	// Marshal request to JSON
+	buffer, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a request
+	req, err := http.NewRequest("PUT",
+		c.host+"/volumes/"+id+"/name",
+		bytes.NewBuffer(buffer))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var volume api.VolumeInfoResponse
+	err = utils.GetJsonFromResponse(r, &volume)
+	if err != nil {
+		return nil, err
+	}
+
+	return &volume, nil
 }
 
 func (c *Client) VolumeList() (*api.VolumeListResponse, error) {
+	return c.VolumeListPage(0, "")
+}
+
+// Returns up to limit volumes following the page of the token, or all
+// of them if limit is zero and the token empty.  The continue token of
+// the response gets the next page.
+func (c *Client) VolumeListPage(limit int, token string) (*api.VolumeListResponse, error) {
 
 	// Create request
-	req, err := http.NewRequest("GET", c.host+"/volumes", nil)
+	req, err := http.NewRequest("GET", listPath(c.host+"/volumes", limit, token), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -154,7 +319,7 @@ func (c *Client) VolumeList() (*api.VolumeListResponse, error) {
 		return nil, err
 	}
 	if r.StatusCode != http.StatusOK {
-		return nil, utils.GetErrorFromResponse(r)
+		return nil, errorFromResponse(r)
 	}
 
 	// Read JSON response
@@ -167,6 +332,28 @@ func (c *Client) VolumeList() (*api.VolumeListResponse, error) {
 	return &volumes, nil
 }
 
+// Calls fn with the id of each volume, getting them in pages of limit
+// ids.  Stops at the first error returned by fn.
+func (c *Client) VolumeListEach(limit int, fn func(id string) error) error {
+	token := ""
+	for {
+		list, err := c.VolumeListPage(limit, token)
+		if err != nil {
+			return err
+		}
+		for _, id := range list.Volumes {
+			err := fn(id)
+			if err != nil {
+				return err
+			}
+		}
+		if list.Continue == "" {
+			return nil
+		}
+		token = list.Continue
+	}
+}
+
 func (c *Client) VolumeInfo(id string) (*api.VolumeInfoResponse, error) {
 
 	// Create request
@@ -187,7 +374,7 @@ func (c *Client) VolumeInfo(id string) (*api.VolumeInfoResponse, error) {
 		return nil, err
 	}
 	if r.StatusCode != http.StatusOK {
-		return nil, utils.GetErrorFromResponse(r)
+		return nil, errorFromResponse(r)
 	}
 
 	// Read JSON response
@@ -221,7 +408,7 @@ func (c *Client) VolumeDelete(id string) error {
 		return err
 	}
 	if r.StatusCode != http.StatusAccepted {
-		return utils.GetErrorFromResponse(r)
+		return errorFromResponse(r)
 	}
 
 	// Wait for response
@@ -230,8 +417,159 @@ func (c *Client) VolumeDelete(id string) error {
 		return err
 	}
 	if r.StatusCode != http.StatusNoContent {
-		return utils.GetErrorFromResponse(r)
+		return errorFromResponse(r)
 	}
 
 	return nil
 }
+
+func (c *Client) VolumeCHAPCredentials(id string) (*api.VolumeCHAPCredentials, error) {
+
+	// Create request
+	req, err := http.NewRequest("GET", c.host+"/volumes/"+id+"/chap-credentials", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get credentials
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var creds api.VolumeCHAPCredentials
+	err = utils.GetJsonFromResponse(r, &creds)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &creds, nil
+}
+
+// Returns the command mounting the volume on a client running the
+// operating system.  The client address is optional.
+func (c *Client) VolumeMount(id, os, client string) (*api.VolumeMountResponse, error) {
+
+	query := url.Values{}
+	if os != "" {
+		query.Set("os", os)
+	}
+	if client != "" {
+		query.Set("client", client)
+	}
+	path := c.host + "/volumes/" + id + "/mount"
+	if len(query) != 0 {
+		path += "?" + query.Encode()
+	}
+
+	// Create request
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get mount command
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var mount api.VolumeMountResponse
+	err = utils.GetJsonFromResponse(r, &mount)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &mount, nil
+}
+
+func (c *Client) volumeProfile(id, op string) error {
+
+	// Create request
+	req, err := http.NewRequest("POST", c.host+"/volumes/"+id+"/profile/"+op, nil)
+	if err != nil {
+		return err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return err
+	}
+
+	// Send request
+	r, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return errorFromResponse(r)
+	}
+
+	return nil
+}
+
+func (c *Client) VolumeProfileStart(id string) error {
+	return c.volumeProfile(id, "start")
+}
+
+func (c *Client) VolumeProfileStop(id string) error {
+	return c.volumeProfile(id, "stop")
+}
+
+func (c *Client) VolumeProfileInfo(id string) (*api.VolumeProfileInfoResponse, error) {
+
+	// Create request
+	req, err := http.NewRequest("GET", c.host+"/volumes/"+id+"/profile/info", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set token
+	err = c.setToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get profile info
+	r, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(r)
+	}
+
+	// Read JSON response
+	var info api.VolumeProfileInfoResponse
+	err = utils.GetJsonFromResponse(r, &info)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
@@ -0,0 +1,136 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(backupCommand)
+	backupCommand.AddCommand(backupCreateCommand)
+	backupCommand.AddCommand(backupRestoreCommand)
+	backupCreateCommand.Flags().StringVar(&backupOutput, "output", "",
+		"\n\tOptional: File the backup is written to, by default"+
+			"\n\theketi-backup-<timestamp>.db in the current directory")
+	backupRestoreCommand.Flags().StringVar(&backupInput, "input", "",
+		"\n\tBackup file to restore")
+	backupCreateCommand.SilenceUsage = true
+	backupRestoreCommand.SilenceUsage = true
+}
+
+var (
+	backupOutput string
+	backupInput  string
+)
+
+var backupCommand = &cobra.Command{
+	Use:   "backup",
+	Short: "Heketi Database Backups",
+	Long:  "Heketi Database Backups",
+}
+
+var backupCreateCommand = &cobra.Command{
+	Use:     "create",
+	Short:   "Writes a backup of the database of the server to a file",
+	Long:    "Writes a backup of the database of the server to a file",
+	Example: "  $ heketi-cli backup create --output=heketi-backup.db",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output := backupOutput
+		if output == "" {
+			output = "heketi-backup-" +
+				time.Now().UTC().Format("20060102-150405") + ".db"
+		}
+
+		// Create a client
+		heketi := newClient()
+
+		// Written to a temporary file so that a failed backup
+		// does not leave a partial file
+		tmpfile := output + ".tmp"
+		fp, err := os.OpenFile(tmpfile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmpfile)
+
+		err = heketi.BackupDb(fp)
+		if err == nil {
+			err = fp.Sync()
+		}
+		if cerr := fp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		err = os.Rename(tmpfile, output)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "Database backed up to %v\n", output)
+		return nil
+	},
+}
+
+var backupRestoreCommand = &cobra.Command{
+	Use:   "restore",
+	Short: "Replaces the database of the server with a backup",
+	Long: "Replaces the database of the server with a backup.  The server\n" +
+		"validates the backup and replaces its database once restarted.",
+	Example: "  $ heketi-cli backup restore --input=heketi-backup.db",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if backupInput == "" {
+			return errors.New("Backup file to restore missing")
+		}
+
+		// Refuse files which are not bolt databases before sending them
+		db, err := bolt.Open(backupInput, 0600, &bolt.Options{
+			ReadOnly: true,
+			Timeout:  time.Second,
+		})
+		if err != nil {
+			return fmt.Errorf("%v is not a valid database: %v", backupInput, err)
+		}
+		db.Close()
+
+		fp, err := os.Open(backupInput)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+
+		// Create a client
+		heketi := newClient()
+
+		err = heketi.RestoreDb(fp)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "Restore of %v staged, restart the server to complete it\n",
+			backupInput)
+		return nil
+	},
+}
@@ -22,7 +22,7 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/heketi/heketi/client/api/go-client"
+	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/spf13/cobra"
 )
 
@@ -30,14 +30,37 @@ func init() {
 	RootCmd.AddCommand(clusterCommand)
 	clusterCommand.AddCommand(clusterCreateCommand)
 	clusterCommand.AddCommand(clusterDeleteCommand)
+	clusterCommand.AddCommand(clusterDeleteImpactCommand)
 	clusterCommand.AddCommand(clusterListCommand)
 	clusterCommand.AddCommand(clusterInfoCommand)
+	clusterCommand.AddCommand(clusterZonesCommand)
+	clusterCommand.AddCommand(clusterSetPolicyCommand)
+	clusterCommand.AddCommand(clusterSetRebalancePolicyCommand)
+	clusterSetRebalancePolicyCommand.Flags().StringVar(&clusterRebalanceSchedule, "schedule", "",
+		"Cron expression of the times the volumes are rebalanced, with the scheduled policy")
+	clusterCommand.AddCommand(clusterSetStoragePolicyCommand)
+	clusterSetStoragePolicyCommand.Flags().Uint64Var(&clusterMinBrickSize, "min-brick-size", 0,
+		"\n\tOptional: Minimum size in GB of the bricks created in the cluster")
+	clusterSetStoragePolicyCommand.Flags().Uint64Var(&clusterMaxBrickSize, "max-brick-size", 0,
+		"\n\tOptional: Maximum size in GB of the bricks created in the cluster")
+	clusterDeleteCommand.Flags().StringVar(&clusterDeleteConfirm, "confirm", "",
+		"\n\tOptional: Hash of the delete impact of the cluster, required to"+
+			"\n\tdelete its volumes and nodes with its delete policy")
 	clusterCreateCommand.SilenceUsage = true
 	clusterDeleteCommand.SilenceUsage = true
+	clusterDeleteImpactCommand.SilenceUsage = true
 	clusterInfoCommand.SilenceUsage = true
+	clusterZonesCommand.SilenceUsage = true
 	clusterListCommand.SilenceUsage = true
 }
 
+var (
+	clusterRebalanceSchedule string
+	clusterDeleteConfirm     string
+	clusterMinBrickSize      uint64
+	clusterMaxBrickSize      uint64
+)
+
 var clusterCommand = &cobra.Command{
 	Use:   "cluster",
 	Short: "Heketi cluster management",
@@ -51,7 +74,7 @@ var clusterCreateCommand = &cobra.Command{
 	Example: "  $ heketi-cli cluster create",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Create a client to talk to Heketi
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 		// Create cluster
 		cluster, err := heketi.ClusterCreate()
 		if err != nil {
@@ -74,10 +97,15 @@ var clusterCreateCommand = &cobra.Command{
 }
 
 var clusterDeleteCommand = &cobra.Command{
-	Use:     "delete [cluster_id]",
-	Short:   "Delete the cluster",
-	Long:    "Delete the cluster",
-	Example: "  $ heketi-cli cluster delete 886a86a868711bef83001",
+	Use:   "delete [cluster_id]",
+	Short: "Delete the cluster",
+	Long:  "Delete the cluster",
+	Example: `  * Delete an empty cluster
+      $ heketi-cli cluster delete 886a86a868711bef83001
+
+  * Delete a cluster with its contents once its impact was reviewed
+      $ heketi-cli cluster delete-impact 886a86a868711bef83001
+      $ heketi-cli cluster delete 886a86a868711bef83001 --confirm=<hash>`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		s := cmd.Flags().Args()
 
@@ -90,10 +118,10 @@ var clusterDeleteCommand = &cobra.Command{
 		clusterId := cmd.Flags().Arg(0)
 
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		//set url
-		err := heketi.ClusterDelete(clusterId)
+		err := heketi.ClusterDeleteConfirm(clusterId, clusterDeleteConfirm)
 		if err == nil {
 			fmt.Fprintf(stdout, "Cluster %v deleted\n", clusterId)
 		}
@@ -102,6 +130,171 @@ var clusterDeleteCommand = &cobra.Command{
 	},
 }
 
+var clusterDeleteImpactCommand = &cobra.Command{
+	Use:     "delete-impact [cluster_id]",
+	Short:   "Show what deleting the cluster deletes",
+	Long:    "Show what deleting the cluster with its contents deletes, and the hash to confirm the delete with",
+	Example: "  $ heketi-cli cluster delete-impact 886a86a868711bef83001",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+
+		//ensure proper number of args
+		if len(s) < 1 {
+			return errors.New("Cluster id missing")
+		}
+
+		// Create a client
+		heketi := newClient()
+
+		impact, err := heketi.ClusterDeleteImpact(cmd.Flags().Arg(0))
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(impact)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			fmt.Fprintf(stdout, "Cluster: %v\n"+
+				"Volumes: %v\n"+
+				"Bricks: %v\n"+
+				"Storage freed (GiB): %v\n"+
+				"Nodes: %v\n"+
+				"Devices: %v\n",
+				impact.Id,
+				impact.Volumes,
+				impact.Bricks,
+				impact.StorageFreed/(1024*1024),
+				impact.Nodes,
+				impact.Devices)
+			for _, pvc := range impact.Pvcs {
+				fmt.Fprintf(stdout, "PVC orphaned: %v\n", pvc)
+			}
+			fmt.Fprintf(stdout, "Confirm with: --confirm=%v\n", impact.Hash)
+		}
+
+		return nil
+	},
+}
+
+var clusterSetPolicyCommand = &cobra.Command{
+	Use:   "set-delete-policy [cluster_id] [policy]",
+	Short: "Set what deleting the cluster does with its contents",
+	Long: "Set what deleting the cluster does with its contents: fail if it has volumes or nodes, " +
+		"force to delete its volumes and nodes without devices, or cascade to delete its volumes, " +
+		"devices and nodes",
+	Example: "  $ heketi-cli cluster set-delete-policy 886a86a868711bef83001 cascade",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+
+		//ensure proper number of args
+		if len(s) < 2 {
+			return errors.New("Cluster id and policy required")
+		}
+
+		//set clusterId
+		clusterId := cmd.Flags().Arg(0)
+
+		// Create a client
+		heketi := newClient()
+
+		req := &api.ClusterPolicyRequest{
+			DeletePolicy: cmd.Flags().Arg(1),
+		}
+		err := heketi.ClusterPolicy(clusterId, req)
+		if err == nil {
+			fmt.Fprintf(stdout, "Delete policy of cluster %v is now %v\n",
+				clusterId, req.DeletePolicy)
+		}
+
+		return err
+	},
+}
+
+var clusterSetRebalancePolicyCommand = &cobra.Command{
+	Use:   "set-rebalance-policy [cluster_id] [policy]",
+	Short: "Set when the volumes of the cluster are rebalanced",
+	Long: "Set when the volumes of the cluster are rebalanced: never, on-expand after a node " +
+		"or a device is added, or scheduled at the times of the cron expression given with --schedule",
+	Example: `  $ heketi-cli cluster set-rebalance-policy 886a86a868711bef83001 on-expand
+  $ heketi-cli cluster set-rebalance-policy 886a86a868711bef83001 scheduled --schedule="0 2 * * 6"`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+
+		//ensure proper number of args
+		if len(s) < 2 {
+			return errors.New("Cluster id and policy required")
+		}
+
+		//set clusterId
+		clusterId := cmd.Flags().Arg(0)
+
+		// Create a client
+		heketi := newClient()
+
+		req := &api.ClusterRebalancePolicyRequest{
+			Policy:   cmd.Flags().Arg(1),
+			Schedule: clusterRebalanceSchedule,
+		}
+		err := heketi.ClusterRebalancePolicy(clusterId, req)
+		if err == nil {
+			fmt.Fprintf(stdout, "Rebalance policy of cluster %v is now %v\n",
+				clusterId, req.Policy)
+		}
+
+		return err
+	},
+}
+
+var clusterSetStoragePolicyCommand = &cobra.Command{
+	Use:   "set-storage-policy [cluster_id]",
+	Short: "Set the sizes of the bricks created in the cluster",
+	Long: "Set the sizes of the bricks created in the cluster.  Volumes are split in more " +
+		"bricks to stay under the maximum, and cannot be created with bricks under the " +
+		"minimum.  Sizes not given are not limited.",
+	Example: "  $ heketi-cli cluster set-storage-policy 886a86a868711bef83001 --min-brick-size=10 --max-brick-size=2048",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+
+		//ensure proper number of args
+		if len(s) < 1 {
+			return errors.New("Cluster id missing")
+		}
+
+		//set clusterId
+		clusterId := cmd.Flags().Arg(0)
+
+		// Create a client
+		heketi := newClient()
+
+		req := &api.ClusterStoragePolicy{
+			MinBrickSizeGB: clusterMinBrickSize,
+			MaxBrickSizeGB: clusterMaxBrickSize,
+		}
+		err := heketi.ClusterStoragePolicy(clusterId, req)
+		if err == nil {
+			fmt.Fprintf(stdout, "Storage policy of cluster %v is now %v\n",
+				clusterId, storagePolicyString(req))
+		}
+
+		return err
+	},
+}
+
+func storagePolicyString(policy *api.ClusterStoragePolicy) string {
+	size := func(gb uint64) string {
+		if gb == 0 {
+			return "unlimited"
+		}
+		return fmt.Sprintf("%v GB", gb)
+	}
+	return fmt.Sprintf("bricks of min %v, max %v",
+		size(policy.MinBrickSizeGB), size(policy.MaxBrickSizeGB))
+}
+
 var clusterInfoCommand = &cobra.Command{
 	Use:     "info [cluster_id]",
 	Short:   "Retrieves information about cluster",
@@ -117,7 +310,7 @@ var clusterInfoCommand = &cobra.Command{
 		clusterId := cmd.Flags().Arg(0)
 
 		// Create a client to talk to Heketi
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		// Create cluster
 		info, err := heketi.ClusterInfo(clusterId)
@@ -134,6 +327,18 @@ var clusterInfoCommand = &cobra.Command{
 			fmt.Fprintf(stdout, string(data))
 		} else {
 			fmt.Fprintf(stdout, "Cluster id: %v\n", info.Id)
+			if info.DeletePolicy != "" {
+				fmt.Fprintf(stdout, "Delete policy: %v\n", info.DeletePolicy)
+			}
+			if info.RebalancePolicy != "" {
+				fmt.Fprintf(stdout, "Rebalance policy: %v\n", info.RebalancePolicy)
+			}
+			if info.RebalanceSchedule != "" {
+				fmt.Fprintf(stdout, "Rebalance schedule: %v\n", info.RebalanceSchedule)
+			}
+			if info.StoragePolicy != nil {
+				fmt.Fprintf(stdout, "Storage policy: %v\n", storagePolicyString(info.StoragePolicy))
+			}
 			fmt.Fprintf(stdout, "Nodes:\n%v", strings.Join(info.Nodes, "\n"))
 			fmt.Fprintf(stdout, "\nVolumes:\n%v", strings.Join(info.Volumes, "\n"))
 		}
@@ -142,6 +347,48 @@ var clusterInfoCommand = &cobra.Command{
 	},
 }
 
+var clusterZonesCommand = &cobra.Command{
+	Use:     "zones [cluster_id]",
+	Short:   "Shows the nodes, devices and storage of each zone of a cluster",
+	Long:    "Shows the nodes, devices and storage of each zone of a cluster",
+	Example: "  $ heketi-cli cluster zones 886a86a868711bef83001",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+		if len(s) < 1 {
+			return errors.New("Cluster id missing")
+		}
+		clusterId := cmd.Flags().Arg(0)
+
+		// Create a client to talk to Heketi
+		heketi := newClient()
+
+		info, err := heketi.ClusterZones(clusterId)
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			for _, zone := range info.Zones {
+				fmt.Fprintf(stdout, "Zone: %v\tNodes: %v\tDevices: %v\t"+
+					"Size (GiB): %v\tFree (GiB): %v\n",
+					zone.ZoneId,
+					zone.NodeCount,
+					zone.DeviceCount,
+					zone.StorageSize/(1024*1024),
+					zone.StorageFree/(1024*1024))
+			}
+		}
+
+		return nil
+	},
+}
+
 var clusterListCommand = &cobra.Command{
 	Use:     "list",
 	Short:   "Lists the clusters managed by Heketi",
@@ -149,7 +396,7 @@ var clusterListCommand = &cobra.Command{
 	Example: "  $ heketi-cli cluster list",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		// List clusters
 		list, err := heketi.ClusterList()
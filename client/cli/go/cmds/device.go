@@ -20,14 +20,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
-	"github.com/heketi/heketi/client/api/go-client"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/spf13/cobra"
 )
 
 var (
-	device, nodeId string
+	device, nodeId     string
+	deviceTrim         bool
+	deviceHistory      bool
+	deviceMaxBrickSize int
 )
 
 func init() {
@@ -37,10 +40,18 @@ func init() {
 	deviceCommand.AddCommand(deviceInfoCommand)
 	deviceCommand.AddCommand(deviceEnableCommand)
 	deviceCommand.AddCommand(deviceDisableCommand)
+	deviceCommand.AddCommand(deviceTrimCommand)
+	deviceCommand.AddCommand(deviceScrubCommand)
 	deviceAddCommand.Flags().StringVar(&device, "name", "",
 		"Name of device to add")
 	deviceAddCommand.Flags().StringVar(&nodeId, "node", "",
 		"Id of the node which has this device")
+	deviceAddCommand.Flags().BoolVar(&deviceTrim, "trim", false,
+		"Mount bricks with discard and trim the device periodically")
+	deviceAddCommand.Flags().IntVar(&deviceMaxBrickSize, "max-brick-size", 0,
+		"Size in GiB of the largest brick placed on the device, not limited if not set")
+	deviceInfoCommand.Flags().BoolVar(&deviceHistory, "history", false,
+		"Also show the recent bricks placed on the device")
 	deviceAddCommand.SilenceUsage = true
 	deviceDeleteCommand.SilenceUsage = true
 	deviceInfoCommand.SilenceUsage = true
@@ -67,14 +78,19 @@ var deviceAddCommand = &cobra.Command{
 		if nodeId == "" {
 			return errors.New("Missing node id")
 		}
+		if deviceMaxBrickSize < 0 {
+			return errors.New("Invalid max brick size")
+		}
 
 		// Create request blob
 		req := &api.DeviceAddRequest{}
 		req.Name = device
 		req.NodeId = nodeId
+		req.TrimEnabled = deviceTrim
+		req.MaxBrickSize = uint64(deviceMaxBrickSize) * 1024 * 1024
 
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		// Add node
 		err := heketi.DeviceAdd(req)
@@ -105,7 +121,7 @@ var deviceDeleteCommand = &cobra.Command{
 		deviceId := cmd.Flags().Arg(0)
 
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		//set url
 		err := heketi.DeviceDelete(deviceId)
@@ -133,10 +149,18 @@ var deviceInfoCommand = &cobra.Command{
 		deviceId := cmd.Flags().Arg(0)
 
 		// Create a client to talk to Heketi
-		heketi := client.NewClient(options.Url, options.User, options.Key)
-
-		// Create cluster
-		info, err := heketi.DeviceInfo(deviceId)
+		heketi := newClient()
+
+		// Get device information
+		var (
+			info *api.DeviceInfoResponse
+			err  error
+		)
+		if deviceHistory {
+			info, err = heketi.DeviceInfoWithHistory(deviceId)
+		} else {
+			info, err = heketi.DeviceInfo(deviceId)
+		}
 		if err != nil {
 			return err
 		}
@@ -151,15 +175,27 @@ var deviceInfoCommand = &cobra.Command{
 			fmt.Fprintf(stdout, "Device Id: %v\n"+
 				"Name: %v\n"+
 				"State: %v\n"+
+				"Trim: %v\n"+
 				"Size (GiB): %v\n"+
 				"Used (GiB): %v\n"+
-				"Free (GiB): %v\n",
+				"Free (GiB): %v\n"+
+				"Watermark: %v\n",
 				info.Id,
 				info.Name,
 				info.State,
+				info.TrimEnabled,
 				info.Storage.Total/(1024*1024),
 				info.Storage.Used/(1024*1024),
-				info.Storage.Free/(1024*1024))
+				info.Storage.Free/(1024*1024),
+				info.Watermark)
+			if info.RawSize != 0 && info.RawSize != info.Storage.Total {
+				fmt.Fprintf(stdout, "Raw size (GiB): %v\n",
+					info.RawSize/(1024*1024))
+			}
+			if info.MaxBrickSize != 0 {
+				fmt.Fprintf(stdout, "Max brick size (GiB): %v\n",
+					info.MaxBrickSize/(1024*1024))
+			}
 
 			fmt.Fprintf(stdout, "Bricks:\n")
 			for _, d := range info.Bricks {
@@ -169,6 +205,26 @@ var deviceInfoCommand = &cobra.Command{
 					d.Id,
 					d.Size/(1024*1024),
 					d.Path)
+				if d.IOStats != nil {
+					fmt.Fprintf(stdout, "    Clients:%-4v"+
+						"Reads/s:%-10.1f"+
+						"Writes/s:%.1f\n",
+						d.IOStats.ClientCount,
+						d.IOStats.ReadOpsPerSec,
+						d.IOStats.WriteOpsPerSec)
+				}
+			}
+
+			if deviceHistory {
+				fmt.Fprintf(stdout, "Allocations:\n")
+				for _, a := range info.AllocationHistory {
+					fmt.Fprintf(stdout, "Volume:%-35v"+
+						"Size (GiB):%-8v"+
+						"Time: %v\n",
+						a.VolumeId,
+						a.Size/(1024*1024),
+						time.Unix(a.Time, 0).Format(time.RFC3339))
+				}
 			}
 		}
 		return nil
@@ -193,7 +249,7 @@ var deviceEnableCommand = &cobra.Command{
 		deviceId := cmd.Flags().Arg(0)
 
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		//set url
 		req := &api.StateRequest{
@@ -225,7 +281,7 @@ var deviceDisableCommand = &cobra.Command{
 		deviceId := cmd.Flags().Arg(0)
 
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		//set url
 		req := &api.StateRequest{
@@ -239,3 +295,85 @@ var deviceDisableCommand = &cobra.Command{
 		return err
 	},
 }
+
+var deviceTrimCommand = &cobra.Command{
+	Use:     "trim [device_id]",
+	Short:   "Discards unused blocks of the bricks on the device",
+	Long:    "Discards unused blocks of the bricks on the device",
+	Example: "  $ heketi-cli device trim 886a86a868711bef83001",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+
+		//ensure proper number of args
+		if len(s) < 1 {
+			return errors.New("device id missing")
+		}
+
+		deviceId := cmd.Flags().Arg(0)
+
+		// Create a client
+		heketi := newClient()
+
+		trim, err := heketi.DeviceTrim(deviceId)
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(trim)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			fmt.Fprintf(stdout, "Device %v trimmed %v MiB\n",
+				deviceId, trim.Trimmed/1024)
+		}
+
+		return nil
+	},
+}
+
+var deviceScrubCommand = &cobra.Command{
+	Use:     "scrub [device_id]",
+	Short:   "Scrubs the volumes of the bricks on the device for bit rot",
+	Long:    "Scrubs the volumes of the bricks on the device for bit rot",
+	Example: "  $ heketi-cli device scrub 886a86a868711bef83001",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+
+		//ensure proper number of args
+		if len(s) < 1 {
+			return errors.New("device id missing")
+		}
+
+		deviceId := cmd.Flags().Arg(0)
+
+		// Create a client
+		heketi := newClient()
+
+		result, err := heketi.DeviceScrub(deviceId)
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			for _, brick := range result.Bricks {
+				fmt.Fprintf(stdout, "Id:%v    Volume:%v    Path:%v    Status:%v",
+					brick.Id, brick.VolumeId, brick.Path, brick.Status)
+				if brick.Error != "" {
+					fmt.Fprintf(stdout, "    Error:%v", brick.Error)
+				}
+				fmt.Fprintf(stdout, "\n")
+			}
+		}
+
+		return nil
+	},
+}
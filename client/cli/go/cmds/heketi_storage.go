@@ -276,7 +276,7 @@ var setupHeketiStorageCommand = &cobra.Command{
 		list.Items = make([]interface{}, 0)
 
 		// Create client
-		c := client.NewClient(options.Url, options.User, options.Key)
+		c := newClient()
 
 		// Create volume
 		volume, err := createHeketiStorageVolume(c)
@@ -20,8 +20,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 
-	"github.com/heketi/heketi/client/api/go-client"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
 	"github.com/spf13/cobra"
 )
@@ -31,6 +31,11 @@ var (
 	managmentHostNames string
 	storageHostNames   string
 	clusterId          string
+	nodeStorageClass   string
+	nodeOwner          string
+	affinityGroup      string
+	createCluster      bool
+	nodeForce          bool
 )
 
 func init() {
@@ -40,13 +45,24 @@ func init() {
 	nodeCommand.AddCommand(nodeInfoCommand)
 	nodeCommand.AddCommand(nodeEnableCommand)
 	nodeCommand.AddCommand(nodeDisableCommand)
+	nodeCommand.AddCommand(nodeSetOwnerCommand)
+	nodeCommand.AddCommand(nodeSetZoneCommand)
+	nodeCommand.AddCommand(nodeSetPreferredCommand)
+	nodeCommand.AddCommand(nodeSetMaxBrickOpsCommand)
+	nodeCommand.AddCommand(nodeVolumesCommand)
 	nodeAddCommand.Flags().IntVar(&zone, "zone", -1, "The zone in which the node should reside")
 	nodeAddCommand.Flags().StringVar(&clusterId, "cluster", "", "The cluster in which the node should reside")
 	nodeAddCommand.Flags().StringVar(&managmentHostNames, "management-host-name", "", "Managment host name")
 	nodeAddCommand.Flags().StringVar(&storageHostNames, "storage-host-name", "", "Storage host name")
+	nodeAddCommand.Flags().StringVar(&nodeStorageClass, "storage-class", "", "Optional: Storage class of the node: hdd, ssd, or nvme")
+	nodeAddCommand.Flags().StringVar(&nodeOwner, "owner", "", "Optional: Tenant for which the node is reserved")
+	nodeAddCommand.Flags().StringVar(&affinityGroup, "affinity-group", "", "Optional: Group of nodes which never have two bricks of a set")
+	nodeAddCommand.Flags().BoolVar(&createCluster, "create-cluster", false, "Optional: Create the cluster if it does not exist, or a new one if no cluster is given")
+	nodeDeleteCommand.Flags().BoolVar(&nodeForce, "force", false, "Optional: Delete the devices without bricks left on the node, when they are already gone from it")
 	nodeAddCommand.SilenceUsage = true
 	nodeDeleteCommand.SilenceUsage = true
 	nodeInfoCommand.SilenceUsage = true
+	nodeVolumesCommand.SilenceUsage = true
 }
 
 var nodeCommand = &cobra.Command{
@@ -76,7 +92,7 @@ var nodeAddCommand = &cobra.Command{
 		if storageHostNames == "" {
 			return errors.New("Missing storage hostname")
 		}
-		if clusterId == "" {
+		if clusterId == "" && !createCluster {
 			return errors.New("Missing cluster id")
 		}
 
@@ -86,9 +102,13 @@ var nodeAddCommand = &cobra.Command{
 		req.Hostnames.Manage = []string{managmentHostNames}
 		req.Hostnames.Storage = []string{storageHostNames}
 		req.Zone = zone
+		req.StorageClass = nodeStorageClass
+		req.Owner = nodeOwner
+		req.AffinityGroup = affinityGroup
+		req.CreateCluster = createCluster
 
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		// Add node
 		node, err := heketi.NodeAdd(req)
@@ -138,10 +158,15 @@ var nodeDeleteCommand = &cobra.Command{
 		nodeId := cmd.Flags().Arg(0)
 
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		//set url
-		err := heketi.NodeDelete(nodeId)
+		var err error
+		if nodeForce {
+			err = heketi.NodeDeleteForce(nodeId)
+		} else {
+			err = heketi.NodeDelete(nodeId)
+		}
 		if err == nil {
 			fmt.Fprintf(stdout, "Node %v deleted\n", nodeId)
 		}
@@ -167,7 +192,7 @@ var nodeEnableCommand = &cobra.Command{
 		nodeId := cmd.Flags().Arg(0)
 
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		//set url
 		req := &api.StateRequest{
@@ -199,7 +224,7 @@ var nodeDisableCommand = &cobra.Command{
 		nodeId := cmd.Flags().Arg(0)
 
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		//set url
 		req := &api.StateRequest{
@@ -214,6 +239,231 @@ var nodeDisableCommand = &cobra.Command{
 	},
 }
 
+var nodeSetOwnerCommand = &cobra.Command{
+	Use:     "set-owner [node_id] [owner]",
+	Short:   "Reserve a node for a tenant",
+	Long:    "Reserve a node for a tenant.  Without an owner the node is available to all tenants",
+	Example: "  $ heketi-cli node set-owner 886a86a868711bef83001 tenant-a",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+
+		//ensure proper number of args
+		if len(s) < 1 {
+			return errors.New("Node id missing")
+		}
+
+		//set nodeId
+		nodeId := cmd.Flags().Arg(0)
+
+		// Create a client
+		heketi := newClient()
+
+		req := &api.NodeOwnerRequest{
+			Owner: cmd.Flags().Arg(1),
+		}
+		err := heketi.NodeOwner(nodeId, req)
+		if err == nil {
+			if req.Owner == "" {
+				fmt.Fprintf(stdout, "Node %v is now available to all tenants\n", nodeId)
+			} else {
+				fmt.Fprintf(stdout, "Node %v is now reserved for %v\n", nodeId, req.Owner)
+			}
+		}
+
+		return err
+	},
+}
+
+var nodeSetZoneCommand = &cobra.Command{
+	Use:     "set-zone [node_id] [zone]",
+	Short:   "Move a node to another zone",
+	Long:    "Move a node to another zone.  Existing bricks are not moved, the volumes which may have bricks of a set in the same zone are listed",
+	Example: "  $ heketi-cli node set-zone 886a86a868711bef83001 2",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+
+		//ensure proper number of args
+		if len(s) < 2 {
+			return errors.New("Node id and zone required")
+		}
+
+		//set nodeId
+		nodeId := cmd.Flags().Arg(0)
+		zone, err := strconv.Atoi(cmd.Flags().Arg(1))
+		if err != nil {
+			return fmt.Errorf("Invalid zone %v", cmd.Flags().Arg(1))
+		}
+
+		// Create a client
+		heketi := newClient()
+
+		req := &api.NodeZoneRequest{
+			Zone: zone,
+		}
+		info, err := heketi.NodeZone(nodeId, req)
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			fmt.Fprintf(stdout, "Node %v moved from zone %v to zone %v\n",
+				nodeId, info.PreviousZone, info.Zone)
+			for _, volume := range info.Volumes {
+				fmt.Fprintf(stdout, "\nVolume %v (%v) has bricks on nodes %v of the same zone\n",
+					volume.Name, volume.Id, volume.Nodes)
+				for _, step := range volume.Remediation {
+					fmt.Fprintf(stdout, "  - %v\n", step)
+				}
+			}
+		}
+
+		return nil
+	},
+}
+
+var nodeSetPreferredCommand = &cobra.Command{
+	Use:     "set-preferred [node_id] [true|false]",
+	Short:   "Set whether new bricks are placed on the node first",
+	Long:    "Set whether new bricks are placed on the node before the nodes which are not preferred, while it has the space for them.  Existing bricks are not moved",
+	Example: "  $ heketi-cli node set-preferred 886a86a868711bef83001 true",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+
+		//ensure proper number of args
+		if len(s) < 2 {
+			return errors.New("Node id and preference required")
+		}
+
+		//set nodeId
+		nodeId := cmd.Flags().Arg(0)
+		preferred, err := strconv.ParseBool(cmd.Flags().Arg(1))
+		if err != nil {
+			return fmt.Errorf("Invalid preference %v", cmd.Flags().Arg(1))
+		}
+
+		// Create a client
+		heketi := newClient()
+
+		req := &api.NodeUpdateRequest{
+			PreferredForNewBricks: &preferred,
+		}
+		node, err := heketi.NodeUpdate(nodeId, req)
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(node)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else if node.PreferredForNewBricks {
+			fmt.Fprintf(stdout, "Node %v is preferred for new bricks\n", nodeId)
+		} else {
+			fmt.Fprintf(stdout, "Node %v is not preferred for new bricks\n", nodeId)
+		}
+
+		return nil
+	},
+}
+
+var nodeSetMaxBrickOpsCommand = &cobra.Command{
+	Use:     "set-max-brick-ops [node_id] [count]",
+	Short:   "Set the brick operations run at once on the node",
+	Long:    "Set the brick creations and deletions run at once on the node, or 0 for the default of the server",
+	Example: "  $ heketi-cli node set-max-brick-ops 886a86a868711bef83001 1",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+
+		//ensure proper number of args
+		if len(s) < 2 {
+			return errors.New("Node id and count required")
+		}
+
+		//set nodeId
+		nodeId := cmd.Flags().Arg(0)
+		count, err := strconv.Atoi(cmd.Flags().Arg(1))
+		if err != nil || count < 0 {
+			return fmt.Errorf("Invalid count %v", cmd.Flags().Arg(1))
+		}
+
+		// Create a client
+		heketi := newClient()
+
+		req := &api.NodeUpdateRequest{
+			MaxConcurrentBrickOps: &count,
+		}
+		node, err := heketi.NodeUpdate(nodeId, req)
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(node)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			fmt.Fprintf(stdout, "Node %v runs up to %v brick operations at once\n",
+				nodeId, node.MaxConcurrentBrickOps)
+		}
+
+		return nil
+	},
+}
+
+var nodeVolumesCommand = &cobra.Command{
+	Use:     "volumes [node_id]",
+	Short:   "Lists the volumes with bricks on the node",
+	Long:    "Lists the volumes with bricks on the node",
+	Example: "  $ heketi-cli node volumes 886a86a868711bef83001",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		//ensure proper number of args
+		s := cmd.Flags().Args()
+		if len(s) < 1 {
+			return errors.New("Node id missing")
+		}
+
+		// Create a client to talk to Heketi
+		heketi := newClient()
+
+		volumes, err := heketi.NodeVolumes(cmd.Flags().Arg(0))
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(volumes)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			for _, v := range volumes.Volumes {
+				fmt.Fprintf(stdout, "Id:%-35v"+
+					"Name:%-20v"+
+					"Size (GiB):%-8v"+
+					"Durability:%-12v"+
+					"Bricks:%v\n",
+					v.Id,
+					v.Name,
+					v.Size,
+					v.Durability,
+					len(v.Bricks))
+			}
+		}
+		return nil
+	},
+}
+
 var nodeInfoCommand = &cobra.Command{
 	Use:     "info [node_id]",
 	Short:   "Retreives information about the node",
@@ -230,7 +480,7 @@ var nodeInfoCommand = &cobra.Command{
 		nodeId := cmd.Flags().Arg(0)
 
 		// Create a client to talk to Heketi
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		// Create cluster
 		info, err := heketi.NodeInfo(nodeId)
@@ -257,6 +507,9 @@ var nodeInfoCommand = &cobra.Command{
 				info.Zone,
 				info.Hostnames.Manage[0],
 				info.Hostnames.Storage[0])
+			if info.AffinityGroup != "" {
+				fmt.Fprintf(stdout, "Affinity Group: %v\n", info.AffinityGroup)
+			}
 			fmt.Fprintf(stdout, "Devices:\n")
 			for _, d := range info.DevicesInfo {
 				fmt.Fprintf(stdout, "Id:%-35v"+
@@ -0,0 +1,379 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(operationsCommand)
+	operationsCommand.AddCommand(operationsListCommand)
+	operationsCommand.AddCommand(operationsInfoCommand)
+	operationsCommand.AddCommand(operationsCancelCommand)
+	operationsCommand.AddCommand(operationsLimitsCommand)
+	operationsCommand.AddCommand(operationsSetLimitsCommand)
+	operationsSetLimitsCommand.Flags().IntVar(&operationsMax, "max", 0,
+		"\n\tMaximum number of operations running at once, 0 for no limit")
+	operationsSetLimitsCommand.Flags().StringVar(&operationsTypeLimits, "types", "",
+		"\n\tOptional: Comma separated limits by type of operation,"+
+			"\n\tfor example DeviceRemove=2,VolumeCreate=10")
+	operationsInfoCommand.Flags().BoolVar(&operationsWatch, "watch", false,
+		"\n\tOptional: Show the steps of the operation as it runs,"+
+			"\n\tuntil it completes")
+	operationsListCommand.SilenceUsage = true
+	operationsInfoCommand.SilenceUsage = true
+	operationsCancelCommand.SilenceUsage = true
+	operationsLimitsCommand.SilenceUsage = true
+	operationsSetLimitsCommand.SilenceUsage = true
+}
+
+var (
+	operationsMax        int
+	operationsTypeLimits string
+	operationsWatch      bool
+)
+
+var operationsCommand = &cobra.Command{
+	Use:   "operations",
+	Short: "Heketi Asynchronous Operations",
+	Long:  "Heketi Asynchronous Operations",
+}
+
+func operationOutcome(op *api.AsyncOperation) string {
+	switch op.State {
+	case api.AsyncOperationFailed, api.AsyncOperationCancelled:
+		return op.Error
+	case api.AsyncOperationSucceeded:
+		return op.Location
+	}
+	return ""
+}
+
+// Returns the step of the operation with its progress, such as
+// "creating bricks 12/60 (20%)"
+func operationStep(step *api.AsyncOperationStep) string {
+	if step == nil {
+		return ""
+	}
+	s := step.Name
+	if step.Total != 0 {
+		s += fmt.Sprintf(" %v/%v (%v%%)", step.Done, step.Total, step.Percent)
+	}
+	if step.Detail != "" {
+		s += ", " + step.Detail
+	}
+	return s
+}
+
+// Returns the percent of the pending operation done with the time
+// left, if known, such as "40% (about 2m0s left)"
+func operationProgress(op *api.AsyncOperation) string {
+	if op.State != api.AsyncOperationPending || op.QueuePosition != 0 {
+		return ""
+	}
+	s := fmt.Sprintf("%v%%", op.Progress)
+	if op.Eta != 0 {
+		s += fmt.Sprintf(" (about %v left)", time.Duration(op.Eta)*time.Second)
+	}
+	return s
+}
+
+// Prints the steps of the operation as they change, until it completes
+func watchOperation(id string, interval time.Duration) error {
+	heketi := newClient()
+
+	last := ""
+	for {
+		info, err := heketi.AsyncOperationInfo(id)
+		if err != nil {
+			return err
+		}
+		if step := operationStep(info.Step); step != last && step != "" {
+			fmt.Fprintf(stdout, "%v %v", time.Now().Format(time.RFC3339), step)
+			if progress := operationProgress(&info.AsyncOperation); progress != "" {
+				fmt.Fprintf(stdout, " [%v]", progress)
+			}
+			fmt.Fprintf(stdout, "\n")
+			last = step
+		}
+		if info.State != api.AsyncOperationPending {
+			fmt.Fprintf(stdout, "State: %v\n", info.State)
+			if outcome := operationOutcome(&info.AsyncOperation); outcome != "" {
+				fmt.Fprintf(stdout, "Outcome: %v\n", outcome)
+			}
+			return nil
+		}
+		time.Sleep(interval)
+	}
+}
+
+var operationsListCommand = &cobra.Command{
+	Use:     "list",
+	Short:   "Lists the running and recently completed operations",
+	Long:    "Lists the running and recently completed operations",
+	Example: "  $ heketi-cli operations list",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Create a client
+		heketi := newClient()
+
+		// List operations
+		list, err := heketi.AsyncOperationList()
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(list)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			for _, op := range list.Operations {
+				fmt.Fprintf(stdout, "Id:%v    Type:%v    State:%v    Started:%v    Targets:%v",
+					op.Id,
+					op.Type,
+					op.State,
+					time.Unix(op.Started, 0).Format(time.RFC3339),
+					strings.Join(op.Targets, ","))
+				if op.QueuePosition != 0 {
+					fmt.Fprintf(stdout, "    Queued:%v", op.QueuePosition)
+				}
+				if op.QueueStatus != "" {
+					fmt.Fprintf(stdout, "    Status:%v", op.QueueStatus)
+				}
+				if step := operationStep(op.Step); step != "" {
+					fmt.Fprintf(stdout, "    Step:%v", step)
+				}
+				if progress := operationProgress(&op); progress != "" {
+					fmt.Fprintf(stdout, "    Progress:%v", progress)
+				}
+				if outcome := operationOutcome(&op); outcome != "" {
+					fmt.Fprintf(stdout, "    Outcome:%v", outcome)
+				}
+				fmt.Fprintf(stdout, "\n")
+			}
+		}
+
+		return nil
+	},
+}
+
+var operationsInfoCommand = &cobra.Command{
+	Use:   "info [operation_id]",
+	Short: "Retrieves information about an operation",
+	Long:  "Retrieves information about an operation",
+	Example: `  * Show an operation
+      $ heketi-cli operations info 886a86a868711bef83001
+
+  * Show the steps of an operation as it runs
+      $ heketi-cli operations info --watch 886a86a868711bef83001`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+		if len(s) < 1 {
+			return errors.New("Operation id missing")
+		}
+		id := cmd.Flags().Arg(0)
+
+		if operationsWatch {
+			return watchOperation(id, 2*time.Second)
+		}
+
+		// Create a client
+		heketi := newClient()
+
+		info, err := heketi.AsyncOperationInfo(id)
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			fmt.Fprintf(stdout, "Id: %v\n"+
+				"Type: %v\n"+
+				"Request: %v %v\n"+
+				"Request Id: %v\n"+
+				"User: %v\n"+
+				"Targets: %v\n"+
+				"State: %v\n"+
+				"Started: %v\n",
+				info.Id,
+				info.Type,
+				info.Method, info.Path,
+				info.RequestId,
+				info.User,
+				strings.Join(info.Targets, ","),
+				info.State,
+				time.Unix(info.Started, 0).Format(time.RFC3339))
+			if info.QueuePosition != 0 {
+				fmt.Fprintf(stdout, "Queue Position: %v\n", info.QueuePosition)
+			}
+			if info.QueueStatus != "" {
+				fmt.Fprintf(stdout, "Queue Status: %v\n", info.QueueStatus)
+			}
+			if info.QueueWait != 0 {
+				fmt.Fprintf(stdout, "Queue Wait: %v\n",
+					time.Duration(info.QueueWait)*time.Second)
+			}
+			if step := operationStep(info.Step); step != "" {
+				fmt.Fprintf(stdout, "Step: %v\n", step)
+			}
+			if progress := operationProgress(&info.AsyncOperation); progress != "" {
+				fmt.Fprintf(stdout, "Progress: %v\n", progress)
+			}
+			if info.Completed != 0 {
+				fmt.Fprintf(stdout, "Completed: %v\n",
+					time.Unix(info.Completed, 0).Format(time.RFC3339))
+			}
+			if outcome := operationOutcome(&info.AsyncOperation); outcome != "" {
+				fmt.Fprintf(stdout, "Outcome: %v\n", outcome)
+			}
+			if len(info.History) != 0 {
+				fmt.Fprintf(stdout, "History:\n")
+				for _, event := range info.History {
+					fmt.Fprintf(stdout, "%v %v\n",
+						time.Unix(event.Time, 0).Format(time.RFC3339),
+						event.Message)
+				}
+			}
+		}
+
+		return nil
+	},
+}
+
+var operationsCancelCommand = &cobra.Command{
+	Use:   "cancel [operation_id]",
+	Short: "Cancels an operation",
+	Long: "Cancels an operation which has not started, or stops a running\n" +
+		"operation at its next safe point and rolls it back",
+	Example: "  $ heketi-cli operations cancel 886a86a868711bef83001",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+		if len(s) < 1 {
+			return errors.New("Operation id missing")
+		}
+		id := cmd.Flags().Arg(0)
+
+		// Create a client
+		heketi := newClient()
+
+		err := heketi.AsyncOperationCancel(id)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "Cancellation of operation %v requested\n", id)
+		return nil
+	},
+}
+
+func printOperationLimits(limits *api.AsyncOperationLimits) error {
+	if options.Json {
+		data, err := json.Marshal(limits)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, string(data))
+		return nil
+	}
+
+	if limits.Max == 0 {
+		fmt.Fprintf(stdout, "Max: unlimited\n")
+	} else {
+		fmt.Fprintf(stdout, "Max: %v\n", limits.Max)
+	}
+	types := make([]string, 0, len(limits.Types))
+	for optype := range limits.Types {
+		types = append(types, optype)
+	}
+	sort.Strings(types)
+	for _, optype := range types {
+		fmt.Fprintf(stdout, "%v: %v\n", optype, limits.Types[optype])
+	}
+	return nil
+}
+
+var operationsLimitsCommand = &cobra.Command{
+	Use:     "limits",
+	Short:   "Shows the limits on concurrent operations",
+	Long:    "Shows the limits on concurrent operations",
+	Example: "  $ heketi-cli operations limits",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Create a client
+		heketi := newClient()
+
+		limits, err := heketi.AsyncOperationLimits()
+		if err != nil {
+			return err
+		}
+
+		return printOperationLimits(limits)
+	},
+}
+
+var operationsSetLimitsCommand = &cobra.Command{
+	Use:   "set-limits",
+	Short: "Sets the limits on concurrent operations",
+	Long: "Sets the limits on concurrent operations until the server\n" +
+		"restarts.  Operations over the limits wait in a queue.",
+	Example: "  $ heketi-cli operations set-limits --max=10 --types=DeviceRemove=2",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		req := &api.AsyncOperationLimits{
+			Max:   operationsMax,
+			Types: make(map[string]int),
+		}
+		if operationsTypeLimits != "" {
+			for _, limit := range strings.Split(operationsTypeLimits, ",") {
+				kv := strings.SplitN(limit, "=", 2)
+				if len(kv) != 2 {
+					return fmt.Errorf("Invalid limit %v, expected type=max", limit)
+				}
+				max, err := strconv.Atoi(kv[1])
+				if err != nil {
+					return fmt.Errorf("Invalid limit %v: %v", limit, err)
+				}
+				req.Types[kv[0]] = max
+			}
+		}
+
+		// Create a client
+		heketi := newClient()
+
+		limits, err := heketi.AsyncOperationLimitsSet(req)
+		if err != nil {
+			return err
+		}
+
+		return printOperationLimits(limits)
+	},
+}
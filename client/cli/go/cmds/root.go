@@ -17,10 +17,12 @@
 package cmds
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"os"
 
+	"github.com/heketi/heketi/client/api/go-client"
 	"github.com/spf13/cobra"
 )
 
@@ -30,12 +32,17 @@ var (
 	stdout             io.Writer
 	options            Options
 	version            bool
+
+	// TLS configuration of the connections to the server, nil when
+	// no TLS option is set
+	tlsConfig *tls.Config
 )
 
 // Main arguments
 type Options struct {
-	Url, Key, User string
-	Json           bool
+	Url, Key, User         string
+	TlsCA, TlsCert, TlsKey string
+	Json                   bool
 }
 
 var RootCmd = &cobra.Command{
@@ -62,6 +69,18 @@ func init() {
 	RootCmd.PersistentFlags().StringVar(&options.User, "user", "",
 		"\n\tHeketi user.  Can also be set using the"+
 			"\n\tenvironment variable HEKETI_CLI_USER")
+	RootCmd.PersistentFlags().StringVar(&options.TlsCA, "tls-ca", "",
+		"\n\tFile of the CA certificates which sign the server"+
+			"\n\tcertificate, instead of the CAs of the system.  Can"+
+			"\n\talso be set using the environment variable"+
+			"\n\tHEKETI_CLI_TLS_CA")
+	RootCmd.PersistentFlags().StringVar(&options.TlsCert, "tls-cert", "",
+		"\n\tFile of the client certificate, for servers which"+
+			"\n\trequire one.  Can also be set using the environment"+
+			"\n\tvariable HEKETI_CLI_TLS_CERT")
+	RootCmd.PersistentFlags().StringVar(&options.TlsKey, "tls-key", "",
+		"\n\tFile of the key of the client certificate.  Can also"+
+			"\n\tbe set using the environment variable HEKETI_CLI_TLS_KEY")
 	RootCmd.PersistentFlags().BoolVar(&options.Json, "json", false,
 		"\n\tPrint response as JSON")
 	RootCmd.Flags().BoolVarP(&version, "version", "v", false,
@@ -88,6 +107,39 @@ func initConfig() {
 	if options.User == "" {
 		options.User = os.Getenv("HEKETI_CLI_USER")
 	}
+
+	// Check TLS
+	if options.TlsCA == "" {
+		options.TlsCA = os.Getenv("HEKETI_CLI_TLS_CA")
+	}
+	if options.TlsCert == "" {
+		options.TlsCert = os.Getenv("HEKETI_CLI_TLS_CERT")
+	}
+	if options.TlsKey == "" {
+		options.TlsKey = os.Getenv("HEKETI_CLI_TLS_KEY")
+	}
+	if options.TlsCA != "" || options.TlsCert != "" || options.TlsKey != "" {
+		tlsOptions := &client.ClientTLSOptions{
+			CAFile:   options.TlsCA,
+			CertFile: options.TlsCert,
+			KeyFile:  options.TlsKey,
+		}
+		var err error
+		tlsConfig, err = tlsOptions.TLSConfig()
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to setup TLS: %v\n", err)
+			os.Exit(3)
+		}
+	}
+}
+
+// Returns a client of the server of the options
+func newClient() *client.Client {
+	heketi := client.NewClient(options.Url, options.User, options.Key)
+	if tlsConfig != nil {
+		heketi.SetTLSConfig(tlsConfig)
+	}
+	return heketi
 }
 
 func NewHeketiCli(heketiVersion string, mstderr io.Writer, mstdout io.Writer) *cobra.Command {
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	client "github.com/heketi/heketi/client/api/go-client"
 	"github.com/heketi/heketi/pkg/glusterfs/api"
@@ -33,7 +34,11 @@ const (
 	DURABILITY_STRING_EC              = "disperse"
 )
 
-var jsonConfigFile string
+var (
+	jsonConfigFile string
+	topologyBefore string
+	topologyAfter  string
+)
 
 // Config file
 type ConfigFileNode struct {
@@ -51,11 +56,17 @@ func init() {
 	RootCmd.AddCommand(topologyCommand)
 	topologyCommand.AddCommand(topologyLoadCommand)
 	topologyCommand.AddCommand(topologyInfoCommand)
+	topologyCommand.AddCommand(topologyDiffCommand)
 	topologyLoadCommand.Flags().StringVarP(&jsonConfigFile, "json", "j", "",
 		"\n\tConfiguration containing devices, nodes, and clusters, in"+
 			"\n\tJSON format.")
 	topologyLoadCommand.SilenceUsage = true
+	topologyDiffCommand.Flags().StringVar(&topologyBefore, "before", "",
+		"\n\tTopology JSON file taken before the changes")
+	topologyDiffCommand.Flags().StringVar(&topologyAfter, "after", "",
+		"\n\tTopology JSON file taken after the changes")
 	topologyInfoCommand.SilenceUsage = true
+	topologyDiffCommand.SilenceUsage = true
 }
 
 var topologyCommand = &cobra.Command{
@@ -87,7 +98,7 @@ var topologyLoadCommand = &cobra.Command{
 		if err = configParser.Decode(&topology); err != nil {
 			return errors.New("Unable to parse config file")
 		}
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 		for _, cluster := range topology.Clusters {
 
 			fmt.Fprintf(stdout, "Creating cluster ... ")
@@ -132,7 +143,7 @@ var topologyInfoCommand = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 
 		// Create a client to talk to Heketi
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		// Create Topology
 		topoinfo, err := heketi.TopologyInfo()
@@ -257,3 +268,99 @@ var topologyInfoCommand = &cobra.Command{
 		return nil
 	},
 }
+
+// Reads a topology saved with 'heketi-cli --json topology info'
+func readTopologyFile(filename string) (*api.TopologyInfoResponse, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var topo api.TopologyInfoResponse
+	err = json.NewDecoder(fp).Decode(&topo)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %v: %v", filename, err)
+	}
+
+	return &topo, nil
+}
+
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+func printTopologyDiff(kind string, entries []client.TopologyDiffEntry) {
+	// See https://no-color.org
+	_, noColor := os.LookupEnv("NO_COLOR")
+
+	for _, entry := range entries {
+		var mark, color string
+		switch entry.Change {
+		case client.TopologyAdded:
+			mark, color = "+", colorGreen
+		case client.TopologyRemoved:
+			mark, color = "-", colorRed
+		default:
+			mark, color = "~", colorYellow
+		}
+
+		line := fmt.Sprintf("%v %v %v", mark, kind, entry.Id)
+		if entry.Name != "" {
+			line += fmt.Sprintf(" (%v)", entry.Name)
+		}
+		if len(entry.Fields) > 0 {
+			line += ": " + strings.Join(entry.Fields, ", ")
+		}
+
+		if noColor {
+			fmt.Fprintln(stdout, line)
+		} else {
+			fmt.Fprintln(stdout, color+line+colorReset)
+		}
+	}
+}
+
+var topologyDiffCommand = &cobra.Command{
+	Use:     "diff",
+	Short:   "Show the differences between two topology snapshots",
+	Long:    "Show the differences between two topology snapshots",
+	Example: " $ heketi-cli topology diff --before=before.json --after=after.json",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// Check arguments
+		if topologyBefore == "" || topologyAfter == "" {
+			return errors.New("Missing --before or --after topology file")
+		}
+
+		before, err := readTopologyFile(topologyBefore)
+		if err != nil {
+			return err
+		}
+		after, err := readTopologyFile(topologyAfter)
+		if err != nil {
+			return err
+		}
+
+		report := client.TopologyDiff(before, after)
+
+		if options.Json {
+			data, err := json.Marshal(report)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else if report.Empty() {
+			fmt.Fprintln(stdout, "No differences")
+		} else {
+			printTopologyDiff("node", report.Nodes)
+			printTopologyDiff("device", report.Devices)
+			printTopologyDiff("volume", report.Volumes)
+		}
+
+		return nil
+	},
+}
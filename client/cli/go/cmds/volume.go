@@ -39,10 +39,26 @@ var (
 	snapshotFactor float64
 	clusters       string
 	expandSize     int
+	shrinkSize     int
 	id             string
 	kubePvFile     string
 	kubePvEndpoint string
 	kubePv         bool
+	storageClass   string
+	volumeOwner    string
+	chapAuth       bool
+	chapUsername   string
+	dataLocality   string
+	preferredNode  string
+	protocol       string
+	worm           bool
+	wormDays       int
+	wormMode       string
+	sharedBricks   bool
+	mountOs        string
+	mountClient    string
+	newName        string
+	dryRun         bool
 )
 
 func init() {
@@ -50,8 +66,16 @@ func init() {
 	volumeCommand.AddCommand(volumeCreateCommand)
 	volumeCommand.AddCommand(volumeDeleteCommand)
 	volumeCommand.AddCommand(volumeExpandCommand)
+	volumeCommand.AddCommand(volumeShrinkCommand)
+	volumeCommand.AddCommand(volumeRenameCommand)
 	volumeCommand.AddCommand(volumeInfoCommand)
 	volumeCommand.AddCommand(volumeListCommand)
+	volumeCommand.AddCommand(volumeCHAPCredentialsCommand)
+	volumeCommand.AddCommand(volumeMountCommand)
+	volumeCommand.AddCommand(volumeProfileCommand)
+	volumeProfileCommand.AddCommand(volumeProfileStartCommand)
+	volumeProfileCommand.AddCommand(volumeProfileStopCommand)
+	volumeProfileCommand.AddCommand(volumeProfileInfoCommand)
 
 	volumeCreateCommand.Flags().IntVar(&size, "size", -1,
 		"\n\tSize of volume in GB")
@@ -82,6 +106,39 @@ func init() {
 			"\n\ton any of the configured clusters which have the available space."+
 			"\n\tProviding a set of clusters will ensure Heketi allocates storage"+
 			"\n\tfor this volume only in the clusters specified.")
+	volumeCreateCommand.Flags().StringVar(&storageClass, "storage-class", "",
+		"\n\tOptional: Storage class of the nodes where the volume must be"+
+			"\n\tallocated.  Values are: hdd, ssd, nvme.  If omitted, the"+
+			"\n\tserver default is used.")
+	volumeCreateCommand.Flags().StringVar(&volumeOwner, "owner", "",
+		"\n\tOptional: Tenant owning the volume.  Bricks are only placed on"+
+			"\n\tnodes reserved for the tenant or not reserved at all.")
+	volumeCreateCommand.Flags().BoolVar(&chapAuth, "chap", false,
+		"\n\tOptional: Require CHAP authentication on the iSCSI targets of"+
+			"\n\tthe volume.  The secret is generated by the server and can be"+
+			"\n\tretrieved once with 'volume chap-credentials'.")
+	volumeCreateCommand.Flags().StringVar(&chapUsername, "chap-username", "",
+		"\n\tOptional: CHAP username.  Defaults to the name of the volume.")
+	volumeCreateCommand.Flags().StringVar(&dataLocality, "data-locality", "",
+		"\n\tOptional: Place the first brick of each set on the node given by"+
+			"\n\t--preferred-node.  Values are: none, prefer-local, strict-local."+
+			"\n\tWith strict-local the volume fails unless the node has space.")
+	volumeCreateCommand.Flags().StringVar(&preferredNode, "preferred-node", "",
+		"\n\tOptional: Id of the node for --data-locality.")
+	volumeCreateCommand.Flags().StringVar(&protocol, "protocol", "",
+		"\n\tOptional: Protocol the volume is exported over besides the native"+
+			"\n\tclient.  Values are: glusterfs, nfs, smb.  Defaults to glusterfs.")
+	volumeCreateCommand.Flags().BoolVar(&worm, "worm", false,
+		"\n\tOptional: Files of the volume become read-only once written.")
+	volumeCreateCommand.Flags().IntVar(&wormDays, "worm-retention-days", 0,
+		"\n\tOptional: Days the WORM volume cannot be deleted after it is created.")
+	volumeCreateCommand.Flags().StringVar(&wormMode, "worm-retention-mode", "",
+		"\n\tOptional: Retention mode of the files of the WORM volume.  Values"+
+			"\n\tare: relax, enterprise.  Defaults to relax.")
+	volumeCreateCommand.Flags().BoolVar(&sharedBricks, "shared-bricks", false,
+		"\n\tOptional: Place the bricks in sub-directories of the bricks of"+
+			"\n\tother volumes created with --shared-bricks, sharing their"+
+			"\n\tstorage, when the sizes match.")
 	volumeCreateCommand.Flags().BoolVar(&kubePv, "persistent-volume", false,
 		"\n\tOptional: Output to standard out a peristent volume JSON file for OpenShift or"+
 			"\n\tKubernetes with the name provided.")
@@ -90,15 +147,34 @@ func init() {
 			"\n\tKubernetes with the name provided.")
 	volumeCreateCommand.Flags().StringVar(&kubePvEndpoint, "persistent-volume-endpoint", "",
 		"\n\tOptional: Endpoint name for the persistent volume")
+	volumeCreateCommand.Flags().BoolVar(&dryRun, "dry-run", false,
+		"\n\tOptional: Show where the bricks of the volume would be placed"+
+			"\n\twithout creating it.")
+	volumeMountCommand.Flags().StringVar(&mountOs, "os", api.MountOsLinux,
+		"\n\tOperating system of the client: linux, mac or windows")
+	volumeMountCommand.Flags().StringVar(&mountClient, "client", "",
+		"\n\tOptional: Address of the client, to mount from the closest host")
 	volumeExpandCommand.Flags().IntVar(&expandSize, "expand-size", -1,
 		"\n\tAmount in GB to add to the volume")
 	volumeExpandCommand.Flags().StringVar(&id, "volume", "",
 		"\n\tId of volume to expand")
+	volumeShrinkCommand.Flags().IntVar(&shrinkSize, "shrink-size", -1,
+		"\n\tAmount in GB to remove from the volume")
+	volumeShrinkCommand.Flags().StringVar(&id, "volume", "",
+		"\n\tId of volume to shrink")
+	volumeRenameCommand.Flags().StringVar(&newName, "name", "",
+		"\n\tNew name of the volume")
 	volumeCreateCommand.SilenceUsage = true
 	volumeDeleteCommand.SilenceUsage = true
 	volumeExpandCommand.SilenceUsage = true
+	volumeShrinkCommand.SilenceUsage = true
+	volumeRenameCommand.SilenceUsage = true
 	volumeInfoCommand.SilenceUsage = true
 	volumeListCommand.SilenceUsage = true
+	volumeCHAPCredentialsCommand.SilenceUsage = true
+	volumeProfileStartCommand.SilenceUsage = true
+	volumeProfileStopCommand.SilenceUsage = true
+	volumeProfileInfoCommand.SilenceUsage = true
 }
 
 var volumeCommand = &cobra.Command{
@@ -157,6 +233,17 @@ var volumeCreateCommand = &cobra.Command{
 		req.Durability.Replicate.Replica = replica
 		req.Durability.Disperse.Data = disperseData
 		req.Durability.Disperse.Redundancy = redundancy
+		req.StorageClass = storageClass
+		req.Owner = volumeOwner
+		req.CHAPAuth = chapAuth
+		req.CHAPUsername = chapUsername
+		req.DataLocality = dataLocality
+		req.PreferredNodeId = preferredNode
+		req.Protocol = protocol
+		req.WORM = worm
+		req.WORMRetentionDays = wormDays
+		req.WORMRetentionMode = wormMode
+		req.SharedBricks = sharedBricks
 
 		if volname != "" {
 			req.Name = volname
@@ -168,7 +255,11 @@ var volumeCreateCommand = &cobra.Command{
 		}
 
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
+
+		if dryRun {
+			return volumeDryRun(heketi, req)
+		}
 
 		// Add volume
 		volume, err := heketi.VolumeCreate(req)
@@ -217,6 +308,34 @@ var volumeCreateCommand = &cobra.Command{
 	},
 }
 
+// Prints where the bricks of the volume requested would be placed
+func volumeDryRun(heketi *client.Client, req *api.VolumeCreateRequest) error {
+	result, err := heketi.VolumeDryRun(req)
+	if err != nil {
+		return err
+	}
+
+	if options.Json {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, string(data))
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "Cluster: %v\nBricks:\n", result.Cluster)
+	for _, brick := range result.Bricks {
+		arbiter := ""
+		if brick.Arbiter {
+			arbiter = " (arbiter)"
+		}
+		fmt.Fprintf(stdout, "Node: %v Device: %v Size (GiB): %v%v\n",
+			brick.NodeId, brick.DeviceId, brick.Size/(1024*1024), arbiter)
+	}
+	return nil
+}
+
 var volumeDeleteCommand = &cobra.Command{
 	Use:     "delete",
 	Short:   "Deletes the volume",
@@ -234,7 +353,7 @@ var volumeDeleteCommand = &cobra.Command{
 		volumeId := cmd.Flags().Arg(0)
 
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		//set url
 		err := heketi.VolumeDelete(volumeId)
@@ -268,7 +387,7 @@ var volumeExpandCommand = &cobra.Command{
 		req.Size = expandSize
 
 		// Create client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		// Expand volume
 		volume, err := heketi.VolumeExpand(id, req)
@@ -289,6 +408,91 @@ var volumeExpandCommand = &cobra.Command{
 	},
 }
 
+var volumeShrinkCommand = &cobra.Command{
+	Use:   "shrink",
+	Short: "Shrink a volume",
+	Long: "Shrink a volume by removing whole replica or disperse sets of bricks " +
+		"once their data has moved to the other bricks",
+	Example: `  * Remove at least 10GB from a volume
+    $ heketi-cli volume shrink --volume=60d46d518074b13a04ce1022c8c7193c --shrink-size=10
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if shrinkSize == -1 {
+			return errors.New("Missing volume amount to shrink")
+		}
+
+		if id == "" {
+			return errors.New("Missing volume id")
+		}
+
+		req := &api.VolumeShrinkRequest{}
+		req.Size = shrinkSize
+
+		// Create client
+		heketi := newClient()
+
+		// Shrink volume
+		volume, err := heketi.VolumeShrink(id, req)
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(volume)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			fmt.Fprintf(stdout, "%v", volume)
+		}
+		return nil
+	},
+}
+
+var volumeRenameCommand = &cobra.Command{
+	Use:   "rename",
+	Short: "Rename a volume",
+	Long: "Rename a volume.  GlusterFS cannot rename a volume, so it keeps\n" +
+		"the name it was created with in GlusterFS, which mounts use.",
+	Example: `  * Rename a volume to project-b
+    $ heketi-cli volume rename 60d46d518074b13a04ce1022c8c7193c --name=project-b
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := cmd.Flags().Args()
+		if len(s) < 1 {
+			return errors.New("Volume id missing")
+		}
+		if newName == "" {
+			return errors.New("Missing new name of the volume")
+		}
+
+		// Create request
+		req := &api.VolumeRenameRequest{}
+		req.Name = newName
+
+		// Create client
+		heketi := newClient()
+
+		// Rename volume
+		volume, err := heketi.VolumeRename(s[0], req)
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(volume)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			fmt.Fprintf(stdout, "%v", volume)
+		}
+		return nil
+	},
+}
+
 var volumeInfoCommand = &cobra.Command{
 	Use:     "info",
 	Short:   "Retreives information about the volume",
@@ -305,7 +509,7 @@ var volumeInfoCommand = &cobra.Command{
 		volumeId := cmd.Flags().Arg(0)
 
 		// Create a client to talk to Heketi
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		// Create cluster
 		info, err := heketi.VolumeInfo(volumeId)
@@ -334,7 +538,7 @@ var volumeListCommand = &cobra.Command{
 	Example: "  $ heketi-cli volume list",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Create a client
-		heketi := client.NewClient(options.Url, options.User, options.Key)
+		heketi := newClient()
 
 		// List volumes
 		list, err := heketi.VolumeList()
@@ -365,3 +569,178 @@ var volumeListCommand = &cobra.Command{
 		return nil
 	},
 }
+
+var volumeCHAPCredentialsCommand = &cobra.Command{
+	Use:     "chap-credentials [volume_id]",
+	Short:   "Retrieves the CHAP credentials of the volume",
+	Long:    "Retrieves the CHAP credentials of the volume.  The secret can only be retrieved once.",
+	Example: "  $ heketi-cli volume chap-credentials 886a86a868711bef83001",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		//ensure proper number of args
+		s := cmd.Flags().Args()
+		if len(s) < 1 {
+			return errors.New("Volume id missing")
+		}
+
+		// Set volume id
+		volumeId := cmd.Flags().Arg(0)
+
+		// Create a client to talk to Heketi
+		heketi := newClient()
+
+		creds, err := heketi.VolumeCHAPCredentials(volumeId)
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(creds)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			fmt.Fprintf(stdout, "Username: %v\nSecret: %v\n",
+				creds.Username, creds.Secret)
+		}
+		return nil
+	},
+}
+
+var volumeMountCommand = &cobra.Command{
+	Use:   "mount [volume_id]",
+	Short: "Shows the command which mounts the volume",
+	Long:  "Shows the command which mounts the volume on a client",
+	Example: `  * Mount on a Linux client
+      $ heketi-cli volume mount 886a86a868711bef83001
+
+  * Mount on a Mac close to 192.168.10.5
+      $ heketi-cli volume mount 886a86a868711bef83001 --os=mac --client=192.168.10.5`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		//ensure proper number of args
+		s := cmd.Flags().Args()
+		if len(s) < 1 {
+			return errors.New("Volume id missing")
+		}
+
+		// Set volume id
+		volumeId := cmd.Flags().Arg(0)
+
+		// Create a client to talk to Heketi
+		heketi := newClient()
+
+		mount, err := heketi.VolumeMount(volumeId, mountOs, mountClient)
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(mount)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			fmt.Fprintln(stdout, mount.Command)
+		}
+		return nil
+	},
+}
+
+var volumeProfileCommand = &cobra.Command{
+	Use:   "profile",
+	Short: "Gathers I/O statistics on the bricks of a volume",
+	Long:  "Gathers I/O statistics on the bricks of a volume with GlusterFS profiling",
+}
+
+var volumeProfileStartCommand = &cobra.Command{
+	Use:     "start [volume_id]",
+	Short:   "Starts profiling the volume",
+	Long:    "Starts gathering I/O statistics on the bricks of the volume",
+	Example: "  $ heketi-cli volume profile start 886a86a868711bef83001",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		//ensure proper number of args
+		s := cmd.Flags().Args()
+		if len(s) < 1 {
+			return errors.New("Volume id missing")
+		}
+
+		// Set volume id
+		volumeId := cmd.Flags().Arg(0)
+
+		// Create a client to talk to Heketi
+		heketi := newClient()
+
+		err := heketi.VolumeProfileStart(volumeId)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "Profiling started on volume %v\n", volumeId)
+		return nil
+	},
+}
+
+var volumeProfileStopCommand = &cobra.Command{
+	Use:     "stop [volume_id]",
+	Short:   "Stops profiling the volume",
+	Long:    "Stops gathering I/O statistics on the bricks of the volume.  The statistics gathered are dropped.",
+	Example: "  $ heketi-cli volume profile stop 886a86a868711bef83001",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		//ensure proper number of args
+		s := cmd.Flags().Args()
+		if len(s) < 1 {
+			return errors.New("Volume id missing")
+		}
+
+		// Set volume id
+		volumeId := cmd.Flags().Arg(0)
+
+		// Create a client to talk to Heketi
+		heketi := newClient()
+
+		err := heketi.VolumeProfileStop(volumeId)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "Profiling stopped on volume %v\n", volumeId)
+		return nil
+	},
+}
+
+var volumeProfileInfoCommand = &cobra.Command{
+	Use:     "info [volume_id]",
+	Short:   "Shows the statistics gathered on the volume",
+	Long:    "Shows the I/O statistics gathered on each brick of the volume since profiling was started",
+	Example: "  $ heketi-cli volume profile info 886a86a868711bef83001",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		//ensure proper number of args
+		s := cmd.Flags().Args()
+		if len(s) < 1 {
+			return errors.New("Volume id missing")
+		}
+
+		// Set volume id
+		volumeId := cmd.Flags().Arg(0)
+
+		// Create a client to talk to Heketi
+		heketi := newClient()
+
+		info, err := heketi.VolumeProfileInfo(volumeId)
+		if err != nil {
+			return err
+		}
+
+		if options.Json {
+			data, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, string(data))
+		} else {
+			fmt.Fprintf(stdout, "%v", info)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,307 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/heketi/heketi/apps/glusterfs"
+	"github.com/heketi/heketi/executors/sshexec"
+)
+
+const dbUsage = `Usage: heketi --config=<file> db repair <command> [options]
+       heketi --config=<file> db rebuild --seed=<file> [--dry-run]
+       heketi db dump --file=<db> [--bucket=<name>] [--id=<id>] [--table]
+       heketi db dump --file=<db> --topology
+
+Repair the database while the server is stopped.  Changes are only
+printed unless --dry-run=false is given.
+
+Commands:
+  delete-node <id> [--force]    Delete a node, detaching its devices if forced
+  delete-bricks --volume <id>   Delete all the bricks of a volume
+  detach-device <id>            Delete a device and its bricks
+  merge-devices <node id>       Merge the devices of a node with the same path
+  reconcile-cluster <id>        Correct the storage accounting of a cluster
+  reindex                       Rebuild the indexes after the db was edited
+
+Rebuild a lost database from the state of the storage nodes.  The seed
+file lists the nodes of each cluster and the ssh settings used to reach
+them:
+
+  {"sshexec": {...}, "clusters": [{"nodes": [<node add request>, ...]}]}
+
+Storage which was not created by heketi is listed as unmanaged.  The
+database file must not exist.
+
+Print the entries of a database as JSON, or one line per entry with
+--table.  The server does not need to be stopped.  The buckets are
+clusters, nodes, devices, bricks, volumes and pendingops.  Values
+which cannot be decoded are printed with the error.  The topology of
+the clusters, nodes, devices and volumes is printed with --topology,
+one entry at a time so that large databases are printed in bounded
+memory.
+`
+
+// Returns the db file from the glusterfs section of the config file
+func dbFileFromConfig(configfile string) (string, error) {
+	fp, err := os.Open(configfile)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+
+	var config struct {
+		GlusterFS struct {
+			DBfile string `json:"db"`
+		} `json:"glusterfs"`
+	}
+	err = json.NewDecoder(fp).Decode(&config)
+	if err != nil {
+		return "", err
+	}
+	if config.GlusterFS.DBfile == "" {
+		return "heketi.db", nil
+	}
+
+	return config.GlusterFS.DBfile, nil
+}
+
+// Parses flags which may appear before or after the arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func dbRepair(dbfile string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Missing repair command")
+	}
+
+	var (
+		dryRun   bool
+		force    bool
+		volumeId string
+	)
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.BoolVar(&dryRun, "dry-run", true, "Only print the changes")
+	fs.BoolVar(&force, "force", false, "Detach the devices of the node")
+	fs.StringVar(&volumeId, "volume", "", "Id of the volume")
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	repair, err := glusterfs.NewDbRepair(dbfile, os.Stdout, dryRun)
+	if err != nil {
+		return err
+	}
+	defer repair.Close()
+
+	switch args[0] {
+	case "delete-node":
+		if len(positional) != 1 {
+			return errors.New("Node id missing")
+		}
+		return repair.DeleteNode(positional[0], force)
+	case "delete-bricks":
+		if volumeId == "" {
+			return errors.New("Volume id missing")
+		}
+		return repair.DeleteBricks(volumeId)
+	case "detach-device":
+		if len(positional) != 1 {
+			return errors.New("Device id missing")
+		}
+		return repair.DetachDevice(positional[0])
+	case "merge-devices":
+		if len(positional) != 1 {
+			return errors.New("Node id missing")
+		}
+		return repair.MergeDevices(positional[0])
+	case "reconcile-cluster":
+		if len(positional) != 1 {
+			return errors.New("Cluster id missing")
+		}
+		return repair.ReconcileCluster(positional[0])
+	case "reindex":
+		return repair.Reindex()
+	}
+
+	return fmt.Errorf("Unknown repair command %v", args[0])
+}
+
+func dbRebuild(dbfile string, args []string) error {
+	var (
+		dryRun   bool
+		seedfile string
+	)
+	fs := flag.NewFlagSet("rebuild", flag.ContinueOnError)
+	fs.BoolVar(&dryRun, "dry-run", false, "Only print what was found")
+	fs.StringVar(&seedfile, "seed", "", "Seed file with the nodes of each cluster")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if seedfile == "" {
+		return errors.New("Seed file missing")
+	}
+
+	fp, err := os.Open(seedfile)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	var seed glusterfs.DbRebuildSeed
+	err = json.NewDecoder(fp).Decode(&seed)
+	if err != nil {
+		return fmt.Errorf("Unable to parse seed file %v: %v", seedfile, err)
+	}
+
+	executor, err := sshexec.NewSshExecutor(&seed.SshConfig)
+	if err != nil {
+		return err
+	}
+
+	rebuild := glusterfs.NewDbRebuild(executor, os.Stdout)
+	err = rebuild.Rebuild(&seed)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+
+	return rebuild.Save(dbfile)
+}
+
+func dbDump(args []string) error {
+	var (
+		dbfile   string
+		bucket   string
+		id       string
+		table    bool
+		topology bool
+	)
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	fs.StringVar(&dbfile, "file", "", "Database file")
+	fs.StringVar(&bucket, "bucket", "", "Only print the entries of the bucket")
+	fs.StringVar(&id, "id", "", "Only print the entry with the id")
+	fs.BoolVar(&table, "table", false, "Print one line per entry")
+	fs.BoolVar(&topology, "topology", false, "Print the topology of the clusters")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	// Default to the db of the config file
+	if dbfile == "" && configfile != "" {
+		dbfile, err = dbFileFromConfig(configfile)
+		if err != nil {
+			return err
+		}
+	}
+	if dbfile == "" {
+		return errors.New("Database file missing")
+	}
+
+	dump, err := glusterfs.NewDbDump(dbfile, os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer dump.Close()
+
+	if topology {
+		return dump.PrintTopology()
+	}
+
+	var buckets []string
+	if bucket != "" {
+		buckets = []string{bucket}
+	}
+	values, err := dump.Values(buckets, id)
+	if err != nil {
+		return err
+	}
+	if id != "" && len(values) == 0 {
+		return fmt.Errorf("Id %v not found", id)
+	}
+
+	if table {
+		return dump.PrintTable(values)
+	}
+	return dump.PrintJson(values)
+}
+
+// Runs the db subcommand and returns the exit status
+func dbCommand(args []string) int {
+	if len(args) > 0 && args[0] == "dump" {
+		err := dbDump(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			fmt.Fprint(os.Stderr, dbUsage)
+			return 1
+		}
+		return 0
+	}
+
+	if configfile == "" {
+		fmt.Fprintln(os.Stderr, "Please provide configuration file")
+		return 1
+	}
+
+	if len(args) == 0 || (args[0] != "repair" && args[0] != "rebuild") {
+		fmt.Fprint(os.Stderr, dbUsage)
+		return 1
+	}
+
+	dbfile, err := dbFileFromConfig(configfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Unable to read db file from %v: %v\n",
+			configfile, err)
+		return 1
+	}
+
+	if args[0] == "rebuild" {
+		err = dbRebuild(dbfile, args[1:])
+	} else {
+		err = dbRepair(dbfile, args[1:])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		fmt.Fprint(os.Stderr, dbUsage)
+		return 1
+	}
+
+	return 0
+}
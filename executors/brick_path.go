@@ -0,0 +1,73 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package executors
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Placeholders of the brick path template, replaced by the name of
+// the volume group, the id of the device and the id of the brick
+const (
+	BrickPathVg     = "{vg}"
+	BrickPathDevice = "{device}"
+	BrickPathBrick  = "{brick}"
+)
+
+// Mount points of the bricks created by heketi
+const DefaultBrickPathTemplate = "/var/lib/heketi/mounts/" + BrickPathVg +
+	"/brick_" + BrickPathBrick
+
+// Returns an error unless the template is an absolute path which only
+// uses the known placeholders, and uses the id of the brick so that
+// each brick is mounted on its own path
+func ValidateBrickPathTemplate(template string) error {
+	if !path.IsAbs(template) {
+		return fmt.Errorf("Brick path template %v is not an absolute path", template)
+	}
+	if !strings.Contains(template, BrickPathBrick) {
+		return fmt.Errorf("Brick path template %v does not contain %v",
+			template, BrickPathBrick)
+	}
+
+	// Nothing is left between braces once the placeholders are removed
+	rest := strings.NewReplacer(BrickPathVg, "", BrickPathDevice, "",
+		BrickPathBrick, "").Replace(template)
+	if strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("Brick path template %v contains unknown placeholders, "+
+			"only %v, %v and %v are replaced",
+			template, BrickPathVg, BrickPathDevice, BrickPathBrick)
+	}
+
+	rendered := RenderBrickPath(template, "vg", "device", "brick")
+	if path.Clean(rendered) != rendered {
+		return fmt.Errorf("Brick path template %v is not a clean path", template)
+	}
+	return nil
+}
+
+// Returns the mount point of a brick from the template, or from the
+// default template if empty
+func RenderBrickPath(template, vg, device, brick string) string {
+	if template == "" {
+		template = DefaultBrickPathTemplate
+	}
+	return strings.NewReplacer(BrickPathVg, vg, BrickPathDevice, device,
+		BrickPathBrick, brick).Replace(template)
+}
@@ -0,0 +1,62 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package executors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/heketi/tests"
+)
+
+func TestRenderBrickPath(t *testing.T) {
+	p := RenderBrickPath("", "vg_abc", "abc", "123")
+	tests.Assert(t, p == "/var/lib/heketi/mounts/vg_abc/brick_123", p)
+
+	p = RenderBrickPath("/srv/bricks/{device}/{vg}-{brick}", "vg_abc", "abc", "123")
+	tests.Assert(t, p == "/srv/bricks/abc/vg_abc-123", p)
+
+	p = RenderBrickPath("/mnt/{brick}", "vg_abc", "abc", "123")
+	tests.Assert(t, p == "/mnt/123", p)
+}
+
+func TestValidateBrickPathTemplate(t *testing.T) {
+	for _, template := range []string{
+		DefaultBrickPathTemplate,
+		"/srv/bricks/{device}/{vg}-{brick}",
+		"/mnt/{brick}",
+	} {
+		err := ValidateBrickPathTemplate(template)
+		tests.Assert(t, err == nil, template, err)
+	}
+
+	for template, reason := range map[string]string{
+		"":                         "not an absolute path",
+		"mounts/{vg}/{brick}":      "not an absolute path",
+		"/mounts/{vg}":             "does not contain {brick}",
+		"/mounts/{volume}/{brick}": "unknown placeholders",
+		"/mounts/{vg/{brick}":      "unknown placeholders",
+		"/mounts/{brick}}":         "unknown placeholders",
+		"/mounts//{brick}":         "not a clean path",
+		"/mounts/{brick}/":         "not a clean path",
+		"/mounts/../{brick}":       "not a clean path",
+	} {
+		err := ValidateBrickPathTemplate(template)
+		tests.Assert(t, err != nil, template)
+		tests.Assert(t, strings.Contains(err.Error(), reason), template, err)
+	}
+}
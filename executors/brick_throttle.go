@@ -0,0 +1,104 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package executors
+
+import (
+	"errors"
+	"sync"
+)
+
+// Returned by a brick operation cancelled while it waited for the
+// others running on the host
+var ErrBrickThrottleCancelled = errors.New(
+	"Cancelled while waiting for the brick operations running on the host")
+
+// Limits the brick creations and deletions run at once on each host,
+// as creating the file systems of many bricks at once overloads the
+// disks of the host.  The limit is given with each operation, as it
+// is a setting of the node.  The methods of a nil throttle do not
+// limit anything.
+type BrickThrottle struct {
+	lock    sync.Mutex
+	running map[string]int
+
+	// Closed and replaced whenever an operation is done, to wake the
+	// operations waiting
+	released chan struct{}
+}
+
+func NewBrickThrottle() *BrickThrottle {
+	return &BrickThrottle{
+		running:  make(map[string]int),
+		released: make(chan struct{}),
+	}
+}
+
+// Waits until fewer than limit brick operations run on the host, and
+// counts the operation as running.  A limit of 0 does not wait.
+// Returns ErrBrickThrottleCancelled without counting the operation if
+// the cancel channel is closed first.
+func (t *BrickThrottle) Acquire(host string, limit int,
+	cancel <-chan struct{}) error {
+	if t == nil {
+		return nil
+	}
+
+	t.lock.Lock()
+	for limit > 0 && t.running[host] >= limit {
+		released := t.released
+		t.lock.Unlock()
+		select {
+		case <-released:
+		case <-cancel:
+			return ErrBrickThrottleCancelled
+		}
+		t.lock.Lock()
+	}
+	t.running[host]++
+	t.lock.Unlock()
+
+	return nil
+}
+
+// Counts the brick operation on the host as done
+func (t *BrickThrottle) Release(host string) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.running[host]--
+	if t.running[host] <= 0 {
+		delete(t.running, host)
+	}
+	close(t.released)
+	t.released = make(chan struct{})
+}
+
+// Returns the brick operations running on the host
+func (t *BrickThrottle) InFlight(host string) int {
+	if t == nil {
+		return 0
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.running[host]
+}
@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package executors
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heketi/tests"
+)
+
+func TestBrickThrottle(t *testing.T) {
+	throttle := NewBrickThrottle()
+
+	var (
+		lock    sync.Mutex
+		running int
+		most    int
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := throttle.Acquire("a", 2, nil)
+			tests.Assert(t, err == nil, err)
+			defer throttle.Release("a")
+
+			lock.Lock()
+			running++
+			if running > most {
+				most = running
+			}
+			lock.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+	tests.Assert(t, most == 2, most)
+	tests.Assert(t, throttle.InFlight("a") == 0)
+
+	// Hosts are limited on their own, and 0 does not limit
+	tests.Assert(t, throttle.Acquire("a", 1, nil) == nil)
+	tests.Assert(t, throttle.Acquire("b", 1, nil) == nil)
+	tests.Assert(t, throttle.Acquire("b", 0, nil) == nil)
+	tests.Assert(t, throttle.InFlight("a") == 1)
+	tests.Assert(t, throttle.InFlight("b") == 2)
+	throttle.Release("b")
+	throttle.Release("b")
+	throttle.Release("a")
+	tests.Assert(t, throttle.InFlight("b") == 0)
+
+	// The wait stops once cancelled, without counting the operation
+	tests.Assert(t, throttle.Acquire("a", 1, nil) == nil)
+	cancel := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- throttle.Acquire("a", 1, cancel)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	tests.Assert(t, len(result) == 0)
+	close(cancel)
+	tests.Assert(t, <-result == ErrBrickThrottleCancelled)
+	tests.Assert(t, throttle.InFlight("a") == 1)
+	throttle.Release("a")
+
+	// A nil throttle does not limit anything
+	var none *BrickThrottle
+	tests.Assert(t, none.Acquire("a", 1, nil) == nil)
+	tests.Assert(t, none.Acquire("a", 1, nil) == nil)
+	tests.Assert(t, none.InFlight("a") == 0)
+	none.Release("a")
+}
@@ -16,11 +16,17 @@
 
 package executors
 
+import (
+	"context"
+)
+
 type Executor interface {
 	PeerProbe(exec_host, newnode string) error
 	PeerDetach(exec_host, detachnode string) error
+	DeviceCanonicalPath(host, device string) (string, error)
 	DeviceSetup(host, device, vgid string) (*DeviceInfo, error)
 	DeviceTeardown(host, device, vgid string) error
+	DeviceTrim(host string, mountpoints []string) (uint64, error)
 	BrickCreate(host string, brick *BrickRequest) (*BrickInfo, error)
 	BrickDestroy(host string, brick *BrickRequest) error
 	BrickDestroyCheck(host string, brick *BrickRequest) error
@@ -28,7 +34,45 @@ type Executor interface {
 	VolumeDestroy(host string, volume string) error
 	VolumeDestroyCheck(host, volume string) error
 	VolumeExpand(host string, volume *VolumeRequest) (*VolumeInfo, error)
+	VolumeInfo(host string, volume string) (*VolumeInfo, error)
+	VolumeReplaceBrick(host string, volume string, oldBrick, newBrick *BrickInfo) error
+	VolumeRemoveBricksStart(host string, volume string, bricks []BrickInfo) error
+	VolumeRemoveBricksStatus(host string, volume string, bricks []BrickInfo) (*RemoveBricksStatus, error)
+	VolumeRemoveBricksCommit(host string, volume string, bricks []BrickInfo) error
+	VolumeRemoveBricksStop(host string, volume string, bricks []BrickInfo) error
+	VolumeProfileStart(host string, volume string) error
+	VolumeProfileStop(host string, volume string) error
+	VolumeProfileInfo(host string, volume string) (*VolumeProfileInfo, error)
+	VolumeScrub(host string, volume string) error
+	VolumeRebalance(host string, volume string) error
+	VolumeRebalanceStatus(host string, volume string) (*RebalanceStatus, error)
+	VolumeHealInfo(host string, volume string) (*HealInfo, error)
+	VolumeIOStats(host string, volume string) (*VolumeIOStats, error)
+	NodeStorageInfo(host string) (*NodeStorageInfo, error)
+	NodeStorageLatency(host string, devices []string) (float64, error)
+	NodeMetrics(host string) (*NodeMetrics, error)
+	NodeVersions(host string) (*NodeVersions, error)
+	GlusterdCheck(host string) error
 	SetLogLevel(level string)
+	LogLevel() string
+
+	// Returns the executor which logs the commands it runs with the
+	// id of the request of the context
+	WithContext(ctx context.Context) Executor
+}
+
+// Implemented by the executors which throttle the commands run on
+// each host
+type QueueReporter interface {
+	// Returns the commands running or waiting to run on each host
+	QueueDepths() map[string]int
+}
+
+// Implemented by the executors which limit the brick operations run
+// at once on each host
+type BrickOpsReporter interface {
+	// Returns the brick creations and deletions running on the host
+	BrickOpsInFlight(host string) int
 }
 
 // Enumerate durability types
@@ -40,6 +84,18 @@ const (
 	DurabilityDispersion
 )
 
+// Protocols a volume can be exported over besides the native client
+const (
+	VolumeProtocolNFS = "nfs"
+	VolumeProtocolSMB = "smb"
+)
+
+// Retention modes of WORM volumes
+const (
+	WORMRetentionRelax      = "relax"
+	WORMRetentionEnterprise = "enterprise"
+)
+
 // Returns the size of the device
 type DeviceInfo struct {
 	// Size in KB
@@ -54,12 +110,38 @@ type BrickRequest struct {
 	TpSize           uint64
 	Size             uint64
 	PoolMetadataSize uint64
+
+	// Mount point of an existing brick to share.  When set, only the
+	// sub-directory is created or removed, unless Last is set on a
+	// destroy, in which case the shared logical volume is removed.
+	MountPoint string
+	Last       bool
+
+	// Directory inside the mount point used by GlusterFS.
+	// Defaults to "brick".
+	SubDir string
+
+	// Mount the brick with the discard option
+	Discard bool
+
+	// Template of the mount point of a new brick which does not share
+	// one, see RenderBrickPath.  DefaultBrickPathTemplate if empty.
+	PathTemplate string
+
+	// Brick creations and deletions run at once on the host, see
+	// BrickThrottle.  Not limited if 0.
+	MaxConcurrentOps int
+
+	// Closed if the operation is cancelled while it waits for the
+	// others running on the host.  Never closed if nil.
+	Cancel <-chan struct{}
 }
 
 // Returns information about the location of the brick
 type BrickInfo struct {
-	Path string
-	Host string
+	Path       string
+	Host       string
+	MountPoint string
 }
 
 type VolumeRequest struct {
@@ -73,7 +155,158 @@ type VolumeRequest struct {
 
 	// Replica
 	Replica int
+
+	// Number of arbiter bricks in each replica set
+	Arbiter int
+
+	// CHAP credentials clients log in to the bricks with.  Only set
+	// if the volume uses CHAP authentication.
+	CHAPUsername string
+	CHAPSecret   string
+
+	// Protocol the volume is exported over besides the native
+	// client, if any
+	Protocol string
+
+	// Oldest version of GlusterFS on the nodes of the bricks, or
+	// empty if not known
+	GlusterVersion string
+
+	// Files become read-only once written, and are retained in the
+	// mode for the period in seconds if it is set
+	WORM                bool
+	WORMRetentionMode   string
+	WORMRetentionPeriod int
 }
 
 type VolumeInfo struct {
+	// Bricks as reported by the cluster.  Only set by VolumeInfo()
+	// and NodeStorageInfo()
+	Bricks []BrickInfo
+
+	// Only set by NodeStorageInfo()
+	Name       string
+	Type       DurabilityType
+	Replica    int
+	Data       int
+	Redundancy int
+}
+
+// Statistics gathered on the bricks of a volume since profiling
+// was started
+type VolumeProfileInfo struct {
+	Bricks []BrickProfileInfo
+}
+
+type BrickProfileInfo struct {
+	// Reported as host:path
+	Name string
+
+	// Seconds since profiling was started
+	Duration uint64
+
+	// Bytes read and written
+	DataRead    uint64
+	DataWritten uint64
+
+	Fops []FopProfileInfo
+}
+
+// Latencies are in microseconds
+type FopProfileInfo struct {
+	Name       string
+	Hits       uint64
+	AvgLatency float64
+	MinLatency float64
+	MaxLatency float64
+}
+
+// Current I/O of the bricks of a volume
+type VolumeIOStats struct {
+	Bricks []BrickIOStats
+}
+
+// The rates are over the interval since the statistics were last
+// read, and are only known while profiling is started on the volume
+type BrickIOStats struct {
+	Host string
+	Path string
+
+	ReadOpsPerSec  float64
+	WriteOpsPerSec float64
+	ClientCount    int
+}
+
+// Progress of the migration of the data off bricks being removed.
+// Each node migrates the data of its bricks, and the migration is
+// completed once all the nodes have completed.
+type RemoveBricksStatus struct {
+	Completed      bool
+	Nodes          int
+	NodesCompleted int
+	Files          uint64
+}
+
+// Progress of the rebalance of a volume.  Each node moves the data of
+// its bricks, and the rebalance is completed once all the nodes have
+// completed.
+type RebalanceStatus struct {
+	Completed      bool
+	Nodes          int
+	NodesCompleted int
+	Files          uint64
+}
+
+// Entries of a volume left to heal.  The heal is completed once no
+// brick has entries left.
+type HealInfo struct {
+	Bricks       int
+	BricksHealed int
+	Entries      uint64
+}
+
+// Memory of a node in bytes and its number of CPUs
+type NodeMetrics struct {
+	MemoryTotal uint64
+	MemoryFree  uint64
+	CpuCount    int
+}
+
+// Versions of GlusterFS and of the kernel running on a node
+type NodeVersions struct {
+	GlusterVersion string
+	KernelVersion  string
+}
+
+// Storage found on a node.  Sizes are in KB.
+type NodeStorageInfo struct {
+	Volumes         []VolumeInfo
+	PhysicalVolumes []PhysicalVolumeInfo
+	VolumeGroups    []VolumeGroupInfo
+	LogicalVolumes  []LogicalVolumeInfo
+	Mounts          []MountInfo
+}
+
+type PhysicalVolumeInfo struct {
+	Name   string
+	VgName string
+}
+
+type VolumeGroupInfo struct {
+	Name string
+	Size uint64
+	Free uint64
+}
+
+type LogicalVolumeInfo struct {
+	Name         string
+	VgName       string
+	PoolLv       string
+	Size         uint64
+	MetadataSize uint64
+}
+
+type MountInfo struct {
+	Device     string
+	MountPoint string
 }
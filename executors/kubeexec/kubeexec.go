@@ -32,6 +32,7 @@ import (
 
 	"github.com/lpabon/godbc"
 
+	"github.com/heketi/heketi/executors"
 	"github.com/heketi/heketi/executors/sshexec"
 	"github.com/heketi/heketi/pkg/utils"
 )
@@ -146,6 +147,7 @@ func NewKubeExecutor(config *KubeConfig) (*KubeExecutor, error) {
 	k := &KubeExecutor{}
 	k.config = config
 	k.Throttlemap = make(map[string]chan bool)
+	k.BrickThrottle = executors.NewBrickThrottle()
 	k.RemoteExecutor = k
 
 	if k.config.Fstab == "" {
@@ -295,10 +297,10 @@ func (k *KubeExecutor) ConnectAndExec(host, namespace, resource string,
 		err = exec.Stream(nil, &b, &berr, false)
 		if err != nil {
 			logger.LogError("Failed to run command [%v] on %v: Err[%v]: Stdout [%v]: Stderr [%v]",
-				command, podName, err, b.String(), berr.String())
+				utils.RedactCommand(command), podName, err, b.String(), berr.String())
 			return nil, fmt.Errorf("Unable to execute command on %v: %v", podName, berr.String())
 		}
-		logger.Debug("Host: %v Command: %v\nResult: %v", podName, command, b.String())
+		logger.Debug("Host: %v Command: %v\nResult: %v", podName, utils.RedactCommand(command), b.String())
 		buffers[index] = b.String()
 
 	}
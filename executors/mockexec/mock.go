@@ -17,26 +17,55 @@
 package mockexec
 
 import (
+	"context"
+
 	"github.com/heketi/heketi/executors"
 )
 
 type MockExecutor struct {
 	// These functions can be overwritten for testing
-	MockPeerProbe          func(exec_host, newnode string) error
-	MockPeerDetach         func(exec_host, newnode string) error
-	MockDeviceSetup        func(host, device, vgid string) (*executors.DeviceInfo, error)
-	MockDeviceTeardown     func(host, device, vgid string) error
-	MockBrickCreate        func(host string, brick *executors.BrickRequest) (*executors.BrickInfo, error)
-	MockBrickDestroy       func(host string, brick *executors.BrickRequest) error
-	MockBrickDestroyCheck  func(host string, brick *executors.BrickRequest) error
-	MockVolumeCreate       func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error)
-	MockVolumeExpand       func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error)
-	MockVolumeDestroy      func(host string, volume string) error
-	MockVolumeDestroyCheck func(host, volume string) error
+	MockPeerProbe                func(exec_host, newnode string) error
+	MockPeerDetach               func(exec_host, newnode string) error
+	MockDeviceCanonicalPath      func(host, device string) (string, error)
+	MockDeviceSetup              func(host, device, vgid string) (*executors.DeviceInfo, error)
+	MockDeviceTeardown           func(host, device, vgid string) error
+	MockDeviceTrim               func(host string, mountpoints []string) (uint64, error)
+	MockBrickCreate              func(host string, brick *executors.BrickRequest) (*executors.BrickInfo, error)
+	MockBrickDestroy             func(host string, brick *executors.BrickRequest) error
+	MockBrickDestroyCheck        func(host string, brick *executors.BrickRequest) error
+	MockVolumeCreate             func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error)
+	MockVolumeExpand             func(host string, volume *executors.VolumeRequest) (*executors.VolumeInfo, error)
+	MockVolumeDestroy            func(host string, volume string) error
+	MockVolumeDestroyCheck       func(host, volume string) error
+	MockVolumeInfo               func(host, volume string) (*executors.VolumeInfo, error)
+	MockVolumeReplaceBrick       func(host, volume string, oldBrick, newBrick *executors.BrickInfo) error
+	MockVolumeRemoveBricksStart  func(host, volume string, bricks []executors.BrickInfo) error
+	MockVolumeRemoveBricksStatus func(host, volume string, bricks []executors.BrickInfo) (*executors.RemoveBricksStatus, error)
+	MockVolumeRemoveBricksCommit func(host, volume string, bricks []executors.BrickInfo) error
+	MockVolumeRemoveBricksStop   func(host, volume string, bricks []executors.BrickInfo) error
+	MockVolumeProfileStart       func(host, volume string) error
+	MockVolumeProfileStop        func(host, volume string) error
+	MockVolumeProfileInfo        func(host, volume string) (*executors.VolumeProfileInfo, error)
+	MockVolumeScrub              func(host, volume string) error
+	MockVolumeRebalance          func(host, volume string) error
+	MockVolumeRebalanceStatus    func(host, volume string) (*executors.RebalanceStatus, error)
+	MockVolumeHealInfo           func(host, volume string) (*executors.HealInfo, error)
+	MockVolumeIOStats            func(host, volume string) (*executors.VolumeIOStats, error)
+	MockNodeStorageInfo          func(host string) (*executors.NodeStorageInfo, error)
+	MockNodeStorageLatency       func(host string, devices []string) (float64, error)
+	MockNodeMetrics              func(host string) (*executors.NodeMetrics, error)
+	MockNodeVersions             func(host string) (*executors.NodeVersions, error)
+	MockGlusterdCheck            func(host string) error
+	MockSetLogLevel              func(level string)
+	MockLogLevel                 func() string
+
+	// Limits the brick operations run at once on each host
+	BrickThrottle *executors.BrickThrottle
 }
 
 func NewMockExecutor() (*MockExecutor, error) {
 	m := &MockExecutor{}
+	m.BrickThrottle = executors.NewBrickThrottle()
 
 	m.MockPeerProbe = func(exec_host, newnode string) error {
 		return nil
@@ -46,6 +75,10 @@ func NewMockExecutor() (*MockExecutor, error) {
 		return nil
 	}
 
+	m.MockDeviceCanonicalPath = func(host, device string) (string, error) {
+		return device, nil
+	}
+
 	m.MockDeviceSetup = func(host, device, vgid string) (*executors.DeviceInfo, error) {
 		d := &executors.DeviceInfo{}
 		d.Size = 500 * 1024 * 1024 // Size in KB
@@ -57,6 +90,10 @@ func NewMockExecutor() (*MockExecutor, error) {
 		return nil
 	}
 
+	m.MockDeviceTrim = func(host string, mountpoints []string) (uint64, error) {
+		return 0, nil
+	}
+
 	m.MockBrickCreate = func(host string, brick *executors.BrickRequest) (*executors.BrickInfo, error) {
 		b := &executors.BrickInfo{
 			Path: "/mockpath",
@@ -84,15 +121,105 @@ func NewMockExecutor() (*MockExecutor, error) {
 		return nil
 	}
 
+	m.MockVolumeInfo = func(host, volume string) (*executors.VolumeInfo, error) {
+		return &executors.VolumeInfo{}, nil
+	}
+
 	m.MockVolumeDestroyCheck = func(host, volume string) error {
 		return nil
 	}
 
+	m.MockVolumeReplaceBrick = func(host, volume string, oldBrick, newBrick *executors.BrickInfo) error {
+		return nil
+	}
+
+	m.MockVolumeProfileStart = func(host, volume string) error {
+		return nil
+	}
+
+	m.MockVolumeProfileStop = func(host, volume string) error {
+		return nil
+	}
+
+	m.MockVolumeProfileInfo = func(host, volume string) (*executors.VolumeProfileInfo, error) {
+		return &executors.VolumeProfileInfo{}, nil
+	}
+
+	m.MockVolumeScrub = func(host, volume string) error {
+		return nil
+	}
+
+	m.MockVolumeRemoveBricksStart = func(host, volume string, bricks []executors.BrickInfo) error {
+		return nil
+	}
+
+	m.MockVolumeRemoveBricksStatus = func(host, volume string, bricks []executors.BrickInfo) (*executors.RemoveBricksStatus, error) {
+		return &executors.RemoveBricksStatus{Completed: true}, nil
+	}
+
+	m.MockVolumeRemoveBricksCommit = func(host, volume string, bricks []executors.BrickInfo) error {
+		return nil
+	}
+
+	m.MockVolumeRemoveBricksStop = func(host, volume string, bricks []executors.BrickInfo) error {
+		return nil
+	}
+
+	m.MockVolumeRebalance = func(host, volume string) error {
+		return nil
+	}
+
+	m.MockVolumeRebalanceStatus = func(host, volume string) (*executors.RebalanceStatus, error) {
+		return &executors.RebalanceStatus{Completed: true}, nil
+	}
+
+	m.MockVolumeHealInfo = func(host, volume string) (*executors.HealInfo, error) {
+		return &executors.HealInfo{}, nil
+	}
+
+	m.MockVolumeIOStats = func(host, volume string) (*executors.VolumeIOStats, error) {
+		return &executors.VolumeIOStats{}, nil
+	}
+
+	m.MockNodeStorageInfo = func(host string) (*executors.NodeStorageInfo, error) {
+		return &executors.NodeStorageInfo{}, nil
+	}
+
+	m.MockNodeStorageLatency = func(host string, devices []string) (float64, error) {
+		return 0, nil
+	}
+
+	m.MockNodeMetrics = func(host string) (*executors.NodeMetrics, error) {
+		return &executors.NodeMetrics{}, nil
+	}
+
+	m.MockNodeVersions = func(host string) (*executors.NodeVersions, error) {
+		return &executors.NodeVersions{
+			GlusterVersion: "3.7.11",
+			KernelVersion:  "3.10.0-327.el7.x86_64",
+		}, nil
+	}
+
+	m.MockGlusterdCheck = func(host string) error {
+		return nil
+	}
+
+	m.MockSetLogLevel = func(level string) {
+	}
+
+	m.MockLogLevel = func() string {
+		return "debug"
+	}
+
 	return m, nil
 }
 
 func (m *MockExecutor) SetLogLevel(level string) {
+	m.MockSetLogLevel(level)
+}
 
+func (m *MockExecutor) LogLevel() string {
+	return m.MockLogLevel()
 }
 
 func (m *MockExecutor) PeerProbe(exec_host, newnode string) error {
@@ -103,6 +230,10 @@ func (m *MockExecutor) PeerDetach(exec_host, newnode string) error {
 	return m.MockPeerDetach(exec_host, newnode)
 }
 
+func (m *MockExecutor) DeviceCanonicalPath(host, device string) (string, error) {
+	return m.MockDeviceCanonicalPath(host, device)
+}
+
 func (m *MockExecutor) DeviceSetup(host, device, vgid string) (*executors.DeviceInfo, error) {
 	return m.MockDeviceSetup(host, device, vgid)
 }
@@ -111,14 +242,32 @@ func (m *MockExecutor) DeviceTeardown(host, device, vgid string) error {
 	return m.MockDeviceTeardown(host, device, vgid)
 }
 
+func (m *MockExecutor) DeviceTrim(host string, mountpoints []string) (uint64, error) {
+	return m.MockDeviceTrim(host, mountpoints)
+}
+
 func (m *MockExecutor) BrickCreate(host string, brick *executors.BrickRequest) (*executors.BrickInfo, error) {
+	err := m.BrickThrottle.Acquire(host, brick.MaxConcurrentOps, brick.Cancel)
+	if err != nil {
+		return nil, err
+	}
+	defer m.BrickThrottle.Release(host)
 	return m.MockBrickCreate(host, brick)
 }
 
 func (m *MockExecutor) BrickDestroy(host string, brick *executors.BrickRequest) error {
+	err := m.BrickThrottle.Acquire(host, brick.MaxConcurrentOps, brick.Cancel)
+	if err != nil {
+		return err
+	}
+	defer m.BrickThrottle.Release(host)
 	return m.MockBrickDestroy(host, brick)
 }
 
+func (m *MockExecutor) BrickOpsInFlight(host string) int {
+	return m.BrickThrottle.InFlight(host)
+}
+
 func (m *MockExecutor) BrickDestroyCheck(host string, brick *executors.BrickRequest) error {
 	return m.MockBrickDestroyCheck(host, brick)
 }
@@ -138,3 +287,84 @@ func (m *MockExecutor) VolumeDestroy(host string, volume string) error {
 func (m *MockExecutor) VolumeDestroyCheck(host string, volume string) error {
 	return m.MockVolumeDestroyCheck(host, volume)
 }
+
+func (m *MockExecutor) VolumeInfo(host, volume string) (*executors.VolumeInfo, error) {
+	return m.MockVolumeInfo(host, volume)
+}
+
+func (m *MockExecutor) VolumeReplaceBrick(host, volume string, oldBrick, newBrick *executors.BrickInfo) error {
+	return m.MockVolumeReplaceBrick(host, volume, oldBrick, newBrick)
+}
+
+func (m *MockExecutor) VolumeRemoveBricksStart(host, volume string, bricks []executors.BrickInfo) error {
+	return m.MockVolumeRemoveBricksStart(host, volume, bricks)
+}
+
+func (m *MockExecutor) VolumeRemoveBricksStatus(host, volume string, bricks []executors.BrickInfo) (*executors.RemoveBricksStatus, error) {
+	return m.MockVolumeRemoveBricksStatus(host, volume, bricks)
+}
+
+func (m *MockExecutor) VolumeRemoveBricksCommit(host, volume string, bricks []executors.BrickInfo) error {
+	return m.MockVolumeRemoveBricksCommit(host, volume, bricks)
+}
+
+func (m *MockExecutor) VolumeRemoveBricksStop(host, volume string, bricks []executors.BrickInfo) error {
+	return m.MockVolumeRemoveBricksStop(host, volume, bricks)
+}
+
+func (m *MockExecutor) VolumeProfileStart(host, volume string) error {
+	return m.MockVolumeProfileStart(host, volume)
+}
+
+func (m *MockExecutor) VolumeProfileStop(host, volume string) error {
+	return m.MockVolumeProfileStop(host, volume)
+}
+
+func (m *MockExecutor) VolumeProfileInfo(host, volume string) (*executors.VolumeProfileInfo, error) {
+	return m.MockVolumeProfileInfo(host, volume)
+}
+
+func (m *MockExecutor) VolumeScrub(host, volume string) error {
+	return m.MockVolumeScrub(host, volume)
+}
+
+func (m *MockExecutor) VolumeRebalance(host, volume string) error {
+	return m.MockVolumeRebalance(host, volume)
+}
+
+func (m *MockExecutor) VolumeRebalanceStatus(host, volume string) (*executors.RebalanceStatus, error) {
+	return m.MockVolumeRebalanceStatus(host, volume)
+}
+
+func (m *MockExecutor) VolumeHealInfo(host, volume string) (*executors.HealInfo, error) {
+	return m.MockVolumeHealInfo(host, volume)
+}
+
+func (m *MockExecutor) VolumeIOStats(host, volume string) (*executors.VolumeIOStats, error) {
+	return m.MockVolumeIOStats(host, volume)
+}
+
+func (m *MockExecutor) NodeStorageInfo(host string) (*executors.NodeStorageInfo, error) {
+	return m.MockNodeStorageInfo(host)
+}
+
+func (m *MockExecutor) NodeStorageLatency(host string, devices []string) (float64, error) {
+	return m.MockNodeStorageLatency(host, devices)
+}
+
+func (m *MockExecutor) NodeMetrics(host string) (*executors.NodeMetrics, error) {
+	return m.MockNodeMetrics(host)
+}
+
+func (m *MockExecutor) NodeVersions(host string) (*executors.NodeVersions, error) {
+	return m.MockNodeVersions(host)
+}
+
+func (m *MockExecutor) GlusterdCheck(host string) error {
+	return m.MockGlusterdCheck(host)
+}
+
+// The mock runs no commands to log
+func (m *MockExecutor) WithContext(ctx context.Context) executors.Executor {
+	return m
+}
@@ -18,6 +18,7 @@ package sshexec
 
 import (
 	"fmt"
+	"path"
 	"strings"
 
 	"github.com/heketi/heketi/executors"
@@ -25,20 +26,48 @@ import (
 )
 
 const (
-	rootMountPoint = "/var/lib/heketi/mounts"
+	defaultSubDir = "brick"
 )
 
+// Id of the brick which created the logical volume.  Bricks sharing
+// a mount point use the logical volume of the brick which created it.
+func (s *SshExecutor) lvId(brick *executors.BrickRequest) string {
+	if brick.MountPoint != "" {
+		return strings.TrimPrefix(path.Base(brick.MountPoint), s.brickName(""))
+	}
+	return brick.Name
+}
+
 // Return the mount point for the brick
 func (s *SshExecutor) brickMountPoint(brick *executors.BrickRequest) string {
-	return rootMountPoint + "/" +
-		s.vgName(brick.VgId) + "/" +
-		s.brickName(brick.Name)
+	if brick.MountPoint != "" {
+		return brick.MountPoint
+	}
+	return executors.RenderBrickPath(brick.PathTemplate,
+		s.vgName(brick.VgId), brick.VgId, brick.Name)
+}
+
+// Directory inside the mount point used by GlusterFS
+func (s *SshExecutor) brickSubDir(brick *executors.BrickRequest) string {
+	if brick.SubDir != "" {
+		return brick.SubDir
+	}
+	return defaultSubDir
 }
 
 // Device node for the lvm volume
 func (s *SshExecutor) devnode(brick *executors.BrickRequest) string {
 	return "/dev/" + s.vgName(brick.VgId) +
-		"/" + s.brickName(brick.Name)
+		"/" + s.brickName(s.lvId(brick))
+}
+
+// Options used to mount the brick file system
+func mountOptions(brick *executors.BrickRequest) string {
+	options := "rw,inode64,noatime,nouuid"
+	if brick.Discard {
+		options += ",discard"
+	}
+	return options
 }
 
 func (s *SshExecutor) BrickCreate(host string,
@@ -52,8 +81,31 @@ func (s *SshExecutor) BrickCreate(host string,
 	godbc.Require(brick.VgId != "")
 	godbc.Require(s.Fstab != "")
 
+	err := s.BrickThrottle.Acquire(host, brick.MaxConcurrentOps, brick.Cancel)
+	if err != nil {
+		return nil, err
+	}
+	defer s.BrickThrottle.Release(host)
+
 	// Create mountpoint name
 	mountpoint := s.brickMountPoint(brick)
+	brickpath := mountpoint + "/" + s.brickSubDir(brick)
+
+	// Only create the directory on a shared mount point
+	if brick.MountPoint != "" {
+		commands := []string{
+			fmt.Sprintf("sudo mkdir -p %v", brickpath),
+		}
+		_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 5)
+		if err != nil {
+			return nil, err
+		}
+
+		return &executors.BrickInfo{
+			Path:       brickpath,
+			MountPoint: mountpoint,
+		}, nil
+	}
 
 	// Create command set to execute on the node
 	commands := []string{
@@ -85,20 +137,21 @@ func (s *SshExecutor) BrickCreate(host string,
 		fmt.Sprintf("sudo mkfs.xfs -i size=512 -n size=8192 %v", s.devnode(brick)),
 
 		// Fstab
-		fmt.Sprintf("echo \"%v %v xfs rw,inode64,noatime,nouuid 1 2\" | sudo tee -a %v > /dev/null ",
+		fmt.Sprintf("echo \"%v %v xfs %v 1 2\" | sudo tee -a %v > /dev/null ",
 			s.devnode(brick),
 			mountpoint,
+			mountOptions(brick),
 			s.Fstab),
 
 		// Mount
-		fmt.Sprintf("sudo mount -o rw,inode64,noatime,nouuid %v %v", s.devnode(brick), mountpoint),
+		fmt.Sprintf("sudo mount -o %v %v %v", mountOptions(brick), s.devnode(brick), mountpoint),
 
 		// Create a directory inside the formated volume for GlusterFS
-		fmt.Sprintf("sudo mkdir %v/brick", mountpoint),
+		fmt.Sprintf("sudo mkdir %v", brickpath),
 	}
 
 	// Execute commands
-	_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	_, err = s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
 	if err != nil {
 		// Cleanup
 		s.BrickDestroy(host, brick)
@@ -107,7 +160,8 @@ func (s *SshExecutor) BrickCreate(host string,
 
 	// Save brick location
 	b := &executors.BrickInfo{
-		Path: fmt.Sprintf("%v/brick", mountpoint),
+		Path:       brickpath,
+		MountPoint: mountpoint,
 	}
 	return b, nil
 }
@@ -120,18 +174,36 @@ func (s *SshExecutor) BrickDestroy(host string,
 	godbc.Require(brick.Name != "")
 	godbc.Require(brick.VgId != "")
 
+	err := s.BrickThrottle.Acquire(host, brick.MaxConcurrentOps, brick.Cancel)
+	if err != nil {
+		return err
+	}
+	defer s.BrickThrottle.Release(host)
+
+	// Only remove the directory if other bricks share the mount point
+	if brick.MountPoint != "" && !brick.Last {
+		commands := []string{
+			fmt.Sprintf("sudo rm -rf %v/%v", brick.MountPoint, s.brickSubDir(brick)),
+		}
+		_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 5)
+		if err != nil {
+			logger.Err(err)
+		}
+		return nil
+	}
+
 	// Try to unmount first
 	commands := []string{
 		fmt.Sprintf("sudo umount %v", s.brickMountPoint(brick)),
 	}
-	_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 5)
+	_, err = s.RemoteExecutor.RemoteCommandExecute(host, commands, 5)
 	if err != nil {
 		logger.Err(err)
 	}
 
 	// Now try to remove the LV
 	commands = []string{
-		fmt.Sprintf("sudo lvremove -f %v/%v", s.vgName(brick.VgId), s.tpName(brick.Name)),
+		fmt.Sprintf("sudo lvremove -f %v/%v", s.vgName(brick.VgId), s.tpName(s.lvId(brick))),
 	}
 	_, err = s.RemoteExecutor.RemoteCommandExecute(host, commands, 5)
 	if err != nil {
@@ -150,7 +222,7 @@ func (s *SshExecutor) BrickDestroy(host string,
 	// Remove from fstab
 	commands = []string{
 		fmt.Sprintf("sudo sed -i.save '/%v/d' %v",
-			s.brickName(brick.Name),
+			s.brickName(s.lvId(brick)),
 			s.Fstab),
 	}
 	_, err = s.RemoteExecutor.RemoteCommandExecute(host, commands, 5)
@@ -188,7 +260,7 @@ func (s *SshExecutor) checkThinPoolUsage(host string,
 	// 		tp_8d4e0849a5c90608a543928961bd2387:1
 	//		tp_3b9b3e07f06b93d94006ef272d3c10eb:2
 
-	tp := s.tpName(brick.Name)
+	tp := s.tpName(s.lvId(brick))
 	commands := []string{
 		fmt.Sprintf("sudo lvs --options=lv_name,thin_count --separator=:"),
 	}
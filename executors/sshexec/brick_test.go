@@ -108,6 +108,63 @@ func TestSshExecBrickCreate(t *testing.T) {
 
 }
 
+func TestSshExecBrickCreatePathTemplate(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+		Fstab:          "/my/fstab",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	b := &executors.BrickRequest{
+		VgId:             "xvgid",
+		Name:             "id",
+		TpSize:           100,
+		Size:             10,
+		PoolMetadataSize: 5,
+		PathTemplate:     "/srv/bricks/{device}/{vg}-{brick}",
+	}
+
+	var executed []string
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		executed = append(executed, commands...)
+		return nil, nil
+	}
+
+	info, err := s.BrickCreate("myhost", b)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.MountPoint == "/srv/bricks/xvgid/vg_xvgid-id", info.MountPoint)
+	tests.Assert(t, info.Path == "/srv/bricks/xvgid/vg_xvgid-id/brick", info.Path)
+	tests.Assert(t, executed[0] == "sudo mkdir -p /srv/bricks/xvgid/vg_xvgid-id", executed)
+	tests.Assert(t, executed[4] == "sudo mount -o rw,inode64,noatime,nouuid "+
+		"/dev/vg_xvgid/brick_id /srv/bricks/xvgid/vg_xvgid-id", executed)
+
+	// Destroyed from the mount point saved at creation
+	executed = nil
+	b.PathTemplate = ""
+	b.MountPoint = info.MountPoint
+	b.Last = true
+	err = s.BrickDestroy("myhost", b)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, executed[0] == "sudo umount /srv/bricks/xvgid/vg_xvgid-id", executed)
+	tests.Assert(t, executed[2] == "sudo rmdir /srv/bricks/xvgid/vg_xvgid-id", executed)
+}
+
 func TestSshExecBrickDestroy(t *testing.T) {
 
 	f := NewFakeSsh()
@@ -175,3 +232,113 @@ func TestSshExecBrickDestroy(t *testing.T) {
 	err = s.BrickDestroy("myhost", b)
 	tests.Assert(t, err == nil, err)
 }
+
+func TestSshExecBrickCreateSubDir(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+		Fstab:          "/my/fstab",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Create a Brick sharing the mount point of brick owner
+	b := &executors.BrickRequest{
+		VgId:             "xvgid",
+		Name:             "id",
+		TpSize:           100,
+		Size:             10,
+		PoolMetadataSize: 5,
+		MountPoint:       "/var/lib/heketi/mounts/vg_xvgid/brick_owner",
+		SubDir:           "sub",
+	}
+
+	// Mock ssh function
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		tests.Assert(t, len(commands) == 1)
+		tests.Assert(t, commands[0] == "sudo mkdir -p "+
+			"/var/lib/heketi/mounts/vg_xvgid/brick_owner/sub", commands[0])
+
+		return nil, nil
+	}
+
+	info, err := s.BrickCreate("myhost", b)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Path == "/var/lib/heketi/mounts/vg_xvgid/brick_owner/sub")
+	tests.Assert(t, info.MountPoint == "/var/lib/heketi/mounts/vg_xvgid/brick_owner")
+}
+
+func TestSshExecBrickDestroySubDir(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+		Fstab:          "/my/fstab",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	b := &executors.BrickRequest{
+		VgId:       "xvgid",
+		Name:       "id",
+		TpSize:     100,
+		Size:       10,
+		MountPoint: "/var/lib/heketi/mounts/vg_xvgid/brick_owner",
+		SubDir:     "sub",
+	}
+
+	// Other bricks share the mount point
+	var cmds []string
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		cmds = append(cmds, commands...)
+		return nil, nil
+	}
+
+	err = s.BrickDestroy("myhost", b)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(cmds) == 1)
+	tests.Assert(t, cmds[0] == "sudo rm -rf "+
+		"/var/lib/heketi/mounts/vg_xvgid/brick_owner/sub", cmds[0])
+
+	// The last brick removes the logical volume of the owner
+	cmds = nil
+	b.Last = true
+	err = s.BrickDestroy("myhost", b)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(cmds) == 4)
+	tests.Assert(t, cmds[0] == "sudo umount "+
+		"/var/lib/heketi/mounts/vg_xvgid/brick_owner", cmds[0])
+	tests.Assert(t, cmds[1] == "sudo lvremove -f vg_xvgid/tp_owner", cmds[1])
+	tests.Assert(t, cmds[2] == "sudo rmdir "+
+		"/var/lib/heketi/mounts/vg_xvgid/brick_owner", cmds[2])
+	tests.Assert(t, cmds[3] == "sudo sed -i.save "+
+		"'/brick_owner/d' /my/fstab", cmds[3])
+}
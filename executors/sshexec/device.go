@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/heketi/heketi/executors"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -36,6 +37,28 @@ const (
 // https://access.redhat.com/documentation/en-US/Red_Hat_Storage/3.1/html/Administration_Guide/Brick_Configuration.html
 //
 
+// Resolve any symlinks in the device path on the node, so that
+// /dev/disk/by-id/... and /dev/sdX names for the same disk match
+func (s *SshExecutor) DeviceCanonicalPath(host, device string) (string, error) {
+
+	commands := []string{
+		fmt.Sprintf("readlink -f %v", device),
+	}
+
+	// Execute command
+	b, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 5)
+	if err != nil {
+		return "", err
+	}
+
+	path := strings.TrimSpace(b[0])
+	if path == "" {
+		return "", fmt.Errorf("Unable to resolve path of device %v on %v", device, host)
+	}
+
+	return path, nil
+}
+
 func (s *SshExecutor) DeviceSetup(host, device, vgid string) (d *executors.DeviceInfo, e error) {
 
 	// Setup commands
@@ -85,6 +108,45 @@ func (s *SshExecutor) DeviceTeardown(host, device, vgid string) error {
 	return nil
 }
 
+// Example: /var/lib/heketi/mounts/vg_1/brick_1: 1 GiB (1073741824 bytes) trimmed
+var fstrimBytes = regexp.MustCompile(`\((\d+) bytes\)`)
+
+// Discard the unused blocks of the file systems mounted on the mount
+// points.  Returns the amount of space trimmed in KB.
+func (s *SshExecutor) DeviceTrim(host string, mountpoints []string) (uint64, error) {
+
+	if len(mountpoints) == 0 {
+		return 0, nil
+	}
+
+	// Setup commands
+	commands := make([]string, 0, len(mountpoints))
+	for _, mountpoint := range mountpoints {
+		commands = append(commands, fmt.Sprintf("sudo fstrim -v %v", mountpoint))
+	}
+
+	// Execute command
+	b, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 60)
+	if err != nil {
+		return 0, err
+	}
+
+	trimmed := uint64(0)
+	for _, output := range b {
+		match := fstrimBytes.FindStringSubmatch(output)
+		if match == nil {
+			return 0, fmt.Errorf("Unable to parse fstrim output: %v", output)
+		}
+		bytes, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		trimmed += bytes
+	}
+
+	return trimmed / 1024, nil
+}
+
 func (s *SshExecutor) getVgSizeFromNode(
 	d *executors.DeviceInfo,
 	host, device, vgid string) error {
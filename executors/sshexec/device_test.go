@@ -0,0 +1,128 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sshexec
+
+import (
+	"testing"
+
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func TestSshExecDeviceCanonicalPath(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Mock ssh function
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		tests.Assert(t, len(commands) == 1)
+		tests.Assert(t, commands[0] == "readlink -f /dev/disk/by-id/abc", commands[0])
+
+		return []string{"/dev/sdb\n"}, nil
+	}
+
+	path, err := s.DeviceCanonicalPath("myhost", "/dev/disk/by-id/abc")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, path == "/dev/sdb", path)
+
+	// Nothing returned from the node
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+		return []string{""}, nil
+	}
+
+	path, err = s.DeviceCanonicalPath("myhost", "/dev/disk/by-id/abc")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, path == "")
+}
+
+func TestSshExecDeviceTrim(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Mock ssh function
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		tests.Assert(t, len(commands) == 2)
+		tests.Assert(t, commands[0] == "sudo fstrim -v /mnt/a", commands[0])
+		tests.Assert(t, commands[1] == "sudo fstrim -v /mnt/b", commands[1])
+
+		return []string{
+			"/mnt/a: 1 MiB (1048576 bytes) trimmed\n",
+			"/mnt/b: 2 MiB (2097152 bytes) trimmed\n",
+		}, nil
+	}
+
+	trimmed, err := s.DeviceTrim("myhost", []string{"/mnt/a", "/mnt/b"})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, trimmed == 3072, trimmed)
+
+	// Nothing to trim
+	trimmed, err = s.DeviceTrim("myhost", []string{})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, trimmed == 0)
+
+	// Unexpected output
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+		return []string{"fstrim: not supported"}, nil
+	}
+
+	_, err = s.DeviceTrim("myhost", []string{"/mnt/a"})
+	tests.Assert(t, err != nil)
+}
@@ -55,3 +55,19 @@ func (s *SshExecutor) PeerDetach(host, detachnode string) error {
 
 	return nil
 }
+
+// Returns an error if the host cannot be reached or glusterd is not
+// running on it
+func (s *SshExecutor) GlusterdCheck(host string) error {
+	godbc.Require(host != "")
+
+	commands := []string{
+		"sudo systemctl status glusterd",
+	}
+	_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 1)
+	if err != nil {
+		return fmt.Errorf("glusterd is not running on %v: %v", host, err)
+	}
+
+	return nil
+}
@@ -17,10 +17,14 @@
 package sshexec
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 
+	"github.com/heketi/heketi/executors"
 	"github.com/heketi/heketi/pkg/utils"
 	"github.com/heketi/heketi/pkg/utils/ssh"
 	"github.com/lpabon/godbc"
@@ -41,12 +45,19 @@ type SshExecutor struct {
 	RemoteExecutor RemoteCommandTransport
 	Fstab          string
 
+	// Limits the brick operations run at once on each host, shared
+	// with the executors of the requests
+	BrickThrottle *executors.BrickThrottle
+
 	// Private
 	private_keyfile string
 	user            string
 	exec            Ssher
 	config          *SshConfig
 	port            string
+
+	// Commands running or waiting for a connection, by host
+	depths sync.Map
 }
 
 type SshConfig struct {
@@ -77,6 +88,7 @@ func NewSshExecutor(config *SshConfig) (*SshExecutor, error) {
 	s := &SshExecutor{}
 	s.RemoteExecutor = s
 	s.Throttlemap = make(map[string]chan bool)
+	s.BrickThrottle = executors.NewBrickThrottle()
 
 	// Set configuration
 	if config.PrivateKeyFile == "" {
@@ -145,6 +157,54 @@ func (s *SshExecutor) SetLogLevel(level string) {
 	}
 }
 
+func (s *SshExecutor) LogLevel() string {
+	return logger.Level().String()
+}
+
+// Runs the commands of a request through the transport of an
+// executor, logging them with the id of the request
+type requestTransport struct {
+	transport RemoteCommandTransport
+	logger    *utils.Logger
+}
+
+func (t *requestTransport) RemoteCommandExecute(host string,
+	commands []string,
+	timeoutMinutes int) ([]string, error) {
+
+	t.logger.Info("Running on %v: %v", host, utils.RedactCommand(strings.Join(commands, "; ")))
+	output, err := t.transport.RemoteCommandExecute(host, commands, timeoutMinutes)
+	if err != nil {
+		t.logger.LogError("Failed on %v: %v", host, err)
+	}
+	return output, err
+}
+
+// Returns an executor running the commands through the transport of
+// this executor, so with its connections and throttling, which logs
+// them with the id of the request of the context.  Returns the
+// executor itself if the context is for no request.
+func (s *SshExecutor) WithContext(ctx context.Context) executors.Executor {
+	if utils.RequestIdFromContext(ctx) == "" {
+		return s
+	}
+
+	return &SshExecutor{
+		Throttlemap: make(map[string]chan bool),
+		RemoteExecutor: &requestTransport{
+			transport: s.RemoteExecutor,
+			logger:    logger.WithContext(ctx),
+		},
+		Fstab:           s.Fstab,
+		BrickThrottle:   s.BrickThrottle,
+		private_keyfile: s.private_keyfile,
+		user:            s.user,
+		exec:            s.exec,
+		config:          s.config,
+		port:            s.port,
+	}
+}
+
 func (s *SshExecutor) AccessConnection(host string) {
 
 	var (
@@ -152,6 +212,8 @@ func (s *SshExecutor) AccessConnection(host string) {
 		ok bool
 	)
 
+	s.queueDepth(host, 1)
+
 	s.Lock.Lock()
 	if c, ok = s.Throttlemap[host]; !ok {
 		c = make(chan bool, 1)
@@ -168,6 +230,28 @@ func (s *SshExecutor) FreeConnection(host string) {
 	s.Lock.Unlock()
 
 	<-c
+	s.queueDepth(host, -1)
+}
+
+func (s *SshExecutor) queueDepth(host string, delta int64) {
+	depth, _ := s.depths.LoadOrStore(host, new(int64))
+	atomic.AddInt64(depth.(*int64), delta)
+}
+
+// Returns the commands running or waiting for a connection on each
+// host, without taking the lock of the connections
+func (s *SshExecutor) QueueDepths() map[string]int {
+	depths := make(map[string]int)
+	s.depths.Range(func(host, depth interface{}) bool {
+		depths[host.(string)] = int(atomic.LoadInt64(depth.(*int64)))
+		return true
+	})
+	return depths
+}
+
+// Returns the brick creations and deletions running on the host
+func (s *SshExecutor) BrickOpsInFlight(host string) int {
+	return s.BrickThrottle.InFlight(host)
 }
 
 func (s *SshExecutor) RemoteCommandExecute(host string,
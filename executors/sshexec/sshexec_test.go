@@ -17,7 +17,10 @@
 package sshexec
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/heketi/heketi/pkg/utils"
 	"github.com/heketi/tests"
@@ -77,6 +80,15 @@ func TestNewSshExec(t *testing.T) {
 	tests.Assert(t, s.exec != nil)
 }
 
+func TestSshExecLogLevel(t *testing.T) {
+	s := &SshExecutor{}
+
+	defer s.SetLogLevel(s.LogLevel())
+	tests.Assert(t, s.LogLevel() == "debug", s.LogLevel())
+	s.SetLogLevel("warning")
+	tests.Assert(t, s.LogLevel() == "warning", s.LogLevel())
+}
+
 func TestNewSshExecDefaults(t *testing.T) {
 	f := NewFakeSsh()
 	defer tests.Patch(&sshNew,
@@ -106,3 +118,109 @@ func TestNewSshExecBadPrivateKeyLocation(t *testing.T) {
 	tests.Assert(t, s == nil)
 	tests.Assert(t, err != nil)
 }
+
+func TestSshExecWithContext(t *testing.T) {
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+		Fstab:          "/my/fstab",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+
+	// No request
+	tests.Assert(t, s.WithContext(context.Background()) == s)
+
+	ctx := utils.ContextWithRequestId(context.Background(), "abc123")
+	rs, ok := s.WithContext(ctx).(*SshExecutor)
+	tests.Assert(t, ok)
+	tests.Assert(t, rs != s)
+	tests.Assert(t, rs.Fstab == "/my/fstab")
+	tests.Assert(t, rs.config == s.config)
+	transport, ok := rs.RemoteExecutor.(*requestTransport)
+	tests.Assert(t, ok)
+	tests.Assert(t, transport.transport == s)
+
+	// The commands run through the executor of the request
+	var executed []string
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		executed = append(executed, commands...)
+		return []string{""}, nil
+	}
+	err = rs.PeerProbe("myhost", "newnode")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(executed) == 1)
+	tests.Assert(t, executed[0] == "sudo gluster peer probe newnode", executed)
+
+	// Failures are returned
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+		return nil, errors.New("boom")
+	}
+	err = rs.PeerProbe("myhost", "newnode")
+	tests.Assert(t, err != nil)
+}
+
+func TestSshExecQueueDepths(t *testing.T) {
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	s, err := NewSshExecutor(&SshConfig{PrivateKeyFile: "xkeyfile"})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(s.QueueDepths()) == 0)
+
+	// One command runs on the host while the other waits
+	running := make(chan bool)
+	release := make(chan bool)
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		running <- true
+		<-release
+		return []string{""}, nil
+	}
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := s.RemoteCommandExecute("myhost", []string{"ls"}, 10)
+			done <- err
+		}()
+	}
+	<-running
+	for i := 0; s.QueueDepths()["myhost"] != 2; i++ {
+		tests.Assert(t, i < 1000, s.QueueDepths())
+		time.Sleep(time.Millisecond)
+	}
+
+	// Not blocked by the lock of the connections
+	s.Lock.Lock()
+	tests.Assert(t, s.QueueDepths()["myhost"] == 2)
+	s.Lock.Unlock()
+
+	release <- true
+	<-running
+	release <- true
+	tests.Assert(t, <-done == nil)
+	tests.Assert(t, <-done == nil)
+	tests.Assert(t, s.QueueDepths()["myhost"] == 0)
+}
@@ -0,0 +1,283 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sshexec
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/heketi/heketi/executors"
+	"github.com/lpabon/godbc"
+)
+
+// Structure used to unmarshal JSON from the lvm report commands
+type lvmReport struct {
+	Report []struct {
+		Pv []map[string]string `json:"pv"`
+		Vg []map[string]string `json:"vg"`
+		Lv []map[string]string `json:"lv"`
+	} `json:"report"`
+}
+
+// Sizes are reported in KB without a suffix
+func lvmSize(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to parse size %v: %v", value, err)
+	}
+	return uint64(size), nil
+}
+
+func (s *SshExecutor) NodeStorageInfo(host string) (*executors.NodeStorageInfo, error) {
+	godbc.Require(host != "")
+
+	commands := []string{
+		"sudo gluster --mode=script volume info --xml",
+		"sudo pvs --reportformat json -o pv_name,vg_name",
+		"sudo vgs --reportformat json --units k --nosuffix -o vg_name,vg_size,vg_free",
+		"sudo lvs --reportformat json --units k --nosuffix " +
+			"-o lv_name,vg_name,pool_lv,lv_size,lv_metadata_size",
+		"cat /proc/mounts",
+	}
+
+	output, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get storage information of %v: %v", host, err)
+	}
+
+	info := &executors.NodeStorageInfo{}
+
+	// Gluster volumes
+	var volInfo cliVolumeInfo
+	err = xml.Unmarshal([]byte(output[0]), &volInfo)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to determine volumes on %v: %v", host, err)
+	}
+	for _, vol := range volInfo.VolInfo.Volumes.Volume {
+		volume := executors.VolumeInfo{
+			Name: vol.Name,
+		}
+		switch {
+		case strings.Contains(vol.TypeStr, "Disperse"):
+			volume.Type = executors.DurabilityDispersion
+			volume.Data = vol.DisperseCount - vol.RedundancyCount
+			volume.Redundancy = vol.RedundancyCount
+		case strings.Contains(vol.TypeStr, "Replicate"):
+			volume.Type = executors.DurabilityReplica
+			volume.Replica = vol.ReplicaCount
+		default:
+			volume.Type = executors.DurabilityNone
+		}
+		volume.Bricks, err = vol.bricks()
+		if err != nil {
+			return nil, err
+		}
+		info.Volumes = append(info.Volumes, volume)
+	}
+
+	// LVM
+	var pvs, vgs, lvs lvmReport
+	for i, report := range []*lvmReport{&pvs, &vgs, &lvs} {
+		err = json.Unmarshal([]byte(output[i+1]), report)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse lvm report from %v: %v", host, err)
+		}
+	}
+	for _, r := range pvs.Report {
+		for _, pv := range r.Pv {
+			info.PhysicalVolumes = append(info.PhysicalVolumes, executors.PhysicalVolumeInfo{
+				Name:   pv["pv_name"],
+				VgName: pv["vg_name"],
+			})
+		}
+	}
+	for _, r := range vgs.Report {
+		for _, vg := range r.Vg {
+			size, err := lvmSize(vg["vg_size"])
+			if err != nil {
+				return nil, err
+			}
+			free, err := lvmSize(vg["vg_free"])
+			if err != nil {
+				return nil, err
+			}
+			info.VolumeGroups = append(info.VolumeGroups, executors.VolumeGroupInfo{
+				Name: vg["vg_name"],
+				Size: size,
+				Free: free,
+			})
+		}
+	}
+	for _, r := range lvs.Report {
+		for _, lv := range r.Lv {
+			size, err := lvmSize(lv["lv_size"])
+			if err != nil {
+				return nil, err
+			}
+			metadataSize, err := lvmSize(lv["lv_metadata_size"])
+			if err != nil {
+				return nil, err
+			}
+			info.LogicalVolumes = append(info.LogicalVolumes, executors.LogicalVolumeInfo{
+				Name:         lv["lv_name"],
+				VgName:       lv["vg_name"],
+				PoolLv:       lv["pool_lv"],
+				Size:         size,
+				MetadataSize: metadataSize,
+			})
+		}
+	}
+
+	// Mounts
+	for _, line := range strings.Split(output[4], "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		info.Mounts = append(info.Mounts, executors.MountInfo{
+			Device:     fields[0],
+			MountPoint: fields[1],
+		})
+	}
+
+	return info, nil
+}
+
+// Returns the average time in milliseconds of direct reads from the
+// devices.  Only reads are timed since the devices are in use.
+func (s *SshExecutor) NodeStorageLatency(host string, devices []string) (float64, error) {
+	godbc.Require(host != "")
+
+	if len(devices) == 0 {
+		return 0, nil
+	}
+
+	// ioping prints the count, total time, iops, speed, then the
+	// min, average and max time of the requests in nanoseconds
+	commands := make([]string, 0, len(devices))
+	for _, device := range devices {
+		commands = append(commands,
+			fmt.Sprintf("sudo ioping -B -D -q -c 5 %v", device))
+	}
+
+	output, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 5)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to measure storage latency of %v: %v", host, err)
+	}
+
+	total := float64(0)
+	for _, out := range output {
+		fields := strings.Fields(out)
+		if len(fields) < 6 {
+			return 0, fmt.Errorf("Unable to parse ioping output: %v", out)
+		}
+		avg, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to parse ioping output: %v", out)
+		}
+		total += avg / 1e6
+	}
+
+	return total / float64(len(output)), nil
+}
+
+// Returns the memory and number of CPUs of the node
+func (s *SshExecutor) NodeMetrics(host string) (*executors.NodeMetrics, error) {
+	godbc.Require(host != "")
+
+	commands := []string{
+		"free -b",
+		"nproc",
+	}
+
+	output, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 5)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get metrics of %v: %v", host, err)
+	}
+	if len(output) != len(commands) {
+		return nil, fmt.Errorf("Unable to get metrics of %v: missing output", host)
+	}
+
+	// free prints the total, used and free memory of the Mem: line
+	metrics := &executors.NodeMetrics{}
+	found := false
+	for _, line := range strings.Split(output[0], "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "Mem:" {
+			continue
+		}
+		metrics.MemoryTotal, err = strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse free output: %v", line)
+		}
+		metrics.MemoryFree, err = strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse free output: %v", line)
+		}
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("Unable to parse free output: %v", output[0])
+	}
+
+	metrics.CpuCount, err = strconv.Atoi(strings.TrimSpace(output[1]))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse nproc output: %v", output[1])
+	}
+
+	return metrics, nil
+}
+
+// Returns the versions of GlusterFS and of the kernel of the node
+func (s *SshExecutor) NodeVersions(host string) (*executors.NodeVersions, error) {
+	godbc.Require(host != "")
+
+	commands := []string{
+		"gluster --version",
+		"uname -r",
+	}
+
+	output, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 5)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get versions of %v: %v", host, err)
+	}
+	if len(output) != len(commands) {
+		return nil, fmt.Errorf("Unable to get versions of %v: missing output", host)
+	}
+
+	// The first line is like: glusterfs 3.7.11 built on Apr 18 2016 14:37:14
+	fields := strings.Fields(strings.SplitN(output[0], "\n", 2)[0])
+	if len(fields) < 2 || fields[0] != "glusterfs" {
+		return nil, fmt.Errorf("Unable to parse gluster version output: %v", output[0])
+	}
+
+	kernel := strings.TrimSpace(output[1])
+	if kernel == "" {
+		return nil, fmt.Errorf("Unable to parse uname output: %v", output[1])
+	}
+
+	return &executors.NodeVersions{
+		GlusterVersion: fields[1],
+		KernelVersion:  kernel,
+	}, nil
+}
@@ -0,0 +1,328 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sshexec
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func TestSshExecNodeStorageInfo(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Mock ssh function
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		tests.Assert(t, len(commands) == 5)
+
+		return []string{`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput>
+  <opRet>0</opRet>
+  <volInfo>
+    <volumes>
+      <volume>
+        <name>vol_abc</name>
+        <typeStr>Replicate</typeStr>
+        <replicaCount>2</replicaCount>
+        <disperseCount>0</disperseCount>
+        <redundancyCount>0</redundancyCount>
+        <bricks>
+          <brick uuid="1"><name>host1:/var/lib/heketi/mounts/vg_d1/brick_b1/brick</name></brick>
+          <brick uuid="2"><name>host2:/var/lib/heketi/mounts/vg_d2/brick_b2/brick</name></brick>
+        </bricks>
+      </volume>
+      <volume>
+        <name>ec</name>
+        <typeStr>Disperse</typeStr>
+        <replicaCount>1</replicaCount>
+        <disperseCount>6</disperseCount>
+        <redundancyCount>2</redundancyCount>
+        <bricks></bricks>
+      </volume>
+      <volume>
+        <name>dist</name>
+        <typeStr>Distribute</typeStr>
+        <replicaCount>1</replicaCount>
+        <bricks></bricks>
+      </volume>
+    </volumes>
+  </volInfo>
+</cliOutput>`,
+			`{"report": [{"pv": [{"pv_name":"/dev/sdb", "vg_name":"vg_d1"}]}]}`,
+			`{"report": [{"vg": [{"vg_name":"vg_d1", "vg_size":"1048576.00", "vg_free":"524288.00"}]}]}`,
+			`{"report": [{"lv": [
+				{"lv_name":"brick_b1", "vg_name":"vg_d1", "pool_lv":"tp_b1", "lv_size":"4096.00", "lv_metadata_size":""},
+				{"lv_name":"tp_b1", "vg_name":"vg_d1", "pool_lv":"", "lv_size":"4096.00", "lv_metadata_size":"512.00"}
+			]}]}`,
+			"/dev/sda1 / xfs rw 0 0\n" +
+				"/dev/mapper/vg_d1-brick_b1 /var/lib/heketi/mounts/vg_d1/brick_b1 xfs rw 0 0\n"}, nil
+	}
+
+	info, err := s.NodeStorageInfo("myhost")
+	tests.Assert(t, err == nil, err)
+
+	tests.Assert(t, len(info.Volumes) == 3)
+	tests.Assert(t, info.Volumes[0].Name == "vol_abc")
+	tests.Assert(t, info.Volumes[0].Type == executors.DurabilityReplica)
+	tests.Assert(t, info.Volumes[0].Replica == 2)
+	tests.Assert(t, len(info.Volumes[0].Bricks) == 2, info.Volumes[0].Bricks)
+	tests.Assert(t, info.Volumes[0].Bricks[1].Host == "host2")
+	tests.Assert(t, info.Volumes[0].Bricks[1].Path == "/var/lib/heketi/mounts/vg_d2/brick_b2/brick")
+	tests.Assert(t, info.Volumes[1].Type == executors.DurabilityDispersion)
+	tests.Assert(t, info.Volumes[1].Data == 4)
+	tests.Assert(t, info.Volumes[1].Redundancy == 2)
+	tests.Assert(t, info.Volumes[2].Type == executors.DurabilityNone)
+
+	tests.Assert(t, reflect.DeepEqual(info.PhysicalVolumes, []executors.PhysicalVolumeInfo{
+		{Name: "/dev/sdb", VgName: "vg_d1"},
+	}))
+	tests.Assert(t, reflect.DeepEqual(info.VolumeGroups, []executors.VolumeGroupInfo{
+		{Name: "vg_d1", Size: 1048576, Free: 524288},
+	}))
+	tests.Assert(t, reflect.DeepEqual(info.LogicalVolumes, []executors.LogicalVolumeInfo{
+		{Name: "brick_b1", VgName: "vg_d1", PoolLv: "tp_b1", Size: 4096},
+		{Name: "tp_b1", VgName: "vg_d1", Size: 4096, MetadataSize: 512},
+	}), info.LogicalVolumes)
+	tests.Assert(t, len(info.Mounts) == 2)
+	tests.Assert(t, info.Mounts[1].Device == "/dev/mapper/vg_d1-brick_b1")
+	tests.Assert(t, info.Mounts[1].MountPoint == "/var/lib/heketi/mounts/vg_d1/brick_b1")
+}
+
+func TestSshExecNodeStorageLatency(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		tests.Assert(t, len(commands) == 2)
+		tests.Assert(t, commands[0] == "sudo ioping -B -D -q -c 5 /dev/sdb", commands[0])
+
+		return []string{
+			"4 2000000 2000 8192000 400000 500000 600000 50000\n",
+			"4 6000000 666 2730666 1400000 1500000 1600000 50000\n",
+		}, nil
+	}
+
+	latency, err := s.NodeStorageLatency("myhost", []string{"/dev/sdb", "/dev/sdc"})
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, latency == 1.0, latency)
+
+	// No devices
+	latency, err = s.NodeStorageLatency("myhost", []string{})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, latency == 0)
+
+	// Unexpected output
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+		return []string{"ioping: command not found"}, nil
+	}
+	_, err = s.NodeStorageLatency("myhost", []string{"/dev/sdb"})
+	tests.Assert(t, err != nil)
+}
+
+func TestSshExecNodeMetrics(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		tests.Assert(t, len(commands) == 2)
+		tests.Assert(t, commands[0] == "free -b", commands[0])
+		tests.Assert(t, commands[1] == "nproc", commands[1])
+
+		return []string{
+			"              total        used        free      shared  buff/cache   available\n" +
+				"Mem:     8254103552  2153500672  3932016640    60674048  2168586240  5735505920\n" +
+				"Swap:    2147479552           0  2147479552\n",
+			"4\n",
+		}, nil
+	}
+
+	metrics, err := s.NodeMetrics("myhost")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, metrics.MemoryTotal == 8254103552, metrics)
+	tests.Assert(t, metrics.MemoryFree == 3932016640, metrics)
+	tests.Assert(t, metrics.CpuCount == 4, metrics)
+
+	// Unexpected output
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+		return []string{"free: command not found", "4"}, nil
+	}
+	_, err = s.NodeMetrics("myhost")
+	tests.Assert(t, err != nil)
+}
+
+func TestSshExecNodeVersions(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		tests.Assert(t, len(commands) == 2)
+		tests.Assert(t, commands[0] == "gluster --version", commands[0])
+		tests.Assert(t, commands[1] == "uname -r", commands[1])
+
+		return []string{
+			"glusterfs 3.7.11 built on Apr 18 2016 14:37:14\n" +
+				"Repository revision: git://git.gluster.com/glusterfs.git\n",
+			"3.10.0-327.el7.x86_64\n",
+		}, nil
+	}
+
+	versions, err := s.NodeVersions("myhost")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, versions.GlusterVersion == "3.7.11", versions)
+	tests.Assert(t, versions.KernelVersion == "3.10.0-327.el7.x86_64", versions)
+
+	// Unexpected output
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+		return []string{"gluster: command not found", "3.10.0"}, nil
+	}
+	_, err = s.NodeVersions("myhost")
+	tests.Assert(t, err != nil)
+}
+
+func TestSshExecGlusterdCheck(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		tests.Assert(t, len(commands) == 1)
+		tests.Assert(t, commands[0] == "sudo systemctl status glusterd", commands[0])
+		return nil, nil
+	}
+	err = s.GlusterdCheck("myhost")
+	tests.Assert(t, err == nil, err)
+
+	// Unreachable host
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+		return nil, errors.New("connection refused")
+	}
+	err = s.GlusterdCheck("myhost")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "myhost"), err)
+}
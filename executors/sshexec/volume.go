@@ -19,11 +19,21 @@ package sshexec
 import (
 	"encoding/xml"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/utils"
 	"github.com/lpabon/godbc"
 )
 
+const (
+	// First versions of GlusterFS with arbiter volumes, and with the
+	// replica count of arbiter volumes only counting the data bricks
+	glusterVersionArbiter     = "3.7"
+	glusterVersionArbiterData = "3.12"
+)
+
 func (s *SshExecutor) VolumeCreate(host string,
 	volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
 
@@ -46,8 +56,22 @@ func (s *SshExecutor) VolumeCreate(host string,
 		inSet = 1
 		maxPerSet = 15
 	case executors.DurabilityReplica:
-		logger.Info("Creating volume %v replica %v", volume.Name, volume.Replica)
-		cmd += fmt.Sprintf("replica %v ", volume.Replica)
+		logger.Info("Creating volume %v replica %v arbiter %v",
+			volume.Name, volume.Replica, volume.Arbiter)
+		version := volume.GlusterVersion
+		switch {
+		case volume.Arbiter == 0:
+			cmd += fmt.Sprintf("replica %v ", volume.Replica)
+		case version != "" && utils.CompareVersions(version, glusterVersionArbiter) < 0:
+			return nil, fmt.Errorf("Arbiter volumes need GlusterFS %v or later, "+
+				"the nodes run %v", glusterVersionArbiter, version)
+		case version != "" && utils.CompareVersions(version, glusterVersionArbiterData) >= 0:
+			// The replica count only counts the data bricks
+			cmd += fmt.Sprintf("replica %v arbiter %v ",
+				volume.Replica-volume.Arbiter, volume.Arbiter)
+		default:
+			cmd += fmt.Sprintf("replica %v arbiter %v ", volume.Replica, volume.Arbiter)
+		}
 		inSet = volume.Replica
 		maxPerSet = 5
 	case executors.DurabilityDispersion:
@@ -71,6 +95,34 @@ func (s *SshExecutor) VolumeCreate(host string,
 	// Now add all the commands to add the bricks
 	commands = append(commands, s.createAddBrickCommands(volume, inSet, inSet, maxPerSet)...)
 
+	// Export the volume over the protocol
+	switch volume.Protocol {
+	case executors.VolumeProtocolNFS:
+		commands = append(commands,
+			fmt.Sprintf("sudo gluster --mode=script volume set %v nfs.disable off", volume.Name),
+			fmt.Sprintf("sudo gluster --mode=script volume set %v nfs.addr-namelookup off", volume.Name))
+	case executors.VolumeProtocolSMB:
+		commands = append(commands,
+			fmt.Sprintf("sudo gluster --mode=script volume set %v user.smb enable", volume.Name))
+	}
+
+	// Files become read-only once written
+	if volume.WORM {
+		commands = append(commands,
+			fmt.Sprintf("sudo gluster --mode=script volume set %v features.worm on", volume.Name),
+			fmt.Sprintf("sudo gluster --mode=script volume set %v features.worm-file-level on", volume.Name),
+			fmt.Sprintf("sudo gluster --mode=script volume set %v features.retention-mode %v",
+				volume.Name, volume.WORMRetentionMode))
+		if volume.WORMRetentionPeriod > 0 {
+			commands = append(commands,
+				fmt.Sprintf("sudo gluster --mode=script volume set %v features.default-retention-period %v",
+					volume.Name, volume.WORMRetentionPeriod))
+		}
+	}
+
+	// Only clients with the CHAP credentials may log in to the bricks
+	commands = append(commands, chapAuthCommands(volume)...)
+
 	// Add command to start the volume
 	commands = append(commands, fmt.Sprintf("sudo gluster volume start %v", volume.Name))
 
@@ -84,6 +136,28 @@ func (s *SshExecutor) VolumeCreate(host string,
 	return &executors.VolumeInfo{}, nil
 }
 
+// Returns the commands allowing the CHAP user of the volume to log in
+// to the bricks of the request, if the volume uses CHAP authentication
+func chapAuthCommands(volume *executors.VolumeRequest) []string {
+	if volume.CHAPUsername == "" {
+		return nil
+	}
+
+	commands := []string{}
+	for _, brick := range volume.Bricks {
+		commands = append(commands,
+			fmt.Sprintf("sudo gluster --mode=script volume set %v auth.login.%v.allow %v",
+				volume.Name, brick.Path, volume.CHAPUsername))
+	}
+	commands = append(commands,
+		fmt.Sprintf("sudo gluster --mode=script volume set %v auth.login.%v.password %v",
+			volume.Name, volume.CHAPUsername, volume.CHAPSecret),
+		fmt.Sprintf("sudo gluster --mode=script volume set %v server.allow-insecure off",
+			volume.Name))
+
+	return commands
+}
+
 func (s *SshExecutor) VolumeExpand(host string,
 	volume *executors.VolumeRequest) (*executors.VolumeInfo, error) {
 
@@ -114,6 +188,7 @@ func (s *SshExecutor) VolumeExpand(host string,
 		0, // start at the beginning of the brick list
 		inSet,
 		maxPerSet)
+	commands = append(commands, chapAuthCommands(volume)...)
 
 	// Rebalance if configured
 	if s.config.RebalanceOnExpansion {
@@ -174,6 +249,598 @@ func (s *SshExecutor) VolumeDestroyCheck(host, volume string) error {
 	return nil
 }
 
+// Stucture used to unmarshal XML from volume info gluster cli
+type cliVolumeInfo struct {
+	VolInfo struct {
+		Volumes struct {
+			Volume []cliVolume `xml:"volume"`
+		} `xml:"volumes"`
+	} `xml:"volInfo"`
+}
+
+type cliVolume struct {
+	Name            string `xml:"name"`
+	TypeStr         string `xml:"typeStr"`
+	ReplicaCount    int    `xml:"replicaCount"`
+	DisperseCount   int    `xml:"disperseCount"`
+	RedundancyCount int    `xml:"redundancyCount"`
+	Bricks          struct {
+		Brick []struct {
+			Name string `xml:"name"`
+		} `xml:"brick"`
+	} `xml:"bricks"`
+}
+
+// Bricks are reported as host:path
+func (v *cliVolume) bricks() ([]executors.BrickInfo, error) {
+	bricks := make([]executors.BrickInfo, 0, len(v.Bricks.Brick))
+	for _, brick := range v.Bricks.Brick {
+		hostpath := strings.SplitN(brick.Name, ":", 2)
+		if len(hostpath) != 2 {
+			return nil, fmt.Errorf("Unable to parse brick %v of volume %v",
+				brick.Name, v.Name)
+		}
+		bricks = append(bricks, executors.BrickInfo{
+			Host: hostpath[0],
+			Path: hostpath[1],
+		})
+	}
+	return bricks, nil
+}
+
+func (s *SshExecutor) VolumeInfo(host string, volume string) (*executors.VolumeInfo, error) {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+
+	// Get volume information
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume info %v --xml", volume),
+	}
+
+	// Execute command
+	output, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get volume info of volume %v: %v", volume, err)
+	}
+
+	var volInfo cliVolumeInfo
+	err = xml.Unmarshal([]byte(output[0]), &volInfo)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to determine volume info of volume %v: %v", volume, err)
+	}
+
+	info := &executors.VolumeInfo{}
+	for _, vol := range volInfo.VolInfo.Volumes.Volume {
+		if vol.Name != volume {
+			continue
+		}
+
+		info.Bricks, err = vol.bricks()
+		if err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+
+	return nil, fmt.Errorf("Volume %v not found on %v", volume, host)
+}
+
+// Replaces a brick of the volume with a new, empty brick.  The data is
+// copied to the new brick by self-heal, so it is only safe for volumes
+// with replicas or redundancy.
+func (s *SshExecutor) VolumeReplaceBrick(host string, volume string,
+	oldBrick, newBrick *executors.BrickInfo) error {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+	godbc.Require(oldBrick != nil)
+	godbc.Require(newBrick != nil)
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume replace-brick %v %v:%v %v:%v commit force",
+			volume, oldBrick.Host, oldBrick.Path, newBrick.Host, newBrick.Path),
+	}
+
+	// Execute command
+	_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return fmt.Errorf("Unable to replace brick %v:%v of volume %v: %v",
+			oldBrick.Host, oldBrick.Path, volume, err)
+	}
+
+	return nil
+}
+
+func removeBricksArgs(bricks []executors.BrickInfo) string {
+	args := make([]string, 0, len(bricks))
+	for _, brick := range bricks {
+		args = append(args, brick.Host+":"+brick.Path)
+	}
+	return strings.Join(args, " ")
+}
+
+// Starts migrating the data off the bricks, which are removed from the
+// volume once committed.  The bricks must be whole replica or
+// disperse sets.
+func (s *SshExecutor) VolumeRemoveBricksStart(host string, volume string,
+	bricks []executors.BrickInfo) error {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+	godbc.Require(len(bricks) > 0)
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume remove-brick %v %v start",
+			volume, removeBricksArgs(bricks)),
+	}
+
+	// Execute command
+	_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return fmt.Errorf("Unable to start removing bricks of volume %v: %v", volume, err)
+	}
+
+	return nil
+}
+
+// Returns the progress of the migration of the data off the bricks,
+// or an error if the migration failed or was stopped
+func (s *SshExecutor) VolumeRemoveBricksStatus(host string, volume string,
+	bricks []executors.BrickInfo) (*executors.RemoveBricksStatus, error) {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+	godbc.Require(len(bricks) > 0)
+
+	type CliOutput struct {
+		RemoveBrick struct {
+			Nodes []struct {
+				StatusStr string `xml:"statusStr"`
+			} `xml:"node"`
+			Aggregate struct {
+				Files     uint64 `xml:"files"`
+				StatusStr string `xml:"statusStr"`
+				Failures  int    `xml:"failures"`
+			} `xml:"aggregate"`
+		} `xml:"volRemoveBrick"`
+	}
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume remove-brick %v %v status --xml",
+			volume, removeBricksArgs(bricks)),
+	}
+
+	// Execute command
+	output, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get the status of the removal of bricks of volume %v: %v",
+			volume, err)
+	}
+
+	var status CliOutput
+	err = xml.Unmarshal([]byte(output[0]), &status)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the status of the removal of bricks of volume %v: %v",
+			volume, err)
+	}
+
+	aggregate := status.RemoveBrick.Aggregate
+	switch aggregate.StatusStr {
+	case "completed":
+		if aggregate.Failures != 0 {
+			return nil, fmt.Errorf("Unable to migrate %v files off the bricks of volume %v",
+				aggregate.Failures, volume)
+		}
+	case "failed", "stopped":
+		return nil, fmt.Errorf("Removal of bricks of volume %v %v", volume, aggregate.StatusStr)
+	}
+
+	progress := &executors.RemoveBricksStatus{
+		Completed: aggregate.StatusStr == "completed",
+		Nodes:     len(status.RemoveBrick.Nodes),
+		Files:     aggregate.Files,
+	}
+	for _, node := range status.RemoveBrick.Nodes {
+		if node.StatusStr == "completed" {
+			progress.NodesCompleted++
+		}
+	}
+	return progress, nil
+}
+
+// Removes the bricks from the volume once their data was migrated
+func (s *SshExecutor) VolumeRemoveBricksCommit(host string, volume string,
+	bricks []executors.BrickInfo) error {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+	godbc.Require(len(bricks) > 0)
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume remove-brick %v %v commit",
+			volume, removeBricksArgs(bricks)),
+	}
+
+	// Execute command
+	_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return fmt.Errorf("Unable to commit the removal of bricks of volume %v: %v", volume, err)
+	}
+
+	return nil
+}
+
+// Stops the migration of the data off the bricks, which stay in the
+// volume
+func (s *SshExecutor) VolumeRemoveBricksStop(host string, volume string,
+	bricks []executors.BrickInfo) error {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+	godbc.Require(len(bricks) > 0)
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume remove-brick %v %v stop",
+			volume, removeBricksArgs(bricks)),
+	}
+
+	// Execute command
+	_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return fmt.Errorf("Unable to stop the removal of bricks of volume %v: %v", volume, err)
+	}
+
+	return nil
+}
+
+func (s *SshExecutor) VolumeProfileStart(host string, volume string) error {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume profile %v start", volume),
+	}
+
+	// Execute command
+	_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return fmt.Errorf("Unable to start profiling volume %v: %v", volume, err)
+	}
+
+	return nil
+}
+
+func (s *SshExecutor) VolumeProfileStop(host string, volume string) error {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume profile %v stop", volume),
+	}
+
+	// Execute command
+	_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return fmt.Errorf("Unable to stop profiling volume %v: %v", volume, err)
+	}
+
+	return nil
+}
+
+// Stucture used to unmarshal XML from volume profile info gluster cli
+type cliVolumeProfile struct {
+	VolProfile struct {
+		Volname string `xml:"volname"`
+		Brick   []struct {
+			BrickName       string          `xml:"brickName"`
+			CumulativeStats cliProfileStats `xml:"cumulativeStats"`
+			IntervalStats   cliProfileStats `xml:"intervalStats"`
+		} `xml:"brick"`
+	} `xml:"volProfile"`
+}
+
+type cliProfileStats struct {
+	FopStats struct {
+		Fop []struct {
+			Name       string  `xml:"name"`
+			Hits       uint64  `xml:"hits"`
+			AvgLatency float64 `xml:"avgLatency"`
+			MinLatency float64 `xml:"minLatency"`
+			MaxLatency float64 `xml:"maxLatency"`
+		} `xml:"fop"`
+	} `xml:"fopStats"`
+	Duration   uint64 `xml:"duration"`
+	TotalRead  uint64 `xml:"totalRead"`
+	TotalWrite uint64 `xml:"totalWrite"`
+}
+
+// Returns the cumulative statistics of the bricks of the volume.
+// Profiling must have been started on the volume.
+func (s *SshExecutor) VolumeProfileInfo(host string,
+	volume string) (*executors.VolumeProfileInfo, error) {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume profile %v info --xml", volume),
+	}
+
+	// Execute command
+	output, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get profile info of volume %v: %v", volume, err)
+	}
+
+	var profile cliVolumeProfile
+	err = xml.Unmarshal([]byte(output[0]), &profile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to determine profile info of volume %v: %v", volume, err)
+	}
+
+	info := &executors.VolumeProfileInfo{
+		Bricks: make([]executors.BrickProfileInfo, 0, len(profile.VolProfile.Brick)),
+	}
+	for _, brick := range profile.VolProfile.Brick {
+		stats := brick.CumulativeStats
+		b := executors.BrickProfileInfo{
+			Name:        brick.BrickName,
+			Duration:    stats.Duration,
+			DataRead:    stats.TotalRead,
+			DataWritten: stats.TotalWrite,
+			Fops:        make([]executors.FopProfileInfo, 0, len(stats.FopStats.Fop)),
+		}
+		for _, fop := range stats.FopStats.Fop {
+			b.Fops = append(b.Fops, executors.FopProfileInfo{
+				Name:       fop.Name,
+				Hits:       fop.Hits,
+				AvgLatency: fop.AvgLatency,
+				MinLatency: fop.MinLatency,
+				MaxLatency: fop.MaxLatency,
+			})
+		}
+		info.Bricks = append(info.Bricks, b)
+	}
+
+	return info, nil
+}
+
+// Stucture used to unmarshal XML from volume status clients gluster cli
+type cliVolumeStatusClients struct {
+	VolStatus struct {
+		Volumes struct {
+			Volume []struct {
+				Node []struct {
+					Hostname      string `xml:"hostname"`
+					Path          string `xml:"path"`
+					ClientsStatus struct {
+						ClientCount int `xml:"clientCount"`
+					} `xml:"clientsStatus"`
+				} `xml:"node"`
+			} `xml:"volume"`
+		} `xml:"volumes"`
+	} `xml:"volStatus"`
+}
+
+// Returns the clients connected to the bricks of the volume, and the
+// rate of their reads and writes.  The volume status does not report
+// the rates, they are taken from the profile info of the interval
+// since it was last read, so they are zero unless profiling is
+// started on the volume.
+func (s *SshExecutor) VolumeIOStats(host string,
+	volume string) (*executors.VolumeIOStats, error) {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume status %v clients --xml", volume),
+	}
+
+	// Execute command
+	output, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get status of volume %v: %v", volume, err)
+	}
+
+	var status cliVolumeStatusClients
+	err = xml.Unmarshal([]byte(output[0]), &status)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to determine status of volume %v: %v", volume, err)
+	}
+
+	info := &executors.VolumeIOStats{
+		Bricks: []executors.BrickIOStats{},
+	}
+	for _, v := range status.VolStatus.Volumes.Volume {
+		for _, node := range v.Node {
+			info.Bricks = append(info.Bricks, executors.BrickIOStats{
+				Host:        node.Hostname,
+				Path:        node.Path,
+				ClientCount: node.ClientsStatus.ClientCount,
+			})
+		}
+	}
+
+	commands = []string{
+		fmt.Sprintf("sudo gluster --mode=script volume profile %v info incremental --xml", volume),
+	}
+	output, err = s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		// Profiling is not started
+		logger.Debug("No profile info of volume %v: %v", volume, err)
+		return info, nil
+	}
+
+	var profile cliVolumeProfile
+	err = xml.Unmarshal([]byte(output[0]), &profile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to determine profile info of volume %v: %v", volume, err)
+	}
+
+	for _, brick := range profile.VolProfile.Brick {
+		stats := brick.IntervalStats
+		if stats.Duration == 0 {
+			continue
+		}
+		for i := range info.Bricks {
+			b := &info.Bricks[i]
+			if b.Host+":"+b.Path != brick.BrickName {
+				continue
+			}
+			for _, fop := range stats.FopStats.Fop {
+				switch fop.Name {
+				case "READ":
+					b.ReadOpsPerSec = float64(fop.Hits) / float64(stats.Duration)
+				case "WRITE":
+					b.WriteOpsPerSec = float64(fop.Hits) / float64(stats.Duration)
+				}
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// Starts scrubbing the bricks of the volume for bit rot, without
+// waiting for it to complete.  Bit rot detection must be enabled on
+// the volume.
+func (s *SshExecutor) VolumeScrub(host string, volume string) error {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume bitrot %v scrub ondemand", volume),
+	}
+
+	// Execute command
+	_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return fmt.Errorf("Unable to scrub volume %v: %v", volume, err)
+	}
+
+	return nil
+}
+
+// Starts moving the data of the volume to its bricks added since it
+// was last rebalanced, without waiting for it to complete
+func (s *SshExecutor) VolumeRebalance(host string, volume string) error {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume rebalance %v start", volume),
+	}
+
+	// Execute command
+	_, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return fmt.Errorf("Unable to rebalance volume %v: %v", volume, err)
+	}
+
+	return nil
+}
+
+// Returns the progress of the rebalance of the volume, or an error if
+// the rebalance failed or was stopped
+func (s *SshExecutor) VolumeRebalanceStatus(host string, volume string) (*executors.RebalanceStatus, error) {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+
+	type CliOutput struct {
+		Rebalance struct {
+			Nodes []struct {
+				StatusStr string `xml:"statusStr"`
+			} `xml:"node"`
+			Aggregate struct {
+				Files     uint64 `xml:"files"`
+				StatusStr string `xml:"statusStr"`
+				Failures  int    `xml:"failures"`
+			} `xml:"aggregate"`
+		} `xml:"volRebalance"`
+	}
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume rebalance %v status --xml", volume),
+	}
+
+	// Execute command
+	output, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get the rebalance status of volume %v: %v", volume, err)
+	}
+
+	var status CliOutput
+	err = xml.Unmarshal([]byte(output[0]), &status)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the rebalance status of volume %v: %v", volume, err)
+	}
+
+	aggregate := status.Rebalance.Aggregate
+	switch aggregate.StatusStr {
+	case "completed":
+		if aggregate.Failures != 0 {
+			return nil, fmt.Errorf("Unable to rebalance %v files of volume %v",
+				aggregate.Failures, volume)
+		}
+	case "failed", "stopped":
+		return nil, fmt.Errorf("Rebalance of volume %v %v", volume, aggregate.StatusStr)
+	}
+
+	progress := &executors.RebalanceStatus{
+		Completed: aggregate.StatusStr == "completed",
+		Nodes:     len(status.Rebalance.Nodes),
+		Files:     aggregate.Files,
+	}
+	for _, node := range status.Rebalance.Nodes {
+		if node.StatusStr == "completed" {
+			progress.NodesCompleted++
+		}
+	}
+	return progress, nil
+}
+
+// Returns the entries of the volume left to heal.  The entries of
+// bricks which are not connected are unknown, and the brick counts
+// as not healed.
+func (s *SshExecutor) VolumeHealInfo(host string, volume string) (*executors.HealInfo, error) {
+	godbc.Require(host != "")
+	godbc.Require(volume != "")
+
+	type CliOutput struct {
+		HealInfo struct {
+			Bricks []struct {
+				Name            string `xml:"name"`
+				NumberOfEntries string `xml:"numberOfEntries"`
+			} `xml:"bricks>brick"`
+		} `xml:"healInfo"`
+	}
+
+	commands := []string{
+		fmt.Sprintf("sudo gluster --mode=script volume heal %v info --xml", volume),
+	}
+
+	// Execute command
+	output, err := s.RemoteExecutor.RemoteCommandExecute(host, commands, 10)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get the heal info of volume %v: %v", volume, err)
+	}
+
+	var heal CliOutput
+	err = xml.Unmarshal([]byte(output[0]), &heal)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the heal info of volume %v: %v", volume, err)
+	}
+
+	info := &executors.HealInfo{
+		Bricks: len(heal.HealInfo.Bricks),
+	}
+	for _, brick := range heal.HealInfo.Bricks {
+		entries, err := strconv.ParseUint(brick.NumberOfEntries, 10, 64)
+		if err != nil {
+			continue
+		}
+		info.Entries += entries
+		if entries == 0 {
+			info.BricksHealed++
+		}
+	}
+	return info, nil
+}
+
 func (s *SshExecutor) createAddBrickCommands(volume *executors.VolumeRequest,
 	start, inSet, maxPerSet int) []string {
 
@@ -196,8 +863,11 @@ func (s *SshExecutor) createAddBrickCommands(volume *executors.VolumeRequest,
 		cmd += fmt.Sprintf("%v:%v ", brick.Host, brick.Path)
 	}
 
-	// Add the last add-brick command to the command list
-	commands = append(commands, cmd)
+	// Add the last add-brick command to the command list.  There is
+	// none if all the bricks were in the create command.
+	if cmd != "" {
+		commands = append(commands, cmd)
+	}
 
 	return commands
 }
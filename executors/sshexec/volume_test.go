@@ -0,0 +1,1005 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package sshexec
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/heketi/heketi/executors"
+	"github.com/heketi/heketi/pkg/utils"
+	"github.com/heketi/tests"
+)
+
+func TestSshExecVolumeInfo(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Mock ssh function
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		tests.Assert(t, len(commands) == 1)
+		tests.Assert(t,
+			strings.HasPrefix(commands[0], "sudo gluster --mode=script volume info "),
+			commands[0])
+		tests.Assert(t, strings.HasSuffix(commands[0], " --xml"), commands[0])
+
+		return []string{`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput>
+  <opRet>0</opRet>
+  <volInfo>
+    <volumes>
+      <volume>
+        <name>myvol</name>
+        <brickCount>2</brickCount>
+        <bricks>
+          <brick uuid="a">host1:/brick/one<name>host1:/brick/one</name><hostUuid>a</hostUuid></brick>
+          <brick uuid="b">host2:/brick/two<name>host2:/brick/two</name><hostUuid>b</hostUuid></brick>
+        </bricks>
+      </volume>
+      <count>1</count>
+    </volumes>
+  </volInfo>
+</cliOutput>`}, nil
+	}
+
+	info, err := s.VolumeInfo("myhost", "myvol")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(info.Bricks) == 2)
+	tests.Assert(t, info.Bricks[0].Host == "host1")
+	tests.Assert(t, info.Bricks[0].Path == "/brick/one")
+	tests.Assert(t, info.Bricks[1].Host == "host2")
+	tests.Assert(t, info.Bricks[1].Path == "/brick/two")
+
+	// Volume not reported
+	info, err = s.VolumeInfo("myhost", "othervol")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, info == nil)
+}
+
+func TestSshExecVolumeCreateDisperse(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Two sets of 4+2
+	volume := &executors.VolumeRequest{
+		Name:       "myvol",
+		Type:       executors.DurabilityDispersion,
+		Data:       4,
+		Redundancy: 2,
+	}
+	for i := 0; i < 12; i++ {
+		volume.Bricks = append(volume.Bricks, executors.BrickInfo{
+			Host: fmt.Sprintf("host%v", i%6),
+			Path: fmt.Sprintf("/brick/%v", i),
+		})
+	}
+
+	var executed []string
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		executed = commands
+		return nil, nil
+	}
+
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(executed) == 3, executed)
+	tests.Assert(t, strings.HasPrefix(executed[0],
+		"sudo gluster --mode=script volume create myvol disperse-data 4 redundancy 2 "+
+			"host0:/brick/0 "), executed[0])
+	tests.Assert(t, strings.Count(executed[0], ":/brick/") == 6, executed[0])
+	tests.Assert(t, strings.HasPrefix(executed[1],
+		"sudo gluster --mode=script volume add-brick myvol host0:/brick/6 "), executed[1])
+	tests.Assert(t, strings.Count(executed[1], ":/brick/") == 6, executed[1])
+	tests.Assert(t, executed[2] == "sudo gluster volume start myvol", executed[2])
+
+	// A single set has no add-brick command
+	volume.Bricks = volume.Bricks[:6]
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(executed) == 2, executed)
+	tests.Assert(t, strings.Contains(executed[0], "disperse-data 4 redundancy 2 "))
+}
+
+func TestSshExecVolumeCreateArbiter(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Two sets of 2+1
+	volume := &executors.VolumeRequest{
+		Name:    "myvol",
+		Type:    executors.DurabilityReplica,
+		Replica: 3,
+		Arbiter: 1,
+	}
+	for i := 0; i < 6; i++ {
+		volume.Bricks = append(volume.Bricks, executors.BrickInfo{
+			Host: fmt.Sprintf("host%v", i%3),
+			Path: fmt.Sprintf("/brick/%v", i),
+		})
+	}
+
+	var executed []string
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		executed = commands
+		return nil, nil
+	}
+
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(executed) == 3, executed)
+	tests.Assert(t, executed[0] ==
+		"sudo gluster --mode=script volume create myvol replica 3 arbiter 1 "+
+			"host0:/brick/0 host1:/brick/1 host2:/brick/2 ", executed[0])
+	tests.Assert(t, executed[1] ==
+		"sudo gluster --mode=script volume add-brick myvol "+
+			"host0:/brick/3 host1:/brick/4 host2:/brick/5 ", executed[1])
+
+	// The replica count of newer versions counts the data bricks
+	volume.GlusterVersion = "3.12.2"
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, strings.HasPrefix(executed[0],
+		"sudo gluster --mode=script volume create myvol replica 2 arbiter 1 "), executed[0])
+
+	volume.GlusterVersion = "3.10.1"
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, strings.HasPrefix(executed[0],
+		"sudo gluster --mode=script volume create myvol replica 3 arbiter 1 "), executed[0])
+
+	// Not created by versions without arbiters
+	volume.GlusterVersion = "3.6.9"
+	executed = nil
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "3.7"), err)
+	tests.Assert(t, executed == nil, executed)
+
+	// Without an arbiter
+	volume.Arbiter = 0
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, !strings.Contains(executed[0], "arbiter"), executed[0])
+}
+
+func TestSshExecVolumeReplaceBrick(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Mock ssh function
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		tests.Assert(t, len(commands) == 1)
+		tests.Assert(t, commands[0] == "sudo gluster --mode=script volume replace-brick "+
+			"myvol host1:/brick/one host1:/brick/two commit force", commands[0])
+
+		return nil, nil
+	}
+
+	err = s.VolumeReplaceBrick("myhost", "myvol",
+		&executors.BrickInfo{Host: "host1", Path: "/brick/one"},
+		&executors.BrickInfo{Host: "host1", Path: "/brick/two"})
+	tests.Assert(t, err == nil, err)
+}
+
+func TestSshExecVolumeProfile(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Mock ssh function
+	var executed []string
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		tests.Assert(t, len(commands) == 1)
+		executed = append(executed, commands[0])
+		if !strings.HasSuffix(commands[0], " info --xml") {
+			return nil, nil
+		}
+
+		return []string{`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput>
+  <opRet>0</opRet>
+  <volProfile>
+    <volname>myvol</volname>
+    <profileOp>3</profileOp>
+    <brickCount>1</brickCount>
+    <brick>
+      <brickName>host1:/brick/one</brickName>
+      <cumulativeStats>
+        <fopStats>
+          <fop>
+            <name>WRITE</name>
+            <hits>10</hits>
+            <avgLatency>12.5</avgLatency>
+            <minLatency>5.000000</minLatency>
+            <maxLatency>30.000000</maxLatency>
+          </fop>
+          <fop>
+            <name>LOOKUP</name>
+            <hits>3</hits>
+            <avgLatency>1.0</avgLatency>
+            <minLatency>1.0</minLatency>
+            <maxLatency>1.0</maxLatency>
+          </fop>
+        </fopStats>
+        <duration>120</duration>
+        <totalRead>0</totalRead>
+        <totalWrite>40960</totalWrite>
+      </cumulativeStats>
+      <intervalStats>
+        <duration>10</duration>
+        <totalRead>0</totalRead>
+        <totalWrite>0</totalWrite>
+      </intervalStats>
+    </brick>
+  </volProfile>
+</cliOutput>`}, nil
+	}
+
+	err = s.VolumeProfileStart("myhost", "myvol")
+	tests.Assert(t, err == nil, err)
+	info, err := s.VolumeProfileInfo("myhost", "myvol")
+	tests.Assert(t, err == nil, err)
+	err = s.VolumeProfileStop("myhost", "myvol")
+	tests.Assert(t, err == nil, err)
+
+	tests.Assert(t, len(executed) == 3, executed)
+	tests.Assert(t, executed[0] == "sudo gluster --mode=script volume profile myvol start")
+	tests.Assert(t, executed[1] == "sudo gluster --mode=script volume profile myvol info --xml")
+	tests.Assert(t, executed[2] == "sudo gluster --mode=script volume profile myvol stop")
+
+	// Only the cumulative stats are kept
+	tests.Assert(t, len(info.Bricks) == 1, info.Bricks)
+	brick := info.Bricks[0]
+	tests.Assert(t, brick.Name == "host1:/brick/one")
+	tests.Assert(t, brick.Duration == 120)
+	tests.Assert(t, brick.DataRead == 0)
+	tests.Assert(t, brick.DataWritten == 40960)
+	tests.Assert(t, len(brick.Fops) == 2)
+	tests.Assert(t, brick.Fops[0].Name == "WRITE")
+	tests.Assert(t, brick.Fops[0].Hits == 10)
+	tests.Assert(t, brick.Fops[0].AvgLatency == 12.5)
+	tests.Assert(t, brick.Fops[0].MaxLatency == 30)
+}
+
+func TestSshExecVolumeScrub(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Mock ssh function
+	var executed []string
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		executed = append(executed, commands...)
+		return nil, nil
+	}
+
+	err = s.VolumeScrub("myhost", "myvol")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(executed) == 1, executed)
+	tests.Assert(t, executed[0] == "sudo gluster --mode=script volume bitrot myvol scrub ondemand")
+
+	// Bit rot detection not enabled on the volume
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+		return nil, errors.New("Bitrot is not enabled on volume myvol")
+	}
+
+	err = s.VolumeScrub("myhost", "myvol")
+	tests.Assert(t, err != nil)
+	tests.Assert(t, strings.Contains(err.Error(), "myvol"), err)
+}
+
+func TestSshExecVolumeRebalance(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Mock ssh function
+	var executed []string
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		executed = append(executed, commands...)
+		return nil, nil
+	}
+
+	err = s.VolumeRebalance("myhost", "myvol")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(executed) == 1, executed)
+	tests.Assert(t, executed[0] == "sudo gluster --mode=script volume rebalance myvol start")
+}
+
+func TestSshExecVolumeRebalanceStatus(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Mock ssh function
+	var executed []string
+	status := "in progress"
+	failures := 0
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		executed = append(executed, commands...)
+		return []string{fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput>
+  <opRet>0</opRet>
+  <volRebalance>
+    <node>
+      <nodeName>host1</nodeName>
+      <files>4</files>
+      <statusStr>completed</statusStr>
+    </node>
+    <node>
+      <nodeName>host2</nodeName>
+      <files>6</files>
+      <statusStr>%v</statusStr>
+    </node>
+    <aggregate>
+      <files>10</files>
+      <failures>%v</failures>
+      <statusStr>%v</statusStr>
+    </aggregate>
+  </volRebalance>
+</cliOutput>`, status, failures, status)}, nil
+	}
+
+	progress, err := s.VolumeRebalanceStatus("myhost", "myvol")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, !progress.Completed)
+	tests.Assert(t, progress.Nodes == 2, progress)
+	tests.Assert(t, progress.NodesCompleted == 1, progress)
+	tests.Assert(t, progress.Files == 10, progress)
+	tests.Assert(t, executed[0] ==
+		"sudo gluster --mode=script volume rebalance myvol status --xml", executed)
+
+	status = "completed"
+	progress, err = s.VolumeRebalanceStatus("myhost", "myvol")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, progress.Completed)
+	tests.Assert(t, progress.NodesCompleted == 2, progress)
+
+	// Files which could not be moved
+	failures = 2
+	_, err = s.VolumeRebalanceStatus("myhost", "myvol")
+	tests.Assert(t, err != nil)
+
+	status = "stopped"
+	failures = 0
+	_, err = s.VolumeRebalanceStatus("myhost", "myvol")
+	tests.Assert(t, err != nil)
+}
+
+func TestSshExecVolumeHealInfo(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Mock ssh function
+	var executed []string
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		executed = append(executed, commands...)
+		return []string{`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput>
+  <healInfo>
+    <bricks>
+      <brick hostUuid="1">
+        <name>host1:/b1</name>
+        <status>Connected</status>
+        <numberOfEntries>0</numberOfEntries>
+      </brick>
+      <brick hostUuid="2">
+        <name>host2:/b2</name>
+        <status>Connected</status>
+        <numberOfEntries>12</numberOfEntries>
+      </brick>
+      <brick hostUuid="3">
+        <name>host3:/b3</name>
+        <status>Transport endpoint is not connected</status>
+        <numberOfEntries>-</numberOfEntries>
+      </brick>
+    </bricks>
+  </healInfo>
+  <opRet>0</opRet>
+</cliOutput>`}, nil
+	}
+
+	info, err := s.VolumeHealInfo("myhost", "myvol")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, info.Bricks == 3, info)
+	tests.Assert(t, info.BricksHealed == 1, info)
+	tests.Assert(t, info.Entries == 12, info)
+	tests.Assert(t, executed[0] ==
+		"sudo gluster --mode=script volume heal myvol info --xml", executed)
+}
+
+func TestSshExecVolumeIOStats(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	// Mock ssh function
+	profiled := true
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		tests.Assert(t, len(commands) == 1)
+		switch commands[0] {
+		case "sudo gluster --mode=script volume status myvol clients --xml":
+			return []string{`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput>
+  <opRet>0</opRet>
+  <volStatus>
+    <volumes>
+      <volume>
+        <volName>myvol</volName>
+        <nodeCount>2</nodeCount>
+        <node>
+          <hostname>host1</hostname>
+          <path>/brick/one</path>
+          <clientsStatus>
+            <clientCount>2</clientCount>
+          </clientsStatus>
+        </node>
+        <node>
+          <hostname>host2</hostname>
+          <path>/brick/two</path>
+          <clientsStatus>
+            <clientCount>1</clientCount>
+          </clientsStatus>
+        </node>
+      </volume>
+    </volumes>
+  </volStatus>
+</cliOutput>`}, nil
+		case "sudo gluster --mode=script volume profile myvol info incremental --xml":
+			if !profiled {
+				return nil, errors.New("Profile on Volume myvol is not started")
+			}
+			return []string{`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput>
+  <opRet>0</opRet>
+  <volProfile>
+    <volname>myvol</volname>
+    <brick>
+      <brickName>host1:/brick/one</brickName>
+      <intervalStats>
+        <fopStats>
+          <fop>
+            <name>READ</name>
+            <hits>50</hits>
+          </fop>
+          <fop>
+            <name>WRITE</name>
+            <hits>20</hits>
+          </fop>
+        </fopStats>
+        <duration>10</duration>
+      </intervalStats>
+    </brick>
+  </volProfile>
+</cliOutput>`}, nil
+		}
+		tests.Assert(t, false, commands[0])
+		return nil, nil
+	}
+
+	info, err := s.VolumeIOStats("myhost", "myvol")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(info.Bricks) == 2, info.Bricks)
+	tests.Assert(t, reflect.DeepEqual(info.Bricks[0], executors.BrickIOStats{
+		Host:           "host1",
+		Path:           "/brick/one",
+		ReadOpsPerSec:  5,
+		WriteOpsPerSec: 2,
+		ClientCount:    2,
+	}), info.Bricks[0])
+	tests.Assert(t, reflect.DeepEqual(info.Bricks[1], executors.BrickIOStats{
+		Host:        "host2",
+		Path:        "/brick/two",
+		ClientCount: 1,
+	}), info.Bricks[1])
+
+	// Without profiling only the clients are known
+	profiled = false
+	info, err = s.VolumeIOStats("myhost", "myvol")
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(info.Bricks) == 2)
+	tests.Assert(t, info.Bricks[0].ClientCount == 2)
+	tests.Assert(t, info.Bricks[0].ReadOpsPerSec == 0)
+}
+
+func TestSshExecVolumeRemoveBricks(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	bricks := []executors.BrickInfo{
+		{Host: "host1", Path: "/b1"},
+		{Host: "host2", Path: "/b2"},
+	}
+
+	// Mock ssh function
+	var executed []string
+	status := "in progress"
+	failures := 0
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		tests.Assert(t, host == "myhost:100", host)
+		executed = append(executed, commands...)
+		if strings.HasSuffix(commands[0], "--xml") {
+			return []string{fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput>
+  <opRet>0</opRet>
+  <volRemoveBrick>
+    <node>
+      <nodeName>host1</nodeName>
+      <files>4</files>
+      <statusStr>completed</statusStr>
+    </node>
+    <node>
+      <nodeName>host2</nodeName>
+      <files>6</files>
+      <statusStr>%v</statusStr>
+    </node>
+    <aggregate>
+      <files>10</files>
+      <failures>%v</failures>
+      <status>1</status>
+      <statusStr>%v</statusStr>
+    </aggregate>
+  </volRemoveBrick>
+</cliOutput>`, status, failures, status)}, nil
+		}
+		return nil, nil
+	}
+
+	err = s.VolumeRemoveBricksStart("myhost", "myvol", bricks)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, executed[0] ==
+		"sudo gluster --mode=script volume remove-brick myvol host1:/b1 host2:/b2 start",
+		executed)
+
+	progress, err := s.VolumeRemoveBricksStatus("myhost", "myvol", bricks)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, !progress.Completed)
+	tests.Assert(t, progress.Nodes == 2, progress)
+	tests.Assert(t, progress.NodesCompleted == 1, progress)
+	tests.Assert(t, progress.Files == 10, progress)
+	tests.Assert(t, executed[1] ==
+		"sudo gluster --mode=script volume remove-brick myvol host1:/b1 host2:/b2 status --xml",
+		executed)
+
+	status = "completed"
+	progress, err = s.VolumeRemoveBricksStatus("myhost", "myvol", bricks)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, progress.Completed)
+	tests.Assert(t, progress.NodesCompleted == 2, progress)
+
+	// Files which could not be migrated
+	failures = 2
+	_, err = s.VolumeRemoveBricksStatus("myhost", "myvol", bricks)
+	tests.Assert(t, err != nil)
+
+	status = "failed"
+	failures = 0
+	_, err = s.VolumeRemoveBricksStatus("myhost", "myvol", bricks)
+	tests.Assert(t, err != nil)
+
+	err = s.VolumeRemoveBricksCommit("myhost", "myvol", bricks)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, executed[len(executed)-1] ==
+		"sudo gluster --mode=script volume remove-brick myvol host1:/b1 host2:/b2 commit",
+		executed)
+
+	err = s.VolumeRemoveBricksStop("myhost", "myvol", bricks)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, executed[len(executed)-1] ==
+		"sudo gluster --mode=script volume remove-brick myvol host1:/b1 host2:/b2 stop",
+		executed)
+}
+
+func TestSshExecVolumeCreateProtocol(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	volume := &executors.VolumeRequest{
+		Name:     "myvol",
+		Type:     executors.DurabilityNone,
+		Protocol: executors.VolumeProtocolNFS,
+		Bricks: []executors.BrickInfo{
+			{Host: "host0", Path: "/brick/0"},
+		},
+	}
+
+	var executed []string
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		executed = commands
+		return nil, nil
+	}
+
+	// NFS is enabled before the volume is started
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, reflect.DeepEqual(executed[1:], []string{
+		"sudo gluster --mode=script volume set myvol nfs.disable off",
+		"sudo gluster --mode=script volume set myvol nfs.addr-namelookup off",
+		"sudo gluster volume start myvol",
+	}), executed)
+
+	volume.Protocol = executors.VolumeProtocolSMB
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, reflect.DeepEqual(executed[1:], []string{
+		"sudo gluster --mode=script volume set myvol user.smb enable",
+		"sudo gluster volume start myvol",
+	}), executed)
+
+	// Only the native client
+	volume.Protocol = ""
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(executed) == 2, executed)
+}
+
+func TestSshExecVolumeCreateWORM(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	volume := &executors.VolumeRequest{
+		Name:                "myvol",
+		Type:                executors.DurabilityNone,
+		WORM:                true,
+		WORMRetentionMode:   executors.WORMRetentionEnterprise,
+		WORMRetentionPeriod: 86400,
+		Bricks: []executors.BrickInfo{
+			{Host: "host0", Path: "/brick/0"},
+		},
+	}
+
+	var executed []string
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		executed = commands
+		return nil, nil
+	}
+
+	// WORM is enabled before the volume is started
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, reflect.DeepEqual(executed[1:], []string{
+		"sudo gluster --mode=script volume set myvol features.worm on",
+		"sudo gluster --mode=script volume set myvol features.worm-file-level on",
+		"sudo gluster --mode=script volume set myvol features.retention-mode enterprise",
+		"sudo gluster --mode=script volume set myvol features.default-retention-period 86400",
+		"sudo gluster volume start myvol",
+	}), executed)
+
+	// The default retention period of GlusterFS
+	volume.WORMRetentionMode = executors.WORMRetentionRelax
+	volume.WORMRetentionPeriod = 0
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, reflect.DeepEqual(executed[1:], []string{
+		"sudo gluster --mode=script volume set myvol features.worm on",
+		"sudo gluster --mode=script volume set myvol features.worm-file-level on",
+		"sudo gluster --mode=script volume set myvol features.retention-mode relax",
+		"sudo gluster volume start myvol",
+	}), executed)
+}
+
+func TestSshExecVolumeCreateCHAP(t *testing.T) {
+
+	f := NewFakeSsh()
+	defer tests.Patch(&sshNew,
+		func(logger *utils.Logger, user string, file string) (Ssher, error) {
+			return f, nil
+		}).Restore()
+
+	config := &SshConfig{
+		PrivateKeyFile: "xkeyfile",
+		User:           "xuser",
+		Port:           "100",
+	}
+
+	s, err := NewSshExecutor(config)
+	tests.Assert(t, err == nil)
+	tests.Assert(t, s != nil)
+
+	volume := &executors.VolumeRequest{
+		Name:         "myvol",
+		Type:         executors.DurabilityNone,
+		CHAPUsername: "myuser",
+		CHAPSecret:   "mysecret",
+		Bricks: []executors.BrickInfo{
+			{Host: "host0", Path: "/brick/0"},
+		},
+	}
+
+	var executed []string
+	f.FakeConnectAndExec = func(host string,
+		commands []string,
+		timeoutMinutes int,
+		useSudo bool) ([]string, error) {
+
+		executed = commands
+		return nil, nil
+	}
+
+	// The credentials are set before the volume is started
+	_, err = s.VolumeCreate("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, reflect.DeepEqual(executed[1:], []string{
+		"sudo gluster --mode=script volume set myvol auth.login./brick/0.allow myuser",
+		"sudo gluster --mode=script volume set myvol auth.login.myuser.password mysecret",
+		"sudo gluster --mode=script volume set myvol server.allow-insecure off",
+		"sudo gluster volume start myvol",
+	}), executed)
+
+	// The new bricks of an expansion also require them
+	volume.Bricks = []executors.BrickInfo{
+		{Host: "host1", Path: "/brick/1"},
+	}
+	_, err = s.VolumeExpand("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, reflect.DeepEqual(executed[1:], []string{
+		"sudo gluster --mode=script volume set myvol auth.login./brick/1.allow myuser",
+		"sudo gluster --mode=script volume set myvol auth.login.myuser.password mysecret",
+		"sudo gluster --mode=script volume set myvol server.allow-insecure off",
+	}), executed)
+
+	// Not without CHAP authentication
+	volume.CHAPUsername = ""
+	volume.CHAPSecret = ""
+	_, err = s.VolumeExpand("myhost", volume)
+	tests.Assert(t, err == nil, err)
+	tests.Assert(t, len(executed) == 1, executed)
+}
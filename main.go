@@ -17,6 +17,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -25,27 +26,40 @@ import (
 	"github.com/heketi/heketi/apps"
 	"github.com/heketi/heketi/apps/glusterfs"
 	"github.com/heketi/heketi/middleware"
+	"github.com/heketi/heketi/pkg/utils"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"syscall"
+	"time"
 )
 
 type Config struct {
 	Port        string                   `json:"port"`
 	AuthEnabled bool                     `json:"use_auth"`
 	JwtConfig   middleware.JwtAuthConfig `json:"jwt"`
+	TlsConfig   utils.TlsServerConfig    `json:"tls"`
 }
 
 var (
 	HEKETI_VERSION = "(dev)"
 	configfile     string
 	showVersion    bool
+	checkConfig    bool
+
+	// Time the requests being handled have to complete on shutdown
+	serverShutdownTimeout = 10 * time.Second
+
+	// Time between the checks of the TLS certificate files for changes
+	tlsReloadInterval = time.Minute
 )
 
 func init() {
 	flag.StringVar(&configfile, "config", "", "Configuration file")
 	flag.BoolVar(&showVersion, "version", false, "Show version")
+	flag.BoolVar(&checkConfig, "check-config", false,
+		"Validate the configuration file and exit")
 }
 
 func printVersion() {
@@ -83,6 +97,11 @@ func main() {
 		return
 	}
 
+	// Offline database commands
+	if flag.Arg(0) == "db" {
+		os.Exit(dbCommand(flag.Args()[1:]))
+	}
+
 	// Check configuration file was given
 	if configfile == "" {
 		fmt.Fprintln(os.Stderr, "Please provide configuration file")
@@ -115,6 +134,16 @@ func main() {
 	// to the application
 	fp.Seek(0, os.SEEK_SET)
 
+	// Quit here if all we needed to do was check the configuration
+	if checkConfig {
+		if err := glusterfs.CheckConfig(fp); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v: %v\n", configfile, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Configuration file %v is valid\n", configfile)
+		return
+	}
+
 	// Setup a new GlusterFS application
 	var app apps.Application
 	glusterfsApp := glusterfs.NewApp(fp)
@@ -123,6 +152,8 @@ func main() {
 		os.Exit(1)
 	}
 	app = glusterfsApp
+	glusterfsApp.SetConfigFile(configfile)
+	go reloadConfig(glusterfsApp, &options)
 
 	// Add /hello router
 	router := mux.NewRouter()
@@ -133,6 +164,12 @@ func main() {
 			fmt.Fprint(w, "Hello from Heketi")
 		})
 
+	// Probes do not authenticate
+	router.Methods("GET").Path("/healthz").Name("Healthz").HandlerFunc(
+		glusterfsApp.Healthz)
+	router.Methods("GET").Path("/readyz").Name("Readyz").HandlerFunc(
+		glusterfsApp.Readyz)
+
 	// Create a router and do not allow any routes
 	// unless defined.
 	heketiRouter := mux.NewRouter().StrictSlash(true)
@@ -175,13 +212,34 @@ func main() {
 	signalch := make(chan os.Signal, 1)
 	signal.Notify(signalch, os.Interrupt, os.Kill, syscall.SIGINT, syscall.SIGTERM)
 
+	server := &http.Server{
+		Addr:    ":" + options.Port,
+		Handler: router,
+	}
+
+	// Serve TLS when a certificate is configured
+	if options.TlsConfig.CertFile != "" {
+		reloader, err := utils.NewTlsReloader(&options.TlsConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Unable to setup TLS: %v\n", err)
+			os.Exit(1)
+		}
+		server.TLSConfig = reloader.TLSConfig()
+		go reloadTls(reloader)
+		fmt.Println("TLS loaded")
+	}
+
 	// Create a channel to know if the server was unable to start
 	done := make(chan bool)
 	go func() {
 		// Start the server.
 		fmt.Printf("Listening on port %v\n", options.Port)
-		err = http.ListenAndServe(":"+options.Port, router)
-		if err != nil {
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			fmt.Printf("ERROR: HTTP Server error: %v\n", err)
 		}
 		done <- true
@@ -194,8 +252,78 @@ func main() {
 	}
 	fmt.Printf("Shutting down...\n")
 
+	// Fail readiness and reject changes, but keep serving the
+	// clients polling while running operations complete
+	glusterfsApp.Drain()
+
+	// Stop the server once the requests being handled complete
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Printf("ERROR: HTTP Server shutdown: %v\n", err)
+	}
+
 	// Shutdown the application
-	// :TODO: Need to shutdown the server
 	app.Close()
 
 }
+
+// Reads the TLS certificate files again on SIGHUP, and when they
+// change, so that they are rotated without restarting the server
+func reloadTls(reloader *utils.TlsReloader) {
+	hupch := make(chan os.Signal, 1)
+	signal.Notify(hupch, syscall.SIGHUP)
+	ticker := time.NewTicker(tlsReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hupch:
+			if err := reloader.Reload(); err != nil {
+				fmt.Printf("ERROR: Unable to reload TLS certificate: %v\n", err)
+			} else {
+				fmt.Println("TLS certificate reloaded")
+			}
+		case <-ticker.C:
+			reloaded, err := reloader.ReloadIfChanged()
+			if err != nil {
+				fmt.Printf("ERROR: Unable to reload TLS certificate: %v\n", err)
+			} else if reloaded {
+				fmt.Println("TLS certificate reloaded")
+			}
+		}
+	}
+}
+
+// Reloads the configuration of the application on SIGHUP.  The
+// settings of the server cannot change without a restart, so the
+// configuration is not reloaded if they did.
+func reloadConfig(app *glusterfs.App, options *Config) {
+	hupch := make(chan os.Signal, 1)
+	signal.Notify(hupch, syscall.SIGHUP)
+
+	for range hupch {
+		fp, err := os.Open(configfile)
+		if err != nil {
+			fmt.Printf("ERROR: Unable to open config file %v: %v\n", configfile, err)
+			continue
+		}
+		var reloaded Config
+		err = json.NewDecoder(fp).Decode(&reloaded)
+		fp.Close()
+		if err != nil {
+			fmt.Printf("ERROR: Unable to parse %v: %v\n", configfile, err)
+			continue
+		}
+		setWithEnvVariables(&reloaded)
+		if !reflect.DeepEqual(&reloaded, options) {
+			fmt.Println("ERROR: Configuration not reloaded, changing the port, " +
+				"authentication or TLS settings requires a restart")
+			continue
+		}
+
+		if _, err := app.ReloadConfigFile(); err != nil {
+			fmt.Printf("ERROR: Unable to reload configuration: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package api
+
+import (
+	"strings"
+)
+
+// Codes of the errors returned by the v2 API, and by the routes
+// without prefix for the requests rejected before they are handled.
+// A code refines the codes it starts with, followed by an underscore,
+// so that CONFLICT_DEVICE_HAS_BRICKS is also a CONFLICT.
+const (
+	ErrorCodeInvalidRequest    = "INVALID_REQUEST"
+	ErrorCodeUnparsableRequest = "INVALID_REQUEST_UNPARSABLE"
+	ErrorCodeUnsupportedMedia  = "INVALID_REQUEST_MEDIA_TYPE"
+	ErrorCodeUnauthorized      = "UNAUTHORIZED"
+	ErrorCodeNotFound          = "NOT_FOUND"
+	ErrorCodeConflict          = "CONFLICT"
+	ErrorCodeDeviceHasBricks   = "CONFLICT_DEVICE_HAS_BRICKS"
+	ErrorCodeNodeHasDevices    = "CONFLICT_NODE_HAS_DEVICES"
+	ErrorCodeClusterNotEmpty   = "CONFLICT_CLUSTER_NOT_EMPTY"
+	ErrorCodeNameInUse         = "CONFLICT_NAME_IN_USE"
+	ErrorCodeVolumeStopped     = "CONFLICT_VOLUME_STOPPED"
+	ErrorCodeStaleEntry        = "CONFLICT_STALE_ENTRY"
+	ErrorCodeGone              = "GONE"
+	ErrorCodeNoSpace           = "NO_SPACE"
+	ErrorCodeMaxBricks         = "NO_SPACE_MAX_BRICKS"
+	ErrorCodeMinimumBrickSize  = "NO_SPACE_MINIMUM_BRICK_SIZE"
+	ErrorCodeCancelled         = "CANCELLED"
+	ErrorCodeUnavailable       = "UNAVAILABLE"
+	ErrorCodeInternal          = "INTERNAL"
+)
+
+// Error returned by the v2 API in the body of the responses with an
+// error status, with the fields of the request it is about such as
+// the id of the entry
+type Error struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Status  int               `json:"status"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// Errors to compare the errors returned by the client to with
+// errors.Is, which matches the code and the codes it refines
+var (
+	ErrInvalidRequest   = &Error{Code: ErrorCodeInvalidRequest}
+	ErrUnsupportedMedia = &Error{Code: ErrorCodeUnsupportedMedia}
+	ErrUnauthorized     = &Error{Code: ErrorCodeUnauthorized}
+	ErrNotFound         = &Error{Code: ErrorCodeNotFound}
+	ErrConflict         = &Error{Code: ErrorCodeConflict}
+	ErrDeviceHasBricks  = &Error{Code: ErrorCodeDeviceHasBricks}
+	ErrNodeHasDevices   = &Error{Code: ErrorCodeNodeHasDevices}
+	ErrClusterNotEmpty  = &Error{Code: ErrorCodeClusterNotEmpty}
+	ErrNameInUse        = &Error{Code: ErrorCodeNameInUse}
+	ErrVolumeStopped    = &Error{Code: ErrorCodeVolumeStopped}
+	ErrNoSpace          = &Error{Code: ErrorCodeNoSpace}
+	ErrCancelled        = &Error{Code: ErrorCodeCancelled}
+	ErrUnavailable      = &Error{Code: ErrorCodeUnavailable}
+	ErrInternal         = &Error{Code: ErrorCodeInternal}
+)
+
+// The message is the same as returned by the API before v2
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Returns true if the target is an *Error with the code of the error
+// or a code it refines
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || t.Code == "" {
+		return false
+	}
+	return e.Code == t.Code || strings.HasPrefix(e.Code, t.Code+"_")
+}
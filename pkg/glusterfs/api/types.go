@@ -43,6 +43,135 @@ const (
 	DurabilityEC             DurabilityType = "disperse"
 )
 
+// Free space bands of devices, from the most to the least free
+const (
+	DeviceWatermarkOk       = "ok"
+	DeviceWatermarkLow      = "low"
+	DeviceWatermarkHigh     = "high"
+	DeviceWatermarkCritical = "critical"
+)
+
+// Storage classes
+const (
+	StorageClassHdd  = "hdd"
+	StorageClassSsd  = "ssd"
+	StorageClassNvme = "nvme"
+)
+
+// Admin
+type ReadOnlyRequest struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+type ReadOnlyInfo struct {
+	ReadOnly bool `json:"read_only"`
+
+	// Set if the db was opened read-only at startup
+	DbReadOnly bool `json:"db_read_only"`
+}
+
+type ConfigReloadResponse struct {
+	// Keys of the settings changed, with the keys of nested
+	// settings joined with dots
+	Changed []string `json:"changed"`
+}
+
+// Levels are one of none, critical, error, warning, info or debug.
+// The levels not given are kept.  The levels are set back to the
+// levels before the change after the time given, unless it is zero.
+type LogLevelRequest struct {
+	GlusterFS      string `json:"glusterfs,omitempty"`
+	Executor       string `json:"executor,omitempty"`
+	RevertAfterSec int    `json:"revert_after_sec,omitempty"`
+}
+
+// The revert time is in seconds since the epoch, and zero when the
+// levels are not set back
+type LogLevelInfo struct {
+	GlusterFS string `json:"glusterfs"`
+	Executor  string `json:"executor"`
+	RevertAt  int64  `json:"revert_at,omitempty"`
+}
+
+// Times are in milliseconds
+type DbOperationStats struct {
+	Count    uint64 `json:"count"`
+	Failures uint64 `json:"failures"`
+	Retries  uint64 `json:"retries"`
+	Panics   uint64 `json:"panics"`
+	TotalMs  uint64 `json:"total_ms"`
+	MaxMs    uint64 `json:"max_ms"`
+}
+
+// Transactions of the handlers by route name
+type DbStatsResponse struct {
+	Operations map[string]DbOperationStats `json:"operations"`
+}
+
+// Error logged by the server.  The time is in seconds since the epoch.
+type DebugError struct {
+	Time    int64  `json:"time"`
+	Message string `json:"message"`
+}
+
+type DebugDbState struct {
+	ReadOnly bool `json:"read_only"`
+
+	// Read transactions open and started since the db was opened
+	OpenTransactions int `json:"open_transactions"`
+	Transactions     int `json:"transactions"`
+}
+
+// Snapshot of the state of the server for debugging.  Parts of the
+// state which could not be read in time, as they are locked, are
+// listed in unavailable with the reason.  The time is in seconds
+// since the epoch.
+type DebugStateResponse struct {
+	Time             int64                  `json:"time"`
+	Goroutines       int                    `json:"goroutines"`
+	Operations       []AsyncOperation       `json:"operations"`
+	QueuedOperations int                    `json:"queued_operations"`
+	ExecutorQueues   map[string]int         `json:"executor_queues"`
+	Db               DebugDbState           `json:"db"`
+	Config           map[string]interface{} `json:"config"`
+	RecentErrors     []DebugError           `json:"recent_errors"`
+	Unavailable      map[string]string      `json:"unavailable,omitempty"`
+
+	// Only set when requested with ?stacks=true
+	Stacks string `json:"stacks,omitempty"`
+}
+
+// Types of the entries kept as tombstones when deleted
+const (
+	TombstoneCluster = "cluster"
+	TombstoneNode    = "node"
+	TombstoneDevice  = "device"
+	TombstoneVolume  = "volume"
+)
+
+// An entry deleted while tombstones are enabled.  The time is in
+// seconds since the epoch.
+type Tombstone struct {
+	Type      string `json:"type"`
+	Id        string `json:"id"`
+	DeletedAt int64  `json:"deleted_at"`
+	DeletedBy string `json:"deleted_by"`
+}
+
+type TombstoneListResponse struct {
+	Tombstones []Tombstone `json:"tombstones"`
+}
+
+// Purge the tombstones older than the number of days, or all of
+// them if zero
+type TombstonePurgeRequest struct {
+	Days int `json:"days"`
+}
+
+type TombstonePurgeResponse struct {
+	Purged int `json:"purged"`
+}
+
 // Common
 type StateRequest struct {
 	State EntryState `json:"state"`
@@ -67,13 +196,36 @@ type BrickInfo struct {
 	DeviceId string `json:"device"`
 	NodeId   string `json:"node"`
 
+	// Bricks sharing a mount point use different sub-directories
+	MountPoint string `json:"mount_point,omitempty"`
+	SubDir     string `json:"subdir,omitempty"`
+
 	// Size in KB
 	Size uint64 `json:"size"`
+
+	// Set if the brick only stores the metadata of the files
+	Arbiter bool `json:"arbiter,omitempty"`
+
+	// Set when the I/O of the brick was fetched
+	IOStats *BrickIOStats `json:"iostats,omitempty"`
+}
+
+// I/O of a brick when last fetched from GlusterFS.  The rates are
+// only known while profiling is started on its volume.
+type BrickIOStats struct {
+	ReadOpsPerSec  float64 `json:"read_ops_per_sec"`
+	WriteOpsPerSec float64 `json:"write_ops_per_sec"`
+	ClientCount    int     `json:"client_count"`
+	Time           int64   `json:"time"`
 }
 
 // Device
+// Bricks larger than the max brick size in KB are not placed on the
+// device.  Not limited if zero.
 type Device struct {
-	Name string `json:"name"`
+	Name         string `json:"name"`
+	TrimEnabled  bool   `json:"trim_enabled,omitempty"`
+	MaxBrickSize uint64 `json:"max_brick_size,omitempty"`
 }
 
 type DeviceAddRequest struct {
@@ -89,26 +241,182 @@ type DeviceInfo struct {
 
 type DeviceInfoResponse struct {
 	DeviceInfo
-	State  EntryState  `json:"state"`
-	Bricks []BrickInfo `json:"bricks"`
+	State     EntryState  `json:"state"`
+	Bricks    []BrickInfo `json:"bricks"`
+	Watermark string      `json:"watermark,omitempty"`
+
+	// Size in KB before the filesystem overhead, of which the
+	// total storage is usable.  Not set for older devices.
+	RawSize uint64 `json:"raw_size,omitempty"`
+
+	// Only set when requested with ?history=true
+	AllocationHistory []DeviceAllocation `json:"allocation_history,omitempty"`
+
+	// Not set if the device was never scrubbed
+	LastScrub *ScrubResult `json:"last_scrub,omitempty"`
+}
+
+// Brick of a volume placed on a device.  Size in KB and time in
+// seconds since the epoch.
+type DeviceAllocation struct {
+	VolumeId string `json:"volume"`
+	BrickId  string `json:"brick,omitempty"`
+	Size     uint64 `json:"size"`
+	Time     int64  `json:"time"`
+}
+
+// Space recovered by a trim in KB
+type DeviceTrimResponse struct {
+	Id      string `json:"id"`
+	Trimmed uint64 `json:"trimmed"`
+}
+
+// Status of the scrub of a brick
+const (
+	ScrubStarted = "started"
+	ScrubFailed  = "failed"
+	ScrubSkipped = "skipped"
+)
+
+type BrickScrubStatus struct {
+	Id       string `json:"id"`
+	VolumeId string `json:"volume"`
+	Path     string `json:"path"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Scrub of the bricks on a device.  Time in seconds since the epoch.
+type ScrubResult struct {
+	Id     string             `json:"id"`
+	Time   int64              `json:"time"`
+	Bricks []BrickScrubStatus `json:"bricks"`
 }
 
 // Node
 type NodeAddRequest struct {
-	Zone      int           `json:"zone"`
-	Hostnames HostAddresses `json:"hostnames"`
-	ClusterId string        `json:"cluster"`
+	Zone         int               `json:"zone"`
+	Hostnames    HostAddresses     `json:"hostnames"`
+	ClusterId    string            `json:"cluster"`
+	StorageClass string            `json:"storage_class,omitempty"`
+	Owner        string            `json:"owner,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+
+	// Create the cluster if it does not exist.  A new cluster
+	// is created if no cluster id is given.
+	CreateCluster bool `json:"create_cluster,omitempty"`
+
+	// Bricks of a set are never placed on two nodes of the same
+	// group.  Nodes without a group are only apart from themselves.
+	AffinityGroup string `json:"affinity_group,omitempty"`
+}
+
+// Set the tenant owning the node.  An empty owner makes the
+// node available to all tenants.
+type NodeOwnerRequest struct {
+	Owner string `json:"owner"`
+}
+
+type NodeZoneRequest struct {
+	Zone int `json:"zone"`
+}
+
+// A volume with bricks on the node and on other nodes of its zone
+type NodeZoneVolume struct {
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Bricks      []string `json:"bricks"`
+	Nodes       []string `json:"nodes"`
+	Remediation []string `json:"remediation"`
+}
+
+type NodeZoneResponse struct {
+	Id           string           `json:"id"`
+	Zone         int              `json:"zone"`
+	PreviousZone int              `json:"previous_zone"`
+	Volumes      []NodeZoneVolume `json:"volumes"`
+}
+
+// Lists are paged with the limit and continue query parameters.  The
+// continue token of the response gets the next page, and is empty on
+// the last page.
+type NodeListResponse struct {
+	Nodes    []string `json:"nodes"`
+	Continue string   `json:"continue,omitempty"`
 }
 
 type NodeInfo struct {
 	NodeAddRequest
 	Id string `json:"id"`
+
+	// Average time of reads from the devices of the node, set by
+	// the health checker
+	StorageLatencyMs float64 `json:"storage_latency_ms,omitempty"`
+
+	// New bricks are placed on the node before the nodes which are
+	// not preferred, while it has the space for them
+	PreferredForNewBricks bool `json:"preferred_for_new_bricks,omitempty"`
+
+	// Versions of GlusterFS and of the kernel of the node when it
+	// was added
+	GlusterVersion string `json:"gluster_version,omitempty"`
+	KernelVersion  string `json:"kernel_version,omitempty"`
+
+	// Brick creations and deletions run at once on the node, the
+	// default of the server if 0
+	MaxConcurrentBrickOps int `json:"max_concurrent_brick_ops,omitempty"`
 }
 
+// Changes the settings of a node.  The settings not given are kept.
+type NodeUpdateRequest struct {
+	PreferredForNewBricks *bool `json:"preferred_for_new_bricks,omitempty"`
+	MaxConcurrentBrickOps *int  `json:"max_concurrent_brick_ops,omitempty"`
+}
+
+// Labels of nodes in the shape of Kubernetes labels, so that
+// provisioners can match the topology of claims
+const (
+	NodeLabelZone     = "topology.kubernetes.io/zone"
+	NodeLabelHostname = "kubernetes.io/hostname"
+	NodeLabelCluster  = "heketi.io/cluster"
+)
+
 type NodeInfoResponse struct {
 	NodeInfo
 	State       EntryState           `json:"state"`
 	DevicesInfo []DeviceInfoResponse `json:"devices"`
+
+	// Number of bricks on the devices of the node
+	BrickCount int `json:"brick_count"`
+
+	// Tags of the node with its zone, hostname and cluster
+	Labels map[string]string `json:"labels"`
+}
+
+// A volume with bricks on the node, and the ids of those bricks
+type NodeVolume struct {
+	Id         string         `json:"id"`
+	Name       string         `json:"name"`
+	Cluster    string         `json:"cluster"`
+	Size       int            `json:"size"`
+	Durability DurabilityType `json:"durability"`
+	Bricks     []string       `json:"bricks"`
+}
+
+type NodeVolumesResponse struct {
+	Volumes []NodeVolume `json:"volumes"`
+}
+
+// Memory in bytes and CPUs of a node
+type NodeMetricsResponse struct {
+	MemoryTotal uint64 `json:"memory_total_bytes"`
+	MemoryFree  uint64 `json:"memory_free_bytes"`
+	CpuCount    int    `json:"cpu_count"`
+
+	// Brick creations and deletions running on the node, and how
+	// many can run at once
+	BrickOpsInFlight      int `json:"brick_ops_in_flight"`
+	MaxConcurrentBrickOps int `json:"max_concurrent_brick_ops"`
 }
 
 // Cluster
@@ -122,14 +430,91 @@ type TopologyInfoResponse struct {
 	ClusterList []Cluster `json:"clusters"`
 }
 
+// What deleting a cluster does with its contents
+const (
+	// Fail if the cluster has volumes or nodes
+	ClusterDeletePolicyFail = "fail"
+
+	// Delete the volumes, then the nodes without devices
+	ClusterDeletePolicyForce = "force"
+
+	// Delete the volumes, the devices and the nodes
+	ClusterDeletePolicyCascade = "cascade"
+)
+
+// When the volumes of a cluster are rebalanced
+const (
+	// Only when requested
+	ClusterRebalancePolicyNever = "never"
+
+	// After a node or a device is added to the cluster
+	ClusterRebalancePolicyOnExpand = "on-expand"
+
+	// At the times of a cron expression
+	ClusterRebalancePolicyScheduled = "scheduled"
+)
+
 type ClusterInfoResponse struct {
-	Id      string           `json:"id"`
-	Nodes   sort.StringSlice `json:"nodes"`
-	Volumes sort.StringSlice `json:"volumes"`
+	Id                string           `json:"id"`
+	Nodes             sort.StringSlice `json:"nodes"`
+	Volumes           sort.StringSlice `json:"volumes"`
+	DeletePolicy      string           `json:"delete_policy,omitempty"`
+	RebalancePolicy   string           `json:"rebalance_policy,omitempty"`
+	RebalanceSchedule string           `json:"rebalance_schedule,omitempty"`
+
+	StoragePolicy *ClusterStoragePolicy `json:"storage_policy,omitempty"`
+}
+
+// Nodes, devices and storage of a zone of a cluster.  Sizes are in
+// KB.  The free storage is on the online devices of online nodes.
+type ZoneInfo struct {
+	ZoneId      int    `json:"zone"`
+	NodeCount   int    `json:"node_count"`
+	DeviceCount int    `json:"device_count"`
+	StorageSize uint64 `json:"storage_size"`
+	StorageFree uint64 `json:"storage_free"`
+}
+
+type ClusterZonesResponse struct {
+	Zones []ZoneInfo `json:"zones"`
+}
+
+// Impact of deleting a cluster with its contents.  Storage is in KB.
+// The PVCs are those of the volumes named by the Kubernetes
+// provisioner, as namespace/name.  The hash is a digest of the other
+// fields, which the delete must be confirmed with.
+type DeleteImpact struct {
+	Id           string   `json:"id"`
+	Volumes      int      `json:"volumes"`
+	Bricks       int      `json:"bricks"`
+	StorageFreed uint64   `json:"storage_freed"`
+	Nodes        int      `json:"nodes"`
+	Devices      int      `json:"devices"`
+	Pvcs         []string `json:"pvcs"`
+	Hash         string   `json:"hash"`
+}
+
+type ClusterPolicyRequest struct {
+	DeletePolicy string `json:"delete_policy"`
+}
+
+// The schedule is a cron expression, only set with the scheduled
+// policy
+type ClusterRebalancePolicyRequest struct {
+	Policy   string `json:"policy"`
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// Sizes in GB the bricks created in the cluster are kept within, by
+// creating more bricks if they would be larger.  Zero is no limit.
+type ClusterStoragePolicy struct {
+	MinBrickSizeGB uint64 `json:"min_brick_size_gb"`
+	MaxBrickSizeGB uint64 `json:"max_brick_size_gb"`
 }
 
 type ClusterListResponse struct {
 	Clusters []string `json:"clusters"`
+	Continue string   `json:"continue,omitempty"`
 }
 
 // Durabilities
@@ -149,6 +534,29 @@ type VolumeDurabilityInfo struct {
 	Disperse  DisperseDurability `json:"disperse,omitempty"`
 }
 
+// Placement of the first brick of each set of a volume on the
+// preferred node
+const (
+	VolumeDataLocalityNone   = "none"
+	VolumeDataLocalityPrefer = "prefer-local"
+	VolumeDataLocalityStrict = "strict-local"
+)
+
+// Protocols a volume is exported over.  Volumes are always exported
+// to the native client, NFS and SMB are added to it.
+const (
+	VolumeProtocolGlusterFS = "glusterfs"
+	VolumeProtocolNFS       = "nfs"
+	VolumeProtocolSMB       = "smb"
+)
+
+// Retention modes of WORM volumes.  Files can be deleted before their
+// retention period expires in relax mode, not in enterprise mode.
+const (
+	VolumeWORMRetentionRelax      = "relax"
+	VolumeWORMRetentionEnterprise = "enterprise"
+)
+
 type VolumeCreateRequest struct {
 	// Size in GB
 	Size       int                  `json:"size"`
@@ -159,6 +567,42 @@ type VolumeCreateRequest struct {
 		Enable bool    `json:"enable"`
 		Factor float32 `json:"factor"`
 	} `json:"snapshot"`
+	StorageClass string `json:"storage_class,omitempty"`
+	Owner        string `json:"owner,omitempty"`
+
+	// Same as durability.disperse.  Setting either implies the
+	// disperse durability type.
+	DisperseData       int `json:"disperse_data,omitempty"`
+	DisperseRedundancy int `json:"disperse_redundancy,omitempty"`
+
+	// CHAP authentication of the iSCSI targets of the volume.
+	// The username defaults to the name of the volume.
+	CHAPAuth     bool   `json:"chap_auth,omitempty"`
+	CHAPUsername string `json:"chap_username,omitempty"`
+
+	// Put the first brick of each set on the preferred node, such as
+	// the node of the pod using the volume.  With strict-local the
+	// volume is not created unless it fits.
+	DataLocality    string `json:"data_locality,omitempty"`
+	PreferredNodeId string `json:"preferred_node,omitempty"`
+
+	// With one arbiter, the last brick of each set of a replica 3
+	// volume only stores the metadata of the files
+	ArbiterCount int `json:"arbiter_count,omitempty"`
+
+	// Protocol the volume is exported over, glusterfs by default
+	Protocol string `json:"protocol,omitempty"`
+
+	// Files of a WORM volume become read-only once written.  The
+	// volume cannot be deleted before the retention in days has
+	// passed since it was created.  Relax retention mode by default.
+	WORM              bool   `json:"worm,omitempty"`
+	WORMRetentionDays int    `json:"worm_retention_days,omitempty"`
+	WORMRetentionMode string `json:"worm_retention_mode,omitempty"`
+
+	// Place the bricks in sub-directories of bricks of the same size
+	// of other volumes with shared bricks, which share their storage
+	SharedBricks bool `json:"shared_bricks,omitempty"`
 }
 
 type VolumeInfo struct {
@@ -170,6 +614,11 @@ type VolumeInfo struct {
 			Hosts      []string          `json:"hosts"`
 			MountPoint string            `json:"device"`
 			Options    map[string]string `json:"options"`
+
+			// Hosts of the bricks other than the one of the
+			// mount point, which clients fetch the volfile from
+			// when it is down
+			BackupVolfileServers []string `json:"backup_volfile_servers"`
 		} `json:"glusterfs"`
 	} `json:"mount"`
 }
@@ -179,14 +628,257 @@ type VolumeInfoResponse struct {
 	Bricks []BrickInfo `json:"bricks"`
 }
 
+// Brick a volume create would place, see DryRunResult
+type DryRunBrick struct {
+	NodeId   string `json:"node"`
+	DeviceId string `json:"device"`
+
+	// Size in KB
+	Size    uint64 `json:"size"`
+	Arbiter bool   `json:"arbiter,omitempty"`
+}
+
+// Placement of the bricks of a volume create request, which is not
+// created.  Bricks are listed by set.
+type DryRunResult struct {
+	Cluster string        `json:"cluster"`
+	Bricks  []DryRunBrick `json:"bricks"`
+}
+
 type VolumeListResponse struct {
-	Volumes []string `json:"volumes"`
+	Volumes  []string `json:"volumes"`
+	Continue string   `json:"continue,omitempty"`
 }
 
 type VolumeExpandRequest struct {
 	Size int `json:"expand_size"`
 }
 
+// Space in GB to remove from the volume
+type VolumeShrinkRequest struct {
+	Size int `json:"size_gb"`
+}
+
+type VolumeRenameRequest struct {
+	Name string `json:"name"`
+}
+
+type VolumeCHAPCredentials struct {
+	Username string `json:"username"`
+	Secret   string `json:"secret"`
+}
+
+// Operating systems of the clients mounting a volume
+const (
+	MountOsLinux   = "linux"
+	MountOsMac     = "mac"
+	MountOsWindows = "windows"
+)
+
+type VolumeMountResponse struct {
+	Os       string `json:"os"`
+	Server   string `json:"server"`
+	Command  string `json:"command"`
+	Protocol string `json:"protocol"`
+}
+
+// Statistics of the bricks since profiling of the volume was started
+type VolumeProfileInfoResponse struct {
+	Id     string             `json:"id"`
+	Bricks []BrickProfileInfo `json:"bricks"`
+}
+
+// Data in bytes and duration in seconds
+type BrickProfileInfo struct {
+	Brick       string           `json:"brick"`
+	Duration    uint64           `json:"duration"`
+	DataRead    uint64           `json:"data_read"`
+	DataWritten uint64           `json:"data_written"`
+	Fops        []FopProfileInfo `json:"fops"`
+}
+
+// Latencies in microseconds
+type FopProfileInfo struct {
+	Name       string  `json:"name"`
+	Hits       uint64  `json:"hits"`
+	AvgLatency float64 `json:"avg_latency"`
+	MinLatency float64 `json:"min_latency"`
+	MaxLatency float64 `json:"max_latency"`
+}
+
+// Result of the liveness and readiness checks of the server
+type HealthResponse struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []HealthCheck `json:"checks"`
+}
+
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Ok      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// States of an asynchronous operation
+const (
+	AsyncOperationPending   = "pending"
+	AsyncOperationSucceeded = "succeeded"
+	AsyncOperationFailed    = "failed"
+	AsyncOperationCancelled = "cancelled"
+)
+
+// An asynchronous operation started by a request.  Times are in
+// seconds since the epoch.  The location of the result or the error
+// is set once the operation has completed.
+type AsyncOperation struct {
+	Id        string   `json:"id"`
+	Type      string   `json:"type"`
+	Targets   []string `json:"targets"`
+	State     string   `json:"state"`
+	Started   int64    `json:"started"`
+	Completed int64    `json:"completed,omitempty"`
+	Location  string   `json:"location,omitempty"`
+	Error     string   `json:"error,omitempty"`
+
+	// Position of a pending operation waiting for the limits on
+	// concurrent operations, from 1, and the seconds it waited
+	QueuePosition int   `json:"queue_position,omitempty"`
+	QueueWait     int64 `json:"queue_wait_seconds,omitempty"`
+
+	// Why a queued operation is not started, if not for the limits
+	QueueStatus string `json:"queue_status,omitempty"`
+
+	// Step the operation is at, or failed at, if it reports steps
+	Step *AsyncOperationStep `json:"step,omitempty"`
+
+	// Approximate percent of the operation done, which reaches 100
+	// once it completes, and the seconds left if they can be estimated
+	Progress int   `json:"progress"`
+	Eta      int64 `json:"eta_seconds,omitempty"`
+}
+
+// Named step of a running operation.  Steps made of parts count the
+// parts done out of the total, and their percentage.
+type AsyncOperationStep struct {
+	Name    string `json:"name"`
+	Started int64  `json:"started"`
+	Done    int    `json:"done,omitempty"`
+	Total   int    `json:"total,omitempty"`
+	Percent int    `json:"percent,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// Status of the operations queued while the server is in maintenance
+const AsyncOperationQueuedMaintenance = "queued — server in maintenance"
+
+type AsyncOperationListResponse struct {
+	Operations []AsyncOperation `json:"operations"`
+}
+
+// The operation with the request which started it, and the attempts
+// to cancel it
+type AsyncOperationInfoResponse struct {
+	AsyncOperation
+	Method    string                `json:"method"`
+	Path      string                `json:"path"`
+	User      string                `json:"user"`
+	RequestId string                `json:"request_id"`
+	History   []AsyncOperationEvent `json:"history"`
+}
+
+type AsyncOperationEvent struct {
+	Time    int64  `json:"time"`
+	Message string `json:"message"`
+}
+
+// Number of asynchronous operations which run at once, in total and
+// by type of operation.  Zero is unlimited.
+type AsyncOperationLimits struct {
+	Max   int            `json:"max"`
+	Types map[string]int `json:"types"`
+}
+
+// Asynchronous operations are queued but not started while the server
+// is in maintenance, as during an upgrade of gluster.  Requests are
+// still served.
+type MaintenanceRequest struct {
+	Maintenance bool   `json:"maintenance"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// The time maintenance started is in seconds since the epoch
+type MaintenanceInfo struct {
+	Maintenance      bool   `json:"maintenance"`
+	Reason           string `json:"reason,omitempty"`
+	Since            int64  `json:"since,omitempty"`
+	QueuedOperations int    `json:"queued_operations"`
+}
+
+// Audit log
+const (
+	AuditEventRequest   = "request"
+	AuditEventResponse  = "response"
+	AuditEventCompleted = "completed"
+)
+
+// Record of a change requested through the API.  A request is
+// recorded before it is handled and once it is handled, and its
+// asynchronous operation once it completes.  The records of a request
+// share its request id.  The outcome is one of the states of
+// asynchronous operations.
+type AuditRecord struct {
+	Time        int64    `json:"time"`
+	Event       string   `json:"event"`
+	RequestId   string   `json:"request_id"`
+	Issuer      string   `json:"issuer"`
+	Subject     string   `json:"subject,omitempty"`
+	Route       string   `json:"route"`
+	Method      string   `json:"method"`
+	Path        string   `json:"path"`
+	Targets     []string `json:"targets,omitempty"`
+	Summary     string   `json:"summary,omitempty"`
+	Status      int      `json:"status,omitempty"`
+	OperationId string   `json:"operation_id,omitempty"`
+	Outcome     string   `json:"outcome,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+type AuditLogResponse struct {
+	Records []AuditRecord `json:"records"`
+}
+
+// Webhooks
+const (
+	WebhookEventVolumeCreated  = "volume.created"
+	WebhookEventVolumeDeleted  = "volume.deleted"
+	WebhookEventVolumeExpanded = "volume.expanded"
+	WebhookEventNodeCreated    = "node.created"
+	WebhookEventNodeDeleted    = "node.deleted"
+	WebhookEventNodeFailed     = "node.failed"
+	WebhookEventDeviceCreated  = "device.created"
+	WebhookEventDeviceDeleted  = "device.deleted"
+	WebhookEventDeviceFailed   = "device.failed"
+
+	// Header of the hex HMAC-SHA256 of the body of the events, with
+	// the secret of the webhooks, prefixed with sha256=
+	WebhookSignatureHeader = "X-Heketi-Signature"
+)
+
+// Event posted to the webhooks once an operation completes.  The ids
+// of the resources are those of the targets of the operation, such as
+// a device and its node.  The outcome is one of the states of
+// asynchronous operations.
+type WebhookEvent struct {
+	Id          string   `json:"id"`
+	Type        string   `json:"type"`
+	ResourceIds []string `json:"resource_ids"`
+	Actor       string   `json:"actor"`
+	Time        int64    `json:"timestamp"`
+	Outcome     string   `json:"outcome"`
+	Error       string   `json:"error,omitempty"`
+	RequestId   string   `json:"request_id,omitempty"`
+	OperationId string   `json:"operation_id,omitempty"`
+}
+
 // Constructors
 
 func NewVolumeInfoResponse() *VolumeInfoResponse {
@@ -234,6 +926,18 @@ func (v *VolumeInfoResponse) String() string {
 		s += "Snapshot: Disabled\n"
 	}
 
+	if v.Protocol != "" {
+		s += fmt.Sprintf("Protocol: %v\n", v.Protocol)
+	}
+
+	if v.WORM {
+		s += fmt.Sprintf("WORM: Enabled\n"+
+			"WORM Retention Days: %v\n"+
+			"WORM Retention Mode: %v\n",
+			v.WORMRetentionDays,
+			v.WORMRetentionMode)
+	}
+
 	s += "\nBricks:\n"
 	for _, b := range v.Bricks {
 		s += fmt.Sprintf("Id: %v\n"+
@@ -250,3 +954,28 @@ func (v *VolumeInfoResponse) String() string {
 
 	return s
 }
+
+func (v *VolumeProfileInfoResponse) String() string {
+	s := fmt.Sprintf("Volume Id: %v\n", v.Id)
+	for _, b := range v.Bricks {
+		s += fmt.Sprintf("\nBrick: %v\n"+
+			"Duration (s): %v\n"+
+			"Data Read (bytes): %v\n"+
+			"Data Written (bytes): %v\n",
+			b.Brick,
+			b.Duration,
+			b.DataRead,
+			b.DataWritten)
+		if len(b.Fops) == 0 {
+			continue
+		}
+		s += fmt.Sprintf("%-16v %12v %14v %14v %14v\n",
+			"Fop", "Calls", "Avg-Latency", "Min-Latency", "Max-Latency")
+		for _, fop := range b.Fops {
+			s += fmt.Sprintf("%-16v %12v %12.2fus %12.2fus %12.2fus\n",
+				fop.Name, fop.Hits, fop.AvgLatency, fop.MinLatency, fop.MaxLatency)
+		}
+	}
+
+	return s
+}
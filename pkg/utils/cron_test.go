@@ -0,0 +1,75 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/heketi/tests"
+)
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		_, err := ParseCronSchedule(expr)
+		tests.Assert(t, err != nil, expr)
+	}
+}
+
+func TestCronScheduleMatches(t *testing.T) {
+	// A Sunday
+	sunday := time.Date(2016, time.May, 1, 2, 30, 0, 0, time.Local)
+
+	for _, test := range []struct {
+		expr    string
+		matches bool
+	}{
+		{"* * * * *", true},
+		{"30 2 * * *", true},
+		{"30 3 * * *", false},
+		{"*/15 * * * *", true},
+		{"*/20 * * * *", false},
+		{"0-29 * * * *", false},
+		{"10,20,30 * * * *", true},
+		{"30 2 1 5 *", true},
+		{"30 2 1 6 *", false},
+		{"30 2 * * 0", true},
+		{"30 2 * * 7", true},
+		{"30 2 * * 1-5", false},
+
+		// Either day matches when both are restricted
+		{"30 2 15 * 0", true},
+		{"30 2 1 * 1", true},
+		{"30 2 15 * 1", false},
+	} {
+		s, err := ParseCronSchedule(test.expr)
+		tests.Assert(t, err == nil, test.expr, err)
+		tests.Assert(t, s.Matches(sunday) == test.matches, test.expr)
+	}
+}
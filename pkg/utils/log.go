@@ -17,12 +17,16 @@
 package utils
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"runtime"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/lpabon/godbc"
 )
@@ -39,20 +43,151 @@ const (
 	LEVEL_DEBUG
 )
 
+var logLevelNames = []string{
+	LEVEL_NOLOG:    "none",
+	LEVEL_CRITICAL: "critical",
+	LEVEL_ERROR:    "error",
+	LEVEL_WARNING:  "warning",
+	LEVEL_INFO:     "info",
+	LEVEL_DEBUG:    "debug",
+}
+
+// Returns the level of its name, as used in the configuration
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, n := range logLevelNames {
+		if n == name {
+			return LogLevel(level), nil
+		}
+	}
+	return LEVEL_NOLOG, fmt.Errorf("Unknown log level %v", name)
+}
+
+func (level LogLevel) String() string {
+	if level < 0 || int(level) >= len(logLevelNames) {
+		return fmt.Sprintf("LogLevel(%d)", int(level))
+	}
+	return logLevelNames[level]
+}
+
+// Formats of the messages logged
+type LogFormat int
+
+const (
+	LOG_FORMAT_TEXT LogFormat = iota
+	LOG_FORMAT_JSON
+)
+
+var logFormatNames = []string{
+	LOG_FORMAT_TEXT: "text",
+	LOG_FORMAT_JSON: "json",
+}
+
+// Returns the format of its name, as used in the configuration
+func ParseLogFormat(name string) (LogFormat, error) {
+	for format, n := range logFormatNames {
+		if n == name {
+			return LogFormat(format), nil
+		}
+	}
+	return LOG_FORMAT_TEXT, fmt.Errorf("Unknown log format %v", name)
+}
+
+func (format LogFormat) String() string {
+	if format < 0 || int(format) >= len(logFormatNames) {
+		return fmt.Sprintf("LogFormat(%d)", int(format))
+	}
+	return logFormatNames[format]
+}
+
 var (
 	stderr io.Writer = os.Stderr
 	stdout io.Writer = os.Stdout
 )
 
+// Key and value attached to the messages of a logger
+type logField struct {
+	key   string
+	value interface{}
+}
+
 type Logger struct {
 	critlog, errorlog, infolog *log.Logger
 	debuglog, warninglog       *log.Logger
 
-	level LogLevel
+	// Write one JSON object per line, to stderr and stdout
+	jsonerr, jsonout *log.Logger
+
+	name string
+
+	// LogLevel and LogFormat, read and set atomically since they
+	// can be changed while the logger is used
+	level  int32
+	format int32
+
+	// Prepended to the messages in text, from the context of a
+	// logger returned by WithContext
+	prefix string
+
+	// From the context of a logger returned by WithContext
+	requestId, operationId string
+
+	// From WithField, in the order they were attached
+	fields []logField
+
+	// Keeps the errors and critical messages, whatever the level,
+	// if set.  Holds a *RecentErrors, read and set atomically since it
+	// can be set while the logger is used.
+	recent atomic.Value
+}
+
+// Message in the JSON format
+type jsonLogEntry struct {
+	Time        string                 `json:"time"`
+	Level       string                 `json:"level"`
+	Logger      string                 `json:"logger"`
+	Message     string                 `json:"message"`
+	File        string                 `json:"file,omitempty"`
+	RequestId   string                 `json:"request_id,omitempty"`
+	OperationId string                 `json:"operation_id,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+}
+
+type contextKey int
+
+const (
+	requestIdKey contextKey = iota
+	operationIdKey
+)
+
+// Returns a context carrying the id of the request it is for
+func ContextWithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey, id)
 }
 
-func logWithLongFile(l *log.Logger, format string, v ...interface{}) {
-	_, file, line, _ := runtime.Caller(2)
+// Returns the id of the request the context is for, or an empty
+// string if it is for none
+func RequestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+// Returns a context carrying the id of the asynchronous operation it
+// is for
+func ContextWithOperationId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, operationIdKey, id)
+}
+
+// Returns the id of the operation the context is for, or an empty
+// string if it is for none
+func OperationIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(operationIdKey).(string)
+	return id
+}
+
+// Returns the file and line of the caller of the logger method,
+// skip calls above
+func callerFile(skip int) string {
+	_, file, line, _ := runtime.Caller(skip + 1)
 
 	// Shorten the path.
 	// From
@@ -64,8 +199,7 @@ func logWithLongFile(l *log.Logger, format string, v ...interface{}) {
 		i = 0
 	}
 
-	l.Print(fmt.Sprintf("%v:%v: ", file[i:], line) +
-		fmt.Sprintf(format, v...))
+	return fmt.Sprintf("%v:%v", file[i:], line)
 }
 
 // Create a new logger
@@ -73,12 +207,14 @@ func NewLogger(prefix string, level LogLevel) *Logger {
 	godbc.Require(level >= 0, level)
 	godbc.Require(level <= LEVEL_DEBUG, level)
 
-	l := &Logger{}
+	l := &Logger{
+		name: strings.Trim(prefix, "[]"),
+	}
 
 	if level == LEVEL_NOLOG {
-		l.level = LEVEL_DEBUG
+		l.level = int32(LEVEL_DEBUG)
 	} else {
-		l.level = level
+		l.level = int32(level)
 	}
 
 	l.critlog = log.New(stderr, prefix+" CRITICAL ", log.LstdFlags)
@@ -86,6 +222,8 @@ func NewLogger(prefix string, level LogLevel) *Logger {
 	l.warninglog = log.New(stdout, prefix+" WARNING ", log.LstdFlags)
 	l.infolog = log.New(stdout, prefix+" INFO ", log.LstdFlags)
 	l.debuglog = log.New(stdout, prefix+" DEBUG ", log.LstdFlags)
+	l.jsonerr = log.New(stderr, "", 0)
+	l.jsonout = log.New(stdout, "", 0)
 
 	godbc.Ensure(l.critlog != nil)
 	godbc.Ensure(l.errorlog != nil)
@@ -96,54 +234,204 @@ func NewLogger(prefix string, level LogLevel) *Logger {
 	return l
 }
 
+// Returns a logger which writes the messages with the ids of the
+// request and of the operation of the context, or the logger itself
+// if the context is for no request.  In text, only the id of the
+// request is written, before the messages.  The level of the logger
+// returned is the level of the logger when it is called.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	id := RequestIdFromContext(ctx)
+	if id == "" {
+		return l
+	}
+
+	c := l.clone()
+	c.prefix = l.prefix + "[" + id + "] "
+	c.requestId = id
+	c.operationId = OperationIdFromContext(ctx)
+	return c
+}
+
+// Returns a logger which attaches the field to the messages it
+// writes, as a field of the JSON object or after the message in text,
+// instead of formatting it in the message:
+//
+//	logger.WithField("node", id).LogError("Unable to unmarshal node: %v", err)
+//
+// Errors are attached as their message.  The level of the logger
+// returned is the level of the logger when it is called.
+func (l *Logger) WithField(key string, value interface{}) *Logger {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+
+	c := l.clone()
+	c.fields = make([]logField, len(l.fields), len(l.fields)+1)
+	copy(c.fields, l.fields)
+	c.fields = append(c.fields, logField{key: key, value: value})
+	return c
+}
+
+// Returns a copy of the logger with its current level and format
+func (l *Logger) clone() *Logger {
+	c := &Logger{
+		critlog:     l.critlog,
+		errorlog:    l.errorlog,
+		infolog:     l.infolog,
+		debuglog:    l.debuglog,
+		warninglog:  l.warninglog,
+		jsonerr:     l.jsonerr,
+		jsonout:     l.jsonout,
+		name:        l.name,
+		level:       int32(l.Level()),
+		format:      int32(l.Format()),
+		prefix:      l.prefix,
+		requestId:   l.requestId,
+		operationId: l.operationId,
+		fields:      l.fields,
+	}
+	if recent := l.recentErrors(); recent != nil {
+		c.recent.Store(recent)
+	}
+	return c
+}
+
+// Keeps the errors and critical messages logged from now on in
+// recent, including those of the loggers returned by WithContext
+// afterwards
+func (l *Logger) RecordErrors(recent *RecentErrors) {
+	l.recent.Store(recent)
+}
+
+func (l *Logger) recentErrors() *RecentErrors {
+	recent, _ := l.recent.Load().(*RecentErrors)
+	return recent
+}
+
 // Return current level
 func (l *Logger) Level() LogLevel {
-	return l.level
+	return LogLevel(atomic.LoadInt32(&l.level))
 }
 
 // Set level
 func (l *Logger) SetLevel(level LogLevel) {
-	l.level = level
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+// Return current format
+func (l *Logger) Format() LogFormat {
+	return LogFormat(atomic.LoadInt32(&l.format))
+}
+
+// Set the format of the messages written from now on, including those
+// of the loggers returned by WithContext and WithField afterwards
+func (l *Logger) SetFormat(format LogFormat) {
+	atomic.StoreInt32(&l.format, int32(format))
+}
+
+// Returns the fields as text, to write after the message
+func (l *Logger) fieldsText() string {
+	text := ""
+	for _, f := range l.fields {
+		text += fmt.Sprintf(" %v=%v", f.key, f.value)
+	}
+	return text
+}
+
+// Writes the message to text with the prefix and fields, or to
+// jsonlog if the format is JSON.  The file of the caller is written
+// if longFile is set.
+func (l *Logger) output(level LogLevel, text, jsonlog *log.Logger,
+	longFile bool, format string, v ...interface{}) {
+
+	msg := fmt.Sprintf(format, v...)
+	file := ""
+	if longFile {
+		// Called by the method of the logger
+		file = callerFile(2)
+	}
+
+	if l.Format() != LOG_FORMAT_JSON {
+		if file != "" {
+			file += ": "
+		}
+		text.Print(file + l.prefix + msg + l.fieldsText())
+		return
+	}
+
+	entry := jsonLogEntry{
+		Time:        time.Now().UTC().Format(time.RFC3339Nano),
+		Level:       level.String(),
+		Logger:      l.name,
+		Message:     msg,
+		File:        file,
+		RequestId:   l.requestId,
+		OperationId: l.operationId,
+	}
+	if len(l.fields) != 0 {
+		entry.Fields = make(map[string]interface{}, len(l.fields))
+		for _, f := range l.fields {
+			entry.Fields[f.key] = f.value
+		}
+	}
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		// Fields which cannot be marshaled are written as text
+		for key, value := range entry.Fields {
+			entry.Fields[key] = fmt.Sprintf("%v", value)
+		}
+		data, _ = json.Marshal(&entry)
+	}
+	jsonlog.Print(string(data))
+}
+
+func (l *Logger) record(format string, v ...interface{}) {
+	if recent := l.recentErrors(); recent != nil {
+		recent.Add(l.prefix + fmt.Sprintf(format, v...) + l.fieldsText())
+	}
 }
 
 // Log critical information
 func (l *Logger) Critical(format string, v ...interface{}) {
-	if l.level >= LEVEL_CRITICAL {
-		logWithLongFile(l.critlog, format, v...)
+	l.record(format, v...)
+	if l.Level() >= LEVEL_CRITICAL {
+		l.output(LEVEL_CRITICAL, l.critlog, l.jsonerr, true, format, v...)
 	}
 }
 
 // Log error string
 func (l *Logger) LogError(format string, v ...interface{}) {
-	if l.level >= LEVEL_ERROR {
-		logWithLongFile(l.errorlog, format, v...)
+	l.record(format, v...)
+	if l.Level() >= LEVEL_ERROR {
+		l.output(LEVEL_ERROR, l.errorlog, l.jsonerr, true, format, v...)
 	}
 }
 
 // Log error variable
 func (l *Logger) Err(err error) {
-	if l.level >= LEVEL_ERROR {
-		logWithLongFile(l.errorlog, "%v", err)
+	l.record("%v", err)
+	if l.Level() >= LEVEL_ERROR {
+		l.output(LEVEL_ERROR, l.errorlog, l.jsonerr, true, "%v", err)
 	}
 }
 
 // Log warning information
 func (l *Logger) Warning(format string, v ...interface{}) {
-	if l.level >= LEVEL_WARNING {
-		l.warninglog.Printf(format, v...)
+	if l.Level() >= LEVEL_WARNING {
+		l.output(LEVEL_WARNING, l.warninglog, l.jsonout, false, format, v...)
 	}
 }
 
 // Log string
 func (l *Logger) Info(format string, v ...interface{}) {
-	if l.level >= LEVEL_INFO {
-		l.infolog.Printf(format, v...)
+	if l.Level() >= LEVEL_INFO {
+		l.output(LEVEL_INFO, l.infolog, l.jsonout, false, format, v...)
 	}
 }
 
 // Log string as debug
 func (l *Logger) Debug(format string, v ...interface{}) {
-	if l.level >= LEVEL_DEBUG {
-		logWithLongFile(l.debuglog, format, v...)
+	if l.Level() >= LEVEL_DEBUG {
+		l.output(LEVEL_DEBUG, l.debuglog, l.jsonout, true, format, v...)
 	}
 }
@@ -18,6 +18,8 @@ package utils
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"github.com/heketi/tests"
 	"strings"
@@ -30,15 +32,56 @@ func TestLogLevel(t *testing.T) {
 	defer tests.Patch(&stdout, &testbuffer).Restore()
 
 	l := NewLogger("[testing]", LEVEL_INFO)
-	tests.Assert(t, LEVEL_INFO == l.level)
 	tests.Assert(t, LEVEL_INFO == l.Level())
 
 	l.SetLevel(LEVEL_CRITICAL)
-	tests.Assert(t, LEVEL_CRITICAL == l.level)
 	tests.Assert(t, LEVEL_CRITICAL == l.Level())
 
 }
 
+func TestLogLevelConcurrent(t *testing.T) {
+	var testbuffer bytes.Buffer
+
+	defer tests.Patch(&stdout, &testbuffer).Restore()
+
+	l := NewLogger("[testing]", LEVEL_INFO)
+
+	// The level and format change while messages are written
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			l.SetLevel(LEVEL_WARNING)
+			l.SetFormat(LOG_FORMAT_TEXT)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		l.WithField("i", i).Debug("Hello")
+		_ = l.Level()
+	}
+	<-done
+	tests.Assert(t, l.Level() == LEVEL_WARNING)
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for _, level := range []LogLevel{
+		LEVEL_NOLOG,
+		LEVEL_CRITICAL,
+		LEVEL_ERROR,
+		LEVEL_WARNING,
+		LEVEL_INFO,
+		LEVEL_DEBUG,
+	} {
+		parsed, err := ParseLogLevel(level.String())
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, parsed == level, parsed, level)
+	}
+	tests.Assert(t, LEVEL_DEBUG.String() == "debug")
+
+	_, err := ParseLogLevel("verbose")
+	tests.Assert(t, err != nil)
+}
+
 func TestLogInfo(t *testing.T) {
 	var testbuffer bytes.Buffer
 
@@ -144,3 +187,182 @@ func TestLogCritical(t *testing.T) {
 	tests.Assert(t, testbuffer.Len() == 0)
 
 }
+
+func TestLogWithContext(t *testing.T) {
+	var testbuffer bytes.Buffer
+
+	defer tests.Patch(&stdout, &testbuffer).Restore()
+
+	l := NewLogger("[testing]", LEVEL_DEBUG)
+
+	// No request
+	tests.Assert(t, l.WithContext(context.Background()) == l)
+
+	ctx := ContextWithRequestId(context.Background(), "abc%v")
+	tests.Assert(t, RequestIdFromContext(ctx) == "abc%v")
+	rl := l.WithContext(ctx)
+	tests.Assert(t, rl != l)
+
+	rl.Info("Hello %v", "World")
+	tests.Assert(t, strings.Contains(testbuffer.String(), "[testing] INFO "), testbuffer.String())
+	tests.Assert(t, strings.Contains(testbuffer.String(), "[abc%v] Hello World"), testbuffer.String())
+	testbuffer.Reset()
+
+	rl.Debug("Hello %v", "World")
+	tests.Assert(t, strings.Contains(testbuffer.String(), "log_test.go"), testbuffer.String())
+	tests.Assert(t, strings.Contains(testbuffer.String(), "[abc%v] Hello World"), testbuffer.String())
+	testbuffer.Reset()
+
+	// The logger it came from is unchanged
+	l.Info("Hello")
+	tests.Assert(t, !strings.Contains(testbuffer.String(), "abc"), testbuffer.String())
+	testbuffer.Reset()
+
+	rl.SetLevel(LEVEL_WARNING)
+	rl.Info("TEXT")
+	tests.Assert(t, testbuffer.Len() == 0)
+	tests.Assert(t, l.Level() == LEVEL_DEBUG)
+}
+
+func TestLogRecordErrors(t *testing.T) {
+	var testbuffer bytes.Buffer
+
+	defer tests.Patch(&stderr, &testbuffer).Restore()
+	defer tests.Patch(&stdout, &testbuffer).Restore()
+
+	l := NewLogger("[testing]", LEVEL_NOLOG)
+	recent := NewRecentErrors(3)
+	l.RecordErrors(recent)
+	tests.Assert(t, len(recent.List()) == 0)
+
+	// Kept whatever the level
+	l.SetLevel(LEVEL_NOLOG)
+	l.LogError("Hello %v", "World")
+	l.Warning("Not an error")
+	l.Err(errors.New("BAD"))
+	list := recent.List()
+	tests.Assert(t, len(list) == 2, list)
+	tests.Assert(t, list[0].Message == "Hello World", list)
+	tests.Assert(t, list[1].Message == "BAD", list)
+	tests.Assert(t, !list[0].Time.IsZero())
+
+	// With the request of the context
+	ctx := ContextWithRequestId(context.Background(), "abc")
+	l.WithContext(ctx).Critical("Lost")
+	list = recent.List()
+	tests.Assert(t, len(list) == 3, list)
+	tests.Assert(t, list[2].Message == "[abc] Lost", list)
+
+	// Only the last are kept, oldest first
+	l.LogError("Last")
+	list = recent.List()
+	tests.Assert(t, len(list) == 3, list)
+	tests.Assert(t, list[0].Message == "BAD", list)
+	tests.Assert(t, list[2].Message == "Last", list)
+}
+
+func TestParseLogFormat(t *testing.T) {
+	for _, format := range []LogFormat{
+		LOG_FORMAT_TEXT,
+		LOG_FORMAT_JSON,
+	} {
+		parsed, err := ParseLogFormat(format.String())
+		tests.Assert(t, err == nil, err)
+		tests.Assert(t, parsed == format, parsed, format)
+	}
+	tests.Assert(t, LOG_FORMAT_JSON.String() == "json")
+
+	_, err := ParseLogFormat("xml")
+	tests.Assert(t, err != nil)
+}
+
+func TestLogWithField(t *testing.T) {
+	var testbuffer bytes.Buffer
+
+	defer tests.Patch(&stderr, &testbuffer).Restore()
+
+	l := NewLogger("[testing]", LEVEL_DEBUG)
+	recent := NewRecentErrors(3)
+	l.RecordErrors(recent)
+
+	fl := l.WithField("node", "abc").WithField("err", errors.New("BAD"))
+	tests.Assert(t, fl != l)
+	fl.LogError("Unable to unmarshal node: %v", "EOF")
+	tests.Assert(t, strings.Contains(testbuffer.String(),
+		"Unable to unmarshal node: EOF node=abc err=BAD"), testbuffer.String())
+	tests.Assert(t, strings.Contains(testbuffer.String(), "log_test.go"), testbuffer.String())
+	list := recent.List()
+	tests.Assert(t, len(list) == 1, list)
+	tests.Assert(t, list[0].Message == "Unable to unmarshal node: EOF node=abc err=BAD", list)
+	testbuffer.Reset()
+
+	// The logger it came from is unchanged
+	l.LogError("Hello")
+	tests.Assert(t, !strings.Contains(testbuffer.String(), "node="), testbuffer.String())
+}
+
+func TestLogJson(t *testing.T) {
+	var testbuffer bytes.Buffer
+
+	defer tests.Patch(&stderr, &testbuffer).Restore()
+	defer tests.Patch(&stdout, &testbuffer).Restore()
+
+	l := NewLogger("[testing]", LEVEL_DEBUG)
+	tests.Assert(t, l.Format() == LOG_FORMAT_TEXT)
+	l.SetFormat(LOG_FORMAT_JSON)
+	tests.Assert(t, l.Format() == LOG_FORMAT_JSON)
+
+	entries := func() []map[string]interface{} {
+		var list []map[string]interface{}
+		lines := strings.Split(strings.TrimSpace(testbuffer.String()), "\n")
+		for _, line := range lines {
+			var entry map[string]interface{}
+			err := json.Unmarshal([]byte(line), &entry)
+			tests.Assert(t, err == nil, err, line)
+			list = append(list, entry)
+		}
+		testbuffer.Reset()
+		return list
+	}
+
+	l.Info("Hello %v", "World")
+	list := entries()
+	tests.Assert(t, len(list) == 1, list)
+	tests.Assert(t, list[0]["level"] == "info", list)
+	tests.Assert(t, list[0]["logger"] == "testing", list)
+	tests.Assert(t, list[0]["message"] == "Hello World", list)
+	tests.Assert(t, list[0]["time"] != "", list)
+	_, ok := list[0]["request_id"]
+	tests.Assert(t, !ok, list)
+
+	// Ids of the context and fields apart from the message
+	ctx := ContextWithRequestId(context.Background(), "abc")
+	ctx = ContextWithOperationId(ctx, "op1")
+	tests.Assert(t, OperationIdFromContext(ctx) == "op1")
+	l.WithContext(ctx).WithField("node", "n1").WithField("count", 2).
+		LogError("Unable to unmarshal node: %v", errors.New("EOF"))
+	list = entries()
+	tests.Assert(t, len(list) == 1, list)
+	tests.Assert(t, list[0]["level"] == "error", list)
+	tests.Assert(t, list[0]["message"] == "Unable to unmarshal node: EOF", list)
+	tests.Assert(t, list[0]["request_id"] == "abc", list)
+	tests.Assert(t, list[0]["operation_id"] == "op1", list)
+	tests.Assert(t, strings.Contains(list[0]["file"].(string), "log_test.go"), list)
+	fields := list[0]["fields"].(map[string]interface{})
+	tests.Assert(t, fields["node"] == "n1", fields)
+	tests.Assert(t, fields["count"] == float64(2), fields)
+
+	// Fields which cannot be marshaled are written as text
+	l.WithField("fn", func() {}).Warning("Hello")
+	list = entries()
+	tests.Assert(t, len(list) == 1, list)
+	fields = list[0]["fields"].(map[string]interface{})
+	tests.Assert(t, strings.HasPrefix(fields["fn"].(string), "0x"), fields)
+
+	// One object per line
+	l.Debug("One")
+	l.Warning("Two\nlines")
+	list = entries()
+	tests.Assert(t, len(list) == 2, list)
+	tests.Assert(t, list[1]["message"] == "Two\nlines", list)
+}
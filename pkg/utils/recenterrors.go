@@ -0,0 +1,71 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lpabon/godbc"
+)
+
+type RecentError struct {
+	Time    time.Time
+	Message string
+}
+
+// Keeps the last errors logged, up to its size, for debugging
+type RecentErrors struct {
+	lock   sync.Mutex
+	errors []RecentError
+	next   int
+	full   bool
+}
+
+func NewRecentErrors(size int) *RecentErrors {
+	godbc.Require(size > 0)
+
+	return &RecentErrors{
+		errors: make([]RecentError, size),
+	}
+}
+
+func (r *RecentErrors) Add(message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.errors[r.next] = RecentError{
+		Time:    time.Now(),
+		Message: message,
+	}
+	r.next = (r.next + 1) % len(r.errors)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Returns the errors kept, oldest first
+func (r *RecentErrors) List() []RecentError {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.full {
+		return append([]RecentError{}, r.errors[:r.next]...)
+	}
+	return append(append([]RecentError{}, r.errors[r.next:]...),
+		r.errors[:r.next]...)
+}
@@ -0,0 +1,29 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package utils
+
+import (
+	"regexp"
+)
+
+// Volume options set to a secret
+var secretOptionRegexp = regexp.MustCompile(`(auth\.login\.\S+\.password\s+)[^\s;]+`)
+
+// Returns the command with the secrets it sets hidden, to be logged
+func RedactCommand(command string) string {
+	return secretOptionRegexp.ReplaceAllString(command, "${1}<redacted>")
+}
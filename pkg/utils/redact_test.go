@@ -0,0 +1,40 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/heketi/tests"
+)
+
+func TestRedactCommand(t *testing.T) {
+	cmd := "sudo gluster --mode=script volume set vol auth.login.user.password s3cr3t"
+	tests.Assert(t, RedactCommand(cmd) ==
+		"sudo gluster --mode=script volume set vol auth.login.user.password <redacted>",
+		RedactCommand(cmd))
+
+	// Several commands joined
+	cmd = "gluster volume set a auth.login.u.password x; gluster volume set b auth.login.v.password y"
+	tests.Assert(t, RedactCommand(cmd) ==
+		"gluster volume set a auth.login.u.password <redacted>; gluster volume set b auth.login.v.password <redacted>",
+		RedactCommand(cmd))
+
+	// Other commands are left as they are
+	cmd = "sudo gluster --mode=script volume set vol auth.login./brick/0.allow user"
+	tests.Assert(t, RedactCommand(cmd) == cmd)
+}
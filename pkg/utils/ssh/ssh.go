@@ -157,19 +157,19 @@ func (s *SshExec) ConnectAndExec(host string, commands []string, timeoutMinutes
 		case err := <-errch:
 			if err != nil {
 				s.logger.LogError("Failed to run command [%v] on %v: Err[%v]: Stdout [%v]: Stderr [%v]",
-					command, host, err, b.String(), berr.String())
+					utils.RedactCommand(command), host, err, b.String(), berr.String())
 				return nil, fmt.Errorf("%s", berr.String())
 			}
-			s.logger.Debug("Host: %v Command: %v\nResult: %v", host, command, b.String())
+			s.logger.Debug("Host: %v Command: %v\nResult: %v", host, utils.RedactCommand(command), b.String())
 			buffers[index] = b.String()
 
 		case <-timeout:
 			s.logger.LogError("Timeout on command [%v] on %v: Err[%v]: Stdout [%v]: Stderr [%v]",
-				command, host, err, b.String(), berr.String())
+				utils.RedactCommand(command), host, err, b.String(), berr.String())
 			err := session.Signal(ssh.SIGKILL)
 			if err != nil {
 				s.logger.LogError("Unable to send kill signal to command [%v] on host [%v]: %v",
-					command, host, err)
+					utils.RedactCommand(command), host, err)
 			}
 			return nil, errors.New("SSH command timeout")
 		}
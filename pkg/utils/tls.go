@@ -0,0 +1,216 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLS settings of a server.  TLS is enabled when the certificate is
+// set.  Clients must present a certificate signed by the client CA
+// when it is set.  The minimum version is one of 1.0, 1.1, 1.2 or
+// 1.3, and the cipher suites are named as in crypto/tls, such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+type TlsServerConfig struct {
+	CertFile     string   `json:"cert_file"`
+	KeyFile      string   `json:"key_file"`
+	ClientCAFile string   `json:"client_ca_file"`
+	MinVersion   string   `json:"min_version"`
+	CipherSuites []string `json:"cipher_suites"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Returns the TLS version of its name, such as 1.2
+func TlsVersion(name string) (uint16, error) {
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("Unknown TLS version %v", name)
+	}
+	return version, nil
+}
+
+// Returns the ids of the cipher suites of their names.  Only the
+// suites crypto/tls considers secure are known.
+func TlsCipherSuites(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown or insecure cipher suite %v", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Returns the certificates of the PEM file as a pool
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificate found in %v", file)
+	}
+	return pool, nil
+}
+
+// Serves the TLS certificate and client CA of a server from their
+// files, and reads them again when asked, so that they are rotated
+// without restarting the server.  The connections already established
+// keep the certificate they were made with.
+type TlsReloader struct {
+	config TlsServerConfig
+	base   *tls.Config
+
+	lock      sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+// Reads the certificate, its key and the client CA of the settings
+func NewTlsReloader(config *TlsServerConfig) (*TlsReloader, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, fmt.Errorf("TLS requires both a certificate and a key")
+	}
+
+	base := &tls.Config{}
+	if config.MinVersion != "" {
+		version, err := TlsVersion(config.MinVersion)
+		if err != nil {
+			return nil, err
+		}
+		base.MinVersion = version
+	}
+	if len(config.CipherSuites) != 0 {
+		suites, err := TlsCipherSuites(config.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		base.CipherSuites = suites
+	}
+
+	r := &TlsReloader{
+		config: *config,
+		base:   base,
+	}
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *TlsReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+// Reads the files again.  The files in use are kept if they cannot
+// be read.
+func (r *TlsReloader) Reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to load certificate %v: %v", r.config.CertFile, err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		clientCAs, err = LoadCertPool(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("Unable to load client CA %v: %v", r.config.ClientCAFile, err)
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return nil
+}
+
+// Reads the files again if one of them was modified since they were
+// last read.  Returns true if they were read.
+func (r *TlsReloader) ReloadIfChanged() (bool, error) {
+	r.lock.RLock()
+	changed := false
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(r.modTimes[file]) {
+			changed = true
+			break
+		}
+	}
+	r.lock.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+	return true, r.Reload()
+}
+
+// Returns the configuration of the server, which uses the files last
+// read for each new connection
+func (r *TlsReloader) TLSConfig() *tls.Config {
+	config := r.base.Clone()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.lock.RLock()
+		defer r.lock.RUnlock()
+
+		c := r.base.Clone()
+		c.Certificates = []tls.Certificate{*r.cert}
+		if r.clientCAs != nil {
+			c.ClientCAs = r.clientCAs
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return c, nil
+	}
+	return config
+}
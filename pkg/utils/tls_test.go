@@ -0,0 +1,153 @@
+//
+// Copyright (c) 2016 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/heketi/tests"
+)
+
+// Writes a self-signed certificate of the name and its key in the
+// directory, and returns their paths
+func writeTestCert(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tests.Assert(t, err == nil)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	tests.Assert(t, err == nil)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	tests.Assert(t, err == nil)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	err = ioutil.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	tests.Assert(t, err == nil)
+	err = ioutil.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	tests.Assert(t, err == nil)
+
+	return certFile, keyFile
+}
+
+func TestTlsVersion(t *testing.T) {
+	version, err := TlsVersion("1.2")
+	tests.Assert(t, err == nil)
+	tests.Assert(t, version == tls.VersionTLS12)
+
+	_, err = TlsVersion("3.0")
+	tests.Assert(t, err != nil)
+}
+
+func TestTlsCipherSuites(t *testing.T) {
+	ids, err := TlsCipherSuites([]string{
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(ids) == 2)
+	tests.Assert(t, ids[0] == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)
+	tests.Assert(t, ids[1] == tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384)
+
+	_, err = TlsCipherSuites([]string{"TLS_NOT_A_SUITE"})
+	tests.Assert(t, err != nil)
+}
+
+func TestTlsReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heketi-tls")
+	tests.Assert(t, err == nil)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCert(t, dir, "server")
+	caFile, _ := writeTestCert(t, dir, "client")
+
+	// Missing key
+	_, err = NewTlsReloader(&TlsServerConfig{CertFile: certFile})
+	tests.Assert(t, err != nil)
+
+	// Unknown version
+	_, err = NewTlsReloader(&TlsServerConfig{
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		MinVersion: "0.9",
+	})
+	tests.Assert(t, err != nil)
+
+	r, err := NewTlsReloader(&TlsServerConfig{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+		MinVersion:   "1.2",
+	})
+	tests.Assert(t, err == nil)
+
+	config, err := r.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, config.MinVersion == tls.VersionTLS12)
+	tests.Assert(t, config.ClientAuth == tls.RequireAndVerifyClientCert)
+	tests.Assert(t, len(config.Certificates) == 1)
+	first := config.Certificates[0].Certificate[0]
+
+	// Nothing changed
+	reloaded, err := r.ReloadIfChanged()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, !reloaded)
+
+	// Rotate the certificate
+	writeTestCert(t, dir, "server")
+	later := time.Now().Add(time.Minute)
+	tests.Assert(t, os.Chtimes(certFile, later, later) == nil)
+	reloaded, err = r.ReloadIfChanged()
+	tests.Assert(t, err == nil)
+	tests.Assert(t, reloaded)
+
+	config, err = r.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, string(config.Certificates[0].Certificate[0]) != string(first))
+
+	// A broken certificate is not used
+	tests.Assert(t, ioutil.WriteFile(certFile, []byte("garbage"), 0600) == nil)
+	err = r.Reload()
+	tests.Assert(t, err != nil)
+	config, err = r.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	tests.Assert(t, err == nil)
+	tests.Assert(t, len(config.Certificates) == 1)
+}
@@ -0,0 +1,62 @@
+//
+// Copyright (c) 2015 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Compares versions like 3.7.11 or 3.12.2rc1 by their numbers,
+// returning -1, 0 or 1 if a is older, the same or newer than b.
+// Only the leading digits of each part are compared, and missing
+// parts are zero.
+func CompareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na = pa[i]
+		}
+		if i < len(pb) {
+			nb = pb[i]
+		}
+		if na < nb {
+			return -1
+		} else if na > nb {
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(version string) []int {
+	var parts []int
+	for _, s := range strings.Split(version, ".") {
+		end := 0
+		for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+			end++
+		}
+		n, _ := strconv.Atoi(s[:end])
+		parts = append(parts, n)
+		if end < len(s) {
+			// The rest is a suffix such as rc1
+			break
+		}
+	}
+	return parts
+}
@@ -0,0 +1,42 @@
+//
+// Copyright (c) 2015 The heketi Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package utils
+
+import (
+	"github.com/heketi/tests"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"3.7.11", "3.7.11", 0},
+		{"3.7", "3.7.0", 0},
+		{"3.7.11", "3.12.0", -1},
+		{"3.12.2", "3.7.11", 1},
+		{"4.0", "3.12.15", 1},
+		{"3.12.2rc1", "3.12.2", 0},
+		{"3.12.2rc1", "3.12.3", -1},
+		{"10.1", "9.6", 1},
+		{"", "3.7", -1},
+	} {
+		tests.Assert(t, CompareVersions(c.a, c.b) == c.expected, c)
+		tests.Assert(t, CompareVersions(c.b, c.a) == -c.expected, c)
+	}
+}